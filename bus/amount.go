@@ -0,0 +1,32 @@
+package bus
+
+import (
+	"github.com/ledgerhq/satstack/utils"
+
+	"github.com/btcsuite/btcutil"
+)
+
+// btcValue is a bitcoin value in the JSON response of an RPC, in BTC, which
+// is decoded to satoshis from its decimal representation, without going
+// through a float64; see utils.ParseBTC.
+type btcValue btcutil.Amount
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (v *btcValue) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+
+	amount, err := utils.ParseBTC(string(data))
+	if err != nil {
+		return err
+	}
+
+	*v = btcValue(amount)
+	return nil
+}
+
+// Amount returns the value in satoshis.
+func (v btcValue) Amount() btcutil.Amount {
+	return btcutil.Amount(v)
+}
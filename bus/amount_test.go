@@ -0,0 +1,98 @@
+package bus
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ledgerhq/satstack/utils"
+
+	"github.com/btcsuite/btcutil"
+)
+
+func TestBTCValue(t *testing.T) {
+	tests := []struct {
+		data string
+		want btcutil.Amount
+	}{
+		{`0.00000001`, 1},
+		{`-0.00000141`, -141},
+		{`1.15`, 115000000},
+		{`20999999.9769`, 2099999997690000},
+		{`1e-8`, 1},
+		{`null`, 0},
+	}
+
+	for _, test := range tests {
+		var got btcValue
+		if err := json.Unmarshal([]byte(test.data), &got); err != nil || got.Amount() != test.want {
+			t.Errorf("%s: got %d, %v, want %d", test.data, got, err, test.want)
+		}
+	}
+
+	var got btcValue
+	if err := json.Unmarshal([]byte(`0.000000001`), &got); err == nil {
+		t.Errorf("got %d, want an error for a fraction of a satoshi", got)
+	}
+}
+
+func TestListTransactionsOutgoing(t *testing.T) {
+	// An outgoing wallet transaction, with a negative amount and fee. The
+	// amount is -114999999.99999999 satoshis as a float64.
+	entry := map[string]interface{}{
+		"address":       "bcrt1qw508d6qejxtdg4y5r3zarvary0c5xw7kygt080",
+		"category":      "send",
+		"amount":        json.Number("-1.15"),
+		"fee":           json.Number("-0.00000141"),
+		"confirmations": 1,
+		"blockhash":     mockBlockHash('a', 10),
+		"txid":          mockBlockHash('t', 1),
+	}
+
+	node := newSyncedNode()
+	node.result("listsinceblock", map[string]interface{}{
+		"transactions": []interface{}{entry},
+		"lastblock":    mockBlockHash('a', 10),
+	})
+
+	txs, err := newTestBus(node).ListTransactions(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(txs) != 1 || txs[0].Fee == nil {
+		t.Fatalf("got transactions %+v, want the outgoing one with its fee", txs)
+	}
+
+	amount, err := utils.ParseSatoshi(txs[0].Amount)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fee, err := utils.ParseSatoshi(*txs[0].Fee)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if amount != -115000000 || fee != -141 || amount+fee != -115000141 {
+		t.Errorf("got amount %d, fee %d, want -115000000 and -141", amount, fee)
+	}
+
+	// The values decoded from the raw JSON are the same.
+	data, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var raw struct {
+		Amount btcValue  `json:"amount"`
+		Fee    *btcValue `json:"fee"`
+	}
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatal(err)
+	}
+
+	if raw.Amount.Amount() != amount || raw.Fee == nil || raw.Fee.Amount() != fee {
+		t.Errorf("got amount %d, fee %v from the raw JSON, want %d and %d", raw.Amount, raw.Fee, amount, fee)
+	}
+}
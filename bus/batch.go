@@ -0,0 +1,191 @@
+package bus
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ledgerhq/satstack/protocol"
+	"github.com/ledgerhq/satstack/redact"
+	"github.com/ledgerhq/satstack/types"
+	"github.com/ledgerhq/satstack/utils"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultBatchSize indicates the number of transactions fetched in a
+	// single batched JSON-RPC request, unless overridden in the config
+	// (rpc_batch_size).
+	defaultBatchSize = 50
+
+	// batchConcurrency indicates the number of batched JSON-RPC requests
+	// that may be in flight at the same time.
+	batchConcurrency = 4
+)
+
+// GetTransactions fetches the transactions with the given hashes, using
+// batched JSON-RPC requests processed by a bounded pool of workers.
+//
+// The returned slice has the same order as hashes. An entry is nil if the
+// corresponding transaction could not be fetched; the failure is logged with
+// the request ID carried by ctx, if any, but does not abort the other
+// lookups.
+func (b *Bus) GetTransactions(ctx context.Context, hashes []string) []*types.Transaction {
+	ret := make([]*types.Transaction, len(hashes))
+
+	type chunk struct {
+		offset int
+		hashes []string
+	}
+
+	chunks := make(chan chunk)
+
+	var wg sync.WaitGroup
+	for i := 0; i < batchConcurrency; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			// Each worker writes to a distinct range of ret.
+			for c := range chunks {
+				copy(ret[c.offset:], b.getTransactionsBatch(ctx, c.hashes))
+			}
+		}()
+	}
+
+	for offset := 0; offset < len(hashes); offset += b.batchSize {
+		end := offset + b.batchSize
+		if end > len(hashes) {
+			end = len(hashes)
+		}
+
+		chunks <- chunk{offset: offset, hashes: hashes[offset:end]}
+	}
+
+	close(chunks)
+	wg.Wait()
+
+	return ret
+}
+
+// getTransactionsBatch fetches the given transactions in a single batched
+// JSON-RPC request, skipping the ones already in the transactions cache.
+//
+// The errors of the individual lookups are logged, while if the batch fails
+// as a whole, the transactions are fetched one by one instead.
+func (b *Bus) getTransactionsBatch(ctx context.Context, hashes []string) []*types.Transaction {
+	ret := make([]*types.Transaction, len(hashes))
+
+	var pending []int // indexes of transactions not found in the cache
+	for idx, hash := range hashes {
+		if tx, found := b.CachedTransaction(ctx, hash); found {
+			ret[idx] = tx
+			continue
+		}
+
+		pending = append(pending, idx)
+	}
+
+	if len(pending) == 0 {
+		return ret
+	}
+
+	batch := b.client.NewBatch()
+
+	receivers := make([]func() (*types.Transaction, error), len(hashes))
+	for _, idx := range pending {
+		receive, err := b.queueGetTransaction(batch, hashes[idx])
+		if err != nil {
+			utils.Logger(ctx).WithFields(log.Fields{
+				"hash":  redact.TxID(hashes[idx]),
+				"error": err,
+			}).Error("Unable to fetch transaction")
+			continue
+		}
+
+		receivers[idx] = receive
+	}
+
+	if batch.Len() == 0 {
+		return ret
+	}
+
+	err := b.callRPC(ctx, "batch", batch.Send)
+	if err != nil {
+		utils.Logger(ctx).WithFields(log.Fields{
+			"size":  len(pending),
+			"error": err,
+		}).Debug("Batch request failed, falling back to individual requests")
+
+		b.getTransactionsSequential(ctx, hashes, pending, ret)
+		return ret
+	}
+
+	for idx, receive := range receivers {
+		if receive == nil {
+			continue
+		}
+
+		tx, err := receive()
+		if err != nil {
+			utils.Logger(ctx).WithFields(log.Fields{
+				"hash":  redact.TxID(hashes[idx]),
+				"error": err,
+			}).Error("Unable to fetch transaction")
+			continue
+		}
+
+		ret[idx] = tx
+	}
+
+	return ret
+}
+
+// queueGetTransaction adds a transaction lookup to the batch, and returns a
+// function to decode the result once the batch has been sent.
+func (b *Bus) queueGetTransaction(batch *rpcBatch, hash string) (func() (*types.Transaction, error), error) {
+	chainHash, err := utils.ParseChainHash(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if b.Capabilities().TxIndex {
+		receive := batch.GetRawTransaction(chainHash)
+		return func() (*types.Transaction, error) {
+			txRaw, err := receive()
+			if err != nil {
+				return nil, err
+			}
+
+			return protocol.DecodeMsgTx(txRaw.MsgTx(), b.Params), nil
+		}, nil
+	}
+
+	receive := batch.GetTransactionWatchOnly(chainHash, true)
+	return func() (*types.Transaction, error) {
+		txRaw, err := receive()
+		if err != nil {
+			return nil, err
+		}
+
+		return protocol.DecodeRawTransaction(txRaw.Hex, b.Params)
+	}, nil
+}
+
+// getTransactionsSequential fetches the transactions at the pending indexes
+// one by one, and stores them in ret.
+func (b *Bus) getTransactionsSequential(ctx context.Context, hashes []string, pending []int, ret []*types.Transaction) {
+	for _, idx := range pending {
+		tx, err := b.GetTransaction(ctx, hashes[idx])
+		if err != nil {
+			utils.Logger(ctx).WithFields(log.Fields{
+				"hash":  redact.TxID(hashes[idx]),
+				"error": err,
+			}).Error("Unable to fetch transaction")
+			continue
+		}
+
+		ret[idx] = tx
+	}
+}
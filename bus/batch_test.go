@@ -0,0 +1,137 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+)
+
+// newTxIndexNode returns a fakeNode with a transaction index holding n
+// transactions, and their hashes.
+func newTxIndexNode(n int) (*fakeNode, []string) {
+	node := newFakeNode()
+
+	txs := make(map[string]string, n)
+	hashes := make([]string, n)
+	for i := range hashes {
+		tx, txHex := testTx(uint32(i))
+		hashes[i] = tx.TxHash().String()
+		txs[hashes[i]] = txHex
+	}
+
+	node.handle("getrawtransaction", func(params []json.RawMessage) (interface{}, error) {
+		var txid string
+		param(params, 0, &txid)
+
+		txHex, ok := txs[txid]
+		if !ok {
+			return nil, btcjson.NewRPCError(btcjson.ErrRPCNoTxInfo, "No such mempool or blockchain transaction")
+		}
+
+		return txHex, nil
+	})
+
+	return node, hashes
+}
+
+func TestGetTransactionsBatch(t *testing.T) {
+	node, hashes := newTxIndexNode(120)
+	b := newTestBus(node)
+	b.setCapabilities(&Capabilities{TxIndex: true})
+
+	// Unknown transactions do not fail the other lookups.
+	unknown := "0000000000000000000000000000000000000000000000000000000000000001"
+	hashes = append(hashes[:60], append([]string{unknown}, hashes[60:]...)...)
+
+	txs := b.GetTransactions(context.Background(), hashes)
+	if len(txs) != len(hashes) {
+		t.Fatalf("got %d transactions, want %d", len(txs), len(hashes))
+	}
+
+	for idx, tx := range txs {
+		if hashes[idx] == unknown {
+			if tx != nil {
+				t.Errorf("txs[%d] = %s, want nil", idx, tx.ID)
+			}
+			continue
+		}
+
+		if tx == nil || tx.ID != hashes[idx] {
+			t.Errorf("txs[%d] = %v, want %s", idx, tx, hashes[idx])
+		}
+	}
+
+	// 121 transactions in batches of 50.
+	if got := node.roundTrips(); got != 3 {
+		t.Errorf("got %d round trips, want 3", got)
+	}
+}
+
+func TestGetTransactionsBatchFallback(t *testing.T) {
+	node, hashes := newTxIndexNode(10)
+	b := newTestBus(node)
+	b.setCapabilities(&Capabilities{TxIndex: true})
+
+	// Fail the batches as a whole, but not the individual requests.
+	failing := &batchFailingNode{node}
+	b.client = newInstrumentedClient(failing, b.rpcStats)
+
+	txs := b.GetTransactions(context.Background(), hashes)
+	for idx, tx := range txs {
+		if tx == nil || tx.ID != hashes[idx] {
+			t.Errorf("txs[%d] = %v, want %s", idx, tx, hashes[idx])
+		}
+	}
+
+	if got := node.count("getrawtransaction"); got != len(hashes) {
+		t.Errorf("got %d getrawtransaction calls, want %d", got, len(hashes))
+	}
+}
+
+// batchFailingNode is a fakeNode failing the batches of several requests.
+type batchFailingNode struct {
+	*fakeNode
+}
+
+func (n *batchFailingNode) Send(ctx context.Context, requests []*btcjson.Request) ([]*btcjson.Response, error) {
+	if len(requests) > 1 {
+		return nil, errors.New("status code: 500, response: \"\"")
+	}
+
+	return n.fakeNode.Send(ctx, requests)
+}
+
+// benchmarkGetTransactions looks up 200 transactions on a node with a round
+// trip latency of 1ms.
+func benchmarkGetTransactions(b *testing.B, lookup func(*Bus, []string)) {
+	node, hashes := newTxIndexNode(200)
+	node.latency = time.Millisecond
+
+	bus := newTestBus(node)
+	bus.setCapabilities(&Capabilities{TxIndex: true})
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lookup(bus, hashes)
+	}
+}
+
+func BenchmarkGetTransactionsBatched(b *testing.B) {
+	benchmarkGetTransactions(b, func(bus *Bus, hashes []string) {
+		bus.GetTransactions(context.Background(), hashes)
+	})
+}
+
+func BenchmarkGetTransactionsSequential(b *testing.B) {
+	benchmarkGetTransactions(b, func(bus *Bus, hashes []string) {
+		for _, hash := range hashes {
+			if _, err := bus.GetTransaction(context.Background(), hash); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,304 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ledgerhq/satstack/types"
+	"github.com/ledgerhq/satstack/utils"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcutil"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// BlockStatsSource is the Source of the BlockStats reported by the
+	// getblockstats RPC.
+	BlockStatsSource = "getblockstats"
+
+	// BlockStatsComputed is the Source of the BlockStats computed from the
+	// transactions of the block, if getblockstats failed.
+	BlockStatsComputed = "block"
+
+	// witnessScaleFactor is the ratio of the weight of a transaction to its
+	// virtual size (BIP141).
+	witnessScaleFactor = 4
+)
+
+// blockStatsFields are the stats requested from the getblockstats RPC.
+var blockStatsFields = []string{
+	"blockhash", "height", "time", "txs", "totalfee",
+	"feerate_percentiles", "minfeerate", "maxfeerate", "avgfeerate",
+}
+
+// blockStatsResult models the subset of the response of the getblockstats
+// RPC used by SatStack, which is not supported by rpcclient. Fees are in
+// satoshis, and fee rates in satoshis per vbyte.
+type blockStatsResult struct {
+	Hash               string  `json:"blockhash"`
+	Height             int64   `json:"height"`
+	Time               int64   `json:"time"`
+	Txs                int64   `json:"txs"`
+	TotalFee           int64   `json:"totalfee"`
+	FeeRatePercentiles []int64 `json:"feerate_percentiles"` // 10th, 25th, 50th, 75th and 90th
+	MinFeeRate         int64   `json:"minfeerate"`
+	MaxFeeRate         int64   `json:"maxfeerate"`
+	AvgFeeRate         int64   `json:"avgfeerate"`
+}
+
+// statsBlock models the subset of the response of the getblock RPC, with
+// verbosity 2 or 3, used to compute the stats of a block.
+type statsBlock struct {
+	Hash   string `json:"hash"`
+	Height int64  `json:"height"`
+	Time   int64  `json:"time"`
+	Tx     []struct {
+		Txid   string    `json:"txid"`
+		Weight int64     `json:"weight"`
+		Fee    *btcValue `json:"fee"` // BTC, if the undo data of the block is available
+		Vin    []struct {
+			Coinbase string `json:"coinbase"`
+			Txid     string `json:"txid"`
+			Vout     uint32 `json:"vout"`
+			Prevout  *struct {
+				Value btcValue `json:"value"` // BTC
+			} `json:"prevout"` // only with verbosity 3
+		} `json:"vin"`
+		Vout []struct {
+			Value btcValue `json:"value"` // BTC
+		} `json:"vout"`
+	} `json:"tx"`
+}
+
+// GetBlockStats returns the fee statistics of the block with the given hash.
+//
+// They are reported by the getblockstats RPC, which does not require the
+// transaction index. If it fails, they are computed from the transactions of
+// the block instead, the same way. The stats of a pruned block cannot be
+// computed, and ErrBlockNotFound is returned.
+//
+// The stats of the blocks below the chain tip are cached, and invalidated on
+// chain reorganizations.
+func (b *Bus) GetBlockStats(ctx context.Context, hash *chainhash.Hash) (*types.BlockStats, error) {
+	if stats, found := b.blockStatsCache.Get(ctx, hash.String()); found {
+		return stats.(*types.BlockStats), nil
+	}
+
+	stats, err := b.fetchBlockStats(ctx, hash)
+	switch {
+	case isPrunedError(err):
+		return nil, fmt.Errorf("%w: block %s pruned by bitcoind", ErrBlockNotFound, hash)
+	case isNotFoundError(err), errors.Is(err, ErrRPCAborted):
+		return nil, err
+	case err != nil:
+		utils.Logger(ctx).WithFields(log.Fields{
+			"hash":  hash.String(),
+			"error": err,
+		}).Debug("getblockstats failed, computing stats from block")
+
+		if stats, err = b.computeBlockStats(ctx, hash); isPrunedError(err) {
+			return nil, fmt.Errorf("%w: block %s pruned by bitcoind", ErrBlockNotFound, hash)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if bestHeight, err := b.GetBestBlockHeight(); err == nil && stats.Height < bestHeight {
+		b.blockStatsCache.Set(hash.String(), stats, 0)
+	}
+
+	return stats, nil
+}
+
+// fetchBlockStats gets the stats of the block with the getblockstats RPC.
+func (b *Bus) fetchBlockStats(ctx context.Context, hash *chainhash.Hash) (*types.BlockStats, error) {
+	var raw json.RawMessage
+	err := b.callRPC(ctx, "getblockstats", func(ctx context.Context) error {
+		var err error
+		raw, err = rawRequest(ctx, b.client, "getblockstats", hash.String(), blockStatsFields)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result blockStatsResult
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, err
+	}
+
+	var median int64
+	if len(result.FeeRatePercentiles) == 5 {
+		median = result.FeeRatePercentiles[2]
+	}
+
+	return &types.BlockStats{
+		Hash:          result.Hash,
+		Height:        result.Height,
+		Time:          utils.ParseUnixTimestamp(result.Time),
+		TxCount:       result.Txs,
+		TotalFees:     btcutil.Amount(result.TotalFee),
+		MedianFeeRate: median,
+		MinFeeRate:    result.MinFeeRate,
+		MaxFeeRate:    result.MaxFeeRate,
+		AvgFeeRate:    result.AvgFeeRate,
+		Source:        BlockStatsSource,
+	}, nil
+}
+
+// computeBlockStats computes the stats of the block from its transactions,
+// like getblockstats does.
+//
+// The fee of each transaction is reported by getblock if bitcoind has the
+// undo data of the block, or from the outputs it spends, which are fetched
+// along with the block on nodes that support it, and looked up otherwise.
+// ErrTxIndexRequired is returned if a spent output cannot be found.
+func (b *Bus) computeBlockStats(ctx context.Context, hash *chainhash.Hash) (*types.BlockStats, error) {
+	verbosity := 2
+	if b.BlockPrevoutsSupported() {
+		verbosity = 3
+	}
+
+	var raw json.RawMessage
+	err := b.callRPC(ctx, "getblock", func(ctx context.Context) error {
+		var err error
+		raw, err = rawRequest(ctx, b.client, "getblock", hash.String(), verbosity)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var block statsBlock
+	if err := json.Unmarshal(raw, &block); err != nil {
+		return nil, err
+	}
+
+	// Look up the transactions spent by the inputs of the transactions
+	// whose fee is unknown.
+	var lookups []string
+	visited := make(map[string]bool)
+
+	for _, tx := range block.Tx {
+		if tx.Fee != nil {
+			continue
+		}
+
+		for _, vin := range tx.Vin {
+			if vin.Coinbase == "" && vin.Prevout == nil && !visited[vin.Txid] {
+				lookups = append(lookups, vin.Txid)
+				visited[vin.Txid] = true
+			}
+		}
+	}
+
+	prevTxs := make(map[string]*types.Transaction, len(lookups))
+	for idx, prevTx := range b.GetTransactions(ctx, lookups) {
+		if prevTx != nil {
+			prevTxs[lookups[idx]] = prevTx
+		}
+	}
+
+	type feeRate struct {
+		rate   int64
+		weight int64
+	}
+
+	var totalFee, totalWeight int64
+	rates := make([]feeRate, 0, len(block.Tx))
+
+	for _, tx := range block.Tx {
+		if len(tx.Vin) > 0 && tx.Vin[0].Coinbase != "" {
+			continue
+		}
+
+		var fee int64
+		if tx.Fee != nil {
+			fee = int64(tx.Fee.Amount())
+		} else {
+			for _, vin := range tx.Vin {
+				switch prevTx := prevTxs[vin.Txid]; {
+				case vin.Prevout != nil:
+					fee += int64(vin.Prevout.Value.Amount())
+				case prevTx != nil && int(vin.Vout) < len(prevTx.Outputs):
+					fee += int64(*prevTx.Outputs[vin.Vout].Value)
+				default:
+					return nil, fmt.Errorf("%w: outputs spent by %s not found", ErrTxIndexRequired, tx.Txid)
+				}
+			}
+
+			for _, vout := range tx.Vout {
+				fee -= int64(vout.Value.Amount())
+			}
+		}
+
+		totalFee += fee
+		totalWeight += tx.Weight
+
+		var rate int64
+		if tx.Weight > 0 {
+			rate = fee * witnessScaleFactor / tx.Weight
+		}
+
+		rates = append(rates, feeRate{rate: rate, weight: tx.Weight})
+	}
+
+	stats := &types.BlockStats{
+		Hash:      block.Hash,
+		Height:    block.Height,
+		Time:      utils.ParseUnixTimestamp(block.Time),
+		TxCount:   int64(len(block.Tx)),
+		TotalFees: btcutil.Amount(totalFee),
+		Source:    BlockStatsComputed,
+	}
+
+	if len(rates) == 0 {
+		return stats, nil
+	}
+
+	sort.Slice(rates, func(i, j int) bool {
+		if rates[i].rate != rates[j].rate {
+			return rates[i].rate < rates[j].rate
+		}
+
+		return rates[i].weight < rates[j].weight
+	})
+
+	stats.MinFeeRate = rates[0].rate
+	stats.MaxFeeRate = rates[len(rates)-1].rate
+
+	if totalWeight > 0 {
+		stats.AvgFeeRate = totalFee * witnessScaleFactor / totalWeight
+	}
+
+	// The median is the fee rate of the transaction that brings the
+	// cumulative weight to half the total, as in getblockstats.
+	var cumulative int64
+	stats.MedianFeeRate = stats.MaxFeeRate
+
+	for _, rate := range rates {
+		cumulative += rate.weight
+		if float64(cumulative) >= float64(totalWeight)/2 {
+			stats.MedianFeeRate = rate.rate
+			break
+		}
+	}
+
+	return stats, nil
+}
+
+// isPrunedError returns true if the RPC error indicates that the data of the
+// requested block was pruned by bitcoind.
+func isPrunedError(err error) bool {
+	var rpcErr *btcjson.RPCError
+	return errors.As(err, &rpcErr) && rpcErr.Code == btcjson.ErrRPCMisc &&
+		strings.Contains(rpcErr.Message, "pruned")
+}
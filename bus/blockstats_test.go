@@ -0,0 +1,216 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/ledgerhq/satstack/types"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// statsBlockHash is the hash of the block at height 5 of newSyncedNode.
+var statsBlockHash, _ = chainhash.NewHashFromStr(mockBlockHash('a', 5))
+
+// statsBlockResult returns the getblock response of a block with a coinbase,
+// and transactions paying 10, 20 and 1 sat/vB. The fee of the second one is
+// computed from its prevout, and the one of the others reported by bitcoind.
+func statsBlockResult() map[string]interface{} {
+	return map[string]interface{}{
+		"hash":   statsBlockHash.String(),
+		"height": 5,
+		"time":   1609459200,
+		"tx": []map[string]interface{}{
+			{
+				"txid":   mockBlockHash('t', 0),
+				"weight": 800,
+				"vin":    []map[string]interface{}{{"coinbase": "0305"}},
+				"vout":   []map[string]interface{}{{"value": json.Number("50.00006500")}},
+			},
+			{
+				"txid":   mockBlockHash('t', 1),
+				"weight": 400,
+				"fee":    json.Number("0.00001"),
+				"vin":    []map[string]interface{}{{"txid": mockBlockHash('p', 1), "vout": 0}},
+			},
+			{
+				"txid":   mockBlockHash('t', 2),
+				"weight": 1000,
+				"vin": []map[string]interface{}{{
+					"txid":    mockBlockHash('p', 2),
+					"vout":    1,
+					"prevout": map[string]interface{}{"value": json.Number("0.001")},
+				}},
+				"vout": []map[string]interface{}{{"value": json.Number("0.00095")}},
+			},
+			{
+				"txid":   mockBlockHash('t', 3),
+				"weight": 2000,
+				"fee":    json.Number("0.000005"),
+				"vin":    []map[string]interface{}{{"txid": mockBlockHash('p', 3), "vout": 0}},
+			},
+		},
+	}
+}
+
+// newStatsBus returns a Bus on newSyncedNode, with getblockstats failing
+// with err if not nil.
+func newStatsBus(err error) (*Bus, *fakeNode) {
+	node := newSyncedNode()
+
+	node.handle("getblockstats", func(params []json.RawMessage) (interface{}, error) {
+		if err != nil {
+			return nil, err
+		}
+
+		return map[string]interface{}{
+			"blockhash":           statsBlockHash.String(),
+			"height":              5,
+			"time":                1609459200,
+			"txs":                 4,
+			"totalfee":            6500,
+			"feerate_percentiles": []int64{1, 1, 1, 10, 20},
+			"minfeerate":          1,
+			"maxfeerate":          20,
+			"avgfeerate":          7,
+		}, nil
+	})
+
+	node.result("getblock", statsBlockResult())
+
+	b := newTestBus(node)
+	b.setCapabilities(&Capabilities{Version: minBlockPrevoutVersion})
+
+	return b, node
+}
+
+// wantStats are the stats of the block of statsBlockResult, without source.
+var wantStats = types.BlockStats{
+	Hash:          mockBlockHash('a', 5),
+	Height:        5,
+	Time:          "2021-01-01T00:00:00Z",
+	TxCount:       4,
+	TotalFees:     6500,
+	MedianFeeRate: 1, // the 1 sat/vB transaction makes up more than half of the weight
+	MinFeeRate:    1,
+	MaxFeeRate:    20,
+	AvgFeeRate:    7, // 6500 sat for 850 vB
+}
+
+func TestGetBlockStats(t *testing.T) {
+	b, node := newStatsBus(nil)
+
+	stats, err := b.GetBlockStats(context.Background(), statsBlockHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := wantStats
+	want.Source = BlockStatsSource
+	if *stats != want {
+		t.Errorf("got stats %+v, want %+v", *stats, want)
+	}
+
+	if node.count("getblock") != 0 {
+		t.Error("got the block fetched, want the stats of getblockstats")
+	}
+
+	// The stats of a block below the tip are cached.
+	if _, err := b.GetBlockStats(context.Background(), statsBlockHash); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := node.count("getblockstats"); got != 1 {
+		t.Errorf("got %d getblockstats calls, want 1", got)
+	}
+
+	b.invalidateAbove(4)
+
+	if _, err := b.GetBlockStats(context.Background(), statsBlockHash); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := node.count("getblockstats"); got != 2 {
+		t.Errorf("got %d getblockstats calls after a reorganization, want 2", got)
+	}
+}
+
+func TestGetBlockStatsComputed(t *testing.T) {
+	b, node := newStatsBus(btcjson.NewRPCError(btcjson.ErrRPCMethodNotFound.Code, "Method not found"))
+
+	stats, err := b.GetBlockStats(context.Background(), statsBlockHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := wantStats
+	want.Source = BlockStatsComputed
+	if *stats != want {
+		t.Errorf("got stats %+v, want %+v", *stats, want)
+	}
+
+	// The prevouts are fetched along with the block.
+	if got := node.count("getrawtransaction"); got != 0 {
+		t.Errorf("got %d getrawtransaction calls, want none", got)
+	}
+}
+
+func TestGetBlockStatsPrevoutNotFound(t *testing.T) {
+	b, node := newStatsBus(btcjson.NewRPCError(btcjson.ErrRPCMethodNotFound.Code, "Method not found"))
+
+	// Without the fee, the outputs spent by the first transaction are
+	// looked up, and cannot be found.
+	block := statsBlockResult()
+	delete(block["tx"].([]map[string]interface{})[1], "fee")
+	node.result("getblock", block)
+
+	if _, err := b.GetBlockStats(context.Background(), statsBlockHash); !errors.Is(err, ErrTxIndexRequired) {
+		t.Errorf("got error %v, want %v", err, ErrTxIndexRequired)
+	}
+}
+
+func TestGetBlockStatsPruned(t *testing.T) {
+	pruned := btcjson.NewRPCError(btcjson.ErrRPCMisc, "Block not available (pruned data)")
+
+	b, node := newStatsBus(pruned)
+	node.handle("getblock", func([]json.RawMessage) (interface{}, error) {
+		return nil, pruned
+	})
+
+	if _, err := b.GetBlockStats(context.Background(), statsBlockHash); !errors.Is(err, ErrBlockNotFound) {
+		t.Errorf("got error %v, want %v", err, ErrBlockNotFound)
+	}
+
+	// getblockstats fails otherwise, and the block is pruned.
+	b, node = newStatsBus(btcjson.NewRPCError(btcjson.ErrRPCMisc, "Internal bug detected"))
+	node.handle("getblock", func([]json.RawMessage) (interface{}, error) {
+		return nil, pruned
+	})
+
+	if _, err := b.GetBlockStats(context.Background(), statsBlockHash); !errors.Is(err, ErrBlockNotFound) {
+		t.Errorf("got error %v, want %v", err, ErrBlockNotFound)
+	}
+}
+
+func TestGetBlockStatsAtTip(t *testing.T) {
+	b, node := newStatsBus(nil)
+
+	tip, _ := chainhash.NewHashFromStr(mockBlockHash('a', 10))
+	node.handle("getblockstats", func([]json.RawMessage) (interface{}, error) {
+		return map[string]interface{}{"blockhash": tip.String(), "height": 10}, nil
+	})
+
+	// The stats of the tip are not cached, since the block may be replaced.
+	for i := 0; i < 2; i++ {
+		if _, err := b.GetBlockStats(context.Background(), tip); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := node.count("getblockstats"); got != 2 {
+		t.Errorf("got %d getblockstats calls, want 2", got)
+	}
+}
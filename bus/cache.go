@@ -1,21 +1,235 @@
 package bus
 
-import "github.com/patrickmn/go-cache"
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
 
-// NewCache initializes a new cache storage and assigns it to the Cache field
-// of Bus.
-func (b *Bus) NewCache() {
-	// cleanupInterval is set to 0 to avoid spinning up the janitor
-	// goroutine.
-	b.Cache = cache.New(cache.NoExpiration, 0)
+	"github.com/ledgerhq/satstack/redact"
+	"github.com/ledgerhq/satstack/types"
+	"github.com/ledgerhq/satstack/utils"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultCacheSize indicates the maximum number of entries in each of
+	// the Bus caches, unless overridden in the config (cache_size).
+	defaultCacheSize = 10000
+
+	// defaultCacheTTL indicates how long unconfirmed transactions are
+	// cached, unless overridden in the config (cache_ttl). A zero TTL
+	// disables caching of unconfirmed transactions. Confirmed
+	// transactions are cached until evicted, or invalidated by a chain
+	// reorganization.
+	defaultCacheTTL = 30 * time.Second
+)
+
+// lruCache is a thread-safe cache, bounded to a maximum number of entries.
+// When full, the least recently used entry is evicted.
+type lruCache struct {
+	name string
+	size int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // front is most recently used
+
+	hits   uint64
+	misses uint64
+}
+
+type lruEntry struct {
+	key     string
+	value   interface{}
+	expires time.Time // zero if the entry does not expire
+}
+
+func newLRUCache(name string, size int) *lruCache {
+	return &lruCache{
+		name:    name,
+		size:    size,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Get returns the value cached for the key, if any and not expired. The
+// lookup is logged with the request ID carried by the context, if any.
+func (c *lruCache) Get(ctx context.Context, key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if ok {
+		entry := elem.Value.(*lruEntry)
+		if entry.expires.IsZero() || time.Now().Before(entry.expires) {
+			c.order.MoveToFront(elem)
+			c.hits++
+			c.logLookup(ctx, key, true)
+			return entry.value, true
+		}
+
+		c.remove(elem)
+	}
+
+	c.misses++
+	c.logLookup(ctx, key, false)
+	return nil, false
+}
+
+// Set caches the value for the key. A zero ttl caches the value until it is
+// evicted or deleted.
+func (c *lruCache) Set(key string, value interface{}, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &lruEntry{key: key, value: value}
+	if ttl > 0 {
+		entry.expires = time.Now().Add(ttl)
+	}
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(entry)
+
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+// Delete removes the entry for the key, if any.
+func (c *lruCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+}
+
+// DeleteFunc removes the entries for which the predicate returns true.
+func (c *lruCache) DeleteFunc(predicate func(value interface{}) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, elem := range c.entries {
+		if predicate(elem.Value.(*lruEntry).value) {
+			c.remove(elem)
+		}
+	}
+}
+
+// Purge removes all the entries.
+func (c *lruCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+}
+
+// Len returns the number of entries, including expired ones that have not
+// been looked up since.
+func (c *lruCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
+
+func (c *lruCache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*lruEntry).key)
+}
+
+// logLookup must be called with the lock held.
+func (c *lruCache) logLookup(ctx context.Context, key string, hit bool) {
+	if !log.IsLevelEnabled(log.DebugLevel) {
+		return
+	}
+
+	utils.Logger(ctx).WithFields(log.Fields{
+		"cache":  c.name,
+		"key":    redact.Abbreviate(key),
+		"hit":    hit,
+		"hits":   c.hits,
+		"misses": c.misses,
+	}).Debug("Cache lookup")
+}
+
+// CacheSize returns the number of entries in the transactions cache.
+func (b *Bus) CacheSize() int {
+	return b.txCache.Len()
 }
 
-// FlushCache clears the Bus cache storage, and sets the value of Bus.Cache to
-// nil.
-func (b *Bus) FlushCache() {
-	if b.Cache != nil {
-		b.Cache.Flush()
+// BlockCacheSize returns the number of entries in the blocks cache.
+func (b *Bus) BlockCacheSize() int {
+	return b.blockCache.Len()
+}
+
+// HeaderCacheSize returns the number of entries in the block headers cache.
+func (b *Bus) HeaderCacheSize() int {
+	return b.headerCache.Len()
+}
+
+// CachedTransaction returns a copy of the transaction cached with
+// CacheTransaction, if any. The confirmations of the copy are not updated,
+// and must be recomputed from the current chain tip by the caller.
+//
+// Confirmed transactions evicted from memory, or cached before a restart,
+// are looked up in the persistent cache, if enabled.
+func (b *Bus) CachedTransaction(ctx context.Context, hash string) (*types.Transaction, bool) {
+	if value, ok := b.txCache.Get(ctx, hash); ok {
+		return copyTransaction(value.(*types.Transaction)), true
+	}
+
+	tx, ok := b.persistent.transaction(hash)
+	if !ok {
+		return nil, false
+	}
+
+	b.txCache.Set(hash, copyTransaction(tx), 0)
+	return tx, true
+}
+
+// CacheTransaction caches a copy of the fully built transaction, as served
+// to clients, against its txid.
+//
+// Confirmed transactions are cached until evicted, or invalidated by a chain
+// reorganization affecting their block. Unconfirmed transactions expire
+// after a short while, since they may be confirmed, replaced, or evicted
+// from the mempool at any time. Only confirmed transactions are persisted.
+func (b *Bus) CacheTransaction(hash string, tx *types.Transaction) {
+	var ttl time.Duration
+	if tx.Block == nil {
+		if b.cacheTTL <= 0 {
+			return
+		}
+
+		ttl = b.cacheTTL
 	}
 
-	b.Cache = nil
+	b.txCache.Set(hash, copyTransaction(tx), ttl)
+	b.persistent.putTransaction(hash, tx)
+}
+
+// copyTransaction returns a copy of the transaction, that can be modified
+// without affecting the original. The Block is shared, and must therefore
+// not be modified.
+func copyTransaction(tx *types.Transaction) *types.Transaction {
+	ret := *tx
+
+	ret.Inputs = make([]types.Input, len(tx.Inputs))
+	copy(ret.Inputs, tx.Inputs)
+
+	ret.Outputs = make([]types.Output, len(tx.Outputs))
+	copy(ret.Outputs, tx.Outputs)
+
+	return &ret
 }
@@ -0,0 +1,141 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcjson"
+)
+
+// Capabilities models the features of the connected bitcoind node that are
+// relevant to SatStack, as detected by probeCapabilities.
+type Capabilities struct {
+	Version     int32 // as reported by getnetworkinfo, for ex 220000 for v22.0.0
+	Pruned      bool
+	PruneHeight int32 // height of the first block that is not pruned, if Pruned
+	TxIndex     bool
+	BlockFilter bool
+	Wallet      bool
+
+	// Warnings describe the missing features that SatStack can do
+	// without, along with the bitcoin.conf option enabling them.
+	Warnings []string
+}
+
+// probeCapabilities checks that the connected bitcoind node is able to serve
+// SatStack, and detects its optional features.
+//
+// All the checks are performed, even if one fails, so that the returned
+// error lists every problem at once, along with the bitcoin.conf option to
+// change. If requireTxIndex is true, a node without transaction index is
+// rejected; otherwise, it is only reported in the warnings. The detected
+// capabilities are returned along with ErrIncompatibleNode.
+func probeCapabilities(
+	client *rpcClient, info *btcjson.GetBlockChainInfoResult, version int32, requireTxIndex bool,
+) (*Capabilities, error) {
+	caps := &Capabilities{
+		Version:     version,
+		Pruned:      info.Pruned,
+		PruneHeight: info.PruneHeight,
+	}
+
+	var problems []string
+
+	if version < minSupportedBitcoindVersion {
+		problems = append(problems, fmt.Sprintf(
+			"bitcoind %s is not supported, upgrade to %s or later",
+			formatVersion(version), formatVersion(minSupportedBitcoindVersion)))
+	}
+
+	walletEnabled, err := walletEnabled(client)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", ErrBitcoindUnreachable, err)
+	}
+
+	caps.Wallet = walletEnabled
+
+	if !walletEnabled {
+		problems = append(problems,
+			"wallet is disabled, remove disablewallet=1 from bitcoin.conf")
+	}
+
+	txIndex, err := txIndexEnabled(client)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", ErrFailedToDetectTxIndex, err)
+	}
+
+	caps.TxIndex = txIndex
+
+	// The transaction index cannot be enabled on a pruned node.
+	txIndexOption := "txindex=1"
+	if info.Pruned {
+		txIndexOption = "txindex=1 and prune=0"
+	}
+
+	switch {
+	case !txIndex && requireTxIndex:
+		problems = append(problems, fmt.Sprintf(
+			"transaction index is disabled, set %s in bitcoin.conf", txIndexOption))
+	case !txIndex:
+		caps.Warnings = append(caps.Warnings, fmt.Sprintf(
+			"transaction index is disabled, only wallet transactions can be looked up; set %s in bitcoin.conf",
+			txIndexOption))
+	}
+
+	if info.Pruned {
+		caps.Warnings = append(caps.Warnings, fmt.Sprintf(
+			"blocks below height %d are pruned, accounts created before cannot be scanned; set prune=0 in bitcoin.conf",
+			info.PruneHeight))
+	}
+
+	blockFilter, err := blockFilterEnabled(client, info.BestBlockHash)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", ErrFailedToDetectBlockFilter, err)
+	}
+
+	caps.BlockFilter = blockFilter
+
+	if !blockFilter {
+		caps.Warnings = append(caps.Warnings,
+			"compact block filters are disabled, rescans are slower; set blockfilterindex=1 in bitcoin.conf")
+	}
+
+	if len(problems) > 0 {
+		return caps, fmt.Errorf("%w: %s", ErrIncompatibleNode, strings.Join(problems, "; "))
+	}
+
+	return caps, nil
+}
+
+// walletEnabled detects whether the wallet of the bitcoind node is enabled,
+// based on the availability of the getwalletinfo RPC. Since SatStack may not
+// have loaded its wallet yet, a wallet not found error is expected.
+func walletEnabled(client *rpcClient) (bool, error) {
+	_, err := rawRequest(context.Background(), client, "getwalletinfo")
+
+	var rpcErr *btcjson.RPCError
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.As(err, &rpcErr) && rpcErr.Code == btcjson.ErrRPCMethodNotFound.Code:
+		return false, nil
+	case errors.As(err, &rpcErr):
+		return true, nil
+	default:
+		return false, err
+	}
+}
+
+// formatVersion formats a version number reported by bitcoind, like 220000,
+// as a release version, like v22.0.0. Releases before v22.0.0 were numbered
+// 0.x.y.
+func formatVersion(version int32) string {
+	major, minor, patch := version/10000, version/100%100, version%100
+	if major < 22 {
+		return fmt.Sprintf("v0.%d.%d", major, minor)
+	}
+
+	return fmt.Sprintf("v%d.%d.%d", major, minor, patch)
+}
@@ -0,0 +1,82 @@
+package bus
+
+import (
+	"sync"
+	"testing"
+)
+
+// TestCapabilitiesConcurrent replaces the capabilities, as on reconnections,
+// while they are read by requests, and is meant to be run with -race.
+func TestCapabilitiesConcurrent(t *testing.T) {
+	b := newTestBus(newFakeNode())
+
+	nodes := []*Capabilities{
+		{Version: 210000, Pruned: true, PruneHeight: 1000, BlockFilter: true},
+		{Version: minBlockPrevoutVersion, TxIndex: true, Warnings: []string{"no block filter index"}},
+	}
+
+	done := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				// Each read sees the capabilities of a single probe.
+				strategies := b.LookupStrategies()
+				caps := b.Capabilities()
+				if caps.TxIndex != (caps.Version == minBlockPrevoutVersion) || len(strategies) < 3 {
+					t.Errorf("got inconsistent capabilities %+v", caps)
+					return
+				}
+
+				_ = b.BlockPrevoutsSupported()
+			}
+		}()
+	}
+
+	for i := 0; i < 1000; i++ {
+		b.setCapabilities(nodes[i%len(nodes)])
+	}
+
+	close(done)
+	wg.Wait()
+}
+
+func TestLookupStrategies(t *testing.T) {
+	tests := []struct {
+		caps Capabilities
+		want []string
+	}{
+		{Capabilities{TxIndex: true}, []string{LookupWallet, LookupMempool, LookupTxIndex}},
+		{Capabilities{BlockFilter: true}, []string{LookupWallet, LookupMempool, LookupBlockHash, LookupBlockFilter}},
+		{Capabilities{}, []string{LookupWallet, LookupMempool, LookupBlockHash}},
+	}
+
+	for _, test := range tests {
+		b := newTestBus(newFakeNode())
+		caps := test.caps
+		b.setCapabilities(&caps)
+
+		got := b.LookupStrategies()
+		if len(got) != len(test.want) {
+			t.Errorf("%+v: got %v, want %v", test.caps, got, test.want)
+			continue
+		}
+
+		for idx := range got {
+			if got[idx] != test.want[idx] {
+				t.Errorf("%+v: got %v, want %v", test.caps, got, test.want)
+				break
+			}
+		}
+	}
+}
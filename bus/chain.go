@@ -1,6 +1,13 @@
 package bus
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
 	"github.com/ledgerhq/satstack/types"
 	"github.com/ledgerhq/satstack/utils"
 
@@ -8,16 +15,91 @@ import (
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 )
 
+// headerCacheDepth is the minimum number of confirmations for the header of
+// a block looked up by hash to be cached.
+const headerCacheDepth = 6
+
+// chainTip models the best block known to the Bus.
+type chainTip struct {
+	Hash   *chainhash.Hash
+	Height int64
+}
+
+// GetBestBlockHash returns the hash of the best block in the longest chain.
+//
+// The value is served from the cached chain tip, which is kept fresh by the
+// worker (either by polling, or by ZMQ notifications). If the tip is not
+// cached, it is fetched from the node.
 func (b *Bus) GetBestBlockHash() (*chainhash.Hash, error) {
-	return b.mainClient.GetBestBlockHash()
+	if tip := b.cachedTip(); tip != nil {
+		return tip.Hash, nil
+	}
+
+	tip, err := b.refreshTip()
+	if err != nil {
+		return nil, err
+	}
+
+	return tip.Hash, nil
+}
+
+// GetBestBlockHeight returns the height of the best block in the longest
+// chain, using the same cached chain tip as GetBestBlockHash.
+func (b *Bus) GetBestBlockHeight() (int64, error) {
+	if tip := b.cachedTip(); tip != nil {
+		return tip.Height, nil
+	}
+
+	tip, err := b.refreshTip()
+	if err != nil {
+		return 0, err
+	}
+
+	return tip.Height, nil
 }
 
-func (b *Bus) GetBlockHash(height int64) (*chainhash.Hash, error) {
-	return b.mainClient.GetBlockHash(height)
+// GetBlockHash returns the hash of the block at the given height in the best
+// chain. ErrBlockNotFound is returned for heights beyond the chain tip.
+func (b *Bus) GetBlockHash(ctx context.Context, height int64) (*chainhash.Hash, error) {
+	var hash *chainhash.Hash
+	err := b.callRPC(ctx, "getblockhash", func(ctx context.Context) (err error) {
+		hash, err = b.client.GetBlockHash(ctx, height)
+		return err
+	})
+
+	var rpcErr *btcjson.RPCError
+	if errors.As(err, &rpcErr) && rpcErr.Code == btcjson.ErrRPCInvalidParameter {
+		return nil, fmt.Errorf("%w: height %d out of range", ErrBlockNotFound, height)
+	}
+
+	return hash, err
 }
 
-func (b *Bus) GetBlock(hash *chainhash.Hash) (*types.Block, error) {
-	nativeBlock, err := b.mainClient.GetBlockVerbose(hash)
+func (b *Bus) GetBlock(ctx context.Context, hash *chainhash.Hash) (*types.Block, error) {
+	// Blocks are immutable, and invalidated on chain reorganizations.
+	if block, found := b.blockCache.Get(ctx, hash.String()); found {
+		return block.(*types.Block), nil
+	}
+
+	// Concurrent requests for the same block share a single getblock call.
+	block, err := b.flights.do(ctx, "getblock:1:"+hash.String(),
+		func(ctx context.Context) (interface{}, error) {
+			return b.fetchBlock(ctx, hash)
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return block.(*types.Block), nil
+}
+
+// fetchBlock gets the block with the getblock RPC, and caches it.
+func (b *Bus) fetchBlock(ctx context.Context, hash *chainhash.Hash) (*types.Block, error) {
+	var nativeBlock *btcjson.GetBlockVerboseResult
+	err := b.callRPC(ctx, "getblock", func(ctx context.Context) (err error) {
+		nativeBlock, err = b.client.GetBlockVerbose(ctx, hash)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -34,9 +116,221 @@ func (b *Bus) GetBlock(hash *chainhash.Hash) (*types.Block, error) {
 		Transactions: &transactions,
 	}
 
+	b.blockCache.Set(block.Hash, &block, 0)
+
 	return &block, nil
 }
 
-func (b *Bus) GetBlockChainInfo() (*btcjson.GetBlockChainInfoResult, error) {
-	return b.mainClient.GetBlockChainInfo()
+func (b *Bus) GetBlockChainInfo(ctx context.Context) (*btcjson.GetBlockChainInfoResult, error) {
+	var info *btcjson.GetBlockChainInfoResult
+	err := b.callRPC(ctx, "getblockchaininfo", func(ctx context.Context) (err error) {
+		info, err = b.client.GetBlockChainInfo(ctx)
+		return err
+	})
+
+	return info, err
+}
+
+// cachedTip returns the cached chain tip, or nil if it has been invalidated.
+func (b *Bus) cachedTip() *chainTip {
+	b.tipMutex.RLock()
+	defer b.tipMutex.RUnlock()
+
+	return b.tip
+}
+
+// InvalidateTip clears the cached chain tip and height, forcing the next
+// lookup to query the node.
+func (b *Bus) InvalidateTip() {
+	b.tipMutex.Lock()
+	defer b.tipMutex.Unlock()
+
+	b.tip = nil
+}
+
+// refreshTip queries the node for the current chain tip, and updates the
+// cached value.
+func (b *Bus) refreshTip() (*chainTip, error) {
+	info, err := b.client.GetBlockChainInfo(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := chainhash.NewHashFromStr(info.BestBlockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	tip := &chainTip{
+		Hash:   hash,
+		Height: int64(info.Blocks),
+	}
+
+	b.updateTip(tip)
+	return tip, nil
+}
+
+// blockHeader is the subset of the getblockheader result used to locate
+// blocks by time.
+type blockHeader struct {
+	Hash              string `json:"hash"`
+	Height            int64  `json:"height"`
+	Confirmations     int64  `json:"confirmations"` // -1 if not in the best chain
+	Time              int64  `json:"time"`
+	MedianTime        int64  `json:"mediantime"`
+	PreviousBlockHash string `json:"previousblockhash"`
+	MerkleRoot        string `json:"merkleroot"`
+}
+
+// GetBlockAtTime returns the last block of the best chain with a median
+// time not after the given UNIX timestamp. Unlike block timestamps, median
+// times never decrease along the chain, which allows a binary search over
+// the block headers.
+//
+// If the timestamp is before the genesis block, the genesis block is
+// returned. If it is in the future, the chain tip is returned, with the
+// Future flag set.
+func (b *Bus) GetBlockAtTime(ctx context.Context, timestamp int64) (*types.BlockTime, error) {
+	bestHeight, err := b.GetBestBlockHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	tip, err := b.headerAt(ctx, bestHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	header := tip
+	if timestamp < tip.MedianTime {
+		// Search the first height with a median time after the timestamp,
+		// in [1, bestHeight]. The block before it is the one at the time.
+		low, high := int64(1), bestHeight
+		for low < high {
+			mid := low + (high-low)/2
+
+			midHeader, err := b.headerAt(ctx, mid)
+			if err != nil {
+				return nil, err
+			}
+
+			if midHeader.MedianTime <= timestamp {
+				low = mid + 1
+			} else {
+				high = mid
+			}
+		}
+
+		if header, err = b.headerAt(ctx, low-1); err != nil {
+			return nil, err
+		}
+	}
+
+	return &types.BlockTime{
+		Hash:       header.Hash,
+		Height:     header.Height,
+		MedianTime: utils.ParseUnixTimestamp(header.MedianTime),
+		Future:     timestamp > time.Now().Unix(),
+	}, nil
+}
+
+// headerAt returns the header of the block of the best chain at the given
+// height. Headers are cached, and invalidated on chain reorganizations.
+func (b *Bus) headerAt(ctx context.Context, height int64) (*blockHeader, error) {
+	key := strconv.FormatInt(height, 10)
+	if header, found := b.headerCache.Get(ctx, key); found {
+		return header.(*blockHeader), nil
+	}
+
+	hash, err := b.GetBlockHash(ctx, height)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := b.fetchHeader(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	b.headerCache.Set(key, header, 0)
+	return header, nil
+}
+
+// GetBlockHeader returns the header of the block with the given hash, using
+// the getblockheader RPC only.
+//
+// The headers of blocks at least headerCacheDepth deep are cached, since
+// they are not expected to change. Like other cached headers, they are
+// invalidated on chain reorganizations.
+func (b *Bus) GetBlockHeader(ctx context.Context, hash *chainhash.Hash) (*types.BlockHeader, error) {
+	bestHeight, err := b.GetBestBlockHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	key := hash.String()
+
+	var header *blockHeader
+	if value, found := b.headerCache.Get(ctx, key); found {
+		header = value.(*blockHeader)
+	} else {
+		if header, err = b.fetchHeader(ctx, hash); err != nil {
+			return nil, err
+		}
+
+		if header.Confirmations >= headerCacheDepth {
+			b.headerCache.Set(key, header, 0)
+		}
+	}
+
+	// The confirmations of cached headers are recomputed from the chain tip.
+	confirmations := header.Confirmations
+	if confirmations > 0 {
+		confirmations = bestHeight - header.Height + 1
+		if confirmations < 1 {
+			confirmations = 1
+		}
+	}
+
+	return &types.BlockHeader{
+		Hash:              header.Hash,
+		Height:            header.Height,
+		Time:              utils.ParseUnixTimestamp(header.Time),
+		MedianTime:        utils.ParseUnixTimestamp(header.MedianTime),
+		PreviousBlockHash: header.PreviousBlockHash,
+		MerkleRoot:        header.MerkleRoot,
+		Confirmations:     confirmations,
+	}, nil
+}
+
+// fetchHeader gets the header of the block with the getblockheader RPC,
+// unless it is in the persistent cache. The headers of blocks at least
+// headerCacheDepth deep are persisted.
+//
+// The confirmations of persisted headers are those at the time they were
+// fetched.
+func (b *Bus) fetchHeader(ctx context.Context, hash *chainhash.Hash) (*blockHeader, error) {
+	if header, ok := b.persistent.header(hash.String()); ok {
+		return header, nil
+	}
+
+	var raw json.RawMessage
+	err := b.callRPC(ctx, "getblockheader", func(ctx context.Context) (err error) {
+		raw, err = rawRequest(ctx, b.client, "getblockheader", hash.String(), true)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var header blockHeader
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return nil, err
+	}
+
+	if header.Confirmations >= headerCacheDepth {
+		b.persistent.putHeader(&header)
+	}
+
+	return &header, nil
 }
@@ -0,0 +1,178 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ledgerhq/satstack/config"
+	"github.com/ledgerhq/satstack/utils"
+
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+// WalletState describes the SatStack wallet in bitcoind, as found by
+// CheckNode.
+type WalletState = string
+
+const (
+	WalletLoaded    WalletState = "loaded"
+	WalletUnloaded  WalletState = "not loaded, will be loaded on startup"
+	WalletMissing   WalletState = "missing, will be created on startup"
+	WalletUnchecked WalletState = "not checked"
+)
+
+// NodeReport describes the bitcoind node, as probed by CheckNode.
+type NodeReport struct {
+	Chain       string
+	Version     string
+	Pruned      bool
+	PruneHeight int32
+	TxIndex     bool
+	BlockFilter bool
+	WalletName  string
+	Wallet      WalletState
+
+	// Warnings describe the optional features missing on the node.
+	Warnings []string
+
+	// Params are the network params of the chain of the node, to validate
+	// the accounts against.
+	Params *chaincfg.Params
+}
+
+// CheckNode connects to bitcoind using the RPC connection settings of the
+// configuration, and checks that the node is able to serve SatStack, like
+// on startup. It does not wait for bitcoind to warm up.
+//
+// Unlike New, the wallet is neither created nor loaded: only read-only RPCs
+// are performed. The returned report is nil if the node could not be
+// queried at all; otherwise, it is returned along with the problems found.
+func CheckNode(configuration *config.Configuration) (*NodeReport, []error) {
+	walletName := configuredWalletName(configuration)
+
+	connCfg, caCert, err := newConnConfig(configuration, walletName)
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	client, err := newClient(connCfg, configuredHTTPTimeout(configuration), newRPCStats())
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	defer client.Shutdown()
+
+	info, err := client.GetBlockChainInfo(context.Background())
+	if message, ok := warmupMessage(err); ok {
+		return nil, []error{fmt.Errorf("%s: warming up (%s), try again later",
+			ErrBitcoindUnreachable, message)}
+	}
+
+	if err != nil {
+		if isCertificateError(err) {
+			return nil, []error{fmt.Errorf("%s (ca: %s): %w", ErrTLSVerification, caCert, err)}
+		}
+
+		return nil, []error{fmt.Errorf("%s: %w", ErrBitcoindUnreachable, err)}
+	}
+
+	networkInfo, err := client.GetNetworkInfo(context.Background())
+	if err != nil {
+		return nil, []error{fmt.Errorf("%s: %w", ErrBitcoindUnreachable, err)}
+	}
+
+	var problems []error
+
+	report := &NodeReport{
+		Chain:      info.Chain,
+		Version:    formatVersion(networkInfo.Version),
+		WalletName: walletName,
+		Wallet:     WalletUnchecked,
+	}
+
+	if report.Params, err = ChainParams(info.Chain); err != nil {
+		problems = append(problems, err)
+	}
+
+	caps, err := probeCapabilities(client, info, networkInfo.Version, configuration.RequireTxIndex)
+	if err != nil {
+		problems = append(problems, err)
+	}
+
+	if caps == nil {
+		return report, problems
+	}
+
+	report.Pruned = caps.Pruned
+	report.PruneHeight = caps.PruneHeight
+	report.TxIndex = caps.TxIndex
+	report.BlockFilter = caps.BlockFilter
+	report.Warnings = caps.Warnings
+
+	if !caps.Wallet {
+		return report, problems
+	}
+
+	state, err := walletState(client, walletName)
+	if err != nil {
+		return report, append(problems, fmt.Errorf("%s: %w", ErrLoadWallet, err))
+	}
+
+	report.Wallet = state
+
+	// The private keys of a wallet can only be checked once loaded.
+	if state == WalletLoaded {
+		walletInfo, err := getWalletProperties(client)
+		if err != nil {
+			return report, append(problems, fmt.Errorf("%s: %w", ErrLoadWallet, err))
+		}
+
+		if err := checkWatchOnly(walletName, walletInfo); err != nil {
+			problems = append(problems, err)
+		}
+	}
+
+	return report, problems
+}
+
+// walletState finds whether the wallet with the given name is loaded, or
+// exists in the wallet directory of bitcoind.
+func walletState(client *rpcClient, walletName string) (WalletState, error) {
+	result, err := rawRequest(context.Background(), client, "listwallets")
+	if err != nil {
+		return "", err
+	}
+
+	var loaded []string
+	if err := json.Unmarshal(result, &loaded); err != nil {
+		return "", err
+	}
+
+	if utils.Contains(loaded, walletName) {
+		return WalletLoaded, nil
+	}
+
+	result, err = rawRequest(context.Background(), client, "listwalletdir")
+	if err != nil {
+		return "", err
+	}
+
+	var dir struct {
+		Wallets []struct {
+			Name string `json:"name"`
+		} `json:"wallets"`
+	}
+
+	if err := json.Unmarshal(result, &dir); err != nil {
+		return "", err
+	}
+
+	for _, wallet := range dir.Wallets {
+		if wallet.Name == walletName {
+			return WalletUnloaded, nil
+		}
+	}
+
+	return WalletMissing, nil
+}
@@ -0,0 +1,452 @@
+package bus
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"sync/atomic"
+	"time"
+
+	"github.com/ledgerhq/satstack/metrics"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// rpcClient performs RPC calls on bitcoind over an rpcTransport. Its
+// methods mirror the ones of rpcclient.Client that SatStack uses, with the
+// same parameters, results and errors; add a method below to use a new RPC.
+//
+// A single client is shared by the Bus, since the transport is safe for
+// concurrent use. All calls go through an instrumentedTransport, so that an
+// RPC cannot be called without being measured.
+type rpcClient struct {
+	transport rpcTransport
+
+	// ID of the last request sent. Access it atomically.
+	lastID uint64
+}
+
+// newClient creates an rpcClient connecting to bitcoind with the given
+// config, recording its calls in stats. A non-zero timeout bounds every HTTP
+// request, regardless of the ctx of the call.
+func newClient(connCfg *rpcclient.ConnConfig, timeout time.Duration, stats *rpcStats) (*rpcClient, error) {
+	transport, err := newHTTPTransport(connCfg, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return newInstrumentedClient(transport, stats), nil
+}
+
+// newInstrumentedClient creates an rpcClient sending its calls over
+// transport, recording them in stats.
+func newInstrumentedClient(transport rpcTransport, stats *rpcStats) *rpcClient {
+	return &rpcClient{transport: &instrumentedTransport{next: transport, stats: stats}}
+}
+
+// Shutdown closes the idle connections of the client.
+func (c *rpcClient) Shutdown() {
+	if closer, ok := c.transport.(interface{ close() }); ok {
+		closer.close()
+	}
+}
+
+// instrumentedTransport is an rpcTransport recording the outcome and latency
+// of the calls sent over next in stats, as well as in the Prometheus
+// metrics. A batch is recorded as a single call of the "batch" method.
+type instrumentedTransport struct {
+	next  rpcTransport
+	stats *rpcStats
+}
+
+// Send implements rpcTransport.
+func (t *instrumentedTransport) Send(ctx context.Context, requests []*btcjson.Request) ([]*btcjson.Response, error) {
+	start := time.Now()
+	responses, err := t.next.Send(ctx, requests)
+
+	method := "batch"
+	if len(requests) == 1 {
+		method = requests[0].Method
+
+		if err == nil {
+			_, err = responseResult(responses[0])
+		}
+	}
+
+	t.stats.observe(method, time.Since(start), err)
+	metrics.ObserveRPC(method, err)
+
+	return responses, err
+}
+
+func (t *instrumentedTransport) close() {
+	if closer, ok := t.next.(interface{ close() }); ok {
+		closer.close()
+	}
+}
+
+// newRequest marshals a btcjson command, like the ones created by
+// btcjson.NewGetBlockCmd, to a request with a new ID. The parameters are
+// those rpcclient would send for the command.
+func (c *rpcClient) newRequest(cmd interface{}) (*btcjson.Request, error) {
+	marshalled, err := btcjson.MarshalCmd(btcjson.RpcVersion1, atomic.AddUint64(&c.lastID, 1), cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	var request btcjson.Request
+	if err := json.Unmarshal(marshalled, &request); err != nil {
+		return nil, err
+	}
+
+	return &request, nil
+}
+
+// send sends a single request, and returns its result. The request is
+// cancelled if ctx is done.
+func (c *rpcClient) send(ctx context.Context, request *btcjson.Request) (json.RawMessage, error) {
+	responses, err := c.transport.Send(ctx, []*btcjson.Request{request})
+	if err != nil {
+		return nil, err
+	}
+
+	return responseResult(responses[0])
+}
+
+// call performs the RPC call of a btcjson command, and unmarshals its result
+// into result, unless nil.
+func (c *rpcClient) call(ctx context.Context, cmd interface{}, result interface{}) error {
+	request, err := c.newRequest(cmd)
+	if err != nil {
+		return err
+	}
+
+	raw, err := c.send(ctx, request)
+	if err != nil || result == nil {
+		return err
+	}
+
+	return json.Unmarshal(raw, result)
+}
+
+// responseResult returns the result of a response, or its error.
+func responseResult(response *btcjson.Response) (json.RawMessage, error) {
+	if response == nil {
+		return nil, errNoResponse
+	}
+
+	if response.Error != nil {
+		return nil, response.Error
+	}
+
+	return response.Result, nil
+}
+
+// rpcBatch collects RPC calls to send in a single batched request.
+type rpcBatch struct {
+	client    *rpcClient
+	requests  []*btcjson.Request
+	responses []*btcjson.Response
+}
+
+// NewBatch creates an empty batch of calls, to send with the client.
+//
+// Unlike the batch mode of rpcclient, the batch is independent of the
+// client, which can be used concurrently for other calls.
+func (c *rpcClient) NewBatch() *rpcBatch {
+	return &rpcBatch{client: c}
+}
+
+// queue adds the call of a btcjson command to the batch, and returns a
+// function to unmarshal its result into result once the batch has been sent.
+func (b *rpcBatch) queue(cmd interface{}, result interface{}) func() error {
+	request, err := b.client.newRequest(cmd)
+	if err != nil {
+		return func() error { return err }
+	}
+
+	idx := len(b.requests)
+	b.requests = append(b.requests, request)
+
+	return func() error {
+		if b.responses == nil {
+			return errNoResponse
+		}
+
+		raw, err := responseResult(b.responses[idx])
+		if err != nil {
+			return err
+		}
+
+		return json.Unmarshal(raw, result)
+	}
+}
+
+// Len returns the number of calls in the batch.
+func (b *rpcBatch) Len() int {
+	return len(b.requests)
+}
+
+// Send sends the calls of the batch, which are recorded as a single batch
+// call. The results of the calls can be received once it returns nil.
+func (b *rpcBatch) Send(ctx context.Context) error {
+	responses, err := b.client.transport.Send(ctx, b.requests)
+	if err != nil {
+		return err
+	}
+
+	b.responses = responses
+	return nil
+}
+
+// GetRawTransaction queues a getrawtransaction call.
+func (b *rpcBatch) GetRawTransaction(txHash *chainhash.Hash) func() (*btcutil.Tx, error) {
+	var txHex string
+	receive := b.queue(btcjson.NewGetRawTransactionCmd(txHash.String(), btcjson.Int(0)), &txHex)
+
+	return func() (*btcutil.Tx, error) {
+		if err := receive(); err != nil {
+			return nil, err
+		}
+
+		return decodeRawTransaction(txHex)
+	}
+}
+
+// GetTransactionWatchOnly queues a gettransaction call.
+func (b *rpcBatch) GetTransactionWatchOnly(txHash *chainhash.Hash, watchOnly bool) func() (*btcjson.GetTransactionResult, error) {
+	var result btcjson.GetTransactionResult
+	receive := b.queue(btcjson.NewGetTransactionCmd(txHash.String(), &watchOnly), &result)
+
+	return func() (*btcjson.GetTransactionResult, error) {
+		if err := receive(); err != nil {
+			return nil, err
+		}
+
+		return &result, nil
+	}
+}
+
+// EstimateSmartFee queues an estimatesmartfee call.
+func (b *rpcBatch) EstimateSmartFee(confTarget int64, mode *btcjson.EstimateSmartFeeMode) func() (*btcjson.EstimateSmartFeeResult, error) {
+	var result btcjson.EstimateSmartFeeResult
+	receive := b.queue(btcjson.NewEstimateSmartFeeCmd(confTarget, mode), &result)
+
+	return func() (*btcjson.EstimateSmartFeeResult, error) {
+		if err := receive(); err != nil {
+			return nil, err
+		}
+
+		return &result, nil
+	}
+}
+
+// decodeRawTransaction decodes a transaction serialized in hex, like the
+// result of getrawtransaction.
+func decodeRawTransaction(txHex string) (*btcutil.Tx, error) {
+	serializedTx, err := hex.DecodeString(txHex)
+	if err != nil {
+		return nil, err
+	}
+
+	var msgTx wire.MsgTx
+	if err := msgTx.Deserialize(bytes.NewReader(serializedTx)); err != nil {
+		return nil, err
+	}
+
+	return btcutil.NewTx(&msgTx), nil
+}
+
+func (c *rpcClient) RawRequest(ctx context.Context, method string, params []json.RawMessage) (json.RawMessage, error) {
+	if params == nil {
+		params = []json.RawMessage{}
+	}
+
+	return c.send(ctx, &btcjson.Request{
+		Jsonrpc: btcjson.RpcVersion1,
+		Method:  method,
+		Params:  params,
+		ID:      atomic.AddUint64(&c.lastID, 1),
+	})
+}
+
+func (c *rpcClient) GetBlockChainInfo(ctx context.Context) (*btcjson.GetBlockChainInfoResult, error) {
+	var result btcjson.GetBlockChainInfoResult
+	if err := c.call(ctx, btcjson.NewGetBlockChainInfoCmd(), &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (c *rpcClient) GetNetworkInfo(ctx context.Context) (*btcjson.GetNetworkInfoResult, error) {
+	var result btcjson.GetNetworkInfoResult
+	if err := c.call(ctx, btcjson.NewGetNetworkInfoCmd(), &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (c *rpcClient) GetWalletInfo(ctx context.Context) (*btcjson.GetWalletInfoResult, error) {
+	var result btcjson.GetWalletInfoResult
+	if err := c.call(ctx, btcjson.NewGetWalletInfoCmd(), &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (c *rpcClient) GetTxOutSetInfo(ctx context.Context) (*btcjson.GetTxOutSetInfoResult, error) {
+	var result btcjson.GetTxOutSetInfoResult
+	if err := c.call(ctx, btcjson.NewGetTxOutSetInfoCmd(), &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (c *rpcClient) GetBlockHash(ctx context.Context, blockHeight int64) (*chainhash.Hash, error) {
+	var hash string
+	if err := c.call(ctx, btcjson.NewGetBlockHashCmd(blockHeight), &hash); err != nil {
+		return nil, err
+	}
+
+	return chainhash.NewHashFromStr(hash)
+}
+
+func (c *rpcClient) GetBlockVerbose(ctx context.Context, blockHash *chainhash.Hash) (*btcjson.GetBlockVerboseResult, error) {
+	var result btcjson.GetBlockVerboseResult
+	if err := c.call(ctx, btcjson.NewGetBlockCmd(blockHash.String(), btcjson.Int(1)), &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (c *rpcClient) GetBlockHeaderVerbose(ctx context.Context, blockHash *chainhash.Hash) (*btcjson.GetBlockHeaderVerboseResult, error) {
+	var result btcjson.GetBlockHeaderVerboseResult
+	if err := c.call(ctx, btcjson.NewGetBlockHeaderCmd(blockHash.String(), btcjson.Bool(true)), &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (c *rpcClient) GetBlockFilter(ctx context.Context, blockHash chainhash.Hash, filterType *btcjson.FilterTypeName) (*btcjson.GetBlockFilterResult, error) {
+	var result btcjson.GetBlockFilterResult
+	if err := c.call(ctx, btcjson.NewGetBlockFilterCmd(blockHash.String(), filterType), &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (c *rpcClient) GetRawTransaction(ctx context.Context, txHash *chainhash.Hash) (*btcutil.Tx, error) {
+	var txHex string
+	if err := c.call(ctx, btcjson.NewGetRawTransactionCmd(txHash.String(), btcjson.Int(0)), &txHex); err != nil {
+		return nil, err
+	}
+
+	return decodeRawTransaction(txHex)
+}
+
+func (c *rpcClient) GetTransactionWatchOnly(ctx context.Context, txHash *chainhash.Hash, watchOnly bool) (*btcjson.GetTransactionResult, error) {
+	var result btcjson.GetTransactionResult
+	if err := c.call(ctx, btcjson.NewGetTransactionCmd(txHash.String(), &watchOnly), &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (c *rpcClient) ListSinceBlockMinConfWatchOnly(ctx context.Context, blockHash *chainhash.Hash, minConfirms int, watchOnly bool) (*btcjson.ListSinceBlockResult, error) {
+	var hash *string
+	if blockHash != nil {
+		hash = btcjson.String(blockHash.String())
+	}
+
+	var result btcjson.ListSinceBlockResult
+	if err := c.call(ctx, btcjson.NewListSinceBlockCmd(hash, &minConfirms, &watchOnly), &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (c *rpcClient) EstimateSmartFee(ctx context.Context, confTarget int64, mode *btcjson.EstimateSmartFeeMode) (*btcjson.EstimateSmartFeeResult, error) {
+	var result btcjson.EstimateSmartFeeResult
+	if err := c.call(ctx, btcjson.NewEstimateSmartFeeCmd(confTarget, mode), &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (c *rpcClient) GetAddressInfo(ctx context.Context, address string) (*btcjson.GetAddressInfoResult, error) {
+	var result btcjson.GetAddressInfoResult
+	if err := c.call(ctx, btcjson.NewGetAddressInfoCmd(address), &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (c *rpcClient) GetDescriptorInfo(ctx context.Context, descriptor string) (*btcjson.GetDescriptorInfoResult, error) {
+	var result btcjson.GetDescriptorInfoResult
+	if err := c.call(ctx, btcjson.NewGetDescriptorInfoCmd(descriptor), &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (c *rpcClient) DeriveAddresses(ctx context.Context, descriptor string, descriptorRange *btcjson.DescriptorRange) (*btcjson.DeriveAddressesResult, error) {
+	var result btcjson.DeriveAddressesResult
+	if err := c.call(ctx, btcjson.NewDeriveAddressesCmd(descriptor, descriptorRange), &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (c *rpcClient) ImportMulti(ctx context.Context, requests []btcjson.ImportMultiRequest, options *btcjson.ImportMultiOptions) (btcjson.ImportMultiResults, error) {
+	var result btcjson.ImportMultiResults
+	if err := c.call(ctx, btcjson.NewImportMultiCmd(requests, options), &result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (c *rpcClient) CreateWallet(ctx context.Context, name string, opts ...rpcclient.CreateWalletOpt) (*btcjson.CreateWalletResult, error) {
+	cmd := btcjson.NewCreateWalletCmd(name, nil, nil, nil, nil)
+	for _, opt := range opts {
+		opt(cmd)
+	}
+
+	var result btcjson.CreateWalletResult
+	if err := c.call(ctx, cmd, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (c *rpcClient) LoadWallet(ctx context.Context, walletName string) (*btcjson.LoadWalletResult, error) {
+	var result btcjson.LoadWalletResult
+	if err := c.call(ctx, btcjson.NewLoadWalletCmd(walletName), &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (c *rpcClient) UnloadWallet(ctx context.Context, walletName *string) error {
+	return c.call(ctx, btcjson.NewUnloadWalletCmd(walletName), nil)
+}
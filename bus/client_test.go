@@ -0,0 +1,88 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/ledgerhq/satstack/metrics"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// methodStats returns the stats recorded for an RPC method, or zero stats.
+func methodStats(stats *rpcStats, method string) RPCMethodStats {
+	for _, methodStats := range stats.snapshot().Methods {
+		if methodStats.Method == method {
+			return methodStats
+		}
+	}
+
+	return RPCMethodStats{Method: method}
+}
+
+func TestInstrumentedTransport(t *testing.T) {
+	node := newFakeNode()
+	node.result("getblockcount", 100)
+	node.handle("getbestblockhash", func([]json.RawMessage) (interface{}, error) {
+		return nil, btcjson.NewRPCError(btcjson.ErrRPCInWarmup, "Loading block index...")
+	})
+
+	stats := newRPCStats()
+	client := newInstrumentedClient(node, stats)
+
+	calls := testutil.ToFloat64(metrics.RPCCalls.WithLabelValues("getblockcount"))
+	errs := testutil.ToFloat64(metrics.RPCErrors.WithLabelValues("getbestblockhash"))
+	batches := testutil.ToFloat64(metrics.RPCCalls.WithLabelValues("batch"))
+
+	var count int64
+	if err := client.call(context.Background(), btcjson.NewGetBlockCountCmd(), &count); err != nil || count != 100 {
+		t.Fatalf("got %d, %v, want 100", count, err)
+	}
+
+	// The error of a single call is recorded, even though the round trip
+	// succeeded.
+	var rpcErr *btcjson.RPCError
+	if err := client.call(context.Background(), btcjson.NewGetBestBlockHashCmd(), nil); !errors.As(err, &rpcErr) {
+		t.Fatalf("got error %v, want an RPC error", err)
+	}
+
+	batch := client.NewBatch()
+	batch.queue(btcjson.NewGetBlockCountCmd(), nil)
+	batch.queue(btcjson.NewGetBestBlockHashCmd(), nil)
+	if err := batch.Send(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method string
+		calls  int64
+		errors int64
+	}{
+		{"getblockcount", 1, 0},
+		{"getbestblockhash", 1, 1},
+		{"batch", 1, 0},
+	}
+
+	for _, test := range tests {
+		got := methodStats(stats, test.method)
+		if got.Calls != test.calls || got.Errors != test.errors {
+			t.Errorf("%s: got %d calls and %d errors, want %d and %d",
+				test.method, got.Calls, got.Errors, test.calls, test.errors)
+		}
+	}
+
+	if got := testutil.ToFloat64(metrics.RPCCalls.WithLabelValues("getblockcount")) - calls; got != 1 {
+		t.Errorf("rpc_calls_total{method=getblockcount} increased by %v, want 1", got)
+	}
+
+	if got := testutil.ToFloat64(metrics.RPCErrors.WithLabelValues("getbestblockhash")) - errs; got != 1 {
+		t.Errorf("rpc_errors_total{method=getbestblockhash} increased by %v, want 1", got)
+	}
+
+	if got := testutil.ToFloat64(metrics.RPCCalls.WithLabelValues("batch")) - batches; got != 1 {
+		t.Errorf("rpc_calls_total{method=batch} increased by %v, want 1", got)
+	}
+}
@@ -0,0 +1,182 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/ledgerhq/satstack/config"
+	"github.com/ledgerhq/satstack/redact"
+	"github.com/ledgerhq/satstack/types"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Chains of the derivations, by index of the descriptor of the account.
+var derivationChains = []string{"external", "change"}
+
+// derivationIndex maps the addresses derived from the descriptors of the
+// configured accounts to their derivation. The zero value is ready to use.
+type derivationIndex struct {
+	addresses map[string]types.Derivation
+	accounts  map[string][]string // addresses of each account, by ID
+	mutex     sync.RWMutex
+}
+
+// get returns the derivation of the address, if it belongs to an account.
+func (d *derivationIndex) get(address string) (types.Derivation, bool) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	derivation, ok := d.addresses[address]
+	return derivation, ok
+}
+
+// accountAddresses returns the set of addresses of the account, and false
+// if the account is unknown.
+func (d *derivationIndex) accountAddresses(account string) (map[string]bool, bool) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	addresses, ok := d.accounts[account]
+	if !ok {
+		return nil, false
+	}
+
+	ret := make(map[string]bool, len(addresses))
+	for _, address := range addresses {
+		ret[address] = true
+	}
+
+	return ret, true
+}
+
+// set replaces the derivations of the account.
+func (d *derivationIndex) set(account string, derivations map[string]types.Derivation) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.removeLocked(account)
+
+	if d.addresses == nil {
+		d.addresses = make(map[string]types.Derivation, len(derivations))
+		d.accounts = make(map[string][]string)
+	}
+
+	addresses := make([]string, 0, len(derivations))
+	for address, derivation := range derivations {
+		d.addresses[address] = derivation
+		addresses = append(addresses, address)
+	}
+
+	d.accounts[account] = addresses
+}
+
+// remove forgets the derivations of the account.
+func (d *derivationIndex) remove(account string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.removeLocked(account)
+}
+
+// relabel sets the label of the derivations of the account, whose label
+// may change when the configuration is reloaded.
+func (d *derivationIndex) relabel(account string, label string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for _, address := range d.accounts[account] {
+		derivation := d.addresses[address]
+		derivation.Label = label
+		d.addresses[address] = derivation
+	}
+}
+
+// removeLocked is the implementation of remove. The caller must hold the
+// mutex.
+func (d *derivationIndex) removeLocked(account string) {
+	for _, address := range d.accounts[account] {
+		delete(d.addresses, address)
+	}
+
+	delete(d.accounts, account)
+}
+
+// Derivation returns the derivation of the address from the descriptors of
+// the configured accounts, if it belongs to one.
+func (b *Bus) Derivation(address string) (types.Derivation, bool) {
+	return b.derivations.get(address)
+}
+
+// AccountAddresses returns the set of addresses derived from the descriptors
+// of the account, identified by its external descriptor or its address, as
+// configured. ErrAccountNotFound is returned if the account is unknown.
+func (b *Bus) AccountAddresses(account string) (map[string]bool, error) {
+	addresses, ok := b.derivations.accountAddresses(account)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrAccountNotFound, account)
+	}
+
+	return addresses, nil
+}
+
+// indexDerivations derives the addresses in the imported range of the
+// descriptors of the accounts, and records their derivation. A failure is
+// logged, and does not prevent the other accounts from being indexed.
+func (b *Bus) indexDerivations(client *rpcClient, accounts []config.Account) {
+	for _, account := range accounts {
+		descs, err := b.descriptors(client, account)
+		if err == nil {
+			err = b.indexAccountDerivations(client, account, descs)
+		}
+
+		if err != nil {
+			log.WithFields(log.Fields{
+				"prefix":     "worker",
+				"descriptor": redact.Descriptor(account.ID()),
+				"error":      err,
+			}).Warn("Failed to index the derivations of account")
+		}
+	}
+}
+
+// indexAccountDerivations derives the addresses in the range of the given
+// descriptors of the account, and replaces its recorded derivations.
+func (b *Bus) indexAccountDerivations(client *rpcClient, account config.Account, descs []descriptor) error {
+	derivations := make(map[string]types.Derivation)
+
+	for idx, desc := range descs {
+		if !desc.ranged() {
+			derivations[desc.Address] = types.Derivation{
+				Account: account.ID(),
+				Label:   account.Name(),
+				Chain:   derivationChains[idx],
+			}
+			continue
+		}
+
+		result, err := rawRequest(context.Background(), client, "deriveaddresses", desc.Value, []int{0, desc.Depth})
+		if err != nil {
+			return fmt.Errorf("%s (%s): %w", ErrDeriveAddress, redact.Descriptor(desc.Value), err)
+		}
+
+		var addresses []string
+		if err := json.Unmarshal(result, &addresses); err != nil {
+			return err
+		}
+
+		for index, address := range addresses {
+			derivations[address] = types.Derivation{
+				Account: account.ID(),
+				Label:   account.Name(),
+				Chain:   derivationChains[idx],
+				Index:   index,
+			}
+		}
+	}
+
+	b.derivations.set(account.ID(), derivations)
+	return nil
+}
@@ -0,0 +1,173 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/ledgerhq/satstack/redact"
+)
+
+// DescriptorInfo describes a descriptor of a configured account, as
+// imported into the wallet.
+type DescriptorInfo struct {
+	Descriptor string `json:"descriptor"` // canonical form, with checksum
+	Range      [2]int `json:"range"`      // derivation range, inclusive
+	Timestamp  uint32 `json:"timestamp"`  // rescan start, as a UNIX timestamp
+	Birthday   string `json:"birthday"`   // rescan start, in RFC3339 format
+	Present    bool   `json:"present"`    // whether the wallet knows the descriptor
+
+	// Address is the single address of the descriptor, if it has no
+	// wildcard, in which case Range is [0, 0].
+	Address string `json:"address,omitempty"`
+
+	// PartialHistory indicates that the rescan starts after the birthday of
+	// the account, since the blocks before are pruned.
+	PartialHistory bool `json:"partial_history,omitempty"`
+}
+
+// AccountDescriptors describes the descriptors of a configured account.
+type AccountDescriptors struct {
+	Label    string          `json:"label"` // see config.Account.Name
+	External DescriptorInfo  `json:"external"`
+	Internal *DescriptorInfo `json:"internal,omitempty"` // omitted for single addresses, or without internal descriptor
+
+	// Address is the single address watched by the account, if configured
+	// instead of descriptors.
+	Address string `json:"address,omitempty"`
+
+	// Multipath is the multipath descriptor from which the external and
+	// internal descriptors were expanded, if any, as configured.
+	Multipath string `json:"multipath,omitempty"`
+}
+
+// ListDescriptors returns the descriptors of the configured accounts, and
+// whether they are present in the wallet.
+//
+// Descriptor wallets are checked with the listdescriptors RPC. Legacy
+// wallets are checked by sampling the addresses at both ends of the
+// derivation range with getaddressinfo.
+func (b *Bus) ListDescriptors() ([]AccountDescriptors, error) {
+	client := b.client
+
+	var walletDescs map[string]bool
+	if b.DescriptorWallet {
+		var err error
+		if walletDescs, err = listWalletDescriptors(client); err != nil {
+			return nil, err
+		}
+	}
+
+	accounts := b.configuredAccounts()
+
+	ret := make([]AccountDescriptors, 0, len(accounts))
+	for _, account := range accounts {
+		descs, err := b.descriptors(client, account)
+		if err != nil {
+			return nil, err // return bare error, since it already has a ctx
+		}
+
+		infos := make([]DescriptorInfo, len(descs))
+		for idx, desc := range descs {
+			start := b.scanStart(desc)
+
+			infos[idx] = DescriptorInfo{
+				Descriptor:     desc.Value,
+				Range:          [2]int{0, desc.Depth},
+				Timestamp:      start,
+				Birthday:       formatTimestamp(start),
+				PartialHistory: start > desc.Birthday,
+			}
+
+			if !desc.ranged() && account.Address == nil {
+				infos[idx].Address = desc.Address
+			}
+
+			if walletDescs != nil {
+				infos[idx].Present = walletDescs[descriptorKey(desc.Value)]
+				continue
+			}
+
+			present, err := hasDescriptor(client, desc)
+			if err != nil {
+				return nil, err
+			}
+
+			infos[idx].Present = present
+		}
+
+		accountDescs := AccountDescriptors{
+			Label:    account.Name(),
+			External: infos[0],
+		}
+
+		switch {
+		case account.Address != nil:
+			accountDescs.Address = *account.Address
+		case account.Multipath():
+			accountDescs.Multipath = *account.External
+			accountDescs.Internal = &infos[1]
+		case len(infos) > 1:
+			accountDescs.Internal = &infos[1]
+		}
+
+		ret = append(ret, accountDescs)
+	}
+
+	return ret, nil
+}
+
+// listWalletDescriptors returns the descriptors of a descriptor wallet, keyed
+// as by descriptorKey.
+func listWalletDescriptors(client *rpcClient) (map[string]bool, error) {
+	raw, err := rawRequest(context.Background(), client, "listdescriptors")
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Descriptors []struct {
+			Descriptor string `json:"desc"`
+		} `json:"descriptors"`
+	}
+
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, err
+	}
+
+	ret := make(map[string]bool, len(result.Descriptors))
+	for _, desc := range result.Descriptors {
+		ret[descriptorKey(desc.Descriptor)] = true
+	}
+
+	return ret, nil
+}
+
+// hasDescriptor indicates whether the first and last addresses of the
+// derivation range of the descriptor are known to the wallet, or its single
+// address if not ranged.
+func hasDescriptor(client *rpcClient, desc descriptor) (bool, error) {
+	for _, index := range []int{0, desc.Depth} {
+		address, err := descriptorAddress(client, desc, index)
+		if err != nil {
+			return false, fmt.Errorf("%s (%s - #%d): %w",
+				ErrDeriveAddress, redact.Descriptor(desc.Value), index, err)
+		}
+
+		addressInfo, err := client.GetAddressInfo(context.Background(), *address)
+		if err != nil {
+			return false, fmt.Errorf("%s (%s): %w", ErrAddressInfo, redact.Address(*address), err)
+		}
+
+		if !addressInfo.IsWatchOnly && !addressInfo.IsMine {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func stripChecksum(desc string) string {
+	return strings.Split(desc, "#")[0]
+}
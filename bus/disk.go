@@ -0,0 +1,142 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// diskCheckInterval is the interval at which the worker refreshes the
+	// disk usage of bitcoind.
+	diskCheckInterval = 1 * time.Minute
+
+	// defaultLowDiskThreshold is the free disk space below which a warning
+	// is reported, unless overridden in the config (low_disk_threshold).
+	defaultLowDiskThreshold = 5 << 30 // 5 GiB
+)
+
+// DiskStatus describes the disk usage of bitcoind, as reported in the
+// ExplorerStatus.
+//
+// FreeBytes is only known if the data directory of bitcoind is configured,
+// and on the same host as SatStack.
+type DiskStatus struct {
+	SizeOnDisk      uint64  `json:"size_on_disk"`
+	FreeBytes       *uint64 `json:"free_bytes,omitempty"`
+	PruneTargetSize *uint64 `json:"prune_target_size,omitempty"`
+	LowDiskWarning  bool    `json:"low_disk_warning"`
+}
+
+// diskCache holds the last DiskStatus computed by the worker.
+type diskCache struct {
+	status *DiskStatus
+	mutex  sync.RWMutex
+}
+
+func (c *diskCache) get() *DiskStatus {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.status
+}
+
+func (c *diskCache) set(status *DiskStatus) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.status = status
+}
+
+// watchDisk refreshes the disk usage of bitcoind right away, and then
+// periodically. A warning is logged each time the free disk space drops
+// below the threshold, rather than at every refresh. It blocks until the
+// context is cancelled.
+func watchDisk(ctx context.Context, b *Bus) {
+	low := false
+
+	for {
+		status, err := b.queryDiskStatus(ctx)
+		switch {
+		case err != nil:
+			log.WithFields(log.Fields{
+				"prefix": "worker",
+				"error":  err,
+			}).Debug("Failed to query disk usage")
+		default:
+			if status.LowDiskWarning && !low {
+				log.WithFields(log.Fields{
+					"prefix":     "worker",
+					"datadir":    b.datadir,
+					"free":       *status.FreeBytes,
+					"threshold":  b.lowDiskThreshold,
+					"sizeOnDisk": status.SizeOnDisk,
+				}).Warn("Low disk space in the data directory of bitcoind")
+			} else if !status.LowDiskWarning && low {
+				log.WithFields(log.Fields{
+					"prefix":  "worker",
+					"datadir": b.datadir,
+				}).Info("Disk space in the data directory of bitcoind is back above the threshold")
+			}
+
+			low = status.LowDiskWarning
+			b.disk.set(status)
+		}
+
+		if !sleep(ctx, diskCheckInterval) {
+			return
+		}
+	}
+}
+
+// queryDiskStatus computes the disk usage of bitcoind.
+//
+// The disk space is reported as low if the free space is below the
+// threshold, unless the node prunes blocks automatically and the free space
+// is enough for the blockchain to grow up to the prune target.
+func (b *Bus) queryDiskStatus(ctx context.Context) (*DiskStatus, error) {
+	result, err := rawRequest(ctx, b.client, "getblockchaininfo")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", ErrBitcoindUnreachable, err)
+	}
+
+	var info struct {
+		SizeOnDisk       uint64  `json:"size_on_disk"`
+		Pruned           bool    `json:"pruned"`
+		AutomaticPruning bool    `json:"automatic_pruning"`
+		PruneTargetSize  *uint64 `json:"prune_target_size"`
+	}
+
+	if err := json.Unmarshal(result, &info); err != nil {
+		return nil, fmt.Errorf("%s: %w", ErrBitcoindUnreachable, err)
+	}
+
+	status := &DiskStatus{SizeOnDisk: info.SizeOnDisk}
+	if info.Pruned && info.AutomaticPruning {
+		status.PruneTargetSize = info.PruneTargetSize
+	}
+
+	if b.datadir == "" {
+		return status, nil
+	}
+
+	free, err := freeDiskSpace(b.datadir)
+	if err != nil {
+		return nil, fmt.Errorf("datadir '%s': %w", b.datadir, err)
+	}
+
+	status.FreeBytes = &free
+	status.LowDiskWarning = free < b.lowDiskThreshold
+
+	// The data directory of a pruned node stops growing at the prune target.
+	if target := status.PruneTargetSize; target != nil &&
+		(*target <= status.SizeOnDisk || free >= *target-status.SizeOnDisk) {
+		status.LowDiskWarning = false
+	}
+
+	return status, nil
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package bus
+
+import "syscall"
+
+// freeDiskSpace returns the space available to unprivileged users on the
+// filesystem of the path, in bytes.
+func freeDiskSpace(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,26 @@
+package bus
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var getDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// freeDiskSpace returns the space available to the user on the volume of
+// the path, in bytes.
+func freeDiskSpace(path string) (uint64, error) {
+	pathPtr, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+
+	var free uint64
+	ret, _, err := getDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(pathPtr)),
+		uintptr(unsafe.Pointer(&free)), 0, 0)
+	if ret == 0 {
+		return 0, err
+	}
+
+	return free, nil
+}
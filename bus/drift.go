@@ -0,0 +1,222 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ledgerhq/satstack/redact"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// WalletDrift describes the entries of the wallet that do not belong to the
+// configured accounts, for ex because the wallet is shared with another
+// SatStack configuration, or because accounts were removed from it, as
+// reported in the ExplorerStatus.
+//
+// Descriptor wallets are reconciled by descriptor, and legacy wallets by
+// address, since they do not record the descriptors that were imported.
+type WalletDrift struct {
+	StaleDescriptors int `json:"stale_descriptors,omitempty"`
+	StaleAddresses   int `json:"stale_addresses,omitempty"`
+}
+
+// driftCache holds the WalletDrift found at startup, if any.
+type driftCache struct {
+	drift *WalletDrift
+	mutex sync.RWMutex
+}
+
+func (c *driftCache) get() *WalletDrift {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.drift
+}
+
+func (c *driftCache) set(drift *WalletDrift) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.drift = drift
+}
+
+// checkDrift reconciles the wallet with the configured accounts. A failure is
+// logged, and does not prevent the accounts from being served.
+func (b *Bus) checkDrift() {
+	if err := b.reconcileWallet(b.client); err != nil {
+		log.WithFields(log.Fields{
+			"prefix": "drift",
+			"error":  err,
+		}).Warn("Failed to reconcile the wallet with the configured accounts")
+	}
+}
+
+// reconcileWallet compares the entries of the wallet with the descriptors of
+// the configured accounts, logs the extraneous ones, and records the drift
+// to be reported in the ExplorerStatus. It must be called once the accounts
+// are imported and their derivations indexed.
+//
+// Bitcoin Core has no RPC to remove descriptors, or watch-only scripts, from
+// a wallet, whatever its type. Stale entries can therefore only be pruned by
+// recreating the wallet, which is left to the user: the instructions are
+// printed, and the wallet is never modified. If the wallet has private keys
+// enabled, it is not reconciled at all.
+func (b *Bus) reconcileWallet(client *rpcClient) error {
+	walletInfo, err := getWalletProperties(client)
+	if err != nil {
+		return fmt.Errorf("%s: %w", ErrLoadWallet, err)
+	}
+
+	if walletInfo.PrivateKeysEnabled {
+		log.WithFields(log.Fields{
+			"prefix": "drift",
+			"wallet": b.WalletName,
+		}).Warn("Wallet has private keys enabled, skipping reconciliation")
+		return nil
+	}
+
+	var drift WalletDrift
+	var stale []string
+
+	if b.DescriptorWallet {
+		stale, err = b.staleDescriptors(client)
+		drift.StaleDescriptors = len(stale)
+	} else {
+		stale, err = b.staleAddresses(client)
+		drift.StaleAddresses = len(stale)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if len(stale) == 0 {
+		b.drift.set(nil)
+		return nil
+	}
+
+	b.drift.set(&drift)
+
+	for _, entry := range stale {
+		fields := log.Fields{"prefix": "drift"}
+		if b.DescriptorWallet {
+			fields["descriptor"] = redact.Descriptor(entry)
+		} else {
+			fields["address"] = redact.Address(entry)
+		}
+
+		log.WithFields(fields).Warn("Wallet entry does not belong to any configured account")
+	}
+
+	fields := log.Fields{
+		"prefix":      "drift",
+		"wallet":      b.WalletName,
+		"descriptors": drift.StaleDescriptors,
+		"addresses":   drift.StaleAddresses,
+	}
+
+	instructions := fmt.Sprintf(
+		"stop SatStack, run 'bitcoin-cli -rpcwallet=%[1]s unloadwallet', "+
+			"move the '%[1]s' directory out of the wallets directory of bitcoind, "+
+			"and start SatStack with --force-rescan to recreate the wallet with "+
+			"the configured accounts only", b.WalletName)
+
+	if b.pruneStaleAccounts {
+		log.WithFields(fields).Warnf(
+			"Bitcoin Core cannot remove entries from a %s wallet; to prune them, %s",
+			b.walletType(), instructions)
+		return nil
+	}
+
+	log.WithFields(fields).Warnf(
+		"The wallet holds entries of other accounts, which are also rescanned; "+
+			"to remove them, %s", instructions)
+
+	return nil
+}
+
+// walletType returns the type of the wallet, as named by Bitcoin Core.
+func (b *Bus) walletType() string {
+	if b.DescriptorWallet {
+		return "descriptor"
+	}
+
+	return "legacy"
+}
+
+// staleDescriptors returns the descriptors of the descriptor wallet that are
+// not descriptors of the configured accounts, sorted.
+func (b *Bus) staleDescriptors(client *rpcClient) ([]string, error) {
+	walletDescs, err := listWalletDescriptors(client)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, account := range b.configuredAccounts() {
+		descs, err := b.descriptors(client, account)
+		if err != nil {
+			return nil, err // return bare error, since it already has a ctx
+		}
+
+		for _, desc := range descs {
+			delete(walletDescs, descriptorKey(desc.Value))
+		}
+	}
+
+	ret := make([]string, 0, len(walletDescs))
+	for desc := range walletDescs {
+		ret = append(ret, desc)
+	}
+
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// staleAddresses returns the addresses of the legacy wallet that are not
+// derived from the descriptors of the configured accounts, sorted.
+func (b *Bus) staleAddresses(client *rpcClient) ([]string, error) {
+	result, err := rawRequest(context.Background(), client, "listreceivedbyaddress",
+		0,    // minconf
+		true, // include_empty
+		true, // include_watchonly
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []struct {
+		Address string `json:"address"`
+	}
+
+	if err := json.Unmarshal(result, &entries); err != nil {
+		return nil, err
+	}
+
+	var ret []string
+	for _, entry := range entries {
+		if _, ok := b.derivations.get(entry.Address); !ok {
+			ret = append(ret, entry.Address)
+		}
+	}
+
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// keyOrigin matches the key origins of a descriptor, for ex [d34db33f/84'/0'/0'].
+var keyOrigin = regexp.MustCompile(`\[[^\]]*\]`)
+
+// descriptorKey returns the descriptor without its checksum, and with the
+// hardened derivation steps of its key origins marked with an apostrophe.
+// Bitcoin Core may report them with an h instead, as in 84h.
+func descriptorKey(desc string) string {
+	return keyOrigin.ReplaceAllStringFunc(stripChecksum(desc), func(origin string) string {
+		return strings.NewReplacer("h", "'", "H", "'").Replace(origin)
+	})
+}
@@ -19,9 +19,15 @@ var (
 	// successful.
 	ErrLoadWallet = errors.New("failed to load wallet")
 
-	// ErrUnsupportedBitcoindVersion indicates that the connected bitcoind node
-	// has a version that is not supported by SatStack.
-	ErrUnsupportedBitcoindVersion = errors.New("unsupported bitcoind version")
+	// ErrWalletPrivateKeys indicates that the wallet used by SatStack is not
+	// a watch-only wallet, and may therefore hold the private keys of the
+	// user.
+	ErrWalletPrivateKeys = errors.New("wallet has private keys enabled")
+
+	// ErrIncompatibleNode indicates that the connected bitcoind node lacks
+	// features required by SatStack, like a supported version, or the
+	// wallet. The error message lists all the problems found.
+	ErrIncompatibleNode = errors.New("incompatible bitcoind node")
 
 	// ErrUnrecognizedChain indicates that the Chain returned by bitcoind in
 	// its response to the getblockchaininfo RPC, is unrecognized by LSS.
@@ -52,6 +58,10 @@ var (
 	// encountered.
 	ErrInvalidDescriptor = errors.New("invalid descriptor")
 
+	// ErrUnsupportedDescriptor indicates that a descriptor is valid, but
+	// cannot be imported in the wallet of the connected node.
+	ErrUnsupportedDescriptor = errors.New("unsupported descriptor")
+
 	// ErrDeriveAddress indicates that an address could not be derived from a
 	// descriptor.
 	ErrDeriveAddress = errors.New("failed to derive address")
@@ -59,4 +69,90 @@ var (
 	// ErrAddressInfo indicates that an error was encountered while trying to
 	// fetch address info.
 	ErrAddressInfo = errors.New("failed to get address info")
+
+	// ErrImportFailed indicates that descriptors could not be imported into
+	// the wallet. The descriptors of the other accounts are imported
+	// nevertheless.
+	ErrImportFailed = errors.New("failed to import descriptors")
+
+	// ErrScanInProgress indicates that an operation was rejected because
+	// descriptors are currently being imported into the wallet.
+	ErrScanInProgress = errors.New("scan in progress")
+
+	// ErrNoScanInProgress indicates that a rescan could not be aborted,
+	// because the wallet is not scanning.
+	ErrNoScanInProgress = errors.New("no scan in progress")
+
+	// ErrInvalidRescan indicates that a rescan was requested from a height
+	// outside the best chain.
+	ErrInvalidRescan = errors.New("invalid rescan")
+
+	// ErrCookieFile indicates that the bitcoind cookie file could not be read.
+	ErrCookieFile = errors.New("failed to read cookie file")
+
+	// ErrTLSCACert indicates that the CA certificate used to verify the TLS
+	// certificate of bitcoind could not be loaded.
+	ErrTLSCACert = errors.New("failed to load CA certificate")
+
+	// ErrTLSVerification indicates that the TLS certificate presented by
+	// bitcoind could not be verified.
+	ErrTLSVerification = errors.New("failed to verify bitcoind TLS certificate")
+
+	// ErrTransactionRejected indicates that a transaction would not be
+	// accepted in the mempool of the node.
+	ErrTransactionRejected = errors.New("transaction rejected")
+
+	// ErrRPCAborted indicates that the wait for an RPC call was aborted,
+	// since it timed out, or the request it was made for was cancelled.
+	ErrRPCAborted = errors.New("bitcoind RPC aborted")
+
+	// ErrTransactionNotFound indicates that a transaction is unknown to the
+	// node.
+	ErrTransactionNotFound = errors.New("transaction not found")
+
+	// ErrTxIndexRequired indicates that a transaction could not be looked
+	// up, since it does not belong to the wallet, and the node has no
+	// transaction index (enabled by option txindex=1), nor enough hints to
+	// find it otherwise.
+	ErrTxIndexRequired = errors.New("transaction index required, set txindex=1 in bitcoin.conf")
+
+	// ErrInvalidLookupAddress indicates that the address given to look up a
+	// transaction is invalid.
+	ErrInvalidLookupAddress = errors.New("invalid address")
+
+	// ErrBlockNotFound indicates that a block is unknown to the node, for
+	// ex a height beyond the chain tip.
+	ErrBlockNotFound = errors.New("block not found")
+
+	// ErrAccountNotFound indicates that an account is not configured, or
+	// its addresses were not derived yet.
+	ErrAccountNotFound = errors.New("account not found")
+
+	// ErrAccountExists indicates that an account added at runtime is already
+	// configured, possibly written differently.
+	ErrAccountExists = errors.New("account already configured")
+
+	// ErrNoAccounts indicates that a request depends on the wallet, while
+	// no accounts are configured.
+	ErrNoAccounts = errors.New("no accounts configured")
+
+	// ErrPartialHistory indicates that the birthday of an account predates
+	// the blocks pruned by bitcoind, which cannot be rescanned.
+	ErrPartialHistory = errors.New("account birthday predates pruned blocks")
+
+	// ErrNotFound, ErrInvalidParameter and ErrRPCFailed are the categories
+	// of the errors returned by bitcoind RPCs, as translated by
+	// ClassifyError; see also ErrBitcoindUnreachable and
+	// ErrTransactionRejected.
+	ErrNotFound         = errors.New("not found")
+	ErrInvalidParameter = errors.New("invalid parameter")
+	ErrRPCFailed        = errors.New("bitcoind RPC failed")
+
+	// ErrInvalidRegtestRequest indicates that a request to mine or fund on
+	// regtest has invalid parameters.
+	ErrInvalidRegtestRequest = errors.New("invalid regtest request")
+
+	// ErrInsufficientFunds indicates that the regtest faucet has no mature
+	// output large enough to fund an address.
+	ErrInsufficientFunds = errors.New("insufficient funds")
 )
@@ -0,0 +1,260 @@
+package bus
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ledgerhq/satstack/types"
+	"github.com/ledgerhq/satstack/utils"
+
+	"github.com/btcsuite/btcd/btcjson"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// EventBlock is the type of events published when the chain tip
+	// changes.
+	EventBlock = "block"
+
+	// EventTransaction is the type of events published when a wallet
+	// transaction enters the mempool, or gets its first confirmation.
+	EventTransaction = "transaction"
+
+	// eventBufferSize indicates the number of events buffered for each
+	// subscriber. Events are dropped for subscribers that fall behind.
+	eventBufferSize = 256
+)
+
+// Event is a notification published to the subscribers of the Bus.
+type Event struct {
+	Type        string            `json:"type"`
+	Block       *types.Block      `json:"block,omitempty"`
+	Transaction *TransactionEvent `json:"transaction,omitempty"`
+}
+
+// TransactionEvent describes a wallet transaction that entered the mempool
+// (zero confirmations), or got its first confirmation.
+type TransactionEvent struct {
+	Hash          string   `json:"hash"`
+	Addresses     []string `json:"addresses"` // wallet addresses involved
+	Confirmations int64    `json:"confirmations"`
+	BlockHash     string   `json:"block_hash,omitempty"`
+}
+
+// subscribers is the set of channels to which events are published.
+type subscribers struct {
+	mu    sync.RWMutex
+	chans map[chan Event]struct{}
+}
+
+// Subscribe returns a channel on which the events are published, and a
+// function to cancel the subscription, which closes the channel.
+//
+// Publishing never blocks: events are dropped if the subscriber does not
+// keep up.
+func (b *Bus) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, eventBufferSize)
+
+	b.subscribers.mu.Lock()
+	if b.subscribers.chans == nil {
+		b.subscribers.chans = make(map[chan Event]struct{})
+	}
+	b.subscribers.chans[ch] = struct{}{}
+	b.subscribers.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			b.subscribers.mu.Lock()
+			delete(b.subscribers.chans, ch)
+			b.subscribers.mu.Unlock()
+
+			close(ch)
+		})
+	}
+
+	return ch, cancel
+}
+
+func (b *Bus) hasSubscribers() bool {
+	b.subscribers.mu.RLock()
+	defer b.subscribers.mu.RUnlock()
+
+	return len(b.subscribers.chans) > 0
+}
+
+func (b *Bus) publish(event Event) {
+	b.subscribers.mu.RLock()
+	defer b.subscribers.mu.RUnlock()
+
+	for ch := range b.subscribers.chans {
+		select {
+		case ch <- event:
+		default:
+			log.WithFields(log.Fields{
+				"prefix": "events",
+				"type":   event.Type,
+			}).Warn("Subscriber is not keeping up, dropped event")
+		}
+	}
+}
+
+// publishBlock publishes a block event for the new chain tip.
+func (b *Bus) publishBlock(tip *chainTip) {
+	if !b.hasSubscribers() {
+		return
+	}
+
+	header, err := b.client.GetBlockHeaderVerbose(context.Background(), tip.Hash)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"prefix": "events",
+			"hash":   tip.Hash.String(),
+			"error":  err,
+		}).Warn("Failed to get block header for event")
+		return
+	}
+
+	b.publish(Event{
+		Type: EventBlock,
+		Block: &types.Block{
+			Hash:   header.Hash,
+			Height: int64(header.Height),
+			Time:   utils.ParseUnixTimestamp(header.Time),
+		},
+	})
+}
+
+// transactionEvents returns the events of the wallet transactions listed by
+// listsinceblock, in the order of the wallet, skipping the conflicted ones,
+// and the unconfirmed ones that were already in the mempool.
+func transactionEvents(txs []btcjson.ListTransactionsResult, mempool map[string]bool) []*TransactionEvent {
+	// listsinceblock returns an entry per address of each transaction.
+	var ret []*TransactionEvent
+	events := make(map[string]*TransactionEvent)
+
+	for _, tx := range txs {
+		if tx.Confirmations < 0 || tx.Confirmations == 0 && mempool[tx.TxID] {
+			continue
+		}
+
+		event, ok := events[tx.TxID]
+		if !ok {
+			event = &TransactionEvent{
+				Hash:          tx.TxID,
+				Confirmations: tx.Confirmations,
+				BlockHash:     tx.BlockHash,
+			}
+			events[tx.TxID] = event
+			ret = append(ret, event)
+		}
+
+		if tx.Address != "" && !utils.Contains(event.Addresses, tx.Address) {
+			event.Addresses = append(event.Addresses, tx.Address)
+		}
+	}
+
+	return ret
+}
+
+// statusSubscribers is the set of channels to which Status updates are
+// published. Only the latest update matters, so each channel buffers a
+// single update, which is replaced if not consumed in time.
+type statusSubscribers struct {
+	mu     sync.Mutex
+	chans  map[chan *ExplorerStatus]struct{}
+	last   *ExplorerStatus
+	closed bool
+}
+
+// SubscribeStatus returns a channel on which the ExplorerStatus is
+// published whenever the Status, the sync progress, or the scan progress
+// changes, and a function to cancel the subscription. The last known
+// ExplorerStatus, if any, is sent right away.
+//
+// The channel is closed when the subscription is cancelled, or when
+// CloseStatusSubscribers is called.
+func (b *Bus) SubscribeStatus() (<-chan *ExplorerStatus, func()) {
+	ch := make(chan *ExplorerStatus, 1)
+
+	b.statusSubscribers.mu.Lock()
+	defer b.statusSubscribers.mu.Unlock()
+
+	if b.statusSubscribers.closed {
+		close(ch)
+		return ch, func() {}
+	}
+
+	if b.statusSubscribers.chans == nil {
+		b.statusSubscribers.chans = make(map[chan *ExplorerStatus]struct{})
+	}
+	b.statusSubscribers.chans[ch] = struct{}{}
+
+	if last := b.statusSubscribers.last; last != nil {
+		ch <- last
+	}
+
+	cancel := func() {
+		b.statusSubscribers.mu.Lock()
+		defer b.statusSubscribers.mu.Unlock()
+
+		if _, ok := b.statusSubscribers.chans[ch]; ok {
+			delete(b.statusSubscribers.chans, ch)
+			close(ch)
+		}
+	}
+
+	return ch, cancel
+}
+
+// CloseStatusSubscribers closes the channels of all the status subscribers,
+// and of those subscribing afterwards. It is used on shutdown, to terminate
+// the streams of status updates.
+func (b *Bus) CloseStatusSubscribers() {
+	b.statusSubscribers.mu.Lock()
+	defer b.statusSubscribers.mu.Unlock()
+
+	b.statusSubscribers.closed = true
+
+	for ch := range b.statusSubscribers.chans {
+		delete(b.statusSubscribers.chans, ch)
+		close(ch)
+	}
+}
+
+// publishStatus publishes the ExplorerStatus to the status subscribers, if
+// it differs from the last one published.
+func (b *Bus) publishStatus(status *ExplorerStatus) {
+	b.statusSubscribers.mu.Lock()
+	defer b.statusSubscribers.mu.Unlock()
+
+	if last := b.statusSubscribers.last; last != nil && !statusChanged(last, status) {
+		return
+	}
+
+	b.statusSubscribers.last = status
+
+	for ch := range b.statusSubscribers.chans {
+		// Replace the pending update, if not consumed yet.
+		select {
+		case <-ch:
+		default:
+		}
+
+		ch <- status
+	}
+}
+
+func statusChanged(a *ExplorerStatus, b *ExplorerStatus) bool {
+	return a.Status != b.Status ||
+		!equalProgress(a.SyncProgress, b.SyncProgress) ||
+		!equalProgress(a.ScanProgress, b.ScanProgress)
+}
+
+func equalProgress(a *float64, b *float64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return *a == *b
+}
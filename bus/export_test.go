@@ -0,0 +1,36 @@
+package bus
+
+import "time"
+
+// The helpers below are exported for the tests of the bus_test package, which
+// drive the middlewares of the httpd packages with a Bus.
+
+// StatusMaxAge is statusMaxAge.
+const StatusMaxAge = statusMaxAge
+
+// NewSyncingTestBus returns a Bus on newSyncedNode, with the number of
+// headers reported by the node set by setHeaders. The node is synced with
+// 10 headers.
+func NewSyncingTestBus() (b *Bus, setHeaders func(headers int64)) {
+	node := newSyncedNode()
+
+	setHeaders = func(headers int64) {
+		node.result("getblockchaininfo", map[string]interface{}{
+			"chain":         "regtest",
+			"blocks":        10,
+			"headers":       headers,
+			"bestblockhash": mockBlockHash('a', 10),
+		})
+	}
+
+	return newTestBus(node), setHeaders
+}
+
+// AgeStatus makes the cached Status older by d, as if the worker had not
+// refreshed it since.
+func (b *Bus) AgeStatus(d time.Duration) {
+	b.statusMutex.Lock()
+	defer b.statusMutex.Unlock()
+
+	b.statusUpdated = b.statusUpdated.Add(-d)
+}
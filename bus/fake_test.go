@@ -0,0 +1,179 @@
+package bus
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// rpcHandler answers an RPC call to fakeNode. An error of type
+// *btcjson.RPCError is returned as the error of the call; any other error
+// fails the whole round trip, like a network error.
+type rpcHandler func(params []json.RawMessage) (interface{}, error)
+
+// fakeNode is an rpcTransport answering RPC calls with handlers, by method,
+// in place of bitcoind.
+type fakeNode struct {
+	// latency is added to every round trip.
+	latency time.Duration
+
+	mu       sync.Mutex
+	handlers map[string]rpcHandler
+	calls    map[string]int
+	trips    int
+}
+
+func newFakeNode() *fakeNode {
+	return &fakeNode{
+		handlers: make(map[string]rpcHandler),
+		calls:    make(map[string]int),
+	}
+}
+
+// handle sets the handler of an RPC method.
+func (n *fakeNode) handle(method string, handler rpcHandler) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.handlers[method] = handler
+}
+
+// result sets a handler of an RPC method always returning result.
+func (n *fakeNode) result(method string, result interface{}) {
+	n.handle(method, func([]json.RawMessage) (interface{}, error) {
+		return result, nil
+	})
+}
+
+// count returns the number of calls made with an RPC method.
+func (n *fakeNode) count(method string) int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.calls[method]
+}
+
+// roundTrips returns the number of round trips made to the node.
+func (n *fakeNode) roundTrips() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.trips
+}
+
+// Send implements rpcTransport.
+func (n *fakeNode) Send(ctx context.Context, requests []*btcjson.Request) ([]*btcjson.Response, error) {
+	if n.latency > 0 {
+		select {
+		case <-time.After(n.latency):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	n.mu.Lock()
+	n.trips++
+	n.mu.Unlock()
+
+	responses := make([]*btcjson.Response, len(requests))
+	for idx, request := range requests {
+		n.mu.Lock()
+		n.calls[request.Method]++
+		handler, ok := n.handlers[request.Method]
+		n.mu.Unlock()
+
+		response := &btcjson.Response{ID: &requests[idx].ID}
+		responses[idx] = response
+
+		if !ok {
+			response.Error = btcjson.NewRPCError(btcjson.ErrRPCMethodNotFound.Code, "Method not found")
+			continue
+		}
+
+		result, err := handler(request.Params)
+		if rpcErr, ok := err.(*btcjson.RPCError); ok {
+			response.Error = rpcErr
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if response.Result, err = json.Marshal(result); err != nil {
+			return nil, err
+		}
+	}
+
+	return responses, nil
+}
+
+// newTestBus creates a Bus on the regtest chain, whose RPC calls are
+// answered by node.
+func newTestBus(node *fakeNode) *Bus {
+	stats := newRPCStats()
+
+	return &Bus{
+		Chain:           "regtest",
+		Currency:        Testnet,
+		Name:            Testnet,
+		WalletName:      defaultWalletName,
+		Params:          &chaincfg.RegressionNetParams,
+		client:          newInstrumentedClient(node, stats),
+		connCfg:         &rpcclient.ConnConfig{Host: "localhost:18443"},
+		rpcStats:        stats,
+		caps:            &Capabilities{},
+		txCache:         newLRUCache("transactions", defaultCacheSize),
+		blockCache:      newLRUCache("blocks", defaultCacheSize),
+		headerCache:     newLRUCache("headers", defaultCacheSize),
+		blockStatsCache: newLRUCache("block stats", defaultCacheSize),
+		cacheTTL:        defaultCacheTTL,
+		batchSize:       defaultBatchSize,
+		rpcTimeout:      defaultRPCTimeout,
+		zmqReset:        make(chan struct{}, 1),
+		pollWake:        make(chan struct{}, 1),
+		tipChanged:      make(chan struct{}, 1),
+		walletIndexSync: make(chan struct{}, 1),
+	}
+}
+
+// param unmarshals the parameter at idx into v, and panics on failure.
+func param(params []json.RawMessage, idx int, v interface{}) {
+	if idx >= len(params) {
+		panic(fmt.Sprintf("missing parameter %d", idx))
+	}
+
+	if err := json.Unmarshal(params[idx], v); err != nil {
+		panic(err)
+	}
+}
+
+// testTx returns a transaction with a single input and output, which are
+// distinct for each seed, along with its hex serialization.
+func testTx(seed uint32) (*wire.MsgTx, string) {
+	tx := wire.NewMsgTx(wire.TxVersion)
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{1}, seed), nil, nil))
+	tx.AddTxOut(wire.NewTxOut(int64(seed)+1000, []byte{0x51}))
+
+	return tx, txHex(tx)
+}
+
+// txHex returns the hex serialization of a transaction.
+func txHex(tx *wire.MsgTx) string {
+	var buf bytes.Buffer
+	if err := tx.Serialize(&buf); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(buf.Bytes())
+}
@@ -0,0 +1,299 @@
+package bus
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/ledgerhq/satstack/utils"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcutil"
+	log "github.com/sirupsen/logrus"
+)
+
+// FeeSource indicates how a fee estimate was obtained. Sources are listed in
+// decreasing order of reliability.
+type FeeSource string
+
+const (
+	// FeeSourceSmartFee indicates estimates from the estimatesmartfee RPC.
+	FeeSourceSmartFee FeeSource = "estimatesmartfee"
+
+	// FeeSourceMempool indicates estimates computed from the contents of the
+	// mempool of the node.
+	FeeSourceMempool FeeSource = "mempool"
+
+	// FeeSourceRelayFee indicates estimates equal to the minimum relay fee.
+	FeeSourceRelayFee FeeSource = "relayfee"
+)
+
+const (
+	// defaultMinRelayFee is the default value of the minrelaytxfee option of
+	// bitcoind, in satoshis per kvB. It is used if the node cannot be
+	// queried for the configured value.
+	defaultMinRelayFee = btcutil.Amount(1000)
+
+	// maxFeeRate is the highest fee rate returned by the estimators, in
+	// satoshis per kvB. It matches the default maxfeerate of bitcoind for
+	// sendrawtransaction, above which transactions are rejected anyway.
+	maxFeeRate = btcutil.Amount(10000000)
+
+	// maxBlockVSize is the maximum virtual size of a block, in vbytes.
+	maxBlockVSize = 1000000
+)
+
+// EstimateFees returns a fee rate estimate in satoshis per kvB for each of
+// the given confirmation targets, along with the least reliable source used
+// for the estimates.
+//
+// The estimatesmartfee RPC is used first. On fresh nodes, or on regtest,
+// it fails until enough blocks have been observed, in which case the
+// estimate is computed from the mempool of the node instead. As a last
+// resort, the minimum relay fee is used.
+//
+// The estimates are never below the minimum relay fee, and never above
+// maxFeeRate.
+func (b *Bus) EstimateFees(ctx context.Context, targets []int64, mode string) (map[int64]btcutil.Amount, FeeSource) {
+	result := make(map[int64]btcutil.Amount, len(targets))
+	source := FeeSourceSmartFee
+
+	var missing []int64
+	for idx, fee := range b.estimateSmartFees(ctx, targets, mode) {
+		if fee == nil {
+			missing = append(missing, targets[idx])
+			continue
+		}
+
+		result[targets[idx]] = clampFeeRate(*fee, 0)
+	}
+
+	if len(missing) == 0 {
+		return result, source
+	}
+
+	minRelayFee := b.minRelayFee(ctx)
+
+	histogram, err := b.mempoolHistogram(ctx)
+	if err != nil {
+		log.WithField("error", err).Warn("Failed to estimate fees from mempool, using relay fee")
+
+		for _, target := range missing {
+			result[target] = minRelayFee
+		}
+
+		return result, FeeSourceRelayFee
+	}
+
+	for _, target := range missing {
+		result[target] = clampFeeRate(histogram.estimate(target), minRelayFee)
+	}
+
+	return result, FeeSourceMempool
+}
+
+// estimateSmartFees invokes the estimatesmartfee RPC for each of the given
+// targets, in a single batch. The result has the same order as targets, with
+// nil entries for failed estimates.
+//
+// Concurrent requests for the same estimates share a single batch.
+func (b *Bus) estimateSmartFees(ctx context.Context, targets []int64, mode string) []*btcutil.Amount {
+	key := fmt.Sprintf("estimatesmartfee:%s:%v", mode, targets)
+
+	fees, err := b.flights.do(ctx, key, func(ctx context.Context) (interface{}, error) {
+		return b.batchEstimateSmartFees(ctx, targets, mode)
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":   err,
+			"targets": targets,
+			"mode":    mode,
+		}).Warn("Failed estimatesmartfee, falling back to mempool")
+
+		return make([]*btcutil.Amount, len(targets))
+	}
+
+	return fees.([]*btcutil.Amount)
+}
+
+func (b *Bus) batchEstimateSmartFees(ctx context.Context, targets []int64, mode string) ([]*btcutil.Amount, error) {
+	ret := make([]*btcutil.Amount, len(targets))
+	if len(targets) == 0 {
+		return ret, nil
+	}
+
+	batch := b.client.NewBatch()
+
+	receivers := make([]func() (*btcjson.EstimateSmartFeeResult, error), len(targets))
+	for idx, target := range targets {
+		receivers[idx] = batch.EstimateSmartFee(target, getMode(mode))
+	}
+
+	if err := b.callRPC(ctx, "batch", batch.Send); err != nil {
+		return nil, err
+	}
+
+	for idx, receive := range receivers {
+		result, err := receive()
+
+		var fee btcutil.Amount
+		if err == nil {
+			fee, err = smartFeeRate(result, targets[idx])
+		}
+
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":  err,
+				"target": targets[idx],
+				"mode":   mode,
+			}).Warn("Failed estimatesmartfee, falling back to mempool")
+			continue
+		}
+
+		ret[idx] = &fee
+	}
+
+	return ret, nil
+}
+
+// EstimateSmartFee returns the fee rate estimate of the estimatesmartfee RPC
+// in satoshis per kvB.
+func (b *Bus) EstimateSmartFee(ctx context.Context, target int64, mode string) (btcutil.Amount, error) {
+	return b.estimateSmartFee(ctx, target, mode)
+}
+
+func (b *Bus) estimateSmartFee(ctx context.Context, target int64, mode string) (btcutil.Amount, error) {
+	var fee *btcjson.EstimateSmartFeeResult
+	err := b.callRPC(ctx, "estimatesmartfee", func(ctx context.Context) (err error) {
+		fee, err = b.client.EstimateSmartFee(ctx, target, getMode(mode))
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return smartFeeRate(fee, target)
+}
+
+// smartFeeRate returns the fee rate of an estimatesmartfee result, in
+// satoshis per kvB.
+func smartFeeRate(fee *btcjson.EstimateSmartFeeResult, target int64) (btcutil.Amount, error) {
+	if len(fee.Errors) > 0 {
+		return 0, fmt.Errorf("%v", fee.Errors)
+	}
+
+	if fee.FeeRate == nil || *fee.FeeRate <= 0 {
+		return 0, fmt.Errorf("no fee rate for target %d", target)
+	}
+
+	return utils.ParseSatoshi(*fee.FeeRate)
+}
+
+// minRelayFee returns the minimum relay fee of the node, in satoshis per kvB.
+func (b *Bus) minRelayFee(ctx context.Context) btcutil.Amount {
+	var info *btcjson.GetNetworkInfoResult
+	err := b.callRPC(ctx, "getnetworkinfo", func(ctx context.Context) (err error) {
+		info, err = b.client.GetNetworkInfo(ctx)
+		return err
+	})
+	if err != nil || info.RelayFee <= 0 {
+		return defaultMinRelayFee
+	}
+
+	fee, err := utils.ParseSatoshi(info.RelayFee)
+	if err != nil {
+		return defaultMinRelayFee
+	}
+
+	return fee
+}
+
+// clampFeeRate bounds the fee rate between floor and maxFeeRate.
+func clampFeeRate(fee btcutil.Amount, floor btcutil.Amount) btcutil.Amount {
+	if fee < floor {
+		return floor
+	}
+
+	if fee > maxFeeRate {
+		return maxFeeRate
+	}
+
+	return fee
+}
+
+// feeHistogram models the transactions in the mempool, sorted by decreasing
+// fee rate.
+type feeHistogram struct {
+	// Minimum fee rate for a transaction to be accepted in the mempool, in
+	// satoshis per kvB.
+	minFee btcutil.Amount
+
+	buckets []feeBucket
+}
+
+type feeBucket struct {
+	feeRate btcutil.Amount // satoshis per kvB
+	vsize   int64          // cumulative virtual size, including this bucket
+}
+
+// estimate returns the fee rate needed for a transaction to be included
+// within the given number of blocks, assuming miners select transactions by
+// fee rate and no new transactions arrive.
+func (h *feeHistogram) estimate(target int64) btcutil.Amount {
+	capacity := target * maxBlockVSize
+
+	for _, bucket := range h.buckets {
+		if bucket.vsize >= capacity {
+			if bucket.feeRate > h.minFee {
+				return bucket.feeRate
+			}
+
+			break
+		}
+	}
+
+	// The mempool clears within the target.
+	return h.minFee
+}
+
+// mempoolHistogram builds a feeHistogram from the getmempoolinfo and
+// getrawmempool RPCs.
+func (b *Bus) mempoolHistogram(ctx context.Context) (*feeHistogram, error) {
+	info, err := b.mempoolInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	mempool, err := b.rawMempool(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	histogram := &feeHistogram{
+		minFee:  info.MempoolMinFee.Amount(),
+		buckets: make([]feeBucket, 0, len(mempool)),
+	}
+
+	for _, entry := range mempool {
+		if entry.VSize <= 0 {
+			continue
+		}
+
+		histogram.buckets = append(histogram.buckets, feeBucket{
+			feeRate: entry.feeRate(),
+			vsize:   entry.VSize,
+		})
+	}
+
+	sort.Slice(histogram.buckets, func(i, j int) bool {
+		return histogram.buckets[i].feeRate > histogram.buckets[j].feeRate
+	})
+
+	var cumulative int64
+	for idx := range histogram.buckets {
+		cumulative += histogram.buckets[idx].vsize
+		histogram.buckets[idx].vsize = cumulative
+	}
+
+	return histogram, nil
+}
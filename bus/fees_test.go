@@ -0,0 +1,88 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/btcsuite/btcutil"
+)
+
+// newFeeNode returns a fakeNode estimating a fee rate of target * 1000
+// satoshis per kvB, except for the targets in missing, for which
+// estimatesmartfee has no data.
+func newFeeNode(missing ...int64) *fakeNode {
+	node := newFakeNode()
+
+	node.handle("estimatesmartfee", func(params []json.RawMessage) (interface{}, error) {
+		var target int64
+		param(params, 0, &target)
+
+		for _, m := range missing {
+			if m == target {
+				return map[string]interface{}{
+					"errors": []string{"Insufficient data or no feerate found"},
+					"blocks": target,
+				}, nil
+			}
+		}
+
+		return map[string]interface{}{
+			"feerate": btcutil.Amount(target * 1000).ToBTC(),
+			"blocks":  target,
+		}, nil
+	})
+
+	node.result("getnetworkinfo", map[string]interface{}{"relayfee": 0.00001})
+	node.result("getmempoolinfo", map[string]interface{}{"size": 0, "bytes": 0, "mempoolminfee": 0.00002})
+	node.result("getrawmempool", map[string]interface{}{})
+
+	return node
+}
+
+func TestEstimateFeesBatch(t *testing.T) {
+	node := newFeeNode()
+	b := newTestBus(node)
+
+	targets := []int64{1, 2, 3, 6, 12, 144}
+	fees, source := b.EstimateFees(context.Background(), targets, "ECONOMICAL")
+
+	if source != FeeSourceSmartFee {
+		t.Errorf("got source %s, want %s", source, FeeSourceSmartFee)
+	}
+
+	for _, target := range targets {
+		if want := btcutil.Amount(target * 1000); fees[target] != want {
+			t.Errorf("fees[%d] = %d, want %d", target, fees[target], want)
+		}
+	}
+
+	// All the estimates are requested in a single round trip.
+	if got := node.roundTrips(); got != 1 {
+		t.Errorf("got %d round trips, want 1", got)
+	}
+
+	if got := node.count("estimatesmartfee"); got != len(targets) {
+		t.Errorf("got %d estimatesmartfee calls, want %d", got, len(targets))
+	}
+}
+
+func TestEstimateFeesFallback(t *testing.T) {
+	node := newFeeNode(1)
+	b := newTestBus(node)
+
+	fees, source := b.EstimateFees(context.Background(), []int64{1, 6}, "")
+
+	if source != FeeSourceMempool {
+		t.Errorf("got source %s, want %s", source, FeeSourceMempool)
+	}
+
+	// The mempool is empty, so it clears at its minimum fee.
+	if fees[1] != 2000 {
+		t.Errorf("fees[1] = %d, want 2000", fees[1])
+	}
+
+	if fees[6] != 6000 {
+		t.Errorf("fees[6] = %d, want 6000", fees[6])
+	}
+}
@@ -0,0 +1,73 @@
+package bus
+
+import (
+	"context"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	log "github.com/sirupsen/logrus"
+)
+
+// firstSeenRetention is how long the first-seen time of a wallet transaction
+// is kept. By then, it is either confirmed, in which case SatStack falls
+// back to the block time, or expired from the mempool of bitcoind, after
+// two weeks by default.
+const firstSeenRetention = 14 * 24 * time.Hour
+
+// FirstSeen returns the UNIX time, in seconds, at which the wallet
+// transaction was first seen unconfirmed, if it was. Unlike the time of the
+// wallet, it is not reset by rescans.
+//
+// Transactions that were already confirmed when SatStack first listed them
+// have no first-seen time.
+func (b *Bus) FirstSeen(txid string) (int64, bool) {
+	if b.state == nil {
+		return 0, false
+	}
+
+	return b.state.firstSeen(txid)
+}
+
+// recordFirstSeen records the first-seen time of the unconfirmed wallet
+// transactions listed by listsinceblock, and persists it in the State.
+//
+// A transaction that entered the mempool of bitcoind before SatStack saw it,
+// for ex while SatStack was not running, is recorded with the time of its
+// mempool entry.
+func (b *Bus) recordFirstSeen(txs []btcjson.ListTransactionsResult) {
+	if b.state == nil {
+		return
+	}
+
+	now := time.Now()
+
+	seen := make(map[string]int64)
+	for _, tx := range txs {
+		if tx.Confirmations != 0 {
+			continue
+		}
+
+		if _, ok := seen[tx.TxID]; ok {
+			continue
+		}
+
+		if _, ok := b.state.firstSeen(tx.TxID); ok {
+			continue
+		}
+
+		seen[tx.TxID] = now.Unix()
+
+		entry, err := getMempoolEntry(context.Background(), b.client, tx.TxID)
+		if err == nil && entry.Time > 0 && entry.Time < now.Unix() {
+			seen[tx.TxID] = entry.Time
+		}
+	}
+
+	if err := b.state.addFirstSeen(seen, now); err != nil {
+		log.WithFields(log.Fields{
+			"prefix": "index",
+			"path":   b.state.path,
+			"error":  err,
+		}).Warn("Failed to persist first-seen times of transactions")
+	}
+}
@@ -0,0 +1,71 @@
+package bus
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// flightGroup coalesces concurrent lookups with the same key, so that the
+// callers share a single in-flight RPC call and its result, including its
+// error. The zero value is ready to use.
+//
+// It complements the caches of the Bus, which are checked first: a lookup
+// is only performed when the cache misses, and concurrent misses for the
+// same key are then coalesced.
+type flightGroup struct {
+	calls map[string]*flight
+	mutex sync.Mutex
+}
+
+// flight is a lookup in progress, or completed once done is closed.
+type flight struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// do performs the lookup implemented by fn, unless a lookup with the same
+// key is already in flight, in which case its result is returned instead.
+// The key must identify the shape of the result, for ex the verbosity of
+// the RPC, along with the resource looked up.
+//
+// The lookup is shared, so it is not made on behalf of any caller: fn is
+// given a context that is never cancelled, and its RPC calls are bounded by
+// the timeouts of callRPC only. The wait of each caller is aborted if its
+// own ctx is done, similarly to callRPC; the lookup carries on for the other
+// waiters. The value is shared as well, and must not be modified.
+func (g *flightGroup) do(
+	ctx context.Context, key string, fn func(context.Context) (interface{}, error),
+) (interface{}, error) {
+	g.mutex.Lock()
+
+	if g.calls == nil {
+		g.calls = make(map[string]*flight)
+	}
+
+	call, ok := g.calls[key]
+	if !ok {
+		call = &flight{done: make(chan struct{})}
+		g.calls[key] = call
+
+		go func() {
+			call.value, call.err = fn(context.Background())
+
+			g.mutex.Lock()
+			delete(g.calls, key)
+			g.mutex.Unlock()
+
+			close(call.done)
+		}()
+	}
+
+	g.mutex.Unlock()
+
+	select {
+	case <-call.done:
+		return call.value, call.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%w: %s: %v", ErrRPCAborted, key, ctx.Err())
+	}
+}
@@ -0,0 +1,196 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const flightCallers = 50
+
+// coalesce calls g.do with key from flightCallers goroutines, and returns
+// their results once fn, which is released after all the callers started,
+// has returned.
+func coalesce(g *flightGroup, key string, fn func() (interface{}, error)) ([]interface{}, []error) {
+	release := make(chan struct{})
+
+	values := make([]interface{}, flightCallers)
+	errs := make([]error, flightCallers)
+
+	var started, done sync.WaitGroup
+	started.Add(flightCallers)
+	done.Add(flightCallers)
+
+	for idx := 0; idx < flightCallers; idx++ {
+		go func(idx int) {
+			defer done.Done()
+
+			started.Done()
+			values[idx], errs[idx] = g.do(context.Background(), key, func(context.Context) (interface{}, error) {
+				<-release
+				return fn()
+			})
+		}(idx)
+	}
+
+	// Leave the callers time to join the flight.
+	started.Wait()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	done.Wait()
+	return values, errs
+}
+
+func TestFlightGroupCoalesces(t *testing.T) {
+	var g flightGroup
+	var fetches int32
+
+	value := &struct{ hash string }{"000000000019d6689c085ae165831e93"}
+	values, errs := coalesce(&g, "getblock:1:hash", func() (interface{}, error) {
+		atomic.AddInt32(&fetches, 1)
+		return value, nil
+	})
+
+	if got := atomic.LoadInt32(&fetches); got != 1 {
+		t.Errorf("got %d fetches, want 1", got)
+	}
+
+	for idx := range values {
+		if values[idx] != value || errs[idx] != nil {
+			t.Errorf("caller %d: got %v, %v, want the shared value", idx, values[idx], errs[idx])
+		}
+	}
+}
+
+func TestFlightGroupSharesErrors(t *testing.T) {
+	var g flightGroup
+	var fetches int32
+
+	failure := errors.New("block not found")
+	_, errs := coalesce(&g, "getblock:1:hash", func() (interface{}, error) {
+		atomic.AddInt32(&fetches, 1)
+		return nil, failure
+	})
+
+	if got := atomic.LoadInt32(&fetches); got != 1 {
+		t.Errorf("got %d fetches, want 1", got)
+	}
+
+	for idx, err := range errs {
+		if err != failure {
+			t.Errorf("caller %d: got error %v, want %v", idx, err, failure)
+		}
+	}
+
+	// Failed lookups are not remembered.
+	if _, err := g.do(context.Background(), "getblock:1:hash", func(context.Context) (interface{}, error) {
+		return "found", nil
+	}); err != nil {
+		t.Errorf("got error %v after a failed lookup, want a new lookup", err)
+	}
+}
+
+func TestFlightGroupKeys(t *testing.T) {
+	var g flightGroup
+	var fetches int32
+
+	release := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for _, key := range []string{"getblock:1:hash", "getblock:3:hash"} {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+
+			value, _ := g.do(context.Background(), key, func(context.Context) (interface{}, error) {
+				atomic.AddInt32(&fetches, 1)
+				<-release
+				return key, nil
+			})
+
+			if value != key {
+				t.Errorf("got %v for %s, want the result of its own lookup", value, key)
+			}
+		}(key)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&fetches); got != 2 {
+		t.Errorf("got %d fetches, want one per key", got)
+	}
+}
+
+func TestFlightGroupCancel(t *testing.T) {
+	var g flightGroup
+
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (interface{}, error) {
+		<-release
+
+		// The lookup is not cancelled along with the caller.
+		return "found", ctx.Err()
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := g.do(context.Background(), "key", fn)
+		result <- err
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	if _, err := g.do(ctx, "key", fn); !errors.Is(err, ErrRPCAborted) || !strings.Contains(err.Error(), "key") {
+		t.Errorf("got error %v, want %v", err, ErrRPCAborted)
+	}
+
+	close(release)
+	if err := <-result; err != nil {
+		t.Errorf("got error %v for the other caller, want the result", err)
+	}
+}
+
+func TestGetPrevoutsBlockCoalesced(t *testing.T) {
+	chain := newPrevoutsChain()
+	node := chain.node()
+	node.latency = 50 * time.Millisecond
+	b := newTestBus(node)
+
+	blocks := make([]*PrevoutsBlock, flightCallers)
+
+	var wg sync.WaitGroup
+	for idx := 0; idx < flightCallers; idx++ {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+
+			block, err := b.GetPrevoutsBlock(context.Background(), chain.hash)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			blocks[idx] = block
+		}(idx)
+	}
+
+	wg.Wait()
+
+	if got := node.count("getblock"); got != 1 {
+		t.Errorf("got %d getblock calls, want 1", got)
+	}
+
+	for idx, block := range blocks {
+		if block != blocks[0] {
+			t.Errorf("caller %d: got another block", idx)
+		}
+	}
+}
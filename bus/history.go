@@ -0,0 +1,92 @@
+package bus
+
+import (
+	"sync"
+	"time"
+)
+
+// rescanWindow is the margin that bitcoind subtracts from the timestamp of a
+// descriptor to find the first block to rescan, since block times are not
+// monotonic (TIMESTAMP_WINDOW).
+const rescanWindow = 2 * time.Hour
+
+// historyCache holds the earliest timestamp from which a pruned node is able
+// to rescan, for the prune height at which it was computed.
+type historyCache struct {
+	height    int32
+	timestamp uint32
+	mutex     sync.RWMutex
+}
+
+func (c *historyCache) get(height int32) (uint32, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.timestamp, c.timestamp > 0 && c.height == height
+}
+
+func (c *historyCache) set(height int32, timestamp uint32) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.height = height
+	c.timestamp = timestamp
+}
+
+// historyStart returns the earliest descriptor timestamp from which bitcoind
+// is able to rescan without reading pruned blocks, or 0 if the node is not
+// pruned.
+//
+// The timestamp is that of the first block that is not pruned, plus the
+// rescanWindow, so that bitcoind does not start the rescan below it.
+func (b *Bus) historyStart(client *rpcClient) (uint32, error) {
+	caps := b.Capabilities()
+	if !caps.Pruned {
+		return 0, nil
+	}
+
+	if timestamp, ok := b.history.get(caps.PruneHeight); ok {
+		return timestamp, nil
+	}
+
+	blockTime, err := blockTimeAt(client, int64(caps.PruneHeight))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := uint32(blockTime + int64(rescanWindow/time.Second))
+	b.history.set(caps.PruneHeight, timestamp)
+
+	return timestamp, nil
+}
+
+// scanStart returns the rescan timestamp of the descriptor: the one with
+// which it was imported, as recorded in the State, or else the one with which
+// it is to be imported.
+func (b *Bus) scanStart(desc descriptor) uint32 {
+	if b.state != nil {
+		if timestamp, ok := b.state.importedTimestamp(desc.Value); ok {
+			return timestamp
+		}
+	}
+
+	return desc.Age
+}
+
+// formatTimestamp formats a rescan timestamp in RFC3339 format.
+func formatTimestamp(timestamp uint32) string {
+	return time.Unix(int64(timestamp), 0).UTC().Format(time.RFC3339)
+}
+
+// PartialHistory indicates whether the scan of any account starts after its
+// birthday, because the blocks before were pruned. The balances of such
+// accounts may be incomplete.
+func (b *Bus) PartialHistory() bool {
+	for _, scan := range b.ScanDetails() {
+		if scan.PartialHistory {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,174 @@
+package bus
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ledgerhq/satstack/config"
+)
+
+// prunedBlockTime is the time of the block at the prune height of
+// newPrunedNode.
+const prunedBlockTime = 1609459200 // 2021-01-01
+
+// newPrunedNode returns a fakeNode pruned below height 1000, with an empty
+// wallet, recording the timestamps of the imported descriptors.
+func newPrunedNode(imported *[]uint32) *fakeNode {
+	node := newFakeNode()
+
+	node.result("getblockhash", mockBlockHash('a', 1000))
+	node.result("getblockheader", map[string]interface{}{
+		"hash":   mockBlockHash('a', 1000),
+		"height": 1000,
+		"time":   prunedBlockTime,
+	})
+
+	node.result("deriveaddresses", []string{"bcrt1qtest"})
+	node.result("getaddressinfo", map[string]interface{}{"address": "bcrt1qtest"})
+
+	var mu sync.Mutex
+	node.handle("importdescriptors", func(params []json.RawMessage) (interface{}, error) {
+		var requests []struct {
+			Timestamp uint32 `json:"timestamp"`
+		}
+		param(params, 0, &requests)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		results := make([]importDescriptorsResult, len(requests))
+		for idx, request := range requests {
+			*imported = append(*imported, request.Timestamp)
+			results[idx].Success = true
+		}
+
+		return results, nil
+	})
+
+	return node
+}
+
+// birthdayAccount returns an account born on the given date (YYYY/MM/DD).
+func birthdayAccount(t *testing.T, birthday string) config.Account {
+	var account config.Account
+	err := json.Unmarshal([]byte(`{
+		"external": "wpkh(tpubD6NzVbkrYhZ4XgiXtGrdW5XDAPFCL9h7we1vwNCpn8tGbBcgfVYjXyhWo4E1xkh56hjod1RhGjxbaTLV3X4FyWuejifB9jusQ46QzG87VKp/0/*)",
+		"internal": "wpkh(tpubD6NzVbkrYhZ4XgiXtGrdW5XDAPFCL9h7we1vwNCpn8tGbBcgfVYjXyhWo4E1xkh56hjod1RhGjxbaTLV3X4FyWuejifB9jusQ46QzG87VKp/1/*)",
+		"birthday": "`+birthday+`"
+	}`), &account)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return account
+}
+
+func newPrunedBus(node *fakeNode, allowPartialHistory bool) *Bus {
+	b := newTestBus(node)
+	b.setCapabilities(&Capabilities{Version: 250000, Pruned: true, PruneHeight: 1000})
+	b.DescriptorWallet = true
+	b.allowPartialHistory = allowPartialHistory
+
+	return b
+}
+
+func TestImportPartialHistoryRefused(t *testing.T) {
+	var imported []uint32
+	node := newPrunedNode(&imported)
+	b := newPrunedBus(node, false)
+
+	err := b.importAccounts([]config.Account{birthdayAccount(t, "2020/01/01")}, false)
+	if !errors.Is(err, ErrImportFailed) {
+		t.Fatalf("got error %v, want %v", err, ErrImportFailed)
+	}
+
+	if len(imported) != 0 {
+		t.Errorf("got %d descriptors imported, want none", len(imported))
+	}
+
+	scans := b.ScanDetails()
+	if len(scans) != 1 || !strings.Contains(scans[0].Error, ErrPartialHistory.Error()) || scans[0].Completed {
+		t.Fatalf("got scan details %+v, want the account failed with %v", scans, ErrPartialHistory)
+	}
+
+	if b.PartialHistory() {
+		t.Error("got partial history for an account that was not imported")
+	}
+}
+
+func TestImportPartialHistoryAllowed(t *testing.T) {
+	var imported []uint32
+	node := newPrunedNode(&imported)
+	b := newPrunedBus(node, true)
+
+	if err := b.importAccounts([]config.Account{birthdayAccount(t, "2020/01/01")}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// The descriptors are rescanned from the first block that is not
+	// pruned, plus the rescan window of bitcoind.
+	start := uint32(prunedBlockTime + int64(rescanWindow/time.Second))
+	if len(imported) != 2 || imported[0] != start || imported[1] != start {
+		t.Errorf("got descriptors imported at %v, want both at %d", imported, start)
+	}
+
+	scans := b.ScanDetails()
+	if len(scans) != 1 || !scans[0].PartialHistory || scans[0].ScanStart != formatTimestamp(start) {
+		t.Fatalf("got scan details %+v, want a partial history from %s", scans, formatTimestamp(start))
+	}
+
+	if !b.PartialHistory() || !b.queryStatus().PartialHistory {
+		t.Error("got no partial history in the status")
+	}
+
+	// The time of the first block that is not pruned is looked up once.
+	if err := b.importAccounts([]config.Account{birthdayAccount(t, "2020/01/01")}, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := node.count("getblockheader"); got != 1 {
+		t.Errorf("got %d getblockheader calls, want 1", got)
+	}
+}
+
+func TestImportAfterPrunedBlocks(t *testing.T) {
+	var imported []uint32
+	b := newPrunedBus(newPrunedNode(&imported), false)
+
+	account := birthdayAccount(t, "2022/01/01")
+	if err := b.importAccounts([]config.Account{account}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	birthday := uint32(account.Birthday.Unix())
+	if len(imported) != 2 || imported[0] != birthday {
+		t.Errorf("got descriptors imported at %v, want the birthday %d", imported, birthday)
+	}
+
+	scans := b.ScanDetails()
+	if len(scans) != 1 || scans[0].PartialHistory || scans[0].ScanStart != formatTimestamp(birthday) {
+		t.Errorf("got scan details %+v, want a full history from the birthday", scans)
+	}
+}
+
+func TestImportUnprunedHistory(t *testing.T) {
+	var imported []uint32
+	node := newPrunedNode(&imported)
+
+	b := newTestBus(node)
+	b.setCapabilities(&Capabilities{Version: 250000})
+	b.DescriptorWallet = true
+
+	if err := b.importAccounts([]config.Account{birthdayAccount(t, "2015/01/01")}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if b.PartialHistory() || node.count("getblockhash") != 0 {
+		t.Errorf("got partial history %v, %d getblockhash calls, want neither on an unpruned node",
+			b.PartialHistory(), node.count("getblockhash"))
+	}
+}
@@ -2,16 +2,24 @@ package bus
 
 import (
 	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/btcsuite/btcd/chaincfg"
 
 	"github.com/btcsuite/btcd/btcjson"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/ledgerhq/satstack/config"
 	"github.com/ledgerhq/satstack/utils"
-	"github.com/patrickmn/go-cache"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -20,141 +28,336 @@ const (
 	// import in the Bitcoin wallet.
 	defaultAccountDepth = 1000
 
-	// connPoolSize indicates the number of *rpcclient.Client objects that
-	// are available to use for communicating to the Bitcoin node.
-	//
-	// Set this to the maximum number of concurrent RPC operations that may be
-	// performed on the Bitcoin node.
-	connPoolSize = 2
-
 	// minimumSupportedBitcoindVersion indicates the minimum version that is
 	// supported by SatStack.
 	minSupportedBitcoindVersion = 200000
 
-	// walletName indicates the name of the wallet created by SatStack in
-	// bitcoind's wallet.
-	walletName = "satstack"
+	// minDescriptorWalletVersion indicates the minimum bitcoind version that
+	// supports native descriptor wallets, and the importdescriptors RPC.
+	minDescriptorWalletVersion = 210000
+
+	// minTaprootVersion indicates the minimum bitcoind version that supports
+	// tr() descriptors.
+	minTaprootVersion = 220000
+
+	// minSubmitPackageVersion indicates the minimum bitcoind version that
+	// supports the submitpackage RPC, for package relay.
+	minSubmitPackageVersion = 250000
+
+	// minMultipathVersion indicates the minimum bitcoind version that
+	// supports the import of multipath descriptors, like wpkh(xpub/<0;1>/*).
+	minMultipathVersion = 260000
+
+	// defaultWalletName indicates the name of the wallet created by SatStack
+	// in bitcoind's wallet, unless overridden in the config.
+	defaultWalletName = "satstack"
 
 	errDuplicateWalletLoadMsg = "Duplicate -wallet filename specified."
+
+	// errRPCWalletAlreadyLoaded is the code of the error returned since
+	// bitcoind v22.0.0 when loading a wallet that is already loaded. It is
+	// not defined by btcjson.
+	errRPCWalletAlreadyLoaded btcjson.RPCErrorCode = -35
 )
 
 // Bus represents a transport allowing access to Bitcoin RPC methods.
 //
-// It maintains a pool of btcd rpcclient objects in a buffered channel to allow
-// concurrent invocation of RPC methods.
+// All RPC methods are invoked with a single rpcClient, which is safe for
+// concurrent use.
 type Bus struct {
 	// Informational fields
-	Chain       string
-	Pruned      bool
-	TxIndex     bool
-	BlockFilter bool
-	Currency    Currency // Based on Chain value, for interoperability with libcore
+	Chain    string
+	Currency Currency // Based on Chain value, for interoperability with libcore
 
-	// Thread-safe Bus cache, to query results typically by hash
-	Cache *cache.Cache
+	// Name is the prefix of the routes of the chain, which defaults to the
+	// Currency. It labels the metrics of the Bus.
+	Name string
 
-	// Config to use for creating new connections on-demand.
-	connCfg *rpcclient.ConnConfig
+	// Capabilities of the connected bitcoind node, replaced as a whole when
+	// the Bus reconnects; see Capabilities.
+	caps      *Capabilities
+	capsMutex sync.RWMutex
+
+	// requireTxIndex indicates whether a node without transaction index
+	// must be rejected.
+	requireTxIndex bool
+
+	// WalletName is the name of the bitcoind wallet used by SatStack. All
+	// wallet RPCs are addressed to this wallet.
+	WalletName string
+
+	// DescriptorWallet indicates whether the SatStack wallet is a native
+	// descriptor wallet. If true, descriptors are imported using the
+	// importdescriptors RPC, and importmulti otherwise.
+	DescriptorWallet bool
+
+	// Thread-safe LRU caches of transactions (by txid), blocks (by hash),
+	// and block headers (by height), shared across requests.
+	txCache     *lruCache
+	blockCache  *lruCache
+	headerCache *lruCache
+
+	// Cache of the fee stats of the blocks below the chain tip (by hash).
+	blockStatsCache *lruCache
+
+	// Optional on-disk cache of confirmed transactions (by txid) and block
+	// headers (by hash), backing txCache and headerCache across restarts.
+	// It is nil if disabled.
+	persistent *persistentCache
+
+	// Lookups in flight, shared by concurrent callers on cache misses.
+	flights flightGroup
+
+	// Duration for which unconfirmed transactions are cached.
+	cacheTTL time.Duration
+
+	// Cache of the mempool histogram.
+	mempoolCache mempoolCache
+
+	// Number of RPC calls to send in a single batch request.
+	batchSize int
+
+	// Timeouts of RPC calls made by callRPC, for most calls and for calls
+	// known to be slow. A zero value disables the timeout.
+	rpcTimeout     time.Duration
+	rpcSlowTimeout time.Duration
+
+	// Bounds of the exponential backoff to reconnect to bitcoind.
+	reconnectInterval    time.Duration
+	reconnectMaxInterval time.Duration
+
+	// Data directory of bitcoind, if on the same host, and the free disk
+	// space below which a warning is reported.
+	datadir          string
+	lowDiskThreshold uint64
+
+	// Disk usage of bitcoind, refreshed by the worker.
+	disk diskCache
+
+	// Entries of the wallet that do not belong to the configured accounts,
+	// found at startup, and whether the user opted in to prune them.
+	drift              driftCache
+	pruneStaleAccounts bool
 
-	// Primary RPC client for JSON-RPC requests. This does NOT allow batch
-	// requests.
-	mainClient *rpcclient.Client
+	// Earliest timestamp from which a pruned node is able to rescan, and
+	// whether the user opted in to import accounts born before it.
+	history             historyCache
+	allowPartialHistory bool
 
-	// Secondary RPC client for JSON-RPC requests. Use when mainClient is busy.
-	secondaryClient *rpcclient.Client
+	// Config of the connection to bitcoind; see newConnConfig.
+	connCfg *rpcclient.ConnConfig
+
+	// RPC client for JSON-RPC requests, including batches; see
+	// rpcClient.NewBatch.
+	client *rpcClient
 
-	// RPC client reserved for performing RPC-based cleanups.
-	janitorClient *rpcclient.Client
+	// Stats of the RPC calls made by the client.
+	rpcStats *rpcStats
 
 	// btcd network params
 	Params *chaincfg.Params
 
-	// IsPendingScan is a boolean field to indicate if satstack is currently
-	// waiting for descriptors to be scanned. One such example is when satstack
-	// is "running the numbers".
-	//
-	// This value can be exported for use by other packages to avoid making
-	// explorer requests before satstack is able to serve them.
-	IsPendingScan bool
+	// Cached chain tip, kept fresh by the worker. A nil value means that the
+	// tip has been invalidated, and must be fetched from the node.
+	tip      *chainTip
+	tipMutex sync.RWMutex
+
+	// Last chain tip processed by the tip poller, which unlike tip is never
+	// invalidated. It is used to detect chain reorganizations.
+	lastTip *chainTip
+
+	// zmqActive is set to 1 while block notifications are being received
+	// over ZMQ. Access it atomically.
+	zmqActive int32
+
+	// zmqReset is used to signal the ZMQ subscriber to reconnect.
+	zmqReset chan struct{}
+
+	// Scan progress of each account, keyed by external descriptor. The
+	// scanOrder slice preserves the order in which accounts were submitted.
+	scans     map[string]*AccountScanStatus
+	scanOrder []string
+	scanMutex sync.RWMutex
+
+	// Status computed by the last call to QueryStatus, and the time at which
+	// it was computed, along with the full ExplorerStatus.
+	status        Status
+	statusDetail  string
+	statusUpdated time.Time
+	statusReport  *ExplorerStatus
+	statusMutex   sync.RWMutex
+
+	// Progress of the current wallet rescan, and of the initial block
+	// download, sampled by queryStatus to estimate the time remaining.
+	scanTracker progressTracker
+	syncTracker progressTracker
+
+	// Last advance of the progress of the current wallet rescan, to detect
+	// rescans that are stuck for longer than scanStallTimeout.
+	scanWatchdog     scanWatchdog
+	scanStallTimeout time.Duration
+
+	// Baseline interval at which the chain tip is polled, and the time
+	// until which it is polled faster, in UNIX nanoseconds; see
+	// tipPollInterval. Access fastPollUntil atomically. pollWake cuts the
+	// wait short once polling is sped up, and tipChanged notifies the poller
+	// of a new chain tip; see processTip.
+	pollInterval  time.Duration
+	fastPollUntil int64
+	pollWake      chan struct{}
+	tipChanged    chan struct{}
+
+	// Last result of getnetworkinfo, refreshed by the worker.
+	networkInfo networkInfoCache
+
+	// Accounts currently configured, replaced as a whole when the
+	// configuration is reloaded, and whether their descriptors are pending a
+	// scan; see IsPendingScan. Guarded by accountsMutex.
+	accounts      []config.Account
+	pendingScan   bool
+	accountsMutex sync.RWMutex
+
+	// Derivations of the addresses of the configured accounts, indexed
+	// when they are imported.
+	derivations derivationIndex
+
+	// Subscribers to the events published by the Bus.
+	subscribers subscribers
+
+	// Subscribers to the Status updates published by the worker.
+	statusSubscribers statusSubscribers
+
+	// Index of the wallet transactions, kept in sync by the worker, and
+	// the channel used to request a sync.
+	walletIndex     walletIndex
+	walletIndexSync chan struct{}
+
+	// importing is the number of account imports in progress. Access it
+	// atomically.
+	importing int32
+
+	// Persisted State. It is nil if the configuration was not loaded from a
+	// file, in which case nothing is persisted.
+	state *State
 }
 
 type descriptor struct {
 	Value string
 	Depth int
 	Age   uint32
+
+	// Birthday is the rescan timestamp of the account, as configured. It
+	// is earlier than Age if the blocks after it are pruned, in which case
+	// Age is the earliest timestamp that can be rescanned.
+	Birthday uint32
+
+	// Multipath is the canonical multipath descriptor from which Value was
+	// expanded, if the node is able to import it natively.
+	Multipath string
+
+	// Address is the single address of the descriptor, if it is not
+	// ranged, for ex the address watched by an account configured with a
+	// single address, as an addr() descriptor. Such descriptors have no
+	// Depth.
+	Address string
+
+	// Label is the name of the account of the descriptor; see
+	// config.Account.Name. It is the wallet label of the imported addresses,
+	// where supported.
+	Label string
 }
 
-// New initializes a Bus struct that embeds a btcd RPC client.
-func New(host string, user string, pass string, noTLS bool) (*Bus, error) {
+// partial indicates whether the descriptor is rescanned from a later time
+// than the birthday of the account.
+func (d descriptor) partial() bool {
+	return d.Age > d.Birthday
+}
+
+// ranged indicates whether addresses are derived from the descriptor.
+func (d descriptor) ranged() bool {
+	return d.Address == ""
+}
+
+// New initializes a Bus struct that embeds a btcd RPC client, using the RPC
+// connection settings of the configuration. Cancelling the context aborts
+// the wait for bitcoind to warm up.
+func New(ctx context.Context, configuration *config.Configuration) (*Bus, error) {
 	log.Info("Warming up...")
 
-	// Prepare the connection config to initialize the rpcclient.Client
-	// pool with.
-	connCfg := &rpcclient.ConnConfig{
-		Host:         fmt.Sprintf("%s/wallet/%s", host, walletName),
-		User:         user,
-		Pass:         pass,
-		HTTPPostMode: true,
-		DisableTLS:   noTLS,
-	}
+	walletName := configuredWalletName(configuration)
 
-	// Initialize RPC clients.
-	mainClient, err := rpcclient.New(connCfg, nil)
+	connCfg, caCert, err := newConnConfig(configuration, walletName)
 	if err != nil {
-		return nil, err // error ctx not required
+		return nil, err
 	}
 
-	secondaryClient, err := rpcclient.New(connCfg, nil)
-	if err != nil {
-		return nil, err // error ctx not required
-	}
+	// Initialize the RPC client.
+	stats := newRPCStats()
 
-	janitorClient, err := rpcclient.New(connCfg, nil)
+	client, err := newClient(connCfg, configuredHTTPTimeout(configuration), stats)
 	if err != nil {
 		return nil, err // error ctx not required
 	}
 
-	info, err := mainClient.GetBlockChainInfo()
+	info, err := waitForWarmup(ctx, client)
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+
+		if isCertificateError(err) {
+			return nil, fmt.Errorf("%s (ca: %s): %w", ErrTLSVerification, caCert, err)
+		}
+
 		return nil, fmt.Errorf("%s: %w", ErrBitcoindUnreachable, err)
 	}
 
-	networkInfo, err := mainClient.GetNetworkInfo()
+	networkInfo, err := client.GetNetworkInfo(context.Background())
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", ErrBitcoindUnreachable, err)
 	}
 
-	if v := networkInfo.Version; v < minSupportedBitcoindVersion {
-		return nil, fmt.Errorf("%s: %d", ErrUnsupportedBitcoindVersion, v)
+	caps, err := probeCapabilities(
+		client, info, networkInfo.Version, configuration.RequireTxIndex)
+	if err != nil {
+		return nil, err
 	}
 
-	blockFilter, err := blockFilterEnabled(mainClient, info.BestBlockHash)
-	if err != nil {
-		return nil, fmt.Errorf("%s: %w", ErrFailedToDetectBlockFilter, err)
+	for _, warning := range caps.Warnings {
+		log.Warn(warning)
 	}
 
-	txIndex, err := txIndexEnabled(mainClient)
+	currency, err := CurrencyFromChain(info.Chain)
 	if err != nil {
-		return nil, fmt.Errorf("%s: %w", ErrFailedToDetectTxIndex, err)
+		return nil, err
 	}
 
-	currency, err := CurrencyFromChain(info.Chain)
+	isNewWallet, err := loadOrCreateWallet(
+		client, walletName, networkInfo.Version >= minDescriptorWalletVersion)
 	if err != nil {
 		return nil, err
 	}
 
-	isNewWallet, err := loadOrCreateWallet(mainClient)
+	walletInfo, err := getWalletProperties(client)
 	if err != nil {
+		return nil, fmt.Errorf("%s: %w", ErrLoadWallet, err)
+	}
+
+	if err := checkWatchOnly(walletName, walletInfo); err != nil {
 		return nil, err
 	}
 
+	descriptorWallet := walletInfo.Descriptors
+
 	if isNewWallet {
 		log.WithFields(log.Fields{
-			"wallet": walletName,
+			"wallet":      walletName,
+			"descriptors": descriptorWallet,
 		}).Info("Created new wallet")
 	} else {
 		log.WithFields(log.Fields{
-			"wallet": walletName,
+			"wallet":      walletName,
+			"descriptors": descriptorWallet,
 		}).Info("Loaded existing wallet")
 	}
 
@@ -163,55 +366,299 @@ func New(host string, user string, pass string, noTLS bool) (*Bus, error) {
 		return nil, fmt.Errorf("failed to get chain params: %w", err)
 	}
 
+	batchSize := defaultBatchSize
+	if configuration.BatchSize != nil && *configuration.BatchSize > 0 {
+		batchSize = *configuration.BatchSize
+	}
+
+	reconnectInterval := defaultReconnectInterval
+	if v := configuration.ReconnectInterval; v != nil {
+		reconnectInterval = time.Duration(*v) * time.Second
+	}
+
+	reconnectMaxInterval := defaultReconnectMaxInterval
+	if v := configuration.ReconnectMaxInterval; v != nil {
+		reconnectMaxInterval = time.Duration(*v) * time.Second
+	}
+
+	if reconnectMaxInterval < reconnectInterval {
+		reconnectMaxInterval = reconnectInterval
+	}
+
+	cacheSize := defaultCacheSize
+	if v := configuration.CacheSize; v != nil {
+		cacheSize = *v
+	}
+
+	cacheTTL := defaultCacheTTL
+	if v := configuration.CacheTTL; v != nil {
+		cacheTTL = time.Duration(*v) * time.Second
+	}
+
+	rpcTimeout := defaultRPCTimeout
+	if v := configuration.RPCTimeout; v != nil {
+		rpcTimeout = time.Duration(*v) * time.Second
+	}
+
+	rpcSlowTimeout := defaultRPCSlowTimeout
+	if v := configuration.RPCSlowTimeout; v != nil {
+		rpcSlowTimeout = time.Duration(*v) * time.Second
+	}
+
+	var persistent *persistentCache
+	if v := configuration.DiskCache; v != nil {
+		maxSize := uint64(defaultDiskCacheSize)
+		if v := configuration.DiskCacheSize; v != nil {
+			maxSize = uint64(*v) << 20
+		}
+
+		persistent = newPersistentCache(*v, info.Chain, maxSize)
+	}
+
+	mempoolCacheTTL := defaultMempoolCacheTTL
+	if v := configuration.MempoolCacheTTL; v != nil {
+		mempoolCacheTTL = time.Duration(*v) * time.Second
+	}
+
+	scanStallTimeout := defaultScanStallTimeout
+	if v := configuration.ScanStallTimeout; v != nil {
+		scanStallTimeout = time.Duration(*v) * time.Second
+	}
+
+	pollInterval := defaultPollInterval
+	if info.Chain == "regtest" {
+		pollInterval = regtestPollInterval
+	}
+
+	if v := configuration.PollingInterval; v != nil {
+		pollInterval = time.Duration(*v) * time.Second
+	}
+
+	var datadir string
+	if v := configuration.Datadir; v != nil {
+		datadir = *v
+	}
+
+	lowDiskThreshold := uint64(defaultLowDiskThreshold)
+	if v := configuration.LowDiskThreshold; v != nil {
+		lowDiskThreshold = uint64(*v) << 20
+	}
+
+	name := currency
+	if configuration.Name != nil {
+		name = *configuration.Name
+	}
+
 	b := &Bus{
-		connCfg:         connCfg,
-		mainClient:      mainClient,
-		secondaryClient: secondaryClient,
-		janitorClient:   janitorClient,
-		Pruned:          info.Pruned,
-		Chain:           info.Chain,
-		BlockFilter:     blockFilter,
-		TxIndex:         txIndex,
-		Currency:        currency,
-		Cache:           nil, // Disabled by default
-		Params:          params,
-		IsPendingScan:   true,
+		connCfg:          connCfg,
+		client:           client,
+		rpcStats:         stats,
+		Chain:            info.Chain,
+		Currency:         currency,
+		Name:             name,
+		WalletName:       walletName,
+		DescriptorWallet: descriptorWallet,
+		requireTxIndex:   configuration.RequireTxIndex,
+		txCache:          newLRUCache("transactions", cacheSize),
+		blockCache:       newLRUCache("blocks", cacheSize),
+		headerCache:      newLRUCache("headers", cacheSize),
+		blockStatsCache:  newLRUCache("block stats", cacheSize),
+		persistent:       persistent,
+		cacheTTL:         cacheTTL,
+		mempoolCache:     mempoolCache{ttl: mempoolCacheTTL},
+		batchSize:        batchSize,
+		rpcTimeout:       rpcTimeout,
+		rpcSlowTimeout:   rpcSlowTimeout,
+		Params:           params,
+		pendingScan:      true,
+		zmqReset:         make(chan struct{}, 1),
+		pollWake:         make(chan struct{}, 1),
+		tipChanged:       make(chan struct{}, 1),
+		walletIndexSync:  make(chan struct{}, 1),
+
+		reconnectInterval:    reconnectInterval,
+		reconnectMaxInterval: reconnectMaxInterval,
+		scanStallTimeout:     scanStallTimeout,
+		pollInterval:         pollInterval,
+		datadir:              datadir,
+		lowDiskThreshold:     lowDiskThreshold,
+		pruneStaleAccounts:   configuration.PruneStaleAccounts,
+		allowPartialHistory:  configuration.AllowPartialHistory,
 	}
 
+	b.setCapabilities(caps)
+
 	return b, nil
 }
 
-// Close performs cleanup operations on the Bus, notably shutting down the
-// rpcclient.Client connections.
+// newConnConfig prepares the config to initialize the rpcClient with, using
+// the RPC connection settings of the configuration. Wallet RPCs are addressed
+// to the wallet with the given name.
+//
+// It also returns a description of the CA certificate used to verify the
+// TLS certificate of bitcoind, for error messages.
+func newConnConfig(configuration *config.Configuration, walletName string) (*rpcclient.ConnConfig, string, error) {
+	// Prepare the connection config to initialize the rpcClient with.
+	connCfg := &rpcclient.ConnConfig{
+		// Wallet RPCs are addressed to the SatStack wallet, even if other
+		// wallets are loaded in bitcoind.
+		Host:         fmt.Sprintf("%s/wallet/%s", configuration.RPCHost(), url.PathEscape(walletName)),
+		HTTPPostMode: true,
+		DisableTLS:   !configuration.UseTLS(),
+
+		// All RPC connections go through the proxy, if any. This does not
+		// affect the HTTP server of SatStack.
+		Proxy: configuration.RPCProxy(),
+	}
+
+	if connCfg.Proxy != "" {
+		// Only log the host, since the URL may include credentials.
+		if proxyURL, err := url.Parse(connCfg.Proxy); err == nil {
+			log.WithField("proxy", proxyURL.Host).Info("Connecting to bitcoind through proxy")
+		}
+	}
+
+	// Without a CA certificate, the system roots are used.
+	caCert := "system roots"
+	if configuration.TLSCACert != nil {
+		caCert = *configuration.TLSCACert
+
+		pem, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, "", fmt.Errorf("%s (%s): %w", ErrTLSCACert, caCert, err)
+		}
+
+		if !x509.NewCertPool().AppendCertsFromPEM(pem) {
+			return nil, "", fmt.Errorf("%s (%s): no PEM certificate found", ErrTLSCACert, caCert)
+		}
+
+		connCfg.Certificates = pem
+	}
+
+	if configuration.UseCookie() {
+		cookiePath, err := configuration.CookieFile()
+		if err != nil {
+			return nil, "", fmt.Errorf("%s: %w", ErrCookieFile, err)
+		}
+
+		// Fail early with a clear error, rather than on the first RPC call.
+		if _, err := ioutil.ReadFile(cookiePath); err != nil {
+			return nil, "", fmt.Errorf("%s: %w", ErrCookieFile, err)
+		}
+
+		log.WithField("path", cookiePath).Info("Using cookie authentication")
+		connCfg.CookiePath = cookiePath
+	} else {
+		connCfg.User = *configuration.RPCUser
+		connCfg.Pass = *configuration.RPCPassword
+	}
+
+	return connCfg, caCert, nil
+}
+
+// configuredHTTPTimeout returns the timeout of the HTTP requests to
+// bitcoind, as set in the configuration. It is disabled by default, since
+// rescans and imports block until the wallet has scanned the chain.
+func configuredHTTPTimeout(configuration *config.Configuration) time.Duration {
+	if v := configuration.RPCHTTPTimeout; v != nil {
+		return time.Duration(*v) * time.Second
+	}
+
+	return 0
+}
+
+// configuredWalletName returns the name of the SatStack wallet, as set in
+// the configuration.
+func configuredWalletName(configuration *config.Configuration) string {
+	if configuration.WalletName != nil {
+		return *configuration.WalletName
+	}
+
+	return defaultWalletName
+}
+
+// Close performs cleanup operations on the Bus, notably aborting any wallet
+// rescan in progress, unloading the wallet, closing the connections to
+// bitcoind, and closing the persistent cache.
 //
 // The cleanup must be performed within a timeout set by the passed context,
-// to prevent hanging on connections indefinitely held by bitcoind.
-func (b *Bus) Close(ctx context.Context) {
+// to prevent hanging on connections indefinitely held by bitcoind. If the
+// timeout expires, the remaining connections are dropped and the context
+// error is returned.
+func (b *Bus) Close(ctx context.Context) error {
 	done := make(chan bool)
 
 	go func() {
-		b.mainClient.Shutdown()
-		b.secondaryClient.Shutdown()
+		// The persistent cache does not depend on bitcoind, and is closed
+		// first, in case the connections hang.
+		b.persistent.Close()
 
+		b.AbortRescan()
 		b.UnloadWallet()
+
+		b.client.Shutdown()
 		done <- true
 	}()
 
 	select {
 	case <-ctx.Done():
 		// Chernobyl nuclear disaster.
-
-		b.janitorClient.Shutdown()
-		log.WithField("error", ctx.Err()).Fatal("Shutdown server: force")
+		return ctx.Err()
 	case <-done:
 		// The control rods have been lowered into the nuclear core, and the
 		// chain reaction has gracefully stopped.
+		return nil
+	}
+}
+
+// AbortRescan stops the wallet rescan in progress, if any, so that the
+// wallet is not left scanning after SatStack exits. The descriptors being
+// imported are not recorded in the persisted State, and will be imported
+// again on the next startup.
+func (b *Bus) AbortRescan() {
+	walletInfo, err := b.client.GetWalletInfo(context.Background())
+	if err != nil {
+		log.WithField("error", err).Warn("Unable to query wallet rescan")
+		return
 	}
 
+	if _, ok := walletInfo.Scanning.Value.(btcjson.ScanProgress); !ok {
+		return
+	}
+
+	if _, err := rawRequest(context.Background(), b.client, "abortrescan"); err != nil {
+		log.WithField("error", err).Warn("Unable to abort wallet rescan")
+		return
+	}
+
+	log.WithField("wallet", b.WalletName).Info("Aborted wallet rescan")
+}
+
+// Client returns the RPC client of the Bus, shared by all callers.
+func (b *Bus) Client() *rpcClient {
+	return b.client
+}
+
+// isCertificateError returns true if the error was caused by the failure to
+// verify a TLS certificate.
+func isCertificateError(err error) bool {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+
+	return errors.As(err, &unknownAuthority) ||
+		errors.As(err, &hostname) ||
+		errors.As(err, &invalid)
 }
 
-func (b *Bus) ClientFactory() (*rpcclient.Client, error) {
-	return rpcclient.New(b.connCfg, nil)
+// isConnectionError returns true if the error was caused by a failure to
+// reach bitcoind (for ex, through a proxy), rather than by an RPC error. Such
+// errors are usually transient.
+func isConnectionError(err error) bool {
+	var urlErr *url.Error
+	var netErr net.Error
+
+	return errors.As(err, &urlErr) || errors.As(err, &netErr)
 }
 
 // Currency represents the currency type (btc) and the network params
@@ -221,6 +668,7 @@ type Currency = string
 const (
 	Testnet Currency = "btc_testnet"
 	Mainnet Currency = "btc"
+	Signet  Currency = "bitcoin_signet"
 )
 
 // currencyFromChain is an adapter function to convert a chain (network) value
@@ -229,6 +677,8 @@ func CurrencyFromChain(chain string) (Currency, error) {
 	switch chain {
 	case "regtest", "test":
 		return Testnet, nil
+	case "signet":
+		return Signet, nil
 	case "main":
 		return Mainnet, nil
 	default:
@@ -241,11 +691,14 @@ func CurrencyFromChain(chain string) (Currency, error) {
 //
 // This value is useful for several operations in btcd, and can be accessed
 // via the Bus struct.
+//
+// Signet shares the address encoding of testnet3 (base58 version bytes and
+// the "tb" bech32 prefix), so the testnet3 params are used for it as well.
 func ChainParams(chain string) (*chaincfg.Params, error) {
 	switch chain {
 	case "regtest":
 		return &chaincfg.RegressionNetParams, nil
-	case "test":
+	case "test", "signet":
 		return &chaincfg.TestNet3Params, nil
 	case "main":
 		return &chaincfg.MainNetParams, nil
@@ -254,8 +707,27 @@ func ChainParams(chain string) (*chaincfg.Params, error) {
 	}
 }
 
-// loadOrCreateWallet attempts to load the default SatStack wallet, and if not
-// found, creates the same.
+// waitForWarmup performs the getblockchaininfo RPC, retrying while bitcoind
+// is warming up, for ex right after it was started, until the context is
+// cancelled.
+func waitForWarmup(ctx context.Context, client *rpcClient) (*btcjson.GetBlockChainInfoResult, error) {
+	for {
+		info, err := client.GetBlockChainInfo(ctx)
+
+		message, ok := warmupMessage(err)
+		if !ok {
+			return info, err
+		}
+
+		log.WithField("detail", message).Info("Waiting for bitcoind to warm up")
+		if !sleep(ctx, warmupRetryInterval) {
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// loadOrCreateWallet attempts to load the SatStack wallet with the given name,
+// and if not found, creates the same.
 //
 // This method also detects if wallet features have been disabled in the
 // Bitcoin node, and returns an error in such a case. This is typically the
@@ -264,10 +736,12 @@ func ChainParams(chain string) (*chaincfg.Params, error) {
 // The function returns a bool to indicate whether the wallet was created
 // (true) or loaded (false). The value is meaningless if an error is returned.
 //
-// In case a new wallet is created, it'll be in loaded state by default.
-func loadOrCreateWallet(client *rpcclient.Client) (bool, error) {
+// In case a new wallet is created, it'll be in loaded state by default. If
+// descriptors is true, the new wallet is a blank native descriptor wallet;
+// otherwise, it is a legacy wallet.
+func loadOrCreateWallet(client *rpcClient, walletName string, descriptors bool) (bool, error) {
 	// Try to load wallet first.
-	_, err := client.LoadWallet(walletName)
+	_, err := client.LoadWallet(context.Background(), walletName)
 	if err == nil {
 		return false, nil
 	}
@@ -280,14 +754,32 @@ func loadOrCreateWallet(client *rpcclient.Client) (bool, error) {
 		return false, ErrWalletDisabled
 	}
 
-	if rpcErr.Code == btcjson.ErrRPCWallet && strings.Contains(rpcErr.Message, errDuplicateWalletLoadMsg) {
+	if rpcErr.Code == errRPCWalletAlreadyLoaded ||
+		(rpcErr.Code == btcjson.ErrRPCWallet && strings.Contains(rpcErr.Message, errDuplicateWalletLoadMsg)) {
 		// wallet already loaded. Ignore the error and return.
 		return false, nil
 	}
 
 	// Wallet to load could not be found - create it.
+	if rpcErr.Code == btcjson.ErrRPCWalletNotFound && descriptors {
+		// The createwallet command of rpcclient does not support the
+		// descriptors argument.
+		if _, err := rawRequest(context.Background(), client, "createwallet",
+			walletName, // wallet_name
+			true,       // disable_private_keys
+			true,       // blank
+			"",         // passphrase
+			false,      // avoid_reuse
+			true,       // descriptors
+		); err != nil {
+			return false, fmt.Errorf("%s: %w", ErrCreateWallet, err)
+		}
+
+		return true, nil
+	}
+
 	if rpcErr.Code == btcjson.ErrRPCWalletNotFound {
-		if _, err := client.CreateWallet(
+		if _, err := client.CreateWallet(context.Background(),
 			walletName,
 			rpcclient.WithCreateWalletDisablePrivateKeys(),
 		); err != nil {
@@ -300,6 +792,65 @@ func loadOrCreateWallet(client *rpcclient.Client) (bool, error) {
 	return false, fmt.Errorf("%s: %w", ErrLoadWallet, rpcErr)
 }
 
+// walletProperties models the fields of the getwalletinfo RPC that are not
+// supported by rpcclient.
+type walletProperties struct {
+	// Descriptors indicates whether the wallet is a native descriptor
+	// wallet. The field is absent on nodes that predate descriptor wallets.
+	Descriptors bool `json:"descriptors"`
+
+	// PrivateKeysEnabled indicates whether the wallet may hold private keys.
+	PrivateKeysEnabled bool `json:"private_keys_enabled"`
+}
+
+// getWalletProperties returns the properties of the loaded wallet, as
+// reported by the getwalletinfo RPC.
+func getWalletProperties(client *rpcClient) (*walletProperties, error) {
+	result, err := rawRequest(context.Background(), client, "getwalletinfo")
+	if err != nil {
+		return nil, err
+	}
+
+	var info walletProperties
+	if err := json.Unmarshal(result, &info); err != nil {
+		return nil, err
+	}
+
+	return &info, nil
+}
+
+// checkWatchOnly returns an error if the wallet has private keys enabled.
+//
+// SatStack only needs a watch-only wallet. Importing descriptors into a
+// wallet holding private keys would mix them with the funds of the user.
+func checkWatchOnly(walletName string, info *walletProperties) error {
+	if info.PrivateKeysEnabled {
+		return fmt.Errorf("%w: '%s', set wallet_name to the name of another wallet",
+			ErrWalletPrivateKeys, walletName)
+	}
+
+	return nil
+}
+
+// Capabilities returns the capabilities of the connected node, as detected
+// by the last capability probe. The result is shared, and must not be
+// modified.
+func (b *Bus) Capabilities() *Capabilities {
+	b.capsMutex.RLock()
+	defer b.capsMutex.RUnlock()
+
+	return b.caps
+}
+
+// setCapabilities replaces the capabilities of the Bus with the result of
+// the capability probe, which must not be modified afterwards.
+func (b *Bus) setCapabilities(caps *Capabilities) {
+	b.capsMutex.Lock()
+	defer b.capsMutex.Unlock()
+
+	b.caps = caps
+}
+
 // txIndexEnabled can be used to detect if the bitcoind server being connected
 // has a transaction index (enabled by option txindex=1).
 //
@@ -309,13 +860,13 @@ func loadOrCreateWallet(client *rpcclient.Client) (bool, error) {
 //
 // If an irrecoverable error is encountered, it returns an error. In such
 // cases, the caller may stop program execution.
-func txIndexEnabled(client *rpcclient.Client) (bool, error) {
-	blockHash, err := client.GetBlockHash(1)
+func txIndexEnabled(client *rpcClient) (bool, error) {
+	blockHash, err := client.GetBlockHash(context.Background(), 1)
 	if err != nil {
 		return false, ErrFailedToGetBlock
 	}
 
-	block, err := client.GetBlockVerbose(blockHash)
+	block, err := client.GetBlockVerbose(context.Background(), blockHash)
 	if err != nil {
 		return false, ErrFailedToGetBlock
 	}
@@ -331,7 +882,7 @@ func txIndexEnabled(client *rpcclient.Client) (bool, error) {
 			"%s (%s): %w", ErrMalformedChainHash, block.Tx[0], err)
 	}
 
-	if _, err := client.GetRawTransaction(tx); err != nil {
+	if _, err := client.GetRawTransaction(context.Background(), tx); err != nil {
 		return false, nil
 	}
 
@@ -349,13 +900,13 @@ func txIndexEnabled(client *rpcclient.Client) (bool, error) {
 // If this function returns true, blockchain rescans will be significantly
 // faster, as bitcoind can avoid iterating through every transaction in every
 // block.
-func blockFilterEnabled(client *rpcclient.Client, hash string) (bool, error) {
+func blockFilterEnabled(client *rpcClient, hash string) (bool, error) {
 	chainHash, err := chainhash.NewHashFromStr(hash)
 	if err != nil {
 		return false, err
 	}
 
-	if _, err := client.GetBlockFilter(*chainHash, nil); err != nil {
+	if _, err := client.GetBlockFilter(context.Background(), *chainHash, nil); err != nil {
 		return false, nil
 	}
 
@@ -363,17 +914,15 @@ func blockFilterEnabled(client *rpcclient.Client, hash string) (bool, error) {
 }
 
 func (b *Bus) UnloadWallet() {
-	if err := b.janitorClient.UnloadWallet(nil); err != nil {
+	if err := b.client.UnloadWallet(context.Background(), nil); err != nil {
 		log.WithFields(log.Fields{
-			"wallet": walletName,
+			"wallet": b.WalletName,
 			"error":  err,
 		}).Warn("Unable to unload wallet")
 		return
 	}
 
 	log.WithFields(log.Fields{
-		"wallet": walletName,
+		"wallet": b.WalletName,
 	}).Info("Unloaded wallet successfully")
-
-	b.janitorClient.Shutdown()
 }
@@ -0,0 +1,59 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil"
+)
+
+func TestChainMapping(t *testing.T) {
+	tests := []struct {
+		chain    string
+		currency Currency
+		params   *chaincfg.Params
+		address  string // valid on the chain
+	}{
+		{"main", Mainnet, &chaincfg.MainNetParams, "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"},
+		{"test", Testnet, &chaincfg.TestNet3Params, "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx"},
+		{"signet", Signet, &chaincfg.TestNet3Params, "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx"},
+		{"regtest", Testnet, &chaincfg.RegressionNetParams, "bcrt1qw508d6qejxtdg4y5r3zarvary0c5xw7kygt080"},
+	}
+
+	for _, test := range tests {
+		node := newFakeNode()
+		node.result("getblockchaininfo", map[string]interface{}{"chain": test.chain})
+
+		info, err := newTestBus(node).client.GetBlockChainInfo(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		currency, err := CurrencyFromChain(info.Chain)
+		if err != nil || currency != test.currency {
+			t.Errorf("%s: got currency %q, %v, want %q", test.chain, currency, err, test.currency)
+		}
+
+		params, err := ChainParams(info.Chain)
+		if err != nil || params != test.params {
+			t.Errorf("%s: got params %v, %v, want %s", test.chain, params, err, test.params.Name)
+			continue
+		}
+
+		if _, err := btcutil.DecodeAddress(test.address, params); err != nil {
+			t.Errorf("%s: address %s rejected: %v", test.chain, test.address, err)
+		}
+	}
+}
+
+func TestChainMappingUnknown(t *testing.T) {
+	if _, err := CurrencyFromChain("testnet4"); !errors.Is(err, ErrUnrecognizedChain) {
+		t.Errorf("got error %v, want %v", err, ErrUnrecognizedChain)
+	}
+
+	if _, err := ChainParams("testnet4"); !errors.Is(err, ErrUnrecognizedChain) {
+		t.Errorf("got error %v, want %v", err, ErrUnrecognizedChain)
+	}
+}
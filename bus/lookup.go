@@ -0,0 +1,236 @@
+package bus
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/ledgerhq/satstack/protocol"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+const (
+	// DefaultLookupWindow is the number of recent blocks searched with the
+	// block filters for a transaction paying or spending an address, unless
+	// specified in the TransactionHint.
+	DefaultLookupWindow = 144
+
+	// MaxLookupWindow bounds the number of blocks searched with the block
+	// filters, since each one requires RPC calls.
+	MaxLookupWindow = 2016
+)
+
+// Strategies to look up transactions that do not belong to the wallet, as
+// reported in ExplorerStatus.
+const (
+	LookupWallet      = "wallet"       // transactions of the wallet
+	LookupMempool     = "mempool"      // unconfirmed transactions
+	LookupTxIndex     = "txindex"      // any transaction, with the transaction index
+	LookupBlockHash   = "block_hash"   // transactions of a given block
+	LookupBlockFilter = "block_filter" // transactions of an address in recent blocks
+)
+
+// TransactionHint helps to find a transaction that does not belong to the
+// wallet, when bitcoind has no transaction index.
+type TransactionHint struct {
+	// BlockHash is the hash of the block containing the transaction.
+	BlockHash *chainhash.Hash
+
+	// Address is paid or spent by the transaction. The recent blocks
+	// possibly containing a transaction of the address are found with
+	// the block filters.
+	Address string
+
+	// Window is the number of recent blocks searched with Address. If 0,
+	// DefaultLookupWindow is used.
+	Window int
+}
+
+// LookupStrategies returns the strategies available on the connected node
+// to look up transactions.
+func (b *Bus) LookupStrategies() []string {
+	ret := []string{LookupWallet, LookupMempool}
+
+	caps := b.Capabilities()
+	if caps.TxIndex {
+		return append(ret, LookupTxIndex)
+	}
+
+	ret = append(ret, LookupBlockHash)
+	if caps.BlockFilter {
+		ret = append(ret, LookupBlockFilter)
+	}
+
+	return ret
+}
+
+// lookupTransactionHex finds a transaction that is neither in the wallet
+// nor in the mempool, on a node without transaction index, using the hint.
+func (b *Bus) lookupTransactionHex(ctx context.Context, hash *chainhash.Hash, hint TransactionHint) (string, error) {
+	switch {
+	case hint.BlockHash != nil:
+		txHex, found, err := b.blockTransactionHex(ctx, hash, hint.BlockHash)
+		if err != nil {
+			return "", err
+		}
+
+		if !found {
+			return "", fmt.Errorf("%w: %s (block: %s)", ErrTransactionNotFound, hash, hint.BlockHash)
+		}
+
+		return txHex, nil
+
+	case hint.Address != "" && !b.Capabilities().BlockFilter:
+		return "", fmt.Errorf("%w: %s, set blockfilterindex=1 in bitcoin.conf to look it up by address",
+			ErrTxIndexRequired, hash)
+
+	case hint.Address != "":
+		return b.filterTransactionHex(ctx, hash, hint)
+
+	default:
+		return "", fmt.Errorf("%w: %s, or specify the block hash or an address of the transaction",
+			ErrTxIndexRequired, hash)
+	}
+}
+
+// filterTransactionHex searches the recent blocks that may contain a
+// transaction of the address of the hint, according to the block filters,
+// for the transaction with the given hash.
+func (b *Bus) filterTransactionHex(ctx context.Context, hash *chainhash.Hash, hint TransactionHint) (string, error) {
+	script, err := b.addressScript(ctx, hint.Address)
+	if err != nil {
+		return "", err
+	}
+
+	window := hint.Window
+	if window == 0 {
+		window = DefaultLookupWindow
+	}
+
+	tip, err := b.GetBestBlockHeight()
+	if err != nil {
+		return "", err
+	}
+
+	for height := tip; height > tip-int64(window) && height >= 0; height-- {
+		if err := ctx.Err(); err != nil {
+			return "", fmt.Errorf("%w: %v", ErrRPCAborted, err)
+		}
+
+		blockHash, err := b.GetBlockHash(ctx, height)
+		if err != nil {
+			return "", err
+		}
+
+		match, err := b.matchBlockFilter(ctx, blockHash, script)
+		if err != nil {
+			return "", err
+		}
+
+		if !match {
+			continue
+		}
+
+		// The block may contain other transactions of the address, or be a
+		// false positive.
+		txHex, found, err := b.blockTransactionHex(ctx, hash, blockHash)
+		if err != nil {
+			return "", err
+		}
+
+		if found {
+			return txHex, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: %s (address: %s, last %d blocks)",
+		ErrTransactionNotFound, hash, hint.Address, window)
+}
+
+// matchBlockFilter returns true if the basic filter of the block may contain
+// the output script.
+func (b *Bus) matchBlockFilter(ctx context.Context, blockHash *chainhash.Hash, script []byte) (bool, error) {
+	var result json.RawMessage
+	err := b.callRPC(ctx, "getblockfilter", func(ctx context.Context) (err error) {
+		result, err = rawRequest(ctx, b.client, "getblockfilter", blockHash.String(), "basic")
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+
+	var filter struct {
+		Filter string `json:"filter"`
+	}
+
+	if err := json.Unmarshal(result, &filter); err != nil {
+		return false, err
+	}
+
+	raw, err := hex.DecodeString(filter.Filter)
+	if err != nil {
+		return false, err
+	}
+
+	return protocol.MatchBasicFilter(raw, blockHash, script)
+}
+
+// blockTransactionHex looks up the transaction in the given block, which
+// does not require a transaction index, unless the block is pruned.
+func (b *Bus) blockTransactionHex(ctx context.Context, hash *chainhash.Hash, blockHash *chainhash.Hash) (string, bool, error) {
+	var result json.RawMessage
+	err := b.callRPC(ctx, "getrawtransaction", func(ctx context.Context) (err error) {
+		result, err = rawRequest(ctx, b.client, "getrawtransaction", hash.String(), false, blockHash.String())
+		return err
+	})
+
+	var rpcErr *btcjson.RPCError
+	switch {
+	case isNotFoundError(err):
+		return "", false, nil
+	case b.Capabilities().Pruned && errors.As(err, &rpcErr) && rpcErr.Code == btcjson.ErrRPCMisc:
+		// Block not available.
+		return "", false, fmt.Errorf("%w: %s, block %s is pruned", ErrTxIndexRequired, hash, blockHash)
+	case err != nil:
+		return "", false, err
+	}
+
+	var txHex string
+	if err := json.Unmarshal(result, &txHex); err != nil {
+		return "", false, err
+	}
+
+	return txHex, true, nil
+}
+
+// addressScript returns the output script of the address, as decoded by
+// bitcoind, which supports every address type.
+func (b *Bus) addressScript(ctx context.Context, address string) ([]byte, error) {
+	var result json.RawMessage
+	err := b.callRPC(ctx, "validateaddress", func(ctx context.Context) (err error) {
+		result, err = rawRequest(ctx, b.client, "validateaddress", address)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var info struct {
+		IsValid      bool   `json:"isvalid"`
+		ScriptPubKey string `json:"scriptPubKey"`
+	}
+
+	if err := json.Unmarshal(result, &info); err != nil {
+		return nil, err
+	}
+
+	if !info.IsValid {
+		return nil, fmt.Errorf("%w: '%s'", ErrInvalidLookupAddress, address)
+	}
+
+	return hex.DecodeString(info.ScriptPubKey)
+}
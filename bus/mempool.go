@@ -0,0 +1,326 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ledgerhq/satstack/types"
+	"github.com/ledgerhq/satstack/utils"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcutil"
+)
+
+// defaultMempoolCacheTTL indicates how long the mempool histogram is cached,
+// unless overridden in the config. Computing it requires the verbose
+// getrawmempool RPC, which is expensive on a busy mempool.
+const defaultMempoolCacheTTL = 30 * time.Second
+
+// mempoolFeeRates are the lower bounds of the buckets of the mempool
+// histogram, in satoshis per vbyte.
+var mempoolFeeRates = []int64{0, 1, 2, 3, 5, 10, 20, 50}
+
+// MempoolEntry models the subset of the getmempoolentry RPC response used by
+// SatStack.
+//
+// The btcjson.GetMempoolEntryResult type does not include the BIP125
+// replaceability flag, so the result is decoded here instead.
+type MempoolEntry struct {
+	VSize           int64 `json:"vsize"`              // virtual size of the transaction
+	Time            int64 `json:"time"`               // time the transaction entered the mempool
+	Replaceable     bool  `json:"bip125-replaceable"` // BIP125 replaceability, inherited from ancestors
+	AncestorCount   int64 `json:"ancestorcount"`      // number of in-mempool ancestors, including itself
+	AncestorSize    int64 `json:"ancestorsize"`       // virtual size of in-mempool ancestors, including itself
+	DescendantCount int64 `json:"descendantcount"`    // number of in-mempool descendants, including itself
+	DescendantSize  int64 `json:"descendantsize"`     // virtual size of in-mempool descendants, including itself
+	Fees            struct {
+		Base       btcValue `json:"base"`       // fee of the transaction (BTC)
+		Ancestor   btcValue `json:"ancestor"`   // fees of in-mempool ancestors, including itself (BTC)
+		Descendant btcValue `json:"descendant"` // fees of in-mempool descendants, including itself (BTC)
+	} `json:"fees"`
+}
+
+// GetMempoolEntry returns the mempool data of the transaction with the given
+// hash. An error is returned if the transaction is not in the mempool, for
+// example if it was confirmed in the meantime.
+func (b *Bus) GetMempoolEntry(ctx context.Context, hash string) (*MempoolEntry, error) {
+	var entry *MempoolEntry
+	err := b.callRPC(ctx, "getmempoolentry", func(ctx context.Context) (err error) {
+		entry, err = getMempoolEntry(ctx, b.client, hash)
+		return err
+	})
+
+	return entry, err
+}
+
+func getMempoolEntry(ctx context.Context, client *rpcClient, hash string) (*MempoolEntry, error) {
+	if _, err := utils.ParseChainHash(hash); err != nil {
+		return nil, err
+	}
+
+	result, err := rawRequest(ctx, client, "getmempoolentry", hash)
+	if err != nil {
+		return nil, err
+	}
+
+	var entry MempoolEntry
+	if err := json.Unmarshal(result, &entry); err != nil {
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+// AncestorFees returns the fees of the in-mempool ancestors of the entry,
+// including itself.
+func (e *MempoolEntry) AncestorFees() btcutil.Amount {
+	return e.Fees.Ancestor.Amount()
+}
+
+// NotInMempoolError indicates that a transaction is not in the mempool, and
+// whether it was confirmed since, or evicted from the mempool.
+type NotInMempoolError struct {
+	TxID      string
+	Confirmed bool // in the best chain
+	Evicted   bool // unconfirmed wallet transaction, no longer in the mempool
+}
+
+func (e *NotInMempoolError) Error() string {
+	switch {
+	case e.Confirmed:
+		return fmt.Sprintf("transaction not in mempool (%s): confirmed", e.TxID)
+	case e.Evicted:
+		return fmt.Sprintf("transaction not in mempool (%s): evicted", e.TxID)
+	default:
+		return fmt.Sprintf("transaction not in mempool (%s): unknown", e.TxID)
+	}
+}
+
+// GetMempoolTransaction returns the mempool data of the transaction with the
+// given hash, with the fees in satoshis.
+//
+// If the transaction is not in the mempool, a *NotInMempoolError is returned,
+// telling whether it was confirmed, according to the wallet or to the
+// transaction index, if any. An unconfirmed wallet transaction missing from
+// the mempool was evicted, for ex because its fee rate was too low.
+func (b *Bus) GetMempoolTransaction(ctx context.Context, hash *chainhash.Hash) (*types.MempoolTransaction, error) {
+	var entry *MempoolEntry
+	err := b.callRPC(ctx, "getmempoolentry", func(ctx context.Context) (err error) {
+		entry, err = getMempoolEntry(ctx, b.client, hash.String())
+		return err
+	})
+
+	switch {
+	case ClassifyError(err) == ErrNotFound:
+		return nil, b.mempoolAbsence(ctx, hash)
+	case err != nil:
+		return nil, err
+	}
+
+	ret := &types.MempoolTransaction{
+		TxID:            hash.String(),
+		Fee:             entry.Fees.Base.Amount(),
+		VSize:           entry.VSize,
+		AncestorCount:   entry.AncestorCount,
+		AncestorSize:    entry.AncestorSize,
+		AncestorFee:     entry.AncestorFees(),
+		DescendantCount: entry.DescendantCount,
+		DescendantSize:  entry.DescendantSize,
+		DescendantFee:   entry.Fees.Descendant.Amount(),
+		Time:            utils.ParseUnixTimestamp(entry.Time),
+		TimeInMempool:   time.Now().Unix() - entry.Time,
+		Replaceable:     entry.Replaceable,
+	}
+
+	if entry.VSize > 0 {
+		ret.FeeRate = float64(ret.Fee) / float64(entry.VSize)
+	}
+
+	if entry.AncestorSize > 0 {
+		ret.AncestorFeeRate = float64(ret.AncestorFee) / float64(entry.AncestorSize)
+	}
+
+	return ret, nil
+}
+
+// mempoolAbsence returns the NotInMempoolError of a transaction missing from
+// the mempool, or the error of the lookup of the transaction.
+func (b *Bus) mempoolAbsence(ctx context.Context, hash *chainhash.Hash) error {
+	ret := &NotInMempoolError{TxID: hash.String()}
+
+	var walletTx *btcjson.GetTransactionResult
+	err := b.callRPC(ctx, "gettransaction", func(ctx context.Context) (err error) {
+		walletTx, err = b.client.GetTransactionWatchOnly(ctx, hash, true)
+		return err
+	})
+
+	switch {
+	case err == nil:
+		// Conflicted transactions have negative confirmations; they were
+		// neither confirmed nor evicted.
+		ret.Confirmed = walletTx.Confirmations > 0
+		ret.Evicted = walletTx.Confirmations == 0
+		return ret
+	case ClassifyError(err) != ErrNotFound:
+		return err
+	case !b.Capabilities().TxIndex:
+		return ret
+	}
+
+	var result json.RawMessage
+	err = b.callRPC(ctx, "getrawtransaction", func(ctx context.Context) (err error) {
+		result, err = rawRequest(ctx, b.client, "getrawtransaction", hash.String(), true)
+		return err
+	})
+
+	switch {
+	case ClassifyError(err) == ErrNotFound:
+		return ret
+	case err != nil:
+		return err
+	}
+
+	var tx struct {
+		Confirmations int64 `json:"confirmations"`
+	}
+
+	if err := json.Unmarshal(result, &tx); err != nil {
+		return err
+	}
+
+	ret.Confirmed = tx.Confirmations > 0
+	return ret
+}
+
+// mempoolInfoResult models the subset of the getmempoolinfo RPC response used
+// by SatStack.
+type mempoolInfoResult struct {
+	Size          int64    `json:"size"`          // number of transactions
+	Bytes         int64    `json:"bytes"`         // sum of the virtual sizes
+	MempoolMinFee btcValue `json:"mempoolminfee"` // BTC per kvB
+}
+
+// rawMempoolEntry models an entry of the verbose getrawmempool RPC response.
+//
+// The fee field of btcjson.GetRawMempoolVerboseResult was removed from
+// bitcoind v23.0.0, so the result is decoded here instead.
+type rawMempoolEntry struct {
+	VSize int64 `json:"vsize"`
+	Fees  struct {
+		Base btcValue `json:"base"` // BTC
+	} `json:"fees"`
+}
+
+// feeRate returns the fee rate of the entry, in satoshis per kvB.
+func (e rawMempoolEntry) feeRate() btcutil.Amount {
+	return e.Fees.Base.Amount() * 1000 / btcutil.Amount(e.VSize)
+}
+
+func (b *Bus) mempoolInfo(ctx context.Context) (*mempoolInfoResult, error) {
+	var result json.RawMessage
+	err := b.callRPC(ctx, "getmempoolinfo", func(ctx context.Context) (err error) {
+		result, err = rawRequest(ctx, b.client, "getmempoolinfo")
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var info mempoolInfoResult
+	if err := json.Unmarshal(result, &info); err != nil {
+		return nil, err
+	}
+
+	return &info, nil
+}
+
+// rawMempool returns the entries of the mempool, keyed by txid.
+func (b *Bus) rawMempool(ctx context.Context) (map[string]rawMempoolEntry, error) {
+	var result json.RawMessage
+	err := b.callRPC(ctx, "getrawmempool", func(ctx context.Context) (err error) {
+		result, err = rawRequest(ctx, b.client, "getrawmempool", true)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var mempool map[string]rawMempoolEntry
+	if err := json.Unmarshal(result, &mempool); err != nil {
+		return nil, err
+	}
+
+	return mempool, nil
+}
+
+// mempoolCache holds the last computed mempool histogram.
+type mempoolCache struct {
+	mu        sync.Mutex
+	histogram *types.MempoolHistogram
+	updated   time.Time
+	ttl       time.Duration
+}
+
+// GetMempoolHistogram returns the distribution of the fee rates of the
+// transactions in the mempool.
+//
+// The histogram is computed lazily, and cached for the configured duration.
+// Concurrent callers wait for a single computation, rather than each
+// querying the mempool.
+func (b *Bus) GetMempoolHistogram(ctx context.Context) (*types.MempoolHistogram, error) {
+	b.mempoolCache.mu.Lock()
+	defer b.mempoolCache.mu.Unlock()
+
+	if b.mempoolCache.histogram != nil && time.Since(b.mempoolCache.updated) < b.mempoolCache.ttl {
+		return b.mempoolCache.histogram, nil
+	}
+
+	info, err := b.mempoolInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	mempool, err := b.rawMempool(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	histogram := &types.MempoolHistogram{
+		Size:          info.Size,
+		Bytes:         info.Bytes,
+		MempoolMinFee: info.MempoolMinFee.Amount(),
+		Buckets:       make([]types.MempoolBucket, len(mempoolFeeRates)),
+	}
+
+	for idx, feeRate := range mempoolFeeRates {
+		histogram.Buckets[idx].MinFeeRate = feeRate
+		if idx+1 < len(mempoolFeeRates) {
+			histogram.Buckets[idx].MaxFeeRate = &mempoolFeeRates[idx+1]
+		}
+	}
+
+	for _, entry := range mempool {
+		if entry.VSize <= 0 {
+			continue
+		}
+
+		// Find the last bucket with a lower bound below the fee rate.
+		feeRate := int64(entry.feeRate() / 1000)
+		idx := len(mempoolFeeRates) - 1
+		for idx > 0 && mempoolFeeRates[idx] > feeRate {
+			idx--
+		}
+
+		histogram.Buckets[idx].Count++
+		histogram.Buckets[idx].VSize += entry.VSize
+	}
+
+	b.mempoolCache.histogram = histogram
+	b.mempoolCache.updated = time.Now()
+
+	return histogram, nil
+}
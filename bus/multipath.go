@@ -0,0 +1,96 @@
+package bus
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ledgerhq/satstack/config"
+)
+
+// multipathPattern matches the multipath steps of a descriptor, for ex
+// <0;1> in wpkh(xpub/<0;1>/*), as specified by BIP-389.
+var multipathPattern = regexp.MustCompile(`<([0-9]+['hH]?(?:;[0-9]+['hH]?)+)>`)
+
+// expandMultipath expands a multipath descriptor, without checksum, into
+// the descriptors for each of its paths, in order. All the multipath steps
+// of the descriptor must have the same number of paths.
+//
+// A descriptor without multipath steps is returned as is.
+func expandMultipath(desc string) ([]string, error) {
+	matches := multipathPattern.FindAllStringSubmatchIndex(desc, -1)
+	if matches == nil {
+		if strings.ContainsAny(desc, "<>") {
+			return nil, fmt.Errorf("%s (%s): malformed multipath step",
+				ErrInvalidDescriptor, desc)
+		}
+
+		return []string{desc}, nil
+	}
+
+	var steps [][]string
+	for _, match := range matches {
+		paths := strings.Split(desc[match[2]:match[3]], ";")
+		if len(steps) > 0 && len(paths) != len(steps[0]) {
+			return nil, fmt.Errorf("%s (%s): multipath steps of different lengths",
+				ErrInvalidDescriptor, desc)
+		}
+
+		steps = append(steps, paths)
+	}
+
+	ret := make([]string, len(steps[0]))
+	for idx := range ret {
+		var b strings.Builder
+
+		prev := 0
+		for stepIdx, match := range matches {
+			b.WriteString(desc[prev:match[0]])
+			b.WriteString(steps[stepIdx][idx])
+			prev = match[1]
+		}
+		b.WriteString(desc[prev:])
+
+		ret[idx] = b.String()
+	}
+
+	return ret, nil
+}
+
+// splitAccountDescriptors returns the external and internal descriptors of
+// the account, without checksums. If the account is configured with a
+// multipath descriptor, it is returned as well, without checksum. Accounts
+// watching a single address have a single addr() descriptor, and accounts
+// configured with a descriptor without wildcard, and no internal one, have
+// only the external descriptor.
+func splitAccountDescriptors(account config.Account) ([]string, string, error) {
+	if account.Address != nil {
+		return []string{fmt.Sprintf("addr(%s)", *account.Address)}, "", nil
+	}
+
+	// Descriptors without wildcard may have no change chain.
+	if !account.Multipath() && account.Internal == nil {
+		return []string{stripChecksum(*account.External)}, "", nil
+	}
+
+	if !account.Multipath() {
+		return []string{
+			stripChecksum(*account.External),
+			stripChecksum(*account.Internal),
+		}, "", nil
+	}
+
+	multipath := stripChecksum(*account.External)
+
+	descs, err := expandMultipath(multipath)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(descs) != 2 {
+		return nil, "", fmt.Errorf("%s (%s): expected 2 paths (external;internal), found %d",
+			ErrInvalidDescriptor, multipath, len(descs))
+	}
+
+	return descs, multipath, nil
+}
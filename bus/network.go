@@ -0,0 +1,115 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/ledgerhq/satstack/utils"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// nodeWarnings are the warnings of bitcoind, like "This is a pre-release
+// test build". They are reported as a single string by getnetworkinfo, or
+// as a list since Bitcoin Core v28.0.
+type nodeWarnings []string
+
+// UnmarshalJSON accepts both forms of the warnings. An empty string means
+// that there are no warnings.
+func (w *nodeWarnings) UnmarshalJSON(data []byte) error {
+	var warning string
+	if err := json.Unmarshal(data, &warning); err == nil {
+		*w = nil
+		if warning != "" {
+			*w = nodeWarnings{warning}
+		}
+
+		return nil
+	}
+
+	var warnings []string
+	if err := json.Unmarshal(data, &warnings); err != nil {
+		return err
+	}
+
+	*w = warnings
+	return nil
+}
+
+// nodeNetworkInfo is the subset of the result of getnetworkinfo reported in
+// the ExplorerStatus.
+type nodeNetworkInfo struct {
+	Version     int32        `json:"version"`
+	SubVersion  string       `json:"subversion"`
+	Connections int32        `json:"connections"`
+	Warnings    nodeWarnings `json:"warnings"`
+}
+
+// networkInfoCache holds the last result of getnetworkinfo, refreshed by the
+// worker along with the Status, so that the status endpoint does not query
+// it on every request.
+type networkInfoCache struct {
+	info  *nodeNetworkInfo
+	mutex sync.RWMutex
+}
+
+func (c *networkInfoCache) get() *nodeNetworkInfo {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.info
+}
+
+func (c *networkInfoCache) set(info *nodeNetworkInfo) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.info = info
+}
+
+// refreshNetworkInfo updates the cached result of getnetworkinfo. The last
+// result is kept if bitcoind cannot be queried, since the Status already
+// reports it as disconnected.
+func (b *Bus) refreshNetworkInfo() {
+	info, err := b.queryNetworkInfo()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"prefix": "worker",
+			"error":  err,
+		}).Debug("Failed to refresh network info")
+		return
+	}
+
+	// Only log the warnings that were not reported at the previous refresh.
+	var previous []string
+	if last := b.networkInfo.get(); last != nil {
+		previous = last.Warnings
+	}
+
+	for _, warning := range info.Warnings {
+		if !utils.Contains(previous, warning) {
+			log.WithFields(log.Fields{
+				"prefix":  "worker",
+				"warning": warning,
+			}).Warn("Bitcoin node reports a warning")
+		}
+	}
+
+	b.networkInfo.set(info)
+}
+
+func (b *Bus) queryNetworkInfo() (*nodeNetworkInfo, error) {
+	result, err := rawRequest(context.Background(), b.client, "getnetworkinfo")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", ErrBitcoindUnreachable, err)
+	}
+
+	var info nodeNetworkInfo
+	if err := json.Unmarshal(result, &info); err != nil {
+		return nil, fmt.Errorf("%s: %w", ErrBitcoindUnreachable, err)
+	}
+
+	return &info, nil
+}
@@ -0,0 +1,129 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// BroadcastResult is the outcome of broadcasting a single transaction of a
+// package.
+type BroadcastResult struct {
+	TxID string // empty if the transaction could not be decoded
+	Err  error  // nil if the transaction was accepted
+
+	// AlreadyKnown is set if bitcoind already had the transaction, in its
+	// mempool or in the chain.
+	AlreadyKnown bool
+}
+
+// submitPackageResult models the response of the submitpackage RPC, which
+// is not supported by rpcclient.
+//
+// Per-transaction errors are only reported by bitcoind 26.0+; older nodes
+// fail the whole RPC call instead.
+type submitPackageResult struct {
+	PackageMsg string `json:"package_msg"`
+	TxResults  map[string]struct {
+		TxID  string `json:"txid"`
+		Error string `json:"error"`
+	} `json:"tx-results"` // keyed by wtxid
+}
+
+// SendTransactions broadcasts the hex-encoded transactions, which must be
+// topologically sorted (parents before children). The result has the same
+// order as txs.
+//
+// On bitcoind 25.0+, the transactions are submitted as a package with the
+// submitpackage RPC, so that a child can pay for a parent below the mempool
+// minimum fee (CPFP). If the node refuses the package as a whole, or does not
+// support package relay, the transactions are broadcast one by one, each with
+// its own result.
+func (b *Bus) SendTransactions(txs []string) []BroadcastResult {
+	ret := make([]BroadcastResult, len(txs))
+
+	wtxids := make([]string, len(txs))
+	decoded := true
+
+	for idx, tx := range txs {
+		msgTx, err := decodeTransaction(tx)
+		if err != nil {
+			ret[idx].Err = err
+			decoded = false
+			continue
+		}
+
+		ret[idx].TxID = msgTx.TxHash().String()
+		wtxids[idx] = msgTx.WitnessHash().String()
+	}
+
+	// A package with a malformed transaction is rejected by bitcoind without
+	// any per-transaction detail.
+	if decoded && len(txs) > 1 && b.Capabilities().Version >= minSubmitPackageVersion {
+		err := b.submitPackage(txs, wtxids, ret)
+		if err == nil {
+			b.speedUpPolling()
+			return ret
+		}
+
+		log.WithFields(log.Fields{
+			"size":  len(txs),
+			"error": err,
+		}).Warn("submitpackage failed, falling back to sendrawtransaction")
+	}
+
+	for idx, tx := range txs {
+		if ret[idx].TxID == "" {
+			continue
+		}
+
+		hash, known, err := b.SendTransaction(tx, nil)
+		if err != nil {
+			ret[idx].Err = err
+			continue
+		}
+
+		ret[idx].TxID = hash.String()
+		ret[idx].AlreadyKnown = known
+	}
+
+	return ret
+}
+
+// submitPackage broadcasts the transactions with the submitpackage RPC, and
+// stores the per-transaction outcome in ret. An error is returned if the
+// package was refused as a whole, in which case ret is left untouched.
+func (b *Bus) submitPackage(txs []string, wtxids []string, ret []BroadcastResult) error {
+	result, err := rawRequest(context.Background(), b.client, "submitpackage", txs)
+	if err != nil {
+		return err
+	}
+
+	var pkg submitPackageResult
+	if err := json.Unmarshal(result, &pkg); err != nil {
+		return err
+	}
+
+	for idx, wtxid := range wtxids {
+		txResult, ok := pkg.TxResults[wtxid]
+		switch {
+		case !ok:
+			ret[idx].Err = fmt.Errorf("%s (%s): %s",
+				ErrTransactionRejected, ret[idx].TxID, pkg.PackageMsg)
+		case txResult.Error != "":
+			ret[idx].Err = &RejectError{
+				TxID:   ret[idx].TxID,
+				Reason: txResult.Error,
+			}
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"size":   len(txs),
+		"result": pkg.PackageMsg,
+	}).Info("submitpackage successful")
+
+	return nil
+}
@@ -0,0 +1,642 @@
+package bus
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ledgerhq/satstack/types"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// defaultDiskCacheSize indicates the maximum size of the persistent
+	// cache, unless overridden in the config (disk_cache_size).
+	defaultDiskCacheSize = 256 << 20
+
+	// persistentQueueSize indicates the number of writes that may be
+	// queued for the persistent cache. Further writes are dropped until
+	// the queue is drained.
+	persistentQueueSize = 1024
+
+	// persistentBatchSize indicates the maximum number of queued writes
+	// committed in a single database transaction.
+	persistentBatchSize = 256
+)
+
+var (
+	transactionsBucket = []byte("transactions") // txid -> height + JSON transaction
+	headersBucket      = []byte("headers")      // hash -> height + JSON header
+	indexBucket        = []byte("index")        // height + kind + key -> empty
+	metaBucket         = []byte("meta")
+
+	chainKey = []byte("chain") // chain of the cached data
+	sizeKey  = []byte("size")  // total size of the cached keys and values
+	tipKey   = []byte("tip")   // height + hash of the last chain tip
+
+	errPersistentCacheClosed = errors.New("persistent cache closed")
+)
+
+// persistentCache is an optional on-disk cache of confirmed transactions
+// (by txid) and block headers (by hash), backed by a bbolt database, so that
+// they survive restarts. It backs the in-memory caches of the Bus.
+//
+// Every entry is indexed by the height of its block, so that the entries
+// above the fork point of a chain reorganization can be invalidated, and
+// the entries of the oldest blocks evicted once the cache exceeds its
+// maximum size.
+//
+// The database is opened on first use. Writes are queued, and committed in
+// batches by a single goroutine, in order with the invalidations. A nil
+// *persistentCache is valid, and caches nothing.
+type persistentCache struct {
+	path    string
+	chain   string
+	maxSize uint64
+
+	once sync.Once
+
+	// db and writes are set once the database is opened, and cleared once
+	// it is closed. The lock is held to queue writes, so that the queue is
+	// never closed while a write is being queued.
+	mu     sync.RWMutex
+	db     *bolt.DB
+	writes chan persistentWrite
+	done   chan struct{}
+
+	// corrupt is set to 1 if a corrupted page was found after the database
+	// was opened. The cache is then disabled, and the file discarded on
+	// close. Access it atomically.
+	corrupt int32
+}
+
+// persistentWrite is a write queued for the persistent cache. If done is
+// not nil, the result is sent to it once committed.
+type persistentWrite struct {
+	fn   func(tx *bolt.Tx) error
+	done chan error
+}
+
+func newPersistentCache(path string, chain string, maxSize uint64) *persistentCache {
+	return &persistentCache{path: path, chain: chain, maxSize: maxSize}
+}
+
+// load opens the database, and starts the goroutine committing the writes.
+// On failure, the cache is disabled with a warning.
+func (c *persistentCache) load() {
+	db, err := c.open()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"prefix": "cache",
+			"path":   c.path,
+			"error":  err,
+		}).Warn("Persistent cache unavailable, disabled")
+		return
+	}
+
+	c.mu.Lock()
+	c.db = db
+	c.writes = make(chan persistentWrite, persistentQueueSize)
+	c.done = make(chan struct{})
+	c.mu.Unlock()
+
+	go c.write(db, c.writes, c.done)
+
+	log.WithFields(log.Fields{
+		"prefix":    "cache",
+		"path":      c.path,
+		"maxSizeMB": c.maxSize >> 20,
+	}).Info("Opened persistent cache")
+}
+
+// open opens the database. If the file is corrupted, it is moved aside, and
+// a fresh database is created in its place.
+//
+// A file locked by another process, or that cannot be read, is left as is,
+// and an error returned.
+func (c *persistentCache) open() (*bolt.DB, error) {
+	db, err := c.openFile()
+	if err == nil {
+		return db, nil
+	}
+
+	var pathErr *os.PathError
+	if errors.Is(err, bolt.ErrTimeout) || errors.As(err, &pathErr) {
+		return nil, err
+	}
+
+	log.WithFields(log.Fields{
+		"prefix": "cache",
+		"path":   c.path,
+		"error":  err,
+	}).Warn("Persistent cache corrupted, starting afresh")
+
+	if err := os.Rename(c.path, c.path+".corrupt"); err != nil {
+		return nil, err
+	}
+
+	return c.openFile()
+}
+
+// openFile opens the database, creating it if needed, and prepares its
+// buckets. The data cached for another chain is dropped.
+//
+// Corrupted databases may fail bbolt assertions, which are reported as
+// errors.
+func (c *persistentCache) openFile() (db *bolt.DB, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if db != nil {
+				db.Close()
+			}
+
+			db, err = nil, fmt.Errorf("%v", r)
+		}
+	}()
+
+	db, err = bolt.Open(c.path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if meta := tx.Bucket(metaBucket); meta != nil && string(meta.Get(chainKey)) != c.chain {
+			if err := c.purge(tx); err != nil {
+				return err
+			}
+		}
+
+		for _, name := range [][]byte{transactionsBucket, headersBucket, indexBucket, metaBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+
+		return tx.Bucket(metaBucket).Put(chainKey, []byte(c.chain))
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// Close commits the queued writes, and closes the database. The cache is
+// disabled afterwards, and the database never opened if it was not yet.
+func (c *persistentCache) Close() {
+	if c == nil {
+		return
+	}
+
+	c.once.Do(func() {})
+
+	c.mu.Lock()
+	db, done := c.db, c.done
+	if db != nil {
+		close(c.writes)
+	}
+	c.db, c.writes = nil, nil
+	c.mu.Unlock()
+
+	if db == nil {
+		return
+	}
+
+	<-done
+
+	if err := db.Close(); err != nil {
+		log.WithFields(log.Fields{
+			"prefix": "cache",
+			"error":  err,
+		}).Warn("Failed to close persistent cache")
+	}
+
+	if atomic.LoadInt32(&c.corrupt) == 1 {
+		if err := os.Rename(c.path, c.path+".corrupt"); err != nil {
+			log.WithFields(log.Fields{
+				"prefix": "cache",
+				"error":  err,
+			}).Warn("Failed to discard corrupted persistent cache")
+		}
+	}
+}
+
+// write commits the queued writes until the queue is closed, batching the
+// writes queued meanwhile, and evicting the oldest blocks if the cache
+// exceeds its maximum size.
+func (c *persistentCache) write(db *bolt.DB, writes chan persistentWrite, done chan struct{}) {
+	defer close(done)
+
+	for write := range writes {
+		batch := []persistentWrite{write}
+
+	drain:
+		for len(batch) < persistentBatchSize {
+			select {
+			case write, ok := <-writes:
+				if !ok {
+					break drain
+				}
+
+				batch = append(batch, write)
+			default:
+				break drain
+			}
+		}
+
+		err := c.guard(func() error {
+			return db.Update(func(tx *bolt.Tx) error {
+				for _, write := range batch {
+					if err := write.fn(tx); err != nil {
+						return err
+					}
+				}
+
+				return c.evict(tx)
+			})
+		})
+		if err != nil {
+			log.WithFields(log.Fields{
+				"prefix": "cache",
+				"writes": len(batch),
+				"error":  err,
+			}).Warn("Failed to write persistent cache")
+		}
+
+		for _, write := range batch {
+			if write.done != nil {
+				write.done <- err
+			}
+		}
+	}
+}
+
+// guard calls fn, disabling the cache if it fails a bbolt assertion, which
+// indicates a corrupted database.
+func (c *persistentCache) guard(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			atomic.StoreInt32(&c.corrupt, 1)
+			err = fmt.Errorf("%v", r)
+
+			log.WithFields(log.Fields{
+				"prefix": "cache",
+				"path":   c.path,
+				"error":  err,
+			}).Warn("Persistent cache corrupted, disabled until restart")
+		}
+	}()
+
+	return fn()
+}
+
+// get returns a copy of the value cached for the key in the bucket, without
+// the height prefix.
+func (c *persistentCache) get(bucket []byte, key string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.once.Do(c.load)
+
+	c.mu.RLock()
+	db := c.db
+	c.mu.RUnlock()
+
+	if db == nil || atomic.LoadInt32(&c.corrupt) == 1 {
+		return nil, false
+	}
+
+	var ret []byte
+	err := c.guard(func() error {
+		return db.View(func(tx *bolt.Tx) error {
+			if value := tx.Bucket(bucket).Get([]byte(key)); len(value) > 8 {
+				ret = append([]byte(nil), value[8:]...)
+			}
+
+			return nil
+		})
+	})
+
+	return ret, err == nil && ret != nil
+}
+
+// enqueue queues a write, which is dropped if the queue is full. If wait is
+// true, the write is never dropped, and enqueue returns once it is
+// committed.
+func (c *persistentCache) enqueue(fn func(tx *bolt.Tx) error, wait bool) error {
+	if c == nil {
+		return nil
+	}
+
+	c.once.Do(c.load)
+
+	c.mu.RLock()
+
+	if c.db == nil || atomic.LoadInt32(&c.corrupt) == 1 {
+		c.mu.RUnlock()
+		return errPersistentCacheClosed
+	}
+
+	if !wait {
+		select {
+		case c.writes <- persistentWrite{fn: fn}:
+		default:
+		}
+
+		c.mu.RUnlock()
+		return nil
+	}
+
+	done := make(chan error, 1)
+	c.writes <- persistentWrite{fn: fn, done: done}
+	c.mu.RUnlock()
+
+	return <-done
+}
+
+// transaction returns the transaction cached with putTransaction, if any.
+func (c *persistentCache) transaction(hash string) (*types.Transaction, bool) {
+	data, ok := c.get(transactionsBucket, hash)
+	if !ok {
+		return nil, false
+	}
+
+	var tx types.Transaction
+	if err := json.Unmarshal(data, &tx); err != nil {
+		return nil, false
+	}
+
+	return &tx, true
+}
+
+// putTransaction caches the confirmed transaction against its txid.
+// Unconfirmed transactions are ignored.
+func (c *persistentCache) putTransaction(hash string, tx *types.Transaction) {
+	if c == nil || tx.Block == nil || tx.Block.Height < 0 {
+		return
+	}
+
+	data, err := json.Marshal(tx)
+	if err != nil {
+		return
+	}
+
+	height := tx.Block.Height
+	c.enqueue(func(tx *bolt.Tx) error {
+		return c.put(tx, transactionsBucket, hash, height, data)
+	}, false)
+}
+
+// header returns the block header cached with putHeader, if any.
+func (c *persistentCache) header(hash string) (*blockHeader, bool) {
+	data, ok := c.get(headersBucket, hash)
+	if !ok {
+		return nil, false
+	}
+
+	var header blockHeader
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, false
+	}
+
+	return &header, true
+}
+
+// putHeader caches the header of a block of the best chain against its
+// hash.
+func (c *persistentCache) putHeader(header *blockHeader) {
+	if c == nil || header.Confirmations < 0 {
+		return
+	}
+
+	data, err := json.Marshal(header)
+	if err != nil {
+		return
+	}
+
+	hash, height := header.Hash, header.Height
+	c.enqueue(func(tx *bolt.Tx) error {
+		return c.put(tx, headersBucket, hash, height, data)
+	}, false)
+}
+
+// tip returns the chain tip recorded with setTip, if any.
+func (c *persistentCache) tip() *chainTip {
+	if c == nil {
+		return nil
+	}
+
+	c.once.Do(c.load)
+
+	c.mu.RLock()
+	db := c.db
+	c.mu.RUnlock()
+
+	if db == nil || atomic.LoadInt32(&c.corrupt) == 1 {
+		return nil
+	}
+
+	var ret *chainTip
+	c.guard(func() error {
+		return db.View(func(tx *bolt.Tx) error {
+			value := tx.Bucket(metaBucket).Get(tipKey)
+			if len(value) <= 8 {
+				return nil
+			}
+
+			hash, err := chainhash.NewHashFromStr(string(value[8:]))
+			if err != nil {
+				return nil
+			}
+
+			ret = &chainTip{Hash: hash, Height: int64(binary.BigEndian.Uint64(value))}
+			return nil
+		})
+	})
+
+	return ret
+}
+
+// setTip records the chain tip, against which the cached data is checked for
+// a chain reorganization on the next startup.
+func (c *persistentCache) setTip(tip *chainTip) {
+	value := heightKey(tip.Height, []byte(tip.Hash.String()))
+
+	c.enqueue(func(tx *bolt.Tx) error {
+		return tx.Bucket(metaBucket).Put(tipKey, value)
+	}, false)
+}
+
+// invalidateAbove removes the entries of the blocks above the given height,
+// once the writes queued before are committed. A negative height removes all
+// the entries.
+func (c *persistentCache) invalidateAbove(height int64) {
+	err := c.enqueue(func(tx *bolt.Tx) error {
+		if height < 0 {
+			return c.purge(tx)
+		}
+
+		index := tx.Bucket(indexBucket).Cursor()
+		start := heightKey(height+1, nil)
+
+		for key, _ := index.Seek(start); key != nil; key, _ = index.Seek(start) {
+			if err := c.remove(tx, key); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}, true)
+
+	if err != nil && err != errPersistentCacheClosed {
+		log.WithFields(log.Fields{
+			"prefix": "cache",
+			"height": height,
+			"error":  err,
+		}).Warn("Failed to invalidate persistent cache")
+	}
+}
+
+// put stores the value for the key in the bucket, and indexes it by height.
+func (c *persistentCache) put(tx *bolt.Tx, bucket []byte, key string, height int64, data []byte) error {
+	entries := tx.Bucket(bucket)
+	index := tx.Bucket(indexBucket)
+	size := c.size(tx)
+
+	if old := entries.Get([]byte(key)); len(old) >= 8 {
+		oldHeight := int64(binary.BigEndian.Uint64(old))
+		if err := index.Delete(indexKey(oldHeight, bucket, key)); err != nil {
+			return err
+		}
+
+		size -= uint64(len(key) + len(old))
+	}
+
+	value := heightKey(height, data)
+	if err := entries.Put([]byte(key), value); err != nil {
+		return err
+	}
+
+	if err := index.Put(indexKey(height, bucket, key), []byte{}); err != nil {
+		return err
+	}
+
+	return c.setSize(tx, size+uint64(len(key)+len(value)))
+}
+
+// remove removes the entry with the given index key, and its index.
+func (c *persistentCache) remove(tx *bolt.Tx, indexKey []byte) error {
+	indexKey = append([]byte(nil), indexKey...) // invalidated by the deletion
+
+	bucket := headersBucket
+	if indexKey[8] == transactionsBucket[0] {
+		bucket = transactionsBucket
+	}
+
+	key := indexKey[9:]
+	entries := tx.Bucket(bucket)
+	size := c.size(tx)
+
+	if value := entries.Get(key); value != nil {
+		size -= uint64(len(key) + len(value))
+		if err := entries.Delete(key); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Bucket(indexBucket).Delete(indexKey); err != nil {
+		return err
+	}
+
+	return c.setSize(tx, size)
+}
+
+// evict removes the entries of the oldest blocks, until the cache no longer
+// exceeds its maximum size. The entries of a block are all removed at once.
+func (c *persistentCache) evict(tx *bolt.Tx) error {
+	if c.size(tx) <= c.maxSize {
+		return nil
+	}
+
+	index := tx.Bucket(indexBucket).Cursor()
+	evicted := int64(-1)
+
+	for key, _ := index.First(); key != nil; key, _ = index.First() {
+		height := int64(binary.BigEndian.Uint64(key))
+		if height != evicted && c.size(tx) <= c.maxSize {
+			break
+		}
+
+		if err := c.remove(tx, key); err != nil {
+			return err
+		}
+
+		evicted = height
+	}
+
+	return nil
+}
+
+// purge removes all the entries.
+func (c *persistentCache) purge(tx *bolt.Tx) error {
+	for _, name := range [][]byte{transactionsBucket, headersBucket, indexBucket} {
+		if tx.Bucket(name) == nil {
+			continue
+		}
+
+		if err := tx.DeleteBucket(name); err != nil {
+			return err
+		}
+
+		if _, err := tx.CreateBucket(name); err != nil {
+			return err
+		}
+	}
+
+	if meta := tx.Bucket(metaBucket); meta != nil {
+		return meta.Delete(sizeKey)
+	}
+
+	return nil
+}
+
+func (c *persistentCache) size(tx *bolt.Tx) uint64 {
+	if value := tx.Bucket(metaBucket).Get(sizeKey); len(value) == 8 {
+		return binary.BigEndian.Uint64(value)
+	}
+
+	return 0
+}
+
+func (c *persistentCache) setSize(tx *bolt.Tx, size uint64) error {
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, size)
+
+	return tx.Bucket(metaBucket).Put(sizeKey, value)
+}
+
+// heightKey returns the big-endian height followed by data, so that keys
+// are sorted by height.
+func heightKey(height int64, data []byte) []byte {
+	ret := make([]byte, 8+len(data))
+	binary.BigEndian.PutUint64(ret, uint64(height))
+	copy(ret[8:], data)
+
+	return ret
+}
+
+// indexKey returns the key indexing an entry of the bucket by height. The
+// kind of entry is identified by the first letter of the bucket.
+func indexKey(height int64, bucket []byte, key string) []byte {
+	return heightKey(height, append([]byte{bucket[0]}, key...))
+}
@@ -0,0 +1,353 @@
+package bus
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ledgerhq/satstack/types"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	bolt "go.etcd.io/bbolt"
+)
+
+// confirmedTx returns a transaction confirmed in the block at height on the
+// branch 'a', with the hash of seed.
+func confirmedTx(seed byte, height int64) *types.Transaction {
+	return &types.Transaction{
+		Hash:  (&chainhash.Hash{seed}).String(),
+		Block: &types.Block{Hash: mockBlockHash('a', height), Height: height},
+	}
+}
+
+// putTransactions caches the transactions, and waits for them to be
+// committed.
+func putTransactions(c *persistentCache, txs ...*types.Transaction) {
+	for _, tx := range txs {
+		c.putTransaction(tx.Hash, tx)
+	}
+
+	flush(c)
+}
+
+// flush waits for the writes queued so far to be committed.
+func flush(c *persistentCache) {
+	c.enqueue(func(*bolt.Tx) error { return nil }, true)
+}
+
+// cachedHeights returns the heights of the cached transactions among txs.
+func cachedHeights(c *persistentCache, txs ...*types.Transaction) []int64 {
+	var ret []int64
+	for _, tx := range txs {
+		if cached, ok := c.transaction(tx.Hash); ok {
+			ret = append(ret, cached.Block.Height)
+		}
+	}
+
+	return ret
+}
+
+// cacheSize returns the size recorded in the database.
+func cacheSize(t *testing.T, c *persistentCache) uint64 {
+	var size uint64
+	err := c.db.View(func(tx *bolt.Tx) error {
+		size = c.size(tx)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return size
+}
+
+func equalHeights(a []int64, b ...int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for idx := range a {
+		if a[idx] != b[idx] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestPersistentCacheReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+
+	c := newPersistentCache(path, "regtest", defaultDiskCacheSize)
+
+	tx := confirmedTx(1, 3)
+	c.putTransaction(tx.Hash, tx)
+	c.putTransaction("unconfirmed", &types.Transaction{Hash: "unconfirmed"})
+
+	header := &blockHeader{Hash: mockBlockHash('a', 3), Height: 3, Confirmations: 3}
+	c.putHeader(header)
+	c.putHeader(&blockHeader{Hash: mockBlockHash('b', 3), Height: 3, Confirmations: -1})
+
+	tipHash, _ := chainhash.NewHashFromStr(mockBlockHash('a', 5))
+	c.setTip(&chainTip{Hash: tipHash, Height: 5})
+
+	// The queued writes are committed on close.
+	c.Close()
+
+	c = newPersistentCache(path, "regtest", defaultDiskCacheSize)
+	defer c.Close()
+
+	if got, ok := c.transaction(tx.Hash); !ok || got.Block.Hash != tx.Block.Hash {
+		t.Errorf("got transaction %+v, %v, want it cached", got, ok)
+	}
+
+	if _, ok := c.transaction("unconfirmed"); ok {
+		t.Error("got an unconfirmed transaction cached")
+	}
+
+	if got, ok := c.header(header.Hash); !ok || *got != *header {
+		t.Errorf("got header %+v, %v, want %+v", got, ok, header)
+	}
+
+	if _, ok := c.header(mockBlockHash('b', 3)); ok {
+		t.Error("got the header of a stale block cached")
+	}
+
+	if tip := c.tip(); tip == nil || !tip.Hash.IsEqual(tipHash) || tip.Height != 5 {
+		t.Errorf("got tip %+v, want block 5", tip)
+	}
+}
+
+func TestPersistentCacheOtherChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+
+	c := newPersistentCache(path, "regtest", defaultDiskCacheSize)
+	tx := confirmedTx(1, 3)
+	putTransactions(c, tx)
+	c.Close()
+
+	// The data of another chain is dropped.
+	c = newPersistentCache(path, "test", defaultDiskCacheSize)
+	defer c.Close()
+
+	if _, ok := c.transaction(tx.Hash); ok {
+		t.Error("got a transaction cached for another chain")
+	}
+
+	if size := cacheSize(t, c); size != 0 {
+		t.Errorf("got size %d, want an empty cache", size)
+	}
+}
+
+func TestPersistentCacheInvalidate(t *testing.T) {
+	c := newPersistentCache(filepath.Join(t.TempDir(), "cache.db"), "regtest", defaultDiskCacheSize)
+	defer c.Close()
+
+	var txs []*types.Transaction
+	for height := int64(1); height <= 5; height++ {
+		txs = append(txs, confirmedTx(byte(height), height))
+	}
+
+	// The invalidation is ordered after the writes queued before.
+	for _, tx := range txs {
+		c.putTransaction(tx.Hash, tx)
+	}
+
+	c.invalidateAbove(3)
+
+	if got := cachedHeights(c, txs...); !equalHeights(got, 1, 2, 3) {
+		t.Errorf("got transactions at %v, want the ones below the fork point", got)
+	}
+
+	c.invalidateAbove(-1)
+
+	if got := cachedHeights(c, txs...); len(got) != 0 {
+		t.Errorf("got transactions at %v after a purge, want none", got)
+	}
+
+	if size := cacheSize(t, c); size != 0 {
+		t.Errorf("got size %d after a purge, want 0", size)
+	}
+}
+
+func TestPersistentCacheEviction(t *testing.T) {
+	data, err := json.Marshal(confirmedTx(1, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The entries of the test have the same size, and three of them fit.
+	entrySize := uint64(len(confirmedTx(1, 1).Hash) + 8 + len(data))
+
+	c := newPersistentCache(filepath.Join(t.TempDir(), "cache.db"), "regtest", 3*entrySize)
+	defer c.Close()
+
+	txs := []*types.Transaction{
+		confirmedTx(1, 1),
+		confirmedTx(2, 2),
+		confirmedTx(3, 2),
+		confirmedTx(4, 3),
+	}
+
+	putTransactions(c, txs...)
+
+	if got := cachedHeights(c, txs...); !equalHeights(got, 2, 2, 3) {
+		t.Fatalf("got transactions at %v, want the oldest block evicted", got)
+	}
+
+	// Caching a transaction again does not count twice.
+	putTransactions(c, txs[3])
+
+	if got := cachedHeights(c, txs...); !equalHeights(got, 2, 2, 3) {
+		t.Fatalf("got transactions at %v, want none evicted", got)
+	}
+
+	// The entries of a block are evicted at once.
+	txs = append(txs, confirmedTx(5, 4))
+	putTransactions(c, txs[4])
+
+	if got := cachedHeights(c, txs...); !equalHeights(got, 3, 4) {
+		t.Errorf("got transactions at %v, want both transactions of block 2 evicted", got)
+	}
+
+	if size := cacheSize(t, c); size != 2*entrySize {
+		t.Errorf("got size %d, want %d", size, 2*entrySize)
+	}
+}
+
+func TestPersistentCacheCorrupted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+
+	garbage := bytes.Repeat([]byte("corrupt"), 4096)
+	if err := ioutil.WriteFile(path, garbage, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newPersistentCache(path, "regtest", defaultDiskCacheSize)
+	defer c.Close()
+
+	tx := confirmedTx(1, 3)
+	putTransactions(c, tx)
+
+	// The corrupted file is moved aside, and the cache starts afresh.
+	if data, err := ioutil.ReadFile(path + ".corrupt"); err != nil || !bytes.Equal(data, garbage) {
+		t.Errorf("got corrupted file moved aside with error %v, want the original file", err)
+	}
+
+	if got := cachedHeights(c, tx); !equalHeights(got, 3) {
+		t.Errorf("got transactions at %v, want the cache usable", got)
+	}
+}
+
+func TestPersistentCacheCorruptedAtRuntime(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+
+	c := newPersistentCache(path, "regtest", defaultDiskCacheSize)
+	tx := confirmedTx(1, 3)
+	putTransactions(c, tx)
+
+	// A failed bbolt assertion disables the cache.
+	if err := c.guard(func() error { panic("page 3 already freed") }); err == nil {
+		t.Fatal("got no error from a failed assertion")
+	}
+
+	if _, ok := c.transaction(tx.Hash); ok {
+		t.Error("got a transaction from a corrupted cache")
+	}
+
+	if err := c.enqueue(func(*bolt.Tx) error { return nil }, true); err != errPersistentCacheClosed {
+		t.Errorf("got error %v writing to a corrupted cache, want %v", err, errPersistentCacheClosed)
+	}
+
+	// The file is discarded on close.
+	c.Close()
+
+	if _, err := os.Stat(path + ".corrupt"); err != nil {
+		t.Errorf("got error %v, want the corrupted file moved aside", err)
+	}
+
+	c = newPersistentCache(path, "regtest", defaultDiskCacheSize)
+	defer c.Close()
+
+	if _, ok := c.transaction(tx.Hash); ok {
+		t.Error("got a transaction cached before the corruption")
+	}
+}
+
+func TestPersistentCacheLocked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+
+	c := newPersistentCache(path, "regtest", defaultDiskCacheSize)
+	defer c.Close()
+	putTransactions(c, confirmedTx(1, 3))
+
+	// A file locked by another instance is left as is.
+	other := newPersistentCache(path, "regtest", defaultDiskCacheSize)
+	defer other.Close()
+
+	if _, ok := other.transaction(confirmedTx(1, 3).Hash); ok {
+		t.Error("got a transaction from a locked cache")
+	}
+
+	if _, err := os.Stat(path + ".corrupt"); !os.IsNotExist(err) {
+		t.Errorf("got error %v, want the locked file kept", err)
+	}
+
+	if got := cachedHeights(c, confirmedTx(1, 3)); !equalHeights(got, 3) {
+		t.Errorf("got transactions at %v, want the first instance unaffected", got)
+	}
+}
+
+func TestPersistentCacheNil(t *testing.T) {
+	var c *persistentCache
+
+	c.putTransaction("abcd", confirmedTx(1, 3))
+	if _, ok := c.transaction("abcd"); ok {
+		t.Error("got a transaction from a nil cache")
+	}
+
+	if c.tip() != nil {
+		t.Error("got a tip from a nil cache")
+	}
+
+	c.Close()
+}
+
+func TestPersistedTipReorg(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+
+	chain := newMockChain(5)
+
+	c := newPersistentCache(path, "regtest", defaultDiskCacheSize)
+	tipHash, _ := chainhash.NewHashFromStr(mockBlockHash('a', 5))
+	c.setTip(&chainTip{Hash: tipHash, Height: 5})
+
+	txs := []*types.Transaction{confirmedTx(1, 3), confirmedTx(2, 4), confirmedTx(3, 5)}
+	putTransactions(c, txs...)
+	c.Close()
+
+	// Blocks 4 and 5 are replaced while SatStack is stopped.
+	chain.fork(3, 3, 'b')
+
+	b := newTestBus(chain.node())
+	b.persistent = newPersistentCache(path, "regtest", defaultDiskCacheSize)
+	defer b.persistent.Close()
+
+	if _, err := b.refreshTip(); err != nil {
+		t.Fatal(err)
+	}
+
+	b.processTip()
+	flush(b.persistent)
+
+	if got := cachedHeights(b.persistent, txs...); !equalHeights(got, 3) {
+		t.Errorf("got transactions at %v, want the ones above the fork point invalidated", got)
+	}
+
+	if tip := b.persistent.tip(); tip == nil || tip.Hash.String() != mockBlockHash('b', 6) {
+		t.Errorf("got tip %+v, want the new tip recorded", tip)
+	}
+}
@@ -0,0 +1,78 @@
+package bus
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultPollInterval is the interval at which the worker polls bitcoind
+	// for the chain tip, when ZMQ block notifications are unavailable,
+	// unless overridden in the config (polling_interval).
+	defaultPollInterval = 7 * time.Second
+
+	// regtestPollInterval replaces defaultPollInterval on regtest, where
+	// blocks are mined on demand.
+	regtestPollInterval = 1 * time.Second
+
+	// fastPollInterval and fastPollWindow indicate how fast, and for how
+	// long, the chain tip is polled after a new block or a broadcast, since
+	// a confirmation is then likely to be awaited.
+	fastPollInterval = 2 * time.Second
+	fastPollWindow   = 1 * time.Minute
+)
+
+// speedUpPolling polls the chain tip at fastPollInterval for the next
+// fastPollWindow. A wait longer than fastPollInterval in progress is cut
+// short.
+func (b *Bus) speedUpPolling() {
+	atomic.StoreInt64(&b.fastPollUntil, time.Now().Add(fastPollWindow).UnixNano())
+
+	select {
+	case b.pollWake <- struct{}{}:
+	default:
+	}
+}
+
+// waitTipPoll waits for the next poll of the chain tip, and returns false if
+// ctx is done meanwhile.
+func (b *Bus) waitTipPoll(ctx context.Context) bool {
+	interval := b.tipPollInterval()
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+			return true
+		case <-b.tipChanged:
+			b.processTip()
+		case <-b.pollWake:
+			// Wait for fastPollInterval at most, from now on.
+			if interval > fastPollInterval {
+				interval = fastPollInterval
+
+				if !timer.Stop() {
+					<-timer.C
+				}
+
+				timer.Reset(interval)
+			}
+		}
+	}
+}
+
+// tipPollInterval returns the interval until the next poll of the chain tip:
+// fastPollInterval within fastPollWindow of a new block or a broadcast, and
+// the configured interval otherwise, whichever is shorter.
+func (b *Bus) tipPollInterval() time.Duration {
+	if b.pollInterval > fastPollInterval &&
+		time.Now().UnixNano() < atomic.LoadInt64(&b.fastPollUntil) {
+		return fastPollInterval
+	}
+
+	return b.pollInterval
+}
@@ -0,0 +1,326 @@
+package bus
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/ledgerhq/satstack/protocol"
+	"github.com/ledgerhq/satstack/redact"
+	"github.com/ledgerhq/satstack/types"
+	"github.com/ledgerhq/satstack/utils"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	log "github.com/sirupsen/logrus"
+)
+
+// minBlockPrevoutVersion indicates the minimum bitcoind version that
+// includes the outputs spent by the inputs of the transactions in the
+// response of the getblock RPC, with verbosity 3.
+const minBlockPrevoutVersion = 250000
+
+// blockWithPrevouts models the subset of the response of the getblock RPC
+// with verbosity 3 used by SatStack.
+type blockWithPrevouts struct {
+	Hash   string       `json:"hash"`
+	Height int64        `json:"height"`
+	Time   int64        `json:"time"`
+	Tx     []prevoutsTx `json:"tx"`
+}
+
+// prevoutsTx models a transaction of the response of the getblock RPC with
+// verbosity 3.
+type prevoutsTx struct {
+	Txid string `json:"txid"`
+	Hex  string `json:"hex"`
+	Vin  []struct {
+		Txid    string `json:"txid"`
+		Vout    uint32 `json:"vout"`
+		Prevout *struct {
+			Value        btcValue `json:"value"` // BTC
+			ScriptPubKey struct {
+				Hex string `json:"hex"`
+			} `json:"scriptPubKey"`
+		} `json:"prevout"` // absent for coinbase inputs
+	} `json:"vin"`
+}
+
+// BlockPrevoutsSupported indicates whether the node can return the outputs
+// spent by the transactions of a block along with the block, so that
+// GetBlockWithPrevouts can be used.
+func (b *Bus) BlockPrevoutsSupported() bool {
+	return b.Capabilities().Version >= minBlockPrevoutVersion
+}
+
+// PrevoutsBlock is a block fetched along with the outputs spent by the
+// inputs of its transactions, which are only decoded on demand, so that the
+// transactions of a large block are not all held in memory at once.
+type PrevoutsBlock struct {
+	// Block lists the IDs of the transactions, in the order of the block.
+	Block *types.Block
+
+	txs    []prevoutsTx
+	params *chaincfg.Params
+}
+
+// Len returns the number of transactions of the block.
+func (p *PrevoutsBlock) Len() int {
+	return len(p.txs)
+}
+
+// Transaction decodes the transaction at the given position in the block,
+// and returns it along with the outputs spent by its inputs.
+func (p *PrevoutsBlock) Transaction(idx int) (*types.Transaction, types.UTXOs, error) {
+	rawTx := p.txs[idx]
+
+	tx, err := protocol.DecodeRawTransaction(rawTx.Hex, p.params)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	utxos := make(types.UTXOs)
+	for _, vin := range rawTx.Vin {
+		if vin.Prevout == nil {
+			continue
+		}
+
+		pkScript, err := hex.DecodeString(vin.Prevout.ScriptPubKey.Hex)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		utxos[types.OutputIdentifier{Hash: vin.Txid, Index: vin.Vout}] = types.UTXOData{
+			Value:   vin.Prevout.Value.Amount(),
+			Address: protocol.ScriptAddress(pkScript, p.params),
+		}
+	}
+
+	return tx, utxos, nil
+}
+
+// GetPrevoutsBlock returns the block with the given hash, along with the
+// outputs spent by the inputs of its transactions, in a single getblock RPC,
+// rather than looking up the previous transaction of each input.
+//
+// Concurrent requests for the same block share a single getblock call, and
+// the block is cached like the ones of GetBlock.
+//
+// It requires bitcoind v25.0.0 or later; see BlockPrevoutsSupported.
+func (b *Bus) GetPrevoutsBlock(ctx context.Context, hash *chainhash.Hash) (*PrevoutsBlock, error) {
+	block, err := b.flights.do(ctx, "getblock:3:"+hash.String(),
+		func(ctx context.Context) (interface{}, error) {
+			return b.fetchPrevoutsBlock(ctx, hash)
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return block.(*PrevoutsBlock), nil
+}
+
+// fetchPrevoutsBlock gets the block with the getblock RPC with verbosity 3,
+// and caches the block without the transactions.
+func (b *Bus) fetchPrevoutsBlock(ctx context.Context, hash *chainhash.Hash) (*PrevoutsBlock, error) {
+	var raw json.RawMessage
+	err := b.callRPC(ctx, "getblock", func(ctx context.Context) (err error) {
+		raw, err = rawRequest(ctx, b.client, "getblock", hash.String(), 3)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result blockWithPrevouts
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, err
+	}
+
+	block, found := b.blockCache.Get(ctx, result.Hash)
+	if !found {
+		txids := make([]string, len(result.Tx))
+		for idx, rawTx := range result.Tx {
+			txids[idx] = rawTx.Txid
+		}
+
+		block = &types.Block{
+			Hash:         result.Hash,
+			Height:       result.Height,
+			Time:         utils.ParseUnixTimestamp(result.Time),
+			Transactions: &txids,
+		}
+
+		b.blockCache.Set(result.Hash, block, 0)
+	}
+
+	return &PrevoutsBlock{
+		Block:  block.(*types.Block),
+		txs:    result.Tx,
+		params: b.Params,
+	}, nil
+}
+
+// GetBlockWithPrevouts is similar to GetPrevoutsBlock, but decodes all the
+// transactions of the block, and returns them along with the outputs spent
+// by their inputs.
+func (b *Bus) GetBlockWithPrevouts(ctx context.Context, hash *chainhash.Hash) (*types.Block, []*types.Transaction, types.UTXOs, error) {
+	block, err := b.GetPrevoutsBlock(ctx, hash)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	txs := make([]*types.Transaction, block.Len())
+	utxos := make(types.UTXOs)
+
+	for idx := range txs {
+		tx, txUTXOs, err := block.Transaction(idx)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		txs[idx] = tx
+		for id, utxo := range txUTXOs {
+			utxos[id] = utxo
+		}
+	}
+
+	return block.Block, txs, utxos, nil
+}
+
+// txOutResult models the subset of the response of the gettxout RPC used by
+// SatStack.
+type txOutResult struct {
+	Value        btcValue `json:"value"` // BTC
+	ScriptPubKey struct {
+		Hex string `json:"hex"`
+	} `json:"scriptPubKey"`
+}
+
+// ResolvePrevouts returns the outputs spent by the non-coinbase inputs, so
+// that the value and address of the inputs, and the fee, can be computed.
+// Inputs whose previous output cannot be found are missing from the result.
+//
+// Each previous output is looked up in the cheapest way available: from the
+// transactions cache first, then from the UTXO set with gettxout, which
+// finds the outputs spent by unconfirmed transactions, then from the wallet
+// with gettransaction, and finally with getrawtransaction, which requires a
+// transaction index unless the previous transaction is unconfirmed.
+func (b *Bus) ResolvePrevouts(ctx context.Context, inputs []types.Input) types.UTXOs {
+	ret := make(types.UTXOs)
+
+	// Previous transactions fetched so far, or nil if not found.
+	prevTxs := make(map[string]*types.Transaction)
+
+	for _, input := range inputs {
+		if len(input.Coinbase) > 0 || input.OutputIndex == nil {
+			continue
+		}
+
+		utxoID := types.OutputIdentifier{
+			Hash:  input.OutputHash,
+			Index: *input.OutputIndex,
+		}
+
+		if _, ok := ret[utxoID]; ok {
+			continue
+		}
+
+		utxo, ok := b.resolvePrevout(ctx, utxoID, prevTxs)
+		if !ok {
+			utils.Logger(ctx).WithFields(log.Fields{
+				"hash": redact.TxID(utxoID.Hash),
+				"vout": utxoID.Index,
+			}).Debug("Failed to resolve previous output")
+			continue
+		}
+
+		ret[utxoID] = utxo
+	}
+
+	return ret
+}
+
+// resolvePrevout looks up a single previous output; see ResolvePrevouts.
+func (b *Bus) resolvePrevout(
+	ctx context.Context, utxoID types.OutputIdentifier, prevTxs map[string]*types.Transaction,
+) (types.UTXOData, bool) {
+	prevTx, fetched := prevTxs[utxoID.Hash]
+
+	if !fetched {
+		if tx, found := b.CachedTransaction(ctx, utxoID.Hash); found {
+			prevTx, fetched = tx, true
+			prevTxs[utxoID.Hash] = tx
+		}
+	}
+
+	if !fetched {
+		if utxo, ok := b.getTxOut(ctx, utxoID); ok {
+			return utxo, true
+		}
+
+		prevTx = b.fetchPrevTransaction(ctx, utxoID.Hash)
+		prevTxs[utxoID.Hash] = prevTx
+	}
+
+	if prevTx == nil || int(utxoID.Index) >= len(prevTx.Outputs) {
+		return types.UTXOData{}, false
+	}
+
+	output := prevTx.Outputs[utxoID.Index]
+	if output.Value == nil {
+		return types.UTXOData{}, false
+	}
+
+	return types.UTXOData{
+		Value:   *output.Value,
+		Address: output.Address,
+	}, true
+}
+
+// getTxOut looks up an output in the UTXO set of the chain, excluding the
+// mempool, so that the outputs spent by unconfirmed transactions are found.
+func (b *Bus) getTxOut(ctx context.Context, utxoID types.OutputIdentifier) (types.UTXOData, bool) {
+	var raw json.RawMessage
+	err := b.callRPC(ctx, "gettxout", func(ctx context.Context) (err error) {
+		raw, err = rawRequest(ctx, b.client, "gettxout", utxoID.Hash, utxoID.Index, false)
+		return err
+	})
+	if err != nil {
+		return types.UTXOData{}, false
+	}
+
+	// The result is null if the output is spent, or unknown.
+	var result *txOutResult
+	if err := json.Unmarshal(raw, &result); err != nil || result == nil {
+		return types.UTXOData{}, false
+	}
+
+	pkScript, err := hex.DecodeString(result.ScriptPubKey.Hex)
+	if err != nil {
+		return types.UTXOData{}, false
+	}
+
+	return types.UTXOData{
+		Value:   result.Value.Amount(),
+		Address: protocol.ScriptAddress(pkScript, b.Params),
+	}, true
+}
+
+// fetchPrevTransaction gets a previous transaction from the wallet, or with
+// getrawtransaction, and returns nil if neither finds it.
+func (b *Bus) fetchPrevTransaction(ctx context.Context, hash string) *types.Transaction {
+	chainHash, err := utils.ParseChainHash(hash)
+	if err != nil {
+		return nil
+	}
+
+	if tx, err := b.fetchWalletTransaction(ctx, chainHash); err == nil {
+		return tx
+	}
+
+	if tx, err := b.fetchRawTransaction(ctx, chainHash); err == nil {
+		return tx
+	}
+
+	return nil
+}
@@ -0,0 +1,210 @@
+package bus
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/ledgerhq/satstack/types"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// prevoutsChain is a block of a coinbase transaction and a transaction
+// spending both outputs of a previous transaction, with a fee of 10000
+// satoshis.
+type prevoutsChain struct {
+	hash     *chainhash.Hash
+	prev     *wire.MsgTx
+	coinbase *wire.MsgTx
+	spend    *wire.MsgTx
+}
+
+func newPrevoutsChain() *prevoutsChain {
+	p2wpkh := append([]byte{0x00, 0x14}, make([]byte, 20)...)
+
+	prev := wire.NewMsgTx(wire.TxVersion)
+	prev.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{2}, 0), nil, nil))
+	prev.AddTxOut(wire.NewTxOut(50000, p2wpkh))
+	prev.AddTxOut(wire.NewTxOut(30000, []byte{0x51}))
+
+	coinbase := wire.NewMsgTx(wire.TxVersion)
+	coinbase.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, wire.MaxPrevOutIndex), []byte{0x51, 0x51}, nil))
+	coinbase.AddTxOut(wire.NewTxOut(625010000, []byte{0x51}))
+
+	prevHash := prev.TxHash()
+	spend := wire.NewMsgTx(wire.TxVersion)
+	spend.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&prevHash, 0), nil, nil))
+	spend.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&prevHash, 1), nil, nil))
+	spend.AddTxOut(wire.NewTxOut(70000, p2wpkh))
+
+	return &prevoutsChain{
+		hash:     &chainhash.Hash{0x0a},
+		prev:     prev,
+		coinbase: coinbase,
+		spend:    spend,
+	}
+}
+
+// node returns a fakeNode with a transaction index, serving the block with
+// getblock, with verbosity 1 or 3.
+func (c *prevoutsChain) node() *fakeNode {
+	node := newFakeNode()
+
+	txs := make(map[string]string)
+	for _, tx := range []*wire.MsgTx{c.prev, c.coinbase, c.spend} {
+		txs[tx.TxHash().String()] = txHex(tx)
+	}
+
+	node.handle("getrawtransaction", func(params []json.RawMessage) (interface{}, error) {
+		var txid string
+		param(params, 0, &txid)
+
+		return txs[txid], nil
+	})
+
+	// The outputs are spent, and no longer in the UTXO set.
+	node.result("gettxout", nil)
+
+	node.handle("getblock", func(params []json.RawMessage) (interface{}, error) {
+		var verbosity int
+		param(params, 1, &verbosity)
+
+		block := map[string]interface{}{
+			"hash":   c.hash.String(),
+			"height": 100,
+			"time":   1600000000,
+		}
+
+		switch verbosity {
+		case 1:
+			block["tx"] = []string{c.coinbase.TxHash().String(), c.spend.TxHash().String()}
+		case 3:
+			block["tx"] = []interface{}{c.verboseTx(c.coinbase), c.verboseTx(c.spend)}
+		}
+
+		return block, nil
+	})
+
+	return node
+}
+
+// verboseTx returns a transaction as in the response of the getblock RPC
+// with verbosity 3.
+func (c *prevoutsChain) verboseTx(tx *wire.MsgTx) map[string]interface{} {
+	vin := make([]map[string]interface{}, len(tx.TxIn))
+	for idx, txIn := range tx.TxIn {
+		if tx == c.coinbase {
+			vin[idx] = map[string]interface{}{"coinbase": hex.EncodeToString(txIn.SignatureScript)}
+			continue
+		}
+
+		prevOut := c.prev.TxOut[txIn.PreviousOutPoint.Index]
+		vin[idx] = map[string]interface{}{
+			"txid": txIn.PreviousOutPoint.Hash.String(),
+			"vout": txIn.PreviousOutPoint.Index,
+			"prevout": map[string]interface{}{
+				"value":        btcutil.Amount(prevOut.Value).ToBTC(),
+				"scriptPubKey": map[string]interface{}{"hex": hex.EncodeToString(prevOut.PkScript)},
+			},
+		}
+	}
+
+	return map[string]interface{}{
+		"txid": tx.TxHash().String(),
+		"hex":  txHex(tx),
+		"vin":  vin,
+	}
+}
+
+// txFee returns the fee of a transaction from the outputs spent by its
+// inputs, or -1 if one is missing.
+func txFee(tx *types.Transaction, utxos types.UTXOs) btcutil.Amount {
+	var fee btcutil.Amount
+	for _, input := range tx.Inputs {
+		if len(input.Coinbase) > 0 {
+			return 0
+		}
+
+		utxo, ok := utxos[types.OutputIdentifier{Hash: input.OutputHash, Index: *input.OutputIndex}]
+		if !ok {
+			return -1
+		}
+
+		fee += utxo.Value
+	}
+
+	for _, output := range tx.Outputs {
+		fee -= *output.Value
+	}
+
+	return fee
+}
+
+func TestBlockWithPrevoutsFees(t *testing.T) {
+	chain := newPrevoutsChain()
+	ctx := context.Background()
+
+	b := newTestBus(chain.node())
+	b.setCapabilities(&Capabilities{TxIndex: true})
+
+	block, txs, utxos, err := b.GetBlockWithPrevouts(ctx, chain.hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Input lookups, as for nodes without getblock verbosity 3.
+	lookups := newTestBus(chain.node())
+	lookups.setCapabilities(&Capabilities{TxIndex: true})
+
+	lookupTxs := lookups.GetTransactions(ctx, *block.Transactions)
+
+	want := []btcutil.Amount{0, 10000}
+	for idx, tx := range txs {
+		prevoutsFee := txFee(tx, utxos)
+		lookupFee := txFee(lookupTxs[idx], lookups.ResolvePrevouts(ctx, lookupTxs[idx].Inputs))
+
+		if prevoutsFee != lookupFee {
+			t.Errorf("tx %d: got fee %d with prevouts, and %d with input lookups", idx, prevoutsFee, lookupFee)
+		}
+
+		if prevoutsFee != want[idx] {
+			t.Errorf("tx %d: got fee %d, want %d", idx, prevoutsFee, want[idx])
+		}
+	}
+
+	// The addresses of the inputs match too.
+	spent := types.OutputIdentifier{Hash: chain.prev.TxHash().String(), Index: 0}
+	lookupUTXOs := lookups.ResolvePrevouts(ctx, lookupTxs[1].Inputs)
+	if utxos[spent] != lookupUTXOs[spent] {
+		t.Errorf("got %+v with prevouts, and %+v with input lookups", utxos[spent], lookupUTXOs[spent])
+	}
+}
+
+func TestPrevoutsBlockCache(t *testing.T) {
+	chain := newPrevoutsChain()
+	node := chain.node()
+	b := newTestBus(node)
+
+	prevouts, err := b.GetPrevoutsBlock(context.Background(), chain.hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The block is then served from the cache.
+	block, err := b.GetBlock(context.Background(), chain.hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if block != prevouts.Block {
+		t.Errorf("got block %+v, want the cached %+v", block, prevouts.Block)
+	}
+
+	if got := node.count("getblock"); got != 1 {
+		t.Errorf("got %d getblock calls, want 1", got)
+	}
+}
@@ -0,0 +1,115 @@
+package bus
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// progressSmoothing is the number of samples over which the rate of a
+	// scan or a sync is averaged, so that the estimated time remaining does
+	// not swing with every sample.
+	progressSmoothing = 10
+
+	// progressMinInterval is the minimum interval between two samples of the
+	// progress, since the status may be queried much more often than the
+	// worker refreshes it.
+	progressMinInterval = 5 * time.Second
+)
+
+// progressTracker samples the progress of a long-running operation of
+// bitcoind, like a wallet rescan or the initial block download, to estimate
+// its rate and the time remaining.
+//
+// The rate is an exponential moving average of the rate between consecutive
+// samples. The zero value is ready to use.
+type progressTracker struct {
+	startedAt    time.Time
+	lastProgress float64
+	lastSample   time.Time
+	rate         float64 // percent per hour, 0 until two samples are taken
+	mutex        sync.Mutex
+}
+
+// progressEstimate is a snapshot of a progressTracker. The ETA and the
+// rate are nil until they can be estimated.
+type progressEstimate struct {
+	StartedAt  time.Time
+	ETASeconds *int64
+	RatePerHr  *float64
+}
+
+// sample records the progress, in percent, of the operation at the given
+// time. If startedAt is not zero, it is the start time reported by bitcoind.
+//
+// A progress lower than the previous sample, or a start time later than the
+// recorded one, indicates that a new operation started.
+func (t *progressTracker) sample(progress float64, startedAt time.Time, now time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	restarted := progress < t.lastProgress ||
+		(!startedAt.IsZero() && startedAt.Sub(t.startedAt) > progressMinInterval)
+
+	if t.startedAt.IsZero() || restarted {
+		if startedAt.IsZero() {
+			startedAt = now
+		}
+
+		t.startedAt = startedAt
+		t.lastProgress = progress
+		t.lastSample = now
+		t.rate = 0
+		return
+	}
+
+	elapsed := now.Sub(t.lastSample)
+	if elapsed < progressMinInterval {
+		return
+	}
+
+	rate := (progress - t.lastProgress) / elapsed.Hours()
+	if t.rate == 0 {
+		t.rate = rate
+	} else {
+		alpha := 2.0 / (progressSmoothing + 1)
+		t.rate = alpha*rate + (1-alpha)*t.rate
+	}
+
+	t.lastProgress = progress
+	t.lastSample = now
+}
+
+// reset forgets the operation being tracked, once it has completed.
+func (t *progressTracker) reset() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.startedAt = time.Time{}
+	t.lastProgress = 0
+	t.lastSample = time.Time{}
+	t.rate = 0
+}
+
+// estimate returns the start time, the rate and the time remaining of the
+// operation being tracked.
+func (t *progressTracker) estimate() progressEstimate {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	ret := progressEstimate{StartedAt: t.startedAt}
+	if t.rate <= 0 {
+		return ret
+	}
+
+	rate := t.rate
+	eta := int64(math.Round((100 - t.lastProgress) / rate * 3600))
+	if eta < 0 {
+		eta = 0
+	}
+
+	ret.RatePerHr = &rate
+	ret.ETASeconds = &eta
+	return ret
+}
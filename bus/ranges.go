@@ -0,0 +1,229 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/ledgerhq/satstack/config"
+	"github.com/ledgerhq/satstack/redact"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// rangeCheckInterval is the interval at which the worker checks how
+	// close the used addresses of the accounts are to the imported range.
+	rangeCheckInterval = 1 * time.Hour
+
+	// rangeEdgeRatio is the fraction of the imported range, at its end, in
+	// which a used address triggers a warning, or an extension of the range.
+	rangeEdgeRatio = 0.2
+)
+
+// checkRanges checks the imported range of the accounts right away, and
+// then periodically, since addresses keep being used. It blocks until the
+// context is cancelled.
+func checkRanges(ctx context.Context, b *Bus) {
+	for {
+		if err := b.checkAccountRanges(); err != nil {
+			log.WithFields(log.Fields{
+				"prefix": "worker",
+				"error":  err,
+			}).Warn("Failed to check the imported range of accounts")
+		}
+
+		if !sleep(ctx, rangeCheckInterval) {
+			return
+		}
+	}
+}
+
+// checkAccountRanges warns about the accounts whose used addresses are close
+// to the end of the imported range, since transactions to the addresses
+// beyond are missed. The range of such accounts is doubled, if they are
+// configured with auto_extend, which rescans the blockchain from their
+// birthday.
+//
+// The check is skipped while descriptors are being imported, and performed
+// again later.
+func (b *Bus) checkAccountRanges() error {
+	if b.IsPendingScan() || !atomic.CompareAndSwapInt32(&b.importing, 0, 1) {
+		return nil
+	}
+
+	defer atomic.AddInt32(&b.importing, -1)
+
+	client := b.client
+
+	used, err := receivedAddresses(client)
+	if err != nil {
+		return err
+	}
+
+	for _, account := range b.configuredAccounts() {
+		// Accounts watching a single address, or configured with
+		// descriptors without wildcard, have no range to check.
+		if !account.Ranged() {
+			continue
+		}
+
+		descs, err := b.descriptors(client, account)
+		if err != nil {
+			return err // return bare error, since it already has a ctx
+		}
+
+		depth := 0
+		for _, desc := range descs {
+			if desc.Depth > depth {
+				depth = desc.Depth
+			}
+		}
+
+		highest := -1
+		for _, desc := range descs {
+			if !desc.ranged() {
+				continue
+			}
+
+			index, err := highestUsedIndex(client, desc.Value, depth, used)
+			if err != nil {
+				return fmt.Errorf("%s (%s): %w", ErrDeriveAddress, redact.Descriptor(desc.Value), err)
+			}
+
+			if index > highest {
+				highest = index
+			}
+		}
+
+		if highest < 0 {
+			continue
+		}
+
+		fields := log.WithFields(log.Fields{
+			"prefix":     "worker",
+			"descriptor": redact.Descriptor(account.ID()),
+			"used":       highest,
+			"depth":      depth,
+		})
+
+		newDepth := 2 * depth
+		if newDepth > config.MaxAccountDepth {
+			newDepth = config.MaxAccountDepth
+		}
+
+		if !account.AutoExtend || newDepth <= depth {
+			fields.Warn("!!! Used addresses are close to the end of the imported range: " +
+				"transactions to the next addresses will be missed, increase the depth of the account !!!")
+			continue
+		}
+
+		fields.WithField("newDepth", newDepth).Warn(
+			"Used addresses are close to the end of the imported range, extending it")
+
+		for idx := range descs {
+			if descs[idx].ranged() {
+				descs[idx].Depth = newDepth
+			}
+		}
+
+		if err := b.extendRange(client, descs); err != nil {
+			return err
+		}
+
+		if err := b.indexAccountDerivations(client, account, descs); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// extendRange imports the descriptors of an account again, with a larger
+// depth. Transactions to the new addresses are found by a rescan from the
+// birthday of the account.
+func (b *Bus) extendRange(client *rpcClient, descs []descriptor) error {
+	// Imported descriptors may have transactions in past blocks.
+	defer b.walletIndex.invalidate()
+
+	failures, err := ImportDescriptors(client, descs, b.DescriptorWallet)
+	if err != nil {
+		return err
+	}
+
+	if _, err := importFailures(failures); err != nil {
+		return err
+	}
+
+	if b.state != nil {
+		if err := b.state.addDescriptors(descs); err != nil {
+			log.WithFields(log.Fields{
+				"prefix": "worker",
+				"path":   b.state.path,
+				"error":  err,
+			}).Warn("Failed to persist import state")
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"prefix": "worker",
+		"depth":  descs[0].Depth,
+	}).Info("Extended the imported range of account")
+
+	return nil
+}
+
+// highestUsedIndex returns the index of the last address of the descriptor
+// that received funds, among the addresses at the end of the range imported
+// with the given depth. It returns -1 if none did.
+func highestUsedIndex(client *rpcClient, desc string, depth int, used map[string]bool) (int, error) {
+	start := int(float64(depth) * (1 - rangeEdgeRatio))
+
+	result, err := rawRequest(context.Background(), client, "deriveaddresses", desc, []int{start, depth})
+	if err != nil {
+		return 0, err
+	}
+
+	var addresses []string
+	if err := json.Unmarshal(result, &addresses); err != nil {
+		return 0, err
+	}
+
+	for idx := len(addresses) - 1; idx >= 0; idx-- {
+		if used[addresses[idx]] {
+			return start + idx, nil
+		}
+	}
+
+	return -1, nil
+}
+
+// receivedAddresses returns the addresses of the wallet that received funds,
+// including in unconfirmed transactions.
+func receivedAddresses(client *rpcClient) (map[string]bool, error) {
+	result, err := rawRequest(context.Background(), client, "listreceivedbyaddress",
+		0,     // minconf
+		false, // include_empty
+		true,  // include_watchonly
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []struct {
+		Address string `json:"address"`
+	}
+
+	if err := json.Unmarshal(result, &entries); err != nil {
+		return nil, err
+	}
+
+	ret := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		ret[entry.Address] = true
+	}
+
+	return ret, nil
+}
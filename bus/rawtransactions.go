@@ -2,48 +2,234 @@ package bus
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
 
+	"github.com/btcsuite/btcd/btcjson"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcutil"
 
 	"github.com/btcsuite/btcd/wire"
+	"github.com/ledgerhq/satstack/redact"
+	"github.com/ledgerhq/satstack/utils"
 	log "github.com/sirupsen/logrus"
 )
 
-func (b *Bus) SendTransaction(tx string) (*chainhash.Hash, error) {
-	// Decode the serialized transaction hex to raw bytes.
-	serializedTx, err := hex.DecodeString(tx)
+// RejectError indicates that a transaction was rejected by the mempool
+// acceptance test, and would therefore not be relayed if broadcast.
+type RejectError struct {
+	TxID    string
+	Reason  string          // reject-reason reported by bitcoind
+	Fee     *btcutil.Amount // in satoshis, if known
+	FeeRate *btcutil.Amount // in satoshis per kvB, if known
+}
+
+func (e *RejectError) Error() string {
+	return fmt.Sprintf("%s (%s): %s", ErrTransactionRejected, e.TxID, e.Reason)
+}
+
+// testMempoolAcceptResult models a single entry of the testmempoolaccept RPC
+// response, which is not supported by rpcclient.
+type testMempoolAcceptResult struct {
+	TxID         string `json:"txid"`
+	Allowed      bool   `json:"allowed"`
+	VSize        int64  `json:"vsize"` // only if allowed
+	RejectReason string `json:"reject-reason"`
+	Fees         *struct {
+		Base btcValue `json:"base"` // in BTC
+	} `json:"fees"` // only if allowed, and bitcoind 0.21+
+}
+
+// alreadyKnownReasons are the reject reasons of testmempoolaccept for a
+// transaction that bitcoind already has, in its mempool or in the chain.
+var alreadyKnownReasons = map[string]bool{
+	"txn-already-in-mempool": true,
+	"txn-already-known":      true,
+}
+
+// missingInputsReasons are the reject reasons of testmempoolaccept for a
+// transaction whose inputs are unknown or spent, which is also the case of a
+// transaction that is already confirmed.
+var missingInputsReasons = map[string]bool{
+	"missing-inputs":                 true,
+	"bad-txns-inputs-missingorspent": true,
+}
+
+// SendTransaction broadcasts the hex-encoded transaction.
+//
+// The transaction is first checked with the testmempoolaccept RPC, and a
+// *RejectError is returned if it would be rejected. The maxFeeRate, in BTC
+// per kvB, is forwarded to bitcoind; a nil value does not enforce any limit.
+//
+// A transaction that bitcoind already has, in its mempool or in the chain,
+// is not an error, so that a broadcast can be retried safely. Its hash is
+// returned along with known set to true.
+func (b *Bus) SendTransaction(tx string, maxFeeRate *float64) (hash *chainhash.Hash, known bool, err error) {
+	msgTx, err := decodeTransaction(tx)
 	if err != nil {
+		return nil, false, err
+	}
+
+	txHash := msgTx.TxHash()
+
+	// A zero maxfeerate disables the check in bitcoind.
+	var feeRate float64
+	if maxFeeRate != nil {
+		feeRate = *maxFeeRate
+	}
+
+	// A confirmed transaction is rejected for missing inputs, and only
+	// sendrawtransaction tells it apart from a transaction spending unknown
+	// inputs.
+	var rejectErr *RejectError
+
+	err = b.testMempoolAccept(tx, feeRate)
+	switch {
+	case errors.As(err, &rejectErr) && alreadyKnownReasons[rejectErr.Reason]:
 		log.WithFields(log.Fields{
-			"hex":   tx,
+			"hash":   redact.TxID(txHash.String()),
+			"reason": rejectErr.Reason,
+		}).Info("Transaction already known, not broadcasting")
+		return &txHash, true, nil
+	case errors.As(err, &rejectErr) && missingInputsReasons[rejectErr.Reason]:
+	case err != nil:
+		log.WithFields(log.Fields{
+			"hex":   redact.RawTx(tx),
 			"error": err,
-		}).Error("Could not decode transaction hex")
-		return nil, err
+		}).Error("testmempoolaccept Bridge failed")
+		return nil, false, err
 	}
 
-	// Deserialize the transaction and return it.
-	var msgTx wire.MsgTx
-	if err := msgTx.Deserialize(bytes.NewReader(serializedTx)); err != nil {
+	result, err := rawRequest(context.Background(), b.client, "sendrawtransaction", tx, feeRate)
+	if isAlreadyKnown(err) {
 		log.WithFields(log.Fields{
-			"hex":   tx,
+			"hash":  redact.TxID(txHash.String()),
 			"error": err,
-		}).Error("Could not deserialize to wire.MsgTx")
-		return nil, err
+		}).Info("Transaction already known, not broadcasting")
+		return &txHash, true, nil
 	}
 
-	chainHash, err := b.mainClient.SendRawTransaction(&msgTx, true)
 	if err != nil {
 		log.WithFields(log.Fields{
-			"hex":   tx,
+			"hex":   redact.RawTx(tx),
 			"error": err,
 		}).Error("sendrawtransaction Bridge failed")
-		return nil, err
+
+		if rejectErr != nil {
+			return nil, false, rejectErr
+		}
+
+		return nil, false, err
+	}
+
+	var txid string
+	if err := json.Unmarshal(result, &txid); err != nil {
+		return nil, false, err
+	}
+
+	chainHash, err := utils.ParseChainHash(txid)
+	if err != nil {
+		return nil, false, err
 	}
 
 	log.WithFields(log.Fields{
-		"hex":  tx,
-		"hash": chainHash.String(),
+		"hex":  redact.RawTx(tx),
+		"hash": redact.TxID(chainHash.String()),
 	}).Info("sendrawtransaction Bridge successful")
 
-	return chainHash, nil
+	// The confirmation of the transaction is likely to be awaited.
+	b.speedUpPolling()
+
+	return chainHash, false, nil
+}
+
+// isAlreadyKnown returns true if err is the error returned by
+// sendrawtransaction for a transaction already in the mempool, or in the
+// chain.
+func isAlreadyKnown(err error) bool {
+	var rpcErr *btcjson.RPCError
+	if !errors.As(err, &rpcErr) {
+		return false
+	}
+
+	if rpcErr.Code == btcjson.ErrRPCVerifyAlreadyInChain {
+		return true
+	}
+
+	for reason := range alreadyKnownReasons {
+		if strings.Contains(rpcErr.Message, reason) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// testMempoolAccept checks whether the transaction would be accepted in the
+// mempool of the node, and returns a *RejectError otherwise.
+func (b *Bus) testMempoolAccept(tx string, maxFeeRate float64) error {
+	result, err := rawRequest(context.Background(), b.client, "testmempoolaccept", []string{tx}, maxFeeRate)
+	if err != nil {
+		return err
+	}
+
+	var results []testMempoolAcceptResult
+	if err := json.Unmarshal(result, &results); err != nil {
+		return err
+	}
+
+	if len(results) != 1 {
+		return fmt.Errorf("testmempoolaccept: expected 1 result, got %d", len(results))
+	}
+
+	r := results[0]
+	if r.Allowed {
+		return nil
+	}
+
+	rejectErr := &RejectError{
+		TxID:   r.TxID,
+		Reason: r.RejectReason,
+	}
+
+	if r.Fees != nil {
+		fee := r.Fees.Base.Amount()
+		rejectErr.Fee = &fee
+
+		if r.VSize > 0 {
+			feeRate := fee * 1000 / btcutil.Amount(r.VSize)
+			rejectErr.FeeRate = &feeRate
+		}
+	}
+
+	return rejectErr
+}
+
+// decodeTransaction deserializes the hex-encoded transaction.
+func decodeTransaction(tx string) (*wire.MsgTx, error) {
+	// Decode the serialized transaction hex to raw bytes.
+	serializedTx, err := hex.DecodeString(tx)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"hex":   redact.RawTx(tx),
+			"error": err,
+		}).Error("Could not decode transaction hex")
+		return nil, err
+	}
+
+	// Deserialize the transaction and return it.
+	var msgTx wire.MsgTx
+	if err := msgTx.Deserialize(bytes.NewReader(serializedTx)); err != nil {
+		log.WithFields(log.Fields{
+			"hex":   redact.RawTx(tx),
+			"error": err,
+		}).Error("Could not deserialize to wire.MsgTx")
+		return nil, err
+	}
+
+	return &msgTx, nil
 }
@@ -0,0 +1,140 @@
+package bus
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultReconnectInterval and defaultReconnectMaxInterval bound the
+	// delay between attempts to reach bitcoind while it is disconnected,
+	// unless overridden in the config.
+	defaultReconnectInterval    = 5 * time.Second
+	defaultReconnectMaxInterval = 1 * time.Minute
+)
+
+// pollStatus keeps the cached Status fresh, so that it can be served
+// without performing RPC calls, and publishes it to the status subscribers.
+//
+// While bitcoind is disconnected, the Status is refreshed with an
+// exponential backoff instead, and while it is warming up, at a short
+// interval. Once bitcoind answers again, the Bus is
+// reconnected: the wallet is loaded if needed, and the node capabilities
+// are checked again, since bitcoind may have been restarted with another
+// version or configuration.
+func pollStatus(ctx context.Context, b *Bus) {
+	p := &statusPoller{backoff: b.reconnectInterval}
+
+	for {
+		if !sleep(ctx, p.poll(b)) {
+			return
+		}
+	}
+}
+
+// statusPoller is the reconnection state of pollStatus.
+type statusPoller struct {
+	disconnected bool
+	backoff      time.Duration
+}
+
+// poll refreshes and publishes the Status, reconnects the Bus if bitcoind
+// answers again, and returns the delay until the next poll.
+//
+// The reconnection is attempted in every state, since bitcoind may still
+// be warming up, or even be syncing, when it answers again.
+func (p *statusPoller) poll(b *Bus) time.Duration {
+	b.refreshNetworkInfo()
+
+	status := b.QueryStatus()
+	b.publishStatus(status)
+
+	switch {
+	case status.Status == NodeDisconnected:
+		p.disconnected = true
+	case p.disconnected:
+		// bitcoind cannot be reconnected until it has finished warming
+		// up, which is retried at the next poll.
+		if err := b.reconnect(); err != nil {
+			if status.Status != Initializing {
+				log.WithFields(log.Fields{
+					"prefix": "worker",
+					"error":  err,
+				}).Warn("Failed to reconnect to bitcoind")
+			}
+		} else {
+			p.disconnected = false
+			p.backoff = b.reconnectInterval
+
+			// Refresh the Status right away, rather than reporting
+			// NodeDisconnected until the next poll.
+			status = b.QueryStatus()
+			b.publishStatus(status)
+		}
+	}
+
+	switch {
+	case status.Status == Initializing:
+		return warmupRetryInterval
+	case !p.disconnected:
+		return statusRefreshInterval
+	}
+
+	log.WithFields(log.Fields{
+		"prefix":  "worker",
+		"retryIn": p.backoff,
+	}).Warn("Bitcoin node disconnected")
+
+	delay := p.backoff
+
+	p.backoff *= 2
+	if p.backoff > b.reconnectMaxInterval {
+		p.backoff = b.reconnectMaxInterval
+	}
+
+	return delay
+}
+
+// reconnect restores the state of the Bus after bitcoind was unreachable.
+func (b *Bus) reconnect() error {
+	client := b.client
+
+	info, err := client.GetBlockChainInfo(context.Background())
+	if err != nil {
+		return fmt.Errorf("%s: %w", ErrBitcoindUnreachable, err)
+	}
+
+	networkInfo, err := client.GetNetworkInfo(context.Background())
+	if err != nil {
+		return fmt.Errorf("%s: %w", ErrBitcoindUnreachable, err)
+	}
+
+	caps, err := probeCapabilities(client, info, networkInfo.Version, b.requireTxIndex)
+	if err != nil {
+		return err
+	}
+
+	// The wallet is not loaded automatically if bitcoind was restarted
+	// without the -wallet option.
+	if _, err := loadOrCreateWallet(
+		client, b.WalletName, networkInfo.Version >= minDescriptorWalletVersion); err != nil {
+		return err
+	}
+
+	b.InvalidateTip()
+
+	b.setCapabilities(caps)
+
+	log.WithFields(log.Fields{
+		"prefix":  "worker",
+		"chain":   info.Chain,
+		"version": networkInfo.Version,
+		"pruned":  info.Pruned,
+		"txindex": caps.TxIndex,
+	}).Info("Reconnected to bitcoind")
+
+	return nil
+}
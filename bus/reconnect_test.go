@@ -0,0 +1,155 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+)
+
+// restartingNode is a fakeNode that can be stopped, and restarted, in which
+// case it warms up before answering.
+type restartingNode struct {
+	*fakeNode
+
+	mu    sync.Mutex
+	state string // "down", "warmup" or "ready"
+}
+
+func newRestartingNode() *restartingNode {
+	n := &restartingNode{fakeNode: newFakeNode(), state: "ready"}
+
+	bestBlockHash := mockBlockHash('a', 10)
+	n.serve("getblockchaininfo", map[string]interface{}{
+		"chain":         "regtest",
+		"blocks":        10,
+		"headers":       10,
+		"bestblockhash": bestBlockHash,
+	})
+	n.serve("getnetworkinfo", map[string]interface{}{
+		"version":     250000,
+		"subversion":  "/Satoshi:25.0.0/",
+		"connections": 8,
+	})
+	n.serve("getwalletinfo", map[string]interface{}{
+		"walletname": defaultWalletName,
+		"scanning":   false,
+	})
+	n.serve("loadwallet", btcjson.LoadWalletResult{Name: defaultWalletName})
+	n.serve("getblockhash", mockBlockHash('a', 1))
+	n.serve("getblock", map[string]interface{}{
+		"hash": mockBlockHash('a', 1),
+		"tx":   []string{mockBlockHash('t', 1)},
+	})
+
+	return n
+}
+
+// serve answers an RPC method with result once ready.
+func (n *restartingNode) serve(method string, result interface{}) {
+	n.handle(method, func([]json.RawMessage) (interface{}, error) {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+
+		switch n.state {
+		case "down":
+			return nil, errors.New("connection refused")
+		case "warmup":
+			return nil, btcjson.NewRPCError(btcjson.ErrRPCInWarmup, "Loading block index...")
+		default:
+			return result, nil
+		}
+	})
+}
+
+func (n *restartingNode) setState(state string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.state = state
+}
+
+func TestPollStatusReconnectDuringWarmup(t *testing.T) {
+	node := newRestartingNode()
+
+	b := newTestBus(node.fakeNode)
+	b.reconnectInterval = time.Second
+	b.reconnectMaxInterval = 2 * time.Second
+
+	p := &statusPoller{backoff: b.reconnectInterval}
+
+	if delay := p.poll(b); delay != statusRefreshInterval || p.disconnected {
+		t.Fatalf("got delay %v, disconnected %v, want a regular poll", delay, p.disconnected)
+	}
+
+	node.setState("down")
+
+	for _, want := range []time.Duration{time.Second, 2 * time.Second, 2 * time.Second} {
+		if delay := p.poll(b); delay != want || !p.disconnected {
+			t.Fatalf("got delay %v, disconnected %v, want a backoff of %v", delay, p.disconnected, want)
+		}
+	}
+
+	// The node is restarted, and warms up: the reconnection is attempted,
+	// and retried at the warmup interval.
+	node.setState("warmup")
+
+	calls := node.count("getblockchaininfo")
+	if delay := p.poll(b); delay != warmupRetryInterval || !p.disconnected {
+		t.Fatalf("got delay %v, disconnected %v, want a warmup retry", delay, p.disconnected)
+	}
+
+	if got := node.count("getblockchaininfo") - calls; got != 2 {
+		t.Errorf("got %d getblockchaininfo calls, want the status and the reconnection", got)
+	}
+
+	if status, _ := b.CachedStatus(); status != Initializing {
+		t.Errorf("got status %s, want %s", status, Initializing)
+	}
+
+	node.setState("ready")
+
+	if delay := p.poll(b); delay != statusRefreshInterval || p.disconnected {
+		t.Fatalf("got delay %v, disconnected %v, want the node reconnected", delay, p.disconnected)
+	}
+
+	if node.count("loadwallet") != 1 {
+		t.Error("wallet not loaded on the reconnection")
+	}
+
+	if status, _ := b.CachedStatus(); status != Ready {
+		t.Errorf("got status %s, want %s", status, Ready)
+	}
+
+	if p.backoff != b.reconnectInterval {
+		t.Errorf("got backoff %v after the reconnection, want %v", p.backoff, b.reconnectInterval)
+	}
+}
+
+func TestWaitForWarmupCancel(t *testing.T) {
+	node := newRestartingNode()
+	node.setState("warmup")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	if _, err := waitForWarmup(ctx, newTestBus(node.fakeNode).client); !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+
+	if elapsed := time.Since(start); elapsed >= warmupRetryInterval {
+		t.Errorf("returned after %v, want the cancellation to interrupt the retry", elapsed)
+	}
+
+	node.setState("ready")
+
+	info, err := waitForWarmup(context.Background(), newTestBus(node.fakeNode).client)
+	if err != nil || info.Blocks != 10 {
+		t.Errorf("got %+v, %v, want the chain info once warmed up", info, err)
+	}
+}
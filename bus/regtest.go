@@ -0,0 +1,295 @@
+package bus
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ledgerhq/satstack/redact"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// maxGenerateBlocks is the maximum number of blocks that can be mined
+	// in a single request.
+	maxGenerateBlocks = 1000
+
+	// coinbaseMaturity is the number of confirmations after which coinbase
+	// outputs can be spent.
+	coinbaseMaturity = 100
+
+	// faucetFee is the fee paid by the funding transactions, which is well
+	// above the minimum relay fee for their size.
+	faucetFee = btcutil.Amount(1000)
+)
+
+// faucetScript is the witness script of the faucet: OP_TRUE, which can be
+// spent by anyone, without a key.
+var faucetScript = []byte{txscript.OP_TRUE}
+
+// GenerateResult models the outcome of mining blocks on regtest.
+type GenerateResult struct {
+	TxID   string   `json:"txid,omitempty"` // funding transaction, if any
+	Blocks []string `json:"blocks"`         // hashes of the mined blocks
+	Height int64    `json:"height"`         // height of the chain tip
+}
+
+// Generate mines the given number of blocks on regtest, paying the rewards
+// to the address. If no address is given, the rewards are paid to the
+// faucet used by Fund.
+//
+// The chain tip is refreshed right away, so that subsequent requests see the
+// new blocks without waiting for the worker.
+func (b *Bus) Generate(count int, address *string) (*GenerateResult, error) {
+	if count < 1 || count > maxGenerateBlocks {
+		return nil, fmt.Errorf("%w: count %d is not in range [1, %d]",
+			ErrInvalidRegtestRequest, count, maxGenerateBlocks)
+	}
+
+	var payTo string
+	switch address {
+	case nil:
+		faucet, err := b.faucetAddress()
+		if err != nil {
+			return nil, err
+		}
+
+		payTo = faucet.EncodeAddress()
+	default:
+		if _, err := b.decodeAddress(*address); err != nil {
+			return nil, err
+		}
+
+		payTo = *address
+	}
+
+	blocks, err := b.generateToAddress(count, payTo)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.generateResult("", blocks)
+}
+
+// Fund sends the amount to the address from the faucet, and mines a block
+// to confirm the funding transaction.
+//
+// The faucet is funded by the rewards of the blocks mined with Generate,
+// without an address. If it has no mature output, enough blocks are mined
+// to the faucet beforehand.
+func (b *Bus) Fund(address string, amount btcutil.Amount) (*GenerateResult, error) {
+	if amount <= 0 {
+		return nil, fmt.Errorf("%w: amount must be positive", ErrInvalidRegtestRequest)
+	}
+
+	target, err := b.decodeAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	faucet, err := b.faucetAddress()
+	if err != nil {
+		return nil, err
+	}
+
+	var blocks []string
+
+	utxo, err := b.faucetUTXO()
+	if err != nil {
+		return nil, err
+	}
+
+	if utxo == nil {
+		log.WithField("prefix", "regtest").Info("Faucet is empty, mining mature blocks")
+
+		// The first of these blocks is mature once all of them are mined.
+		if blocks, err = b.generateToAddress(coinbaseMaturity+1, faucet.EncodeAddress()); err != nil {
+			return nil, err
+		}
+
+		if utxo, err = b.faucetUTXO(); err != nil {
+			return nil, err
+		}
+
+		if utxo == nil {
+			return nil, fmt.Errorf("%w: no mature faucet output", ErrInsufficientFunds)
+		}
+	}
+
+	if utxo.Value < amount+faucetFee {
+		return nil, fmt.Errorf("%w: largest faucet output is %d sats, requested %d sats",
+			ErrInsufficientFunds, utxo.Value, amount)
+	}
+
+	tx, err := fundingTransaction(utxo, target, faucet, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	txHash, _, err := b.SendTransaction(tx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Confirm the funding transaction.
+	confirmation, err := b.generateToAddress(1, faucet.EncodeAddress())
+	if err != nil {
+		return nil, err
+	}
+
+	return b.generateResult(txHash.String(), append(blocks, confirmation...))
+}
+
+// faucetOutput is an unspent output of the faucet.
+type faucetOutput struct {
+	OutPoint wire.OutPoint
+	Value    btcutil.Amount
+}
+
+// faucetUTXO returns the largest mature output of the faucet, or nil if
+// there is none.
+func (b *Bus) faucetUTXO() (*faucetOutput, error) {
+	faucet, err := b.faucetAddress()
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := rawRequest(context.Background(), b.client, "scantxoutset", "start",
+		[]string{fmt.Sprintf("addr(%s)", faucet.EncodeAddress())})
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Height   int64 `json:"height"`
+		Unspents []struct {
+			TxID   string   `json:"txid"`
+			Vout   uint32   `json:"vout"`
+			Amount btcValue `json:"amount"`
+			Height int64    `json:"height"`
+		} `json:"unspents"`
+	}
+
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, err
+	}
+
+	var ret *faucetOutput
+	for _, unspent := range result.Unspents {
+		// Outputs of funding transactions are treated as coinbase outputs,
+		// which is simpler, and harmless on regtest.
+		if result.Height-unspent.Height+1 < coinbaseMaturity {
+			continue
+		}
+
+		value := unspent.Amount.Amount()
+		if ret != nil && value <= ret.Value {
+			continue
+		}
+
+		hash, err := chainhash.NewHashFromStr(unspent.TxID)
+		if err != nil {
+			return nil, err
+		}
+
+		ret = &faucetOutput{
+			OutPoint: *wire.NewOutPoint(hash, unspent.Vout),
+			Value:    value,
+		}
+	}
+
+	return ret, nil
+}
+
+// fundingTransaction returns the hex-encoded transaction spending the
+// faucet output to the target address, with the change back to the faucet.
+func fundingTransaction(
+	utxo *faucetOutput, target btcutil.Address, faucet btcutil.Address, amount btcutil.Amount,
+) (string, error) {
+	targetScript, err := txscript.PayToAddrScript(target)
+	if err != nil {
+		return "", err
+	}
+
+	faucetPkScript, err := txscript.PayToAddrScript(faucet)
+	if err != nil {
+		return "", err
+	}
+
+	tx := wire.NewMsgTx(wire.TxVersion)
+
+	txIn := wire.NewTxIn(&utxo.OutPoint, nil, wire.TxWitness{faucetScript})
+	tx.AddTxIn(txIn)
+
+	tx.AddTxOut(wire.NewTxOut(int64(amount), targetScript))
+
+	// Dust change is left to the miner.
+	if change := utxo.Value - amount - faucetFee; change > faucetFee {
+		tx.AddTxOut(wire.NewTxOut(int64(change), faucetPkScript))
+	}
+
+	var buf bytes.Buffer
+	if err := tx.Serialize(&buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf.Bytes()), nil
+}
+
+// faucetAddress returns the P2WSH address of the faucet script.
+func (b *Bus) faucetAddress() (btcutil.Address, error) {
+	scriptHash := sha256.Sum256(faucetScript)
+	return btcutil.NewAddressWitnessScriptHash(scriptHash[:], b.Params)
+}
+
+func (b *Bus) decodeAddress(address string) (btcutil.Address, error) {
+	decoded, err := btcutil.DecodeAddress(address, b.Params)
+	if err != nil || !decoded.IsForNet(b.Params) {
+		return nil, fmt.Errorf("%w: invalid address '%s'", ErrInvalidRegtestRequest, address)
+	}
+
+	return decoded, nil
+}
+
+func (b *Bus) generateToAddress(count int, address string) ([]string, error) {
+	raw, err := rawRequest(context.Background(), b.client, "generatetoaddress", count, address)
+	if err != nil {
+		return nil, err
+	}
+
+	var blocks []string
+	if err := json.Unmarshal(raw, &blocks); err != nil {
+		return nil, err
+	}
+
+	log.WithFields(log.Fields{
+		"prefix":  "regtest",
+		"count":   count,
+		"address": redact.Address(address),
+	}).Info("Mined blocks")
+
+	return blocks, nil
+}
+
+// generateResult refreshes the chain tip, and reports it along with the
+// mined blocks.
+func (b *Bus) generateResult(txID string, blocks []string) (*GenerateResult, error) {
+	tip, err := b.refreshTip()
+	if err != nil {
+		return nil, err
+	}
+
+	return &GenerateResult{
+		TxID:   txID,
+		Blocks: blocks,
+		Height: tip.Height,
+	}, nil
+}
@@ -0,0 +1,230 @@
+package bus
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/ledgerhq/satstack/config"
+	"github.com/ledgerhq/satstack/redact"
+	log "github.com/sirupsen/logrus"
+)
+
+// ReloadAccounts replaces the configured accounts with the given ones, and
+// imports the descriptors of the accounts that were added. The import runs
+// in the background, during which the Status is PendingScan.
+//
+// Removed accounts are not removed from the wallet, since Bitcoin Core does
+// not support it, but they are no longer reported in the scan details.
+//
+// The reload is rejected with ErrScanInProgress if descriptors are already
+// being imported.
+func (b *Bus) ReloadAccounts(accounts []config.Account) error {
+	if b.IsPendingScan() || !atomic.CompareAndSwapInt32(&b.importing, 0, 1) {
+		log.WithField(
+			"prefix", "reload",
+		).Warn("Reload rejected: descriptors are currently being imported")
+		return ErrScanInProgress
+	}
+
+	added, removed := diffAccounts(b.configuredAccounts(), accounts)
+
+	if len(removed) > 0 {
+		for _, account := range removed {
+			// Accounts that failed to import may be tracked as configured.
+			b.removeAccountScan(account.ID())
+			b.derivations.remove(account.ID())
+
+			// Scans are tracked by the canonical external descriptor, which
+			// is expanded from multipath descriptors.
+			descs, _, err := splitAccountDescriptors(account)
+
+			var desc *string
+			if err == nil {
+				desc, err = GetCanonicalDescriptor(b.client, descs[0])
+			}
+
+			if err != nil {
+				log.WithFields(log.Fields{
+					"prefix":     "reload",
+					"descriptor": redact.Descriptor(account.ID()),
+					"error":      err,
+				}).Warn("Failed to remove account")
+				continue
+			}
+
+			b.removeAccountScan(*desc)
+		}
+	}
+
+	b.setAccounts(accounts)
+
+	// The labels of the accounts that were kept may have changed.
+	for _, account := range accounts {
+		b.derivations.relabel(account.ID(), account.Name())
+	}
+
+	log.WithFields(log.Fields{
+		"prefix":  "reload",
+		"added":   len(added),
+		"removed": len(removed),
+	}).Info("Reloaded accounts")
+
+	if len(added) == 0 {
+		atomic.AddInt32(&b.importing, -1)
+		return nil
+	}
+
+	b.setPendingScan(true)
+
+	go func() {
+		defer atomic.AddInt32(&b.importing, -1)
+
+		done := make(chan struct{})
+		go func() {
+			for {
+				select {
+				case <-done:
+					return
+				case <-time.After(7 * time.Second):
+					if err := getImportProgress(b); err != nil {
+						log.WithFields(log.Fields{
+							"prefix": "reload",
+							"error":  err,
+						}).Warn("Failed to query wallet state")
+					}
+				}
+			}
+		}()
+
+		err := b.importAccounts(added, false)
+		close(done)
+
+		b.setPendingScan(false)
+
+		if err != nil {
+			log.WithFields(log.Fields{
+				"prefix": "reload",
+				"error":  err,
+			}).Error("Failed to import new accounts")
+			return
+		}
+
+		log.WithField("prefix", "reload").Info("Imported new accounts")
+	}()
+
+	return nil
+}
+
+// AddAccount adds the account to the configured ones, and imports its
+// descriptors in the background; see ReloadAccounts.
+//
+// ErrAccountExists is returned if an equivalent account is already
+// configured, for ex with the same external descriptor written with another
+// checksum, or as a multipath descriptor, or with the same label.
+func (b *Bus) AddAccount(account config.Account) error {
+	known := b.configuredAccounts()
+	for _, known := range known {
+		if sameAccount(known, account) {
+			return fmt.Errorf("%w: %s", ErrAccountExists, account.ID())
+		}
+
+		if known.Name() == account.Name() {
+			return fmt.Errorf("%w: label %s", ErrAccountExists, account.Name())
+		}
+	}
+
+	accounts := make([]config.Account, 0, len(known)+1)
+	return b.ReloadAccounts(append(append(accounts, known...), account))
+}
+
+// WalletEnabled indicates whether accounts are configured, in which case
+// their transactions are tracked in the wallet. Accounts may be added at
+// runtime; see ReloadAccounts and AddAccount.
+func (b *Bus) WalletEnabled() bool {
+	return len(b.configuredAccounts()) > 0
+}
+
+// configuredAccounts returns the accounts currently configured. The slice is
+// replaced as a whole on reloads, and must not be modified.
+func (b *Bus) configuredAccounts() []config.Account {
+	b.accountsMutex.RLock()
+	defer b.accountsMutex.RUnlock()
+
+	return b.accounts
+}
+
+func (b *Bus) setAccounts(accounts []config.Account) {
+	b.accountsMutex.Lock()
+	defer b.accountsMutex.Unlock()
+
+	b.accounts = accounts
+}
+
+// IsPendingScan indicates whether SatStack is currently waiting for
+// descriptors to be scanned, for ex while "running the numbers", or while
+// the accounts added by a reload are imported.
+//
+// Other packages use it to avoid making explorer requests before SatStack is
+// able to serve them.
+func (b *Bus) IsPendingScan() bool {
+	b.accountsMutex.RLock()
+	defer b.accountsMutex.RUnlock()
+
+	return b.pendingScan
+}
+
+func (b *Bus) setPendingScan(pending bool) {
+	b.accountsMutex.Lock()
+	defer b.accountsMutex.Unlock()
+
+	b.pendingScan = pending
+}
+
+// sameAccount indicates whether the accounts watch the same address, or
+// derive their addresses from the same external descriptor.
+func sameAccount(a config.Account, b config.Account) bool {
+	if a.Address != nil || b.Address != nil {
+		return a.Address != nil && b.Address != nil && *a.Address == *b.Address
+	}
+
+	aDescs, _, aErr := splitAccountDescriptors(a)
+	bDescs, _, bErr := splitAccountDescriptors(b)
+	if aErr != nil || bErr != nil {
+		return a.ID() == b.ID()
+	}
+
+	return descriptorKey(aDescs[0]) == descriptorKey(bDescs[0])
+}
+
+// diffAccounts returns the accounts that are in next but not in prev, and the
+// ones that are in prev but not in next. Accounts are identified by their
+// external descriptor or address, as written in the configuration.
+func diffAccounts(prev []config.Account, next []config.Account) ([]config.Account, []config.Account) {
+	known := func(accounts []config.Account) map[string]bool {
+		ret := make(map[string]bool, len(accounts))
+		for _, account := range accounts {
+			ret[account.ID()] = true
+		}
+
+		return ret
+	}
+
+	prevSet, nextSet := known(prev), known(next)
+
+	var added, removed []config.Account
+
+	for _, account := range next {
+		if !prevSet[account.ID()] {
+			added = append(added, account)
+		}
+	}
+
+	for _, account := range prev {
+		if !nextSet[account.ID()] {
+			removed = append(removed, account)
+		}
+	}
+
+	return added, removed
+}
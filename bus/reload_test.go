@@ -0,0 +1,139 @@
+package bus
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ledgerhq/satstack/config"
+	"github.com/ledgerhq/satstack/types"
+)
+
+// TestReloadAccountsConcurrent reloads the accounts while they are read, as
+// by handlers and by the worker, and is meant to be run with -race.
+func TestReloadAccountsConcurrent(t *testing.T) {
+	b := newTestBus(newFakeNode())
+
+	address := "bcrt1qw508d6qejxtdg4y5r3zarvary0c5xw7kygt080"
+	account := func(idx int) []config.Account {
+		label := fmt.Sprintf("account %d", idx)
+		return []config.Account{{Address: &address, Label: &label}}
+	}
+
+	b.setAccounts(account(0))
+
+	done := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				if !b.WalletEnabled() || b.IsPendingScan() {
+					t.Error("got no accounts, or a pending scan")
+					return
+				}
+
+				for _, account := range b.configuredAccounts() {
+					_ = account.Name()
+				}
+			}
+		}()
+	}
+
+	for i := 1; i <= 100; i++ {
+		// Relabeling an account imports nothing, so that each reload
+		// completes synchronously.
+		if err := b.ReloadAccounts(account(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	close(done)
+	wg.Wait()
+
+	if got := b.configuredAccounts()[0].Name(); got != "account 100" {
+		t.Errorf("got account %q, want the last reload", got)
+	}
+}
+
+func TestReloadAccountsPendingScan(t *testing.T) {
+	b := newTestBus(newFakeNode())
+	b.setPendingScan(true)
+
+	if err := b.ReloadAccounts(nil); err != ErrScanInProgress {
+		t.Errorf("got error %v, want %v", err, ErrScanInProgress)
+	}
+}
+
+func TestReloadAccountsRelabel(t *testing.T) {
+	b := newTestBus(multisigNode(new([]importDescriptorsRequest), nil))
+
+	address := "bcrt1qw508d6qejxtdg4y5r3zarvary0c5xw7kygt080"
+	label := "savings"
+	account := config.Account{Address: &address, Label: &label}
+
+	b.setAccounts([]config.Account{account})
+	b.derivations.set(address, map[string]types.Derivation{
+		address: {Account: address, Label: label},
+	})
+
+	relabeled := "spending"
+	account.Label = &relabeled
+
+	if err := b.ReloadAccounts([]config.Account{account}); err != nil {
+		t.Fatal(err)
+	}
+
+	if derivation, ok := b.Derivation(address); !ok || derivation.Label != relabeled {
+		t.Errorf("got derivation %+v, %v, want the new label", derivation, ok)
+	}
+
+	accounts, err := b.ListDescriptors()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(accounts) != 1 || accounts[0].Label != relabeled {
+		t.Errorf("got descriptors %+v, want the new label", accounts)
+	}
+
+	// Without label, the account is named after its address.
+	account.Label = nil
+	if err := b.ReloadAccounts([]config.Account{account}); err != nil {
+		t.Fatal(err)
+	}
+
+	if derivation, _ := b.Derivation(address); derivation.Label != account.Name() {
+		t.Errorf("got label %q, want the fingerprint %q", derivation.Label, account.Name())
+	}
+}
+
+func TestAddAccountLabelExists(t *testing.T) {
+	b := newTestBus(newFakeNode())
+
+	address := "bcrt1qw508d6qejxtdg4y5r3zarvary0c5xw7kygt080"
+	other := "bcrt1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3qzf4jry"
+	label := "savings"
+
+	b.setAccounts([]config.Account{{Address: &address, Label: &label}})
+
+	err := b.AddAccount(config.Account{Address: &other, Label: &label})
+	if !errors.Is(err, ErrAccountExists) || !strings.Contains(err.Error(), "label savings") {
+		t.Errorf("got error %v, want %v for the label", err, ErrAccountExists)
+	}
+
+	if len(b.configuredAccounts()) != 1 {
+		t.Errorf("got %d accounts, want the account not added", len(b.configuredAccounts()))
+	}
+}
@@ -0,0 +1,179 @@
+package bus
+
+import (
+	"context"
+	"github.com/ledgerhq/satstack/types"
+	"github.com/ledgerhq/satstack/utils"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxReorgDepth is the maximum number of blocks walked back from a stale tip
+// to find the fork point with the best chain. Deeper reorganizations are
+// handled by invalidating all cached data.
+const maxReorgDepth = 100
+
+// checkReorg verifies that the previous chain tip is still in the best
+// chain. If not, the chain has been reorganized: cached data above the fork
+// point is invalidated, so that transactions are served with their
+// post-reorg blocks.
+//
+// The wallet index is rebuilt, so that wallet transactions that were
+// evicted back to the mempool are reported as unconfirmed.
+func (b *Bus) checkReorg(prev *chainTip) {
+	hash := prev.Hash
+
+	var forkHeight int64 = -1
+	for depth := 0; depth <= maxReorgDepth; depth++ {
+		header, err := b.client.GetBlockHeaderVerbose(context.Background(), hash)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"prefix": "worker",
+				"hash":   hash.String(),
+				"error":  err,
+			}).Warn("Failed to verify chain ancestry")
+			return
+		}
+
+		// Blocks that are not in the best chain have -1 confirmations.
+		if header.Confirmations >= 0 {
+			forkHeight = int64(header.Height)
+			break
+		}
+
+		if hash, err = utils.ParseChainHash(header.PreviousHash); err != nil {
+			return
+		}
+	}
+
+	if forkHeight == prev.Height {
+		// No reorganization; the previous tip is an ancestor of the new one.
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"prefix":     "worker",
+		"staleTip":   prev.Hash.String(),
+		"forkHeight": forkHeight,
+		"depth":      prev.Height - forkHeight,
+	}).Warn("Chain reorganization detected")
+
+	b.invalidateAbove(forkHeight)
+}
+
+// invalidateAbove removes cached transactions confirmed in a block above the
+// given height, as well as unconfirmed ones, and cached blocks, headers and
+// block stats above the given height, including in the persistent cache. A negative
+// height invalidates all cached data. The wallet index is rebuilt in any case.
+func (b *Bus) invalidateAbove(height int64) {
+	b.walletIndex.invalidate()
+	b.persistent.invalidateAbove(height)
+
+	if height < 0 {
+		b.txCache.Purge()
+		b.blockCache.Purge()
+		b.headerCache.Purge()
+		b.blockStatsCache.Purge()
+		return
+	}
+
+	b.txCache.DeleteFunc(func(value interface{}) bool {
+		tx, ok := value.(*types.Transaction)
+		return !ok || tx.Block == nil || tx.Block.Height > height || tx.Block.Height < 0
+	})
+
+	b.blockCache.DeleteFunc(func(value interface{}) bool {
+		block, ok := value.(*types.Block)
+		return !ok || block.Height > height
+	})
+
+	b.headerCache.DeleteFunc(func(value interface{}) bool {
+		header, ok := value.(*blockHeader)
+		return !ok || header.Height > height
+	})
+
+	b.blockStatsCache.DeleteFunc(func(value interface{}) bool {
+		stats, ok := value.(*types.BlockStats)
+		return !ok || stats.Height > height
+	})
+}
+
+// updateTip stores the new chain tip, and notifies the tip poller if it
+// changed, so that the chain is checked for a reorganization; see
+// processTip. The check walks the headers back from the previous tip, and is
+// kept off the request goroutines that refresh the tip.
+func (b *Bus) updateTip(tip *chainTip) {
+	b.tipMutex.Lock()
+	b.tip = tip
+	changed := b.lastTip == nil || !b.lastTip.Hash.IsEqual(tip.Hash)
+	b.tipMutex.Unlock()
+
+	if !changed {
+		return
+	}
+
+	select {
+	case b.tipChanged <- struct{}{}:
+	default:
+	}
+}
+
+// processTip handles the change of the chain tip since the last call, if
+// any: the chain is checked for a reorganization if the new tip does not
+// build on the previous one, and the new block is published. It is called by
+// the tip poller only.
+//
+// The first tip is checked against the one recorded in the persistent cache,
+// if any, to detect the reorganizations that happened while SatStack was
+// stopped.
+func (b *Bus) processTip() {
+	b.tipMutex.Lock()
+	prev, tip := b.lastTip, b.tip
+	if tip != nil {
+		b.lastTip = tip
+	}
+	b.tipMutex.Unlock()
+
+	// The tip was invalidated meanwhile, and is processed once refreshed.
+	if tip == nil {
+		return
+	}
+
+	if prev == nil {
+		b.checkPersistedTip(tip)
+		b.persistent.setTip(tip)
+		return
+	}
+
+	if !prev.Hash.IsEqual(tip.Hash) {
+		b.checkReorg(prev)
+		b.persistent.setTip(tip)
+		b.signalWalletIndex()
+		b.publishBlock(tip)
+		b.speedUpPolling()
+	}
+}
+
+// checkPersistedTip checks for a reorganization between the chain tip
+// recorded in the persistent cache and the given one. If the recorded tip
+// cannot be verified, for ex since it is unknown to bitcoind after a resync,
+// the persistent cache is emptied, since the tip is recorded anew next.
+func (b *Bus) checkPersistedTip(tip *chainTip) {
+	prev := b.persistent.tip()
+	if prev == nil || prev.Hash.IsEqual(tip.Hash) {
+		return
+	}
+
+	if _, err := b.client.GetBlockHeaderVerbose(context.Background(), prev.Hash); err != nil {
+		log.WithFields(log.Fields{
+			"prefix": "worker",
+			"hash":   prev.Hash.String(),
+			"error":  err,
+		}).Warn("Failed to verify persisted chain tip, emptying persistent cache")
+
+		b.persistent.invalidateAbove(-1)
+		return
+	}
+
+	b.checkReorg(prev)
+}
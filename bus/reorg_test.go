@@ -0,0 +1,264 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ledgerhq/satstack/types"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// mockChain is a chain of block headers served by a fakeNode, whose best
+// chain can be extended, or reorganized.
+type mockChain struct {
+	mu      sync.Mutex
+	headers map[string]*btcjson.GetBlockHeaderVerboseResult
+	best    []string // hashes of the best chain, by height
+}
+
+// mockBlockHash returns the hash of the block at height on the branch tag.
+func mockBlockHash(tag byte, height int64) string {
+	return (&chainhash.Hash{tag, byte(height), byte(height >> 8)}).String()
+}
+
+// newMockChain returns a chain from the genesis block up to height, on the
+// branch 'a'.
+func newMockChain(height int64) *mockChain {
+	c := &mockChain{headers: make(map[string]*btcjson.GetBlockHeaderVerboseResult)}
+	c.fork(-1, height+1, 'a')
+
+	return c
+}
+
+// fork replaces the best chain above height with count blocks on the branch
+// tag. A height below the tip reorganizes the chain.
+func (c *mockChain) fork(height int64, count int64, tag byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.best = c.best[:height+1]
+	for i := int64(0); i < count; i++ {
+		header := &btcjson.GetBlockHeaderVerboseResult{
+			Hash:   mockBlockHash(tag, height+1+i),
+			Height: int32(height + 1 + i),
+		}
+
+		if len(c.best) > 0 {
+			header.PreviousHash = c.best[len(c.best)-1]
+		}
+
+		c.headers[header.Hash] = header
+		c.best = append(c.best, header.Hash)
+	}
+}
+
+// tip returns the height of the best chain.
+func (c *mockChain) tip() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return int64(len(c.best) - 1)
+}
+
+// node returns a fakeNode serving the chain.
+func (c *mockChain) node() *fakeNode {
+	node := newFakeNode()
+
+	node.handle("getblockchaininfo", func([]json.RawMessage) (interface{}, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		return map[string]interface{}{
+			"chain":         "regtest",
+			"blocks":        len(c.best) - 1,
+			"bestblockhash": c.best[len(c.best)-1],
+		}, nil
+	})
+
+	node.handle("getblockhash", func(params []json.RawMessage) (interface{}, error) {
+		var height int64
+		param(params, 0, &height)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		if height < 0 || height >= int64(len(c.best)) {
+			return nil, btcjson.NewRPCError(btcjson.ErrRPCInvalidParameter, "Block height out of range")
+		}
+
+		return c.best[height], nil
+	})
+
+	node.handle("getblockheader", func(params []json.RawMessage) (interface{}, error) {
+		var hash string
+		param(params, 0, &hash)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		header, ok := c.headers[hash]
+		if !ok {
+			return nil, btcjson.NewRPCError(btcjson.ErrRPCBlockNotFound, "Block not found")
+		}
+
+		// Blocks that are not in the best chain have -1 confirmations.
+		ret := *header
+		ret.Confirmations = -1
+		if int(header.Height) < len(c.best) && c.best[header.Height] == hash {
+			ret.Confirmations = int64(len(c.best)) - int64(header.Height)
+		}
+
+		return ret, nil
+	})
+
+	return node
+}
+
+// cacheBlock caches the block at height on the branch tag.
+func cacheBlock(b *Bus, tag byte, height int64) {
+	hash := mockBlockHash(tag, height)
+	b.blockCache.Set(hash, &types.Block{Hash: hash, Height: height}, 0)
+}
+
+func cachedBlock(b *Bus, tag byte, height int64) bool {
+	_, found := b.blockCache.Get(context.Background(), mockBlockHash(tag, height))
+	return found
+}
+
+func TestReorgOnPoller(t *testing.T) {
+	chain := newMockChain(5)
+	node := chain.node()
+	b := newTestBus(node)
+
+	if _, err := b.refreshTip(); err != nil {
+		t.Fatal(err)
+	}
+
+	b.processTip()
+
+	for height := int64(3); height <= 5; height++ {
+		cacheBlock(b, 'a', height)
+	}
+
+	// Blocks 4 and 5 are replaced by a longer branch.
+	chain.fork(3, 3, 'b')
+
+	// Requests refresh the tip, but leave the reorganization to the poller.
+	b.InvalidateTip()
+	height, err := b.GetBestBlockHeight()
+	if err != nil || height != 6 {
+		t.Fatalf("got height %d, %v, want 6", height, err)
+	}
+
+	if got := node.count("getblockheader"); got != 0 {
+		t.Errorf("got %d getblockheader calls on the request, want none", got)
+	}
+
+	if !cachedBlock(b, 'a', 5) {
+		t.Error("stale block evicted before the check")
+	}
+
+	select {
+	case <-b.tipChanged:
+	default:
+		t.Fatal("poller not notified of the new tip")
+	}
+
+	b.processTip()
+
+	// The headers of blocks 5 and 4 are stale, and the one of 3 in the
+	// best chain.
+	if got := node.count("getblockheader"); got != 3 {
+		t.Errorf("got %d getblockheader calls, want 3", got)
+	}
+
+	if cachedBlock(b, 'a', 4) || cachedBlock(b, 'a', 5) {
+		t.Error("stale blocks still cached after the reorganization")
+	}
+
+	if !cachedBlock(b, 'a', 3) {
+		t.Error("block below the fork point evicted")
+	}
+
+	// The tip is processed once.
+	b.processTip()
+	if got := node.count("getblockheader"); got != 3 {
+		t.Errorf("got %d getblockheader calls after processing the tip again, want 3", got)
+	}
+}
+
+func TestNoReorgOnNewBlock(t *testing.T) {
+	chain := newMockChain(5)
+	node := chain.node()
+	b := newTestBus(node)
+
+	if _, err := b.refreshTip(); err != nil {
+		t.Fatal(err)
+	}
+
+	b.processTip()
+	cacheBlock(b, 'a', 5)
+
+	chain.fork(5, 1, 'a')
+	if _, err := b.refreshTip(); err != nil {
+		t.Fatal(err)
+	}
+
+	b.processTip()
+
+	// Only the previous tip is checked, and found in the best chain.
+	if got := node.count("getblockheader"); got != 1 {
+		t.Errorf("got %d getblockheader calls, want 1", got)
+	}
+
+	if !cachedBlock(b, 'a', 5) {
+		t.Error("block evicted without reorganization")
+	}
+}
+
+func TestReorgPoller(t *testing.T) {
+	chain := newMockChain(5)
+	node := chain.node()
+	b := newTestBus(node)
+	b.pollInterval = time.Hour
+
+	if _, err := b.refreshTip(); err != nil {
+		t.Fatal(err)
+	}
+
+	b.processTip()
+	cacheBlock(b, 'a', 5)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pollTip(ctx, b)
+	}()
+
+	chain.fork(4, 2, 'b')
+	b.InvalidateTip()
+	if _, err := b.GetBestBlockHash(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The poller processes the new tip without waiting for its next poll.
+	deadline := time.Now().Add(5 * time.Second)
+	for cachedBlock(b, 'a', 5) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if cachedBlock(b, 'a', 5) {
+		t.Error("stale block still cached")
+	}
+
+	cancel()
+	<-done
+}
@@ -0,0 +1,161 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/btcsuite/btcd/btcjson"
+	log "github.com/sirupsen/logrus"
+)
+
+// timestampWindow is the margin, in seconds, applied by Bitcoin Core when
+// rescanning from a timestamp, to account for the inaccuracy of block
+// timestamps.
+const timestampWindow = 2 * 60 * 60
+
+// Rescan rescans the blockchain for wallet transactions, starting from the
+// given height, or from the first block that may contain transactions after
+// the given UNIX timestamp. If neither is given, the rescan starts from the
+// genesis block.
+//
+// The rescan runs in the background, during which the Status is Scanning.
+// The height from which the rescan starts is returned. It is rejected with
+// ErrScanInProgress if the wallet is already scanning, or if descriptors are
+// being imported.
+func (b *Bus) Rescan(height *int64, timestamp *int64) (int64, error) {
+	if b.IsPendingScan() || !atomic.CompareAndSwapInt32(&b.importing, 0, 1) {
+		return 0, ErrScanInProgress
+	}
+
+	startHeight, err := b.startRescan(height, timestamp)
+	if err != nil {
+		atomic.AddInt32(&b.importing, -1)
+		return 0, err
+	}
+
+	return startHeight, nil
+}
+
+func (b *Bus) startRescan(height *int64, timestamp *int64) (int64, error) {
+	walletInfo, err := b.client.GetWalletInfo(context.Background())
+	if err != nil {
+		return 0, err
+	}
+
+	if _, ok := walletInfo.Scanning.Value.(btcjson.ScanProgress); ok {
+		return 0, ErrScanInProgress
+	}
+
+	bestHeight, err := b.GetBestBlockHeight()
+	if err != nil {
+		return 0, err
+	}
+
+	var startHeight int64
+	switch {
+	case height != nil:
+		startHeight = *height
+	case timestamp != nil:
+		startHeight, err = b.heightFromTimestamp(*timestamp-timestampWindow, bestHeight)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if startHeight < 0 || startHeight > bestHeight {
+		return 0, fmt.Errorf("%w: height %d is not in range [0, %d]",
+			ErrInvalidRescan, startHeight, bestHeight)
+	}
+
+	// The rescanblockchain RPC blocks until the rescan is complete, in the
+	// background.
+	go func() {
+		defer atomic.AddInt32(&b.importing, -1)
+
+		// Transactions may be found in past blocks.
+		defer b.walletIndex.invalidate()
+
+		log.WithFields(log.Fields{
+			"prefix": "rescan",
+			"height": startHeight,
+		}).Info("Rescanning blockchain")
+
+		if _, err := rawRequest(context.Background(), b.client, "rescanblockchain", startHeight); err != nil {
+			log.WithFields(log.Fields{
+				"prefix": "rescan",
+				"height": startHeight,
+				"error":  err,
+			}).Error("Failed to rescan blockchain")
+			return
+		}
+
+		log.WithField("prefix", "rescan").Info("Rescan complete")
+	}()
+
+	return startHeight, nil
+}
+
+// StopRescan stops the wallet rescan in progress, whether it was started
+// with Rescan or by an import of descriptors. It returns ErrNoScanInProgress
+// if the wallet is not scanning.
+func (b *Bus) StopRescan() error {
+	result, err := rawRequest(context.Background(), b.client, "abortrescan")
+	if err != nil {
+		return err
+	}
+
+	var aborted bool
+	if err := json.Unmarshal(result, &aborted); err != nil {
+		return err
+	}
+
+	if !aborted {
+		return ErrNoScanInProgress
+	}
+
+	log.WithField("prefix", "rescan").Info("Aborted wallet rescan")
+	return nil
+}
+
+// heightFromTimestamp returns the height of the first block of the best
+// chain with a timestamp not before the given UNIX timestamp, or bestHeight
+// if there is none.
+//
+// Block timestamps are not strictly increasing, but the median-time-past
+// rule bounds how far back a timestamp can be, which is covered by
+// timestampWindow.
+func (b *Bus) heightFromTimestamp(timestamp int64, bestHeight int64) (int64, error) {
+	low, high := int64(0), bestHeight
+	for low < high {
+		mid := low + (high-low)/2
+
+		blockTime, err := blockTimeAt(b.client, mid)
+		if err != nil {
+			return 0, err
+		}
+
+		if blockTime < timestamp {
+			low = mid + 1
+		} else {
+			high = mid
+		}
+	}
+
+	return low, nil
+}
+
+func blockTimeAt(client *rpcClient, height int64) (int64, error) {
+	hash, err := client.GetBlockHash(context.Background(), height)
+	if err != nil {
+		return 0, err
+	}
+
+	header, err := client.GetBlockHeaderVerbose(context.Background(), hash)
+	if err != nil {
+		return 0, err
+	}
+
+	return header.Time, nil
+}
@@ -0,0 +1,115 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ledgerhq/satstack/utils"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultRPCTimeout bounds the time spent waiting for an RPC call, unless
+	// overridden in the config (rpc_timeout).
+	defaultRPCTimeout = 30 * time.Second
+
+	// defaultRPCSlowTimeout bounds the time spent waiting for an RPC call
+	// known to be slow, unless overridden in the config (rpc_slow_timeout).
+	defaultRPCSlowTimeout = 5 * time.Minute
+
+	// rpcRetries indicates the number of times an idempotent RPC call is
+	// retried after a transient network error, with an exponential backoff
+	// starting at rpcRetryBackoff.
+	rpcRetries      = 2
+	rpcRetryBackoff = 250 * time.Millisecond
+)
+
+// slowRPCs are the RPC methods that are known to take long, and which are
+// bounded by the slow timeout instead (rpc_slow_timeout).
+//
+// Imports and rescans are not made with callRPC, since they block until the
+// wallet has scanned the chain, which may take hours.
+var slowRPCs = map[string]bool{
+	"getrawmempool": true,
+	"scantxoutset":  true,
+}
+
+// nonIdempotentRPCs are the RPC methods that are never retried, since the
+// first attempt may have reached bitcoind.
+var nonIdempotentRPCs = map[string]bool{
+	"abortrescan":        true,
+	"createwallet":       true,
+	"generateblock":      true,
+	"generatetoaddress":  true,
+	"importdescriptors":  true,
+	"importmulti":        true,
+	"loadwallet":         true,
+	"rescanblockchain":   true,
+	"sendrawtransaction": true,
+	"submitpackage":      true,
+	"unloadwallet":       true,
+}
+
+// callRPC performs an RPC call, implemented by call, on behalf of the
+// request carried by ctx. It is meant for query calls: the outcome of a call
+// changing the state of bitcoind, like a broadcast, must not be abandoned.
+//
+// The call is bounded by the timeout of the method, and cancelled if ctx is
+// done, for ex if the client of the HTTP request went away: call must pass
+// the ctx it is given to the client, which closes the HTTP request to
+// bitcoind in flight.
+//
+// Idempotent calls are retried on transient network errors, like a refused
+// connection while bitcoind restarts.
+func (b *Bus) callRPC(ctx context.Context, method string, call func(ctx context.Context) error) error {
+	timeout := b.rpcTimeout
+	if slowRPCs[method] {
+		timeout = b.rpcSlowTimeout
+	}
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	backoff := rpcRetryBackoff
+
+	for attempt := 0; ; attempt++ {
+		err := call(ctx)
+		if err != nil && ctx.Err() != nil {
+			return fmt.Errorf("%w: %s: %v", ErrRPCAborted, method, ctx.Err())
+		}
+
+		if err == nil || attempt == rpcRetries || nonIdempotentRPCs[method] || !isTransientError(err) {
+			return err
+		}
+
+		utils.Logger(ctx).WithFields(log.Fields{
+			"method":  method,
+			"error":   err,
+			"retryIn": backoff,
+		}).Debug("Retrying RPC call after transient error")
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %s: %v", ErrRPCAborted, method, ctx.Err())
+		}
+
+		backoff *= 2
+	}
+}
+
+// isTransientError returns true if the error was caused by a network
+// failure, like a refused or dropped connection, after which the call may
+// succeed.
+func isTransientError(err error) bool {
+	return isConnectionError(err) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,166 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ledgerhq/satstack/utils"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestCallRPCTimeout(t *testing.T) {
+	node := newFakeNode()
+	node.latency = time.Second
+	node.result("getblockcount", 100)
+
+	b := newTestBus(node)
+	b.rpcTimeout = 20 * time.Millisecond
+
+	// The ctx passed to the call is cancelled at the timeout, which aborts
+	// the request in flight instead of leaving it running in the background.
+	var callErr error
+	start := time.Now()
+	err := b.callRPC(context.Background(), "getblockcount", func(ctx context.Context) (err error) {
+		_, callErr = rawRequest(ctx, b.client, "getblockcount")
+		return callErr
+	})
+
+	if !errors.Is(err, ErrRPCAborted) {
+		t.Errorf("got error %v, want %v", err, ErrRPCAborted)
+	}
+
+	if !errors.Is(callErr, context.DeadlineExceeded) {
+		t.Errorf("call returned %v, want %v", callErr, context.DeadlineExceeded)
+	}
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("callRPC returned after %s, want about %s", elapsed, b.rpcTimeout)
+	}
+
+	if got := node.roundTrips(); got != 0 {
+		t.Errorf("got %d completed round trips, want 0", got)
+	}
+}
+
+func TestCallRPCCancel(t *testing.T) {
+	node := newFakeNode()
+	node.latency = time.Second
+
+	b := newTestBus(node)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	err := b.callRPC(ctx, "getblockcount", func(ctx context.Context) error {
+		_, err := rawRequest(ctx, b.client, "getblockcount")
+		return err
+	})
+
+	if !errors.Is(err, ErrRPCAborted) {
+		t.Errorf("got error %v, want %v", err, ErrRPCAborted)
+	}
+}
+
+func TestCallRPCRetry(t *testing.T) {
+	b := newTestBus(newFakeNode())
+
+	transient := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+	tests := []struct {
+		method string
+		err    error
+		calls  int
+	}{
+		{"getblockcount", transient, rpcRetries + 1},
+		{"sendrawtransaction", transient, 1},
+		{"getblockcount", errors.New("-5: Invalid address"), 1},
+	}
+
+	for _, test := range tests {
+		var calls int
+		err := b.callRPC(context.Background(), test.method, func(context.Context) error {
+			calls++
+			return test.err
+		})
+
+		if err != test.err {
+			t.Errorf("%s: got error %v, want %v", test.method, err, test.err)
+		}
+
+		if calls != test.calls {
+			t.Errorf("%s (%v): got %d calls, want %d", test.method, test.err, calls, test.calls)
+		}
+	}
+}
+
+func TestCallRPCRetryRequestID(t *testing.T) {
+	hook := test.NewGlobal()
+	defer hook.Reset()
+
+	level := log.GetLevel()
+	log.SetLevel(log.DebugLevel)
+	defer log.SetLevel(level)
+
+	b := newTestBus(newFakeNode())
+
+	transient := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	ctx := utils.WithRequestID(context.Background(), "abcd")
+
+	var calls int
+	_ = b.callRPC(ctx, "getblockcount", func(context.Context) error {
+		calls++
+		if calls > 1 {
+			return nil
+		}
+
+		return transient
+	})
+
+	entry := hook.LastEntry()
+	if entry == nil || entry.Data["request_id"] != "abcd" {
+		t.Errorf("got log entry %+v, want the retry logged with the request ID", entry)
+	}
+}
+
+func TestHTTPTransportCancel(t *testing.T) {
+	var cancelled int32
+
+	client := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		// The server only notices the closed connection once the body is
+		// read.
+		_, _ = io.Copy(ioutil.Discard, r.Body)
+
+		select {
+		case <-r.Context().Done():
+			atomic.StoreInt32(&cancelled, 1)
+		case <-time.After(5 * time.Second):
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := client.RawRequest(ctx, "getblockcount", []json.RawMessage{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// The server sees the request go away.
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&cancelled) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if atomic.LoadInt32(&cancelled) == 0 {
+		t.Error("the request to bitcoind was not cancelled")
+	}
+}
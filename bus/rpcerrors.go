@@ -0,0 +1,53 @@
+package bus
+
+import (
+	"errors"
+
+	"github.com/btcsuite/btcd/btcjson"
+)
+
+// ClassifyError returns the category of an error returned by a bitcoind
+// RPC, based on its RPC error code, so that callers do not depend on the
+// codes of bitcoind:
+//   - ErrNotFound for unknown transactions, blocks, or addresses
+//   - ErrInvalidParameter for parameters rejected by bitcoind
+//   - ErrTransactionRejected for transactions rejected by the mempool
+//   - ErrBitcoindUnreachable if bitcoind cannot be reached, is warming up,
+//     or has not loaded the wallet
+//   - ErrRPCAborted if the wait for the RPC was aborted
+//   - ErrRPCFailed for other RPC errors
+//
+// It returns nil if err is not an RPC error.
+func ClassifyError(err error) error {
+	var rpcErr *btcjson.RPCError
+
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, ErrRPCAborted):
+		return ErrRPCAborted
+	case errors.As(err, &rpcErr):
+		return classifyRPCErrorCode(rpcErr.Code)
+	case isConnectionError(err):
+		return ErrBitcoindUnreachable
+	default:
+		return nil
+	}
+}
+
+func classifyRPCErrorCode(code btcjson.RPCErrorCode) error {
+	switch code {
+	case btcjson.ErrRPCInvalidAddressOrKey:
+		return ErrNotFound
+	case btcjson.ErrRPCInvalidParameter, btcjson.ErrRPCType,
+		btcjson.ErrRPCDeserialization, btcjson.ErrRPCRawTxString:
+		return ErrInvalidParameter
+	case btcjson.ErrRPCVerify, btcjson.ErrRPCVerifyRejected, btcjson.ErrRPCVerifyAlreadyInChain:
+		return ErrTransactionRejected
+	case btcjson.ErrRPCInWarmup, btcjson.ErrRPCClientInInitialDownload,
+		btcjson.ErrRPCWalletNotFound, btcjson.ErrRPCWalletNotSpecified:
+		return ErrBitcoindUnreachable
+	default:
+		return ErrRPCFailed
+	}
+}
@@ -0,0 +1,38 @@
+package bus
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcjson"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"nil", nil, nil},
+		{"unknown transaction", btcjson.NewRPCError(btcjson.ErrRPCInvalidAddressOrKey, "No such mempool or blockchain transaction"), ErrNotFound},
+		{"invalid parameter", btcjson.NewRPCError(btcjson.ErrRPCInvalidParameter, "Invalid parameter"), ErrInvalidParameter},
+		{"deserialization", btcjson.NewRPCError(btcjson.ErrRPCDeserialization, "TX decode failed"), ErrInvalidParameter},
+		{"rejected", btcjson.NewRPCError(btcjson.ErrRPCVerifyRejected, "min relay fee not met"), ErrTransactionRejected},
+		{"already in chain", btcjson.NewRPCError(btcjson.ErrRPCVerifyAlreadyInChain, "Transaction already in block chain"), ErrTransactionRejected},
+		{"warmup", btcjson.NewRPCError(btcjson.ErrRPCInWarmup, "Loading block index..."), ErrBitcoindUnreachable},
+		{"wallet not loaded", btcjson.NewRPCError(btcjson.ErrRPCWalletNotFound, "Requested wallet does not exist or is not loaded"), ErrBitcoindUnreachable},
+		{"other RPC error", btcjson.NewRPCError(btcjson.ErrRPCMisc, "Internal bug detected"), ErrRPCFailed},
+		{"wrapped RPC error", fmt.Errorf("%s: %w", ErrFailedToGetBlock, btcjson.NewRPCError(btcjson.ErrRPCInvalidAddressOrKey, "Block not found")), ErrNotFound},
+		{"connection refused", &url.Error{Op: "Post", URL: "http://localhost:8332", Err: errors.New("connection refused")}, ErrBitcoindUnreachable},
+		{"aborted", fmt.Errorf("%w: getblock", ErrRPCAborted), ErrRPCAborted},
+		{"not an RPC error", errors.New("unexpected"), nil},
+	}
+
+	for _, test := range tests {
+		if got := ClassifyError(test.err); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
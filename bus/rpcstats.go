@@ -0,0 +1,184 @@
+package bus
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// rpcStatsSamples indicates the number of most recent latencies kept
+	// for each RPC method, from which the quantiles are computed.
+	rpcStatsSamples = 1024
+
+	// rpcStatsLogInterval indicates the interval at which a summary of the
+	// RPC stats is logged, at the debug level.
+	rpcStatsLogInterval = 5 * time.Minute
+)
+
+// RPCMethodStats summarizes the RPC calls made to bitcoind with a method.
+// The latencies are in milliseconds, and computed from the most recent
+// calls only.
+type RPCMethodStats struct {
+	Method  string  `json:"method"`
+	Calls   int64   `json:"calls"`
+	Errors  int64   `json:"errors"`
+	Samples int     `json:"samples"` // number of latencies the quantiles are computed from
+	P50     float64 `json:"p50_ms"`
+	P95     float64 `json:"p95_ms"`
+	P99     float64 `json:"p99_ms"`
+}
+
+// RPCStats summarizes the RPC calls made to bitcoind since Since, in RFC3339
+// format, by method.
+type RPCStats struct {
+	Since   string           `json:"since"`
+	Methods []RPCMethodStats `json:"methods"`
+}
+
+// rpcMethodStats records the calls made with an RPC method. The latencies
+// are kept in a ring buffer, indexed by the number of samples recorded. All
+// fields are accessed atomically.
+type rpcMethodStats struct {
+	calls   int64
+	errors  int64
+	next    uint64
+	samples [rpcStatsSamples]int64 // in nanoseconds
+}
+
+// rpcStats records the calls made by the rpcClient of a Bus. It is
+// safe for concurrent use, and recording a call takes no lock once the
+// method has been seen.
+type rpcStats struct {
+	methods sync.Map // method name -> *rpcMethodStats
+	since   int64    // UNIX time of the last reset, in nanoseconds
+}
+
+func newRPCStats() *rpcStats {
+	return &rpcStats{since: time.Now().UnixNano()}
+}
+
+// observe records a call with the given method.
+func (s *rpcStats) observe(method string, duration time.Duration, err error) {
+	value, ok := s.methods.Load(method)
+	if !ok {
+		value, _ = s.methods.LoadOrStore(method, &rpcMethodStats{})
+	}
+
+	stats := value.(*rpcMethodStats)
+
+	atomic.AddInt64(&stats.calls, 1)
+	if err != nil {
+		atomic.AddInt64(&stats.errors, 1)
+	}
+
+	idx := atomic.AddUint64(&stats.next, 1) - 1
+	atomic.StoreInt64(&stats.samples[idx%rpcStatsSamples], int64(duration))
+}
+
+// snapshot returns the stats of every method called since the last reset,
+// sorted by method.
+func (s *rpcStats) snapshot() RPCStats {
+	ret := RPCStats{
+		Since:   time.Unix(0, atomic.LoadInt64(&s.since)).UTC().Format(time.RFC3339),
+		Methods: []RPCMethodStats{},
+	}
+
+	s.methods.Range(func(key, value interface{}) bool {
+		stats := value.(*rpcMethodStats)
+
+		count := atomic.LoadUint64(&stats.next)
+		if count > rpcStatsSamples {
+			count = rpcStatsSamples
+		}
+
+		samples := make([]int64, count)
+		for idx := range samples {
+			samples[idx] = atomic.LoadInt64(&stats.samples[idx])
+		}
+
+		sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+
+		ret.Methods = append(ret.Methods, RPCMethodStats{
+			Method:  key.(string),
+			Calls:   atomic.LoadInt64(&stats.calls),
+			Errors:  atomic.LoadInt64(&stats.errors),
+			Samples: len(samples),
+			P50:     quantile(samples, 0.50),
+			P95:     quantile(samples, 0.95),
+			P99:     quantile(samples, 0.99),
+		})
+
+		return true
+	})
+
+	sort.Slice(ret.Methods, func(i, j int) bool {
+		return ret.Methods[i].Method < ret.Methods[j].Method
+	})
+
+	return ret
+}
+
+// reset forgets the calls recorded so far. The calls in flight may be
+// recorded either before or after the reset.
+func (s *rpcStats) reset() {
+	s.methods.Range(func(key, _ interface{}) bool {
+		s.methods.Delete(key)
+		return true
+	})
+
+	atomic.StoreInt64(&s.since, time.Now().UnixNano())
+}
+
+// quantile returns the q-quantile of the sorted latencies in nanoseconds,
+// in milliseconds, using the nearest-rank method.
+func quantile(sorted []int64, q float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	rank := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+
+	return float64(sorted[rank]) / float64(time.Millisecond)
+}
+
+// RPCStats returns the stats of the RPC calls made to bitcoind since the
+// last reset.
+func (b *Bus) RPCStats() RPCStats {
+	return b.rpcStats.snapshot()
+}
+
+// ResetRPCStats forgets the RPC calls recorded so far.
+func (b *Bus) ResetRPCStats() {
+	b.rpcStats.reset()
+}
+
+// logRPCStats periodically logs a summary of the RPC stats, if the debug
+// level is enabled, until the context is cancelled.
+func logRPCStats(ctx context.Context, b *Bus) {
+	for sleep(ctx, rpcStatsLogInterval) {
+		if !log.IsLevelEnabled(log.DebugLevel) {
+			continue
+		}
+
+		for _, stats := range b.RPCStats().Methods {
+			log.WithFields(log.Fields{
+				"prefix": "rpc",
+				"method": stats.Method,
+				"calls":  stats.Calls,
+				"errors": stats.Errors,
+				"p50_ms": stats.P50,
+				"p95_ms": stats.P95,
+				"p99_ms": stats.P99,
+			}).Debug("RPC stats")
+		}
+	}
+}
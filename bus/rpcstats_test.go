@@ -0,0 +1,131 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRPCStatsQuantiles(t *testing.T) {
+	stats := newRPCStats()
+
+	// Latencies of 1 to 100 ms, in reverse order.
+	for ms := 100; ms >= 1; ms-- {
+		var err error
+		if ms%10 == 0 {
+			err = errors.New("failed")
+		}
+
+		stats.observe("getblock", time.Duration(ms)*time.Millisecond, err)
+	}
+
+	got := methodStats(stats, "getblock")
+	want := RPCMethodStats{Method: "getblock", Calls: 100, Errors: 10, Samples: 100, P50: 50, P95: 95, P99: 99}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if got := methodStats(stats, "getblockcount"); got.Calls != 0 || got.Samples != 0 {
+		t.Errorf("got %+v for a method never called", got)
+	}
+}
+
+func TestRPCStatsRingBuffer(t *testing.T) {
+	stats := newRPCStats()
+
+	for idx := 0; idx < rpcStatsSamples; idx++ {
+		stats.observe("getblock", time.Second, nil)
+	}
+
+	// The latencies of the most recent calls replace the oldest ones.
+	for idx := 0; idx < rpcStatsSamples; idx++ {
+		stats.observe("getblock", time.Millisecond, nil)
+	}
+
+	got := methodStats(stats, "getblock")
+	if got.Calls != 2*rpcStatsSamples || got.Samples != rpcStatsSamples || got.P99 != 1 {
+		t.Errorf("got %+v, want %d calls, and the quantiles of the last %d", got, 2*rpcStatsSamples, rpcStatsSamples)
+	}
+}
+
+func TestRPCStatsReset(t *testing.T) {
+	stats := newRPCStats()
+	stats.observe("getblock", time.Millisecond, nil)
+
+	stats.since = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano()
+	since := stats.snapshot().Since
+
+	stats.reset()
+
+	snapshot := stats.snapshot()
+	if len(snapshot.Methods) != 0 || snapshot.Since == since {
+		t.Errorf("got %+v after a reset, want no methods since the reset", snapshot)
+	}
+
+	stats.observe("getblock", time.Millisecond, nil)
+	if got := methodStats(stats, "getblock"); got.Calls != 1 {
+		t.Errorf("got %d calls after a reset, want 1", got.Calls)
+	}
+}
+
+func TestRPCStatsConcurrent(t *testing.T) {
+	const goroutines, calls = 8, 1000
+
+	stats := newRPCStats()
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for idx := 0; idx < calls; idx++ {
+				stats.observe("getblock", time.Millisecond, nil)
+				if idx%100 == 0 {
+					stats.snapshot()
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if got := methodStats(stats, "getblock"); got.Calls != goroutines*calls || got.Samples != rpcStatsSamples {
+		t.Errorf("got %+v, want %d calls", got, goroutines*calls)
+	}
+}
+
+// BenchmarkRPCStatsObserve measures the overhead of recording a call, which
+// must remain negligible next to the round trip to bitcoind.
+func BenchmarkRPCStatsObserve(b *testing.B) {
+	stats := newRPCStats()
+	stats.observe("getblock", time.Millisecond, nil)
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			stats.observe("getblock", time.Millisecond, nil)
+		}
+	})
+}
+
+func TestBusRPCStats(t *testing.T) {
+	chain := newPrevoutsChain()
+	b := newTestBus(chain.node())
+
+	if _, err := b.GetBlock(context.Background(), chain.hash); err != nil {
+		t.Fatal(err)
+	}
+
+	// The calls of the Bus are recorded by its client.
+	if got := methodStats(b.rpcStats, "getblock"); got.Calls != 1 {
+		t.Errorf("got %d getblock calls in the stats, want 1", got.Calls)
+	}
+
+	b.ResetRPCStats()
+	if got := b.RPCStats(); len(got.Methods) != 0 {
+		t.Errorf("got stats %+v after a reset, want none", got)
+	}
+}
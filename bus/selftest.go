@@ -0,0 +1,384 @@
+package bus
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ledgerhq/satstack/config"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// SelfTestResult is the outcome of a check of the self-test.
+type SelfTestResult = string
+
+const (
+	SelfTestPass SelfTestResult = "pass"
+	SelfTestFail SelfTestResult = "fail"
+	SelfTestSkip SelfTestResult = "skip"
+)
+
+// SelfTestOutcome is the overall outcome of the self-test.
+type SelfTestOutcome = string
+
+const (
+	SelfTestPassed      SelfTestOutcome = "pass"        // no check failed
+	SelfTestPartial     SelfTestOutcome = "partial"     // some checks failed
+	SelfTestUnreachable SelfTestOutcome = "unreachable" // bitcoind could not be queried at all
+)
+
+// SelfTestCheck reports a check of the self-test.
+type SelfTestCheck struct {
+	Name     string         `json:"name"`
+	Result   SelfTestResult `json:"result"`
+	Message  string         `json:"message"`
+	Duration float64        `json:"duration_ms"`
+}
+
+// SelfTestReport reports the checks of the self-test, in the order in which
+// they were run, and the total time they took.
+type SelfTestReport struct {
+	Result   SelfTestOutcome `json:"result"`
+	Duration float64         `json:"duration_ms"`
+	Checks   []SelfTestCheck `json:"checks"`
+}
+
+// selfTest runs the checks of the self-test in sequence. A check reads the
+// results of the previous ones, and may skip itself.
+type selfTest struct {
+	report SelfTestReport
+	start  time.Time
+
+	info        *btcjson.GetBlockChainInfoResult
+	networkInfo *btcjson.GetNetworkInfoResult
+}
+
+// run runs the check with the given name, timing it. The check returns its
+// result, along with a message.
+func (t *selfTest) run(name string, check func() (SelfTestResult, string)) SelfTestResult {
+	start := time.Now()
+	result, message := check()
+
+	t.report.Checks = append(t.report.Checks, SelfTestCheck{
+		Name:     name,
+		Result:   result,
+		Message:  message,
+		Duration: float64(time.Since(start)) / float64(time.Millisecond),
+	})
+
+	return result
+}
+
+// skip records the check with the given name as skipped.
+func (t *selfTest) skip(name string, message string) {
+	t.run(name, func() (SelfTestResult, string) {
+		return SelfTestSkip, message
+	})
+}
+
+// SelfTest checks the whole read path from SatStack to bitcoind, on a
+// dedicated connection, to diagnose a broken setup: that bitcoind is
+// reachable and accepts the credentials, that its chain and features match
+// the configuration, that the wallet has the descriptors of the accounts,
+// and that a block and a fee estimate can be fetched.
+//
+// If broadcast is true, a transaction spending an outpoint that does not
+// exist is also submitted to testmempoolaccept, to check that the broadcast
+// path reaches the node. Nothing is ever broadcast.
+//
+// The checks that depend on a failed one are skipped. Every RPC is bounded
+// by the RPC timeout, and aborted if ctx is done.
+func (b *Bus) SelfTest(ctx context.Context, broadcast bool) SelfTestReport {
+	t := &selfTest{start: time.Now()}
+
+	reachable := t.run("rpc", func() (SelfTestResult, string) {
+		var info *btcjson.GetNetworkInfoResult
+		err := b.callRPC(ctx, "getnetworkinfo", func(ctx context.Context) error {
+			var err error
+			info, err = b.client.GetNetworkInfo(ctx)
+			return err
+		})
+
+		switch {
+		case err == nil:
+			t.networkInfo = info
+			return SelfTestPass, fmt.Sprintf("reachable, bitcoind %s (%s)",
+				formatVersion(info.Version), info.SubVersion)
+		case isAuthError(err):
+			return SelfTestPass, "reachable"
+		case isCertificateError(err):
+			return SelfTestFail, fmt.Sprintf("%s: %s", ErrTLSVerification, err)
+		default:
+			return SelfTestFail, fmt.Sprintf("%s: %s", ErrBitcoindUnreachable, err)
+		}
+	})
+
+	if reachable != SelfTestPass {
+		t.skip("auth", "bitcoind unreachable")
+		return t.finish(SelfTestUnreachable)
+	}
+
+	authenticated := t.run("auth", func() (SelfTestResult, string) {
+		method := "user and password"
+		if b.connCfg.CookiePath != "" {
+			method = "cookie " + b.connCfg.CookiePath
+		}
+
+		if t.networkInfo == nil {
+			return SelfTestFail, fmt.Sprintf("credentials rejected (%s)", method)
+		}
+
+		return SelfTestPass, fmt.Sprintf("authenticated (%s)", method)
+	})
+
+	if authenticated != SelfTestPass {
+		return t.finish(SelfTestUnreachable)
+	}
+
+	warm := t.run("chain", func() (SelfTestResult, string) {
+		var info *btcjson.GetBlockChainInfoResult
+		err := b.callRPC(ctx, "getblockchaininfo", func(ctx context.Context) error {
+			var err error
+			info, err = b.client.GetBlockChainInfo(ctx)
+			return err
+		})
+		if message, ok := warmupMessage(err); ok {
+			return SelfTestFail, fmt.Sprintf("warming up (%s)", message)
+		}
+
+		if err != nil {
+			return SelfTestFail, err.Error()
+		}
+
+		t.info = info
+
+		if t.info.Chain != b.Chain {
+			return SelfTestFail, fmt.Sprintf("chain %s, but SatStack started on %s; restart SatStack",
+				t.info.Chain, b.Chain)
+		}
+
+		accounts := b.configuredAccounts()
+		if err := (config.Configuration{Accounts: accounts}).ValidateChain(b.Params); err != nil {
+			return SelfTestFail, err.Error()
+		}
+
+		return SelfTestPass, fmt.Sprintf("chain %s, matching the %d accounts configured",
+			t.info.Chain, len(accounts))
+	})
+
+	if warm != SelfTestPass && t.info == nil {
+		return t.finish(SelfTestUnreachable)
+	}
+
+	t.run("capabilities", func() (SelfTestResult, string) {
+		caps, err := probeCapabilities(b.client, t.info, t.networkInfo.Version, b.requireTxIndex)
+		if caps == nil {
+			return SelfTestFail, err.Error()
+		}
+
+		message := fmt.Sprintf("txindex: %t, pruned: %t, block_filter: %t",
+			caps.TxIndex, caps.Pruned, caps.BlockFilter)
+		if caps.Pruned {
+			message += fmt.Sprintf(" (from height %d)", caps.PruneHeight)
+		}
+
+		if err != nil {
+			return SelfTestFail, fmt.Sprintf("%s; %s", message, err)
+		}
+
+		if len(caps.Warnings) > 0 {
+			message += "; " + strings.Join(caps.Warnings, "; ")
+		}
+
+		return SelfTestPass, message
+	})
+
+	t.run("wallet", func() (SelfTestResult, string) {
+		err := b.callRPC(ctx, "getwalletinfo", func(ctx context.Context) error {
+			_, err := b.client.GetWalletInfo(ctx)
+			return err
+		})
+		if err != nil {
+			return SelfTestFail, fmt.Sprintf("%s: %s", ErrLoadWallet, err)
+		}
+
+		if !b.WalletEnabled() {
+			return SelfTestPass, fmt.Sprintf("wallet %s loaded, no accounts configured", b.WalletName)
+		}
+
+		accounts, err := b.ListDescriptors()
+		if err != nil {
+			return SelfTestFail, err.Error()
+		}
+
+		var expected, missing int
+		for _, account := range accounts {
+			for _, info := range []*DescriptorInfo{&account.External, account.Internal} {
+				if info == nil {
+					continue
+				}
+
+				expected++
+				if !info.Present {
+					missing++
+				}
+			}
+		}
+
+		switch {
+		case missing == 0:
+			return SelfTestPass, fmt.Sprintf("wallet %s loaded, with the %d descriptors of the accounts",
+				b.WalletName, expected)
+		case b.IsPendingScan() || atomic.LoadInt32(&b.importing) > 0:
+			return SelfTestSkip, fmt.Sprintf("wallet %s loaded, %d of %d descriptors being imported",
+				b.WalletName, missing, expected)
+		default:
+			return SelfTestFail, fmt.Sprintf("wallet %s loaded, but %d of %d descriptors missing",
+				b.WalletName, missing, expected)
+		}
+	})
+
+	t.run("block", func() (SelfTestResult, string) {
+		hash, err := chainhash.NewHashFromStr(t.info.BestBlockHash)
+		if err != nil {
+			return SelfTestFail, err.Error()
+		}
+
+		var block *btcjson.GetBlockVerboseResult
+		err = b.callRPC(ctx, "getblock", func(ctx context.Context) error {
+			var err error
+			block, err = b.client.GetBlockVerbose(ctx, hash)
+			return err
+		})
+		if err != nil {
+			return SelfTestFail, err.Error()
+		}
+
+		return SelfTestPass, fmt.Sprintf("block %d at tip, with %d transactions", block.Height, len(block.Tx))
+	})
+
+	t.run("fees", func() (SelfTestResult, string) {
+		var estimate *btcjson.EstimateSmartFeeResult
+		err := b.callRPC(ctx, "estimatesmartfee", func(ctx context.Context) error {
+			var err error
+			estimate, err = b.client.EstimateSmartFee(ctx, 2, getMode(""))
+			return err
+		})
+		if err != nil {
+			return SelfTestFail, err.Error()
+		}
+
+		if estimate.FeeRate == nil {
+			// Typical of regtest, or of a node that just started.
+			return SelfTestSkip, fmt.Sprintf("no estimate: %s", strings.Join(estimate.Errors, "; "))
+		}
+
+		return SelfTestPass, fmt.Sprintf("%g BTC/kvB for 2 blocks", *estimate.FeeRate)
+	})
+
+	t.runBroadcast(ctx, b, broadcast)
+
+	return t.finish(SelfTestPassed)
+}
+
+// runBroadcast submits a transaction spending an outpoint that does not exist
+// to testmempoolaccept, which must reject it, if broadcast is true.
+func (t *selfTest) runBroadcast(ctx context.Context, b *Bus, broadcast bool) {
+	if !broadcast {
+		t.skip("broadcast", "not requested, use ?broadcast=true")
+		return
+	}
+
+	t.run("broadcast", func() (SelfTestResult, string) {
+		tx, err := invalidTransaction()
+		if err != nil {
+			return SelfTestFail, err.Error()
+		}
+
+		var results []testMempoolAcceptResult
+		err = b.callRPC(ctx, "testmempoolaccept", func(ctx context.Context) error {
+			result, err := rawRequest(ctx, b.client, "testmempoolaccept", []string{tx})
+			if err != nil {
+				return err
+			}
+
+			return json.Unmarshal(result, &results)
+		})
+		if err != nil {
+			return SelfTestFail, err.Error()
+		}
+
+		if len(results) != 1 || results[0].Allowed {
+			return SelfTestFail, "invalid transaction not rejected by testmempoolaccept"
+		}
+
+		return SelfTestPass, fmt.Sprintf("invalid transaction rejected as expected (%s)", results[0].RejectReason)
+	})
+}
+
+// finish completes the report. If bitcoind is unreachable, the remaining
+// checks are skipped; otherwise, the outcome is partial if any check failed.
+func (t *selfTest) finish(outcome SelfTestOutcome) SelfTestReport {
+	if outcome == SelfTestUnreachable {
+		for _, name := range []string{"chain", "capabilities", "wallet", "block", "fees", "broadcast"} {
+			if !t.has(name) {
+				t.skip(name, "bitcoind unreachable")
+			}
+		}
+	}
+
+	if outcome == SelfTestPassed {
+		for _, check := range t.report.Checks {
+			if check.Result == SelfTestFail {
+				outcome = SelfTestPartial
+			}
+		}
+	}
+
+	t.report.Result = outcome
+	t.report.Duration = float64(time.Since(t.start)) / float64(time.Millisecond)
+
+	return t.report
+}
+
+// has indicates whether the check with the given name was run.
+func (t *selfTest) has(name string) bool {
+	for _, check := range t.report.Checks {
+		if check.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// invalidTransaction returns a hex-encoded transaction spending an outpoint
+// that does not exist, which bitcoind always rejects.
+func invalidTransaction() (string, error) {
+	tx := wire.NewMsgTx(wire.TxVersion)
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{0x01}, 0), nil, nil))
+	tx.AddTxOut(wire.NewTxOut(1000, []byte{0x51})) // OP_TRUE
+
+	var buf bytes.Buffer
+	if err := tx.Serialize(&buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf.Bytes()), nil
+}
+
+// isAuthError returns true if bitcoind rejected the credentials of an RPC
+// call. Since bitcoind answers with an empty body, rpcclient only reports
+// the HTTP status code.
+func isAuthError(err error) bool {
+	return err != nil && (strings.Contains(err.Error(), "status code: 401") ||
+		strings.Contains(err.Error(), "status code: 403"))
+}
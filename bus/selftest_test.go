@@ -0,0 +1,165 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcjson"
+)
+
+// newSelfTestNode returns a fakeNode on regtest passing every check of the
+// self-test, except for the fee estimate, which regtest lacks.
+func newSelfTestNode() *fakeNode {
+	node := newSyncedNode()
+
+	node.result("getnetworkinfo", map[string]interface{}{
+		"version":    250000,
+		"subversion": "/Satoshi:25.0.0/",
+	})
+	node.result("getblockhash", mockBlockHash('a', 1))
+	node.result("getblock", map[string]interface{}{
+		"hash":   mockBlockHash('a', 10),
+		"height": 10,
+		"tx":     []string{mockBlockHash('t', 1)},
+	})
+	node.result("estimatesmartfee", map[string]interface{}{
+		"errors": []string{"Insufficient data or no feerate found"},
+		"blocks": 2,
+	})
+	node.result("testmempoolaccept", []map[string]interface{}{
+		{"txid": mockBlockHash('t', 2), "allowed": false, "reject-reason": "missing-inputs"},
+	})
+
+	return node
+}
+
+// checkResults checks the results of the checks of the report, by name.
+func checkResults(t *testing.T, report SelfTestReport, want map[string]SelfTestResult) {
+	t.Helper()
+
+	if len(report.Checks) != 8 {
+		t.Errorf("got %d checks, want 8", len(report.Checks))
+	}
+
+	for _, check := range report.Checks {
+		if result, ok := want[check.Name]; ok && check.Result != result {
+			t.Errorf("%s: got %s (%s), want %s", check.Name, check.Result, check.Message, result)
+		}
+	}
+}
+
+func TestSelfTestPassed(t *testing.T) {
+	node := newSelfTestNode()
+	b := newTestBus(node)
+
+	report := b.SelfTest(context.Background(), true)
+	if report.Result != SelfTestPassed {
+		t.Fatalf("got result %s, want %s: %+v", report.Result, SelfTestPassed, report.Checks)
+	}
+
+	checkResults(t, report, map[string]SelfTestResult{
+		"rpc":          SelfTestPass,
+		"auth":         SelfTestPass,
+		"chain":        SelfTestPass,
+		"capabilities": SelfTestPass,
+		"wallet":       SelfTestPass,
+		"block":        SelfTestPass,
+		"fees":         SelfTestSkip,
+		"broadcast":    SelfTestPass,
+	})
+
+	// Nothing is submitted unless requested.
+	report = b.SelfTest(context.Background(), false)
+	if report.Result != SelfTestPassed || node.count("testmempoolaccept") != 1 {
+		t.Errorf("got result %s, %d testmempoolaccept calls, want the broadcast skipped",
+			report.Result, node.count("testmempoolaccept"))
+	}
+}
+
+func TestSelfTestPartial(t *testing.T) {
+	node := newSelfTestNode()
+
+	// bitcoind is on another chain, and accepts the invalid transaction.
+	node.result("getblockchaininfo", map[string]interface{}{
+		"chain":         "test",
+		"blocks":        10,
+		"headers":       10,
+		"bestblockhash": mockBlockHash('a', 10),
+	})
+	node.result("testmempoolaccept", []map[string]interface{}{
+		{"txid": mockBlockHash('t', 2), "allowed": true},
+	})
+
+	report := newTestBus(node).SelfTest(context.Background(), true)
+	if report.Result != SelfTestPartial {
+		t.Fatalf("got result %s, want %s: %+v", report.Result, SelfTestPartial, report.Checks)
+	}
+
+	// The checks that do not depend on the chain are run nevertheless.
+	checkResults(t, report, map[string]SelfTestResult{
+		"chain":     SelfTestFail,
+		"wallet":    SelfTestPass,
+		"block":     SelfTestPass,
+		"broadcast": SelfTestFail,
+	})
+
+	if !strings.Contains(report.Checks[2].Message, "restart SatStack") {
+		t.Errorf("got message %q, want the chain mismatch", report.Checks[2].Message)
+	}
+}
+
+func TestSelfTestUnreachable(t *testing.T) {
+	tests := []struct {
+		name string
+		node func(node *fakeNode)
+		want map[string]SelfTestResult
+	}{
+		{
+			"unreachable",
+			func(node *fakeNode) {
+				node.handle("getnetworkinfo", func([]json.RawMessage) (interface{}, error) {
+					return nil, errors.New("malformed HTTP response")
+				})
+			},
+			map[string]SelfTestResult{"rpc": SelfTestFail, "auth": SelfTestSkip, "chain": SelfTestSkip},
+		},
+		{
+			"credentials rejected",
+			func(node *fakeNode) {
+				node.handle("getnetworkinfo", func([]json.RawMessage) (interface{}, error) {
+					return nil, errors.New("status code: 401, response: \"\"")
+				})
+			},
+			map[string]SelfTestResult{"rpc": SelfTestPass, "auth": SelfTestFail, "chain": SelfTestSkip},
+		},
+		{
+			"warming up",
+			func(node *fakeNode) {
+				node.handle("getblockchaininfo", func([]json.RawMessage) (interface{}, error) {
+					return nil, btcjson.NewRPCError(btcjson.ErrRPCInWarmup, "Loading block index...")
+				})
+			},
+			map[string]SelfTestResult{"rpc": SelfTestPass, "auth": SelfTestPass, "chain": SelfTestFail, "block": SelfTestSkip},
+		},
+	}
+
+	for _, test := range tests {
+		node := newSelfTestNode()
+		test.node(node)
+
+		report := newTestBus(node).SelfTest(context.Background(), true)
+		if report.Result != SelfTestUnreachable {
+			t.Errorf("%s: got result %s, want %s: %+v", test.name, report.Result, SelfTestUnreachable, report.Checks)
+			continue
+		}
+
+		checkResults(t, report, test.want)
+
+		if node.count("testmempoolaccept") != 0 {
+			t.Errorf("%s: got the broadcast checked, want it skipped", test.name)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package bus
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ledgerhq/satstack/utils"
+)
+
+// State models the data persisted by SatStack across restarts. It is stored
+// as JSON in a file next to the configuration file.
+type State struct {
+	// Descriptors successfully imported into the Bitcoin Core wallet.
+	Descriptors []ImportedDescriptor `json:"descriptors"`
+
+	// FirstSeen is the UNIX time, in seconds, at which each wallet
+	// transaction was first seen unconfirmed, by txid.
+	FirstSeen map[string]int64 `json:"first_seen,omitempty"`
+
+	path  string
+	mutex sync.Mutex
+}
+
+// ImportedDescriptor models a descriptor that was successfully imported,
+// along with the parameters used for the import.
+type ImportedDescriptor struct {
+	Descriptor string `json:"descriptor"` // canonical form, with checksum
+	Depth      int    `json:"depth"`      // number of addresses imported
+	Timestamp  uint32 `json:"timestamp"`  // rescan timestamp
+}
+
+// LoadState reads the state file at the given path. A missing file is not an
+// error, and yields an empty State that will be written to the same path.
+func LoadState(path string) (*State, error) {
+	state := &State{path: path}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// covers returns true if the descriptor was imported with a depth and a
+// rescan timestamp at least as generous as the ones requested.
+func (s *State) covers(d descriptor) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, imported := range s.Descriptors {
+		if imported.Descriptor == d.Value &&
+			imported.Depth >= d.Depth &&
+			imported.Timestamp <= d.Age {
+			return true
+		}
+	}
+
+	return false
+}
+
+// importedDepth returns the depth with which the descriptor was imported, or
+// zero if it was not.
+func (s *State) importedDepth(value string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, imported := range s.Descriptors {
+		if imported.Descriptor == value {
+			return imported.Depth
+		}
+	}
+
+	return 0
+}
+
+// importedTimestamp returns the rescan timestamp with which the descriptor
+// was imported, and false if it was not.
+func (s *State) importedTimestamp(value string) (uint32, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, imported := range s.Descriptors {
+		if imported.Descriptor == value {
+			return imported.Timestamp, true
+		}
+	}
+
+	return 0, false
+}
+
+// addDescriptors records the given descriptors as imported, replacing any
+// previous record of the same descriptor, and persists the State.
+func (s *State) addDescriptors(descriptors []descriptor) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, d := range descriptors {
+		record := ImportedDescriptor{
+			Descriptor: d.Value,
+			Depth:      d.Depth,
+			Timestamp:  d.Age,
+		}
+
+		var found bool
+		for idx, imported := range s.Descriptors {
+			if imported.Descriptor == d.Value {
+				s.Descriptors[idx] = record
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			s.Descriptors = append(s.Descriptors, record)
+		}
+	}
+
+	return s.save()
+}
+
+// firstSeen returns the time at which the transaction was first seen
+// unconfirmed, if it was.
+func (s *State) firstSeen(txid string) (int64, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	seen, ok := s.FirstSeen[txid]
+	return seen, ok
+}
+
+// addFirstSeen records the first-seen time of the transactions that were not
+// seen yet, forgets the ones seen before the retention, and persists the
+// State if it changed.
+func (s *State) addFirstSeen(seen map[string]int64, now time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var changed bool
+	expiry := now.Add(-firstSeenRetention).Unix()
+
+	if s.FirstSeen == nil {
+		s.FirstSeen = make(map[string]int64, len(seen))
+	}
+
+	for txid, timestamp := range seen {
+		if _, ok := s.FirstSeen[txid]; !ok && timestamp >= expiry {
+			s.FirstSeen[txid] = timestamp
+			changed = true
+		}
+	}
+
+	for txid, timestamp := range s.FirstSeen {
+		if timestamp < expiry {
+			delete(s.FirstSeen, txid)
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+
+	return s.save()
+}
+
+// save writes the State to disk atomically, with utils.WriteFileAtomic, so
+// that a crash during the write never leaves a corrupt state file behind.
+//
+// The caller must hold the mutex.
+func (s *State) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return utils.WriteFileAtomic(s.path, data, 0600)
+}
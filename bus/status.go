@@ -1,12 +1,42 @@
 package bus
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/ledgerhq/satstack/metrics"
+	"github.com/ledgerhq/satstack/version"
+
+	"github.com/btcsuite/btcd/btcjson"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// statusRefreshInterval is the interval at which the worker refreshes
+	// the cached Status.
+	statusRefreshInterval = 7 * time.Second
+
+	// statusMaxAge is the age beyond which the cached Status is considered
+	// stale, for ex if bitcoind hangs while the Status is being refreshed.
+	statusMaxAge = 3 * statusRefreshInterval
+
+	// warmupRetryInterval is the interval at which the Status is refreshed
+	// while bitcoind is warming up, so that SatStack becomes available as
+	// soon as bitcoind has finished loading.
+	warmupRetryInterval = 2 * time.Second
+)
+
 // Status indicates the state of LSS with regards to the readiness of the
 // connected Bitcoin Core node.
 type Status string
 
 const (
 	// Initializing is a Status to indicate the initial state of LSS, while it
-	// is warming up.
+	// is warming up, or while bitcoind is warming up, for ex loading its
+	// block index.
 	Initializing Status = "initializing"
 
 	// NodeDisconnected is a Status to indicate that the bitcoind instance is
@@ -26,7 +56,7 @@ const (
 	// of descriptors. This is typically the case when LSS is launched, while it
 	// is "running the numbers".
 	//
-	// Use this Status when Bus.IsPendingScan is set to true.
+	// Use this Status when Bus.IsPendingScan returns true.
 	PendingScan Status = "pending-scan"
 
 	// Scanning is a Status to indicate that the Bitcoin Core node is currently
@@ -34,16 +64,415 @@ const (
 	Scanning Status = "scanning"
 )
 
+// Statuses is the list of all known Status values.
+var Statuses = []Status{
+	Initializing,
+	NodeDisconnected,
+	Ready,
+	Syncing,
+	PendingScan,
+	Scanning,
+}
+
 // ExplorerStatus represents the structure of payload returned by GetStatus
 // service method.
 type ExplorerStatus struct {
 	Version      string   `json:"version"`
+	NodeVersion  string   `json:"node_version"`
 	TxIndex      bool     `json:"txindex"`
 	BlockFilter  bool     `json:"block_filter"`
 	Pruned       bool     `json:"pruned"`
+	PruneHeight  *int32   `json:"prune_height,omitempty"`
 	Chain        string   `json:"chain"`
 	Currency     Currency `json:"currency"`
+	Wallet       string   `json:"wallet"`
+	ZMQ          bool     `json:"zmq"`
 	Status       Status   `json:"status"`
+	StatusDetail string   `json:"status_detail,omitempty"`
 	SyncProgress *float64 `json:"sync_progress,omitempty"`
 	ScanProgress *float64 `json:"scan_progress,omitempty"`
+
+	// Start time, rate (in percent per hour) and estimated time remaining of
+	// the initial block download, while Status is Syncing.
+	SyncStartedAt   *time.Time `json:"sync_started_at,omitempty"`
+	SyncETASeconds  *int64     `json:"sync_eta_seconds,omitempty"`
+	SyncRatePerHour *float64   `json:"sync_rate_per_hour,omitempty"`
+
+	// Start time, rate (in percent per hour) and estimated time remaining of
+	// the wallet rescan, while Status is Scanning.
+	ScanStartedAt   *time.Time `json:"scan_started_at,omitempty"`
+	ScanETASeconds  *int64     `json:"scan_eta_seconds,omitempty"`
+	ScanRatePerHour *float64   `json:"scan_rate_per_hour,omitempty"`
+
+	// WalletEnabled indicates whether accounts are configured. Without
+	// them, only the endpoints that do not depend on the wallet are served.
+	WalletEnabled bool `json:"wallet_enabled"`
+
+	// ScanDetails contains the scan progress of each configured account.
+	ScanDetails []AccountScanStatus `json:"scan_details,omitempty"`
+
+	// Warnings describe the optional features missing on the bitcoind node,
+	// and how to enable them.
+	Warnings []string `json:"warnings,omitempty"`
+
+	// NodeVersionNumber and NodeUserAgent are the numeric version of
+	// bitcoind (for ex, 220000 for v22.0.0), and its user agent (for ex,
+	// /Satoshi:22.0.0/), as reported by getnetworkinfo.
+	NodeVersionNumber int32  `json:"node_version_number,omitempty"`
+	NodeUserAgent     string `json:"node_user_agent,omitempty"`
+
+	// NodeWarnings are the warnings of bitcoind, for ex when running a
+	// pre-release, or when unknown new consensus rules are activated.
+	NodeWarnings []string `json:"node_warnings,omitempty"`
+
+	// Connections is the number of peers of bitcoind.
+	Connections *int32 `json:"connections,omitempty"`
+
+	// TxLookup lists the strategies available to look up the transactions
+	// that do not belong to the wallet, like txindex or block_filter.
+	TxLookup []string `json:"tx_lookup"`
+
+	// Commit and BuildDate identify the build of SatStack, along with
+	// Version.
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+
+	// Disk describes the disk usage of bitcoind, once known.
+	Disk *DiskStatus `json:"disk,omitempty"`
+
+	// WalletDrift describes the entries of the wallet that do not belong to
+	// the configured accounts, if any were found at startup.
+	WalletDrift *WalletDrift `json:"wallet_drift,omitempty"`
+
+	// PartialHistory indicates that the scan of some accounts starts after
+	// their birthday, since the blocks before are pruned; see
+	// AccountScanStatus.
+	PartialHistory bool `json:"partial_history"`
+
+	// ScanRecoveries is the number of times the worker recovered from a
+	// wallet rescan whose progress was stuck.
+	ScanRecoveries int64 `json:"scan_recoveries"`
+}
+
+// AccountScanStatus represents the progress of the import of the
+// descriptors of a single account into the Bitcoin Core wallet.
+type AccountScanStatus struct {
+	Descriptor string  `json:"descriptor"` // external descriptor of the account
+	Progress   float64 `json:"progress"`   // between 0 and 1
+	Completed  bool    `json:"completed"`
+
+	// ScanStart is the time from which the descriptors of the account are
+	// rescanned, in RFC3339 format. PartialHistory indicates that it is
+	// later than the birthday of the account, since the blocks before are
+	// pruned, in which case its balance may be incomplete.
+	ScanStart      string `json:"scan_start,omitempty"`
+	PartialHistory bool   `json:"partial_history,omitempty"`
+
+	// Error describes why the descriptors of the account could not be
+	// imported, if they could not.
+	Error string `json:"error,omitempty"`
+}
+
+// ScanDetails returns a snapshot of the scan progress of every account that
+// was submitted for import, in the order in which they were submitted.
+func (b *Bus) ScanDetails() []AccountScanStatus {
+	b.scanMutex.RLock()
+	defer b.scanMutex.RUnlock()
+
+	if len(b.scanOrder) == 0 {
+		return nil
+	}
+
+	ret := make([]AccountScanStatus, 0, len(b.scanOrder))
+	for _, desc := range b.scanOrder {
+		ret = append(ret, *b.scans[desc])
+	}
+
+	return ret
+}
+
+// setAccountScan creates or updates the scan status of the account
+// identified by its external descriptor.
+func (b *Bus) setAccountScan(descriptor string, progress float64, completed bool) {
+	b.scanMutex.Lock()
+	defer b.scanMutex.Unlock()
+
+	if b.scans == nil {
+		b.scans = make(map[string]*AccountScanStatus)
+	}
+
+	scan, ok := b.scans[descriptor]
+	if !ok {
+		scan = &AccountScanStatus{Descriptor: descriptor}
+		b.scans[descriptor] = scan
+		b.scanOrder = append(b.scanOrder, descriptor)
+	}
+
+	scan.Progress = progress
+	scan.Completed = completed
+	scan.Error = ""
+}
+
+// setAccountScanStart records the time from which the descriptors of the
+// account identified by its key are rescanned, once its scan status is set.
+func (b *Bus) setAccountScanStart(descriptor string, timestamp uint32, partial bool) {
+	b.scanMutex.Lock()
+	defer b.scanMutex.Unlock()
+
+	if scan, ok := b.scans[descriptor]; ok {
+		scan.ScanStart = formatTimestamp(timestamp)
+		scan.PartialHistory = partial
+	}
+}
+
+// failAccountScan reports that the descriptors of the account identified by
+// its key could not be imported.
+func (b *Bus) failAccountScan(descriptor string, err error) {
+	b.setAccountScan(descriptor, 0, false)
+
+	b.scanMutex.Lock()
+	defer b.scanMutex.Unlock()
+
+	b.scans[descriptor].Error = err.Error()
+}
+
+// updatePendingScans sets the progress of all accounts that have not
+// completed their import yet. Bitcoin Core rescans the blockchain once for
+// all descriptors imported together, so the progress is shared.
+func (b *Bus) updatePendingScans(progress float64) {
+	b.scanMutex.Lock()
+	defer b.scanMutex.Unlock()
+
+	for _, scan := range b.scans {
+		if !scan.Completed && scan.Error == "" {
+			scan.Progress = progress
+		}
+	}
+}
+
+// removeAccountScan stops reporting the scan status of the account
+// identified by its external descriptor.
+func (b *Bus) removeAccountScan(descriptor string) {
+	b.scanMutex.Lock()
+	defer b.scanMutex.Unlock()
+
+	if _, ok := b.scans[descriptor]; !ok {
+		return
+	}
+
+	delete(b.scans, descriptor)
+
+	for idx, desc := range b.scanOrder {
+		if desc == descriptor {
+			b.scanOrder = append(b.scanOrder[:idx], b.scanOrder[idx+1:]...)
+			break
+		}
+	}
+}
+
+// CachedStatus returns the last Status computed by QueryStatus, without
+// performing any RPC call.
+//
+// If the Status was never computed, Initializing is returned. If it has not
+// been refreshed recently, bitcoind is assumed to be unresponsive, and
+// NodeDisconnected is returned.
+//
+// The detail of the Status is returned as well, for ex the progress reported
+// by bitcoind while it is warming up.
+func (b *Bus) CachedStatus() (Status, string) {
+	b.statusMutex.RLock()
+	defer b.statusMutex.RUnlock()
+
+	switch {
+	case b.status == "":
+		return Initializing, ""
+	case time.Since(b.statusUpdated) > statusMaxAge:
+		return NodeDisconnected, ""
+	default:
+		return b.status, b.statusDetail
+	}
+}
+
+// CachedExplorerStatus returns the last ExplorerStatus computed by
+// QueryStatus, without performing any RPC call, or nil if it was never
+// computed. It is shared, and must not be modified.
+//
+// Unlike CachedStatus, it is returned even if stale.
+func (b *Bus) CachedExplorerStatus() *ExplorerStatus {
+	b.statusMutex.RLock()
+	defer b.statusMutex.RUnlock()
+
+	return b.statusReport
+}
+
+// QueryStatus computes the current status of SatStack and bitcoind, and
+// updates the cached Status and the metrics accordingly.
+func (b *Bus) QueryStatus() *ExplorerStatus {
+	status := b.queryStatus()
+	b.cacheStatus(status.Status, status.StatusDetail)
+
+	b.statusMutex.Lock()
+	b.statusReport = status
+	b.statusMutex.Unlock()
+
+	if status.SyncProgress != nil {
+		metrics.SyncProgress.WithLabelValues(b.Name).Set(*status.SyncProgress)
+	}
+
+	if status.ScanProgress != nil {
+		metrics.ScanProgress.WithLabelValues(b.Name).Set(*status.ScanProgress)
+	}
+
+	return status
+}
+
+// cacheStatus records the Status returned by CachedStatus, along with its
+// detail, and updates the status metric.
+func (b *Bus) cacheStatus(status Status, detail string) {
+	b.statusMutex.Lock()
+	b.status = status
+	b.statusDetail = detail
+	b.statusUpdated = time.Now()
+	b.statusMutex.Unlock()
+
+	known := make([]string, len(Statuses))
+	for i, v := range Statuses {
+		known[i] = string(v)
+	}
+
+	metrics.SetStatus(b.Name, string(status), known)
+}
+
+func (b *Bus) queryStatus() *ExplorerStatus {
+	caps := b.Capabilities()
+
+	// Prepare base ExplorerStatus instance.
+	status := ExplorerStatus{
+		Version:     version.Version,
+		Commit:      version.Commit(),
+		BuildDate:   version.Date(),
+		NodeVersion: formatVersion(caps.Version),
+		TxIndex:     caps.TxIndex,
+		BlockFilter: caps.BlockFilter,
+		Pruned:      caps.Pruned,
+		Chain:       b.Chain,
+		Currency:    b.Currency,
+		Wallet:      b.WalletName,
+		ZMQ:         b.ZMQActive(),
+
+		WalletEnabled: b.WalletEnabled(),
+
+		ScanDetails: b.ScanDetails(),
+		Warnings:    caps.Warnings,
+		TxLookup:    b.LookupStrategies(),
+		Disk:        b.disk.get(),
+		WalletDrift: b.drift.get(),
+
+		PartialHistory: b.PartialHistory(),
+
+		ScanRecoveries: b.scanWatchdog.count(),
+	}
+
+	if caps.Pruned {
+		pruneHeight := caps.PruneHeight
+		status.PruneHeight = &pruneHeight
+	}
+
+	if info := b.networkInfo.get(); info != nil {
+		status.NodeVersionNumber = info.Version
+		status.NodeUserAgent = info.SubVersion
+		status.NodeWarnings = info.Warnings
+		status.Connections = &info.Connections
+	}
+
+	// Case 1: satstack is running the numbers.
+	if b.IsPendingScan() {
+		status.Status = PendingScan
+		return &status
+	}
+
+	// Case 2: bitcoind is warming up, or unreachable - chain RPC failed.
+	blockChainInfo, err := b.client.GetBlockChainInfo(context.Background())
+	if message, ok := warmupMessage(err); ok {
+		status.Status = Initializing
+		status.StatusDetail = message
+		return &status
+	}
+
+	if err != nil {
+		log.WithField(
+			"err", fmt.Errorf("%s: %w", ErrBitcoindUnreachable, err),
+		).Error("Failed to query status")
+
+		status.Status = NodeDisconnected
+		return &status
+	}
+
+	// Case 3: bitcoind is currently catching up on new blocks.
+	if blockChainInfo.Blocks != blockChainInfo.Headers {
+		status.Status = Syncing
+		status.SyncProgress = btcjson.Float64(
+			blockChainInfo.VerificationProgress * 100)
+
+		b.syncTracker.sample(*status.SyncProgress, time.Time{}, time.Now())
+		estimate := b.syncTracker.estimate()
+		status.SyncStartedAt = &estimate.StartedAt
+		status.SyncETASeconds = estimate.ETASeconds
+		status.SyncRatePerHour = estimate.RatePerHr
+		return &status
+	}
+
+	b.syncTracker.reset()
+
+	// Case 4: bitcoind is currently importing descriptors
+	walletInfo, err := b.client.GetWalletInfo(context.Background())
+	if err != nil {
+		log.WithField(
+			"err", fmt.Errorf("%s: %w", ErrBitcoindUnreachable, err),
+		).Error("Failed to query status")
+
+		status.Status = NodeDisconnected
+		return &status
+	}
+
+	switch v := walletInfo.Scanning.Value.(type) {
+	case btcjson.ScanProgress:
+		status.Status = Scanning
+		status.ScanProgress = btcjson.Float64(v.Progress * 100)
+
+		now := time.Now()
+		startedAt := now.Add(-time.Duration(v.Duration) * time.Second)
+		b.scanTracker.sample(*status.ScanProgress, startedAt, now)
+		b.scanWatchdog.observe(*status.ScanProgress, now)
+
+		estimate := b.scanTracker.estimate()
+		status.ScanStartedAt = &estimate.StartedAt
+		status.ScanETASeconds = estimate.ETASeconds
+		status.ScanRatePerHour = estimate.RatePerHr
+		return &status
+	}
+
+	b.scanTracker.reset()
+
+	// An import or a rescan RPC still in flight, while the wallet is not
+	// scanning, is left for the watchdog to recover from.
+	if atomic.LoadInt32(&b.importing) == 0 {
+		b.scanWatchdog.reset()
+	}
+
+	// Case 5: bitcoind is ready to be used with satstack.
+	status.Status = Ready
+	return &status
+}
+
+// warmupMessage returns the message of the error returned by bitcoind while
+// it is warming up, like "Loading block index...", and true if err is such an
+// error.
+func warmupMessage(err error) (string, bool) {
+	var rpcErr *btcjson.RPCError
+	if errors.As(err, &rpcErr) && rpcErr.Code == btcjson.ErrRPCInWarmup {
+		return rpcErr.Message, true
+	}
+
+	return "", false
 }
@@ -0,0 +1,50 @@
+package bus
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/ledgerhq/satstack/config"
+)
+
+// newSyncedNode returns a fakeNode that is synced, with a wallet that is not
+// scanning.
+func newSyncedNode() *fakeNode {
+	node := newFakeNode()
+
+	node.result("getblockchaininfo", map[string]interface{}{
+		"chain":         "regtest",
+		"blocks":        10,
+		"headers":       10,
+		"bestblockhash": mockBlockHash('a', 10),
+	})
+	node.result("getwalletinfo", map[string]interface{}{
+		"walletname": defaultWalletName,
+		"scanning":   false,
+	})
+
+	return node
+}
+
+func TestStatusWithoutAccounts(t *testing.T) {
+	b := newTestBus(newSyncedNode())
+
+	status := b.queryStatus()
+	if status.Status != Ready || status.WalletEnabled {
+		t.Fatalf("got status %s, wallet enabled %v, want %s without wallet", status.Status, status.WalletEnabled, Ready)
+	}
+
+	// The field is reported when false.
+	data, err := json.Marshal(status)
+	if err != nil || !strings.Contains(string(data), `"wallet_enabled":false`) {
+		t.Errorf("got %s, %v, want wallet_enabled false", data, err)
+	}
+
+	address := "bcrt1qw508d6qejxtdg4y5r3zarvary0c5xw7kygt080"
+	b.setAccounts([]config.Account{{Address: &address}})
+
+	if status := b.queryStatus(); status.Status != Ready || !status.WalletEnabled {
+		t.Errorf("got status %s, wallet enabled %v, want %s with wallet", status.Status, status.WalletEnabled, Ready)
+	}
+}
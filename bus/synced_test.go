@@ -0,0 +1,69 @@
+package bus_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ledgerhq/satstack/bus"
+	"github.com/ledgerhq/satstack/httpd/middleware"
+	"github.com/ledgerhq/satstack/httpd/svc"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSyncedGate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	b, setHeaders := bus.NewSyncingTestBus()
+
+	engine := gin.New()
+	engine.GET("/addresses", middleware.Synced(&svc.Service{Bus: b}), func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+
+	serve := func() int {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/addresses", nil))
+		return w.Code
+	}
+
+	// The Status is Initializing until the worker first refreshes it.
+	if got := serve(); got != http.StatusServiceUnavailable {
+		t.Errorf("before the first refresh: got status %d, want %d", got, http.StatusServiceUnavailable)
+	}
+
+	setHeaders(12)
+	if status := b.QueryStatus(); status.Status != bus.Syncing {
+		t.Fatalf("got status %s, want %s", status.Status, bus.Syncing)
+	}
+
+	if got := serve(); got != http.StatusServiceUnavailable {
+		t.Errorf("syncing: got status %d, want %d", got, http.StatusServiceUnavailable)
+	}
+
+	// The gate opens as soon as the worker refreshes the Status to Ready.
+	setHeaders(10)
+	if status := b.QueryStatus(); status.Status != bus.Ready {
+		t.Fatalf("got status %s, want %s", status.Status, bus.Ready)
+	}
+
+	if got := serve(); got != http.StatusOK {
+		t.Errorf("ready: got status %d, want %d", got, http.StatusOK)
+	}
+
+	// A Status that is no longer refreshed is stale, and closes the gate
+	// until the next refresh.
+	b.AgeStatus(bus.StatusMaxAge + time.Second)
+
+	if got := serve(); got != http.StatusServiceUnavailable {
+		t.Errorf("stale: got status %d, want %d", got, http.StatusServiceUnavailable)
+	}
+
+	b.QueryStatus()
+
+	if got := serve(); got != http.StatusOK {
+		t.Errorf("refreshed: got status %d, want %d", got, http.StatusOK)
+	}
+}
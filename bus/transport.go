@@ -0,0 +1,272 @@
+package bus
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ledgerhq/satstack/version"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/rpcclient"
+)
+
+// dialTimeout bounds the time spent connecting to bitcoind, including the
+// TLS handshake.
+const dialTimeout = 10 * time.Second
+
+// errNoResponse is returned for an RPC call of a batch that bitcoind did not
+// answer.
+var errNoResponse = errors.New("no response to RPC call")
+
+// rpcTransport sends JSON-RPC requests to bitcoind. It is implemented by
+// httpTransport, and by fakes of bitcoind in tests.
+type rpcTransport interface {
+	// Send sends the requests in a single round trip, as a batch if there
+	// are several, and returns their responses in the same order. A
+	// response is nil if bitcoind did not answer the request.
+	//
+	// The error is only returned if the round trip failed as a whole; the
+	// errors of the individual calls are in the responses.
+	Send(ctx context.Context, requests []*btcjson.Request) ([]*btcjson.Response, error)
+}
+
+// httpTransport is an rpcTransport that POSTs the requests to the HTTP
+// server of bitcoind. Unlike rpcclient, which queues the requests of a client
+// and sends them one at a time, it is safe for concurrent use, and keeps the
+// connections alive across requests.
+type httpTransport struct {
+	url       string
+	client    *http.Client
+	userAgent string
+
+	// Credentials, either set in the config, or read from the cookie file.
+	user   string
+	pass   string
+	cookie *cookieFile
+}
+
+// newHTTPTransport creates an httpTransport from the connection settings of
+// connCfg: the host, TLS, proxy and credentials. A non-zero timeout bounds
+// the HTTP requests, including reading the response.
+//
+// Connecting to bitcoind is always bounded, so that a node that does not
+// accept connections fails the calls quickly, rather than at the timeout.
+func newHTTPTransport(connCfg *rpcclient.ConnConfig, timeout time.Duration) (*httpTransport, error) {
+	transport := &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: dialTimeout}).DialContext,
+		TLSHandshakeTimeout: dialTimeout,
+		MaxIdleConnsPerHost: batchConcurrency + 2,
+		IdleConnTimeout:     time.Minute,
+	}
+
+	if connCfg.Proxy != "" {
+		proxyURL, err := url.Parse(connCfg.Proxy)
+		if err != nil {
+			return nil, err
+		}
+
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	scheme := "http"
+	if !connCfg.DisableTLS {
+		scheme = "https"
+
+		if len(connCfg.Certificates) > 0 {
+			pool := x509.NewCertPool()
+			pool.AppendCertsFromPEM(connCfg.Certificates)
+			transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		}
+	}
+
+	t := &httpTransport{
+		url:       scheme + "://" + connCfg.Host,
+		client:    &http.Client{Transport: transport, Timeout: timeout},
+		userAgent: version.UserAgent(),
+		user:      connCfg.User,
+		pass:      connCfg.Pass,
+	}
+
+	if connCfg.Pass == "" && connCfg.CookiePath != "" {
+		t.cookie = &cookieFile{path: connCfg.CookiePath}
+	}
+
+	return t, nil
+}
+
+// Send implements rpcTransport.
+//
+// As with rpcclient, a body that is not a JSON-RPC response, for ex after an
+// authentication failure, is reported along with the HTTP status code.
+func (t *httpTransport) Send(ctx context.Context, requests []*btcjson.Request) ([]*btcjson.Response, error) {
+	var payload interface{} = requests
+	if len(requests) == 1 {
+		payload = requests[0]
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	httpRequest.Header.Set("Content-Type", "application/json")
+	httpRequest.Header.Set("User-Agent", t.userAgent)
+
+	user, pass, err := t.credentials()
+	if err != nil {
+		return nil, err
+	}
+
+	httpRequest.SetBasicAuth(user, pass)
+
+	httpResponse, err := t.client.Do(httpRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	defer httpResponse.Body.Close()
+
+	respBytes, err := ioutil.ReadAll(httpResponse.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading json reply: %w", err)
+	}
+
+	var responses []*btcjson.Response
+	if len(requests) == 1 {
+		var response btcjson.Response
+		err = json.Unmarshal(respBytes, &response)
+		responses = []*btcjson.Response{&response}
+	} else {
+		err = json.Unmarshal(respBytes, &responses)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("status code: %d, response: %q",
+			httpResponse.StatusCode, string(respBytes))
+	}
+
+	if len(requests) == 1 {
+		return responses, nil
+	}
+
+	return matchResponses(requests, responses), nil
+}
+
+// credentials returns the user and password of the HTTP basic auth.
+func (t *httpTransport) credentials() (string, string, error) {
+	if t.cookie != nil {
+		return t.cookie.credentials()
+	}
+
+	return t.user, t.pass, nil
+}
+
+// close closes the idle connections of the transport.
+func (t *httpTransport) close() {
+	t.client.CloseIdleConnections()
+}
+
+// matchResponses orders the responses of a batch like the requests, by ID,
+// since the responses of a batch may come in any order.
+func matchResponses(requests []*btcjson.Request, responses []*btcjson.Response) []*btcjson.Response {
+	byID := make(map[string]*btcjson.Response, len(responses))
+	for _, response := range responses {
+		if response == nil || response.ID == nil {
+			continue
+		}
+
+		byID[responseKey(*response.ID)] = response
+	}
+
+	ret := make([]*btcjson.Response, len(requests))
+	for idx, request := range requests {
+		ret[idx] = byID[responseKey(request.ID)]
+	}
+
+	return ret
+}
+
+// responseKey returns the JSON encoding of an ID, in which the numbers
+// decoded as float64 match the integer IDs of the requests.
+func responseKey(id interface{}) string {
+	key, _ := json.Marshal(id)
+	return string(key)
+}
+
+// cookieFile reads the credentials of cookie authentication from the cookie
+// file of bitcoind. The file is read again whenever it is modified, since
+// bitcoind writes new credentials to it every time it starts.
+type cookieFile struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	user    string
+	pass    string
+}
+
+// credentials returns the user and password in the cookie file.
+func (c *cookieFile) credentials() (string, string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	info, err := os.Stat(c.path)
+	if err != nil {
+		return "", "", err
+	}
+
+	if info.ModTime().Equal(c.modTime) {
+		return c.user, c.pass, nil
+	}
+
+	user, pass, err := readCookieFile(c.path)
+	if err != nil {
+		return "", "", err
+	}
+
+	c.modTime, c.user, c.pass = info.ModTime(), user, pass
+	return user, pass, nil
+}
+
+// readCookieFile reads the user and password in the cookie file at path,
+// written by bitcoind as "user:password" on the first line.
+func readCookieFile(path string) (string, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Scan()
+	if err := scanner.Err(); err != nil {
+		return "", "", err
+	}
+
+	parts := strings.SplitN(scanner.Text(), ":", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("malformed cookie file")
+	}
+
+	return parts[0], parts[1], nil
+}
@@ -0,0 +1,267 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ledgerhq/satstack/config"
+	"github.com/ledgerhq/satstack/version"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/rpcclient"
+)
+
+// newTestServer starts an HTTP server standing for bitcoind, and returns a
+// client connected to it.
+func newTestServer(t *testing.T, handler http.HandlerFunc) *rpcClient {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	connCfg := &rpcclient.ConnConfig{
+		Host:         strings.TrimPrefix(server.URL, "http://"),
+		User:         "user",
+		Pass:         "pass",
+		HTTPPostMode: true,
+		DisableTLS:   true,
+	}
+
+	client, err := newClient(connCfg, 0, newRPCStats())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return client
+}
+
+func TestHTTPTransportBatch(t *testing.T) {
+	client := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		var requests []btcjson.Request
+		if err := json.NewDecoder(r.Body).Decode(&requests); err != nil {
+			t.Errorf("batch is not an array: %v", err)
+			return
+		}
+
+		// Answer in reverse order, with an error for the first call.
+		responses := make([]map[string]interface{}, 0, len(requests))
+		for idx := len(requests) - 1; idx >= 0; idx-- {
+			response := map[string]interface{}{"id": requests[idx].ID, "error": nil}
+
+			var hash int64
+			_ = json.Unmarshal(requests[idx].Params[0], &hash)
+
+			if idx == 0 {
+				response["error"] = map[string]interface{}{"code": -8, "message": "Block height out of range"}
+			} else {
+				response["result"] = strings.Repeat("0", 63) + string(rune('0'+hash))
+			}
+
+			responses = append(responses, response)
+		}
+
+		_ = json.NewEncoder(w).Encode(responses)
+	})
+
+	batch := client.NewBatch()
+	receivers := make([]func() error, 3)
+	hashes := make([]string, 3)
+	for height := range receivers {
+		height := height
+		receivers[height] = batch.queue(btcjson.NewGetBlockHashCmd(int64(height)), &hashes[height])
+	}
+
+	if err := batch.Send(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var rpcErr *btcjson.RPCError
+	if err := receivers[0](); !errors.As(err, &rpcErr) || rpcErr.Code != -8 {
+		t.Errorf("got error %v, want RPC error -8", err)
+	}
+
+	for height := 1; height < 3; height++ {
+		if err := receivers[height](); err != nil {
+			t.Fatal(err)
+		}
+
+		if want := strings.Repeat("0", 63) + string(rune('0'+height)); hashes[height] != want {
+			t.Errorf("hashes[%d] = %s, want %s", height, hashes[height], want)
+		}
+	}
+}
+
+func TestHTTPTransportErrors(t *testing.T) {
+	client := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "user" || pass != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"result":null,"error":{"code":-18,"message":"Requested wallet does not exist or is not loaded"},"id":1}`))
+	})
+
+	_, err := client.GetWalletInfo(context.Background())
+
+	var rpcErr *btcjson.RPCError
+	if !errors.As(err, &rpcErr) || rpcErr.Code != btcjson.ErrRPCWalletNotFound {
+		t.Errorf("got error %v, want RPC error %d", err, btcjson.ErrRPCWalletNotFound)
+	}
+
+	client.transport.(*instrumentedTransport).next.(*httpTransport).pass = "wrong"
+
+	if _, err := client.GetWalletInfo(context.Background()); !isAuthError(err) {
+		t.Errorf("got error %v, want an authentication error", err)
+	}
+}
+
+func TestHTTPTransportUserAgent(t *testing.T) {
+	var userAgent string
+	client := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.Header.Get("User-Agent")
+		_, _ = w.Write([]byte(`{"result":100,"error":null,"id":1}`))
+	})
+
+	if _, err := client.RawRequest(context.Background(), "getblockcount", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := version.UserAgent(); userAgent != want {
+		t.Errorf("got User-Agent %q, want %q", userAgent, want)
+	}
+
+	if !strings.HasPrefix(userAgent, "satstack/"+version.Version+" ") {
+		t.Errorf("User-Agent %q does not start with the version", userAgent)
+	}
+}
+
+// newCredentialsServer starts an HTTP server standing for bitcoind, which
+// records the credentials of each request, and returns its URL.
+func newCredentialsServer(t *testing.T, credentials *[]string) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		*credentials = append(*credentials, user+":"+pass)
+		_, _ = w.Write([]byte(`{"result":100,"error":null,"id":1}`))
+	}))
+	t.Cleanup(server.Close)
+
+	return server.URL
+}
+
+func TestExplicitCredentials(t *testing.T) {
+	var credentials []string
+	rpcURL := newCredentialsServer(t, &credentials)
+
+	user, pass := "user", "pass"
+	connCfg, _, err := newConnConfig(&config.Configuration{
+		RPCURL:      &rpcURL,
+		RPCUser:     &user,
+		RPCPassword: &pass,
+	}, defaultWalletName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if connCfg.CookiePath != "" {
+		t.Errorf("got cookie file %s with explicit credentials", connCfg.CookiePath)
+	}
+
+	client, err := newClient(connCfg, 0, newRPCStats())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.RawRequest(context.Background(), "getblockcount", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(credentials) != 1 || credentials[0] != "user:pass" {
+		t.Errorf("got credentials %v, want user:pass", credentials)
+	}
+}
+
+func TestCookieCredentials(t *testing.T) {
+	var credentials []string
+	rpcURL := newCredentialsServer(t, &credentials)
+
+	cookiePath := filepath.Join(t.TempDir(), ".cookie")
+	writeCookie := func(cookie string, modTime time.Time) {
+		if err := ioutil.WriteFile(cookiePath, []byte(cookie+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.Chtimes(cookiePath, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	start := time.Now().Add(-time.Hour)
+	writeCookie("__cookie__:first", start)
+
+	connCfg, _, err := newConnConfig(&config.Configuration{
+		RPCURL:     &rpcURL,
+		CookiePath: &cookiePath,
+	}, defaultWalletName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if connCfg.CookiePath != cookiePath || connCfg.User != "" || connCfg.Pass != "" {
+		t.Fatalf("got cookie file %q, user %q, want cookie file %s only",
+			connCfg.CookiePath, connCfg.User, cookiePath)
+	}
+
+	client, err := newClient(connCfg, 0, newRPCStats())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	getBlockCount := func() {
+		if _, err := client.RawRequest(context.Background(), "getblockcount", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	getBlockCount()
+
+	// bitcoind writes new credentials to the cookie file when restarted.
+	writeCookie("__cookie__:second", start.Add(time.Minute))
+	getBlockCount()
+
+	want := []string{"__cookie__:first", "__cookie__:second"}
+	if strings.Join(credentials, " ") != strings.Join(want, " ") {
+		t.Errorf("got credentials %v, want %v", credentials, want)
+	}
+}
+
+func TestCookieCredentialsMissing(t *testing.T) {
+	rpcURL := "http://127.0.0.1:18443"
+	cookiePath := filepath.Join(t.TempDir(), ".cookie")
+
+	_, _, err := newConnConfig(&config.Configuration{
+		RPCURL:     &rpcURL,
+		CookiePath: &cookiePath,
+	}, defaultWalletName)
+	if err == nil || !strings.HasPrefix(err.Error(), ErrCookieFile.Error()) {
+		t.Errorf("got error %v, want %v", err, ErrCookieFile)
+	}
+}
+
+func TestReadCookieFileMalformed(t *testing.T) {
+	cookiePath := filepath.Join(t.TempDir(), ".cookie")
+	if err := ioutil.WriteFile(cookiePath, []byte("no separator\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := readCookieFile(cookiePath); err == nil {
+		t.Error("got no error for a malformed cookie file")
+	}
+}
@@ -1,46 +1,18 @@
 package bus
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+
 	"github.com/btcsuite/btcd/btcjson"
-	"github.com/btcsuite/btcd/rpcclient"
-	"github.com/btcsuite/btcutil"
-	"github.com/ledgerhq/satstack/utils"
+	"github.com/ledgerhq/satstack/config"
+	"github.com/ledgerhq/satstack/redact"
 	log "github.com/sirupsen/logrus"
 )
 
-const fallbackFee = btcutil.Amount(1)
-
-func (b *Bus) EstimateSmartFee(target int64, mode string) btcutil.Amount {
-	fee, err := b.mainClient.EstimateSmartFee(target, getMode(mode))
-
-	// If failed to get smart fee estimate, fallback to fallbackFee.
-	// Example: if the full-node is a regtest chain, there are normally
-	// no transactions in the mempool to analyze for estimating fees.
-	//
-	// TODO: Use Minimum Relay Fee instead of btcutil.Amount(1)
-	if err != nil {
-		log.WithFields(log.Fields{
-			"error":  err,
-			"target": target,
-			"mode":   mode,
-		}).Error("Failed estimatesmartfee Bridge")
-		return fallbackFee
-	}
-
-	if len(fee.Errors) > 0 {
-		log.WithFields(log.Fields{
-			"error":  fee.Errors,
-			"target": target,
-			"mode":   mode,
-		}).Error("Failed estimatesmartfee Bridge")
-		return fallbackFee
-	}
-
-	return utils.ParseSatoshi(*fee.FeeRate)
-}
-
-func DeriveAddress(client *rpcclient.Client, descriptor string, index int) (*string, error) {
-	addresses, err := client.DeriveAddresses(
+func DeriveAddress(ctx context.Context, client *rpcClient, descriptor string, index int) (*string, error) {
+	addresses, err := client.DeriveAddresses(ctx,
 		descriptor,
 
 		// Since we're interested in only the address at addressIndex,
@@ -51,7 +23,7 @@ func DeriveAddress(client *rpcclient.Client, descriptor string, index int) (*str
 	if err != nil {
 		log.WithFields(log.Fields{
 			"error":      err,
-			"descriptor": descriptor,
+			"descriptor": redact.Descriptor(descriptor),
 			"index":      index,
 		}).Error("Failed to derive address")
 		return nil, err
@@ -60,14 +32,77 @@ func DeriveAddress(client *rpcclient.Client, descriptor string, index int) (*str
 	return &(*addresses)[0], nil // *addresses is always a single-element slice
 }
 
-// GetCanonicalDescriptor returns the descriptor in canonical form, along with
-// its computed checksum.
-func GetCanonicalDescriptor(client *rpcclient.Client, descriptor string) (*string, error) {
-	info, err := client.GetDescriptorInfo(descriptor)
+// singleAddress derives the address of a descriptor that is not ranged.
+// Unlike DeriveAddress, no range is passed, since bitcoind rejects it.
+func singleAddress(client *rpcClient, descriptor string) (string, error) {
+	addresses, err := client.DeriveAddresses(context.Background(), descriptor, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if len(*addresses) != 1 {
+		return "", fmt.Errorf("expected a single address, found %d", len(*addresses))
+	}
+
+	return (*addresses)[0], nil
+}
+
+// descriptorAddress returns the address of the descriptor at the given index,
+// or its single address if not ranged.
+func descriptorAddress(client *rpcClient, desc descriptor, index int) (*string, error) {
+	if !desc.ranged() {
+		return &desc.Address, nil
+	}
+
+	return DeriveAddress(context.Background(), client, desc.Value, index)
+}
+
+// VerifyChecksums enables the cross-check of the descriptor checksums
+// computed by GetCanonicalDescriptor against getdescriptorinfo, for debugging.
+var VerifyChecksums = false
+
+// GetCanonicalDescriptor returns the descriptor along with its checksum,
+// which is computed locally. An existing checksum is validated instead.
+//
+// Unlike getdescriptorinfo, the descriptor is not normalized by bitcoind, and
+// is returned as written. If VerifyChecksums is set, the result is compared
+// with the one of getdescriptorinfo, and any difference is logged.
+func GetCanonicalDescriptor(client *rpcClient, descriptor string) (*string, error) {
+	desc, err := config.DescriptorWithChecksum(descriptor)
 	if err != nil {
 		return nil, err
 	}
-	return &info.Descriptor, nil
+
+	if VerifyChecksums {
+		verifyChecksum(client, desc)
+	}
+
+	return &desc, nil
+}
+
+// verifyChecksum compares the descriptor, suffixed with its checksum, with
+// the canonical form returned by getdescriptorinfo.
+func verifyChecksum(client *rpcClient, desc string) {
+	info, err := client.GetDescriptorInfo(context.Background(), desc)
+	switch {
+	case err != nil:
+		log.WithFields(log.Fields{
+			"prefix":     "descriptor",
+			"descriptor": redact.Descriptor(desc),
+			"error":      err,
+		}).Warn("Failed to cross-check descriptor checksum")
+	case info.Descriptor != desc:
+		log.WithFields(log.Fields{
+			"prefix":     "descriptor",
+			"descriptor": redact.Descriptor(desc),
+			"bitcoind":   redact.Descriptor(info.Descriptor),
+		}).Warn("Descriptor does not match getdescriptorinfo")
+	default:
+		log.WithFields(log.Fields{
+			"prefix":     "descriptor",
+			"descriptor": redact.Descriptor(desc),
+		}).Debug("Descriptor checksum matches getdescriptorinfo")
+	}
 }
 
 func getMode(s string) *btcjson.EstimateSmartFeeMode {
@@ -82,3 +117,19 @@ func getMode(s string) *btcjson.EstimateSmartFeeMode {
 		return &btcjson.EstimateModeEconomical
 	}
 }
+
+// rawRequest invokes an RPC method that is not natively supported by
+// rpcclient. Each param is marshalled to JSON, and the raw result returned.
+func rawRequest(ctx context.Context, client *rpcClient, method string, params ...interface{}) (json.RawMessage, error) {
+	rawParams := make([]json.RawMessage, 0, len(params))
+	for _, param := range params {
+		raw, err := json.Marshal(param)
+		if err != nil {
+			return nil, err
+		}
+
+		rawParams = append(rawParams, raw)
+	}
+
+	return client.RawRequest(ctx, method, rawParams)
+}
@@ -0,0 +1,38 @@
+package bus
+
+import "strings"
+
+// ChainVersion returns a string that changes whenever the chain tip moves,
+// for ex to derive the ETag of responses that only depend on the chain. It
+// returns false if the chain tip is unknown.
+func (b *Bus) ChainVersion() (string, bool) {
+	hash, err := b.GetBestBlockHash()
+	if err != nil {
+		return "", false
+	}
+
+	return hash.String(), true
+}
+
+// AddressesVersion returns a string that changes whenever the chain tip
+// moves, the wallet index is synced up to a new block, or the unconfirmed
+// wallet transactions involving the addresses change. It returns false if the
+// wallet index is not ready, in which case the transactions are listed from
+// the node, and no version can be derived.
+//
+// The generation of the wallet index is part of the version, since the
+// transactions of past blocks may change once it is rebuilt, for ex after a
+// rescan.
+func (b *Bus) AddressesVersion(addresses []string) (string, bool) {
+	chain, ok := b.ChainVersion()
+	if !ok {
+		return "", false
+	}
+
+	index, ok := b.walletIndex.version(addresses)
+	if !ok {
+		return "", false
+	}
+
+	return strings.Join(append([]string{chain}, index...), ","), true
+}
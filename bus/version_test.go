@@ -0,0 +1,57 @@
+package bus
+
+import "testing"
+
+func TestAddressesVersion(t *testing.T) {
+	wallet := &mockWallet{chain: newMockChain(5)}
+	wallet.add(1, "bcrt1qfirst", 3)
+
+	b, _ := newIndexedBus(t, wallet)
+
+	version := func() string {
+		v, ok := b.AddressesVersion([]string{"bcrt1qfirst"})
+		if !ok {
+			t.Fatal("no version with the index ready")
+		}
+
+		return v
+	}
+
+	v := version()
+	if err := b.syncWalletIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := version(); got != v {
+		t.Errorf("got version %s after an empty sync, want %s", got, v)
+	}
+
+	// A transaction is confirmed in a new block, which the index lists
+	// before the cached tip is refreshed.
+	wallet.chain.fork(5, 1, 'a')
+	wallet.add(2, "bcrt1qfirst", 6)
+
+	if err := b.syncWalletIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := version(); got == v {
+		t.Error("got the same version after the index synced a new block")
+	}
+
+	v = version()
+	wallet.add(3, "bcrt1qfirst", -1)
+
+	if err := b.syncWalletIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := version(); got == v {
+		t.Error("got the same version after a mempool transaction")
+	}
+
+	b.walletIndex.invalidate()
+	if _, ok := b.AddressesVersion([]string{"bcrt1qfirst"}); ok {
+		t.Error("got a version with the index invalidated")
+	}
+}
@@ -1,24 +1,56 @@
 package bus
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 
-	"github.com/btcsuite/btcd/rpcclient"
-
 	"github.com/ledgerhq/satstack/protocol"
+	"github.com/ledgerhq/satstack/redact"
 	"github.com/ledgerhq/satstack/types"
 
 	"github.com/ledgerhq/satstack/utils"
 
-	"github.com/patrickmn/go-cache"
-
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 
 	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcutil"
 	log "github.com/sirupsen/logrus"
 )
 
+// ListTransactions returns the wallet transactions confirmed after the block
+// referenced by blockHash (if any), and the unconfirmed ones.
+//
+// The transactions are served from the wallet index if it is ready, and
+// listed from the node otherwise.
 func (b *Bus) ListTransactions(blockHash *string) ([]btcjson.ListTransactionsResult, error) {
+	if txs, ok := b.indexedTransactions(context.Background(), nil, blockHash); ok {
+		return txs, nil
+	}
+
+	return b.listTransactions(context.Background(), blockHash)
+}
+
+// ListAddressTransactions is like ListTransactions, but may skip the
+// transactions that do not involve the given addresses. The transactions
+// sent by the wallet are always returned, since their inputs must be
+// checked against the addresses by the caller.
+func (b *Bus) ListAddressTransactions(
+	ctx context.Context, addresses []string, blockHash *string,
+) ([]btcjson.ListTransactionsResult, error) {
+	if addresses == nil {
+		addresses = []string{}
+	}
+
+	if txs, ok := b.indexedTransactions(ctx, addresses, blockHash); ok {
+		return txs, nil
+	}
+
+	return b.listTransactions(ctx, blockHash)
+}
+
+func (b *Bus) listTransactions(ctx context.Context, blockHash *string) ([]btcjson.ListTransactionsResult, error) {
 	var blockHashNative *chainhash.Hash
 	if blockHash != nil {
 		var err error
@@ -28,7 +60,11 @@ func (b *Bus) ListTransactions(blockHash *string) ([]btcjson.ListTransactionsRes
 		}
 	}
 
-	txs, err := b.mainClient.ListSinceBlockMinConfWatchOnly(blockHashNative, 1, true)
+	var txs *btcjson.ListSinceBlockResult
+	err := b.callRPC(ctx, "listsinceblock", func(ctx context.Context) (err error) {
+		txs, err = b.client.ListSinceBlockMinConfWatchOnly(ctx, blockHashNative, 1, true)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -36,97 +72,476 @@ func (b *Bus) ListTransactions(blockHash *string) ([]btcjson.ListTransactionsRes
 	return txs.Transactions, nil
 }
 
-func (b *Bus) GetTransactionHex(hash *chainhash.Hash) (string, error) {
-	tx, err := b.mainClient.GetTransactionWatchOnly(hash, true)
+// WalletAddressInfo is the subset of the getaddressinfo result describing
+// the relation of an address to the wallet.
+type WalletAddressInfo struct {
+	IsMine      bool `json:"ismine"`
+	IsWatchOnly bool `json:"iswatchonly"`
+	IsChange    bool `json:"ischange"` // derived from an internal descriptor
+}
+
+// GetWalletAddressInfo returns how the address relates to the wallet. The
+// ischange field is not supported by the btcd client, hence the raw request.
+func (b *Bus) GetWalletAddressInfo(ctx context.Context, address string) (*WalletAddressInfo, error) {
+	var raw json.RawMessage
+	err := b.callRPC(ctx, "getaddressinfo", func(ctx context.Context) (err error) {
+		raw, err = rawRequest(ctx, b.client, "getaddressinfo", address)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var info WalletAddressInfo
+	if err := json.Unmarshal(raw, &info); err != nil {
+		return nil, err
+	}
+
+	return &info, nil
+}
+
+// unspentChunkSize is the maximum number of addresses by which the
+// listunspent RPC is filtered in a single request.
+const unspentChunkSize = 500
+
+// ListUnspent returns the unspent outputs of the wallet paying to the given
+// addresses, including unconfirmed ones.
+//
+// Large lists of addresses are split into several requests, to keep the
+// size of each request reasonable.
+func (b *Bus) ListUnspent(ctx context.Context, addresses []string) ([]btcjson.ListUnspentResult, error) {
+	var ret []btcjson.ListUnspentResult
+
+	for start := 0; start < len(addresses); start += unspentChunkSize {
+		end := start + unspentChunkSize
+		if end > len(addresses) {
+			end = len(addresses)
+		}
+
+		// The btcd client requires decoded addresses, for the network of
+		// the client, hence the raw request.
+		var raw json.RawMessage
+		err := b.callRPC(ctx, "listunspent", func(ctx context.Context) (err error) {
+			raw, err = rawRequest(ctx, b.client, "listunspent", 0, 9999999, addresses[start:end])
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var utxos []btcjson.ListUnspentResult
+		if err := json.Unmarshal(raw, &utxos); err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, utxos...)
+	}
+
+	return ret, nil
+}
+
+// GetTransactionHex returns the serialized transaction with the given hash,
+// in hex.
+//
+// With a transaction index, any transaction can be looked up. Otherwise,
+// the transactions of the wallet, or in the mempool, can be found, and the
+// others only with a hint: the block containing the transaction, or an
+// address it pays or spends, to search the recent blocks with the block
+// filters. ErrTxIndexRequired is returned if there is no usable hint, since
+// bitcoind cannot tell whether the transaction exists.
+func (b *Bus) GetTransactionHex(ctx context.Context, hash *chainhash.Hash, hint TransactionHint) (string, error) {
+	// Concurrent requests for the same transaction, with the same hint,
+	// share a single lookup.
+	var hintBlock string
+	if hint.BlockHash != nil {
+		hintBlock = hint.BlockHash.String()
+	}
+
+	key := fmt.Sprintf("txhex:%s:%s:%s:%d", hash, hintBlock, hint.Address, hint.Window)
+
+	txHex, err := b.flights.do(ctx, key, func(ctx context.Context) (interface{}, error) {
+		return b.getTransactionHex(ctx, hash, hint)
+	})
 	if err != nil {
 		return "", err
 	}
 
-	return tx.Hex, nil
+	return txHex.(string), nil
+}
+
+// getTransactionHex is the implementation of GetTransactionHex.
+func (b *Bus) getTransactionHex(ctx context.Context, hash *chainhash.Hash, hint TransactionHint) (string, error) {
+	txIndex := b.Capabilities().TxIndex
+	if !txIndex {
+		tx, err := b.client.GetTransactionWatchOnly(ctx, hash, true)
+		if err == nil {
+			return tx.Hex, nil
+		}
+
+		if !isNotFoundError(err) {
+			return "", err
+		}
+	}
+
+	// Without a transaction index, getrawtransaction only finds the
+	// transactions in the mempool.
+	result, err := rawRequest(ctx, b.client, "getrawtransaction", hash.String(), false)
+	switch {
+	case isNotFoundError(err) && txIndex:
+		return "", fmt.Errorf("%w: %s", ErrTransactionNotFound, hash)
+	case isNotFoundError(err):
+		return b.lookupTransactionHex(ctx, hash, hint)
+	case err != nil:
+		return "", err
+	}
+
+	var txHex string
+	if err := json.Unmarshal(result, &txHex); err != nil {
+		return "", err
+	}
+
+	return txHex, nil
+}
+
+// isNotFoundError returns true if the RPC error indicates that the requested
+// transaction, or address, is unknown to the node.
+func isNotFoundError(err error) bool {
+	var rpcErr *btcjson.RPCError
+	return errors.As(err, &rpcErr) && rpcErr.Code == btcjson.ErrRPCInvalidAddressOrKey
+}
+
+// GetTransactionBlock returns the block containing the wallet transaction
+// with the given hash, or nil if the transaction is unconfirmed.
+func (b *Bus) GetTransactionBlock(ctx context.Context, hash *chainhash.Hash) (*types.Block, error) {
+	var tx *btcjson.GetTransactionResult
+	err := b.callRPC(ctx, "gettransaction", func(ctx context.Context) (err error) {
+		tx, err = b.client.GetTransactionWatchOnly(ctx, hash, true)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if tx.BlockHash == "" {
+		return nil, nil
+	}
+
+	if value, found := b.blockCache.Get(ctx, tx.BlockHash); found {
+		block := value.(*types.Block)
+		return &types.Block{
+			Hash:   block.Hash,
+			Height: block.Height,
+			Time:   block.Time,
+		}, nil
+	}
+
+	blockHash, err := utils.ParseChainHash(tx.BlockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	var header *btcjson.GetBlockHeaderVerboseResult
+	err = b.callRPC(ctx, "getblockheader", func(ctx context.Context) (err error) {
+		header, err = b.client.GetBlockHeaderVerbose(ctx, blockHash)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.Block{
+		Hash:   header.Hash,
+		Height: int64(header.Height),
+		Time:   utils.ParseUnixTimestamp(header.Time),
+	}, nil
+}
+
+// ImportDescriptors imports the descriptors into the wallet as watch-only,
+// and rescans the blockchain from the earliest descriptor timestamp. All
+// descriptors are imported in a single RPC call, so that the blockchain is
+// rescanned only once.
+//
+// If native is true, the wallet is expected to be a descriptor wallet, and
+// the importdescriptors RPC is used. Otherwise, the legacy importmulti RPC
+// is used.
+//
+// The returned slice holds the outcome of the import of each descriptor, in
+// the same order: nil if it was imported successfully. The successful
+// imports are kept by the wallet even if others failed. An error is returned
+// only if the RPC call failed as a whole.
+func ImportDescriptors(client *rpcClient, descriptors []descriptor, native bool) ([]error, error) {
+	if native {
+		return importDescriptors(client, descriptors)
+	}
+
+	return importMulti(client, descriptors)
+}
+
+// importFailures returns the number of descriptors that failed to import,
+// along with the first failure, if any.
+func importFailures(failures []error) (int, error) {
+	var count int
+	var first error
+
+	for _, err := range failures {
+		if err == nil {
+			continue
+		}
+
+		if first == nil {
+			first = err
+		}
+
+		count++
+	}
+
+	return count, first
+}
+
+// importDescriptorsRequest models a single request of the importdescriptors
+// RPC, which is not supported by rpcclient.
+type importDescriptorsRequest struct {
+	Descriptor string                 `json:"desc"`
+	Active     bool                   `json:"active"`
+	Range      []int                  `json:"range,omitempty"` // must be omitted for non-ranged descriptors
+	Timestamp  btcjson.TimestampOrNow `json:"timestamp"`
+	Internal   bool                   `json:"internal,omitempty"` // must be omitted for multipath descriptors
+	Label      string                 `json:"label,omitempty"`    // must be omitted for ranged descriptors
+}
+
+// importDescriptorsResult models a single result of the importdescriptors
+// RPC, in the same order as the requests.
+type importDescriptorsResult struct {
+	Success  bool              `json:"success"`
+	Warnings []string          `json:"warnings,omitempty"`
+	Error    *btcjson.RPCError `json:"error,omitempty"`
+}
+
+// importDescriptors imports the descriptors with the importdescriptors RPC.
+// Descriptors expanded from the same multipath descriptor are imported at
+// once, as the multipath descriptor, if the node supports it, and share the
+// outcome of its import.
+func importDescriptors(client *rpcClient, descriptors []descriptor) ([]error, error) {
+	requests := make([]importDescriptorsRequest, 0, len(descriptors))
+	multipaths := make(map[string]int) // index of the request of each multipath descriptor
+
+	// Index of the request of each descriptor.
+	requestIdx := make([]int, len(descriptors))
+
+	for idx, descriptor := range descriptors {
+		value := descriptor.Value
+		if descriptor.Multipath != "" {
+			if reqIdx, ok := multipaths[descriptor.Multipath]; ok {
+				requestIdx[idx] = reqIdx
+				continue
+			}
+
+			multipaths[descriptor.Multipath] = len(requests)
+			value = descriptor.Multipath
+		}
+
+		request := importDescriptorsRequest{
+			Descriptor: value,
+			Active:     false,
+			Timestamp:  btcjson.TimestampOrNow{Value: descriptor.Age},
+			Internal:   false,
+		}
+
+		// bitcoind rejects the labels of ranged descriptors, so only single
+		// addresses are labeled.
+		if descriptor.ranged() {
+			request.Range = []int{0, descriptor.Depth}
+		} else {
+			request.Label = descriptor.Label
+		}
+
+		requestIdx[idx] = len(requests)
+		requests = append(requests, request)
+	}
+
+	raw, err := rawRequest(context.Background(), client, "importdescriptors", requests)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []importDescriptorsResult
+	if err := json.Unmarshal(raw, &results); err != nil {
+		return nil, err
+	}
+
+	if len(results) != len(requests) {
+		return nil, fmt.Errorf("importdescriptors RPC returned %d results for %d descriptors",
+			len(results), len(requests))
+	}
+
+	requestFailures := checkImportResults(results, requests)
+
+	failures := make([]error, len(descriptors))
+	for idx := range descriptors {
+		failures[idx] = requestFailures[requestIdx[idx]]
+	}
+
+	return failures, nil
 }
 
-func ImportDescriptors(client *rpcclient.Client, descriptors []descriptor) error {
+// checkImportResults logs the outcome of each importdescriptors request, and
+// returns the error of each failed request, in the same order.
+func checkImportResults(results []importDescriptorsResult, requests []importDescriptorsRequest) []error {
+	failures := make([]error, len(results))
+
+	for idx, result := range results {
+		fields := log.WithFields(log.Fields{
+			"descriptor": redact.Descriptor(requests[idx].Descriptor),
+		})
+
+		if result.Warnings != nil {
+			fields.WithField("warnings", result.Warnings).Warn("Imported descriptor with warnings")
+		}
+
+		if !result.Success {
+			fields.WithField("error", result.Error).Error("Failed to import descriptor")
+			failures[idx] = importError(requests[idx].Descriptor, result.Error)
+			continue
+		}
+
+		fields.Debug("Import descriptor successfully")
+	}
+
+	return failures
+}
+
+// importError describes the failure of the import of a descriptor. Since
+// bitcoind does not always report the reason, the RPC error may be nil.
+func importError(desc string, rpcErr *btcjson.RPCError) error {
+	if rpcErr == nil {
+		return fmt.Errorf("%s (%s)", ErrImportFailed, redact.Descriptor(desc))
+	}
+
+	return fmt.Errorf("%s (%s): %w", ErrImportFailed, redact.Descriptor(desc), rpcErr)
+}
+
+func importMulti(client *rpcClient, descriptors []descriptor) ([]error, error) {
 	var requests []btcjson.ImportMultiRequest
 	for _, descriptor := range descriptors {
-		requests = append(requests, btcjson.ImportMultiRequest{
+		request := btcjson.ImportMultiRequest{
 			Descriptor: btcjson.String(descriptor.Value),
-			Range:      &btcjson.DescriptorRange{Value: []int{0, descriptor.Depth}},
 			Timestamp:  btcjson.TimestampOrNow{Value: descriptor.Age},
 			WatchOnly:  btcjson.Bool(true),
 			KeyPool:    btcjson.Bool(false),
 			Internal:   btcjson.Bool(false),
-		})
+		}
+
+		if descriptor.Label != "" {
+			request.Label = btcjson.String(descriptor.Label)
+		}
+
+		// Descriptors without wildcard, like the addr() descriptor of an
+		// account watching a single address, are imported without range.
+		if descriptor.ranged() {
+			request.Range = &btcjson.DescriptorRange{Value: []int{0, descriptor.Depth}}
+		}
+
+		requests = append(requests, request)
 	}
 
 	opts := &btcjson.ImportMultiOptions{Rescan: true}
 
-	results, err := client.ImportMulti(requests, opts)
+	results, err := client.ImportMulti(context.Background(), requests, opts)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	var hasError bool
+	if len(results) != len(requests) {
+		return nil, fmt.Errorf("importmulti RPC returned %d results for %d descriptors",
+			len(results), len(requests))
+	}
+
+	failures := make([]error, len(requests))
 
 	for idx, result := range results {
 		fields := log.WithFields(log.Fields{
-			"descriptor": *requests[idx].Descriptor,
+			"descriptor": redact.Descriptor(*requests[idx].Descriptor),
 		})
 
-		if result.Error != nil {
-			fields.Error("Failed to import descriptor")
-			hasError = true
-		}
-
-		if result.Success {
-			fields.Debug("Import descriptor successfully")
+		if result.Error != nil || !result.Success {
+			fields.WithField("error", result.Error).Error("Failed to import descriptor")
+			failures[idx] = importError(*requests[idx].Descriptor, result.Error)
+			continue
 		}
-	}
 
-	if hasError {
-		return fmt.Errorf("importmulti RPC failed")
+		fields.Debug("Import descriptor successfully")
 	}
 
-	return nil
+	return failures, nil
 }
 
-func (b *Bus) GetTransaction(hash string) (*types.Transaction, error) {
-	if b.Cache != nil { // Cache has been enabled at the svc level
-		if tx, found := b.Cache.Get(hash); found {
-			return tx.(*types.Transaction), nil
-		}
+func (b *Bus) GetTransaction(ctx context.Context, hash string) (*types.Transaction, error) {
+	// The inputs and outputs of a transaction never change, so a cached
+	// copy can be built upon by the caller.
+	if tx, found := b.CachedTransaction(ctx, hash); found {
+		return tx, nil
 	}
 
-	chainHash, err := utils.ParseChainHash(hash)
+	// Concurrent requests for the same transaction share a single RPC call,
+	// and each caller gets its own copy to build upon.
+	method := "gettransaction"
+	if b.Capabilities().TxIndex {
+		method = "getrawtransaction"
+	}
+
+	tx, err := b.flights.do(ctx, method+":"+hash,
+		func(ctx context.Context) (interface{}, error) {
+			return b.fetchTransaction(ctx, hash)
+		})
 	if err != nil {
 		return nil, err
 	}
 
-	var tx *types.Transaction
+	return copyTransaction(tx.(*types.Transaction)), nil
+}
 
-	switch b.TxIndex {
-	case true:
-		txRaw, err := b.mainClient.GetRawTransaction(chainHash)
-		if err != nil {
-			return nil, err
-		}
+// fetchTransaction gets the transaction with the getrawtransaction RPC if
+// bitcoind has a transaction index, or from the wallet otherwise.
+func (b *Bus) fetchTransaction(ctx context.Context, hash string) (*types.Transaction, error) {
+	chainHash, err := utils.ParseChainHash(hash)
+	if err != nil {
+		return nil, err
+	}
 
-		tx = protocol.DecodeMsgTx(txRaw.MsgTx(), b.Params)
+	if b.Capabilities().TxIndex {
+		return b.fetchRawTransaction(ctx, chainHash)
+	}
 
-	case false:
-		txRaw, err := b.mainClient.GetTransactionWatchOnly(chainHash, true)
-		if err != nil {
-			return nil, err
-		}
+	return b.fetchWalletTransaction(ctx, chainHash)
+}
 
-		tx, err = protocol.DecodeRawTransaction(txRaw.Hex, b.Params)
-		if err != nil {
-			return nil, err
-		}
+// fetchRawTransaction gets the transaction with the getrawtransaction RPC,
+// which finds any transaction if bitcoind has a transaction index, and the
+// transactions of the mempool otherwise.
+func (b *Bus) fetchRawTransaction(ctx context.Context, hash *chainhash.Hash) (*types.Transaction, error) {
+	var txRaw *btcutil.Tx
+	err := b.callRPC(ctx, "getrawtransaction", func(ctx context.Context) (err error) {
+		txRaw, err = b.client.GetRawTransaction(ctx, hash)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	if b.Cache != nil {
-		b.Cache.Set(hash, tx, cache.NoExpiration)
+	return protocol.DecodeMsgTx(txRaw.MsgTx(), b.Params), nil
+}
+
+// fetchWalletTransaction gets the transaction from the wallet, with the
+// gettransaction RPC.
+func (b *Bus) fetchWalletTransaction(ctx context.Context, hash *chainhash.Hash) (*types.Transaction, error) {
+	var txRaw *btcjson.GetTransactionResult
+	err := b.callRPC(ctx, "gettransaction", func(ctx context.Context) (err error) {
+		txRaw, err = b.client.GetTransactionWatchOnly(ctx, hash, true)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return tx, nil
+	return protocol.DecodeRawTransaction(txRaw.Hex, b.Params)
 }
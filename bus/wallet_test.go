@@ -0,0 +1,294 @@
+package bus
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcjson"
+)
+
+// testDescriptors are a ranged descriptor, and the addr() descriptor of an
+// account watching a single address.
+var testDescriptors = []descriptor{
+	{
+		Value: "wpkh(tpubD6NzVbkrYhZ4XgiXtGrdW5XDAPFCL9h7we1vwNCpn8tGbBcgfVYjXyhWo4E1xkh56hjod1RhGjxbaTLV3X4FyWuejifB9jusQ46QzG87VKp/0/*)",
+		Depth: 10,
+		Age:   1600000000,
+	},
+	{
+		Value:   "addr(bcrt1qw508d6qejxtdg4y5r3zarvary0c5xw7kygt080)",
+		Age:     1600000000,
+		Address: "bcrt1qw508d6qejxtdg4y5r3zarvary0c5xw7kygt080",
+		Label:   "savings",
+	},
+}
+
+func TestImportDescriptorsPartialSuccess(t *testing.T) {
+	node := newFakeNode()
+
+	var requests []importDescriptorsRequest
+	node.handle("importdescriptors", func(params []json.RawMessage) (interface{}, error) {
+		param(params, 0, &requests)
+
+		return []importDescriptorsResult{
+			{Success: true},
+			{Success: false, Error: btcjson.NewRPCError(btcjson.ErrRPCInvalidParameter, "Invalid address")},
+		}, nil
+	})
+
+	failures, err := ImportDescriptors(newTestBus(node).client, testDescriptors, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(requests) != 2 {
+		t.Fatalf("got %d requests, want 2", len(requests))
+	}
+
+	if got := requests[0].Range; len(got) != 2 || got[1] != 10 || requests[0].Label != "" {
+		t.Errorf("ranged descriptor: got range %v, label %q, want [0 10] without label", got, requests[0].Label)
+	}
+
+	if requests[1].Range != nil || requests[1].Label != "savings" {
+		t.Errorf("single address: got range %v, label %q, want no range, label savings",
+			requests[1].Range, requests[1].Label)
+	}
+
+	count, first := importFailures(failures)
+	if count != 1 || failures[0] != nil {
+		t.Fatalf("got failures %v, want only the second descriptor", failures)
+	}
+
+	var rpcErr *btcjson.RPCError
+	if !errors.As(first, &rpcErr) || rpcErr.Code != btcjson.ErrRPCInvalidParameter {
+		t.Errorf("got error %v, want the RPC error of the import", first)
+	}
+
+	if !strings.HasPrefix(first.Error(), ErrImportFailed.Error()) {
+		t.Errorf("got error %q, want prefix %q", first, ErrImportFailed)
+	}
+}
+
+func TestImportDescriptorsMultipath(t *testing.T) {
+	const multipath = "wpkh(tpubD6NzVbkrYhZ4XgiXtGrdW5XDAPFCL9h7we1vwNCpn8tGbBcgfVYjXyhWo4E1xkh56hjod1RhGjxbaTLV3X4FyWuejifB9jusQ46QzG87VKp/<0;1>/*)"
+
+	node := newFakeNode()
+	node.handle("importdescriptors", func(params []json.RawMessage) (interface{}, error) {
+		var requests []importDescriptorsRequest
+		param(params, 0, &requests)
+
+		if len(requests) != 1 || requests[0].Descriptor != multipath {
+			t.Errorf("got requests %+v, want the multipath descriptor only", requests)
+		}
+
+		return []importDescriptorsResult{{Success: false}}, nil
+	})
+
+	descriptors := []descriptor{
+		{Value: strings.Replace(multipath, "<0;1>", "0", 1), Multipath: multipath, Depth: 10},
+		{Value: strings.Replace(multipath, "<0;1>", "1", 1), Multipath: multipath, Depth: 10},
+	}
+
+	failures, err := ImportDescriptors(newTestBus(node).client, descriptors, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count, _ := importFailures(failures); count != 2 {
+		t.Errorf("got %d failures, want both descriptors to share the failure", count)
+	}
+}
+
+func TestImportDescriptorsResultCount(t *testing.T) {
+	node := newFakeNode()
+	node.result("importdescriptors", []importDescriptorsResult{{Success: true}})
+
+	if _, err := ImportDescriptors(newTestBus(node).client, testDescriptors, true); err == nil {
+		t.Error("got no error for a missing result")
+	}
+}
+
+func TestImportMultiPartialSuccess(t *testing.T) {
+	node := newFakeNode()
+
+	var requests []btcjson.ImportMultiRequest
+	var opts btcjson.ImportMultiOptions
+	node.handle("importmulti", func(params []json.RawMessage) (interface{}, error) {
+		param(params, 0, &requests)
+		param(params, 1, &opts)
+
+		return btcjson.ImportMultiResults{
+			{Success: false, Error: btcjson.NewRPCError(btcjson.ErrRPCWallet, "Rescan failed")},
+			{Success: true},
+		}, nil
+	})
+
+	failures, err := ImportDescriptors(newTestBus(node).client, testDescriptors, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if node.count("importdescriptors") != 0 {
+		t.Error("importdescriptors called for a legacy wallet")
+	}
+
+	if !opts.Rescan {
+		t.Error("got importmulti without rescan")
+	}
+
+	if len(requests) != 2 || requests[0].Range == nil || requests[1].Range != nil {
+		t.Fatalf("got requests %+v, want a range for the ranged descriptor only", requests)
+	}
+
+	if requests[1].Label == nil || *requests[1].Label != "savings" {
+		t.Errorf("got label %v, want savings", requests[1].Label)
+	}
+
+	count, first := importFailures(failures)
+	if count != 1 || failures[1] != nil {
+		t.Fatalf("got failures %v, want only the first descriptor", failures)
+	}
+
+	var rpcErr *btcjson.RPCError
+	if !errors.As(first, &rpcErr) || rpcErr.Code != btcjson.ErrRPCWallet {
+		t.Errorf("got error %v, want the RPC error of the import", first)
+	}
+}
+
+// walletNode returns a node without any wallet named walletName, which
+// records the parameters of createwallet.
+func walletNode(created *[]json.RawMessage) *fakeNode {
+	node := newFakeNode()
+	node.handle("loadwallet", func([]json.RawMessage) (interface{}, error) {
+		return nil, btcjson.NewRPCError(btcjson.ErrRPCWalletNotFound, "Wallet file verification failed")
+	})
+	node.handle("createwallet", func(params []json.RawMessage) (interface{}, error) {
+		*created = params
+		return btcjson.CreateWalletResult{Name: defaultWalletName}, nil
+	})
+
+	return node
+}
+
+func TestLoadOrCreateWallet(t *testing.T) {
+	tests := []struct {
+		name        string
+		descriptors bool
+	}{
+		{"descriptor wallet", true},
+		{"legacy wallet", false},
+	}
+
+	for _, test := range tests {
+		var params []json.RawMessage
+		node := walletNode(&params)
+
+		created, err := loadOrCreateWallet(newTestBus(node).client, defaultWalletName, test.descriptors)
+		if err != nil || !created {
+			t.Errorf("%s: got created %v, error %v, want wallet created", test.name, created, err)
+			continue
+		}
+
+		var name string
+		var disablePrivateKeys bool
+		param(params, 0, &name)
+		param(params, 1, &disablePrivateKeys)
+
+		if name != defaultWalletName || !disablePrivateKeys {
+			t.Errorf("%s: got wallet %q, disable_private_keys %v, want %q, true",
+				test.name, name, disablePrivateKeys, defaultWalletName)
+		}
+
+		var descriptors bool
+		if len(params) > 5 {
+			param(params, 5, &descriptors)
+		}
+
+		if descriptors != test.descriptors {
+			t.Errorf("%s: got descriptors %v, want %v", test.name, descriptors, test.descriptors)
+		}
+	}
+}
+
+func TestLoadOrCreateWalletLoaded(t *testing.T) {
+	tests := []struct {
+		name string
+		err  *btcjson.RPCError
+	}{
+		{"loaded", nil},
+		{"already loaded", btcjson.NewRPCError(errRPCWalletAlreadyLoaded, "Wallet already loaded")},
+		{"duplicate", btcjson.NewRPCError(btcjson.ErrRPCWallet, errDuplicateWalletLoadMsg)},
+	}
+
+	for _, test := range tests {
+		var params []json.RawMessage
+		node := walletNode(&params)
+		node.handle("loadwallet", func([]json.RawMessage) (interface{}, error) {
+			if test.err != nil {
+				return nil, test.err
+			}
+
+			return btcjson.LoadWalletResult{Name: defaultWalletName}, nil
+		})
+
+		created, err := loadOrCreateWallet(newTestBus(node).client, defaultWalletName, true)
+		if err != nil || created || node.count("createwallet") != 0 {
+			t.Errorf("%s: got created %v, error %v, want wallet loaded", test.name, created, err)
+		}
+	}
+}
+
+func TestLoadOrCreateWalletDisabled(t *testing.T) {
+	// Without handlers, every method is reported as not found, like on a
+	// node with the wallet disabled.
+	_, err := loadOrCreateWallet(newTestBus(newFakeNode()).client, defaultWalletName, true)
+	if !errors.Is(err, ErrWalletDisabled) {
+		t.Errorf("got error %v, want %v", err, ErrWalletDisabled)
+	}
+}
+
+func TestWalletProperties(t *testing.T) {
+	tests := []struct {
+		name   string
+		result map[string]interface{}
+		want   walletProperties
+	}{
+		{
+			"descriptor wallet",
+			map[string]interface{}{"descriptors": true, "private_keys_enabled": false},
+			walletProperties{Descriptors: true},
+		},
+		{
+			// Nodes predating descriptor wallets omit the field.
+			"legacy wallet",
+			map[string]interface{}{"private_keys_enabled": false},
+			walletProperties{},
+		},
+		{
+			"wallet with private keys",
+			map[string]interface{}{"descriptors": true, "private_keys_enabled": true},
+			walletProperties{Descriptors: true, PrivateKeysEnabled: true},
+		},
+	}
+
+	for _, test := range tests {
+		node := newFakeNode()
+		node.result("getwalletinfo", test.result)
+
+		info, err := getWalletProperties(newTestBus(node).client)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if *info != test.want {
+			t.Errorf("%s: got %+v, want %+v", test.name, *info, test.want)
+		}
+
+		if err := checkWatchOnly(defaultWalletName, info); (err != nil) != test.want.PrivateKeysEnabled {
+			t.Errorf("%s: got watch-only error %v", test.name, err)
+		}
+	}
+}
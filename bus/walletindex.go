@@ -0,0 +1,534 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ledgerhq/satstack/redact"
+	"github.com/ledgerhq/satstack/utils"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	log "github.com/sirupsen/logrus"
+)
+
+// walletIndexInterval is the interval at which the wallet index is synced
+// with the wallet, in addition to every change of the chain tip, so that
+// new mempool transactions are picked up.
+const walletIndexInterval = 5 * time.Second
+
+// walletIndex is an in-memory index of the wallet transactions, as listed by
+// the listsinceblock RPC, which is kept in sync incrementally by the worker.
+//
+// The index is invalidated on chain reorganizations, and while descriptors
+// are imported or the blockchain rescanned, since transactions may then be
+// found in blocks that were already processed. It is rebuilt from scratch
+// on the next sync. Meanwhile, wallet transactions are listed from the node.
+//
+// Each sync also feeds the transaction events of the subscribers of the Bus,
+// so that the wallet is polled with listsinceblock by the index only.
+type walletIndex struct {
+	mu sync.RWMutex
+
+	ready     bool
+	lastBlock *chainhash.Hash // block up to which transactions were listed
+
+	// generation is incremented when the index is invalidated, so that a
+	// sync started before is not applied.
+	generation uint64
+
+	// Entries of listsinceblock by txid, and txids by address. The sequence
+	// number of each transaction preserves the order of the wallet.
+	txs       map[string]*indexedTransaction
+	byAddress map[string]map[string]struct{}
+	seq       uint64
+
+	// heights of the blocks of the indexed transactions, by hash, so that
+	// the blocks passed to list are resolved without a getblockheader call.
+	heights map[string]int64
+}
+
+type indexedTransaction struct {
+	seq     uint64
+	entries []btcjson.ListTransactionsResult
+}
+
+func (tx *indexedTransaction) sent() bool {
+	for _, entry := range tx.entries {
+		if entry.Category == "send" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// listSinceBlockResult models the result of the listsinceblock RPC, with the
+// removed field that is not supported by rpcclient.
+type listSinceBlockResult struct {
+	Transactions []btcjson.ListTransactionsResult `json:"transactions"`
+	Removed      []btcjson.ListTransactionsResult `json:"removed"`
+	LastBlock    string                           `json:"lastblock"`
+}
+
+// invalidate marks the index as stale, so that it is rebuilt on the next
+// sync, and not read meanwhile.
+func (idx *walletIndex) invalidate() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.ready = false
+	idx.generation++
+}
+
+// state returns whether the index is ready, its last block, and its
+// generation, to be passed to reset or update.
+func (idx *walletIndex) state() (bool, *chainhash.Hash, uint64) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	return idx.ready, idx.lastBlock, idx.generation
+}
+
+// reset replaces the contents of the index with the given transactions,
+// unless it was invalidated since the given generation.
+func (idx *walletIndex) reset(txs []btcjson.ListTransactionsResult, lastBlock *chainhash.Hash, generation uint64) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if idx.generation != generation {
+		return
+	}
+
+	idx.txs = make(map[string]*indexedTransaction)
+	idx.byAddress = make(map[string]map[string]struct{})
+	idx.heights = make(map[string]int64)
+	idx.seq = 0
+
+	idx.add(txs)
+
+	idx.lastBlock = lastBlock
+	idx.ready = true
+}
+
+// update applies the transactions listed since the last block of the index,
+// unless it was invalidated since the given generation. Unconfirmed
+// transactions are always listed, so the ones of the previous sync are
+// dropped beforehand, in case they left the mempool.
+//
+// It returns the events of the transactions that entered the mempool, or got
+// their first confirmation, since the previous sync.
+func (idx *walletIndex) update(
+	txs []btcjson.ListTransactionsResult, lastBlock *chainhash.Hash, generation uint64,
+) []*TransactionEvent {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if idx.generation != generation {
+		return nil
+	}
+
+	mempool := make(map[string]bool)
+	for txid, tx := range idx.txs {
+		if tx.entries[0].BlockHash == "" {
+			mempool[txid] = tx.entries[0].Confirmations == 0
+			idx.remove(txid)
+		}
+	}
+
+	idx.add(txs)
+
+	idx.lastBlock = lastBlock
+
+	// Transactions confirmed in the blocks already indexed are not listed
+	// anymore, so the confirmed ones are all new.
+	return transactionEvents(txs, mempool)
+}
+
+// add must be called with the lock held.
+func (idx *walletIndex) add(txs []btcjson.ListTransactionsResult) {
+	// listsinceblock returns an entry per address of each transaction, which
+	// replace the entries of a previous sync.
+	fresh := make(map[string]bool)
+
+	for _, entry := range txs {
+		tx, ok := idx.txs[entry.TxID]
+		switch {
+		case !ok:
+			idx.seq++
+			tx = &indexedTransaction{seq: idx.seq}
+			idx.txs[entry.TxID] = tx
+		case !fresh[entry.TxID]:
+			idx.remove(entry.TxID)
+			tx = &indexedTransaction{seq: tx.seq}
+			idx.txs[entry.TxID] = tx
+		}
+
+		fresh[entry.TxID] = true
+		tx.entries = append(tx.entries, entry)
+
+		if entry.BlockHash != "" && entry.BlockHeight != nil {
+			idx.heights[entry.BlockHash] = int64(*entry.BlockHeight)
+		}
+
+		if entry.Address != "" {
+			txids, ok := idx.byAddress[entry.Address]
+			if !ok {
+				txids = make(map[string]struct{})
+				idx.byAddress[entry.Address] = txids
+			}
+
+			txids[entry.TxID] = struct{}{}
+		}
+	}
+}
+
+// remove must be called with the lock held.
+func (idx *walletIndex) remove(txid string) {
+	tx, ok := idx.txs[txid]
+	if !ok {
+		return
+	}
+
+	for _, entry := range tx.entries {
+		if txids, ok := idx.byAddress[entry.Address]; ok {
+			delete(txids, txid)
+			if len(txids) == 0 {
+				delete(idx.byAddress, entry.Address)
+			}
+		}
+	}
+
+	delete(idx.txs, txid)
+}
+
+// list returns the entries of the transactions confirmed above sinceHeight,
+// or unconfirmed, in the order of the wallet. If addresses is not nil, only
+// the transactions involving one of the addresses, and the transactions sent
+// by the wallet, are returned. It returns false if the index is not ready.
+//
+// The confirmations of the entries are recomputed from the best height.
+func (idx *walletIndex) list(
+	addresses []string, sinceHeight int64, bestHeight int64,
+) ([]btcjson.ListTransactionsResult, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	if !idx.ready {
+		return nil, false
+	}
+
+	var txs []*indexedTransaction
+	switch addresses {
+	case nil:
+		txs = make([]*indexedTransaction, 0, len(idx.txs))
+		for _, tx := range idx.txs {
+			txs = append(txs, tx)
+		}
+	default:
+		selected := make(map[string]bool)
+		for _, address := range addresses {
+			for txid := range idx.byAddress[address] {
+				selected[txid] = true
+			}
+		}
+
+		for txid, tx := range idx.txs {
+			// The inputs of sent transactions must be checked by the caller.
+			if selected[txid] || tx.sent() {
+				txs = append(txs, tx)
+			}
+		}
+	}
+
+	sort.Slice(txs, func(i, j int) bool {
+		return txs[i].seq < txs[j].seq
+	})
+
+	var ret []btcjson.ListTransactionsResult
+	for _, tx := range txs {
+		for _, entry := range tx.entries {
+			if entry.BlockHeight != nil && int64(*entry.BlockHeight) <= sinceHeight {
+				continue
+			}
+
+			if entry.BlockHeight != nil && entry.BlockHash != "" {
+				// The block may be above the cached tip, until it is refreshed.
+				entry.Confirmations = bestHeight - int64(*entry.BlockHeight) + 1
+				if entry.Confirmations < 1 {
+					entry.Confirmations = 1
+				}
+			}
+
+			ret = append(ret, entry)
+		}
+	}
+
+	return ret, true
+}
+
+// blockHeight returns the height of a block of the indexed transactions. It
+// returns false if the block is unknown, or the index is not ready.
+func (idx *walletIndex) blockHeight(hash string) (int64, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	if !idx.ready {
+		return 0, false
+	}
+
+	height, ok := idx.heights[hash]
+	return height, ok
+}
+
+// version returns the parts of a version of the transactions that list
+// would return for the addresses: the generation and the last block of the
+// index, followed by the txids of the unconfirmed transactions, sorted. It
+// returns false if the index is not ready.
+//
+// The last block is part of the version since the index is synced apart
+// from the cached chain tip, so that transactions confirmed in a new block
+// may be listed before the tip moves.
+func (idx *walletIndex) version(addresses []string) ([]string, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	if !idx.ready {
+		return nil, false
+	}
+
+	selected := make(map[string]bool)
+	for _, address := range addresses {
+		for txid := range idx.byAddress[address] {
+			selected[txid] = true
+		}
+	}
+
+	var txids []string
+	for txid, tx := range idx.txs {
+		if tx.entries[0].BlockHash == "" && (selected[txid] || tx.sent()) {
+			txids = append(txids, txid)
+		}
+	}
+
+	sort.Strings(txids)
+
+	lastBlock := ""
+	if idx.lastBlock != nil {
+		lastBlock = idx.lastBlock.String()
+	}
+
+	return append([]string{strconv.FormatUint(idx.generation, 10), lastBlock}, txids...), true
+}
+
+// indexedTransactions lists the wallet transactions from the index, if it is
+// ready. See walletIndex.list.
+//
+// If blockHash is not nil, only transactions after this block are listed.
+// Like with listsinceblock, a block that is not in the best chain lists the
+// transactions after the fork point, which is left to the node.
+//
+// The block is usually the one of the last transaction of a previous page,
+// whose height is known to the index. Other blocks are looked up with
+// GetBlockHeader, which caches the headers of deep blocks.
+func (b *Bus) indexedTransactions(
+	ctx context.Context, addresses []string, blockHash *string,
+) ([]btcjson.ListTransactionsResult, bool) {
+	bestHeight, err := b.GetBestBlockHeight()
+	if err != nil {
+		return nil, false
+	}
+
+	sinceHeight := int64(-1)
+	if blockHash != nil {
+		height, ok := b.walletIndex.blockHeight(*blockHash)
+		if !ok {
+			if height, ok = b.bestChainHeight(ctx, *blockHash); !ok {
+				return nil, false
+			}
+		}
+
+		sinceHeight = height
+	}
+
+	return b.walletIndex.list(addresses, sinceHeight, bestHeight)
+}
+
+// bestChainHeight returns the height of the block with the given hash, or
+// false if it is not in the best chain.
+func (b *Bus) bestChainHeight(ctx context.Context, blockHash string) (int64, bool) {
+	hash, err := utils.ParseChainHash(blockHash)
+	if err != nil {
+		return 0, false
+	}
+
+	// Blocks that are not in the best chain have -1 confirmations.
+	header, err := b.GetBlockHeader(ctx, hash)
+	if err != nil || header.Confirmations < 0 {
+		return 0, false
+	}
+
+	return header.Height, true
+}
+
+// fillBlockHeights sets the block height of the confirmed entries, which
+// listsinceblock reports since bitcoind v0.21.0 only, from the block hash.
+// Without it, the confirmations of the index could not be recomputed from
+// the best height.
+func (b *Bus) fillBlockHeights(ctx context.Context, txs []btcjson.ListTransactionsResult) error {
+	heights := make(map[string]int32)
+
+	for idx := range txs {
+		entry := &txs[idx]
+		if entry.BlockHash == "" || entry.BlockHeight != nil {
+			continue
+		}
+
+		height, ok := heights[entry.BlockHash]
+		if !ok {
+			found, ok := b.walletIndex.blockHeight(entry.BlockHash)
+			if !ok {
+				if found, ok = b.bestChainHeight(ctx, entry.BlockHash); !ok {
+					return fmt.Errorf("block of transaction %s not in best chain: %s",
+						redact.TxID(entry.TxID), entry.BlockHash)
+				}
+			}
+
+			height = int32(found)
+			heights[entry.BlockHash] = height
+		}
+
+		entry.BlockHeight = &height
+	}
+
+	return nil
+}
+
+// syncWalletIndex brings the wallet index up to date, using the transactions
+// listed since its last block. The index is rebuilt from scratch if it is
+// not ready, or if a reorganization removed transactions from the chain.
+//
+// The transaction events of an update are published to the subscribers. A
+// rebuild publishes none, rather than reporting the whole wallet.
+func (b *Bus) syncWalletIndex() error {
+	// Transactions are found in past blocks while descriptors are imported,
+	// or the blockchain rescanned.
+	if b.IsPendingScan() || atomic.LoadInt32(&b.importing) > 0 {
+		b.walletIndex.invalidate()
+		return nil
+	}
+
+	ready, lastBlock, generation := b.walletIndex.state()
+
+	if !ready {
+		lastBlock = nil
+	}
+
+	result, err := b.listSinceBlock(lastBlock)
+	if err != nil {
+		return err
+	}
+
+	b.recordFirstSeen(result.Transactions)
+
+	newLastBlock, err := chainhash.NewHashFromStr(result.LastBlock)
+	if err != nil {
+		return err
+	}
+
+	if lastBlock != nil && len(result.Removed) > 0 {
+		log.WithFields(log.Fields{
+			"prefix":  "index",
+			"removed": len(result.Removed),
+		}).Info("Wallet transactions removed by reorganization, rebuilding index")
+
+		if result, err = b.listSinceBlock(nil); err != nil {
+			return err
+		}
+
+		if newLastBlock, err = chainhash.NewHashFromStr(result.LastBlock); err != nil {
+			return err
+		}
+
+		lastBlock = nil
+	}
+
+	if err := b.fillBlockHeights(context.Background(), result.Transactions); err != nil {
+		return err
+	}
+
+	if lastBlock == nil {
+		b.walletIndex.reset(result.Transactions, newLastBlock, generation)
+
+		log.WithFields(log.Fields{
+			"prefix":  "index",
+			"entries": len(result.Transactions),
+		}).Info("Built wallet transaction index")
+
+		return nil
+	}
+
+	for _, event := range b.walletIndex.update(result.Transactions, newLastBlock, generation) {
+		b.publish(Event{Type: EventTransaction, Transaction: event})
+	}
+
+	return nil
+}
+
+// listSinceBlock lists the wallet transactions confirmed after the given
+// block, or all of them if nil, along with the unconfirmed ones.
+func (b *Bus) listSinceBlock(blockHash *chainhash.Hash) (*listSinceBlockResult, error) {
+	var hash string
+	if blockHash != nil {
+		hash = blockHash.String()
+	}
+
+	raw, err := rawRequest(context.Background(), b.client, "listsinceblock", hash, 1, true, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var result listSinceBlockResult
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// indexWallet keeps the wallet index in sync, on every change of the chain
+// tip, and periodically for mempool transactions. It blocks until the
+// context is cancelled.
+func indexWallet(ctx context.Context, b *Bus) {
+	for {
+		if err := b.syncWalletIndex(); err != nil {
+			b.walletIndex.invalidate()
+
+			log.WithFields(log.Fields{
+				"prefix": "index",
+				"error":  err,
+			}).Debug("Failed to sync wallet transaction index")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-b.walletIndexSync:
+		case <-time.After(walletIndexInterval):
+		}
+	}
+}
+
+// signalWalletIndex requests a sync of the wallet index, without blocking.
+func (b *Bus) signalWalletIndex() {
+	select {
+	case b.walletIndexSync <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,330 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// mockWallet is a wallet on a mockChain, whose transactions are listed by
+// listsinceblock.
+type mockWallet struct {
+	chain *mockChain
+
+	// legacy omits the block height of the entries, like bitcoind before
+	// v0.21.0.
+	legacy bool
+
+	mu      sync.Mutex
+	entries []mockWalletEntry
+}
+
+type mockWalletEntry struct {
+	txid    string
+	address string
+	height  int64 // of the block on the best chain, or -1 if unconfirmed
+}
+
+// add adds an entry of a transaction, unconfirmed if height is -1.
+func (w *mockWallet) add(txid byte, address string, height int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.entries = append(w.entries, mockWalletEntry{
+		txid:    (&chainhash.Hash{txid}).String(),
+		address: address,
+		height:  height,
+	})
+}
+
+// confirm moves the unconfirmed entries of a transaction to a block.
+func (w *mockWallet) confirm(txid byte, height int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for idx := range w.entries {
+		if w.entries[idx].txid == (&chainhash.Hash{txid}).String() {
+			w.entries[idx].height = height
+		}
+	}
+}
+
+// node returns the fakeNode of the chain, answering listsinceblock with the
+// entries confirmed after the given block, and the unconfirmed ones.
+func (w *mockWallet) node() *fakeNode {
+	node := w.chain.node()
+
+	node.handle("listsinceblock", func(params []json.RawMessage) (interface{}, error) {
+		var hash string
+		param(params, 0, &hash)
+
+		w.chain.mu.Lock()
+		defer w.chain.mu.Unlock()
+
+		w.mu.Lock()
+		defer w.mu.Unlock()
+
+		sinceHeight := int64(-1)
+		if header, ok := w.chain.headers[hash]; ok {
+			sinceHeight = int64(header.Height)
+		}
+
+		tip := int64(len(w.chain.best) - 1)
+		result := listSinceBlockResult{
+			Transactions: []btcjson.ListTransactionsResult{},
+			LastBlock:    w.chain.best[tip],
+		}
+
+		for _, entry := range w.entries {
+			if entry.height >= 0 && entry.height <= sinceHeight {
+				continue
+			}
+
+			tx := btcjson.ListTransactionsResult{
+				TxID:     entry.txid,
+				Address:  entry.address,
+				Category: "receive",
+			}
+
+			if entry.height >= 0 {
+				tx.BlockHash = w.chain.best[entry.height]
+				tx.Confirmations = tip - entry.height + 1
+
+				if !w.legacy {
+					height := int32(entry.height)
+					tx.BlockHeight = &height
+				}
+			}
+
+			result.Transactions = append(result.Transactions, tx)
+		}
+
+		return result, nil
+	})
+
+	return node
+}
+
+// newIndexedBus returns a Bus on the wallet, with a ready wallet index.
+func newIndexedBus(t *testing.T, wallet *mockWallet) (*Bus, *fakeNode) {
+	node := wallet.node()
+	b := newTestBus(node)
+
+	if _, err := b.refreshTip(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.syncWalletIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	return b, node
+}
+
+// receiveEvents returns the transaction events published so far.
+func receiveEvents(events <-chan Event) []*TransactionEvent {
+	var ret []*TransactionEvent
+	for {
+		select {
+		case event := <-events:
+			if event.Type == EventTransaction {
+				ret = append(ret, event.Transaction)
+			}
+		default:
+			return ret
+		}
+	}
+}
+
+func TestWalletIndexEvents(t *testing.T) {
+	wallet := &mockWallet{chain: newMockChain(5)}
+	wallet.add(1, "bcrt1qold", 3)
+
+	b, _ := newIndexedBus(t, wallet)
+
+	events, cancel := b.Subscribe()
+	defer cancel()
+
+	wallet.add(2, "bcrt1qfirst", -1)
+	wallet.add(2, "bcrt1qsecond", -1)
+
+	if err := b.syncWalletIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := receiveEvents(events)
+	if len(got) != 1 || got[0].Confirmations != 0 || len(got[0].Addresses) != 2 {
+		t.Fatalf("got events %+v, want the mempool transaction with both addresses", got)
+	}
+
+	if err := b.syncWalletIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := receiveEvents(events); len(got) != 0 {
+		t.Errorf("got events %+v for a transaction still in the mempool, want none", got)
+	}
+
+	wallet.chain.fork(5, 1, 'a')
+	wallet.confirm(2, 6)
+
+	if err := b.syncWalletIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	got = receiveEvents(events)
+	if len(got) != 1 || got[0].Confirmations != 1 || got[0].BlockHash != mockBlockHash('a', 6) {
+		t.Fatalf("got events %+v, want the first confirmation in block 6", got)
+	}
+
+	if err := b.syncWalletIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := receiveEvents(events); len(got) != 0 {
+		t.Errorf("got events %+v after the first confirmation, want none", got)
+	}
+}
+
+func TestWalletIndexRebuildEvents(t *testing.T) {
+	wallet := &mockWallet{chain: newMockChain(5)}
+	b, _ := newIndexedBus(t, wallet)
+
+	events, cancel := b.Subscribe()
+	defer cancel()
+
+	wallet.add(1, "bcrt1qconfirmed", 2)
+	wallet.add(2, "bcrt1qunconfirmed", -1)
+
+	// A rebuild does not report the whole wallet.
+	b.walletIndex.invalidate()
+	if err := b.syncWalletIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := receiveEvents(events); len(got) != 0 {
+		t.Errorf("got events %+v on a rebuild, want none", got)
+	}
+}
+
+func TestIndexedTransactionsCursor(t *testing.T) {
+	wallet := &mockWallet{chain: newMockChain(10)}
+	wallet.add(1, "bcrt1qfirst", 2)
+	wallet.add(2, "bcrt1qsecond", 4)
+
+	b, node := newIndexedBus(t, wallet)
+	ctx := context.Background()
+
+	// Blocks of the indexed transactions are resolved by the index.
+	cursor := mockBlockHash('a', 2)
+	for i := 0; i < 2; i++ {
+		txs, ok := b.indexedTransactions(ctx, nil, &cursor)
+		if !ok || len(txs) != 1 || txs[0].Address != "bcrt1qsecond" {
+			t.Fatalf("got %+v, %v, want the transaction of block 4", txs, ok)
+		}
+	}
+
+	if got := node.count("getblockheader"); got != 0 {
+		t.Errorf("got %d getblockheader calls for a block of the index, want none", got)
+	}
+
+	// Other deep blocks are looked up once.
+	cursor = mockBlockHash('a', 3)
+	for i := 0; i < 2; i++ {
+		if txs, ok := b.indexedTransactions(ctx, nil, &cursor); !ok || len(txs) != 1 {
+			t.Fatalf("got %+v, %v, want the transaction of block 4", txs, ok)
+		}
+	}
+
+	if got := node.count("getblockheader"); got != 1 {
+		t.Errorf("got %d getblockheader calls for a deep block, want 1", got)
+	}
+
+	// Blocks that are not in the best chain are left to the node.
+	wallet.chain.fork(2, 1, 'b')
+	cursor = mockBlockHash('b', 3)
+	wallet.chain.fork(2, 8, 'a')
+
+	if _, ok := b.indexedTransactions(ctx, nil, &cursor); ok {
+		t.Error("got transactions from the index after a stale block")
+	}
+}
+
+func TestWalletIndexLegacyHeights(t *testing.T) {
+	wallet := &mockWallet{chain: newMockChain(5), legacy: true}
+	wallet.add(1, "bcrt1qfirst", 3)
+
+	b, _ := newIndexedBus(t, wallet)
+
+	txs, ok := b.indexedTransactions(context.Background(), nil, nil)
+	if !ok || len(txs) != 1 || txs[0].BlockHeight == nil || *txs[0].BlockHeight != 3 {
+		t.Fatalf("got %+v, %v, want the block height looked up from the block hash", txs, ok)
+	}
+
+	// The confirmations are recomputed from the best height.
+	wallet.chain.fork(5, 2, 'a')
+	if _, err := b.refreshTip(); err != nil {
+		t.Fatal(err)
+	}
+
+	txs, ok = b.indexedTransactions(context.Background(), nil, nil)
+	if !ok || len(txs) != 1 || txs[0].Confirmations != 5 {
+		t.Fatalf("got %+v, %v, want 5 confirmations", txs, ok)
+	}
+
+	// The block of the transaction is a valid cursor.
+	cursor := mockBlockHash('a', 3)
+	if txs, ok := b.indexedTransactions(context.Background(), nil, &cursor); !ok || len(txs) != 0 {
+		t.Errorf("got %+v, %v, want no transactions after block 3", txs, ok)
+	}
+}
+
+func TestListTransactionsTipAdvances(t *testing.T) {
+	wallet := &mockWallet{chain: newMockChain(5)}
+	wallet.add(1, "bcrt1qfirst", 3)
+
+	b, node := newIndexedBus(t, wallet)
+	ctx := context.Background()
+
+	confirmations := func() int64 {
+		txs, err := b.ListAddressTransactions(ctx, []string{"bcrt1qfirst"}, nil)
+		if err != nil || len(txs) != 1 {
+			t.Fatalf("got %+v, %v, want the transaction of block 3", txs, err)
+		}
+
+		return txs[0].Confirmations
+	}
+
+	if got := confirmations(); got != 3 {
+		t.Errorf("got %d confirmations, want 3", got)
+	}
+
+	// The tip advances between the requests.
+	wallet.chain.fork(5, 2, 'a')
+	b.InvalidateTip()
+
+	if got := confirmations(); got != 5 {
+		t.Errorf("got %d confirmations after 2 blocks, want 5", got)
+	}
+
+	if got := node.count("listsinceblock"); got != 1 {
+		t.Errorf("got %d listsinceblock calls, want the one of the index only", got)
+	}
+
+	// A block indexed before the cached tip is refreshed has 1 confirmation.
+	wallet.chain.fork(7, 1, 'a')
+	wallet.add(2, "bcrt1qsecond", 8)
+
+	if err := b.syncWalletIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	txs, err := b.ListAddressTransactions(ctx, []string{"bcrt1qsecond"}, nil)
+	if err != nil || len(txs) != 1 || txs[0].Confirmations != 1 {
+		t.Fatalf("got %+v, %v, want 1 confirmation ahead of the cached tip", txs, err)
+	}
+}
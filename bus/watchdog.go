@@ -0,0 +1,216 @@
+package bus
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ledgerhq/satstack/config"
+
+	"github.com/btcsuite/btcd/btcjson"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultScanStallTimeout is the duration without any advance of the
+	// rescan progress after which the rescan is considered stuck, unless
+	// overridden in the config.
+	defaultScanStallTimeout = 15 * time.Minute
+
+	// scanWatchdogInterval is the maximum interval at which the worker
+	// checks whether the rescan is stuck.
+	scanWatchdogInterval = 1 * time.Minute
+)
+
+// scanWatchdog records when the progress of the wallet rescan last
+// advanced, to detect rescans that are stuck, for ex because bitcoind was
+// killed in the middle of an import. The zero value is ready to use.
+type scanWatchdog struct {
+	progress   float64   // last progress, in percent
+	advancedAt time.Time // zero while no rescan is being watched
+	recoveries int64
+	mutex      sync.Mutex
+}
+
+// observe records the progress, in percent, of the rescan at the given time.
+func (w *scanWatchdog) observe(progress float64, now time.Time) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.advancedAt.IsZero() || progress != w.progress {
+		w.progress = progress
+		w.advancedAt = now
+	}
+}
+
+// stalled returns the progress at which the rescan being watched is stuck,
+// and true if it has not advanced for the given duration.
+func (w *scanWatchdog) stalled(timeout time.Duration, now time.Time) (float64, bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.advancedAt.IsZero() || now.Sub(w.advancedAt) < timeout {
+		return 0, false
+	}
+
+	return w.progress, true
+}
+
+// rearm restarts the stall timeout of the rescan being watched, so that a
+// rescan that stays stuck is only reported once per timeout.
+func (w *scanWatchdog) rearm(now time.Time) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.advancedAt = now
+}
+
+// reset stops watching the rescan, once it has completed.
+func (w *scanWatchdog) reset() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.progress = 0
+	w.advancedAt = time.Time{}
+}
+
+// recovered counts a recovery from a stuck rescan.
+func (w *scanWatchdog) recovered() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.recoveries++
+}
+
+// count returns the number of recoveries from stuck rescans.
+func (w *scanWatchdog) count() int64 {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.recoveries
+}
+
+// watchScan detects wallet rescans whose progress has not advanced for the
+// configured stall timeout, and recovers from them.
+//
+// If bitcoind no longer reports an active rescan, the import of the accounts
+// is reconciled: the accounts whose import was interrupted are imported
+// again, which triggers a new rescan, and the others are checked for
+// completeness. If bitcoind cannot be queried, the Status reports
+// NodeDisconnected until bitcoind answers again.
+func watchScan(ctx context.Context, b *Bus) {
+	interval := scanWatchdogInterval
+	if b.scanStallTimeout < interval {
+		interval = b.scanStallTimeout
+	}
+
+	for sleep(ctx, interval) {
+		progress, ok := b.scanWatchdog.stalled(b.scanStallTimeout, time.Now())
+		if !ok {
+			continue
+		}
+
+		b.checkStalledScan(progress)
+	}
+}
+
+// checkStalledScan queries the wallet about the rescan that is stuck at the
+// given progress, and recovers from it.
+func (b *Bus) checkStalledScan(progress float64) {
+	fields := log.Fields{
+		"prefix":   "watchdog",
+		"progress": fmt.Sprintf("%.2f%%", progress),
+		"timeout":  b.scanStallTimeout,
+	}
+
+	walletInfo, err := b.client.GetWalletInfo(context.Background())
+	if err != nil {
+		b.recoverDisconnected(fields, err)
+		return
+	}
+
+	if v, ok := walletInfo.Scanning.Value.(btcjson.ScanProgress); ok {
+		if current := v.Progress * 100; current != progress {
+			b.scanWatchdog.observe(current, time.Now())
+			return
+		}
+
+		// bitcoind is still scanning, only slowly.
+		log.WithFields(fields).Warn("Rescan progress stalled")
+		b.scanWatchdog.rearm(time.Now())
+		return
+	}
+
+	log.WithFields(fields).Warn("Rescan no longer active, reconciling import")
+
+	b.scanWatchdog.reset()
+	b.scanWatchdog.recovered()
+
+	if err := b.reconcileImports(); err != nil {
+		log.WithFields(log.Fields{
+			"prefix": "watchdog",
+			"error":  err,
+		}).Error("Failed to reconcile import")
+	}
+
+	b.publishStatus(b.QueryStatus())
+}
+
+// recoverDisconnected reports the Status as NodeDisconnected, after the
+// wallet could not be queried about a stuck rescan. The Status is refreshed
+// by the worker once bitcoind answers again.
+func (b *Bus) recoverDisconnected(fields log.Fields, err error) {
+	fields["error"] = err
+	log.WithFields(fields).Error("Rescan stalled and bitcoind unreachable")
+
+	b.scanWatchdog.rearm(time.Now())
+	b.scanWatchdog.recovered()
+
+	b.cacheStatus(NodeDisconnected, "")
+}
+
+// reconcileImports imports again the accounts whose import was interrupted,
+// or checks the import of all accounts if none was.
+func (b *Bus) reconcileImports() error {
+	accounts := b.configuredAccounts()
+
+	var interrupted []config.Account
+	for _, account := range accounts {
+		if b.scanInterrupted(b.client, account) {
+			interrupted = append(interrupted, account)
+		}
+	}
+
+	if len(interrupted) == 0 {
+		return b.importAccounts(accounts, false)
+	}
+
+	log.WithFields(log.Fields{
+		"prefix":   "watchdog",
+		"accounts": len(interrupted),
+	}).Info("Importing interrupted accounts again")
+
+	return b.importAccounts(interrupted, true)
+}
+
+// scanInterrupted returns true if the import of the account started, but
+// neither completed nor failed.
+func (b *Bus) scanInterrupted(client *rpcClient, account config.Account) bool {
+	// Scans are tracked by the canonical external descriptor.
+	descs, _, err := splitAccountDescriptors(account)
+	if err != nil {
+		return false
+	}
+
+	desc, err := GetCanonicalDescriptor(client, descs[0])
+	if err != nil {
+		return false
+	}
+
+	b.scanMutex.RLock()
+	defer b.scanMutex.RUnlock()
+
+	scan, ok := b.scans[*desc]
+	return ok && !scan.Completed && scan.Error == ""
+}
@@ -1,28 +1,57 @@
 package bus
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
-	"github.com/btcsuite/btcd/rpcclient"
-
 	"github.com/btcsuite/btcd/btcjson"
 	"github.com/btcsuite/btcutil"
 	"github.com/ledgerhq/satstack/config"
+	"github.com/ledgerhq/satstack/metrics"
+	"github.com/ledgerhq/satstack/redact"
 	"github.com/ledgerhq/satstack/utils"
 	log "github.com/sirupsen/logrus"
 )
 
-func waitForIBD(b *Bus) error {
+const (
+	// zmqHealthCheckInterval is the interval at which the worker cross-checks
+	// the chain tip received over ZMQ against the one reported by bitcoind.
+	zmqHealthCheckInterval = 1 * time.Minute
+
+	// importConcurrency is the number of accounts whose descriptors are
+	// checked against the wallet at the same time, before being imported.
+	importConcurrency = 4
+)
+
+func waitForIBD(ctx context.Context, b *Bus) error {
 	for {
-		info, err := b.mainClient.GetBlockChainInfo()
+		info, err := b.client.GetBlockChainInfo(ctx)
+		if err != nil && isConnectionError(err) {
+			log.WithFields(log.Fields{
+				"prefix": "worker",
+				"error":  err,
+			}).Warn("Failed to reach bitcoind, retrying")
+
+			if !sleep(ctx, 7*time.Second) {
+				return ctx.Err()
+			}
+
+			continue
+		}
+
 		if err != nil {
 			return err
 		}
 
+		metrics.SyncProgress.WithLabelValues(b.Name).Set(info.VerificationProgress * 100)
+
 		if info.Blocks != info.Headers {
 			log.WithFields(log.Fields{
 				"prefix":   "worker",
@@ -39,20 +68,25 @@ func waitForIBD(b *Bus) error {
 			break
 		}
 
-		time.Sleep(7 * time.Second)
+		if !sleep(ctx, 7*time.Second) {
+			return ctx.Err()
+		}
 	}
 
 	return nil
 }
 
 func getImportProgress(b *Bus) error {
-	walletInfo, err := b.secondaryClient.GetWalletInfo()
+	walletInfo, err := b.client.GetWalletInfo(context.Background())
 	if err != nil {
 		return err
 	}
 
 	switch v := walletInfo.Scanning.Value.(type) {
 	case btcjson.ScanProgress:
+		metrics.ScanProgress.WithLabelValues(b.Name).Set(v.Progress * 100)
+		b.updatePendingScans(v.Progress)
+		b.scanWatchdog.observe(v.Progress*100, time.Now())
 		log.WithFields(log.Fields{
 			"prefix":   "worker",
 			"progress": fmt.Sprintf("%.2f%%", v.Progress*100),
@@ -70,59 +104,287 @@ func getImportProgress(b *Bus) error {
 // ImportAccounts will import the descriptors corresponding to the accounts
 // into the Bitcoin Core wallet. This is a blocking operation.
 func (b *Bus) ImportAccounts(accounts []config.Account) error {
+	return b.importAccounts(accounts, false)
+}
+
+// importAccounts is the implementation of ImportAccounts.
+//
+// Descriptors recorded in the persisted State as already imported are
+// skipped without querying the wallet. If force is true, every descriptor is
+// imported again regardless, which triggers a full rescan from the account
+// birthdays.
+//
+// The descriptors of all accounts are imported at once, so that bitcoind
+// rescans the blockchain only once, from the earliest birthday. An account
+// whose descriptors cannot be imported is reported as failed in the scan
+// status, and does not prevent the import of the others; ErrImportFailed is
+// returned in that case.
+func (b *Bus) importAccounts(accounts []config.Account, force bool) error {
+	atomic.AddInt32(&b.importing, 1)
+	defer atomic.AddInt32(&b.importing, -1)
+
+	// Imported descriptors may have transactions in past blocks.
+	defer b.walletIndex.invalidate()
+
 	// Skip import of descriptors, if no account config found. SatStack
 	// will run in zero-configuration mode.
 	if accounts == nil {
 		return nil
 	}
 
-	client, err := b.ClientFactory()
+	client := b.client
+
+	plans := b.planImports(client, accounts, force)
+
+	// The derivations are indexed once the import is complete, whatever
+	// its outcome, for the accounts whose descriptors are valid.
+	var planned []config.Account
+	for idx, plan := range plans {
+		if plan.err == nil {
+			planned = append(planned, accounts[idx])
+		}
+	}
+
+	defer b.indexDerivations(client, planned)
+
+	var descriptorsToImport []descriptor
+	var failed int
+
+	for _, plan := range plans {
+		switch {
+		case plan.err != nil:
+			log.WithFields(log.Fields{
+				"prefix":     "worker",
+				"descriptor": redact.Descriptor(plan.key),
+				"error":      plan.err,
+			}).Error("Failed to prepare import of account")
+
+			b.failAccountScan(plan.key, plan.err)
+			failed++
+		case len(plan.pending) > 0:
+			b.setAccountScan(plan.key, 0, false)
+			descriptorsToImport = append(descriptorsToImport, plan.pending...)
+		default:
+			// Accounts imported during a previous run are reported as
+			// completed.
+			b.setAccountScan(plan.key, 1, true)
+		}
+
+		if plan.err == nil {
+			b.setAccountScanStart(plan.key, plan.scanStart, plan.scanStart > plan.birthday)
+		}
+	}
+
+	if len(descriptorsToImport) == 0 {
+		log.WithField(
+			"prefix", "worker",
+		).Info("No (new) descriptors to import")
+		return importResult(failed, len(accounts))
+	}
+
+	failures, err := ImportDescriptors(client, descriptorsToImport, b.DescriptorWallet)
 	if err != nil {
+		for _, plan := range plans {
+			if plan.err == nil && len(plan.pending) > 0 {
+				b.failAccountScan(plan.key, err)
+			}
+		}
+
 		return err
 	}
 
-	defer client.Shutdown()
+	var imported []descriptor
 
-	var allDescriptors []descriptor
-	for _, account := range accounts {
-		accountDescriptors, err := descriptors(client, account)
-		if err != nil {
-			return err // return bare error, since it already has a ctx
+	offset := 0
+	for _, plan := range plans {
+		if plan.err != nil || len(plan.pending) == 0 {
+			continue
+		}
+
+		accountFailures := failures[offset : offset+len(plan.pending)]
+		for idx, desc := range plan.pending {
+			if accountFailures[idx] == nil {
+				imported = append(imported, desc)
+			}
+		}
+
+		offset += len(plan.pending)
+
+		if _, err := importFailures(accountFailures); err != nil {
+			b.failAccountScan(plan.key, err)
+			failed++
+			continue
 		}
 
-		allDescriptors = append(allDescriptors, accountDescriptors...)
+		b.setAccountScan(plan.key, 1, true)
 	}
 
-	var descriptorsToImport []descriptor
-	for _, descriptor := range allDescriptors {
-		address, err := DeriveAddress(client, descriptor.Value, descriptor.Depth)
+	if b.state != nil && len(imported) > 0 {
+		if err := b.state.addDescriptors(imported); err != nil {
+			log.WithFields(log.Fields{
+				"prefix": "worker",
+				"path":   b.state.path,
+				"error":  err,
+			}).Warn("Failed to persist import state")
+		}
+	}
+
+	return importResult(failed, len(accounts))
+}
+
+// importResult returns ErrImportFailed if some accounts failed to import.
+func importResult(failed int, total int) error {
+	if failed == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %d/%d accounts", ErrImportFailed, failed, total)
+}
+
+// accountImport describes the descriptors of an account that remain to be
+// imported.
+type accountImport struct {
+	key     string       // key of the account in the scan status
+	pending []descriptor // descriptors not imported yet
+	err     error
+
+	// Configured birthday of the account, and effective timestamp from
+	// which its descriptors are rescanned; see scanStart.
+	birthday  uint32
+	scanStart uint32
+}
+
+// planImports finds the descriptors of each account that remain to be
+// imported. The accounts are checked concurrently, since each descriptor
+// requires RPC calls to find whether it is already in the wallet.
+//
+// The returned slice has the same order as accounts.
+func (b *Bus) planImports(client *rpcClient, accounts []config.Account, force bool) []accountImport {
+	ret := make([]accountImport, len(accounts))
+
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for i := 0; i < importConcurrency; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			// Each worker writes to distinct entries of ret.
+			for idx := range indexes {
+				ret[idx] = b.planImport(client, accounts[idx], force)
+			}
+		}()
+	}
+
+	for idx := range accounts {
+		indexes <- idx
+	}
+
+	close(indexes)
+	wg.Wait()
+
+	return ret
+}
+
+// planImport finds the descriptors of the account that remain to be
+// imported.
+func (b *Bus) planImport(client *rpcClient, account config.Account, force bool) accountImport {
+	accountDescriptors, err := b.descriptors(client, account)
+	if err != nil {
+		// Without canonical descriptors, the account is reported as
+		// configured.
+		return accountImport{key: account.ID(), err: err}
+	}
+
+	ret := accountImport{key: accountDescriptors[0].Value}
+
+	if account.Multipath() {
+		log.WithFields(log.Fields{
+			"prefix":     "worker",
+			"descriptor": redact.Descriptor(*account.External),
+			"external":   redact.Descriptor(accountDescriptors[0].Value),
+			"internal":   redact.Descriptor(accountDescriptors[1].Value),
+		}).Info("Expanded multipath descriptor")
+	}
+
+	for _, descriptor := range accountDescriptors {
+		if force {
+			ret.pending = append(ret.pending, descriptor)
+			continue
+		}
+
+		if b.state != nil && b.state.covers(descriptor) {
+			continue
+		}
+
+		address, err := descriptorAddress(client, descriptor, descriptor.Depth)
 		if err != nil {
-			return fmt.Errorf("%s (%s - #%d): %w",
+			ret.err = fmt.Errorf("%s (%s - #%d): %w",
 				ErrDeriveAddress, descriptor.Value, descriptor.Depth, err)
+			return ret
 		}
 
-		addressInfo, err := client.GetAddressInfo(*address)
+		addressInfo, err := client.GetAddressInfo(context.Background(), *address)
 		if err != nil {
-			return fmt.Errorf("%s (%s): %w", ErrAddressInfo, *address, err)
+			ret.err = fmt.Errorf("%s (%s): %w", ErrAddressInfo, redact.Address(*address), err)
+			return ret
 		}
 
-		if !addressInfo.IsWatchOnly {
-			descriptorsToImport = append(descriptorsToImport, descriptor)
+		// Addresses of descriptor wallets are never reported as
+		// watch-only, but as mine, since private keys are disabled.
+		if !addressInfo.IsWatchOnly && !addressInfo.IsMine {
+			ret.pending = append(ret.pending, descriptor)
 		}
 	}
 
-	if len(descriptorsToImport) == 0 {
-		log.WithField(
-			"prefix", "worker",
-		).Info("No (new) descriptors to import")
-		return nil
+	pending := make(map[string]bool, len(ret.pending))
+	for _, descriptor := range ret.pending {
+		pending[descriptor.Value] = true
 	}
 
-	return ImportDescriptors(client, descriptorsToImport)
+	for _, descriptor := range accountDescriptors {
+		start := descriptor.Age
+		if !pending[descriptor.Value] {
+			start = b.scanStart(descriptor)
+		}
+
+		ret.birthday = descriptor.Birthday
+		if start > ret.scanStart {
+			ret.scanStart = start
+		}
+	}
+
+	for _, descriptor := range ret.pending {
+		if !descriptor.partial() {
+			continue
+		}
+
+		if !b.allowPartialHistory {
+			ret.err = fmt.Errorf(
+				"%w: birthday %s, blocks below height %d are pruned; set a later "+
+					"birthday, or allow_partial_history to scan from %s",
+				ErrPartialHistory, formatTimestamp(descriptor.Birthday), b.Capabilities().PruneHeight,
+				formatTimestamp(descriptor.Age))
+			return ret
+		}
+
+		log.WithFields(log.Fields{
+			"prefix":     "worker",
+			"descriptor": redact.Descriptor(descriptor.Value),
+			"birthday":   formatTimestamp(descriptor.Birthday),
+			"scan_start": formatTimestamp(descriptor.Age),
+		}).Warn("Account birthday predates pruned blocks, its history may be incomplete")
+	}
+
+	return ret
 }
 
 // descriptors returns canonical descriptors from the account configuration.
-func descriptors(client *rpcclient.Client, account config.Account) ([]descriptor, error) {
+// The first one is the external descriptor, followed by the internal one,
+// unless the account watches a single address.
+func (b *Bus) descriptors(client *rpcClient, account config.Account) ([]descriptor, error) {
 	var ret []descriptor
 
 	var depth int
@@ -141,27 +403,148 @@ func descriptors(client *rpcclient.Client, account config.Account) ([]descriptor
 		age = uint32(account.Birthday.Unix())
 	}
 
-	rawDescs := []string{
-		strings.Split(*account.External, "#")[0], // strip out the checksum
-		strings.Split(*account.Internal, "#")[0], // strip out the checksum
+	// The blocks pruned by bitcoind cannot be rescanned, and importing a
+	// descriptor older than them fails.
+	historyStart, err := b.historyStart(client)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", ErrFailedToGetBlock, err)
+	}
+
+	birthday := age
+	if age < historyStart {
+		age = historyStart
+	}
+
+	rawDescs, multipath, err := splitAccountDescriptors(account)
+	if err != nil {
+		return nil, err
+	}
+
+	// Nodes that support multipath descriptors import both paths at once,
+	// with a checksum computed for the multipath descriptor itself.
+	var canonicalMultipath string
+	if multipath != "" && b.DescriptorWallet && b.Capabilities().Version >= minMultipathVersion {
+		desc, err := GetCanonicalDescriptor(client, multipath)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", ErrInvalidDescriptor, err)
+		}
+
+		canonicalMultipath = *desc
 	}
 
 	for _, desc := range rawDescs {
+		if err := b.checkDescriptorSupport(desc); err != nil {
+			return nil, err
+		}
+
 		canonicalDesc, err := GetCanonicalDescriptor(client, desc)
 		if err != nil {
 			return nil, fmt.Errorf("%s: %w", ErrInvalidDescriptor, err)
 		}
 
+		if err := checkMultisigDescriptor(client, *canonicalDesc); err != nil {
+			return nil, err
+		}
+
+		// The range may have been extended during a previous run, and
+		// bitcoind rejects imports with a smaller range.
+		descDepth := depth
+		if b.state != nil && b.state.importedDepth(*canonicalDesc) > depth {
+			descDepth = b.state.importedDepth(*canonicalDesc)
+		}
+
+		if account.Address != nil {
+			ret = append(ret, descriptor{
+				Value:    *canonicalDesc,
+				Age:      age,
+				Birthday: birthday,
+				Address:  *account.Address,
+				Label:    account.Name(),
+			})
+			continue
+		}
+
+		// Descriptors without wildcard, like a multisig at a fixed
+		// derivation path, have a single address, and no range.
+		if !config.RangedDescriptor(*canonicalDesc) {
+			address, err := singleAddress(client, *canonicalDesc)
+			if err != nil {
+				return nil, fmt.Errorf("%s (%s): %w",
+					ErrDeriveAddress, redact.Descriptor(*canonicalDesc), err)
+			}
+
+			ret = append(ret, descriptor{
+				Value:    *canonicalDesc,
+				Age:      age,
+				Birthday: birthday,
+				Address:  address,
+				Label:    account.Name(),
+			})
+			continue
+		}
+
 		ret = append(ret, descriptor{
-			Value: *canonicalDesc,
-			Depth: depth,
-			Age:   age,
+			Value:     *canonicalDesc,
+			Depth:     descDepth,
+			Age:       age,
+			Birthday:  birthday,
+			Multipath: canonicalMultipath,
+			Label:     account.Name(),
 		})
 	}
 
 	return ret, nil
 }
 
+// checkDescriptorSupport verifies that the connected node is able to import
+// the descriptor.
+//
+// Taproot tr() descriptors require bitcoind v22.0+, along with a native
+// descriptor wallet, since importmulti does not support them.
+func (b *Bus) checkDescriptorSupport(desc string) error {
+	if !strings.HasPrefix(desc, "tr(") {
+		return nil
+	}
+
+	if version := b.Capabilities().Version; version < minTaprootVersion {
+		return fmt.Errorf("%s (%s): taproot requires bitcoind v22.0+, found %d",
+			ErrUnsupportedDescriptor, redact.Descriptor(desc), version)
+	}
+
+	if !b.DescriptorWallet {
+		return fmt.Errorf("%s (%s): taproot requires a descriptor wallet",
+			ErrUnsupportedDescriptor, redact.Descriptor(desc))
+	}
+
+	return nil
+}
+
+// checkMultisigDescriptor verifies that bitcoind parses a multi() or
+// sortedmulti() descriptor as written, without reordering or normalizing its
+// keys, and agrees on whether it is ranged. Otherwise, the descriptor would
+// not be found in the wallet once imported. Other descriptors are not checked.
+func checkMultisigDescriptor(client *rpcClient, desc string) error {
+	if !strings.Contains(desc, "multi(") {
+		return nil
+	}
+
+	info, err := client.GetDescriptorInfo(context.Background(), desc)
+	if err != nil {
+		return fmt.Errorf("%s (%s): %w", ErrInvalidDescriptor, redact.Descriptor(desc), err)
+	}
+
+	switch {
+	case descriptorKey(info.Descriptor) != descriptorKey(desc):
+		return fmt.Errorf("%s (%s): bitcoind normalizes it to %s",
+			ErrInvalidDescriptor, redact.Descriptor(desc), redact.Descriptor(info.Descriptor))
+	case info.IsRange != config.RangedDescriptor(desc):
+		return fmt.Errorf("%s (%s): range mismatch, bitcoind reports isrange=%t",
+			ErrInvalidDescriptor, redact.Descriptor(desc), info.IsRange)
+	}
+
+	return nil
+}
+
 // runTheNumbers performs inflation checks against the connected full node.
 //
 // It does NOT perform any equality comparison between expected and actual
@@ -169,45 +552,135 @@ func descriptors(client *rpcclient.Client, account config.Account) ([]descriptor
 func runTheNumbers(b *Bus) error {
 	log.WithField("prefix", "worker").Info("Computing circulating supply...")
 
-	info, err := b.mainClient.GetTxOutSetInfo()
+	info, err := b.client.GetTxOutSetInfo(context.Background())
 	if err != nil {
 		return err
 	}
 
 	const halvingBlocks = 210000
 
+	// The subsidy is halved in satoshis, rounding down, as by consensus.
 	var (
-		subsidy float64 = 50
-		supply  float64 = 0
+		subsidy = btcutil.Amount(50 * btcutil.SatoshiPerBitcoin)
+		supply  = btcutil.Amount(0)
 	)
 
 	i := int64(0)
-	for ; i < info.Height/halvingBlocks; i++ {
+	for ; i < info.Height/halvingBlocks && subsidy > 0; i++ {
 		supply += halvingBlocks * subsidy
 		subsidy /= 2
 	}
 
-	supply += subsidy * float64(info.Height-(halvingBlocks*i))
-
-	supplyBTC, err := btcutil.NewAmount(supply)
-	if err != nil {
-		return err
-	}
+	supply += subsidy * btcutil.Amount(info.Height-(halvingBlocks*i))
 
 	log.WithFields(log.Fields{
 		"prefix":         "worker",
 		"height":         info.Height,
-		"expectedSupply": supplyBTC,
+		"expectedSupply": supply,
 		"actualSupply":   info.TotalAmount,
 	}).Info("#RunTheNumbers successful")
 
 	return nil
 }
 
-func (b *Bus) Worker(config *config.Configuration) {
+// pollTip keeps the cached chain tip fresh by polling bitcoind, at the
+// interval returned by tipPollInterval; see waitTipPoll. While ZMQ
+// notifications are active, polling is limited to a periodic health check
+// that detects missed notifications, and forces the subscriber to reconnect.
+//
+// The changes of the chain tip, however refreshed, are processed by the
+// poller; see processTip.
+func pollTip(ctx context.Context, b *Bus) {
+	var lastCheck time.Time
+
+	for b.waitTipPoll(ctx) {
+		zmqActive := b.ZMQActive()
+		if zmqActive && time.Since(lastCheck) < zmqHealthCheckInterval {
+			continue
+		}
+
+		lastCheck = time.Now()
+
+		cached := b.cachedTip()
+		tip, err := b.refreshTip()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"prefix": "worker",
+				"error":  err,
+			}).Warn("Failed to poll chain tip")
+			continue
+		}
+
+		b.processTip()
+
+		if zmqActive && cached != nil && !cached.Hash.IsEqual(tip.Hash) {
+			log.WithFields(log.Fields{
+				"prefix":      "worker",
+				"blockHash":   tip.Hash.String(),
+				"blockHeight": tip.Height,
+			}).Warn("Missed ZMQ block notification")
+
+			b.resetZMQ()
+		}
+	}
+}
+
+// Worker starts the background tasks of the Bus: waiting for the node to
+// sync, importing the configured accounts, and tracking the chain tip.
+//
+// If forceRescan is true, the persisted import state is ignored and all
+// account descriptors are imported again.
+//
+// The background tasks stop when the context is cancelled. An import RPC
+// that is already in flight is not interrupted; see AbortRescan.
+func (b *Bus) Worker(ctx context.Context, config *config.Configuration, forceRescan bool) {
 	importDone := make(chan bool)
 
+	b.setAccounts(config.Accounts)
+
+	// Without accounts, there is nothing to import, and the Status moves on
+	// from Syncing to Ready.
+	noAccounts := len(config.Accounts) == 0
+	if noAccounts {
+		b.setPendingScan(false)
+	}
+
+	if path := config.StatePath(); path != "" {
+		state, err := LoadState(path)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"prefix": "worker",
+				"path":   path,
+				"error":  err,
+			}).Warn("Failed to load import state, ignoring")
+
+			state = &State{path: path}
+		}
+
+		b.state = state
+	}
+
+	if config.ZMQ != nil {
+		b.SubscribeBlocks(ctx, *config.ZMQ)
+	} else {
+		log.WithField(
+			"prefix", "worker",
+		).Info("ZMQ not configured, polling for new blocks")
+	}
+
+	go pollTip(ctx, b)
+	go pollStatus(ctx, b)
+	go indexWallet(ctx, b)
+	go watchDisk(ctx, b)
+	go watchScan(ctx, b)
+	go logRPCStats(ctx, b)
+
 	sendInterruptSignal := func() {
+		// Failures caused by a shutdown in progress are expected.
+		if ctx.Err() != nil {
+			return
+		}
+
 		pid := syscall.Getpid()
 		p, err := os.FindProcess(pid)
 		if err != nil {
@@ -229,7 +702,7 @@ func (b *Bus) Worker(config *config.Configuration) {
 	}
 
 	go func() {
-		if err := waitForIBD(b); err != nil {
+		if err := waitForIBD(ctx, b); err != nil {
 			log.WithFields(log.Fields{
 				"prefix": "worker",
 				"error":  err,
@@ -239,7 +712,22 @@ func (b *Bus) Worker(config *config.Configuration) {
 			return
 		}
 
-		b.IsPendingScan = true
+		if noAccounts {
+			log.WithField(
+				"prefix", "worker",
+			).Info("No accounts configured, wallet features disabled")
+
+			// Accounts may be added at runtime.
+			go checkRanges(ctx, b)
+
+			select {
+			case importDone <- true:
+			case <-ctx.Done():
+			}
+			return
+		}
+
+		b.setPendingScan(true)
 
 		if err := runTheNumbers(b); err != nil {
 			log.WithFields(log.Fields{
@@ -251,9 +739,18 @@ func (b *Bus) Worker(config *config.Configuration) {
 			return
 		}
 
-		b.IsPendingScan = false
+		b.setPendingScan(false)
 
-		if err := b.ImportAccounts(config.Accounts); err != nil {
+		err := b.importAccounts(config.Accounts, forceRescan)
+		switch {
+		case errors.Is(err, ErrImportFailed):
+			// The other accounts are served nevertheless, and the failed
+			// ones are reported in the scan status.
+			log.WithFields(log.Fields{
+				"prefix": "worker",
+				"error":  err,
+			}).Error("Failed to import some accounts")
+		case err != nil:
 			log.WithFields(log.Fields{
 				"prefix": "worker",
 				"error":  err,
@@ -263,7 +760,14 @@ func (b *Bus) Worker(config *config.Configuration) {
 			return
 		}
 
-		importDone <- true
+		b.checkDrift()
+
+		go checkRanges(ctx, b)
+
+		select {
+		case importDone <- true:
+		case <-ctx.Done():
+		}
 	}()
 
 	go func() {
@@ -280,10 +784,21 @@ func (b *Bus) Worker(config *config.Configuration) {
 			case <-importDone:
 				return
 
-			default:
-				time.Sleep(7 * time.Second)
+			case <-ctx.Done():
+				return
 
-				if err := getImportProgress(b); err != nil {
+			case <-time.After(7 * time.Second):
+				err := getImportProgress(b)
+				if err != nil && isConnectionError(err) {
+					// The Status is reported as NodeDisconnected meanwhile.
+					log.WithFields(log.Fields{
+						"prefix": "worker",
+						"error":  err,
+					}).Warn("Failed to reach bitcoind, retrying")
+					continue
+				}
+
+				if err != nil {
 					log.WithFields(log.Fields{
 						"prefix": "worker",
 						"error":  err,
@@ -296,3 +811,14 @@ func (b *Bus) Worker(config *config.Configuration) {
 		}
 	}()
 }
+
+// sleep pauses the current goroutine for the given duration. It returns
+// false if the context was cancelled in the meantime.
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
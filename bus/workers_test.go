@@ -0,0 +1,179 @@
+package bus
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ledgerhq/satstack/config"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
+)
+
+func TestCheckDescriptorSupport(t *testing.T) {
+	const taproot = "tr(tpubD6NzVbkrYhZ4XgiXtGrdW5XDAPFCL9h7we1vwNCpn8tGbBcgfVYjXyhWo4E1xkh56hjod1RhGjxbaTLV3X4FyWuejifB9jusQ46QzG87VKp/0/*)"
+	const segwit = "wpkh(tpubD6NzVbkrYhZ4XgiXtGrdW5XDAPFCL9h7we1vwNCpn8tGbBcgfVYjXyhWo4E1xkh56hjod1RhGjxbaTLV3X4FyWuejifB9jusQ46QzG87VKp/0/*)"
+
+	tests := []struct {
+		name             string
+		desc             string
+		version          int32
+		descriptorWallet bool
+		supported        bool
+	}{
+		{"taproot", taproot, minTaprootVersion, true, true},
+		{"taproot on old node", taproot, minTaprootVersion - 10000, true, false},
+		{"taproot in legacy wallet", taproot, minTaprootVersion, false, false},
+		{"segwit on old node", segwit, minDescriptorWalletVersion - 10000, false, true},
+	}
+
+	for _, test := range tests {
+		b := newTestBus(newFakeNode())
+		b.setCapabilities(&Capabilities{Version: test.version})
+		b.DescriptorWallet = test.descriptorWallet
+
+		err := b.checkDescriptorSupport(test.desc)
+		if test.supported && err != nil {
+			t.Errorf("%s: got error %v", test.name, err)
+		}
+
+		if !test.supported && (err == nil || !strings.HasPrefix(err.Error(), ErrUnsupportedDescriptor.Error())) {
+			t.Errorf("%s: got error %v, want %v", test.name, err, ErrUnsupportedDescriptor)
+		}
+	}
+}
+
+// multisigNode returns a fakeNode with an empty wallet, whose descriptors
+// have a single address, and are parsed as written, unless info is set. The
+// import requests are recorded.
+func multisigNode(requests *[]importDescriptorsRequest, info func(desc string) btcjson.GetDescriptorInfoResult) *fakeNode {
+	node := newFakeNode()
+
+	node.handle("getdescriptorinfo", func(params []json.RawMessage) (interface{}, error) {
+		var desc string
+		param(params, 0, &desc)
+
+		if info != nil {
+			return info(desc), nil
+		}
+
+		return btcjson.GetDescriptorInfoResult{Descriptor: desc, IsRange: config.RangedDescriptor(desc)}, nil
+	})
+
+	node.handle("deriveaddresses", func(params []json.RawMessage) (interface{}, error) {
+		if len(params) != 1 {
+			return nil, btcjson.NewRPCError(btcjson.ErrRPCInvalidParameter, "Range should not be specified for an un-ranged descriptor")
+		}
+
+		return []string{"tb1qmultisig"}, nil
+	})
+
+	node.result("getaddressinfo", map[string]interface{}{"address": "tb1qmultisig"})
+
+	node.handle("importdescriptors", func(params []json.RawMessage) (interface{}, error) {
+		param(params, 0, requests)
+
+		return []importDescriptorsResult{{Success: true}}, nil
+	})
+
+	return node
+}
+
+// testnetKeys returns count extended public keys on testnet, derived from
+// distinct seeds.
+func testnetKeys(t *testing.T, count int) []string {
+	keys := make([]string, count)
+	for idx := range keys {
+		seed := make([]byte, hdkeychain.RecommendedSeedLen)
+		seed[0] = byte(idx + 1)
+
+		master, err := hdkeychain.NewMaster(seed, &chaincfg.TestNet3Params)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		public, err := master.Neuter()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		keys[idx] = public.String()
+	}
+
+	return keys
+}
+
+func TestImportSortedMulti(t *testing.T) {
+	keys := testnetKeys(t, 3)
+	external := fmt.Sprintf("wsh(sortedmulti(2,%s/0/5,%s/0/5,%s/0/5))", keys[0], keys[1], keys[2])
+	label := "vault"
+	account := config.Account{External: &external, Label: &label}
+
+	var requests []importDescriptorsRequest
+	b := newTestBus(multisigNode(&requests, nil))
+	b.setCapabilities(&Capabilities{Version: 250000})
+	b.DescriptorWallet = true
+
+	if err := b.importAccounts([]config.Account{account}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// A single descriptor is imported, without range nor internal
+	// descriptor.
+	if len(requests) != 1 || requests[0].Range != nil || requests[0].Internal || requests[0].Label != label {
+		t.Fatalf("got requests %+v, want the external descriptor without range, labeled", requests)
+	}
+
+	if want, _ := config.DescriptorWithChecksum(external); requests[0].Descriptor != want {
+		t.Errorf("got descriptor %s, want %s", requests[0].Descriptor, want)
+	}
+
+	// The single address is indexed for the ownership annotations.
+	derivation, ok := b.Derivation("tb1qmultisig")
+	if !ok || derivation.Account != external || derivation.Label != label || derivation.Index != 0 {
+		t.Errorf("got derivation %+v, %v, want the address of the account", derivation, ok)
+	}
+}
+
+func TestCheckMultisigDescriptor(t *testing.T) {
+	keys := testnetKeys(t, 3)
+	desc, err := config.DescriptorWithChecksum(
+		fmt.Sprintf("wsh(sortedmulti(2,%s/0/5,%s/0/5,%s/0/5))", keys[0], keys[1], keys[2]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		desc  string
+		info  func(string) btcjson.GetDescriptorInfoResult
+		valid bool
+	}{
+		{"as written", desc, nil, true},
+		{"normalized keys", desc, func(d string) btcjson.GetDescriptorInfoResult {
+			return btcjson.GetDescriptorInfoResult{Descriptor: strings.Replace(d, keys[0], keys[1], 1)}
+		}, false},
+		{"range mismatch", desc, func(d string) btcjson.GetDescriptorInfoResult {
+			return btcjson.GetDescriptorInfoResult{Descriptor: d, IsRange: true}
+		}, false},
+		{"not a multisig", "wpkh(" + keys[0] + "/0/5)", func(string) btcjson.GetDescriptorInfoResult {
+			panic("getdescriptorinfo called")
+		}, true},
+	}
+
+	for _, test := range tests {
+		var requests []importDescriptorsRequest
+		client := newTestBus(multisigNode(&requests, test.info)).client
+
+		err := checkMultisigDescriptor(client, test.desc)
+		switch {
+		case test.valid && err != nil:
+			t.Errorf("%s: got error %v", test.name, err)
+		case !test.valid && (err == nil || !strings.HasPrefix(err.Error(), ErrInvalidDescriptor.Error())):
+			t.Errorf("%s: got error %v, want %v", test.name, err, ErrInvalidDescriptor)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package bus
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/go-zeromq/zmq4"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// zmqTopicHashBlock is the topic on which bitcoind publishes the hash of
+	// every block connected to the best chain (option zmqpubhashblock).
+	zmqTopicHashBlock = "hashblock"
+
+	// zmqMinBackoff and zmqMaxBackoff bound the delay between attempts to
+	// re-establish the ZMQ subscription, for example while bitcoind is
+	// restarting.
+	zmqMinBackoff = 1 * time.Second
+	zmqMaxBackoff = 1 * time.Minute
+)
+
+// ZMQActive indicates whether the Bus is currently receiving block
+// notifications over ZMQ. When false, the worker falls back to polling.
+func (b *Bus) ZMQActive() bool {
+	return atomic.LoadInt32(&b.zmqActive) == 1
+}
+
+func (b *Bus) setZMQActive(active bool) {
+	var value int32
+	if active {
+		value = 1
+	}
+
+	atomic.StoreInt32(&b.zmqActive, value)
+}
+
+// resetZMQ requests the ZMQ subscriber to tear down its socket and
+// reconnect. It is used when the worker detects that a block notification
+// was missed, which usually means the connection has silently dropped.
+func (b *Bus) resetZMQ() {
+	select {
+	case b.zmqReset <- struct{}{}:
+	default:
+		// A reset is already pending.
+	}
+}
+
+// SubscribeBlocks listens for block hash notifications published by bitcoind
+// on the given ZMQ endpoint (for ex, tcp://127.0.0.1:28332), and refreshes
+// the cached chain tip as soon as a new block is announced.
+//
+// The subscription is re-established with an exponential backoff whenever
+// the connection drops, so that a bitcoind restart does not require
+// restarting SatStack. This method does not block, and the subscription is
+// closed when the context is cancelled.
+func (b *Bus) SubscribeBlocks(ctx context.Context, endpoint string) {
+	go func() {
+		backoff := zmqMinBackoff
+
+		for {
+			start := time.Now()
+			err := b.subscribeBlocks(ctx, endpoint)
+			b.setZMQActive(false)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			// Reset the backoff if the subscription was healthy for a while.
+			if time.Since(start) > zmqMaxBackoff {
+				backoff = zmqMinBackoff
+			}
+
+			log.WithFields(log.Fields{
+				"prefix":   "zmq",
+				"endpoint": endpoint,
+				"error":    err,
+				"retryIn":  backoff,
+			}).Warn("ZMQ subscription lost, falling back to polling")
+
+			if !sleep(ctx, backoff) {
+				return
+			}
+
+			backoff *= 2
+			if backoff > zmqMaxBackoff {
+				backoff = zmqMaxBackoff
+			}
+		}
+	}()
+}
+
+// subscribeBlocks runs a single ZMQ subscription session. It blocks until
+// the session fails or the context is cancelled, and always returns a non-nil
+// error.
+func (b *Bus) subscribeBlocks(parent context.Context, endpoint string) error {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	sub := zmq4.NewSub(ctx)
+	defer sub.Close()
+
+	if err := sub.Dial(endpoint); err != nil {
+		return fmt.Errorf("dial: %w", err)
+	}
+
+	if err := sub.SetOption(zmq4.OptionSubscribe, zmqTopicHashBlock); err != nil {
+		return fmt.Errorf("subscribe: %w", err)
+	}
+
+	b.setZMQActive(true)
+
+	log.WithFields(log.Fields{
+		"prefix":   "zmq",
+		"endpoint": endpoint,
+		"topic":    zmqTopicHashBlock,
+	}).Info("Subscribed to block notifications")
+
+	msgs := make(chan zmq4.Msg)
+	errs := make(chan error, 1)
+
+	go func() {
+		for {
+			msg, err := sub.Recv()
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			select {
+			case msgs <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case msg := <-msgs:
+			// Multipart message: [topic, 32-byte block hash, sequence]
+			if len(msg.Frames) < 2 || string(msg.Frames[0]) != zmqTopicHashBlock {
+				continue
+			}
+
+			// The hash is published in RPC byte order, which is the reverse
+			// of the internal byte order used by chainhash.
+			raw := make([]byte, len(msg.Frames[1]))
+			for i, v := range msg.Frames[1] {
+				raw[len(raw)-1-i] = v
+			}
+
+			hash, err := chainhash.NewHash(raw)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"prefix": "zmq",
+					"error":  err,
+				}).Warn("Received malformed block hash")
+				continue
+			}
+
+			b.InvalidateTip()
+
+			if _, err := b.refreshTip(); err != nil {
+				log.WithFields(log.Fields{
+					"prefix": "zmq",
+					"hash":   hash.String(),
+					"error":  err,
+				}).Error("Failed to refresh chain tip")
+				continue
+			}
+
+			log.WithFields(log.Fields{
+				"prefix": "zmq",
+				"hash":   hash.String(),
+			}).Debug("New block notification")
+
+		case err := <-errs:
+			return fmt.Errorf("recv: %w", err)
+
+		case <-b.zmqReset:
+			return fmt.Errorf("missed block notification")
+
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
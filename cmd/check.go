@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ledgerhq/satstack/bus"
+	"github.com/ledgerhq/satstack/config"
+)
+
+// checkConfig validates the config file, and unless offline is true, checks
+// that bitcoind is able to serve SatStack. All the problems found are
+// printed, and the number of problems is returned.
+//
+// Nothing is imported, and the wallet is neither created nor loaded.
+func checkConfig(offline bool) int {
+	path, err := config.Find()
+	if err != nil {
+		return printProblems([]error{err})
+	}
+
+	fmt.Printf("Checking config file %s\n", path)
+
+	configuration, err := config.ReadFile(path)
+	if err != nil {
+		return printProblems([]error{err})
+	}
+
+	problems := validationProblems(configuration.Validate())
+
+	for idx, chain := range configuration.ChainConfigurations() {
+		// The problems of additional chains are prefixed like the
+		// validation ones.
+		prefix := ""
+		if idx > 0 {
+			prefix = fmt.Sprintf("chains[%d]: ", idx-1)
+			fmt.Printf("Checking chains[%d]\n", idx-1)
+		}
+
+		for _, problem := range checkNode(chain, offline) {
+			problems = append(problems, fmt.Errorf("%s%w", prefix, problem))
+		}
+	}
+
+	return printProblems(problems)
+}
+
+// checkNode checks that the bitcoind node of the chain is able to serve
+// SatStack, unless offline is true, and returns the problems found.
+func checkNode(chain *config.Configuration, offline bool) []error {
+	switch {
+	case offline:
+		fmt.Println("Skipped bitcoind checks (offline)")
+	case chain.RPCURL == nil:
+		fmt.Println("Skipped bitcoind checks (no rpcurl)")
+	case !chain.UseCookie() && (chain.RPCUser == nil || chain.RPCPassword == nil):
+		fmt.Println("Skipped bitcoind checks (incomplete credentials)")
+	default:
+		report, problems := bus.CheckNode(chain)
+
+		if report != nil {
+			printReport(report)
+
+			if report.Params != nil {
+				problems = append(problems,
+					validationProblems(chain.ValidateChain(report.Params))...)
+			}
+		}
+
+		return problems
+	}
+
+	return nil
+}
+
+// validationProblems unwraps the list of problems of a validation error.
+func validationProblems(err error) []error {
+	var problems config.Problems
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &problems):
+		return problems
+	default:
+		return []error{err}
+	}
+}
+
+func printReport(report *bus.NodeReport) {
+	pruned := "no"
+	if report.Pruned {
+		pruned = fmt.Sprintf("yes, above height %d", report.PruneHeight)
+	}
+
+	fmt.Printf("bitcoind %s on chain %s\n", report.Version, report.Chain)
+	fmt.Printf("  pruned:        %s\n", pruned)
+	fmt.Printf("  txindex:       %s\n", yesNo(report.TxIndex))
+	fmt.Printf("  block filters: %s\n", yesNo(report.BlockFilter))
+	fmt.Printf("  wallet '%s': %s\n", report.WalletName, report.Wallet)
+
+	for _, warning := range report.Warnings {
+		fmt.Printf("  warning: %s\n", warning)
+	}
+}
+
+func printProblems(problems []error) int {
+	if len(problems) == 0 {
+		fmt.Println("No problems found")
+		return 0
+	}
+
+	fmt.Printf("%d problem(s) found:\n", len(problems))
+	for _, problem := range problems {
+		fmt.Printf("  - %s\n", problem)
+	}
+
+	return len(problems)
+}
+
+func yesNo(value bool) string {
+	if value {
+		return "yes"
+	}
+
+	return "no"
+}
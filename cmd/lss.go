@@ -2,9 +2,15 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/ledgerhq/satstack/bus"
@@ -12,12 +18,32 @@ import (
 	"github.com/ledgerhq/satstack/fortunes"
 	"github.com/ledgerhq/satstack/httpd"
 	"github.com/ledgerhq/satstack/httpd/svc"
+	"github.com/ledgerhq/satstack/httpd/ws"
+	"github.com/ledgerhq/satstack/redact"
 	"github.com/ledgerhq/satstack/version"
 	log "github.com/sirupsen/logrus"
 	prefixed "github.com/x-cray/logrus-prefixed-formatter"
 )
 
-func startup() *svc.Service {
+var forceRescan = flag.Bool("force-rescan", false,
+	"ignore the persisted import state, and import all accounts again")
+
+var checkConfigFlag = flag.Bool("check-config", false,
+	"validate the config file and check bitcoind, without importing anything, then exit")
+
+var offline = flag.Bool("offline", false,
+	"with -check-config, only validate the config file, without connecting to bitcoind")
+
+var versionFlag = flag.Bool("version", false,
+	"print the version of SatStack, and how it was built, then exit")
+
+var checkDescriptors = flag.Bool("check-descriptors", false,
+	"cross-check the descriptor checksums computed by SatStack with getdescriptorinfo, for debugging")
+
+var shutdownTimeout = flag.Duration("shutdown-timeout", 10*time.Second,
+	"maximum time to wait for in-flight requests and RPC calls on shutdown")
+
+func startup(ctx context.Context) ([]*svc.Service, *config.Configuration) {
 	log.SetFormatter(&prefixed.TextFormatter{
 		TimestampFormat:  "2006/01/02 - 15:04:05",
 		FullTimestamp:    true,
@@ -25,100 +51,316 @@ func startup() *svc.Service {
 		SpacePadding:     45,
 	})
 
+	configuration, err := config.Load()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err.Error(),
+		}).Fatal("Failed to load config")
+		return nil, nil
+	}
+
+	if configuration.LogFormat != nil && *configuration.LogFormat == "json" {
+		log.SetFormatter(&log.JSONFormatter{
+			TimestampFormat: time.RFC3339Nano,
+		})
+	}
+
+	if configuration.LogLevel != nil {
+		// The level was validated along with the config.
+		level, _ := log.ParseLevel(*configuration.LogLevel)
+		log.SetLevel(level)
+	}
+
+	// Privacy mode is only disabled by default at trace level, to debug
+	// the lookups of specific addresses or transactions.
+	privacyMode := !log.IsLevelEnabled(log.TraceLevel)
+	if configuration.PrivacyMode != nil {
+		privacyMode = *configuration.PrivacyMode
+	}
+
+	redact.SetEnabled(privacyMode)
+
 	log.WithFields(log.Fields{
 		"build":   version.Build,
-		"commit":  version.GitCommit,
+		"commit":  version.Commit(),
+		"date":    version.Date(),
 		"runtime": version.GoVersion,
 		"arch":    version.OsArch,
 	}).Infof("Ledger SatStack (lss) %s", version.Version)
 
-	configuration, err := config.Load()
-	if err != nil {
-		log.WithFields(log.Fields{
-			"error": err.Error(),
-		}).Fatal("Failed to load config")
-		return nil
+	// The nodes are connected to concurrently, since each one may take a
+	// while to warm up.
+	chains := configuration.ChainConfigurations()
+	services := make([]*svc.Service, len(chains))
+	errs := make([]error, len(chains))
+
+	var wg sync.WaitGroup
+	for idx, chain := range chains {
+		wg.Add(1)
+		go func(idx int, chain *config.Configuration) {
+			defer wg.Done()
+			services[idx], errs[idx] = newService(ctx, idx, chain)
+		}(idx, chain)
 	}
 
-	b, err := bus.New(
-		*configuration.RPCURL,
-		*configuration.RPCUser,
-		*configuration.RPCPassword,
-		configuration.NoTLS,
-	)
-	if err != nil {
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		log.Fatal("Startup interrupted")
+		return nil, nil
+	}
+
+	// The default chain is required, but the failure of an additional chain
+	// does not prevent serving the others.
+	if errs[0] != nil {
 		log.WithFields(log.Fields{
-			"error": err,
+			"error": errs[0],
 		}).Fatal("Failed to initialize Bus")
-		return nil
+		return nil, nil
+	}
+
+	var ret []*svc.Service
+	names := make(map[string]bool)
+
+	for idx, s := range services {
+		if err := errs[idx]; err == nil && names[s.Bus.Name] {
+			errs[idx] = fmt.Errorf("duplicate chain name '%s', set the name of the chain", s.Bus.Name)
+		}
+
+		if err := errs[idx]; err != nil {
+			log.WithFields(log.Fields{
+				"chain": fmt.Sprintf("chains[%d]", idx-1),
+				"error": err,
+			}).Error("Failed to initialize Bus, skipping chain")
+			continue
+		}
+
+		names[s.Bus.Name] = true
+		ret = append(ret, s)
+	}
+
+	fortunes.Fortune()
+
+	for _, s := range ret {
+		// The name of the chain is only known once connected, and names
+		// the state file of the additional chains.
+		chain := chains[s.ChainIndex]
+		chain.Name = &s.Bus.Name
+
+		s.Bus.Worker(ctx, chain, *forceRescan)
+
+		// The caches are warmed up in the background, once the Bus is Ready.
+		go s.WarmUp(ctx)
+	}
+
+	return ret, configuration
+}
+
+// newService connects to the node of the chain at the given position in the
+// config file, and returns the Service of the chain.
+func newService(ctx context.Context, idx int, chain *config.Configuration) (*svc.Service, error) {
+	b, err := bus.New(ctx, chain)
+	if err != nil {
+		return nil, err
+	}
+
+	// The chain of the extended keys can only be checked once connected.
+	if err := chain.ValidateChain(b.Params); err != nil {
+		return nil, err
 	}
 
 	log.WithFields(log.Fields{
+		"name":        b.Name,
 		"chain":       b.Chain,
-		"pruned":      b.Pruned,
-		"txindex":     b.TxIndex,
-		"blockFilter": b.BlockFilter,
+		"pruned":      b.Capabilities().Pruned,
+		"txindex":     b.Capabilities().TxIndex,
+		"blockFilter": b.Capabilities().BlockFilter,
 	}).Info("RPC connection established")
 
 	s := &svc.Service{
-		Bus: b,
+		Bus:          b,
+		FeeTargets:   config.DefaultFeeTargets,
+		FeeMode:      config.DefaultFeeMode,
+		WarmupBlocks: config.DefaultWarmupBlocks,
+		ConfigPath:   chain.Path,
+		ChainIndex:   idx,
 	}
 
-	fortunes.Fortune()
+	if len(chain.FeeTargets) > 0 {
+		s.FeeTargets = chain.FeeTargets
+	}
 
-	s.Bus.Worker(configuration)
+	if chain.FeeMode != nil {
+		s.FeeMode = strings.ToUpper(*chain.FeeMode)
+	}
+
+	if chain.WarmupBlocks != nil {
+		s.WarmupBlocks = *chain.WarmupBlocks
+	}
 
-	return s
+	return s, nil
 }
 
 func main() {
-	s := startup()
-	engine := httpd.GetRouter(s)
+	flag.Parse()
+
+	if *versionFlag || flag.Arg(0) == "version" {
+		fmt.Printf("Ledger SatStack (lss) %s\n", version.Full())
+		fmt.Printf("%s, %s\n", version.GoVersion, version.OsArch)
+		return
+	}
+
+	if *checkConfigFlag {
+		if checkConfig(*offline) > 0 {
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	bus.VerifyChecksums = *checkDescriptors
+
+	// Register the signal handler early, so that an interrupt during the
+	// startup is not lost.
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+
+	workerCtx, stopWorker := context.WithCancel(context.Background())
+
+	// An interrupt during the startup, for ex while bitcoind warms up,
+	// aborts it. A signal received once started is handed over to the
+	// shutdown below.
+	started := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-quit:
+			stopWorker()
+
+			select {
+			case quit <- sig:
+			default:
+			}
+		case <-started:
+		}
+	}()
+
+	services, configuration := startup(workerCtx)
+	close(started)
+
+	chains := make([]httpd.Chain, len(services))
+	for idx, s := range services {
+		chains[idx] = httpd.Chain{
+			Name:    s.Bus.Name,
+			Service: s,
+			Hub:     ws.NewHub(s.Bus, configuration.AllowedOrigins),
+		}
+	}
+
+	engine := httpd.GetRouter(chains, configuration)
+
+	// The listen addresses have already been validated when loading the
+	// config.
+	addresses, _ := configuration.Listeners()
+	mode, _ := configuration.SocketFileMode()
+
+	listeners, err := httpd.Listen(addresses, mode)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Fatal("Failed to listen")
+	}
 
 	srv := &http.Server{
-		Addr:    ":20000",
 		Handler: engine,
 	}
 
+	for idx, listener := range listeners {
+		log.WithField("address", addresses[idx]).Info("Listening for HTTP requests")
+
+		go func(listener net.Listener) {
+			// service connections
+			if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
+				log.WithFields(log.Fields{
+					"error": err,
+				}).Fatal("Failed to listen and serve")
+			}
+		}(listener)
+	}
+
+	// Reload the accounts from the config file on SIGHUP.
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+
 	go func() {
-		// service connections
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.WithFields(log.Fields{
-				"error": err,
-			}).Fatal("Failed to listen and serve")
+		for range reload {
+			log.Info("Reloading accounts from config file")
+
+			for _, s := range services {
+				if err := s.ReloadAccounts(); err != nil {
+					log.WithFields(log.Fields{
+						"name":  s.Bus.Name,
+						"error": err,
+					}).Error("Failed to reload accounts")
+				}
+			}
 		}
 	}()
 
-	// Wait for interrupt signal to gracefully shutdown the server with
-	// a timeout of 5 seconds.
-	quit := make(chan os.Signal)
-	signal.Notify(quit, os.Interrupt)
-
 	<-quit
 
-	log.Info("Shutdown server: in progress")
+	log.WithField("timeout", *shutdownTimeout).Info("Shutdown server: in progress")
+
+	exitCode := 0
 
 	{
-		// Scoped block to disconnect all connections, and stop all goroutines.
-		// If not successful within 5s, drop a nuclear bomb and fail with a
-		// FATAL error.
+		// Scoped block to stop accepting new connections, and wait for the
+		// in-flight requests to complete.
 
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
 		defer cancel()
 
-		s.Bus.Close(ctx)
+		// WebSocket connections are hijacked, and therefore not closed by
+		// the server. Streams of status updates never become idle, and must
+		// be terminated for the server to drain.
+		for _, chain := range chains {
+			chain.Hub.Close()
+			chain.Service.Bus.CloseStatusSubscribers()
+		}
+
+		if err := srv.Shutdown(ctx); err != nil {
+			log.WithField("error", err).Error("Shutdown server: failed to drain requests")
+			exitCode = 1
+		}
+
+		httpd.RemoveSockets(addresses)
 	}
 
+	// Stop the background tasks of the worker before closing the RPC
+	// connections they rely on.
+	stopWorker()
+
 	{
-		// Scoped block to gracefully shutdown Gin-Gonic server within 10s.
+		// Scoped block to abort the wallet rescan, and disconnect all RPC
+		// connections. If not successful within the timeout, drop a nuclear
+		// bomb and exit with a non-zero code.
 
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
 		defer cancel()
 
-		if err := srv.Shutdown(ctx); err != nil {
-			log.WithField("error", err).Fatal("Failed to shutdown server")
+		for _, s := range services {
+			if err := s.Bus.Close(ctx); err != nil {
+				log.WithFields(log.Fields{
+					"name":  s.Bus.Name,
+					"error": err,
+				}).Error("Shutdown server: force")
+				exitCode = 1
+			}
 		}
+	}
 
-		log.Info("Shutdown server: done")
+	if exitCode != 0 {
+		os.Exit(exitCode)
 	}
+
+	log.Info("Shutdown server: done")
 }
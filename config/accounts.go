@@ -0,0 +1,94 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/ledgerhq/satstack/utils"
+
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+// ValidateAccount checks an account submitted at runtime, like Validate and
+// ValidateChain check the accounts of the config file. The problems are
+// reported as for the first account of a config file, for ex
+// accounts[0].external.
+func ValidateAccount(account Account, params *chaincfg.Params) error {
+	problems := Problems(account.problems(0))
+	if len(problems) > 0 {
+		return fmt.Errorf("%s: %w", ErrValidation, problems)
+	}
+
+	return Configuration{Accounts: []Account{account}}.ValidateChain(params)
+}
+
+// AddAccountToFile appends the account to the accounts of the chain at the
+// given index (see ChainConfigurations) in the config file at the given path.
+//
+// The other fields of the file are preserved as written, although the keys
+// of the objects enclosing the accounts are sorted. The file is replaced
+// atomically, by writing to a temporary file in the same directory and
+// renaming it over the config file.
+func AddAccountToFile(path string, chainIndex int, account Account) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var root map[string]json.RawMessage
+	if err := json.Unmarshal(data, &root); err != nil {
+		return fmt.Errorf("%s: %w", ErrMalformed, err)
+	}
+
+	chain := root
+	var chains []map[string]json.RawMessage
+
+	if chainIndex > 0 {
+		if err := json.Unmarshal(root["chains"], &chains); err != nil {
+			return fmt.Errorf("%s: chains: %w", ErrMalformed, err)
+		}
+
+		if chainIndex > len(chains) {
+			return fmt.Errorf("%s: chain #%d removed from config file", ErrValidation, chainIndex)
+		}
+
+		chain = chains[chainIndex-1]
+	}
+
+	var accounts []json.RawMessage
+	if raw, ok := chain["accounts"]; ok {
+		if err := json.Unmarshal(raw, &accounts); err != nil {
+			return fmt.Errorf("%s: accounts: %w", ErrMalformed, err)
+		}
+	}
+
+	rawAccount, err := json.Marshal(account)
+	if err != nil {
+		return err
+	}
+
+	if chain["accounts"], err = json.Marshal(append(accounts, rawAccount)); err != nil {
+		return err
+	}
+
+	if chainIndex > 0 {
+		if root["chains"], err = json.Marshal(chains); err != nil {
+			return err
+		}
+	}
+
+	data, err = json.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// The mode of the existing file is preserved.
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	return utils.WriteFileAtomic(path, append(data, '\n'), info.Mode())
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
+)
+
+// testnetKeys returns count extended public keys on testnet, derived from
+// distinct seeds.
+func testnetKeys(t *testing.T, count int) []string {
+	keys := make([]string, count)
+	for idx := range keys {
+		seed := make([]byte, hdkeychain.RecommendedSeedLen)
+		seed[0] = byte(idx + 1)
+
+		master, err := hdkeychain.NewMaster(seed, &chaincfg.TestNet3Params)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		public, err := master.Neuter()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		keys[idx] = public.String()
+	}
+
+	return keys
+}
+
+// sortedMulti returns a 2-of-3 sortedmulti descriptor of the keys, at the
+// given paths.
+func sortedMulti(keys []string, path string) string {
+	return fmt.Sprintf("wsh(sortedmulti(2,%s%s,%s%s,%s%s))", keys[0], path, keys[1], path, keys[2], path)
+}
+
+func TestValidateAccountWithoutWildcard(t *testing.T) {
+	keys := testnetKeys(t, 3)
+	external := sortedMulti(keys, "/0/5")
+	internal := sortedMulti(keys, "/1/5")
+	depth := 100
+
+	tests := []struct {
+		name    string
+		account Account
+		problem string // expected in the error, if any
+	}{
+		{"without internal descriptor", Account{External: &external}, ""},
+		{"with internal descriptor", Account{External: &external, Internal: &internal}, ""},
+		{"with depth", Account{External: &external, Depth: &depth}, "depth and auto_extend"},
+		{"with auto_extend", Account{External: &external, AutoExtend: true}, "depth and auto_extend"},
+	}
+
+	for _, test := range tests {
+		err := ValidateAccount(test.account, &chaincfg.TestNet3Params)
+		switch {
+		case test.problem == "" && err != nil:
+			t.Errorf("%s: got error %v", test.name, err)
+		case test.problem != "" && (err == nil || !strings.Contains(err.Error(), test.problem)):
+			t.Errorf("%s: got error %v, want %q", test.name, err, test.problem)
+		}
+	}
+
+	account := Account{External: &external}
+	if account.Ranged() {
+		t.Error("account without wildcard reported as ranged")
+	}
+
+	// Ranged descriptors still require an internal descriptor.
+	ranged := sortedMulti(keys, "/0/*")
+	if err := ValidateAccount(Account{External: &ranged}, &chaincfg.TestNet3Params); err == nil ||
+		!strings.Contains(err.Error(), "internal") {
+		t.Errorf("got error %v for a ranged descriptor without internal descriptor, want a missing key", err)
+	}
+
+	if err := ValidateAccount(account, &chaincfg.MainNetParams); err == nil {
+		t.Error("got no error for testnet keys on mainnet")
+	}
+}
+
+func TestRangedDescriptor(t *testing.T) {
+	keys := testnetKeys(t, 3)
+
+	tests := []struct {
+		desc string
+		want bool
+	}{
+		{sortedMulti(keys, "/0/5"), false},
+		{sortedMulti(keys, "/0/*"), true},
+		{sortedMulti(keys, "/0/5") + "#checksum", false},
+		{"wpkh(" + keys[0] + "/0/*)", true},
+		{"wpkh(" + keys[0] + "/0/*')", true},
+		{"wpkh(" + keys[0] + ")", false},
+		{"addr(tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx)", false},
+	}
+
+	for _, test := range tests {
+		if got := RangedDescriptor(test.desc); got != test.want {
+			t.Errorf("%s: got ranged %v, want %v", test.desc, got, test.want)
+		}
+	}
+}
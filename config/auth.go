@@ -0,0 +1,52 @@
+package config
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// AuthModeToken authenticates requests with a static bearer token.
+	AuthModeToken = "token"
+
+	// AuthModeBasic authenticates requests with HTTP Basic authentication,
+	// against a bcrypt-hashed password.
+	AuthModeBasic = "basic"
+)
+
+// Auth models the configuration of the authentication of the HTTP API.
+//
+// Fields marked as (?) are optional.
+type Auth struct {
+	Mode         string   `json:"mode"`          // token or basic
+	Token        string   `json:"token"`         // (?) Bearer token, in token mode
+	Username     string   `json:"username"`      // (?) Username, in basic mode
+	PasswordHash string   `json:"password_hash"` // (?) bcrypt hash of the password, in basic mode
+	Exempt       []string `json:"exempt"`        // (?) Paths served without authentication, for ex /healthz
+}
+
+// validate checks that the credentials required by the mode are set.
+func (a Auth) validate() error {
+	switch a.Mode {
+	case AuthModeToken:
+		if a.Token == "" {
+			return fmt.Errorf("auth: token is required in token mode")
+		}
+
+	case AuthModeBasic:
+		if a.Username == "" || a.PasswordHash == "" {
+			return fmt.Errorf("auth: username and password_hash are required in basic mode")
+		}
+
+		if _, err := bcrypt.Cost([]byte(a.PasswordHash)); err != nil {
+			return fmt.Errorf("auth: password_hash: %w", err)
+		}
+
+	default:
+		return fmt.Errorf("auth: expected mode %s or %s, got '%s'",
+			AuthModeToken, AuthModeBasic, a.Mode)
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/ledgerhq/satstack/utils"
+)
+
+// ReservedChainNames lists the names that cannot be used as the prefix of
+// the routes of a chain, since they conflict with routes of the default
+// chain.
+var ReservedChainNames = []string{
+	"blockchain", "control", "healthz", "metrics", "readyz", "regtest",
+	"status", "timestamp", "ws",
+}
+
+var chainNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// ChainConfigurations returns the configuration of each chain served by
+// SatStack, starting with the default chain, configured at the top level,
+// and followed by the additional chains.
+//
+// The settings of the HTTP server, like listen, auth or the rate limits, are
+// only read from the top level. The additional chains share the path of the
+// config file, in order to reload their accounts from it.
+func (c *Configuration) ChainConfigurations() []*Configuration {
+	ret := []*Configuration{c}
+
+	for idx := range c.Chains {
+		chain := &c.Chains[idx]
+		chain.Path = c.Path
+		chain.secondary = true
+
+		ret = append(ret, chain)
+	}
+
+	return ret
+}
+
+// ValidateChainName returns an error if the name cannot be used as the
+// prefix of the routes of a chain.
+func ValidateChainName(name string) error {
+	switch {
+	case !chainNamePattern.MatchString(name):
+		return fmt.Errorf("name: expected lowercase letters, digits, - and _, got '%s'", name)
+	case utils.Contains(ReservedChainNames, name):
+		return fmt.Errorf("name: '%s' is reserved", name)
+	}
+
+	return nil
+}
+
+// chainProblems returns the problems found in the names of the chains, and
+// in the configurations of the additional chains.
+func (c Configuration) chainProblems() Problems {
+	var problems Problems
+
+	if c.Name != nil {
+		if err := ValidateChainName(*c.Name); err != nil {
+			problems = append(problems, err)
+		}
+	}
+
+	names := make(map[string]bool)
+	if c.Name != nil {
+		names[*c.Name] = true
+	}
+
+	for idx, chain := range c.Chains {
+		for _, err := range chain.problems() {
+			problems = append(problems, fmt.Errorf("chains[%d]: %w", idx, err))
+		}
+
+		if len(chain.Chains) > 0 {
+			problems = append(problems, fmt.Errorf("chains[%d].chains: chains cannot be nested", idx))
+		}
+
+		if chain.Name == nil {
+			continue
+		}
+
+		if err := ValidateChainName(*chain.Name); err != nil {
+			problems = append(problems, fmt.Errorf("chains[%d].%w", idx, err))
+		}
+
+		if names[*chain.Name] {
+			problems = append(problems,
+				fmt.Errorf("chains[%d].name: duplicate name '%s'", idx, *chain.Name))
+		}
+
+		names[*chain.Name] = true
+	}
+
+	return problems
+}
@@ -5,3 +5,36 @@ import "time"
 // BIP0039Genesis indicates the earliest date of a BIP39 seed that a Ledger
 // device could possibly have.
 var BIP0039Genesis, _ = time.Parse("2006/01/02", "2013/09/10")
+
+const (
+	// MinFeeTarget and MaxFeeTarget indicate the range of confirmation
+	// targets (in blocks) accepted by the estimatesmartfee RPC.
+	MinFeeTarget = 1
+	MaxFeeTarget = 1008
+
+	// MaxAccountDepth indicates the maximum number of addresses of an
+	// account, which is the largest range of descriptors accepted by
+	// bitcoind.
+	MaxAccountDepth = 1000000
+)
+
+// DefaultFeeTargets indicates the confirmation targets for which fees are
+// estimated, unless overridden in the config or in the request.
+var DefaultFeeTargets = []int64{2, 3, 6}
+
+// DefaultFeeMode indicates the estimatesmartfee mode used, unless overridden
+// in the config or in the request.
+const DefaultFeeMode = "CONSERVATIVE"
+
+// DefaultWarmupBlocks indicates the number of recent blocks cached on
+// startup, unless overridden in the config.
+const DefaultWarmupBlocks = 12
+
+// LogFormats lists the valid log formats. The first one is the default.
+var LogFormats = []string{"text", "json"}
+
+// LogLevels lists the valid log levels. The first one is the default.
+var LogLevels = []string{"info", "debug", "trace", "warn", "error"}
+
+// FeeModes lists the valid estimatesmartfee modes.
+var FeeModes = []string{"UNSET", "ECONOMICAL", "CONSERVATIVE"}
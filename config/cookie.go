@@ -0,0 +1,66 @@
+package config
+
+import (
+	"net"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+// defaultRPCPorts maps the default RPC port of each chain to the name of the
+// subdirectory of the bitcoind data directory used for that chain. Mainnet
+// uses the data directory itself.
+var defaultRPCPorts = map[string]string{
+	"8332":  "",
+	"18332": "testnet3",
+	"38332": "signet",
+	"18443": "regtest",
+}
+
+// UseCookie indicates whether the bitcoind RPC server should be
+// authenticated using the cookie file, rather than static credentials.
+func (c Configuration) UseCookie() bool {
+	return c.RPCUser == nil && c.RPCPassword == nil
+}
+
+// CookieFile returns the path of the bitcoind cookie file. If cookie_path is
+// not set, the default location for the chain is used; the chain is guessed
+// from the port in rpcurl, and defaults to mainnet.
+func (c Configuration) CookieFile() (string, error) {
+	if c.CookiePath != nil {
+		return homedir.Expand(*c.CookiePath)
+	}
+
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+
+	var chainDir string
+	if c.RPCURL != nil {
+		host := *c.RPCURL
+		if idx := strings.Index(host, "://"); idx >= 0 {
+			host = host[idx+3:]
+		}
+
+		if _, port, err := net.SplitHostPort(host); err == nil {
+			chainDir = defaultRPCPorts[port]
+		}
+	}
+
+	return filepath.Join(bitcoindDataDir(home), chainDir, ".cookie"), nil
+}
+
+// bitcoindDataDir returns the default data directory of bitcoind.
+func bitcoindDataDir(home string) string {
+	switch runtime.GOOS {
+	case "darwin":
+		return filepath.Join(home, "Library", "Application Support", "Bitcoin")
+	case "windows":
+		return filepath.Join(home, "AppData", "Roaming", "Bitcoin")
+	default:
+		return filepath.Join(home, ".bitcoin")
+	}
+}
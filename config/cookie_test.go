@@ -0,0 +1,64 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+func TestUseCookie(t *testing.T) {
+	user, pass := "user", "pass"
+
+	tests := []struct {
+		name   string
+		config Configuration
+		want   bool
+	}{
+		{"no credentials", Configuration{}, true},
+		{"explicit credentials", Configuration{RPCUser: &user, RPCPassword: &pass}, false},
+		{"user only", Configuration{RPCUser: &user}, false},
+	}
+
+	for _, test := range tests {
+		if got := test.config.UseCookie(); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCookieFile(t *testing.T) {
+	home, err := homedir.Dir()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataDir := bitcoindDataDir(home)
+
+	tests := []struct {
+		rpcURL string
+		want   string
+	}{
+		{"localhost:8332", filepath.Join(dataDir, ".cookie")},
+		{"http://localhost:18332", filepath.Join(dataDir, "testnet3", ".cookie")},
+		{"https://localhost:38332", filepath.Join(dataDir, "signet", ".cookie")},
+		{"localhost:18443", filepath.Join(dataDir, "regtest", ".cookie")},
+		{"localhost:9999", filepath.Join(dataDir, ".cookie")},
+		{"localhost", filepath.Join(dataDir, ".cookie")},
+	}
+
+	for _, test := range tests {
+		rpcURL := test.rpcURL
+
+		got, err := Configuration{RPCURL: &rpcURL}.CookieFile()
+		if err != nil || got != test.want {
+			t.Errorf("%s: got %s, %v, want %s", test.rpcURL, got, err, test.want)
+		}
+	}
+
+	cookiePath := "~/bitcoin/.cookie"
+	got, err := Configuration{CookiePath: &cookiePath}.CookieFile()
+	if want := filepath.Join(home, "bitcoin", ".cookie"); err != nil || got != want {
+		t.Errorf("cookie_path: got %s, %v, want %s", got, err, want)
+	}
+}
@@ -0,0 +1,396 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ledgerhq/satstack/protocol"
+	"github.com/ledgerhq/satstack/utils"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/hdkeychain"
+)
+
+// descriptorCharset and checksumCharset are the character sets of output
+// script descriptors and of their checksums, as specified by BIP-380.
+const (
+	descriptorCharset = "0123456789()[],'/*abcdefgh@:$%{}" +
+		"IJKLMNOPQRSTUVWXYZ&+-.;<=>?!^_|~" +
+		"ijklmnopqrstuvwxyzABCDEFGH`#\"\\ "
+	checksumCharset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+)
+
+// checksumGenerators are the generators of the BCH code of the descriptor
+// checksums.
+var checksumGenerators = [...]uint64{
+	0xf5dee51989, 0xa9fdca3312, 0x1bab10e32d, 0x3706b1677a, 0x644d626ffd,
+}
+
+// accountScripts lists the top-level script functions of the descriptors
+// that can be used for accounts.
+var accountScripts = []string{"pkh", "wpkh", "sh", "wsh", "tr"}
+
+var (
+	// extendedKeyPattern matches an extended key, along with its key origin
+	// (if any) and its derivation path, for ex [d34db33f/84'/0'/0']xpub/0/*.
+	extendedKeyPattern = regexp.MustCompile(
+		`(?:\[([^\]]*)\])?([1-9A-HJ-NP-Za-km-z]{100,})((?:/[^,()/]*)*)`)
+
+	// pathStepPattern matches a step of a derivation path, which is either
+	// an index, a wildcard, or a BIP-389 multipath step, possibly hardened.
+	pathStepPattern = regexp.MustCompile(
+		`^(?:[0-9]+['hH]?|\*['hH]?|<[0-9]+['hH]?(?:;[0-9]+['hH]?)+>)$`)
+
+	fingerprintPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}$`)
+)
+
+// descriptorChecksum computes the checksum of a descriptor, without the
+// checksum suffix.
+func descriptorChecksum(desc string) (string, error) {
+	var symbols []uint64
+	var groups []uint64
+
+	for _, c := range desc {
+		value := strings.IndexRune(descriptorCharset, c)
+		if value < 0 {
+			return "", fmt.Errorf("invalid character '%c'", c)
+		}
+
+		symbols = append(symbols, uint64(value&31))
+		groups = append(groups, uint64(value>>5))
+
+		if len(groups) == 3 {
+			symbols = append(symbols, groups[0]*9+groups[1]*3+groups[2])
+			groups = nil
+		}
+	}
+
+	switch len(groups) {
+	case 1:
+		symbols = append(symbols, groups[0])
+	case 2:
+		symbols = append(symbols, groups[0]*3+groups[1])
+	}
+
+	symbols = append(symbols, 0, 0, 0, 0, 0, 0, 0, 0)
+
+	chk := uint64(1)
+	for _, value := range symbols {
+		top := chk >> 35
+		chk = (chk&0x7ffffffff)<<5 ^ value
+
+		for idx, generator := range checksumGenerators {
+			if (top>>uint(idx))&1 == 1 {
+				chk ^= generator
+			}
+		}
+	}
+
+	chk ^= 1
+
+	var ret strings.Builder
+	for idx := 0; idx < 8; idx++ {
+		ret.WriteByte(checksumCharset[(chk>>uint(5*(7-idx)))&31])
+	}
+
+	return ret.String(), nil
+}
+
+// DescriptorWithChecksum returns the descriptor suffixed with its checksum,
+// as computed by bitcoind. A descriptor that already has a checksum is
+// returned as is, if the checksum is valid.
+func DescriptorWithChecksum(desc string) (string, error) {
+	body, checksum, found := desc, "", false
+	if idx := strings.IndexByte(desc, '#'); idx >= 0 {
+		body, checksum, found = desc[:idx], desc[idx+1:], true
+	}
+
+	expected, err := descriptorChecksum(body)
+	if err != nil {
+		return "", err
+	}
+
+	// A trailing '#' is a missing checksum, rather than no checksum.
+	if found && checksum != expected {
+		return "", fmt.Errorf("invalid checksum '%s', expected '%s'", checksum, expected)
+	}
+
+	return body + "#" + expected, nil
+}
+
+// descriptorProblems returns the problems found in the descriptor of an
+// account, without connecting to bitcoind.
+//
+// The syntax, the checksum (if any) and the derivation paths are checked,
+// along with the extended keys. The descriptor need not be ranged; see
+// RangedDescriptor.
+func descriptorProblems(desc string) []error {
+	var problems []error
+
+	body := desc
+	if idx := strings.IndexByte(desc, '#'); idx >= 0 {
+		body = desc[:idx]
+		checksum := desc[idx+1:]
+
+		expected, err := descriptorChecksum(body)
+		switch {
+		case err != nil:
+			return []error{err}
+		case checksum != expected:
+			problems = append(problems,
+				fmt.Errorf("invalid checksum '%s', expected '%s'", checksum, expected))
+		}
+	} else if _, err := descriptorChecksum(body); err != nil {
+		return []error{err}
+	}
+
+	if err := checkBrackets(body); err != nil {
+		return append(problems, err)
+	}
+
+	script := body
+	if idx := strings.IndexByte(body, '('); idx >= 0 {
+		script = body[:idx]
+	}
+
+	if !utils.Contains(accountScripts, script) {
+		problems = append(problems,
+			fmt.Errorf("unsupported script '%s', expected one of %v", script, accountScripts))
+	}
+
+	keys := extendedKeyPattern.FindAllStringSubmatch(body, -1)
+	if len(keys) == 0 {
+		return append(problems, fmt.Errorf("no extended public key found"))
+	}
+
+	for _, match := range keys {
+		origin, key, path := match[1], match[2], match[3]
+
+		if strings.HasPrefix(match[0], "[") {
+			if err := checkKeyOrigin(origin); err != nil {
+				problems = append(problems, err)
+			}
+		}
+
+		if _, err := checkDerivationPath(path); err != nil {
+			problems = append(problems, fmt.Errorf("derivation path '%s': %w", path, err))
+		}
+
+		if err := checkExtendedKey(key); err != nil {
+			problems = append(problems, err)
+		}
+	}
+
+	return problems
+}
+
+// RangedDescriptor indicates whether addresses are derived from the
+// descriptor, that is whether the derivation path of one of its keys ends with
+// a wildcard, as in wpkh(xpub/0/*). Other descriptors, like
+// wsh(sortedmulti(2,xpub1/0/5,xpub2/0/5,xpub3/0/5)), have a single address.
+func RangedDescriptor(desc string) bool {
+	for _, match := range extendedKeyPattern.FindAllStringSubmatch(desc, -1) {
+		if wildcard, err := checkDerivationPath(match[3]); wildcard || err != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkBrackets verifies that the brackets of a descriptor are balanced.
+func checkBrackets(desc string) error {
+	closing := map[rune]rune{'(': ')', '[': ']', '{': '}'}
+
+	var stack []rune
+	for _, c := range desc {
+		switch c {
+		case '(', '[', '{':
+			stack = append(stack, closing[c])
+		case ')', ']', '}':
+			if len(stack) == 0 || stack[len(stack)-1] != c {
+				return fmt.Errorf("unexpected '%c'", c)
+			}
+
+			stack = stack[:len(stack)-1]
+		}
+	}
+
+	if len(stack) > 0 {
+		return fmt.Errorf("missing '%c'", stack[len(stack)-1])
+	}
+
+	return nil
+}
+
+// checkKeyOrigin verifies a key origin, made of a fingerprint followed by
+// a derivation path without wildcard, for ex d34db33f/84'/0'/0'.
+func checkKeyOrigin(origin string) error {
+	steps := strings.Split(origin, "/")
+	if !fingerprintPattern.MatchString(steps[0]) {
+		return fmt.Errorf("key origin '%s': invalid fingerprint '%s'", origin, steps[0])
+	}
+
+	for _, step := range steps[1:] {
+		if !pathStepPattern.MatchString(step) || strings.ContainsAny(step, "*<") {
+			return fmt.Errorf("key origin '%s': invalid step '%s'", origin, step)
+		}
+
+		if err := checkPathIndex(step); err != nil {
+			return fmt.Errorf("key origin '%s': %w", origin, err)
+		}
+	}
+
+	return nil
+}
+
+// checkDerivationPath verifies the derivation path following an extended
+// key, for ex /0/*, and returns whether it ends with a wildcard. Since only
+// public keys are supported, hardened steps cannot be derived.
+func checkDerivationPath(path string) (bool, error) {
+	if path == "" {
+		return false, nil
+	}
+
+	steps := strings.Split(path[1:], "/")
+	for idx, step := range steps {
+		if !pathStepPattern.MatchString(step) {
+			return false, fmt.Errorf("invalid step '%s'", step)
+		}
+
+		if strings.HasPrefix(step, "*") && idx != len(steps)-1 {
+			return false, fmt.Errorf("wildcard must be the last step")
+		}
+
+		if strings.ContainsAny(step, "'hH") {
+			return false, fmt.Errorf("hardened step '%s' cannot be derived from a public key", step)
+		}
+
+		if strings.HasPrefix(step, "<") {
+			for _, index := range strings.Split(step[1:len(step)-1], ";") {
+				if err := checkPathIndex(index); err != nil {
+					return false, err
+				}
+			}
+
+			continue
+		}
+
+		if err := checkPathIndex(step); err != nil {
+			return false, err
+		}
+	}
+
+	return steps[len(steps)-1] == "*", nil
+}
+
+// checkPathIndex verifies that an index of a derivation path, possibly
+// hardened, is below 2^31. Wildcards are ignored.
+func checkPathIndex(step string) error {
+	index := strings.TrimRight(step, "'hH")
+	if index == "*" {
+		return nil
+	}
+
+	if n, err := strconv.ParseUint(index, 10, 32); err != nil || n >= hdkeychain.HardenedKeyStart {
+		return fmt.Errorf("index '%s' out of range", index)
+	}
+
+	return nil
+}
+
+// descriptorChainProblems returns the problems found in the extended keys of
+// the descriptor of an account, whose version bytes must match the chain
+// described by params. Invalid keys are ignored; see descriptorProblems.
+func descriptorChainProblems(desc string, params *chaincfg.Params) []error {
+	var problems []error
+
+	for _, match := range extendedKeyPattern.FindAllStringSubmatch(desc, -1) {
+		key := match[2]
+
+		extendedKey, err := hdkeychain.NewKeyFromString(key)
+		if err != nil || extendedKey.IsForNet(params) {
+			continue
+		}
+
+		if network := keyNetwork(extendedKey); network != "" {
+			problems = append(problems,
+				fmt.Errorf("extended key '%s': %s key, does not match the chain of bitcoind (%s)",
+					shortKey(key), network, params.Name))
+		}
+	}
+
+	return problems
+}
+
+// checkExtendedKey verifies that the key is a valid extended public key.
+//
+// SLIP-0132 keys, like ypub or zpub, are rejected, since bitcoind only
+// supports xpub and tpub.
+func checkExtendedKey(key string) error {
+	short := shortKey(key)
+
+	extendedKey, err := hdkeychain.NewKeyFromString(key)
+	if err != nil {
+		return fmt.Errorf("extended key '%s': %w", short, err)
+	}
+
+	if extendedKey.IsPrivate() {
+		return fmt.Errorf("extended key '%s': private key, only public keys are supported", short)
+	}
+
+	network := keyNetwork(extendedKey)
+	if network == "" {
+		return fmt.Errorf("extended key '%s': unsupported version bytes, convert it to %s",
+			short, "an xpub (mainnet) or a tpub (testnet, signet, regtest)")
+	}
+
+	return nil
+}
+
+// shortKey abbreviates an extended key in error messages.
+func shortKey(key string) string {
+	return key[:8] + "..." + key[len(key)-4:]
+}
+
+// keyNetwork returns the networks matching the version bytes of the key, or
+// an empty string if none does. The version bytes of testnet3 are shared by
+// signet and regtest.
+func keyNetwork(key *hdkeychain.ExtendedKey) string {
+	switch {
+	case key.IsForNet(&chaincfg.MainNetParams):
+		return "mainnet"
+	case key.IsForNet(&chaincfg.TestNet3Params):
+		return "testnet"
+	default:
+		return ""
+	}
+}
+
+// isValidAddress indicates whether the address is valid on any of the
+// networks supported by SatStack. Signet shares the addresses of testnet3.
+func isValidAddress(address string) bool {
+	for _, params := range []*chaincfg.Params{
+		&chaincfg.MainNetParams, &chaincfg.TestNet3Params, &chaincfg.RegressionNetParams,
+	} {
+		if isAddressForNet(address, params) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isAddressForNet indicates whether the address is valid on the network
+// described by params.
+func isAddressForNet(address string, params *chaincfg.Params) bool {
+	if protocol.IsTaprootAddress(address, params) {
+		return true
+	}
+
+	decoded, err := btcutil.DecodeAddress(address, params)
+	return err == nil && decoded.IsForNet(params)
+}
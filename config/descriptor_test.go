@@ -0,0 +1,104 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+// checksumVectors are descriptors of the BIP-0380 and Bitcoin Core
+// (descriptor_tests.cpp) test vectors, along with their checksums.
+var checksumVectors = []struct {
+	desc     string
+	checksum string
+}{
+	{"raw(deadbeef)", "89f8spxm"},
+	{"pk(0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798)", "gn28ywm7"},
+	{"sh(multi(2,[00000000/111'/222]xprvA1RpRA33e1JQ7ifknakTFpgNXPmW2YvmhqLQYMmrj4xJXXWYpDPS3xz7iAxn8L39njGVyuoseXzU6rcxFLJ8HFsTjSyQbLYnMpCqE2VbFWc,xprv9uPDJpEQgRQfDcW7BkF7eTya6RPxXeJCqCJGHuCJ4GiRVLzkTXBAJMu2qaMWPrS7AANYqdq6vcBcBUdJCVVFceUvJFjaPdGZ2y9WACViL4L/0))", "ggrsrxfy"},
+	{"sh(multi(2,[00000000/111'/222]xpub6ERApfZwUNrhLCkDtcHTcxd75RbzS1ed54G1LkBUHQVHQKqhMkhgbmJbZRkrgZw4koxb5JaHWkY4ALHY2grBGRjaDMzQLcgJvLJuZZvRcEL,xpub68NZiKmJWnxxS6aaHmn81bvJeTESw724CRDs6HbuccFQN9Ku14VQrADWgqbhhTHBaohPX4CjNLf9fq9MYo6oDaPPLPxSb7gwQN3ih19Zm4Y/0))", "tjg09x5t"},
+}
+
+// accountVector is a checksum vector that is a valid account descriptor.
+var accountVector = checksumVectors[3]
+
+func TestDescriptorChecksum(t *testing.T) {
+	for _, vector := range checksumVectors {
+		got, err := descriptorChecksum(vector.desc)
+		if err != nil || got != vector.checksum {
+			t.Errorf("%s: got %q, %v, want %q", vector.desc, got, err, vector.checksum)
+		}
+	}
+
+	for _, desc := range []string{"raw(Ü)", "raw(dead\tbeef)", "raw(dead\nbeef)"} {
+		if _, err := descriptorChecksum(desc); err == nil || !strings.Contains(err.Error(), "invalid character") {
+			t.Errorf("%q: got error %v, want an invalid character", desc, err)
+		}
+	}
+}
+
+func TestDescriptorWithChecksum(t *testing.T) {
+	for _, vector := range checksumVectors {
+		want := vector.desc + "#" + vector.checksum
+
+		// The checksum is appended, or kept if valid.
+		for _, desc := range []string{vector.desc, want} {
+			if got, err := DescriptorWithChecksum(desc); err != nil || got != want {
+				t.Errorf("%s: got %q, %v, want %q", desc, got, err, want)
+			}
+		}
+	}
+
+	// Invalid vectors of BIP-0380.
+	tests := []struct {
+		name string
+		desc string
+	}{
+		{"missing checksum", "raw(deadbeef)#"},
+		{"too long checksum", "raw(deadbeef)#89f8spxmx"},
+		{"too short checksum", "raw(deadbeef)#89f8spx"},
+		{"error in payload", "raw(dedbeef)#89f8spxm"},
+		{"error in checksum", "raw(deadbeef)##9f8spxm"},
+		{"invalid character in payload", "raw(Ü)#00000000"},
+	}
+
+	for _, test := range tests {
+		if got, err := DescriptorWithChecksum(test.desc); err == nil {
+			t.Errorf("%s: got %q, want an error", test.name, got)
+		}
+	}
+}
+
+func TestDescriptorProblemsChecksum(t *testing.T) {
+	desc, err := DescriptorWithChecksum(accountVector.desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if problems := descriptorProblems(desc); len(problems) != 0 {
+		t.Errorf("got problems %v, want none", problems)
+	}
+
+	if problems := descriptorProblems(accountVector.desc); len(problems) != 0 {
+		t.Errorf("got problems %v without checksum, want none", problems)
+	}
+
+	tests := []struct {
+		name string
+		desc string
+	}{
+		{"error in checksum", accountVector.desc + "#tjg09x5u"},
+		{"error in payload", strings.Replace(desc, "/111'", "/112'", 1)},
+		{"missing checksum", accountVector.desc + "#"},
+	}
+
+	for _, test := range tests {
+		problems := descriptorProblems(test.desc)
+		if len(problems) != 1 || !strings.Contains(problems[0].Error(), "invalid checksum") {
+			t.Errorf("%s: got problems %v, want an invalid checksum", test.name, problems)
+		}
+	}
+
+	if problems := descriptorProblems("wpkh(Ü)"); len(problems) != 1 ||
+		!strings.Contains(problems[0].Error(), "invalid character") {
+		t.Errorf("got problems %v, want an invalid character", problems)
+	}
+}
@@ -1,6 +1,9 @@
 package config
 
-import "errors"
+import (
+	"errors"
+	"strings"
+)
 
 var (
 	// ErrMissingKey indicates that a key was expected in the config,
@@ -23,3 +26,16 @@ var (
 	// the user's home directory.
 	ErrHomeNotFound = errors.New("home directory not found")
 )
+
+// Problems is a list of errors found in the config, which are reported all at
+// once.
+type Problems []error
+
+func (p Problems) Error() string {
+	messages := make([]string, len(p))
+	for idx, err := range p {
+		messages[idx] = err.Error()
+	}
+
+	return strings.Join(messages, "; ")
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DefaultListen is the address on which SatStack listens, unless overridden
+// in the config. It is the address expected by Ledger Live.
+const DefaultListen = ":20000"
+
+// defaultSocketMode is the file mode of unix domain sockets, unless
+// overridden in the config.
+const defaultSocketMode = os.FileMode(0660)
+
+// unixScheme is the prefix of the listen addresses of unix domain sockets.
+const unixScheme = "unix://"
+
+// ListenAddresses models the listen field, which is either a single address,
+// or a list of addresses.
+type ListenAddresses []string
+
+func (l *ListenAddresses) UnmarshalJSON(input []byte) error {
+	var address string
+	if err := json.Unmarshal(input, &address); err == nil {
+		*l = ListenAddresses{address}
+		return nil
+	}
+
+	var addresses []string
+	if err := json.Unmarshal(input, &addresses); err != nil {
+		return fmt.Errorf("expected an address, or a list of addresses")
+	}
+
+	*l = addresses
+	return nil
+}
+
+// Listener models an address on which the HTTP server listens.
+type Listener struct {
+	Network string // tcp or unix
+	Address string // host:port, or path of the socket
+}
+
+func (l Listener) String() string {
+	if l.Network == "unix" {
+		return unixScheme + l.Address
+	}
+
+	return l.Address
+}
+
+// Listeners returns the addresses on which the HTTP server listens, which
+// are either host:port pairs, with IPv6 hosts in brackets like [::1]:20000,
+// or paths of unix domain sockets like unix:///run/lss.sock.
+func (c Configuration) Listeners() ([]Listener, error) {
+	addresses := c.Listen
+	if len(addresses) == 0 {
+		addresses = ListenAddresses{DefaultListen}
+	}
+
+	ret := make([]Listener, 0, len(addresses))
+	for _, address := range addresses {
+		if strings.HasPrefix(address, unixScheme) {
+			path := address[len(unixScheme):]
+			if path == "" {
+				return nil, fmt.Errorf("listen: missing socket path in '%s'", address)
+			}
+
+			ret = append(ret, Listener{Network: "unix", Address: path})
+			continue
+		}
+
+		_, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, fmt.Errorf("listen: expected host:port or unix:///path, got '%s'", address)
+		}
+
+		if n, err := strconv.ParseUint(port, 10, 16); err != nil || n == 0 {
+			return nil, fmt.Errorf("listen: invalid port in '%s'", address)
+		}
+
+		ret = append(ret, Listener{Network: "tcp", Address: address})
+	}
+
+	return ret, nil
+}
+
+// SocketFileMode returns the file mode of the unix domain sockets, given in
+// octal in the config, like "0660".
+func (c Configuration) SocketFileMode() (os.FileMode, error) {
+	if c.SocketMode == nil {
+		return defaultSocketMode, nil
+	}
+
+	mode, err := strconv.ParseUint(*c.SocketMode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("socket_mode: expected an octal file mode, got '%s'", *c.SocketMode)
+	}
+
+	return os.FileMode(mode), nil
+}
@@ -22,33 +22,57 @@ import (
 //
 // The filename is always expected to be lss.json.
 func Load() (*Configuration, error) {
-	paths, err := configLookupPaths()
+	configPath, err := Find()
 	if err != nil {
 		return nil, err
 	}
 
-	var configPath string
+	log.WithField("path", configPath).Info("Config file detected")
+
+	return LoadFile(configPath)
+}
+
+// Find returns the path of the config file, searched in the directories
+// listed by Load.
+func Find() (string, error) {
+	paths, err := configLookupPaths()
+	if err != nil {
+		return "", err
+	}
+
 	for _, maybePath := range paths {
 		if fileExists(maybePath) {
-			configPath = maybePath
-			break
+			return maybePath, nil
 		}
 	}
 
-	if configPath == "" {
-		return nil, ErrConfigFileNotFound
+	return "", ErrConfigFileNotFound
+}
+
+// LoadFile reads and validates the config file at the given path. It is
+// used to reload the configuration from the file it was initially loaded
+// from.
+func LoadFile(configPath string) (*Configuration, error) {
+	configuration, err := ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := configuration.Validate(); err != nil {
+		return nil, err
 	}
 
-	log.WithField("path", configPath).Info("Config file detected")
+	return configuration, nil
+}
 
+// ReadFile reads the config file at the given path, without validating it.
+func ReadFile(configPath string) (*Configuration, error) {
 	configuration, err := loadFromPath(configPath)
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", ErrMalformed, err)
 	}
 
-	if err := configuration.validate(); err != nil {
-		return nil, fmt.Errorf("%s: %w", ErrValidation, err)
-	}
+	configuration.Path = configPath
 
 	return configuration, nil
 }
@@ -1,18 +1,74 @@
 package config
 
 import (
+	"fmt"
+	"path/filepath"
 	"strings"
 	"time"
+
+	"github.com/ledgerhq/satstack/redact"
 )
 
 // Account struct models the configuration of an account on Ledger Live.
 //
 // Fields marked as (?) are optional.
 type Account struct {
-	External *string `json:"external"` // output descriptor at external path
-	Internal *string `json:"internal"` // output descriptor at internal path
-	Depth    *int    `json:"depth"`    // (?) Number of addresses to import
-	Birthday *date   `json:"birthday"` // (?) Earliest known creation date (YYYY/MM/DD)
+	External   *string `json:"external,omitempty"`    // output descriptor at external path, or multipath descriptor
+	Address    *string `json:"address,omitempty"`     // single address to watch, instead of external
+	Internal   *string `json:"internal,omitempty"`    // (?) output descriptor at internal path; omit for multipath descriptors, or descriptors without wildcard
+	Depth      *int    `json:"depth,omitempty"`       // (?) Number of addresses to import
+	Birthday   *date   `json:"birthday,omitempty"`    // (?) Earliest known creation date (YYYY/MM/DD)
+	AutoExtend bool    `json:"auto_extend,omitempty"` // (?) Import more addresses when the used ones get close to the depth
+	Label      *string `json:"label,omitempty"`       // (?) Name of the account in API responses, unique across accounts
+}
+
+// ID identifies the account in the configuration, by its external
+// descriptor or its address, as written.
+func (a Account) ID() string {
+	if a.Address != nil {
+		return *a.Address
+	}
+
+	return *a.External
+}
+
+// Name returns the label of the account, as configured, or else a
+// fingerprint of the first extended key of its external descriptor (see
+// redact.Fingerprint), for ex xpub:1a2b3c4d. Accounts watching a single
+// address, or whose descriptor has no extended key, are fingerprinted by
+// their ID instead.
+func (a Account) Name() string {
+	if a.Label != nil {
+		return *a.Label
+	}
+
+	if a.External != nil {
+		if match := extendedKeyPattern.FindStringSubmatch(*a.External); match != nil {
+			return redact.Fingerprint(match[2])
+		}
+	}
+
+	return redact.Fingerprint(a.ID())
+}
+
+// Multipath indicates whether the external descriptor of the account is a
+// multipath descriptor, like wpkh(xpub/<0;1>/*), covering both the external
+// and internal paths.
+func (a Account) Multipath() bool {
+	return a.External != nil && strings.Contains(*a.External, "<")
+}
+
+// Ranged indicates whether addresses are derived from any descriptor of the
+// account; see RangedDescriptor. Accounts watching a single address are not
+// ranged.
+func (a Account) Ranged() bool {
+	for _, desc := range a.descriptors() {
+		if RangedDescriptor(desc.value) {
+			return true
+		}
+	}
+
+	return false
 }
 
 // Configuration is a struct to model the JSON configuration
@@ -20,11 +76,128 @@ type Account struct {
 //
 // Fields marked as (?) are optional.
 type Configuration struct {
-	RPCURL      *string   `json:"rpcurl"`
-	RPCUser     *string   `json:"rpcuser"`
-	RPCPassword *string   `json:"rpcpass"`
-	NoTLS       bool      `json:"notls"`
-	Accounts    []Account `json:"accounts"`
+	RPCURL               *string         `json:"rpcurl"`
+	RPCUser              *string         `json:"rpcuser"`     // (?) Omit both rpcuser and rpcpass to use the cookie file
+	RPCPassword          *string         `json:"rpcpass"`     // (?) See rpcuser
+	CookiePath           *string         `json:"cookie_path"` // (?) Path of the bitcoind cookie file
+	NoTLS                bool            `json:"notls"`
+	TLS                  bool            `json:"tls"`                    // (?) Force TLS; implied by an https:// rpcurl
+	TLSCACert            *string         `json:"tls_ca_cert"`            // (?) PEM CA certificate to verify bitcoind with
+	Proxy                *string         `json:"proxy"`                  // (?) SOCKS5 proxy for RPC connections
+	ZMQ                  *string         `json:"zmq"`                    // (?) bitcoind zmqpubhashblock endpoint
+	WalletName           *string         `json:"wallet_name"`            // (?) Name of the bitcoind wallet used by SatStack
+	Listen               ListenAddresses `json:"listen"`                 // (?) Addresses to listen on, host:port or unix:///path
+	SocketMode           *string         `json:"socket_mode"`            // (?) Octal file mode of unix domain sockets
+	Metrics              bool            `json:"metrics"`                // (?) Expose Prometheus metrics on /metrics
+	BatchSize            *int            `json:"rpc_batch_size"`         // (?) Number of RPC calls per batch request
+	RPCTimeout           *int            `json:"rpc_timeout"`            // (?) Maximum duration of RPC calls (seconds)
+	RPCSlowTimeout       *int            `json:"rpc_slow_timeout"`       // (?) Maximum duration of slow RPC calls, like getrawmempool (seconds)
+	RPCHTTPTimeout       *int            `json:"rpc_http_timeout"`       // (?) Maximum duration of any HTTP request to bitcoind (seconds)
+	FeeTargets           []int64         `json:"fee_targets"`            // (?) Default confirmation targets for fees
+	FeeMode              *string         `json:"fee_mode"`               // (?) Default estimatesmartfee mode
+	ReconnectInterval    *int            `json:"reconnect_interval"`     // (?) Initial delay between reconnection attempts (seconds)
+	ReconnectMaxInterval *int            `json:"reconnect_max_interval"` // (?) Maximum delay between reconnection attempts (seconds)
+	CacheSize            *int            `json:"cache_size"`             // (?) Maximum number of cached transactions and blocks
+	CacheTTL             *int            `json:"cache_ttl"`              // (?) Duration for which unconfirmed transactions are cached (seconds)
+	MempoolCacheTTL      *int            `json:"mempool_cache_ttl"`      // (?) Duration for which the mempool histogram is cached (seconds)
+	DiskCache            *string         `json:"disk_cache"`             // (?) Path of the file caching confirmed transactions and block headers across restarts
+	DiskCacheSize        *int            `json:"disk_cache_size"`        // (?) Maximum size of the disk cache (MB)
+	NoDescriptorList     bool            `json:"no_descriptor_list"`     // (?) Disable the /control/descriptors endpoint
+	NoCompression        bool            `json:"no_compression"`         // (?) Disable the gzip compression of responses
+	NoETags              bool            `json:"no_etags"`               // (?) Disable the ETags of the block and address transactions endpoints
+	ControlAPI           bool            `json:"control_api"`            // (?) Enable the endpoints adding accounts at runtime; requires auth
+	RateLimitExplorer    *RateLimit      `json:"rate_limit_explorer"`    // (?) Rate limit of the explorer endpoints
+	RateLimitStatus      *RateLimit      `json:"rate_limit_status"`      // (?) Rate limit of the status and health endpoints
+	TrustedProxies       []string        `json:"trusted_proxies"`        // (?) IPs or CIDRs of reverse proxies setting X-Forwarded-For
+	AllowedOrigins       []string        `json:"allowed_origins"`        // (?) Origins allowed to make CORS requests; "*" for any
+	LogFormat            *string         `json:"log_format"`             // (?) Log format, text (default) or json
+	LogLevel             *string         `json:"log_level"`              // (?) Log level, info (default), debug, trace, warn or error
+	PrivacyMode          *bool           `json:"privacy_mode"`           // (?) Redact addresses, txids and extended keys in logs; default on, except at trace level
+	Auth                 *Auth           `json:"auth"`                   // (?) Authentication of the HTTP API
+	DevMode              bool            `json:"dev_mode"`               // (?) Enable the /regtest endpoints, on regtest only
+	RequireTxIndex       bool            `json:"require_txindex"`        // (?) Refuse to start if bitcoind has no transaction index
+	Datadir              *string         `json:"datadir"`                // (?) Data directory of bitcoind, if on the same host, to check its free disk space
+	LowDiskThreshold     *int            `json:"low_disk_threshold"`     // (?) Free disk space below which to warn (MB)
+	ScanStallTimeout     *int            `json:"scan_stall_timeout"`     // (?) Duration without rescan progress after which it is recovered (seconds)
+	PollingInterval      *int            `json:"polling_interval"`       // (?) Interval at which the chain tip is polled, without ZMQ (seconds)
+	WarmupBlocks         *int            `json:"warmup_blocks"`          // (?) Number of recent blocks cached on startup; 0 to disable
+	PruneStaleAccounts   bool            `json:"prune_stale_accounts"`   // (?) Prune the wallet entries of unconfigured accounts, where the wallet allows it
+	AllowPartialHistory  bool            `json:"allow_partial_history"`  // (?) Import accounts born before the blocks pruned by bitcoind, scanning from the earliest block available
+	ServeDuringSync      bool            `json:"serve_during_sync"`      // (?) Serve the address endpoints while bitcoind is syncing or the wallet scanning, with incomplete results
+	Accounts             []Account       `json:"accounts"`
+	Name                 *string         `json:"name"`   // (?) Prefix of the routes of the chain; defaults to its currency
+	Chains               []Configuration `json:"chains"` // (?) Additional chains, each with its own node and accounts
+
+	// Path of the file the configuration was loaded from.
+	Path string `json:"-"`
+
+	// secondary is set on the configurations of the additional chains.
+	secondary bool
+}
+
+// StatePath returns the path of the file in which SatStack persists its
+// state across restarts. It lives next to the configuration file, and the
+// state of each additional chain is stored in a file named after the chain.
+//
+// An empty string is returned if the configuration was not loaded from a
+// file.
+func (c Configuration) StatePath() string {
+	if c.Path == "" {
+		return ""
+	}
+
+	name := "lss.state.json"
+	if c.secondary && c.Name != nil {
+		name = fmt.Sprintf("lss.%s.state.json", *c.Name)
+	}
+
+	return filepath.Join(filepath.Dir(c.Path), name)
+}
+
+// RPCHost returns the host and port of the bitcoind RPC server, without any
+// URL scheme.
+func (c Configuration) RPCHost() string {
+	host := *c.RPCURL
+	for _, scheme := range []string{"https://", "http://"} {
+		if strings.HasPrefix(strings.ToLower(host), scheme) {
+			return host[len(scheme):]
+		}
+	}
+
+	return host
+}
+
+// UseTLS indicates whether the connection to the bitcoind RPC server must
+// use TLS. It is enabled by the tls field, or by an https:// scheme in
+// rpcurl. Otherwise, TLS is used unless notls is set.
+func (c Configuration) UseTLS() bool {
+	if c.TLS || strings.HasPrefix(strings.ToLower(*c.RPCURL), "https://") {
+		return true
+	}
+
+	if strings.HasPrefix(strings.ToLower(*c.RPCURL), "http://") {
+		return false
+	}
+
+	return !c.NoTLS
+}
+
+// RPCProxy returns the URL of the SOCKS5 proxy to connect to bitcoind
+// through, or an empty string if none is configured.
+//
+// The socks5h:// scheme is accepted as an alias of socks5://, since the
+// proxy always resolves hostnames (required for .onion addresses).
+func (c Configuration) RPCProxy() string {
+	if c.Proxy == nil {
+		return ""
+	}
+
+	proxy := *c.Proxy
+	if strings.HasPrefix(strings.ToLower(proxy), "socks5h://") {
+		proxy = "socks5://" + proxy[len("socks5h://"):]
+	}
+
+	return proxy
 }
 
 type date struct {
@@ -42,3 +215,9 @@ func (d *date) UnmarshalJSON(input []byte) error {
 	d.Time = newTime
 	return nil
 }
+
+// MarshalJSON implements the json.Marshaler interface, with the format read
+// by UnmarshalJSON.
+func (d date) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + d.Format("2006/01/02") + `"`), nil
+}
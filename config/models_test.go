@@ -0,0 +1,33 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/ledgerhq/satstack/redact"
+)
+
+func TestAccountName(t *testing.T) {
+	keys := testnetKeys(t, 3)
+
+	external := "wpkh([d34db33f/84'/1'/0']" + keys[0] + "/0/*)"
+	multisig := sortedMulti(keys, "/0/*")
+	address := "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx"
+	label := "savings"
+
+	tests := []struct {
+		name    string
+		account Account
+		want    string
+	}{
+		{"label", Account{External: &external, Label: &label}, label},
+		{"extended key", Account{External: &external}, redact.Fingerprint(keys[0])},
+		{"first extended key", Account{External: &multisig}, redact.Fingerprint(keys[0])},
+		{"address", Account{Address: &address}, redact.Fingerprint(address)},
+	}
+
+	for _, test := range tests {
+		if got := test.account.Name(); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// RateLimit models the configuration of a token-bucket rate limiter, applied
+// independently to each client IP.
+type RateLimit struct {
+	RPS   float64 `json:"rps"`   // Sustained number of requests per second
+	Burst int     `json:"burst"` // (?) Maximum number of requests at once; defaults to rps
+}
+
+// validate checks that the rate limit allows at least one request.
+func (r RateLimit) validate(name string) error {
+	if r.RPS <= 0 {
+		return fmt.Errorf("%s: rps must be positive", name)
+	}
+
+	if r.Burst < 0 {
+		return fmt.Errorf("%s: burst must not be negative", name)
+	}
+
+	return nil
+}
+
+// TrustedProxyNets returns the networks of the trusted_proxies, whose
+// X-Forwarded-For header is honored to identify clients. Single IP addresses
+// are converted to networks of a single host.
+func (c Configuration) TrustedProxyNets() ([]*net.IPNet, error) {
+	ret := make([]*net.IPNet, 0, len(c.TrustedProxies))
+
+	for _, proxy := range c.TrustedProxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("trusted_proxies: invalid IP '%s'", proxy)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+
+			ret = append(ret, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("trusted_proxies: %w", err)
+		}
+
+		ret = append(ret, network)
+	}
+
+	return ret, nil
+}
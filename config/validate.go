@@ -2,44 +2,361 @@ package config
 
 import (
 	"fmt"
+	"net/url"
+	"strings"
+	"time"
 
+	"github.com/ledgerhq/satstack/redact"
+	"github.com/ledgerhq/satstack/utils"
+
+	"github.com/btcsuite/btcd/chaincfg"
 	log "github.com/sirupsen/logrus"
 )
 
-// validate checks for the validity of the JSON configuration loaded in
+// Validate checks for the validity of the JSON configuration loaded in
 // Configuration struct.
 //
 // It does not mutate the configuration values, and returns an error in case of
-// invalid configuration.
-func (c Configuration) validate() error {
+// invalid configuration. All the problems found are reported at once, as
+// Problems wrapped by ErrValidation.
+//
+// The chain of the extended keys of the accounts is not checked, since it is
+// only known once connected to bitcoind; see ValidateChain.
+func (c Configuration) Validate() error {
+	problems := c.problems()
+	problems = append(problems, c.chainProblems()...)
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%s: %w", ErrValidation, problems)
+	}
+
+	return nil
+}
+
+// problems returns the problems found in the fields of the configuration,
+// except the chains.
+func (c Configuration) problems() Problems {
+	var problems Problems
+
 	if err := validateStringField("rpcurl", c.RPCURL); err != nil {
-		return err
+		problems = append(problems, err)
+	} else {
+		if c.NoTLS && (c.TLS || strings.HasPrefix(strings.ToLower(*c.RPCURL), "https://")) {
+			problems = append(problems, fmt.Errorf("notls: conflicts with tls or https:// rpcurl"))
+		}
+
+		if c.TLSCACert != nil && !c.UseTLS() {
+			problems = append(problems, fmt.Errorf("tls_ca_cert: TLS is disabled"))
+		}
+	}
+
+	if c.Proxy != nil {
+		proxyURL, err := url.Parse(c.RPCProxy())
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Errorf("proxy: %w", err))
+		case proxyURL.Scheme != "socks5" || proxyURL.Host == "":
+			problems = append(problems,
+				fmt.Errorf("proxy: expected socks5://host:port, got '%s'", *c.Proxy))
+		}
+	}
+
+	if c.WalletName != nil && strings.TrimSpace(*c.WalletName) == "" {
+		problems = append(problems, fmt.Errorf("wallet_name: must not be empty"))
+	}
+
+	if _, err := c.Listeners(); err != nil {
+		problems = append(problems, err)
+	}
+
+	if _, err := c.SocketFileMode(); err != nil {
+		problems = append(problems, err)
+	}
+
+	if c.ReconnectInterval != nil && *c.ReconnectInterval <= 0 {
+		problems = append(problems, fmt.Errorf("reconnect_interval: must be positive"))
+	}
+
+	if c.ReconnectMaxInterval != nil && *c.ReconnectMaxInterval <= 0 {
+		problems = append(problems, fmt.Errorf("reconnect_max_interval: must be positive"))
+	}
+
+	if c.ScanStallTimeout != nil && *c.ScanStallTimeout <= 0 {
+		problems = append(problems, fmt.Errorf("scan_stall_timeout: must be positive"))
+	}
+
+	if c.PollingInterval != nil && *c.PollingInterval <= 0 {
+		problems = append(problems, fmt.Errorf("polling_interval: must be positive"))
+	}
+
+	if c.WarmupBlocks != nil && *c.WarmupBlocks < 0 {
+		problems = append(problems, fmt.Errorf("warmup_blocks: must not be negative"))
+	}
+
+	if c.CacheSize != nil && *c.CacheSize <= 0 {
+		problems = append(problems, fmt.Errorf("cache_size: must be positive"))
 	}
 
-	if err := validateStringField("rpcuser", c.RPCUser); err != nil {
-		return err
+	if c.CacheTTL != nil && *c.CacheTTL < 0 {
+		problems = append(problems, fmt.Errorf("cache_ttl: must not be negative"))
 	}
 
-	if err := validateStringField("rpcpass", c.RPCPassword); err != nil {
-		return err
+	if c.RPCTimeout != nil && *c.RPCTimeout < 0 {
+		problems = append(problems, fmt.Errorf("rpc_timeout: must not be negative"))
 	}
 
-	for _, account := range c.Accounts {
-		if err := validateStringField("external", account.External); err != nil {
-			return err
+	if c.RPCSlowTimeout != nil && *c.RPCSlowTimeout < 0 {
+		problems = append(problems, fmt.Errorf("rpc_slow_timeout: must not be negative"))
+	}
+
+	if c.RPCHTTPTimeout != nil && *c.RPCHTTPTimeout < 0 {
+		problems = append(problems, fmt.Errorf("rpc_http_timeout: must not be negative"))
+	}
+
+	if c.MempoolCacheTTL != nil && *c.MempoolCacheTTL < 0 {
+		problems = append(problems, fmt.Errorf("mempool_cache_ttl: must not be negative"))
+	}
+
+	if c.DiskCache != nil && strings.TrimSpace(*c.DiskCache) == "" {
+		problems = append(problems, fmt.Errorf("disk_cache: must not be empty"))
+	}
+
+	if c.DiskCacheSize != nil && *c.DiskCacheSize <= 0 {
+		problems = append(problems, fmt.Errorf("disk_cache_size: must be positive"))
+	}
+
+	if c.Datadir != nil && strings.TrimSpace(*c.Datadir) == "" {
+		problems = append(problems, fmt.Errorf("datadir: must not be empty"))
+	}
+
+	if c.LowDiskThreshold != nil && *c.LowDiskThreshold <= 0 {
+		problems = append(problems, fmt.Errorf("low_disk_threshold: must be positive"))
+	}
+
+	if c.RateLimitExplorer != nil {
+		if err := c.RateLimitExplorer.validate("rate_limit_explorer"); err != nil {
+			problems = append(problems, err)
+		}
+	}
+
+	if c.RateLimitStatus != nil {
+		if err := c.RateLimitStatus.validate("rate_limit_status"); err != nil {
+			problems = append(problems, err)
+		}
+	}
+
+	if _, err := c.TrustedProxyNets(); err != nil {
+		problems = append(problems, err)
+	}
+
+	if c.LogFormat != nil && !utils.Contains(LogFormats, *c.LogFormat) {
+		problems = append(problems, fmt.Errorf("log_format: expected one of %v, got '%s'", LogFormats, *c.LogFormat))
+	}
+
+	if c.LogLevel != nil && !utils.Contains(LogLevels, *c.LogLevel) {
+		problems = append(problems, fmt.Errorf("log_level: expected one of %v, got '%s'", LogLevels, *c.LogLevel))
+	}
+
+	if c.Auth != nil {
+		if err := c.Auth.validate(); err != nil {
+			problems = append(problems, err)
 		}
-		if err := validateStringField("internal", account.Internal); err != nil {
-			return err
+	}
+
+	if c.ControlAPI && c.Auth == nil {
+		problems = append(problems, fmt.Errorf("control_api: requires auth"))
+	}
+
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			continue
 		}
 
-		if account.Birthday != nil && account.Birthday.Before(BIP0039Genesis) {
+		originURL, err := url.Parse(origin)
+		if err != nil || originURL.Scheme == "" || originURL.Host == "" {
+			problems = append(problems,
+				fmt.Errorf("allowed_origins: expected scheme://host[:port] or *, got '%s'", origin))
+		}
+	}
+
+	// Static credentials are optional, in which case the cookie file is
+	// used. However, setting only one of them is most likely a mistake.
+	if !c.UseCookie() {
+		if err := validateStringField("rpcuser", c.RPCUser); err != nil {
+			problems = append(problems, err)
+		}
+
+		if err := validateStringField("rpcpass", c.RPCPassword); err != nil {
+			problems = append(problems, err)
+		}
+	}
+
+	for _, target := range c.FeeTargets {
+		if err := ValidateFeeTarget(target); err != nil {
+			problems = append(problems, fmt.Errorf("fee_targets: %w", err))
+		}
+	}
+
+	if c.FeeMode != nil && !utils.Contains(FeeModes, strings.ToUpper(*c.FeeMode)) {
+		problems = append(problems, fmt.Errorf("fee_mode: invalid mode '%s'", *c.FeeMode))
+	}
+
+	labels := make(map[string]int) // index of the account with each name
+	for idx, account := range c.Accounts {
+		problems = append(problems, account.problems(idx)...)
+
+		if account.External == nil && account.Address == nil {
+			continue // reported as missing
+		}
+
+		name := account.Name()
+		if prev, ok := labels[name]; ok {
+			problems = append(problems,
+				fmt.Errorf("accounts[%d].label: '%s' is already the label of accounts[%d]", idx, name, prev))
+			continue
+		}
+
+		labels[name] = idx
+	}
+
+	return problems
+}
+
+// ValidateChain checks that the extended keys and the addresses of the
+// accounts match the chain described by params, which is the one of bitcoind. The error is
+// consistent with Validate.
+func (c Configuration) ValidateChain(params *chaincfg.Params) error {
+	var problems Problems
+
+	for idx, account := range c.Accounts {
+		if account.Address != nil && !isAddressForNet(*account.Address, params) {
+			problems = append(problems,
+				fmt.Errorf("accounts[%d].address: '%s' does not match the chain of bitcoind (%s)",
+					idx, *account.Address, params.Name))
+		}
+
+		for _, desc := range account.descriptors() {
+			for _, err := range descriptorChainProblems(desc.value, params) {
+				problems = append(problems, fmt.Errorf("accounts[%d].%s: %w", idx, desc.name, err))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%s: %w", ErrValidation, problems)
+	}
+
+	return nil
+}
+
+// problems returns the problems found in the account at the given index of
+// the config.
+func (a Account) problems(idx int) []error {
+	var problems []error
+
+	switch {
+	case a.Address != nil && (a.External != nil || a.Internal != nil):
+		return append(problems,
+			fmt.Errorf("accounts[%d].address: conflicts with external and internal", idx))
+	case a.Address != nil:
+		problems = append(problems, a.addressProblems(idx)...)
+	case a.External == nil:
+		return append(problems, fmt.Errorf("accounts[%d]: %s: external or address", idx, ErrMissingKey))
+	case a.Multipath() && a.Internal != nil:
+		problems = append(problems,
+			fmt.Errorf("accounts[%d].internal: must be omitted for multipath descriptor", idx))
+	case a.Internal == nil && !RangedDescriptor(*a.External):
+		// A descriptor with a single address has no change chain.
+	case !a.Multipath():
+		if err := validateStringField("internal", a.Internal); err != nil {
+			problems = append(problems, fmt.Errorf("accounts[%d]: %w", idx, err))
+		}
+	}
+
+	if a.Label != nil && strings.TrimSpace(*a.Label) == "" {
+		problems = append(problems, fmt.Errorf("accounts[%d].label: must not be empty", idx))
+	}
+
+	if a.External != nil && !a.Ranged() && (a.Depth != nil || a.AutoExtend) {
+		problems = append(problems,
+			fmt.Errorf("accounts[%d]: depth and auto_extend do not apply to descriptors without wildcard", idx))
+	}
+
+	for _, desc := range a.descriptors() {
+		for _, err := range descriptorProblems(desc.value) {
+			problems = append(problems, fmt.Errorf("accounts[%d].%s: %w", idx, desc.name, err))
+		}
+	}
+
+	if a.Depth != nil && (*a.Depth <= 0 || *a.Depth > MaxAccountDepth) {
+		problems = append(problems,
+			fmt.Errorf("accounts[%d].depth: must be between 1 and %d", idx, MaxAccountDepth))
+	}
+
+	if a.Birthday != nil {
+		switch {
+		case a.Birthday.After(time.Now()):
+			problems = append(problems, fmt.Errorf("accounts[%d].birthday: %s is in the future",
+				idx, a.Birthday.Format("2006/01/02")))
+		case a.Birthday.Before(BIP0039Genesis):
 			log.WithFields(log.Fields{
-				"descriptor": account.External,
-				"birthday":   account.Birthday,
+				"descriptor": redact.Descriptor(a.ID()),
+				"birthday":   a.Birthday,
 			}).Warn("Account birthday older than 2016/06/01")
 		}
 	}
 
+	return problems
+}
+
+// addressProblems returns the problems found in an account watching a
+// single address.
+func (a Account) addressProblems(idx int) []error {
+	var problems []error
+
+	if !isValidAddress(*a.Address) {
+		problems = append(problems,
+			fmt.Errorf("accounts[%d].address: invalid address '%s'", idx, *a.Address))
+	}
+
+	if a.Depth != nil || a.AutoExtend {
+		problems = append(problems,
+			fmt.Errorf("accounts[%d]: depth and auto_extend do not apply to a single address", idx))
+	}
+
+	return problems
+}
+
+// namedDescriptor is a descriptor of an account, along with the name of the
+// config field it was read from.
+type namedDescriptor struct {
+	name  string
+	value string
+}
+
+// descriptors returns the descriptors set in the account.
+func (a Account) descriptors() []namedDescriptor {
+	var ret []namedDescriptor
+
+	if a.External != nil {
+		ret = append(ret, namedDescriptor{name: "external", value: *a.External})
+	}
+
+	if a.Internal != nil {
+		ret = append(ret, namedDescriptor{name: "internal", value: *a.Internal})
+	}
+
+	return ret
+}
+
+// ValidateFeeTarget returns an error if the confirmation target is out of
+// the range supported by bitcoind.
+func ValidateFeeTarget(target int64) error {
+	if target < MinFeeTarget || target > MaxFeeTarget {
+		return fmt.Errorf("target %d out of range [%d, %d]",
+			target, MinFeeTarget, MaxFeeTarget)
+	}
+
 	return nil
 }
 
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+// labelProblems returns the problems of the labels of the accounts.
+func labelProblems(accounts []Account) []string {
+	var ret []string
+	for _, problem := range (Configuration{Accounts: accounts}).problems() {
+		if strings.Contains(problem.Error(), ".label") {
+			ret = append(ret, problem.Error())
+		}
+	}
+
+	return ret
+}
+
+func TestValidateLabels(t *testing.T) {
+	keys := testnetKeys(t, 2)
+
+	first := "wpkh(" + keys[0] + "/0/*)"
+	firstChange := "wpkh(" + keys[0] + "/1/*)"
+	second := "wpkh(" + keys[1] + "/0/*)"
+	secondChange := "wpkh(" + keys[1] + "/1/*)"
+	taproot := "tr(" + keys[0] + "/0/*)"
+	taprootChange := "tr(" + keys[0] + "/1/*)"
+
+	label := func(v string) *string { return &v }
+
+	tests := []struct {
+		name     string
+		accounts []Account
+		problems []string // expected in the problems, in order
+	}{
+		{
+			"unique labels",
+			[]Account{
+				{External: &first, Internal: &firstChange, Label: label("savings")},
+				{External: &second, Internal: &secondChange, Label: label("spending")},
+			},
+			nil,
+		},
+		{
+			"fingerprints",
+			[]Account{
+				{External: &first, Internal: &firstChange},
+				{External: &second, Internal: &secondChange},
+			},
+			nil,
+		},
+		{
+			"empty label",
+			[]Account{{External: &first, Internal: &firstChange, Label: label(" ")}},
+			[]string{"accounts[0].label: must not be empty"},
+		},
+		{
+			"duplicate label",
+			[]Account{
+				{External: &first, Internal: &firstChange, Label: label("savings")},
+				{External: &second, Internal: &secondChange, Label: label("savings")},
+			},
+			[]string{"accounts[1].label: 'savings' is already the label of accounts[0]"},
+		},
+		{
+			// Accounts of the same key have the same fingerprint.
+			"duplicate fingerprint",
+			[]Account{
+				{External: &first, Internal: &firstChange},
+				{External: &taproot, Internal: &taprootChange},
+			},
+			[]string{"accounts[1].label:"},
+		},
+		{
+			"label of a fingerprint",
+			[]Account{
+				{External: &first, Internal: &firstChange},
+				{External: &second, Internal: &secondChange, Label: label((Account{External: &first}).Name())},
+			},
+			[]string{"accounts[1].label:"},
+		},
+	}
+
+	for _, test := range tests {
+		got := labelProblems(test.accounts)
+		if len(got) != len(test.problems) {
+			t.Errorf("%s: got problems %v, want %v", test.name, got, test.problems)
+			continue
+		}
+
+		for idx, problem := range test.problems {
+			if !strings.Contains(got[idx], problem) {
+				t.Errorf("%s: got problem %q, want %q", test.name, got[idx], problem)
+			}
+		}
+	}
+}
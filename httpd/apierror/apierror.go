@@ -0,0 +1,259 @@
+// Package apierror defines the error responses of the HTTP API of SatStack.
+//
+// Every error response is a JSON object with a machine-readable code, and a
+// human-readable message in the error field, for ex:
+//
+//	{"code": "tx-not-found", "error": "transaction not found: 4a5e1e..."}
+//
+// Some codes carry additional fields, like the reject reason of tx-rejected.
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ledgerhq/satstack/bus"
+	"github.com/ledgerhq/satstack/config"
+	"github.com/ledgerhq/satstack/httpd/svc"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/gin-gonic/gin"
+)
+
+// Code is the machine-readable category of an error response.
+type Code string
+
+const (
+	InvalidRequest    Code = "invalid-request"     // malformed parameters or body
+	InvalidAddress    Code = "invalid-address"     // address rejected by bitcoind
+	Unauthorized      Code = "unauthorized"        // missing or wrong credentials
+	NotFound          Code = "not-found"           // unknown route or resource
+	TxNotFound        Code = "tx-not-found"        // unknown transaction
+	BlockNotFound     Code = "block-not-found"     // unknown block
+	ScanInProgress    Code = "scan-in-progress"    // descriptors are being imported
+	NoScanInProgress  Code = "no-scan-in-progress" // no rescan to abort
+	InsufficientFunds Code = "insufficient-funds"  // regtest faucet cannot fund the request
+	TxIndexRequired   Code = "txindex-required"    // lookup impossible without transaction index
+	TxRejected        Code = "tx-rejected"         // transaction rejected by the mempool
+	RateLimited       Code = "rate-limited"        // too many requests
+	Internal          Code = "internal-error"      // unexpected failure of SatStack
+	RPCError          Code = "rpc-error"           // unexpected failure of a bitcoind RPC
+	NodeUnavailable   Code = "node-unavailable"    // bitcoind unreachable, or warming up
+	NodeTimeout       Code = "node-timeout"        // bitcoind did not answer in time
+	NotReady          Code = "not-ready"           // bitcoind is syncing, or the wallet scanning
+	AccountExists     Code = "account-exists"      // equivalent account already configured
+	ValidationFailed  Code = "validation-failed"   // account rejected by the config validation
+	NoAccounts        Code = "no-accounts"         // wallet endpoint, while no accounts are configured
+)
+
+// statuses maps each Code to the HTTP status of its responses.
+var statuses = map[Code]int{
+	InvalidRequest:    http.StatusBadRequest,
+	InvalidAddress:    http.StatusBadRequest,
+	Unauthorized:      http.StatusUnauthorized,
+	NotFound:          http.StatusNotFound,
+	TxNotFound:        http.StatusNotFound,
+	BlockNotFound:     http.StatusNotFound,
+	ScanInProgress:    http.StatusConflict,
+	NoScanInProgress:  http.StatusConflict,
+	InsufficientFunds: http.StatusBadRequest,
+	TxIndexRequired:   http.StatusUnprocessableEntity,
+	TxRejected:        http.StatusBadRequest,
+	RateLimited:       http.StatusTooManyRequests,
+	Internal:          http.StatusInternalServerError,
+	RPCError:          http.StatusBadGateway,
+	NodeUnavailable:   http.StatusServiceUnavailable,
+	NodeTimeout:       http.StatusGatewayTimeout,
+	NotReady:          http.StatusServiceUnavailable,
+	AccountExists:     http.StatusConflict,
+	ValidationFailed:  http.StatusUnprocessableEntity,
+	NoAccounts:        http.StatusNotImplemented,
+}
+
+// Error is an error response of the HTTP API.
+type Error struct {
+	Code    Code
+	Message string
+
+	// Fields are added to the response, along with the code and message.
+	Fields gin.H
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Status returns the HTTP status of the response.
+func (e *Error) Status() int {
+	if status, ok := statuses[e.Code]; ok {
+		return status
+	}
+
+	return http.StatusInternalServerError
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (e *Error) MarshalJSON() ([]byte, error) {
+	body := make(gin.H, len(e.Fields)+2)
+	for key, value := range e.Fields {
+		body[key] = value
+	}
+
+	body["code"] = e.Code
+	body["error"] = e.Message
+
+	return json.Marshal(body)
+}
+
+// New returns an Error with the given code and message.
+func New(code Code, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// With returns a copy of the Error, with an additional field.
+func (e *Error) With(key string, value interface{}) *Error {
+	fields := make(gin.H, len(e.Fields)+1)
+	for k, v := range e.Fields {
+		fields[k] = v
+	}
+
+	fields[key] = value
+
+	ret := *e
+	ret.Fields = fields
+	return &ret
+}
+
+// From translates an error returned by the services into an Error, using
+// the errors of the Bus, and the categories of the bitcoind RPC errors.
+//
+// The code of errors reporting an unknown resource depends on the resource
+// that was requested, and is given by notFound, for ex TxNotFound.
+//
+// Errors of bitcoind RPCs are reported with the message of bitcoind only,
+// rather than the whole chain of wrapped errors.
+func From(err error, notFound Code) *Error {
+	var apiErr *Error
+	var rejectErr *bus.RejectError
+	var mempoolErr *bus.NotInMempoolError
+	var problems config.Problems
+
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr
+	case errors.As(err, &rejectErr):
+		return &Error{
+			Code:    TxRejected,
+			Message: rejectErr.Error(),
+			Fields: gin.H{
+				"txid":          rejectErr.TxID,
+				"reject_reason": rejectErr.Reason,
+				"fee":           rejectErr.Fee,
+				"fee_rate":      rejectErr.FeeRate,
+			},
+		}
+	case errors.As(err, &mempoolErr):
+		status := "unknown"
+		if mempoolErr.Confirmed {
+			status = "confirmed"
+		}
+
+		return New(TxNotFound, "%s", err).
+			With("txid", mempoolErr.TxID).
+			With("status", status).
+			With("evicted", mempoolErr.Evicted)
+	case errors.Is(err, bus.ErrTransactionNotFound):
+		return New(TxNotFound, "%s", err)
+	case errors.Is(err, bus.ErrBlockNotFound):
+		return New(BlockNotFound, "%s", err)
+	case errors.Is(err, bus.ErrAccountNotFound):
+		return New(NotFound, "%s", err)
+	case errors.Is(err, bus.ErrNoAccounts):
+		return New(NoAccounts, "%s", err)
+	case errors.Is(err, bus.ErrAccountExists):
+		return New(AccountExists, "%s", err)
+	case errors.As(err, &problems):
+		// Problems are only returned by the validation, wrapped by
+		// config.ErrValidation.
+		return New(ValidationFailed, "%s", err).With("problems", problemFields(problems))
+	case errors.Is(err, bus.ErrTxIndexRequired):
+		return New(TxIndexRequired, "%s", err)
+	case errors.Is(err, bus.ErrInvalidLookupAddress):
+		return New(InvalidAddress, "%s", err)
+	case errors.Is(err, bus.ErrScanInProgress):
+		return New(ScanInProgress, "%s", err)
+	case errors.Is(err, bus.ErrNoScanInProgress):
+		return New(NoScanInProgress, "%s", err)
+	case errors.Is(err, bus.ErrInsufficientFunds):
+		return New(InsufficientFunds, "%s", err)
+	case errors.Is(err, bus.ErrInvalidRescan), errors.Is(err, bus.ErrInvalidRegtestRequest),
+		errors.Is(err, bus.ErrInvalidDescriptor), errors.Is(err, bus.ErrMalformedChainHash),
+		errors.Is(err, svc.ErrInvalidBlock):
+		return New(InvalidRequest, "%s", err)
+	}
+
+	message := err.Error()
+
+	var rpcErr *btcjson.RPCError
+	if errors.As(err, &rpcErr) {
+		message = "bitcoind: " + rpcErr.Message
+	}
+
+	switch bus.ClassifyError(err) {
+	case bus.ErrNotFound:
+		return New(notFound, "%s", message)
+	case bus.ErrInvalidParameter:
+		return New(InvalidRequest, "%s", message)
+	case bus.ErrTransactionRejected:
+		return New(TxRejected, "%s", message)
+	case bus.ErrBitcoindUnreachable:
+		return New(NodeUnavailable, "%s", bus.ErrBitcoindUnreachable)
+	case bus.ErrRPCAborted:
+		return New(NodeTimeout, "%s", bus.ErrRPCAborted)
+	case bus.ErrRPCFailed:
+		return New(RPCError, "%s", message)
+	default:
+		return New(Internal, "%s", message)
+	}
+}
+
+// problemFields returns the problems of an account validation, as objects
+// with the field of the problem, if any, and its message. The fields are
+// relative to the account, for ex external.
+func problemFields(problems config.Problems) []gin.H {
+	ret := make([]gin.H, len(problems))
+
+	for idx, problem := range problems {
+		message := problem.Error()
+
+		var field string
+		if parts := strings.SplitN(message, ": ", 2); len(parts) == 2 &&
+			strings.HasPrefix(parts[0], "accounts[0]") {
+			field = strings.TrimPrefix(strings.TrimPrefix(parts[0], "accounts[0]"), ".")
+			message = parts[1]
+		}
+
+		ret[idx] = gin.H{"field": field, "error": message}
+	}
+
+	return ret
+}
+
+// Unready returns an Error for a request rejected while SatStack is not
+// ready, with the Status and its detail, if any.
+func Unready(code Code, status bus.Status, detail string) *Error {
+	ret := New(code, "not ready: %s", status).With("status", status)
+	if detail != "" {
+		ret = ret.With("status_detail", detail)
+	}
+
+	return ret
+}
+
+// Abort writes the error response, and stops the chain of handlers.
+func Abort(ctx *gin.Context, err *Error) {
+	ctx.AbortWithStatusJSON(err.Status(), err)
+}
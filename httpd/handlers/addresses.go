@@ -3,8 +3,10 @@ package handlers
 import (
 	"net/http"
 	"sort"
+	"strconv"
 	"strings"
 
+	"github.com/ledgerhq/satstack/httpd/apierror"
 	"github.com/ledgerhq/satstack/httpd/svc"
 	"github.com/ledgerhq/satstack/utils"
 
@@ -15,6 +17,7 @@ func GetAddresses(s svc.AddressesService) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		param := ctx.Param("addresses")
 		blockHashQuery := ctx.Query("block_hash")
+		batchSizeQuery := ctx.Query("batch_size")
 
 		addressList := strings.Split(param, ",")
 
@@ -23,9 +26,56 @@ func GetAddresses(s svc.AddressesService) gin.HandlerFunc {
 			blockHash = &blockHashQuery
 		}
 
-		addresses, err := s.GetAddresses(addressList, blockHash)
+		// A zero batch size disables pagination.
+		var batchSize int
+		if batchSizeQuery != "" {
+			var err error
+			batchSize, err = strconv.Atoi(batchSizeQuery)
+			if err != nil || batchSize <= 0 {
+				apierror.Abort(ctx, apierror.New(apierror.InvalidRequest,
+					"invalid batch_size '%s'", batchSizeQuery))
+				return
+			}
+		}
+
+		// Addresses that do not belong to the account are ignored.
+		if account := ctx.Query("account"); account != "" {
+			var err error
+			addressList, err = s.FilterAccountAddresses(account, addressList)
+			if err != nil {
+				apierror.Abort(ctx, apierror.From(err, apierror.NotFound))
+				return
+			}
+		}
+
+		switch format := ctx.Query("format"); format {
+		case "", "json":
+		case "csv":
+			page, err := s.GetAddressTransactions(ctx.Request.Context(), addressList, blockHash, batchSize)
+			if err != nil {
+				apierror.Abort(ctx, apierror.From(err, apierror.NotFound))
+				return
+			}
+
+			// Exports are in block order, like paginated results.
+			writeTransactionsCSV(ctx, page, addressList)
+			return
+		default:
+			apierror.Abort(ctx, apierror.New(apierror.InvalidRequest,
+				"invalid format '%s', expected json or csv", format))
+			return
+		}
+
+		addresses, err := s.GetAddresses(ctx.Request.Context(), addressList, blockHash, batchSize)
 		if err != nil {
-			ctx.JSON(http.StatusNotFound, err)
+			apierror.Abort(ctx, apierror.From(err, apierror.NotFound))
+			return
+		}
+
+		// Paginated transactions are already in block order, which must be
+		// preserved for the cursor to be meaningful.
+		if batchSize > 0 {
+			ctx.JSON(http.StatusOK, addresses)
 			return
 		}
 
@@ -36,7 +86,10 @@ func GetAddresses(s svc.AddressesService) gin.HandlerFunc {
 		//
 		//        The bug seems to manifest itself only on accounts with a
 		//        large number of operations.
-		sort.Slice(addresses.Transactions[:], func(i, j int) bool {
+		//
+		// The sort is stable, so that transactions received at the same
+		// time keep the order of the service.
+		sort.SliceStable(addresses.Transactions[:], func(i, j int) bool {
 			iReceivedAt, iErr := utils.ParseRFC3339Timestamp(addresses.Transactions[i].ReceivedAt)
 			jReceivedAt, jErr := utils.ParseRFC3339Timestamp(addresses.Transactions[j].ReceivedAt)
 
@@ -51,3 +104,35 @@ func GetAddresses(s svc.AddressesService) gin.HandlerFunc {
 		ctx.JSON(http.StatusOK, addresses)
 	}
 }
+
+// GetUTXOs is a gin handler (factory) to get the unspent outputs of a
+// comma-separated list of addresses, including unconfirmed ones.
+func GetUTXOs(s svc.AddressesService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		addressList := strings.Split(ctx.Param("addresses"), ",")
+
+		utxos, err := s.GetUTXOs(ctx.Request.Context(), addressList)
+		if err != nil {
+			apierror.Abort(ctx, apierror.From(err, apierror.NotFound))
+			return
+		}
+
+		ctx.JSON(http.StatusOK, utxos)
+	}
+}
+
+// GetBalances is a gin handler (factory) to get the balances of a
+// comma-separated list of addresses, and their aggregate, in satoshis.
+func GetBalances(s svc.AddressesService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		addressList := strings.Split(ctx.Param("addresses"), ",")
+
+		balances, err := s.GetBalances(ctx.Request.Context(), addressList)
+		if err != nil {
+			apierror.Abort(ctx, apierror.From(err, apierror.NotFound))
+			return
+		}
+
+		ctx.JSON(http.StatusOK, balances)
+	}
+}
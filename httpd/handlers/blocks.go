@@ -1,12 +1,18 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
 	"net/http"
+	"strconv"
 
+	"github.com/ledgerhq/satstack/httpd/apierror"
 	"github.com/ledgerhq/satstack/httpd/svc"
 	"github.com/ledgerhq/satstack/types"
+	"github.com/ledgerhq/satstack/utils"
 
 	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
 )
 
 // GetBlock gets the current block, or a block by height or hash.
@@ -17,13 +23,32 @@ import (
 //
 // Except for the case where the block reference is "current", the response is
 // a list of 1 element.
+//
+// With the txs=false query parameter, the list of transaction IDs is omitted,
+// and only the header of the block is fetched.
 func GetBlock(s svc.BlocksService) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		blockRef := ctx.Param("block")
 
-		block, err := s.GetBlock(blockRef)
+		var block *types.Block
+		var err error
+
+		switch ctx.Query("txs") {
+		case "false":
+			var header *types.BlockHeader
+			if header, err = s.GetBlockHeader(ctx.Request.Context(), blockRef); err == nil {
+				block = &types.Block{
+					Hash:   header.Hash,
+					Height: header.Height,
+					Time:   header.Time,
+				}
+			}
+		default:
+			block, err = s.GetBlock(ctx.Request.Context(), blockRef)
+		}
+
 		if err != nil {
-			ctx.JSON(http.StatusNotFound, err)
+			apierror.Abort(ctx, apierror.From(err, apierror.BlockNotFound))
 			return
 		}
 
@@ -35,3 +60,148 @@ func GetBlock(s svc.BlocksService) gin.HandlerFunc {
 		}
 	}
 }
+
+// GetBlockHeader gets the header of a block, referenced by height or hash,
+// without fetching its transactions. The "current" reference is also
+// supported.
+func GetBlockHeader(s svc.BlocksService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		header, err := s.GetBlockHeader(ctx.Request.Context(), ctx.Param("block"))
+		if err != nil {
+			apierror.Abort(ctx, apierror.From(err, apierror.BlockNotFound))
+			return
+		}
+
+		ctx.JSON(http.StatusOK, header)
+	}
+}
+
+// GetBlockStats gets the fee statistics of a block, referenced by height or
+// hash. The "current" reference is also supported.
+func GetBlockStats(s svc.BlocksService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		stats, err := s.GetBlockStats(ctx.Request.Context(), ctx.Param("block"))
+		if err != nil {
+			apierror.Abort(ctx, apierror.From(err, apierror.BlockNotFound))
+			return
+		}
+
+		ctx.JSON(http.StatusOK, stats)
+	}
+}
+
+// GetBlockTransactions gets the transactions of a block, referenced by height
+// or hash, in the order in which they appear in the block. The "current"
+// reference is also supported.
+//
+// The optional offset and limit query parameters select a range of the
+// transactions, and the total number of transactions of the block is returned
+// in the X-Total-Count header.
+func GetBlockTransactions(s svc.BlocksService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		offset, ok := queryCount(ctx, "offset")
+		if !ok {
+			return
+		}
+
+		limit, ok := queryCount(ctx, "limit")
+		if !ok {
+			return
+		}
+
+		page, err := s.GetBlockTransactions(ctx.Request.Context(), ctx.Param("block"), offset, limit)
+		if err != nil {
+			apierror.Abort(ctx, apierror.From(err, apierror.BlockNotFound))
+			return
+		}
+
+		ctx.Header("X-Total-Count", strconv.Itoa(page.Total))
+		writeTransactionsJSON(ctx, page)
+	}
+}
+
+// queryCount parses the query parameter as a non-negative integer, which
+// defaults to 0. An invalid value is reported, and false is returned.
+func queryCount(ctx *gin.Context, name string) (int, bool) {
+	param := ctx.Query(name)
+	if param == "" {
+		return 0, true
+	}
+
+	count, err := strconv.Atoi(param)
+	if err != nil || count < 0 {
+		apierror.Abort(ctx, apierror.New(apierror.InvalidRequest, "invalid %s '%s'", name, param))
+		return 0, false
+	}
+
+	return count, true
+}
+
+// transactionIterator builds transactions one at a time, like
+// svc.BlockTransactions.
+type transactionIterator interface {
+	Each(ctx context.Context, fn func(tx *types.Transaction) error) error
+}
+
+// writeTransactionsJSON streams the transactions of the page as a JSON list,
+// encoding them one at a time, as they are built.
+//
+// Since the status is sent with the first transaction, a failure in the
+// middle of the list can only be reported by truncating it.
+func writeTransactionsJSON(ctx *gin.Context, page transactionIterator) {
+	ctx.Header("Content-Type", "application/json; charset=utf-8")
+	ctx.Status(http.StatusOK)
+
+	encoder := json.NewEncoder(ctx.Writer)
+
+	var count int
+	_, err := ctx.Writer.WriteString("[")
+	if err == nil {
+		err = page.Each(ctx.Request.Context(), func(tx *types.Transaction) error {
+			if count > 0 {
+				if _, err := ctx.Writer.WriteString(","); err != nil {
+					return err
+				}
+			}
+
+			count++
+			return encoder.Encode(tx)
+		})
+	}
+
+	if err == nil {
+		_, err = ctx.Writer.WriteString("]")
+	}
+
+	if err != nil {
+		utils.Logger(ctx.Request.Context()).WithFields(log.Fields{
+			"transactions": count,
+			"error":        err,
+		}).Error("Failed to stream block transactions")
+	}
+}
+
+// GetBlockAtTime gets the last block of the best chain with a median time
+// not after the given UNIX timestamp, in seconds. Timestamps before the
+// genesis block resolve to the genesis block, and timestamps in the future
+// resolve to the chain tip, with the future flag set.
+func GetBlockAtTime(s svc.BlocksService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		param := ctx.Param("timestamp")
+
+		timestamp, err := strconv.ParseInt(param, 10, 64)
+		if err != nil {
+			apierror.Abort(ctx, apierror.New(apierror.InvalidRequest,
+				"invalid timestamp '%s'", param))
+			return
+		}
+
+		block, err := s.GetBlockAtTime(ctx.Request.Context(), timestamp)
+		if err != nil {
+			apierror.Abort(ctx, apierror.From(err, apierror.BlockNotFound))
+			return
+		}
+
+		ctx.JSON(http.StatusOK, block)
+	}
+}
@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ledgerhq/satstack/bus"
+	"github.com/ledgerhq/satstack/httpd/apierror"
+	"github.com/ledgerhq/satstack/types"
+
+	"github.com/btcsuite/btcutil"
+	"github.com/gin-gonic/gin"
+)
+
+// fakeTransactions is a transactionIterator building count transactions,
+// and failing with err after the transaction at failAt, if err is not nil.
+type fakeTransactions struct {
+	count  int
+	err    error
+	failAt int
+
+	// built is called before each transaction is built.
+	built func(idx int)
+}
+
+func (f *fakeTransactions) Each(ctx context.Context, fn func(tx *types.Transaction) error) error {
+	for idx := 0; idx < f.count; idx++ {
+		if f.built != nil {
+			f.built(idx)
+		}
+
+		if err := fn(fakeTransaction(idx)); err != nil {
+			return err
+		}
+
+		if f.err != nil && idx == f.failAt {
+			return f.err
+		}
+	}
+
+	return nil
+}
+
+func fakeTransaction(idx int) *types.Transaction {
+	value, index := btcutil.Amount(1000), uint32(0)
+	return &types.Transaction{
+		ID:      fmt.Sprintf("%064x", idx),
+		Hash:    fmt.Sprintf("%064x", idx),
+		Outputs: []types.Output{{OutputIndex: &index, Value: &value, Address: "bcrt1qtest"}},
+		Block:   &types.Block{Hash: testTxHash, Height: 100},
+	}
+}
+
+// streamTransactions serves the transactions with writeTransactionsJSON, to
+// the given recorder.
+func streamTransactions(page transactionIterator, recorder http.ResponseWriter) {
+	gin.SetMode(gin.TestMode)
+
+	engine := gin.New()
+	engine.GET("/txs", func(ctx *gin.Context) { writeTransactionsJSON(ctx, page) })
+	engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/txs", nil))
+}
+
+func TestWriteTransactionsJSON(t *testing.T) {
+	const count = 3000
+
+	recorder := httptest.NewRecorder()
+
+	// Each transaction is written before the next one is built.
+	written := 0
+	page := &fakeTransactions{count: count}
+	page.built = func(idx int) {
+		if idx > 0 && recorder.Body.Len() <= written {
+			t.Fatalf("transaction %d built before transaction %d was written", idx, idx-1)
+		}
+
+		written = recorder.Body.Len()
+	}
+
+	streamTransactions(page, recorder)
+
+	if recorder.Code != http.StatusOK || !strings.HasPrefix(recorder.Header().Get("Content-Type"), "application/json") {
+		t.Errorf("got status %d, content type %q, want JSON", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+
+	var txs []types.Transaction
+	if err := json.Unmarshal(recorder.Body.Bytes(), &txs); err != nil {
+		t.Fatalf("got invalid JSON: %v", err)
+	}
+
+	if len(txs) != count || txs[count-1].ID != fakeTransaction(count-1).ID {
+		t.Errorf("got %d transactions, want %d in order", len(txs), count)
+	}
+
+	recorder = httptest.NewRecorder()
+	streamTransactions(&fakeTransactions{}, recorder)
+
+	if got := strings.TrimSpace(recorder.Body.String()); got != "[]" {
+		t.Errorf("got body %q for an empty block, want an empty list", got)
+	}
+}
+
+func TestWriteTransactionsJSONTruncated(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	streamTransactions(&fakeTransactions{count: 10, err: errors.New("node unreachable"), failAt: 4}, recorder)
+
+	// The status is already sent: the list is truncated, so that clients
+	// cannot mistake it for the whole block.
+	var txs []types.Transaction
+	if err := json.Unmarshal(recorder.Body.Bytes(), &txs); err == nil {
+		t.Errorf("got a valid list of %d transactions, want a truncated one", len(txs))
+	}
+
+	if !strings.Contains(recorder.Body.String(), fakeTransaction(4).ID) ||
+		strings.Contains(recorder.Body.String(), fakeTransaction(5).ID) {
+		t.Errorf("got body %q, want the transactions up to the failure", recorder.Body)
+	}
+}
+
+func TestGetBlockTransactionsQuery(t *testing.T) {
+	s := &fakeService{}
+	for _, target := range []string{
+		"/blocks/1/txs?offset=-1",
+		"/blocks/1/txs?offset=abc",
+		"/blocks/1/txs?limit=-5",
+		"/blocks/1/txs?limit=1.5",
+	} {
+		recorder := serve(GetBlockTransactions(s), http.MethodGet, "/blocks/:block/txs", target, "")
+		checkError(t, target, recorder, apierror.InvalidRequest)
+	}
+
+	if s.calls != 0 {
+		t.Errorf("got %d calls of the service for invalid requests, want none", s.calls)
+	}
+
+	for _, class := range failureClasses {
+		code := class.code
+		if code == "" {
+			code = apierror.BlockNotFound
+		}
+
+		recorder := serve(GetBlockTransactions(&fakeService{err: class.err}),
+			http.MethodGet, "/blocks/:block/txs", "/blocks/1/txs?offset=10&limit=10", "")
+		checkError(t, class.name, recorder, code)
+	}
+}
+
+func TestGetBlockStats(t *testing.T) {
+	recorder := serve(GetBlockStats(&fakeService{}), http.MethodGet, "/blocks/:block/stats", "/blocks/1/stats", "")
+
+	var stats types.BlockStats
+	if err := json.Unmarshal(recorder.Body.Bytes(), &stats); err != nil || recorder.Code != http.StatusOK {
+		t.Fatalf("got %d %q: %v", recorder.Code, recorder.Body, err)
+	}
+
+	if stats.TotalFees != 6500 || stats.Source != bus.BlockStatsSource {
+		t.Errorf("got stats %+v, want the stats of the service", stats)
+	}
+
+	// Pruned blocks are reported as not found.
+	recorder = serve(GetBlockStats(&fakeService{err: fmt.Errorf("%w: block 1 pruned by bitcoind", bus.ErrBlockNotFound)}),
+		http.MethodGet, "/blocks/:block/stats", "/blocks/1/stats", "")
+	checkError(t, "pruned block", recorder, apierror.BlockNotFound)
+
+	if !strings.Contains(recorder.Body.String(), "pruned") {
+		t.Errorf("got body %q, want the reason", recorder.Body)
+	}
+
+	for _, class := range failureClasses {
+		code := class.code
+		if code == "" {
+			code = apierror.BlockNotFound
+		}
+
+		recorder := serve(GetBlockStats(&fakeService{err: class.err}),
+			http.MethodGet, "/blocks/:block/stats", "/blocks/1/stats", "")
+		checkError(t, class.name, recorder, code)
+	}
+}
+
+// discardWriter is an http.ResponseWriter discarding the body.
+type discardWriter struct {
+	header http.Header
+}
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) Write(b []byte) (int, error) { return ioutil.Discard.Write(b) }
+func (w *discardWriter) WriteHeader(int)             {}
+
+// BenchmarkWriteTransactionsJSON streams blocks of several sizes: the memory
+// allocated per transaction is the same, since transactions are not held
+// once written.
+func BenchmarkWriteTransactionsJSON(b *testing.B) {
+	for _, count := range []int{500, 2000, 5000} {
+		b.Run(fmt.Sprintf("%d transactions", count), func(b *testing.B) {
+			page := &fakeTransactions{count: count}
+
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				streamTransactions(page, &discardWriter{header: make(http.Header)})
+			}
+		})
+	}
+}
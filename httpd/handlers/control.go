@@ -1,9 +1,13 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
 
+	"github.com/ledgerhq/satstack/bus"
 	"github.com/ledgerhq/satstack/config"
+	"github.com/ledgerhq/satstack/httpd/apierror"
 	"github.com/ledgerhq/satstack/httpd/svc"
 	log "github.com/sirupsen/logrus"
 
@@ -16,9 +20,9 @@ func ImportAccounts(s svc.ControlService) gin.HandlerFunc {
 			Accounts []config.Account `json:"accounts" binding:"required"`
 		}
 
-		if err := ctx.BindJSON(&request); err != nil {
+		if err := ctx.ShouldBindJSON(&request); err != nil {
 			log.Error("Failed to bind JSON request")
-			ctx.JSON(http.StatusBadRequest, err)
+			apierror.Abort(ctx, apierror.New(apierror.InvalidRequest, "%s", err))
 			return
 		}
 
@@ -28,22 +32,188 @@ func ImportAccounts(s svc.ControlService) gin.HandlerFunc {
 	}
 }
 
+// ReloadAccounts is a gin handler (factory) to reload the accounts from the
+// config file. A reload while descriptors are being imported is a conflict.
+func ReloadAccounts(s svc.ControlService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		err := s.ReloadAccounts()
+
+		// Other failures come from the config file.
+		switch {
+		case errors.Is(err, bus.ErrScanInProgress):
+			apierror.Abort(ctx, apierror.From(err, apierror.NotFound))
+		case err != nil:
+			log.WithField("error", err).Error("Failed to reload accounts")
+			apierror.Abort(ctx, apierror.New(apierror.InvalidRequest, "%s", err))
+		default:
+			ctx.JSON(http.StatusOK, gin.H{"Status": "OK"})
+		}
+	}
+}
+
+// AddAccount is a gin handler (factory) to add an account at runtime, with
+// the schema of the accounts of the config file. The descriptors are
+// imported in the background, and the progress is reported by the status
+// endpoint.
+func AddAccount(s svc.ControlService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var account config.Account
+
+		if err := ctx.ShouldBindJSON(&account); err != nil {
+			log.Error("Failed to bind JSON request")
+			apierror.Abort(ctx, apierror.New(apierror.InvalidRequest, "%s", err))
+			return
+		}
+
+		if err := s.AddAccount(account); err != nil {
+			log.WithField("error", err).Error("Failed to add account")
+			apierror.Abort(ctx, apierror.From(err, apierror.NotFound))
+			return
+		}
+
+		ctx.JSON(http.StatusAccepted, gin.H{"Status": "OK"})
+	}
+}
+
+// Rescan is a gin handler (factory) to rescan the blockchain for wallet
+// transactions, from an optional height or UNIX timestamp. The progress is
+// reported by the status endpoint.
+func Rescan(s svc.ControlService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var request struct {
+			Height    *int64 `json:"height"`
+			Timestamp *int64 `json:"timestamp"`
+		}
+
+		// The request body is optional.
+		if ctx.Request.ContentLength != 0 {
+			if err := ctx.ShouldBindJSON(&request); err != nil {
+				log.Error("Failed to bind JSON request")
+				apierror.Abort(ctx, apierror.New(apierror.InvalidRequest, "%s", err))
+				return
+			}
+		}
+
+		if request.Height != nil && request.Timestamp != nil {
+			apierror.Abort(ctx, apierror.New(apierror.InvalidRequest,
+				"height and timestamp are mutually exclusive"))
+			return
+		}
+
+		height, err := s.Rescan(request.Height, request.Timestamp)
+
+		switch {
+		case errors.Is(err, bus.ErrScanInProgress), errors.Is(err, bus.ErrInvalidRescan):
+			apierror.Abort(ctx, apierror.From(err, apierror.BlockNotFound))
+		case err != nil:
+			log.WithField("error", err).Error("Failed to start rescan")
+			apierror.Abort(ctx, apierror.From(err, apierror.BlockNotFound))
+		default:
+			ctx.JSON(http.StatusAccepted, gin.H{"start_height": height})
+		}
+	}
+}
+
+// StopRescan is a gin handler (factory) to abort the rescan in progress.
+func StopRescan(s svc.ControlService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		err := s.StopRescan()
+
+		switch {
+		case errors.Is(err, bus.ErrNoScanInProgress):
+			apierror.Abort(ctx, apierror.From(err, apierror.NotFound))
+		case err != nil:
+			log.WithField("error", err).Error("Failed to abort rescan")
+			apierror.Abort(ctx, apierror.From(err, apierror.NotFound))
+		default:
+			ctx.JSON(http.StatusOK, gin.H{"Status": "OK"})
+		}
+	}
+}
+
+// ListDescriptors is a gin handler (factory) to list the descriptors of the
+// configured accounts, and whether they were imported into the wallet.
+func ListDescriptors(s svc.ControlService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		accounts, err := s.ListDescriptors()
+		if err != nil {
+			log.WithField("error", err).Error("Failed to list descriptors")
+			apierror.Abort(ctx, apierror.From(err, apierror.NotFound))
+			return
+		}
+
+		ctx.JSON(http.StatusOK, gin.H{
+			"accounts": accounts,
+		})
+	}
+}
+
+// RPCStats is a gin handler (factory) to report the number of RPC calls made
+// to bitcoind, their errors and latencies, by method.
+func RPCStats(s svc.ControlService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, s.RPCStats())
+	}
+}
+
+// ResetRPCStats is a gin handler (factory) to reset the RPC stats.
+func ResetRPCStats(s svc.ControlService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		s.ResetRPCStats()
+		ctx.JSON(http.StatusOK, gin.H{"Status": "OK"})
+	}
+}
+
+// SelfTest is a gin handler (factory) to run the self-test, and report its
+// checks. The status is 200 if no check failed, 207 if some did, and 503 if
+// bitcoind is unreachable.
+//
+// The testmempoolaccept check only runs with the broadcast=true query
+// parameter.
+func SelfTest(s svc.ControlService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var broadcast bool
+		if query := ctx.Query("broadcast"); query != "" {
+			value, err := strconv.ParseBool(query)
+			if err != nil {
+				apierror.Abort(ctx, apierror.New(apierror.InvalidRequest,
+					"invalid broadcast '%s'", query))
+				return
+			}
+
+			broadcast = value
+		}
+
+		report := s.SelfTest(ctx.Request.Context(), broadcast)
+
+		switch report.Result {
+		case bus.SelfTestPassed:
+			ctx.JSON(http.StatusOK, report)
+		case bus.SelfTestPartial:
+			ctx.JSON(http.StatusMultiStatus, report)
+		default:
+			ctx.JSON(http.StatusServiceUnavailable, report)
+		}
+	}
+}
+
 func HasDescriptor(s svc.ControlService) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		var request struct {
 			Descriptor string `json:"descriptor" binding:"required"`
 		}
 
-		if err := ctx.BindJSON(&request); err != nil {
+		if err := ctx.ShouldBindJSON(&request); err != nil {
 			log.Error("Failed to bind JSON request")
-			ctx.JSON(http.StatusBadRequest, err)
+			apierror.Abort(ctx, apierror.New(apierror.InvalidRequest, "%s", err))
 			return
 		}
 
-		exists, err := s.HasDescriptor(request.Descriptor)
+		exists, err := s.HasDescriptor(ctx.Request.Context(), request.Descriptor)
 		if err != nil {
+			// bitcoind reports malformed descriptors as not found.
 			log.WithField("error", err).Error("Failed to handle descriptor")
-			ctx.JSON(http.StatusBadRequest, err)
+			apierror.Abort(ctx, apierror.From(err, apierror.InvalidRequest))
 			return
 		}
 
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/ledgerhq/satstack/bus"
+	"github.com/ledgerhq/satstack/httpd/apierror"
+)
+
+func TestRPCStats(t *testing.T) {
+	s := &fakeService{rpcStats: bus.RPCStats{
+		Since:   "2026-01-01T00:00:00Z",
+		Methods: []bus.RPCMethodStats{{Method: "getblock", Calls: 3, Errors: 1, Samples: 3, P50: 1.5}},
+	}}
+
+	recorder := serve(RPCStats(s), http.MethodGet, "/control/rpc-stats", "/control/rpc-stats", "")
+
+	var got bus.RPCStats
+	if err := json.Unmarshal(recorder.Body.Bytes(), &got); err != nil || recorder.Code != http.StatusOK {
+		t.Fatalf("got %d %q: %v", recorder.Code, recorder.Body, err)
+	}
+
+	if len(got.Methods) != 1 || got.Methods[0] != s.rpcStats.Methods[0] {
+		t.Errorf("got stats %+v, want %+v", got, s.rpcStats)
+	}
+
+	recorder = serve(ResetRPCStats(s), http.MethodDelete, "/control/rpc-stats", "/control/rpc-stats", "")
+	if recorder.Code != http.StatusOK || len(s.rpcStats.Methods) != 0 {
+		t.Errorf("got %d, stats %+v after a reset, want none", recorder.Code, s.rpcStats)
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	tests := []struct {
+		result bus.SelfTestOutcome
+		status int
+	}{
+		{bus.SelfTestPassed, http.StatusOK},
+		{bus.SelfTestPartial, http.StatusMultiStatus},
+		{bus.SelfTestUnreachable, http.StatusServiceUnavailable},
+	}
+
+	for _, test := range tests {
+		s := &fakeService{selfTest: bus.SelfTestReport{
+			Result: test.result,
+			Checks: []bus.SelfTestCheck{{Name: "rpc", Result: bus.SelfTestPass}},
+		}}
+
+		recorder := serve(SelfTest(s), http.MethodGet, "/control/selftest", "/control/selftest", "")
+
+		var got bus.SelfTestReport
+		if err := json.Unmarshal(recorder.Body.Bytes(), &got); err != nil || recorder.Code != test.status {
+			t.Errorf("%s: got %d %q: %v, want %d", test.result, recorder.Code, recorder.Body, err, test.status)
+			continue
+		}
+
+		if got.Result != test.result || len(got.Checks) != 1 || s.broadcast {
+			t.Errorf("%s: got report %+v, broadcast %v, want the report without broadcast", test.result, got, s.broadcast)
+		}
+	}
+
+	s := &fakeService{selfTest: bus.SelfTestReport{Result: bus.SelfTestPassed}}
+
+	recorder := serve(SelfTest(s), http.MethodGet, "/control/selftest", "/control/selftest?broadcast=true", "")
+	if recorder.Code != http.StatusOK || !s.broadcast {
+		t.Errorf("got %d, broadcast %v, want the broadcast checked", recorder.Code, s.broadcast)
+	}
+
+	s = &fakeService{}
+	checkError(t, "invalid broadcast",
+		serve(SelfTest(s), http.MethodGet, "/control/selftest", "/control/selftest?broadcast=maybe", ""),
+		apierror.InvalidRequest)
+
+	if s.calls != 0 {
+		t.Errorf("got %d calls with an invalid query, want none", s.calls)
+	}
+}
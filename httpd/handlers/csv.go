@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ledgerhq/satstack/httpd/svc"
+	"github.com/ledgerhq/satstack/types"
+	"github.com/ledgerhq/satstack/utils"
+
+	"github.com/btcsuite/btcutil"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// csvFlushRows is the number of CSV rows after which the response is
+// flushed to the client.
+const csvFlushRows = 100
+
+// csvHeader is the first row of the CSV export of transactions.
+var csvHeader = []string{
+	"timestamp", "txid", "direction", "amount_sat", "amount_btc", "fee_sat", "block_height", "address",
+}
+
+// writeTransactionsCSV streams the transactions of the page as CSV rows, as
+// they are built. The cursor of the next page, if any, is returned in the
+// X-Next-Token header.
+//
+// Since the status is sent with the first row, a failure in the middle of
+// the export can only be reported by truncating it.
+func writeTransactionsCSV(ctx *gin.Context, page *svc.AddressTransactions, addresses []string) {
+	addressSet := make(map[string]bool, len(addresses))
+	for _, address := range addresses {
+		addressSet[address] = true
+	}
+
+	ctx.Header("Content-Type", "text/csv; charset=utf-8")
+	ctx.Header("Content-Disposition", `attachment; filename="transactions.csv"`)
+	if page.Token != nil {
+		ctx.Header("X-Next-Token", *page.Token)
+	}
+
+	ctx.Status(http.StatusOK)
+
+	writer := csv.NewWriter(ctx.Writer)
+
+	var rows int
+	err := writer.Write(csvHeader)
+	if err == nil {
+		err = page.Each(ctx.Request.Context(), func(tx *types.Transaction) error {
+			if err := writer.Write(csvRow(tx, addressSet)); err != nil {
+				return err
+			}
+
+			if rows++; rows%csvFlushRows == 0 {
+				writer.Flush()
+				ctx.Writer.Flush()
+			}
+
+			return writer.Error()
+		})
+	}
+
+	writer.Flush()
+	if err == nil {
+		err = writer.Error()
+	}
+
+	if err != nil {
+		utils.Logger(ctx.Request.Context()).WithFields(log.Fields{
+			"rows":  rows,
+			"error": err,
+		}).Error("Failed to export transactions as CSV")
+	}
+}
+
+// csvRow returns the CSV row of the transaction, from the point of view of
+// the given addresses.
+//
+// A transaction spending from the addresses is sent, and its amount is the
+// value paid to other addresses, excluding the fee; if it pays only to the
+// addresses, it is a transfer to self. Other transactions are received. The
+// address column lists the counterparties, separated by spaces: the other
+// outputs of sent transactions, and the inputs of received ones.
+func csvRow(tx *types.Transaction, addresses map[string]bool) []string {
+	var received, spent btcutil.Amount
+	for _, output := range tx.Outputs {
+		if output.Value != nil && addresses[output.Address] {
+			received += *output.Value
+		}
+	}
+
+	for _, input := range tx.Inputs {
+		if input.Value != nil && addresses[input.Address] {
+			spent += *input.Value
+		}
+	}
+
+	var fees btcutil.Amount
+	if tx.Fees != nil {
+		fees = *tx.Fees
+	}
+
+	var direction, fee string
+	var amount btcutil.Amount
+	var counterparties []string
+
+	switch {
+	case spent == 0:
+		direction = "received"
+		amount = received
+
+		for _, input := range tx.Inputs {
+			counterparties = append(counterparties, input.Address)
+		}
+	default:
+		direction = "sent"
+		amount = spent - received - fees
+		fee = strconv.FormatInt(int64(fees), 10)
+
+		if amount <= 0 {
+			direction = "self"
+			amount = 0
+		}
+
+		for _, output := range tx.Outputs {
+			counterparties = append(counterparties, output.Address)
+		}
+	}
+
+	var height string
+	if tx.Block != nil {
+		height = strconv.FormatInt(tx.Block.Height, 10)
+	}
+
+	return []string{
+		tx.ReceivedAt,
+		tx.Hash,
+		direction,
+		strconv.FormatInt(int64(amount), 10),
+		utils.FormatBTC(amount),
+		fee,
+		height,
+		strings.Join(foreignAddresses(counterparties, addresses), " "),
+	}
+}
+
+// foreignAddresses returns the distinct non-empty addresses of the list that
+// are not in the given set, in order.
+func foreignAddresses(list []string, addresses map[string]bool) []string {
+	var ret []string
+
+	seen := make(map[string]bool)
+	for _, address := range list {
+		if address == "" || addresses[address] || seen[address] {
+			continue
+		}
+
+		seen[address] = true
+		ret = append(ret, address)
+	}
+
+	return ret
+}
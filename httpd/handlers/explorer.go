@@ -1,21 +1,28 @@
 package handlers
 
 import (
+	"io"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/ledgerhq/satstack/bus"
+	"github.com/ledgerhq/satstack/config"
+	"github.com/ledgerhq/satstack/httpd/apierror"
 	"github.com/ledgerhq/satstack/httpd/svc"
+	"github.com/ledgerhq/satstack/utils"
 
 	"github.com/gin-gonic/gin"
 )
 
 func GetHealth(s svc.ExplorerService) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		err := s.GetHealth()
+		// Any failure is reported as a 503, as expected by health checks.
+		err := s.GetHealth(ctx.Request.Context())
 		if err != nil {
-			ctx.JSON(http.StatusServiceUnavailable, err)
+			apierror.Abort(ctx, apierror.New(apierror.NodeUnavailable, "%s", bus.ErrBitcoindUnreachable))
 			return
 		}
 
@@ -23,26 +30,47 @@ func GetHealth(s svc.ExplorerService) gin.HandlerFunc {
 	}
 }
 
+// GetFees is a gin handler (factory) to estimate fees for a list of
+// confirmation targets.
+//
+// Targets can be passed as a comma-separated list, or by repeating the
+// block_count query parameter. Duplicate targets are ignored, and an invalid
+// target is a bad request. If no target or mode is passed, the defaults of
+// the service are used.
 func GetFees(s svc.ExplorerService) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		blockCounts := ctx.QueryArray("block_count")
-		mode := strings.ToUpper(ctx.Param("mode"))
-		if mode == "" || (mode != "UNSET" && mode != "ECONOMICAL" && mode != "CONSERVATIVE") {
-			mode = "CONSERVATIVE"
+		mode := strings.ToUpper(ctx.Query("mode"))
+		if mode != "" && !utils.Contains(config.FeeModes, mode) {
+			apierror.Abort(ctx, apierror.New(apierror.InvalidRequest, "invalid mode '%s'", mode))
+			return
 		}
 
-		var blockCountsIntegers []int64
-		for _, blockCount := range blockCounts {
-			if value, err := strconv.ParseInt(blockCount, 10, 64); err == nil {
-				blockCountsIntegers = append(blockCountsIntegers, value)
-			}
-		}
+		var targets []int64
+		visited := make(map[int64]bool)
+
+		for _, blockCounts := range ctx.QueryArray("block_count") {
+			for _, blockCount := range strings.Split(blockCounts, ",") {
+				target, err := strconv.ParseInt(strings.TrimSpace(blockCount), 10, 64)
+				if err != nil {
+					apierror.Abort(ctx, apierror.New(apierror.InvalidRequest,
+						"invalid block_count '%s'", blockCount))
+					return
+				}
 
-		if len(blockCountsIntegers) == 0 {
-			blockCountsIntegers = append(blockCountsIntegers, 2, 3, 6)
+				if err := config.ValidateFeeTarget(target); err != nil {
+					apierror.Abort(ctx, apierror.New(apierror.InvalidRequest,
+						"invalid block_count: %s", err))
+					return
+				}
+
+				if !visited[target] {
+					targets = append(targets, target)
+					visited[target] = true
+				}
+			}
 		}
 
-		fees := s.GetFees(blockCountsIntegers, mode)
+		fees := s.GetFees(ctx.Request.Context(), targets, mode)
 		ctx.JSON(http.StatusOK, fees)
 	}
 }
@@ -60,3 +88,113 @@ func GetStatus(s svc.ExplorerService) gin.HandlerFunc {
 		ctx.JSON(http.StatusOK, s.GetStatus())
 	}
 }
+
+// GetStatuses is a gin handler (factory) to get the ExplorerStatus of every
+// chain served, keyed by the name of the chain. The first chain is the
+// default one.
+//
+// The nodes are queried concurrently, so that an unresponsive node only
+// delays the response by its RPC timeout.
+func GetStatuses(names []string, services []svc.ExplorerService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		results := make([]*bus.ExplorerStatus, len(services))
+
+		var wg sync.WaitGroup
+		for idx, s := range services {
+			wg.Add(1)
+			go func(idx int, s svc.ExplorerService) {
+				defer wg.Done()
+				results[idx] = s.GetStatus()
+			}(idx, s)
+		}
+
+		wg.Wait()
+
+		statuses := make(map[string]*bus.ExplorerStatus, len(services))
+		for idx, status := range results {
+			statuses[names[idx]] = status
+		}
+
+		ctx.JSON(http.StatusOK, gin.H{
+			"default": names[0],
+			"chains":  statuses,
+		})
+	}
+}
+
+// statusKeepAliveInterval is the interval at which a comment is sent on the
+// stream of status updates, so that idle connections are not dropped by
+// proxies.
+const statusKeepAliveInterval = 15 * time.Second
+
+// StreamStatus is a gin handler (factory) that streams the updates of the
+// ExplorerStatus as Server-Sent Events, starting with the last known one.
+//
+// The stream ends when the client disconnects, or when SatStack shuts down.
+func StreamStatus(s svc.ExplorerService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		statuses, cancel := s.SubscribeStatus()
+		defer cancel()
+
+		keepAlive := time.NewTicker(statusKeepAliveInterval)
+		defer keepAlive.Stop()
+
+		ctx.Header("Content-Type", "text/event-stream")
+		ctx.Header("Cache-Control", "no-cache")
+		ctx.Header("X-Accel-Buffering", "no") // disable buffering by nginx
+
+		ctx.Stream(func(w io.Writer) bool {
+			select {
+			case status, ok := <-statuses:
+				if !ok {
+					return false
+				}
+
+				ctx.SSEvent("status", status)
+				return true
+			case <-keepAlive.C:
+				_, err := io.WriteString(w, ": keep-alive\n\n")
+				return err == nil
+			case <-ctx.Request.Context().Done():
+				return false
+			}
+		})
+	}
+}
+
+// GetMempool is a gin handler (factory) to get the distribution of the fee
+// rates of the transactions in the mempool.
+func GetMempool(s svc.ExplorerService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		histogram, err := s.GetMempool(ctx.Request.Context())
+		if err != nil {
+			apierror.Abort(ctx, apierror.From(err, apierror.NotFound))
+			return
+		}
+
+		ctx.JSON(http.StatusOK, histogram)
+	}
+}
+
+// GetLiveness is a gin handler (factory) for the liveness probe. It succeeds
+// as long as the HTTP server is up.
+func GetLiveness() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, gin.H{"Status": "OK"})
+	}
+}
+
+// GetReadiness is a gin handler (factory) for the readiness probe. It
+// succeeds only if the Status is Ready, and does not perform RPC calls, so
+// that a hung bitcoind cannot make the probe hang.
+func GetReadiness(s svc.ExplorerService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		status, detail := s.GetReadiness()
+		if status != bus.Ready {
+			apierror.Abort(ctx, apierror.Unready(apierror.NotReady, status, detail))
+			return
+		}
+
+		ctx.JSON(http.StatusOK, gin.H{"status": status})
+	}
+}
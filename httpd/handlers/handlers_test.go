@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ledgerhq/satstack/bus"
+	"github.com/ledgerhq/satstack/httpd/apierror"
+	"github.com/ledgerhq/satstack/httpd/svc"
+	"github.com/ledgerhq/satstack/types"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/gin-gonic/gin"
+)
+
+const testTxHash = "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b"
+
+// fakeService implements the services used by the handlers under test.
+// Calling any other method panics.
+type fakeService struct {
+	svc.ServiceInterface
+
+	// err is returned by every method, if not nil.
+	err   error
+	calls int
+
+	rpcStats bus.RPCStats
+
+	selfTest  bus.SelfTestReport
+	broadcast bool
+}
+
+func (s *fakeService) GetTransactionHex(context.Context, string, bus.TransactionHint) (string, error) {
+	s.calls++
+	return "0100", s.err
+}
+
+func (s *fakeService) GetMempoolTransaction(_ context.Context, hash string) (*types.MempoolTransaction, error) {
+	s.calls++
+	return &types.MempoolTransaction{TxID: hash}, s.err
+}
+
+func (s *fakeService) SendTransaction(string, *float64) (string, bool, error) {
+	s.calls++
+	return testTxHash, false, s.err
+}
+
+func (s *fakeService) GetBlock(context.Context, string) (*types.Block, error) {
+	s.calls++
+	return &types.Block{Height: 1}, s.err
+}
+
+func (s *fakeService) GetBlockHeader(context.Context, string) (*types.BlockHeader, error) {
+	s.calls++
+	return &types.BlockHeader{Height: 1}, s.err
+}
+
+func (s *fakeService) GetBlockStats(_ context.Context, ref string) (*types.BlockStats, error) {
+	s.calls++
+	return &types.BlockStats{Height: 1, TotalFees: 6500, Source: bus.BlockStatsSource}, s.err
+}
+
+func (s *fakeService) GetBlockTransactions(context.Context, string, int, int) (*svc.BlockTransactions, error) {
+	s.calls++
+	return nil, s.err
+}
+
+func (s *fakeService) FilterAccountAddresses(_ string, addresses []string) ([]string, error) {
+	s.calls++
+	return addresses, s.err
+}
+
+func (s *fakeService) HasDescriptor(context.Context, string) (bool, error) {
+	s.calls++
+	return true, s.err
+}
+
+func (s *fakeService) RPCStats() bus.RPCStats {
+	s.calls++
+	return s.rpcStats
+}
+
+func (s *fakeService) ResetRPCStats() {
+	s.calls++
+	s.rpcStats = bus.RPCStats{Since: "2026-01-02T00:00:00Z", Methods: []bus.RPCMethodStats{}}
+}
+
+func (s *fakeService) SelfTest(_ context.Context, broadcast bool) bus.SelfTestReport {
+	s.calls++
+	s.broadcast = broadcast
+	return s.selfTest
+}
+
+// serve handles a request with the handler, routed at route.
+func serve(handler gin.HandlerFunc, method, route, target, body string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+
+	engine := gin.New()
+	engine.Handle(method, route, handler)
+
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, httptest.NewRequest(method, target, strings.NewReader(body)))
+
+	return recorder
+}
+
+// checkError checks that the response is an error response with the given
+// code, and its status.
+func checkError(t *testing.T, name string, recorder *httptest.ResponseRecorder, code apierror.Code) {
+	t.Helper()
+
+	want := (&apierror.Error{Code: code}).Status()
+	if recorder.Code != want {
+		t.Errorf("%s: got status %d, want %d", name, recorder.Code, want)
+	}
+
+	// The headers written by the handler, unlike the ones set after the
+	// response was sent.
+	if got := recorder.Result().Header.Get("Content-Type"); !strings.HasPrefix(got, "application/json") {
+		t.Errorf("%s: got Content-Type %q, want JSON", name, got)
+	}
+
+	var body struct {
+		Code  apierror.Code `json:"code"`
+		Error string        `json:"error"`
+	}
+
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Errorf("%s: got body %q: %v", name, recorder.Body, err)
+		return
+	}
+
+	if body.Code != code || body.Error == "" {
+		t.Errorf("%s: got body %q, want code %s and a message", name, recorder.Body, code)
+	}
+}
+
+// failureClasses are the errors of the services, by the code of their
+// responses.
+var failureClasses = []struct {
+	name string
+	err  error
+	code apierror.Code
+}{
+	{"RPC not found", btcjson.NewRPCError(btcjson.ErrRPCInvalidAddressOrKey, "No such mempool or blockchain transaction"), ""},
+	{"RPC invalid parameter", btcjson.NewRPCError(btcjson.ErrRPCInvalidParameter, "Invalid parameter"), apierror.InvalidRequest},
+	{"unreachable", &url.Error{Op: "Post", URL: "http://localhost:8332", Err: errors.New("connection refused")}, apierror.NodeUnavailable},
+	{"warmup", btcjson.NewRPCError(btcjson.ErrRPCInWarmup, "Loading block index..."), apierror.NodeUnavailable},
+	{"timeout", fmt.Errorf("%w: getrawtransaction: context deadline exceeded", bus.ErrRPCAborted), apierror.NodeTimeout},
+	{"RPC failure", btcjson.NewRPCError(btcjson.ErrRPCMisc, "Internal bug detected"), apierror.RPCError},
+	{"unexpected", errors.New("unexpected"), apierror.Internal},
+}
+
+func TestGetTransactionHexErrors(t *testing.T) {
+	for _, class := range failureClasses {
+		code := class.code
+		if code == "" {
+			code = apierror.TxNotFound
+		}
+
+		recorder := serve(GetTransactionHex(&fakeService{err: class.err}),
+			http.MethodGet, "/tx/:hash/hex", "/tx/"+testTxHash+"/hex", "")
+		checkError(t, class.name, recorder, code)
+	}
+
+	recorder := serve(GetTransactionHex(&fakeService{err: fmt.Errorf("%w: %s", bus.ErrTxIndexRequired, testTxHash)}),
+		http.MethodGet, "/tx/:hash/hex", "/tx/"+testTxHash+"/hex", "")
+	checkError(t, "transaction index", recorder, apierror.TxIndexRequired)
+
+	s := &fakeService{}
+	for _, target := range []string{
+		"/tx/nothex/hex",
+		"/tx/" + testTxHash + "/hex?block_hash=nothex",
+		"/tx/" + testTxHash + "/hex?window=0",
+	} {
+		checkError(t, target, serve(GetTransactionHex(s), http.MethodGet, "/tx/:hash/hex", target, ""),
+			apierror.InvalidRequest)
+	}
+
+	if s.calls != 0 {
+		t.Errorf("got %d calls of the service for invalid requests, want none", s.calls)
+	}
+
+	recorder = serve(GetTransactionHex(&fakeService{}), http.MethodGet, "/tx/:hash/hex", "/tx/"+testTxHash+"/hex", "")
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), `"hex":"0100"`) {
+		t.Errorf("got %d %q, want the hex of the transaction", recorder.Code, recorder.Body)
+	}
+}
+
+func TestGetMempoolTransactionErrors(t *testing.T) {
+	err := &bus.NotInMempoolError{TxID: testTxHash, Evicted: true}
+
+	recorder := serve(GetMempoolTransaction(&fakeService{err: err}),
+		http.MethodGet, "/tx/:hash/mempool", "/tx/"+testTxHash+"/mempool", "")
+	checkError(t, "not in mempool", recorder, apierror.TxNotFound)
+
+	if !strings.Contains(recorder.Body.String(), `"evicted":true`) {
+		t.Errorf("got body %q, want the transaction reported as evicted", recorder.Body)
+	}
+}
+
+func TestGetBlockErrors(t *testing.T) {
+	for _, class := range failureClasses {
+		code := class.code
+		if code == "" {
+			code = apierror.BlockNotFound
+		}
+
+		for _, target := range []string{"/blocks/1", "/blocks/1?txs=false"} {
+			recorder := serve(GetBlock(&fakeService{err: class.err}), http.MethodGet, "/blocks/:block", target, "")
+			checkError(t, class.name+" "+target, recorder, code)
+		}
+	}
+
+	recorder := serve(GetBlock(&fakeService{err: fmt.Errorf("%w: height 1000", bus.ErrBlockNotFound)}),
+		http.MethodGet, "/blocks/:block", "/blocks/1000", "")
+	checkError(t, "unknown block", recorder, apierror.BlockNotFound)
+
+	recorder = serve(GetBlock(&fakeService{err: fmt.Errorf("%w 'abc'", svc.ErrInvalidBlock)}),
+		http.MethodGet, "/blocks/:block", "/blocks/abc", "")
+	checkError(t, "invalid block", recorder, apierror.InvalidRequest)
+}
+
+func TestSendTransactionErrors(t *testing.T) {
+	rejected := &bus.RejectError{TxID: testTxHash, Reason: "min relay fee not met"}
+
+	recorder := serve(SendTransaction(&fakeService{err: rejected}),
+		http.MethodPost, "/tx/send", "/tx/send", `{"tx": "0100"}`)
+	checkError(t, "rejected", recorder, apierror.TxRejected)
+
+	if !strings.Contains(recorder.Body.String(), `"reject_reason":"min relay fee not met"`) {
+		t.Errorf("got body %q, want the reject reason", recorder.Body)
+	}
+
+	for _, class := range failureClasses[2:] {
+		recorder := serve(SendTransaction(&fakeService{err: class.err}),
+			http.MethodPost, "/tx/send", "/tx/send", `{"tx": "0100"}`)
+		checkError(t, class.name, recorder, class.code)
+	}
+
+	s := &fakeService{}
+	for target, body := range map[string]string{
+		"/tx/send":                 `{}`,
+		"/tx/send?max_fee_rate=-1": `{"tx": "0100"}`,
+		"/tx/send?max_fee_rate=x":  `{"tx": "0100"}`,
+	} {
+		checkError(t, target+" "+body, serve(SendTransaction(s), http.MethodPost, "/tx/send", target, body),
+			apierror.InvalidRequest)
+	}
+
+	if s.calls != 0 {
+		t.Errorf("got %d calls of the service for invalid requests, want none", s.calls)
+	}
+}
+
+func TestGetAddressesErrors(t *testing.T) {
+	s := &fakeService{err: fmt.Errorf("%w: savings", bus.ErrAccountNotFound)}
+
+	recorder := serve(GetAddresses(s), http.MethodGet, "/addresses/:addresses/transactions",
+		"/addresses/bcrt1qfirst/transactions?account=savings", "")
+	checkError(t, "unknown account", recorder, apierror.NotFound)
+
+	recorder = serve(GetAddresses(s), http.MethodGet, "/addresses/:addresses/transactions",
+		"/addresses/bcrt1qfirst/transactions?batch_size=0", "")
+	checkError(t, "invalid batch size", recorder, apierror.InvalidRequest)
+}
+
+func TestHasDescriptorErrors(t *testing.T) {
+	recorder := serve(HasDescriptor(&fakeService{err: fmt.Errorf("%w: missing checksum", bus.ErrInvalidDescriptor)}),
+		http.MethodPost, "/control/descriptors/has", "/control/descriptors/has", `{"descriptor": "wpkh(xpub)"}`)
+	checkError(t, "invalid descriptor", recorder, apierror.InvalidRequest)
+
+	recorder = serve(HasDescriptor(&fakeService{}),
+		http.MethodPost, "/control/descriptors/has", "/control/descriptors/has", `{}`)
+	checkError(t, "missing descriptor", recorder, apierror.InvalidRequest)
+}
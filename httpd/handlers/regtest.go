@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ledgerhq/satstack/bus"
+	"github.com/ledgerhq/satstack/httpd/apierror"
+	"github.com/ledgerhq/satstack/httpd/svc"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/btcsuite/btcutil"
+	"github.com/gin-gonic/gin"
+)
+
+// Generate is a gin handler (factory) to mine blocks on regtest. The body
+// contains the number of blocks, and optionally the address to pay the
+// rewards to. Without an address, the rewards fund the faucet used by Fund.
+func Generate(s svc.RegtestService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var request struct {
+			Count   int     `json:"count" binding:"required"`
+			Address *string `json:"address"`
+		}
+
+		if err := ctx.ShouldBindJSON(&request); err != nil {
+			log.Error("Failed to bind JSON request")
+			apierror.Abort(ctx, apierror.New(apierror.InvalidRequest, "%s", err))
+			return
+		}
+
+		result, err := s.Generate(request.Count, request.Address)
+		regtestResponse(ctx, result, err)
+	}
+}
+
+// Fund is a gin handler (factory) to send an amount, in satoshis, to an
+// address on regtest. A block is mined on top to confirm the transaction.
+func Fund(s svc.RegtestService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var request struct {
+			Address string `json:"address" binding:"required"`
+			Amount  int64  `json:"amount" binding:"required"`
+		}
+
+		if err := ctx.ShouldBindJSON(&request); err != nil {
+			log.Error("Failed to bind JSON request")
+			apierror.Abort(ctx, apierror.New(apierror.InvalidRequest, "%s", err))
+			return
+		}
+
+		result, err := s.Fund(request.Address, btcutil.Amount(request.Amount))
+		regtestResponse(ctx, result, err)
+	}
+}
+
+func regtestResponse(ctx *gin.Context, result *bus.GenerateResult, err error) {
+	switch {
+	case errors.Is(err, bus.ErrInvalidRegtestRequest), errors.Is(err, bus.ErrInsufficientFunds):
+		apierror.Abort(ctx, apierror.From(err, apierror.NotFound))
+	case err != nil:
+		log.WithField("error", err).Error("Failed to mine blocks")
+		apierror.Abort(ctx, apierror.From(err, apierror.NotFound))
+	default:
+		ctx.JSON(http.StatusOK, result)
+	}
+}
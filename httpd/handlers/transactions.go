@@ -1,22 +1,27 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/ledgerhq/satstack/bus"
+	"github.com/ledgerhq/satstack/httpd/apierror"
 	"github.com/ledgerhq/satstack/httpd/svc"
+	"github.com/ledgerhq/satstack/utils"
 	log "github.com/sirupsen/logrus"
 )
 
 // GetTransactionHex is a gin handler (factory) to query transaction hex
-// by hash parameter.
+// by hash parameter. The response is a list of 1 element, as expected by
+// libcore.
 func GetTransactionHex(s svc.TransactionsService) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		txHash := ctx.Param("hash")
 
-		txHex, err := s.GetTransactionHex(txHash)
-		if err != nil {
-			ctx.JSON(http.StatusNotFound, err)
+		txHex, ok := transactionHex(ctx, s, txHash)
+		if !ok {
 			return
 		}
 
@@ -29,26 +34,192 @@ func GetTransactionHex(s svc.TransactionsService) gin.HandlerFunc {
 	}
 }
 
+// GetRawTransactionHex is a gin handler (factory) to query the hex of any
+// transaction by hash parameter, for ex to sign legacy inputs, which commit
+// to the full previous transaction.
+//
+// Transactions that do not belong to the wallet can only be found if the
+// node has a transaction index, or with a hint; see transactionHex.
+func GetRawTransactionHex(s svc.TransactionsService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		txHash := ctx.Param("hash")
+
+		txHex, ok := transactionHex(ctx, s, txHash)
+		if !ok {
+			return
+		}
+
+		ctx.JSON(http.StatusOK, gin.H{
+			"transaction_hash": txHash,
+			"hex":              txHex,
+		})
+	}
+}
+
+// GetMempoolTransaction is a gin handler (factory) to get the mempool data of
+// a transaction by hash parameter, for ex to find out why it is not
+// confirmed. A transaction that is not in the mempool is reported with a
+// 404, telling whether it was confirmed, or evicted; see apierror.From.
+func GetMempoolTransaction(s svc.TransactionsService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		txHash := ctx.Param("hash")
+
+		if _, err := utils.ParseChainHash(txHash); err != nil {
+			apierror.Abort(ctx, apierror.New(apierror.InvalidRequest,
+				"invalid transaction hash '%s'", txHash))
+			return
+		}
+
+		entry, err := s.GetMempoolTransaction(ctx.Request.Context(), txHash)
+		if err != nil {
+			apierror.Abort(ctx, apierror.From(err, apierror.TxNotFound))
+			return
+		}
+
+		ctx.JSON(http.StatusOK, entry)
+	}
+}
+
+// transactionHex looks up the hex of the transaction with the given hash. If
+// it fails, the error response is written, and false is returned.
+//
+// Without a transaction index, the transactions that do not belong to the
+// wallet are found with the block_hash query parameter, or with the address
+// one, which searches the last window blocks with the block filters. If
+// neither is usable, a 422 explains the limitation.
+func transactionHex(ctx *gin.Context, s svc.TransactionsService, txHash string) (string, bool) {
+	if _, err := utils.ParseChainHash(txHash); err != nil {
+		apierror.Abort(ctx, apierror.New(apierror.InvalidRequest,
+			"invalid transaction hash '%s'", txHash))
+		return "", false
+	}
+
+	hint, err := transactionHint(ctx)
+	if err != nil {
+		apierror.Abort(ctx, apierror.New(apierror.InvalidRequest, "%s", err))
+		return "", false
+	}
+
+	txHex, err := s.GetTransactionHex(ctx.Request.Context(), txHash, hint)
+	if err != nil {
+		apierror.Abort(ctx, apierror.From(err, apierror.TxNotFound))
+		return "", false
+	}
+
+	return txHex, true
+}
+
+// transactionHint parses the block_hash, address and window query
+// parameters, used to look up transactions without transaction index.
+func transactionHint(ctx *gin.Context) (bus.TransactionHint, error) {
+	var hint bus.TransactionHint
+
+	if query := ctx.Query("block_hash"); query != "" {
+		hash, err := utils.ParseChainHash(query)
+		if err != nil {
+			return hint, fmt.Errorf("invalid block_hash '%s'", query)
+		}
+
+		hint.BlockHash = hash
+	}
+
+	hint.Address = ctx.Query("address")
+
+	if query := ctx.Query("window"); query != "" {
+		window, err := strconv.Atoi(query)
+		if err != nil || window < 1 || window > bus.MaxLookupWindow {
+			return hint, fmt.Errorf("invalid window '%s', expected between 1 and %d",
+				query, bus.MaxLookupWindow)
+		}
+
+		hint.Window = window
+	}
+
+	return hint, nil
+}
+
 func SendTransaction(s svc.TransactionsService) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		var request struct {
 			Transaction string `json:"tx" binding:"required"`
 		}
 
-		if err := ctx.BindJSON(&request); err != nil {
+		if err := ctx.ShouldBindJSON(&request); err != nil {
 			log.Error("Failed to bind JSON request")
-			ctx.JSON(http.StatusBadRequest, err)
+			apierror.Abort(ctx, apierror.New(apierror.InvalidRequest, "%s", err))
 			return
 		}
 
-		txHash, err := s.SendTransaction(request.Transaction)
+		// Optional maximum fee rate in BTC/kvB, protecting against fee
+		// mistakes.
+		var maxFeeRate *float64
+		if query := ctx.Query("max_fee_rate"); query != "" {
+			value, err := strconv.ParseFloat(query, 64)
+			if err != nil || value < 0 {
+				apierror.Abort(ctx, apierror.New(apierror.InvalidRequest,
+					"invalid max_fee_rate '%s'", query))
+				return
+			}
+
+			maxFeeRate = &value
+		}
+
+		txHash, known, err := s.SendTransaction(request.Transaction, maxFeeRate)
 		if err != nil {
-			ctx.JSON(http.StatusInternalServerError, err)
+			apierror.Abort(ctx, apierror.From(err, apierror.NotFound))
 			return
 		}
 
+		// Retried broadcasts succeed, and are flagged as such.
+		response := gin.H{"result": txHash}
+		if known {
+			response["already_known"] = true
+		}
+
+		ctx.JSON(http.StatusOK, response)
+	}
+}
+
+// SendTransactions is a gin handler (factory) to broadcast an ordered list
+// of transactions as a package.
+//
+// The outcome is reported for each transaction, in the order of the request,
+// so a partial failure does not hide the transactions that were accepted.
+func SendTransactions(s svc.TransactionsService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var request struct {
+			Transactions []string `json:"txs" binding:"required,min=1"`
+		}
+
+		if err := ctx.ShouldBindJSON(&request); err != nil {
+			log.Error("Failed to bind JSON request")
+			apierror.Abort(ctx, apierror.New(apierror.InvalidRequest, "%s", err))
+			return
+		}
+
+		results := s.SendTransactions(request.Transactions)
+
+		// Failed transactions are reported with the fields of an error
+		// response, and their txid.
+		response := make([]interface{}, len(results))
+		for idx, result := range results {
+			switch {
+			case result.Err == nil && result.AlreadyKnown:
+				response[idx] = gin.H{"txid": result.TxID, "already_known": true}
+			case result.Err == nil:
+				response[idx] = gin.H{"txid": result.TxID}
+			default:
+				apiErr := apierror.From(result.Err, apierror.NotFound)
+				if _, ok := apiErr.Fields["txid"]; !ok {
+					apiErr = apiErr.With("txid", result.TxID)
+				}
+
+				response[idx] = apiErr
+			}
+		}
+
 		ctx.JSON(http.StatusOK, gin.H{
-			"result": txHash,
+			"results": response,
 		})
 	}
 }
@@ -0,0 +1,80 @@
+package httpd
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/ledgerhq/satstack/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// Listen opens the listeners of the HTTP server. If one of them fails, the
+// ones already opened are closed.
+//
+// A stale unix domain socket, left by a process that did not exit cleanly,
+// is replaced. Other files are never removed.
+func Listen(listeners []config.Listener, mode os.FileMode) ([]net.Listener, error) {
+	var ret []net.Listener
+
+	for _, l := range listeners {
+		listener, err := listen(l, mode)
+		if err != nil {
+			for _, opened := range ret {
+				_ = opened.Close()
+			}
+
+			return nil, fmt.Errorf("%s: %w", l, err)
+		}
+
+		ret = append(ret, listener)
+	}
+
+	return ret, nil
+}
+
+func listen(l config.Listener, mode os.FileMode) (net.Listener, error) {
+	if l.Network != "unix" {
+		return net.Listen(l.Network, l.Address)
+	}
+
+	if info, err := os.Lstat(l.Address); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("file exists and is not a socket")
+		}
+
+		if err := os.Remove(l.Address); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen(l.Network, l.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(l.Address, mode); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+// RemoveSockets removes the files of the unix domain sockets, if they were
+// not already removed when closing the listeners.
+func RemoveSockets(listeners []config.Listener) {
+	for _, l := range listeners {
+		if l.Network != "unix" {
+			continue
+		}
+
+		if err := os.Remove(l.Address); err != nil && !os.IsNotExist(err) {
+			log.WithFields(log.Fields{
+				"prefix": "http",
+				"socket": l.Address,
+				"error":  err,
+			}).Warn("Failed to remove socket file")
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/ledgerhq/satstack/config"
+	"github.com/ledgerhq/satstack/httpd/apierror"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// authRealm is the realm advertised in the WWW-Authenticate header.
+const authRealm = "SatStack"
+
+// Auth is a gin middleware (factory) that rejects requests without valid
+// credentials with a 401. Requests to the exempt paths, given either as
+// routes (for ex, /blockchain/:version/explorer/_health) or as plain paths,
+// are served without authentication.
+//
+// Comparisons are constant-time, between keyed digests of the credentials;
+// see newDigest. In basic mode, the bcrypt hash is only checked until the
+// password is first verified; from then on, passwords are compared against
+// the digest of the verified one, so that neither valid nor invalid requests
+// pay the cost of bcrypt.
+//
+// CORS preflight requests carry no credentials, so this middleware must be
+// registered after the CORS one.
+func Auth(auth config.Auth) gin.HandlerFunc {
+	exempt := make(map[string]bool, len(auth.Exempt))
+	for _, path := range auth.Exempt {
+		exempt[path] = true
+	}
+
+	var check func(r *http.Request) bool
+	var challenge string
+
+	switch auth.Mode {
+	case config.AuthModeBasic:
+		check = newBasicChecker(auth).check
+		challenge = `Basic realm="` + authRealm + `", charset="UTF-8"`
+	default:
+		digest := newDigest()
+		token := digest(auth.Token)
+		check = func(r *http.Request) bool {
+			value := r.Header.Get("Authorization")
+			if !strings.HasPrefix(value, "Bearer ") {
+				return false
+			}
+
+			return subtle.ConstantTimeCompare(digest(strings.TrimPrefix(value, "Bearer ")), token) == 1
+		}
+		challenge = `Bearer realm="` + authRealm + `"`
+	}
+
+	return func(ctx *gin.Context) {
+		if exempt[ctx.FullPath()] || exempt[ctx.Request.URL.Path] {
+			ctx.Next()
+			return
+		}
+
+		if !check(ctx.Request) {
+			ctx.Header("WWW-Authenticate", challenge)
+			apierror.Abort(ctx, apierror.New(apierror.Unauthorized, "unauthorized"))
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+type basicChecker struct {
+	digest   func(value string) []byte
+	username []byte // digest of the username
+	hash     []byte
+
+	mu       sync.RWMutex
+	verified []byte // digest of the verified password, if any
+}
+
+func newBasicChecker(auth config.Auth) *basicChecker {
+	digest := newDigest()
+
+	return &basicChecker{
+		digest:   digest,
+		username: digest(auth.Username),
+		hash:     []byte(auth.PasswordHash),
+	}
+}
+
+func (c *basicChecker) check(r *http.Request) bool {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+
+	validUsername := subtle.ConstantTimeCompare(c.digest(username), c.username) == 1
+
+	passwordDigest := c.digest(password)
+
+	c.mu.RLock()
+	verified := c.verified
+	c.mu.RUnlock()
+
+	if verified != nil {
+		validPassword := subtle.ConstantTimeCompare(passwordDigest, verified) == 1
+		return validUsername && validPassword
+	}
+
+	if bcrypt.CompareHashAndPassword(c.hash, []byte(password)) != nil {
+		return false
+	}
+
+	c.mu.Lock()
+	c.verified = passwordDigest
+	c.mu.Unlock()
+
+	return validUsername
+}
+
+// newDigest returns a function computing the HMAC-SHA256 of credentials, so
+// that they are compared in constant time regardless of their length. The
+// key is random, and generated when the middleware is created, so that the
+// digests held in memory cannot be reversed with precomputed tables, nor
+// reused across restarts.
+func newDigest() func(value string) []byte {
+	key := make([]byte, sha256.Size)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("failed to generate the key of the credential digests: %s", err))
+	}
+
+	return func(value string) []byte {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(value))
+		return mac.Sum(nil)
+	}
+}
@@ -0,0 +1,210 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ledgerhq/satstack/config"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newAuthEngine returns an engine serving /private and the exempt /healthz,
+// behind the Auth middleware, registered after the CORS one as in the router.
+func newAuthEngine(auth config.Auth) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	auth.Exempt = []string{"/healthz"}
+
+	engine := gin.New()
+	engine.Use(CORS([]string{"https://dashboard.example"}, engine.Routes), Auth(auth))
+
+	ok := func(ctx *gin.Context) { ctx.Status(http.StatusOK) }
+	engine.GET("/private", ok)
+	engine.GET("/healthz", ok)
+
+	return engine
+}
+
+// serve performs a GET request, with the header set by authorize if any,
+// and returns the status code.
+func serve(engine *gin.Engine, path string, authorize func(r *http.Request)) int {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	if authorize != nil {
+		authorize(r)
+	}
+
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, r)
+
+	return w.Code
+}
+
+func bearer(token string) func(r *http.Request) {
+	return func(r *http.Request) {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+func basic(username, password string) func(r *http.Request) {
+	return func(r *http.Request) {
+		r.SetBasicAuth(username, password)
+	}
+}
+
+func TestAuthToken(t *testing.T) {
+	engine := newAuthEngine(config.Auth{Mode: config.AuthModeToken, Token: "secret"})
+
+	tests := []struct {
+		name      string
+		path      string
+		authorize func(r *http.Request)
+		want      int
+	}{
+		{"valid token", "/private", bearer("secret"), http.StatusOK},
+		{"invalid token", "/private", bearer("secret2"), http.StatusUnauthorized},
+		{"empty token", "/private", bearer(""), http.StatusUnauthorized},
+		{"no credentials", "/private", nil, http.StatusUnauthorized},
+		{"basic credentials", "/private", basic("user", "secret"), http.StatusUnauthorized},
+		{"exempt path", "/healthz", nil, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		if got := serve(engine, test.path, test.authorize); got != test.want {
+			t.Errorf("%s: got status %d, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+func TestAuthBasic(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth := config.Auth{Mode: config.AuthModeBasic, Username: "user", PasswordHash: string(hash)}
+	checker := newBasicChecker(auth)
+
+	check := func(authorize func(r *http.Request)) bool {
+		r := httptest.NewRequest(http.MethodGet, "/private", nil)
+		authorize(r)
+		return checker.check(r)
+	}
+
+	// Checked against the bcrypt hash, until the password is verified.
+	if check(basic("user", "wrong")) || check(basic("other", "wrong")) {
+		t.Error("invalid credentials accepted")
+	}
+
+	if checker.verified != nil {
+		t.Fatal("password verified by invalid credentials")
+	}
+
+	if check(basic("other", "secret")) {
+		t.Error("invalid username accepted")
+	}
+
+	if !check(basic("user", "secret")) {
+		t.Fatal("valid credentials rejected")
+	}
+
+	// Checked against the digest of the verified password, from then on.
+	checker.hash = nil
+
+	tests := []struct {
+		username string
+		password string
+		want     bool
+	}{
+		{"user", "secret", true},
+		{"user", "wrong", false},
+		{"user", "", false},
+		{"other", "secret", false},
+		{"", "", false},
+	}
+
+	for _, test := range tests {
+		if got := check(basic(test.username, test.password)); got != test.want {
+			t.Errorf("%s:%s: got %v, want %v", test.username, test.password, got, test.want)
+		}
+	}
+}
+
+func TestAuthBasicEngine(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	engine := newAuthEngine(config.Auth{Mode: config.AuthModeBasic, Username: "user", PasswordHash: string(hash)})
+
+	if got := serve(engine, "/private", basic("user", "secret")); got != http.StatusOK {
+		t.Errorf("valid credentials: got status %d, want %d", got, http.StatusOK)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/private", nil)
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, r)
+
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("no credentials: got status %d, challenge %q, want %d with a challenge",
+			w.Code, w.Header().Get("WWW-Authenticate"), http.StatusUnauthorized)
+	}
+}
+
+func TestAuthPreflight(t *testing.T) {
+	engine := newAuthEngine(config.Auth{Mode: config.AuthModeToken, Token: "secret"})
+
+	// The preflight carries no credentials, and allows the browser to send
+	// them with the actual request.
+	r := httptest.NewRequest(http.MethodOptions, "/private", nil)
+	r.Header.Set("Origin", "https://dashboard.example")
+	r.Header.Set("Access-Control-Request-Method", http.MethodGet)
+	r.Header.Set("Access-Control-Request-Headers", "authorization")
+
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, r)
+
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusNoContent)
+	}
+
+	allowed := strings.Split(w.Header().Get("Access-Control-Allow-Headers"), ", ")
+	if !containsFold(allowed, "authorization") {
+		t.Errorf("got allowed headers %v, want Authorization", allowed)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/private", nil)
+	r.Header.Set("Origin", "https://dashboard.example")
+	bearer("secret")(r)
+
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "https://dashboard.example" {
+		t.Errorf("got status %d, allowed origin %q, want %d for the origin",
+			w.Code, w.Header().Get("Access-Control-Allow-Origin"), http.StatusOK)
+	}
+}
+
+// containsFold indicates whether the header names contain name, regardless
+// of the case.
+func containsFold(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestDigestKey(t *testing.T) {
+	// Digests are keyed at random, and cannot be precomputed.
+	if string(newDigest()("secret")) == string(newDigest()("secret")) {
+		t.Error("digests computed with the same key")
+	}
+}
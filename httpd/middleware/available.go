@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"github.com/ledgerhq/satstack/bus"
+	"github.com/ledgerhq/satstack/httpd/apierror"
+	"github.com/ledgerhq/satstack/httpd/svc"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Available is a gin middleware (factory) that rejects requests with a 503
+// while bitcoind is disconnected or warming up, rather than letting them fail with an
+// opaque error. The body contains the current Status.
+//
+// The Status is read from the cache, so that the check never blocks.
+func Available(s svc.ExplorerService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		// A detail with the Initializing status is only reported while
+		// bitcoind is warming up.
+		status, detail := s.GetReadiness()
+		if status == bus.NodeDisconnected || (status == bus.Initializing && detail != "") {
+			apierror.Abort(ctx, apierror.Unready(apierror.NodeUnavailable, status, detail))
+			return
+		}
+
+		ctx.Next()
+	}
+}
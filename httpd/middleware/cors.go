@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// corsAllowedHeaders lists the request headers used by the API, beyond the
+// CORS-safelisted ones. Authorization carries the credentials of the Auth
+// middleware, and X-Request-ID the ID of the request in the access log.
+const corsAllowedHeaders = "Authorization, Content-Type, If-None-Match, " + RequestIDHeader
+
+// corsExposedHeaders lists the response headers set by the API that browser
+// clients may read, beyond the CORS-safelisted ones.
+const corsExposedHeaders = "Retry-After, ETag, X-Total-Count, " + RequestIDHeader
+
+// CORS is a gin middleware (factory) that adds the CORS headers to responses
+// to requests from the allowed origins, and answers preflight requests. An
+// origin of "*" allows any origin.
+//
+// Requests from other origins are served as usual, without CORS headers. It
+// must be registered globally with Engine.Use, so that it also receives the
+// preflight requests to routes that do not accept the OPTIONS method.
+//
+// The allowed methods of a path are looked up from the routes, which are only
+// read once, on the first request.
+func CORS(allowedOrigins []string, routes func() gin.RoutesInfo) gin.HandlerFunc {
+	origins := make(map[string]bool, len(allowedOrigins))
+	for _, origin := range allowedOrigins {
+		origins[strings.TrimSuffix(origin, "/")] = true
+	}
+
+	var once sync.Once
+	var table []gin.RouteInfo
+
+	return func(ctx *gin.Context) {
+		origin := ctx.GetHeader("Origin")
+		if origin == "" {
+			ctx.Next()
+			return
+		}
+
+		ctx.Writer.Header().Add("Vary", "Origin")
+
+		if !origins["*"] && !origins[origin] {
+			ctx.Next()
+			return
+		}
+
+		once.Do(func() { table = routes() })
+
+		methods := allowedMethods(table, ctx.Request.URL.Path)
+		if len(methods) == 0 {
+			ctx.Next()
+			return
+		}
+
+		header := ctx.Writer.Header()
+		if origins["*"] {
+			header.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			header.Set("Access-Control-Allow-Origin", origin)
+		}
+
+		isPreflight := ctx.Request.Method == http.MethodOptions &&
+			ctx.GetHeader("Access-Control-Request-Method") != ""
+
+		if !isPreflight {
+			header.Set("Access-Control-Expose-Headers", corsExposedHeaders)
+			ctx.Next()
+			return
+		}
+
+		header.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		header.Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+		header.Set("Access-Control-Max-Age", "600")
+
+		ctx.AbortWithStatus(http.StatusNoContent)
+	}
+}
+
+// allowedMethods returns the methods of the routes matching the path, in
+// alphabetical order, along with OPTIONS. It returns nil if no route matches.
+func allowedMethods(routes gin.RoutesInfo, path string) []string {
+	var ret []string
+	seen := map[string]bool{}
+
+	for _, route := range routes {
+		if !seen[route.Method] && matchRoute(route.Path, path) {
+			ret = append(ret, route.Method)
+			seen[route.Method] = true
+		}
+	}
+
+	if len(ret) == 0 {
+		return nil
+	}
+
+	if !seen[http.MethodOptions] {
+		ret = append(ret, http.MethodOptions)
+	}
+
+	sort.Strings(ret)
+	return ret
+}
+
+// matchRoute indicates whether the path matches the gin route template, in
+// which :name matches a single segment, and *name the rest of the path.
+func matchRoute(template string, path string) bool {
+	tSegments := strings.Split(strings.Trim(template, "/"), "/")
+	pSegments := strings.Split(strings.Trim(path, "/"), "/")
+
+	for idx, segment := range tSegments {
+		if strings.HasPrefix(segment, "*") {
+			return true
+		}
+
+		if idx >= len(pSegments) {
+			return false
+		}
+
+		if !strings.HasPrefix(segment, ":") && segment != pSegments[idx] {
+			return false
+		}
+	}
+
+	return len(tSegments) == len(pSegments)
+}
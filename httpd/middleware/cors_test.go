@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCORSRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	engine := gin.New()
+	engine.Use(RequestID(), CORS([]string{"*"}, engine.Routes))
+	engine.GET("/status", func(ctx *gin.Context) { ctx.Status(http.StatusOK) })
+
+	r := httptest.NewRequest(http.MethodOptions, "/status", nil)
+	r.Header.Set("Origin", "https://dashboard.example")
+	r.Header.Set("Access-Control-Request-Method", http.MethodGet)
+	r.Header.Set("Access-Control-Request-Headers", "x-request-id")
+
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, r)
+
+	allowed := strings.Split(w.Header().Get("Access-Control-Allow-Headers"), ", ")
+	if w.Code != http.StatusNoContent || !containsFold(allowed, RequestIDHeader) {
+		t.Errorf("got status %d, allowed headers %v, want %d with %s", w.Code, allowed, http.StatusNoContent, RequestIDHeader)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/status", nil)
+	r.Header.Set("Origin", "https://dashboard.example")
+	r.Header.Set(RequestIDHeader, "abcd")
+
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, r)
+
+	// The ID sent by the client is echoed back, and readable by it.
+	exposed := strings.Split(w.Header().Get("Access-Control-Expose-Headers"), ", ")
+	if w.Header().Get(RequestIDHeader) != "abcd" || !containsFold(exposed, RequestIDHeader) {
+		t.Errorf("got request ID %q, exposed headers %v, want abcd exposed",
+			w.Header().Get(RequestIDHeader), exposed)
+	}
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ETag is a gin middleware (factory) that tags the responses with an ETag,
+// derived from the version of the data they depend on and from the URL of
+// the request, and answers conditional requests with a 304 if the tag is
+// unchanged.
+//
+// The version is returned by the given function, for ex the best block hash;
+// if it returns false, the request is served without ETag. The tags are weak,
+// since the body may be compressed or not. Error responses are never tagged,
+// since they do not depend on the version alone.
+func ETag(version func(ctx *gin.Context) (string, bool)) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		v, ok := version(ctx)
+		if !ok {
+			ctx.Next()
+			return
+		}
+
+		sum := sha256.Sum256([]byte(v + "\n" + ctx.Request.URL.RequestURI()))
+		etag := `W/"` + hex.EncodeToString(sum[:16]) + `"`
+
+		ctx.Header("ETag", etag)
+		ctx.Header("Cache-Control", "no-cache")
+
+		if matchETag(ctx.GetHeader("If-None-Match"), etag) {
+			ctx.AbortWithStatus(http.StatusNotModified)
+			return
+		}
+
+		ctx.Writer = &etagWriter{ResponseWriter: ctx.Writer}
+		ctx.Next()
+	}
+}
+
+// etagWriter drops the ETag of the response if the status is an error.
+type etagWriter struct {
+	gin.ResponseWriter
+}
+
+func (w *etagWriter) WriteHeader(code int) {
+	if code >= http.StatusBadRequest {
+		w.Header().Del("ETag")
+	}
+
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// matchETag indicates whether the If-None-Match header of a request matches
+// the given tag, using the weak comparison.
+func matchETag(header string, etag string) bool {
+	for _, item := range strings.Split(header, ",") {
+		item = strings.TrimSpace(item)
+		if item == "*" || strings.TrimPrefix(item, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newETagEngine returns an engine serving /ok and /error behind the ETag
+// middleware, with the version returned by version.
+func newETagEngine(version func() (string, bool)) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	engine := gin.New()
+	engine.Use(ETag(func(*gin.Context) (string, bool) { return version() }))
+
+	engine.GET("/ok", func(ctx *gin.Context) { ctx.String(http.StatusOK, "body") })
+	engine.GET("/error", func(ctx *gin.Context) {
+		ctx.AbortWithStatusJSON(http.StatusBadGateway, gin.H{"error": "node unreachable"})
+	})
+
+	return engine
+}
+
+func get(engine *gin.Engine, path string, etag string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	if etag != "" {
+		r.Header.Set("If-None-Match", etag)
+	}
+
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, r)
+
+	return w
+}
+
+func TestETag(t *testing.T) {
+	version := "a"
+	engine := newETagEngine(func() (string, bool) { return version, true })
+
+	w := get(engine, "/ok", "")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" {
+		t.Fatalf("got status %d, ETag %q, want a tagged response", w.Code, etag)
+	}
+
+	if w := get(engine, "/ok", etag); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusNotModified)
+	}
+
+	if w := get(engine, "/ok", `"other", `+etag[2:]); w.Code != http.StatusNotModified {
+		t.Errorf("got status %d with a list of strong tags, want %d", w.Code, http.StatusNotModified)
+	}
+
+	if w := get(engine, "/ok?cursor=1", etag); w.Code != http.StatusOK {
+		t.Errorf("got status %d for another URL, want %d", w.Code, http.StatusOK)
+	}
+
+	version = "b"
+	if w := get(engine, "/ok", etag); w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+		t.Errorf("got status %d, ETag %q after a new version, want a new tag", w.Code, w.Header().Get("ETag"))
+	}
+}
+
+func TestETagError(t *testing.T) {
+	engine := newETagEngine(func() (string, bool) { return "a", true })
+
+	w := get(engine, "/error", "")
+	if w.Code != http.StatusBadGateway || w.Header().Get("ETag") != "" {
+		t.Errorf("got status %d, ETag %q, want an untagged error", w.Code, w.Header().Get("ETag"))
+	}
+}
+
+func TestETagUnknownVersion(t *testing.T) {
+	engine := newETagEngine(func() (string, bool) { return "", false })
+
+	if w := get(engine, "/ok", "*"); w.Code != http.StatusOK || w.Header().Get("ETag") != "" {
+		t.Errorf("got status %d, ETag %q, want an untagged response", w.Code, w.Header().Get("ETag"))
+	}
+}
@@ -0,0 +1,145 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// gzipMinSize is the size of the body from which responses are compressed.
+// Smaller responses, like the status, are not worth the overhead.
+const gzipMinSize = 1024
+
+// Gzip is a gin middleware (factory) that compresses the responses with gzip,
+// if the client accepts it, and if the body is at least gzipMinSize bytes.
+//
+// The body is buffered until the threshold is reached, or the response is
+// flushed, for ex by streaming endpoints, in which case the rest of the body
+// is sent as is. WebSocket upgrades are never compressed.
+func Gzip() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Writer.Header().Add("Vary", "Accept-Encoding")
+
+		if ctx.Request.Method == http.MethodHead || ctx.GetHeader("Upgrade") != "" ||
+			!acceptsGzip(ctx.GetHeader("Accept-Encoding")) {
+			ctx.Next()
+			return
+		}
+
+		writer := &gzipWriter{ResponseWriter: ctx.Writer}
+		ctx.Writer = writer
+
+		defer writer.close()
+
+		ctx.Next()
+	}
+}
+
+// acceptsGzip indicates whether the Accept-Encoding header of a request
+// allows gzip, either explicitly or with a wildcard.
+func acceptsGzip(header string) bool {
+	for _, item := range strings.Split(header, ",") {
+		parts := strings.Split(item, ";")
+
+		coding := strings.ToLower(strings.TrimSpace(parts[0]))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+
+		accepted := true
+		for _, param := range parts[1:] {
+			param = strings.ReplaceAll(param, " ", "")
+			if strings.HasPrefix(param, "q=") {
+				accepted = strings.Trim(strings.TrimPrefix(param, "q="), "0.") != ""
+			}
+		}
+
+		return accepted
+	}
+
+	return false
+}
+
+// gzipWriter buffers the body of the response until it is known whether it
+// is compressed, and compresses it if so.
+type gzipWriter struct {
+	gin.ResponseWriter
+
+	buffer  []byte
+	decided bool
+	gz      *gzip.Writer // nil if the body is sent as is
+}
+
+func (w *gzipWriter) Write(data []byte) (int, error) {
+	if w.decided {
+		if w.gz != nil {
+			return w.gz.Write(data)
+		}
+
+		return w.ResponseWriter.Write(data)
+	}
+
+	w.buffer = append(w.buffer, data...)
+	if len(w.buffer) >= gzipMinSize {
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(data), nil
+}
+
+func (w *gzipWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush sends the buffered body, uncompressed if it is still below the
+// threshold.
+func (w *gzipWriter) Flush() {
+	if !w.decided {
+		_ = w.decide(false)
+	}
+
+	if w.gz != nil {
+		_ = w.gz.Flush()
+	}
+
+	w.ResponseWriter.Flush()
+}
+
+// decide sends the buffered body, compressed or not. Responses that already
+// have a Content-Encoding are never compressed.
+func (w *gzipWriter) decide(compress bool) error {
+	w.decided = true
+
+	header := w.Header()
+	if compress && header.Get("Content-Encoding") == "" {
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+
+	buffer := w.buffer
+	w.buffer = nil
+
+	if len(buffer) == 0 {
+		return nil
+	}
+
+	_, err := w.Write(buffer)
+	return err
+}
+
+// close sends the rest of the body, once the handlers are done.
+func (w *gzipWriter) close() {
+	if !w.decided {
+		_ = w.decide(false)
+	}
+
+	if w.gz != nil {
+		_ = w.gz.Close()
+	}
+}
@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"time"
+
+	"github.com/ledgerhq/satstack/redact"
+	"github.com/ledgerhq/satstack/utils"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// RequestIDHeader is the HTTP header carrying the ID of a request.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds the length of request IDs supplied by clients.
+const maxRequestIDLength = 128
+
+// RequestID is a gin middleware (factory) that assigns an ID to every
+// request, and attaches it to the context of the request. The ID supplied by
+// the client in the X-Request-ID header is used if valid, and a random one
+// otherwise. It is echoed back in the response header.
+func RequestID() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id := ctx.GetHeader(RequestIDHeader)
+		if !isValidRequestID(id) {
+			id = newRequestID()
+		}
+
+		ctx.Request = ctx.Request.WithContext(
+			utils.WithRequestID(ctx.Request.Context(), id))
+		ctx.Header(RequestIDHeader, id)
+
+		ctx.Next()
+	}
+}
+
+// AccessLog is a gin middleware (factory) that logs every request once
+// served, along with its ID, latency, status code, route and client IP. It
+// replaces the default logger of gin, so that access logs honor the log
+// format. The client IP is determined as by RateLimit. The addresses and
+// txids in the path are redacted; see redact.Path.
+func AccessLog(trustedProxies []*net.IPNet) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		start := time.Now()
+
+		ctx.Next()
+
+		route := ctx.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		entry := utils.Logger(ctx.Request.Context()).WithFields(log.Fields{
+			"prefix":   "http",
+			"method":   ctx.Request.Method,
+			"path":     redact.Path(ctx.Request.URL.Path),
+			"route":    route,
+			"status":   ctx.Writer.Status(),
+			"latency":  time.Since(start).String(),
+			"clientIP": clientIP(ctx.Request, trustedProxies),
+		})
+
+		if len(ctx.Errors) > 0 {
+			entry = entry.WithField("error", ctx.Errors.String())
+		}
+
+		entry.Info("Request served")
+	}
+}
+
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	// Restrict to printable ASCII, to prevent log injection.
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newRequestID() string {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(raw)
+}
@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ledgerhq/satstack/metrics"
+)
+
+// Metrics is a gin middleware (factory) that records the latency of every
+// request in the HTTP request duration histogram, labeled by route.
+func Metrics() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		start := time.Now()
+
+		ctx.Next()
+
+		// Use the route template rather than the raw path, to keep the
+		// cardinality of the route label bounded.
+		route := ctx.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		metrics.HTTPRequestDuration.WithLabelValues(
+			ctx.Request.Method,
+			route,
+			strconv.Itoa(ctx.Writer.Status()),
+		).Observe(time.Since(start).Seconds())
+	}
+}
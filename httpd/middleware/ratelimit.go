@@ -0,0 +1,148 @@
+package middleware
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ledgerhq/satstack/config"
+	"github.com/ledgerhq/satstack/httpd/apierror"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bucketIdleTimeout is the duration after which the bucket of a client that
+// stopped sending requests is discarded. By then, the bucket is full again,
+// so discarding it does not change the limits.
+const bucketIdleTimeout = 10 * time.Minute
+
+// RateLimit is a gin middleware (factory) that limits the rate of requests
+// of each client IP with a token bucket, and rejects requests beyond the
+// limit with a 429 and a Retry-After header.
+//
+// The X-Forwarded-For header is only honored for requests coming from one
+// of the trusted proxies.
+func RateLimit(limit config.RateLimit, trustedProxies []*net.IPNet) gin.HandlerFunc {
+	limiter := newRateLimiter(limit)
+
+	return func(ctx *gin.Context) {
+		wait := limiter.take(clientIP(ctx.Request, trustedProxies), time.Now())
+		if wait > 0 {
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			ctx.Header("Retry-After", strconv.Itoa(retryAfter))
+			apierror.Abort(ctx, apierror.New(apierror.RateLimited, "rate limit exceeded"))
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+type rateLimiter struct {
+	rate  float64 // tokens per second
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(limit config.RateLimit) *rateLimiter {
+	burst := float64(limit.Burst)
+	if burst <= 0 {
+		burst = math.Max(limit.RPS, 1)
+	}
+
+	return &rateLimiter{
+		rate:    limit.RPS,
+		burst:   burst,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// take consumes a token from the bucket of the key. It returns 0 on success,
+// and otherwise the duration after which a token will be available.
+func (l *rateLimiter) take(key string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > bucketIdleTimeout {
+		for k, b := range l.buckets {
+			if now.Sub(b.last) > bucketIdleTimeout {
+				delete(l.buckets, k)
+			}
+		}
+
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+
+	return time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// clientIP returns the IP address of the client. If the request comes from a
+// trusted proxy, the X-Forwarded-For header is walked from the right, and the
+// first address that is not a trusted proxy is used.
+func clientIP(r *http.Request, trustedProxies []*net.IPNet) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+
+	if !isTrusted(remote, trustedProxies) {
+		return remote
+	}
+
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for idx := len(forwarded) - 1; idx >= 0; idx-- {
+		ip := strings.TrimSpace(forwarded[idx])
+		if ip == "" {
+			continue
+		}
+
+		if !isTrusted(ip, trustedProxies) {
+			return ip
+		}
+
+		remote = ip
+	}
+
+	return remote
+}
+
+func isTrusted(ip string, trustedProxies []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	for _, network := range trustedProxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/ledgerhq/satstack/bus"
+	"github.com/ledgerhq/satstack/httpd/apierror"
+	"github.com/ledgerhq/satstack/httpd/svc"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Bounds of the Retry-After delay of the requests rejected by Synced,
+	// and the delay used when the time remaining is unknown, for ex before
+	// the rescan has started.
+	minRetryAfter     = 10 * time.Second
+	maxRetryAfter     = time.Hour
+	defaultRetryAfter = time.Minute
+)
+
+// Synced is a gin middleware (factory) that rejects requests with a 503 until
+// the Status is Ready, for ex while bitcoind is syncing, or the wallet is about
+// to scan or scanning, since the wallet then answers with incomplete
+// transaction histories, which Ledger Live would keep. The body contains the
+// current Status, along with the ExplorerStatus, and the Retry-After header
+// the estimated time remaining.
+//
+// Like Available, it reads the Status from the cache, so that the gate opens
+// as soon as the worker reports the Ready Status.
+func Synced(s svc.ExplorerService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		status, detail := s.GetReadiness()
+		if status == bus.Ready {
+			ctx.Next()
+			return
+		}
+
+		report := s.GetCachedStatus()
+		ctx.Header("Retry-After", strconv.Itoa(int(retryAfter(report).Seconds())))
+
+		err := apierror.Unready(apierror.NotReady, status, detail)
+		if report != nil {
+			err = err.With("explorer_status", report)
+		}
+
+		apierror.Abort(ctx, err)
+	}
+}
+
+// retryAfter returns the delay after which to retry a request rejected by
+// Synced, from the time remaining of the sync or the scan.
+func retryAfter(report *bus.ExplorerStatus) time.Duration {
+	var eta *int64
+	if report != nil {
+		switch report.Status {
+		case bus.Syncing:
+			eta = report.SyncETASeconds
+		case bus.Scanning:
+			eta = report.ScanETASeconds
+		}
+	}
+
+	if eta == nil {
+		return defaultRetryAfter
+	}
+
+	switch delay := time.Duration(*eta) * time.Second; {
+	case delay < minRetryAfter:
+		return minRetryAfter
+	case delay > maxRetryAfter:
+		return maxRetryAfter
+	default:
+		return delay
+	}
+}
@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ledgerhq/satstack/bus"
+	"github.com/ledgerhq/satstack/httpd/apierror"
+	"github.com/ledgerhq/satstack/httpd/svc"
+
+	"github.com/gin-gonic/gin"
+)
+
+// statusService is an ExplorerService reporting the given Status, as cached
+// by the worker.
+type statusService struct {
+	svc.ExplorerService
+	report bus.ExplorerStatus
+}
+
+func (s *statusService) GetReadiness() (bus.Status, string) {
+	return s.report.Status, s.report.StatusDetail
+}
+
+func (s *statusService) GetCachedStatus() *bus.ExplorerStatus {
+	report := s.report
+	return &report
+}
+
+func newSyncedEngine(s svc.ExplorerService) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	engine := gin.New()
+	engine.GET("/addresses", Synced(s), func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, []string{})
+	})
+
+	return engine
+}
+
+func TestSynced(t *testing.T) {
+	eta := int64(300)
+
+	tests := []struct {
+		report     bus.ExplorerStatus
+		retryAfter time.Duration
+	}{
+		{bus.ExplorerStatus{Status: bus.Initializing}, defaultRetryAfter},
+		{bus.ExplorerStatus{Status: bus.Initializing, StatusDetail: "Loading block index..."}, defaultRetryAfter},
+		{bus.ExplorerStatus{Status: bus.NodeDisconnected}, defaultRetryAfter},
+		{bus.ExplorerStatus{Status: bus.Syncing, SyncETASeconds: &eta}, 5 * time.Minute},
+		{bus.ExplorerStatus{Status: bus.Syncing}, defaultRetryAfter},
+		{bus.ExplorerStatus{Status: bus.PendingScan}, defaultRetryAfter},
+		{bus.ExplorerStatus{Status: bus.Scanning, ScanETASeconds: &eta}, 5 * time.Minute},
+	}
+
+	tested := map[bus.Status]bool{bus.Ready: true}
+
+	for _, test := range tests {
+		status := test.report.Status
+		tested[status] = true
+
+		w := get(newSyncedEngine(&statusService{report: test.report}), "/addresses", "")
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s: got status %d, want %d", status, w.Code, http.StatusServiceUnavailable)
+			continue
+		}
+
+		want := strconv.Itoa(int(test.retryAfter.Seconds()))
+		if got := w.Header().Get("Retry-After"); got != want {
+			t.Errorf("%s: got Retry-After %q, want %q", status, got, want)
+		}
+
+		var body struct {
+			Code           apierror.Code       `json:"code"`
+			Status         bus.Status          `json:"status"`
+			ExplorerStatus *bus.ExplorerStatus `json:"explorer_status"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Code != apierror.NotReady ||
+			body.Status != status || body.ExplorerStatus == nil {
+			t.Errorf("%s: got body %s, want a %s error with the status", status, w.Body.String(), apierror.NotReady)
+		}
+	}
+
+	for _, status := range bus.Statuses {
+		if !tested[status] {
+			t.Errorf("%s: not tested", status)
+		}
+	}
+}
+
+// The gate opening when the worker refreshes the Status of a Bus is tested
+// by TestSyncedGate, in the bus package.
+
+func TestRetryAfter(t *testing.T) {
+	seconds := func(v int64) *int64 { return &v }
+
+	tests := []struct {
+		report *bus.ExplorerStatus
+		want   time.Duration
+	}{
+		{nil, defaultRetryAfter},
+		{&bus.ExplorerStatus{Status: bus.Syncing, SyncETASeconds: seconds(1)}, minRetryAfter},
+		{&bus.ExplorerStatus{Status: bus.Syncing, SyncETASeconds: seconds(86400)}, maxRetryAfter},
+		{&bus.ExplorerStatus{Status: bus.Scanning, ScanETASeconds: seconds(120)}, 2 * time.Minute},
+		// The ETA of the sync does not apply to the scan.
+		{&bus.ExplorerStatus{Status: bus.Scanning, SyncETASeconds: seconds(120)}, defaultRetryAfter},
+	}
+
+	for _, test := range tests {
+		if got := retryAfter(test.report); got != test.want {
+			t.Errorf("%+v: got %v, want %v", test.report, got, test.want)
+		}
+	}
+}
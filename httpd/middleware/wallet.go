@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"github.com/ledgerhq/satstack/bus"
+	"github.com/ledgerhq/satstack/httpd/apierror"
+	"github.com/ledgerhq/satstack/httpd/svc"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WalletEnabled is a gin middleware (factory) that rejects requests with a
+// 501 while no accounts are configured, since the routes it guards are served
+// from the wallet, which then tracks no transactions. An empty success would
+// be mistaken for addresses without history.
+func WalletEnabled(s svc.AddressesService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !s.WalletEnabled() {
+			apierror.Abort(ctx, apierror.From(bus.ErrNoAccounts, apierror.NotFound))
+			return
+		}
+
+		ctx.Next()
+	}
+}
@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/ledgerhq/satstack/httpd/apierror"
+	"github.com/ledgerhq/satstack/httpd/svc"
+
+	"github.com/gin-gonic/gin"
+)
+
+// walletService is an AddressesService whose wallet is enabled on demand.
+type walletService struct {
+	svc.AddressesService
+	enabled bool
+}
+
+func (s *walletService) WalletEnabled() bool {
+	return s.enabled
+}
+
+func TestWalletEnabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	s := &walletService{}
+
+	engine := gin.New()
+	engine.GET("/addresses", WalletEnabled(s), func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, []string{})
+	})
+
+	w := get(engine, "/addresses", "")
+	if w.Code != http.StatusNotImplemented {
+		t.Fatalf("got status %d without accounts, want %d", w.Code, http.StatusNotImplemented)
+	}
+
+	var body struct {
+		Code apierror.Code `json:"code"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Code != apierror.NoAccounts {
+		t.Errorf("got body %s, want a %s error", w.Body.String(), apierror.NoAccounts)
+	}
+
+	// Accounts are added at runtime.
+	s.enabled = true
+
+	if w := get(engine, "/addresses", ""); w.Code != http.StatusOK {
+		t.Errorf("got status %d with accounts, want %d", w.Code, http.StatusOK)
+	}
+}
@@ -1,53 +1,267 @@
 package httpd
 
 import (
+	"net"
+	"strings"
+
+	"github.com/ledgerhq/satstack/config"
+	"github.com/ledgerhq/satstack/httpd/apierror"
 	"github.com/ledgerhq/satstack/httpd/handlers"
+	"github.com/ledgerhq/satstack/httpd/middleware"
 	"github.com/ledgerhq/satstack/httpd/svc"
+	"github.com/ledgerhq/satstack/httpd/ws"
+	"github.com/ledgerhq/satstack/metrics"
 
 	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
 )
 
-func GetRouter(s *svc.Service) *gin.Engine {
-	engine := gin.Default()
+// Chain is a chain served by SatStack, with the service and the WebSocket
+// hub of its node.
+type Chain struct {
+	Name    string // prefix of the routes of the chain
+	Service *svc.Service
+	Hub     *ws.Hub
+}
+
+// GetRouter returns the router of the HTTP API. The routes of each chain are
+// mounted under its name, and the routes of the first chain, which is the
+// default one, are also mounted without prefix.
+//
+// The settings of the HTTP server are read from the configuration of the
+// default chain.
+func GetRouter(chains []Chain, configuration *config.Configuration) *gin.Engine {
+	// Trusted proxies have already been validated when loading the config.
+	trustedProxies, _ := configuration.TrustedProxyNets()
+
+	engine := gin.New()
+	engine.Use(
+		middleware.RequestID(),
+		middleware.AccessLog(trustedProxies),
+		gin.Recovery(),
+	)
+
+	if !configuration.NoCompression {
+		engine.Use(middleware.Gzip())
+	}
+
+	if len(configuration.AllowedOrigins) > 0 {
+		engine.Use(middleware.CORS(configuration.AllowedOrigins, engine.Routes))
+	}
+
+	// Authenticate before rate limiting, so that unauthenticated requests are
+	// rejected as cheaply as possible.
+	if configuration.Auth != nil {
+		engine.Use(middleware.Auth(*configuration.Auth))
+	}
+
+	if configuration.Metrics {
+		engine.Use(middleware.Metrics())
+		engine.GET("metrics", gin.WrapH(metrics.Handler()))
+
+		for _, chain := range chains {
+			b := chain.Service.Bus
+
+			metrics.RegisterCacheSize(chain.Name, "transactions", func() float64 {
+				return float64(b.CacheSize())
+			})
+			metrics.RegisterCacheSize(chain.Name, "blocks", func() float64 {
+				return float64(b.BlockCacheSize())
+			})
+			metrics.RegisterCacheSize(chain.Name, "headers", func() float64 {
+				return float64(b.HeaderCacheSize())
+			})
+		}
+	}
+
+	limits := routeLimits{
+		status:   rateLimit(configuration.RateLimitStatus, trustedProxies),
+		explorer: rateLimit(configuration.RateLimitExplorer, trustedProxies),
+	}
 
 	engine.GET("timestamp", handlers.GetTimestamp())
 
+	// Status of every chain, keyed by name.
+	names := make([]string, len(chains))
+	services := make([]svc.ExplorerService, len(chains))
+	for idx, chain := range chains {
+		names[idx] = chain.Name
+		services[idx] = chain.Service
+	}
+
+	engine.GET("status/all", append(limits.status, handlers.GetStatuses(names, services))...)
+
+	registerChain(engine, chains[0], configuration, limits)
+	for _, chain := range chains {
+		registerChain(engine.Group(chain.Name), chain, configuration, limits)
+
+		if configuration.DevMode && chain.Service.Bus.Chain != "regtest" {
+			log.WithFields(log.Fields{
+				"name":  chain.Name,
+				"chain": chain.Service.Bus.Chain,
+			}).Warn("Ignoring dev_mode: chain is not regtest")
+		}
+	}
+
+	engine.NoRoute(func(ctx *gin.Context) {
+		apierror.Abort(ctx, apierror.New(apierror.NotFound, "unknown route %s", ctx.Request.URL.Path))
+	})
+
+	return engine
+}
+
+// routeLimits holds the rate limiting middlewares, shared by the routes of
+// all the chains.
+type routeLimits struct {
+	status   []gin.HandlerFunc
+	explorer []gin.HandlerFunc
+}
+
+// registerChain mounts the routes served by the node of the chain on the
+// given router.
+func registerChain(router gin.IRouter, chain Chain, configuration *config.Configuration, limits routeLimits) {
+	s := chain.Service
+	statusLimit := limits.status
+	explorerLimit := limits.explorer
+
+	// Stream of new blocks and wallet transactions.
+	router.GET("ws", chain.Hub.Handler())
+
+	// Probes for process supervisors (for ex, Kubernetes or systemd).
+	probesRouter := router.Group("", statusLimit...)
+	{
+		probesRouter.GET("healthz", handlers.GetLiveness())
+		probesRouter.GET("readyz", handlers.GetReadiness(s))
+		probesRouter.GET("status", handlers.GetStatus(s))
+	}
+
+	// Stream of the sync and scan progress, for ex during the initial import
+	// of descriptors.
+	router.GET("status/stream", append(statusLimit, handlers.StreamStatus(s))...)
+
 	// controlRouter exposes endpoints that can be used to programmatically
 	// control SatStack (for ex, from Ledger Live).
-	controlRouter := engine.Group("control")
+	controlRouter := router.Group("control", middleware.Available(s))
 	{
 		controlRouter.GET("descriptors/import", handlers.ImportAccounts(s))
 		controlRouter.POST("descriptors/has", handlers.HasDescriptor(s))
+		controlRouter.POST("reload", handlers.ReloadAccounts(s))
+		controlRouter.POST("rescan", handlers.Rescan(s))
+		controlRouter.DELETE("rescan", handlers.StopRescan(s))
+
+		// Accounts added at runtime are persisted to the config file.
+		if configuration.ControlAPI {
+			controlRouter.POST("accounts", handlers.AddAccount(s))
+		}
+
+		if !configuration.NoDescriptorList {
+			controlRouter.GET("descriptors", handlers.ListDescriptors(s))
+		}
+	}
+
+	// The RPC stats and the self-test are served while bitcoind is
+	// unavailable too, since they help diagnose why.
+	router.GET("control/rpc-stats", handlers.RPCStats(s))
+	router.DELETE("control/rpc-stats", handlers.ResetRPCStats(s))
+	router.GET("control/selftest", handlers.SelfTest(s))
+
+	// regtestRouter exposes endpoints to mine and fund addresses, for
+	// integration tests. They are never registered on other chains.
+	if configuration.DevMode && s.Bus.Chain == "regtest" {
+		regtestRouter := router.Group("regtest", middleware.Available(s))
+		{
+			regtestRouter.POST("generate", handlers.Generate(s))
+			regtestRouter.POST("fund", handlers.Fund(s))
+		}
 	}
 
 	// We support both Ledger Blockchain Explorer v2 and v3. The version here
 	// is irrelevant.
-	baseRouter := engine.Group("blockchain/:version")
+	baseRouter := router.Group("blockchain/:version")
+
+	explorerStatusRouter := baseRouter.Group("explorer", statusLimit...)
 	{
-		baseRouter.GET("explorer/_health", handlers.GetHealth(s))
-		baseRouter.GET("explorer/status", handlers.GetStatus(s))
+		explorerStatusRouter.GET("_health", handlers.GetHealth(s))
+		explorerStatusRouter.GET("status", handlers.GetStatus(s))
 	}
 
-	currencyRouter := baseRouter.Group(s.Bus.Currency)
+	currencyRouter := baseRouter.Group(s.Bus.Currency,
+		append(explorerLimit, middleware.Available(s))...)
 	{
 		currencyRouter.GET("fees", handlers.GetFees(s))
 	}
 
-	blocksRouter := currencyRouter.Group("/blocks")
+	// The mempool is not specific to the currency of the Ledger explorer.
+	baseRouter.GET("mempool",
+		append(explorerLimit, middleware.Available(s), handlers.GetMempool(s))...)
+
+	// Conditional requests are answered with a 304 while the chain tip, and
+	// the unconfirmed transactions of the addresses, are unchanged.
+	var chainETag, addressesETag []gin.HandlerFunc
+	if !configuration.NoETags {
+		chainETag = []gin.HandlerFunc{middleware.ETag(func(*gin.Context) (string, bool) {
+			return s.ChainVersion()
+		})}
+		addressesETag = []gin.HandlerFunc{middleware.ETag(func(ctx *gin.Context) (string, bool) {
+			return s.AddressesVersion(strings.Split(ctx.Param("addresses"), ","))
+		})}
+	}
+
+	blocksRouter := currencyRouter.Group("/blocks", chainETag...)
 	{
 		blocksRouter.GET(":block", handlers.GetBlock(s))
+		blocksRouter.GET(":block/transactions", handlers.GetBlockTransactions(s))
+		blocksRouter.GET(":block/header", handlers.GetBlockHeader(s))
+		blocksRouter.GET(":block/stats", handlers.GetBlockStats(s))
+	}
+
+	// The path of blocks by time does not include the currency, since it
+	// would conflict with the path of blocks by reference.
+	blocksByTimeRouter := baseRouter.Group("blocks",
+		append(explorerLimit, middleware.Available(s))...)
+	{
+		blocksByTimeRouter.GET("by-time/:timestamp", handlers.GetBlockAtTime(s))
+	}
+
+	// Unlike the hex endpoint of the Ledger explorer, the response is not
+	// wrapped in a list.
+	rawTransactionsRouter := baseRouter.Group("transactions",
+		append(explorerLimit, middleware.Available(s))...)
+	{
+		rawTransactionsRouter.GET(":hash/hex", handlers.GetRawTransactionHex(s))
+		rawTransactionsRouter.GET(":hash/mempool", handlers.GetMempoolTransaction(s))
 	}
 
 	transactionsRouter := currencyRouter.Group("/transactions")
 	{
 		transactionsRouter.GET(":hash/hex", handlers.GetTransactionHex(s))
 		transactionsRouter.POST("send", handlers.SendTransaction(s))
+		transactionsRouter.POST("batch", handlers.SendTransactions(s))
 	}
 
-	addressesRouter := currencyRouter.Group("/addresses")
+	// The addresses are looked up in the wallet, which tracks the
+	// transactions of the configured accounts only, and whose histories are
+	// incomplete until bitcoind is synced and the accounts scanned.
+	addressesGates := []gin.HandlerFunc{middleware.WalletEnabled(s)}
+	if !configuration.ServeDuringSync {
+		addressesGates = append(addressesGates, middleware.Synced(s))
+	}
+
+	addressesRouter := currencyRouter.Group("/addresses", addressesGates...)
 	{
-		addressesRouter.GET(":addresses/transactions", handlers.GetAddresses(s))
+		addressesRouter.GET(":addresses/transactions",
+			append(addressesETag, handlers.GetAddresses(s))...)
+		addressesRouter.GET(":addresses/utxos", handlers.GetUTXOs(s))
+		addressesRouter.GET(":addresses/balance", handlers.GetBalances(s))
+	}
+}
+
+// rateLimit returns the rate limiting middleware for the given config, if
+// any, to be used as the handlers of a route group.
+func rateLimit(limit *config.RateLimit, trustedProxies []*net.IPNet) []gin.HandlerFunc {
+	if limit == nil {
+		return nil
 	}
 
-	return engine
+	return []gin.HandlerFunc{middleware.RateLimit(*limit, trustedProxies)}
 }
@@ -1,95 +1,507 @@
 package svc
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/ledgerhq/satstack/redact"
 	"github.com/ledgerhq/satstack/types"
 	"github.com/ledgerhq/satstack/utils"
 
 	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcutil"
 
 	log "github.com/sirupsen/logrus"
 )
 
-func (s *Service) GetAddresses(addresses []string, blockHash *string) (types.Addresses, error) {
-	// Cache the results of GetTransaction calls against the TxID. The avoids
-	// wasteful querying of the Bitcoin node for the same TxID, within the
-	// lifecycle of this function invocation.
-	s.Bus.NewCache()
-	defer s.Bus.FlushCache()
+// GetAddresses is a service method to get the transactions of the given
+// addresses, confirmed after the block referenced by blockHash (if any).
+//
+// Each transaction is listed once, even if it involves several of the
+// addresses, and the transactions are sorted as by sortTransactions.
+//
+// If batchSize is positive, the result is paginated: only whole blocks are
+// included until at least batchSize transactions are collected. When more
+// pages are available, the hash of the last included block is returned as
+// the cursor for the next page. Unconfirmed transactions only appear on the
+// final page.
+func (s *Service) GetAddresses(ctx context.Context, addresses []string, blockHash *string, batchSize int) (types.Addresses, error) {
+	page, err := s.GetAddressTransactions(ctx, addresses, blockHash, batchSize)
+	if err != nil {
+		return types.Addresses{}, err
+	}
 
-	blockchainInfo, err := s.Bus.GetBlockChainInfo()
+	txs := []types.Transaction{}
+	err = page.Each(ctx, func(tx *types.Transaction) error {
+		txs = append(txs, *tx)
+		return nil
+	})
 	if err != nil {
 		return types.Addresses{}, err
 	}
 
-	txResults, err := s.Bus.ListTransactions(blockHash)
+	return types.Addresses{
+		Truncated:    page.Token != nil,
+		Transactions: txs,
+		Token:        page.Token,
+	}, nil
+}
+
+// AddressTransactions is a page of the transactions of a list of addresses,
+// which are built one at a time by Each, so that large pages can be
+// streamed rather than held in memory.
+type AddressTransactions struct {
+	// Token is the cursor of the next page, if more pages are available.
+	Token *string
+
+	service         *Service
+	walletTxs       []btcjson.ListTransactionsResult
+	bestBlockHeight int32
+}
+
+// GetAddressTransactions is a service method to select the transactions of
+// the given addresses, like GetAddresses, without building them yet.
+func (s *Service) GetAddressTransactions(
+	ctx context.Context, addresses []string, blockHash *string, batchSize int,
+) (*AddressTransactions, error) {
+	// All the transactions of the response are confirmed against the same
+	// chain tip, served from the cache of the Bus.
+	bestBlockHeight, err := s.Bus.GetBestBlockHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	txResults, err := s.Bus.ListAddressTransactions(ctx, addresses, blockHash)
 	if err != nil {
-		log.WithFields(log.Fields{
+		utils.Logger(ctx).WithFields(log.Fields{
 			"error":     err,
 			"blockHash": nil,
 		}).Error("Unable to fetch transaction")
 	}
-	walletTxs := s.filterTransactionsByAddresses(addresses, txResults, blockchainInfo.Headers)
+	walletTxs := s.filterTransactionsByAddresses(ctx, addresses, txResults, int32(bestBlockHeight))
+	sortTransactions(walletTxs, s.Bus.FirstSeen)
+
+	var token *string
+	if batchSize > 0 {
+		walletTxs, token = paginateTransactions(walletTxs, batchSize)
+	}
+
+	return &AddressTransactions{
+		Token:           token,
+		service:         s,
+		walletTxs:       walletTxs,
+		bestBlockHeight: int32(bestBlockHeight),
+	}, nil
+}
+
+// Each builds the transactions of the page, and calls fn with each of them.
+// The transactions that cannot be built are logged and skipped. It stops at
+// the first error returned by fn, or once ctx is done, and returns it.
+func (p *AddressTransactions) Each(ctx context.Context, fn func(tx *types.Transaction) error) error {
+	s := p.service
+
+	for _, txn := range p.walletTxs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 
-	txs := []types.Transaction{}
-	for _, txn := range walletTxs {
 		block := blockFromTxResult(txn)
-		tx, err := s.GetTransaction(txn.TxID, block, blockchainInfo.Headers)
+		tx, err := s.GetTransaction(ctx, txn.TxID, block, p.bestBlockHeight)
 		if err != nil {
-			log.WithFields(log.Fields{
+			utils.Logger(ctx).WithFields(log.Fields{
 				"error": err,
-				"hash":  txn.TxID,
+				"hash":  redact.TxID(txn.TxID),
 			}).Error("Unable to fetch transaction")
-
-			s.Bus.Cache.Delete(txn.TxID)
 			continue
 		}
 
 		// Be defensive here with the retrieved transaction, to avoid
 		// nil pointer dereference.
-		if tx != nil {
-			txs = append(txs, *tx)
+		if tx == nil {
+			continue
+		}
+
+		if txn.BlockHash == "" {
+			s.addMempoolInfo(ctx, tx, p.bestBlockHeight)
+		}
+
+		if err := fn(tx); err != nil {
+			return err
 		}
 	}
 
-	return types.Addresses{
-		Truncated:    false,
-		Transactions: txs,
-	}, nil
+	return nil
+}
+
+// AddressesVersion is a service method to get a version of the transactions
+// of the addresses, to derive the ETag of the address transactions endpoint;
+// see bus.AddressesVersion.
+func (s *Service) AddressesVersion(addresses []string) (string, bool) {
+	return s.Bus.AddressesVersion(addresses)
+}
+
+// WalletEnabled is a service method to find whether accounts are
+// configured, which the wallet-dependent endpoints require.
+func (s *Service) WalletEnabled() bool {
+	return s.Bus.WalletEnabled()
+}
+
+// FilterAccountAddresses is a service method to restrict the given addresses
+// to the ones derived from the descriptors of the account, identified by its
+// external descriptor or its address, as configured.
+func (s *Service) FilterAccountAddresses(account string, addresses []string) ([]string, error) {
+	accountAddresses, err := s.Bus.AccountAddresses(account)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []string{}
+	for _, address := range addresses {
+		if accountAddresses[address] {
+			ret = append(ret, address)
+		}
+	}
+
+	return ret, nil
 }
 
+// GetUTXOs is a service method to get the unspent outputs of the given
+// addresses, including unconfirmed ones.
+//
+// Addresses that are not derived from an imported descriptor are invisible
+// to the wallet. They are reported in the warnings of the result, rather than
+// failing the request, and so are invalid addresses.
+func (s *Service) GetUTXOs(ctx context.Context, addresses []string) (types.AddressUTXOs, error) {
+	ret := types.AddressUTXOs{UTXOs: []types.UnspentOutput{}}
+
+	// Addresses known to the wallet, and whether they are change addresses.
+	known := make(map[string]bool)
+	var knownList []string
+
+	for _, address := range addresses {
+		if _, ok := known[address]; ok {
+			continue
+		}
+
+		info, err := s.Bus.GetWalletAddressInfo(ctx, address)
+
+		var rpcErr *btcjson.RPCError
+		switch {
+		case errors.As(err, &rpcErr) && rpcErr.Code == btcjson.ErrRPCInvalidAddressOrKey:
+			ret.Warnings = append(ret.Warnings,
+				fmt.Sprintf("invalid address '%s'", address))
+			continue
+		case err != nil:
+			return types.AddressUTXOs{}, err
+		case !info.IsMine && !info.IsWatchOnly:
+			ret.Warnings = append(ret.Warnings,
+				fmt.Sprintf("address '%s' does not belong to any imported descriptor", address))
+			continue
+		}
+
+		known[address] = info.IsChange
+		knownList = append(knownList, address)
+	}
+
+	if len(knownList) == 0 {
+		return ret, nil
+	}
+
+	utxos, err := s.Bus.ListUnspent(ctx, knownList)
+	if err != nil {
+		return types.AddressUTXOs{}, err
+	}
+
+	ret.UTXOs, ret.Total = unspentOutputs(ctx, utxos, known, s.Bus.Derivation)
+
+	return ret, nil
+}
+
+// unspentOutputs converts the unspent outputs listed by the wallet, flagged
+// as change by the given addresses, and labeled with the account of the
+// derivation of their address, if any. The outputs whose value cannot be
+// parsed are skipped. Their total value is returned along with them.
+func unspentOutputs(
+	ctx context.Context, utxos []btcjson.ListUnspentResult, change map[string]bool,
+	derivation func(address string) (types.Derivation, bool),
+) ([]types.UnspentOutput, btcutil.Amount) {
+	ret := []types.UnspentOutput{}
+	var total btcutil.Amount
+
+	for _, utxo := range utxos {
+		value, err := utils.ParseSatoshi(utxo.Amount)
+		if err != nil {
+			utils.Logger(ctx).WithFields(log.Fields{
+				"error": err,
+				"txid":  redact.TxID(utxo.TxID),
+				"vout":  utxo.Vout,
+			}).Error("Unable to parse UTXO value")
+			continue
+		}
+
+		output := types.UnspentOutput{
+			TxID:          utxo.TxID,
+			Vout:          utxo.Vout,
+			Value:         value,
+			Confirmations: utxo.Confirmations,
+			ScriptHex:     utxo.ScriptPubKey,
+			Address:       utxo.Address,
+			Change:        change[utxo.Address],
+		}
+
+		if derivation, ok := derivation(utxo.Address); ok {
+			output.Label = derivation.Label
+		}
+
+		ret = append(ret, output)
+
+		total += value
+	}
+
+	return ret, total
+}
+
+// GetBalances is a service method to get the balances of the given
+// addresses, and their aggregate.
+//
+// The current balance of an address is the sum of its unspent outputs,
+// including unconfirmed ones. The transactions used to compute the received
+// amounts and the unconfirmed changes are selected in the same way as for
+// GetAddresses, so that both never disagree.
+func (s *Service) GetBalances(ctx context.Context, addresses []string) (types.AddressBalances, error) {
+	balances := make(map[string]*types.AddressBalance)
+	var addressList []string
+
+	for _, address := range addresses {
+		if _, ok := balances[address]; !ok {
+			balances[address] = &types.AddressBalance{}
+			addressList = append(addressList, address)
+		}
+	}
+
+	bestBlockHeight, err := s.Bus.GetBestBlockHeight()
+	if err != nil {
+		return types.AddressBalances{}, err
+	}
+
+	utxos, err := s.Bus.ListUnspent(ctx, addressList)
+	if err != nil {
+		return types.AddressBalances{}, err
+	}
+
+	// Start with the current balance, from which the unconfirmed change is
+	// subtracted afterwards.
+	for _, utxo := range utxos {
+		value, err := utils.ParseSatoshi(utxo.Amount)
+		if err != nil {
+			return types.AddressBalances{}, err
+		}
+
+		if balance, ok := balances[utxo.Address]; ok {
+			balance.Confirmed += value
+		}
+	}
+
+	txResults, err := s.Bus.ListAddressTransactions(ctx, addressList, nil)
+	if err != nil {
+		return types.AddressBalances{}, err
+	}
+
+	// Conflicted transactions never affect the balance.
+	var walletTxs []btcjson.ListTransactionsResult
+	for _, txn := range txResults {
+		if txn.Confirmations >= 0 {
+			walletTxs = append(walletTxs, txn)
+		}
+	}
+
+	for _, txn := range s.filterTransactionsByAddresses(ctx, addressList, walletTxs, int32(bestBlockHeight)) {
+		tx, err := s.GetTransaction(ctx, txn.TxID, blockFromTxResult(txn), int32(bestBlockHeight))
+		if err != nil {
+			return types.AddressBalances{}, err
+		}
+
+		unconfirmed := txn.BlockHash == ""
+
+		for _, output := range tx.Outputs {
+			if balance, ok := balances[output.Address]; ok && output.Value != nil {
+				balance.Received += *output.Value
+				if unconfirmed {
+					balance.Unconfirmed += *output.Value
+				}
+			}
+		}
+
+		if !unconfirmed {
+			continue
+		}
+
+		for _, input := range tx.Inputs {
+			if balance, ok := balances[input.Address]; ok && input.Value != nil {
+				balance.Unconfirmed -= *input.Value
+			}
+		}
+	}
+
+	ret := types.AddressBalances{
+		Addresses: make(map[string]types.AddressBalance, len(balances)),
+	}
+
+	for address, balance := range balances {
+		balance.Confirmed -= balance.Unconfirmed
+
+		ret.Addresses[address] = *balance
+		ret.Total.Confirmed += balance.Confirmed
+		ret.Total.Unconfirmed += balance.Unconfirmed
+		ret.Total.Received += balance.Received
+	}
+
+	return ret, nil
+}
+
+// paginateTransactions returns the first page of at least batchSize of the
+// transactions, sorted by sortTransactions.
+//
+// A page never ends in the middle of a block, so that the hash of its last
+// block can be used as a start-after cursor. The returned cursor is nil if
+// the page is the final one.
+func paginateTransactions(
+	txs []btcjson.ListTransactionsResult, batchSize int,
+) ([]btcjson.ListTransactionsResult, *string) {
+	for idx := batchSize; idx < len(txs); idx++ {
+		// Unconfirmed transactions are sorted last, and have no block hash.
+		// They are therefore never split across pages.
+		if txs[idx].BlockHash != txs[idx-1].BlockHash {
+			return txs[:idx], utils.ToStringPointer(txs[idx-1].BlockHash)
+		}
+	}
+
+	return txs, nil
+}
+
+// sortTransactions sorts the wallet transactions in a stable order: the
+// confirmed transactions first, in ascending block height and position in
+// the block, then the unconfirmed ones, by the time SatStack first saw them,
+// as returned by firstSeen, or else at which the wallet received them. Ties
+// are broken by txid.
+func sortTransactions(txs []btcjson.ListTransactionsResult, firstSeen func(txid string) (int64, bool)) {
+	seen := func(tx btcjson.ListTransactionsResult) int64 {
+		if t, ok := firstSeen(tx.TxID); ok {
+			return t
+		}
+
+		return tx.TimeReceived
+	}
+
+	sort.Slice(txs, func(i, j int) bool {
+		a, b := txs[i], txs[j]
+
+		if ca, cb := a.BlockHash != "", b.BlockHash != ""; ca != cb {
+			return ca
+		}
+
+		if ha, hb := txHeight(a), txHeight(b); ha != hb {
+			return ha < hb
+		}
+
+		if a.BlockHash != "" {
+			if ia, ib := txBlockIndex(a), txBlockIndex(b); ia != ib {
+				return ia < ib
+			}
+		} else if ta, tb := seen(a), seen(b); ta != tb {
+			return ta < tb
+		}
+
+		return a.TxID < b.TxID
+	})
+}
+
+// txBlockIndex returns the position of a confirmed wallet transaction in its
+// block, or 0 if bitcoind did not report it.
+func txBlockIndex(tx btcjson.ListTransactionsResult) int64 {
+	if tx.BlockIndex == nil {
+		return 0
+	}
+
+	return *tx.BlockIndex
+}
+
+// txHeight returns the block height of a wallet transaction, for sorting
+// purposes. Unconfirmed transactions are sorted after confirmed ones.
+func txHeight(tx btcjson.ListTransactionsResult) int64 {
+	if tx.BlockHeight == nil || tx.BlockHash == "" {
+		return math.MaxInt64
+	}
+
+	return int64(*tx.BlockHeight)
+}
+
+// filterTransactionsByAddresses returns the wallet transactions involving
+// the given addresses, either as an output, or as an input of a transaction
+// sent by the wallet; see selectTransactions.
 func (s *Service) filterTransactionsByAddresses(
-	addresses []string, txs []btcjson.ListTransactionsResult, bestBlockHeight int32,
+	ctx context.Context, addresses []string, txs []btcjson.ListTransactionsResult, bestBlockHeight int32,
+) []btcjson.ListTransactionsResult {
+	return selectTransactions(addresses, txs, func(tx btcjson.ListTransactionsResult) ([]string, bool) {
+		tx2, err := s.GetTransaction(ctx, tx.TxID, blockFromTxResult(tx), bestBlockHeight)
+		if err != nil {
+			utils.Logger(ctx).WithFields(log.Fields{
+				"error":    err,
+				"hash":     redact.TxID(tx.TxID),
+				"category": tx.Category,
+			}).Error("Failed to get wallet transaction")
+
+			return nil, false
+		}
+
+		return getTransactionInputAddresses(*tx2), true
+	})
+}
+
+// selectTransactions returns the first entry of each wallet transaction
+// involving the given addresses, either as an output, or as an input of a
+// transaction sent by the wallet, in the order of the entries.
+//
+// The input addresses of the sent transactions are returned by
+// inputAddresses. If it fails, the entry is skipped.
+func selectTransactions(
+	addresses []string,
+	txs []btcjson.ListTransactionsResult,
+	inputAddresses func(tx btcjson.ListTransactionsResult) ([]string, bool),
 ) []btcjson.ListTransactionsResult {
 	var result []btcjson.ListTransactionsResult
-	var visited []string
 
-	for _, tx := range txs {
-		if tx.Category == "send" {
-			block := blockFromTxResult(tx)
-			tx2, err := s.GetTransaction(tx.TxID, block, bestBlockHeight)
-			if err != nil {
-				log.WithFields(log.Fields{
-					"error":    err,
-					"hash":     tx.TxID,
-					"category": tx.Category,
-				}).Error("Failed to get wallet transaction")
+	// Sets, since the lists of addresses and transactions may be large.
+	addressSet := make(map[string]bool, len(addresses))
+	for _, address := range addresses {
+		addressSet[address] = true
+	}
 
+	visited := make(map[string]bool)
+
+	for _, tx := range txs {
+		if tx.Category == "send" && !visited[tx.TxID] {
+			inputs, ok := inputAddresses(tx)
+			if !ok {
 				// abandon processing the current transaction
 				continue
 			}
 
-			for _, inputAddress := range getTransactionInputAddresses(*tx2) {
-				if utils.Contains(addresses, inputAddress) && !utils.Contains(visited, tx.TxID) {
+			for _, inputAddress := range inputs {
+				if addressSet[inputAddress] {
 					result = append(result, tx)
-					visited = append(visited, tx.TxID)
+					visited[tx.TxID] = true
 					break
 				}
 			}
 		}
 
-		if utils.Contains(addresses, tx.Address) && !utils.Contains(visited, tx.TxID) {
+		if addressSet[tx.Address] && !visited[tx.TxID] {
 			result = append(result, tx)
-			visited = append(visited, tx.TxID)
+			visited[tx.TxID] = true
 		}
 	}
 
@@ -0,0 +1,183 @@
+package svc
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/ledgerhq/satstack/types"
+
+	"github.com/btcsuite/btcd/btcjson"
+)
+
+// walletEntry returns a listsinceblock entry of a transaction, confirmed at
+// the given height and position in the block, or unconfirmed if height is
+// negative.
+func walletEntry(txid, category, address string, height int32, index int64) btcjson.ListTransactionsResult {
+	entry := btcjson.ListTransactionsResult{
+		TxID:     txid,
+		Category: category,
+		Address:  address,
+	}
+
+	if height >= 0 {
+		entry.BlockHash = "block" + string(rune('0'+height))
+		entry.BlockHeight = &height
+		entry.BlockIndex = &index
+	}
+
+	return entry
+}
+
+func txids(txs []btcjson.ListTransactionsResult) []string {
+	ret := []string{}
+	for _, tx := range txs {
+		ret = append(ret, tx.TxID)
+	}
+
+	return ret
+}
+
+func TestSelectTransactions(t *testing.T) {
+	txs := []btcjson.ListTransactionsResult{
+		// Self-transfer from A to A.
+		walletEntry("self", "send", "A", 1, 0),
+		walletEntry("self", "receive", "A", 1, 0),
+		// Paying A and B in different outputs.
+		walletEntry("both", "receive", "A", 2, 0),
+		walletEntry("both", "receive", "B", 2, 0),
+		// Sent from A to an external address.
+		walletEntry("external", "send", "X", 3, 0),
+		// Paying an address that is not queried.
+		walletEntry("other", "receive", "C", 3, 1),
+		// Sent, with inputs that cannot be resolved, and change to B.
+		walletEntry("unresolved", "send", "X", 4, 0),
+		walletEntry("unresolved", "receive", "B", 4, 0),
+	}
+
+	inputs := map[string][]string{
+		"self":     {"A"},
+		"external": {"A"},
+	}
+
+	lookups := make(map[string]int)
+	inputAddresses := func(tx btcjson.ListTransactionsResult) ([]string, bool) {
+		lookups[tx.TxID]++
+		addresses, ok := inputs[tx.TxID]
+		return addresses, ok
+	}
+
+	got := selectTransactions([]string{"A", "B", "A"}, txs, inputAddresses)
+
+	want := []string{"self", "both", "external", "unresolved"}
+	if !reflect.DeepEqual(txids(got), want) {
+		t.Fatalf("got transactions %v, want %v", txids(got), want)
+	}
+
+	if got[0].Category != "send" {
+		t.Errorf("got the %s entry of the self-transfer, want the first one", got[0].Category)
+	}
+
+	if got[1].Address != "A" {
+		t.Errorf("got the entry of %s for both addresses, want the first one", got[1].Address)
+	}
+
+	if lookups["self"] != 1 || lookups["both"] != 0 {
+		t.Errorf("got input lookups %v, want one per sent transaction", lookups)
+	}
+
+	if got := selectTransactions([]string{"B"}, txs, inputAddresses); !reflect.DeepEqual(txids(got), []string{"both", "unresolved"}) {
+		t.Errorf("got transactions %v of B, want the ones paying B", txids(got))
+	}
+}
+
+func TestSortTransactions(t *testing.T) {
+	mempool := func(txid string, received int64) btcjson.ListTransactionsResult {
+		entry := walletEntry(txid, "receive", "A", -1, 0)
+		entry.TimeReceived = received
+		return entry
+	}
+
+	txs := []btcjson.ListTransactionsResult{
+		mempool("m3", 100),
+		walletEntry("c3", "receive", "A", 2, 5),
+		mempool("m1", 300),
+		walletEntry("c1", "receive", "A", 1, 0),
+		mempool("m2", 200),
+		walletEntry("c2b", "receive", "A", 2, 1),
+		walletEntry("c2a", "receive", "A", 2, 1),
+		mempool("m0", 0),
+	}
+
+	// SatStack saw m1 before the wallet received m2 and m3, and m0 along
+	// with m2.
+	firstSeen := func(txid string) (int64, bool) {
+		seen, ok := map[string]int64{"m0": 200, "m1": 50}[txid]
+		return seen, ok
+	}
+
+	sortTransactions(txs, firstSeen)
+
+	want := []string{"c1", "c2a", "c2b", "c3", "m1", "m3", "m0", "m2"}
+	if !reflect.DeepEqual(txids(txs), want) {
+		t.Errorf("got transactions %v, want %v", txids(txs), want)
+	}
+}
+
+func TestSelectAndSortOverlappingAddresses(t *testing.T) {
+	// The entries of a transaction paying A and B are not consecutive once
+	// listed by address.
+	txs := []btcjson.ListTransactionsResult{
+		walletEntry("both", "receive", "B", 2, 3),
+		walletEntry("first", "receive", "A", 1, 0),
+		walletEntry("self", "send", "A", -1, 0),
+		walletEntry("both", "receive", "A", 2, 3),
+		walletEntry("self", "receive", "A", -1, 0),
+	}
+
+	inputAddresses := func(btcjson.ListTransactionsResult) ([]string, bool) {
+		return []string{"A"}, true
+	}
+
+	for _, addresses := range [][]string{{"A", "B"}, {"B", "A"}} {
+		got := selectTransactions(addresses, txs, inputAddresses)
+		sortTransactions(got, func(string) (int64, bool) { return 0, false })
+
+		if want := []string{"first", "both", "self"}; !reflect.DeepEqual(txids(got), want) {
+			t.Errorf("%v: got transactions %v, want %v", addresses, txids(got), want)
+		}
+	}
+}
+
+func TestUnspentOutputs(t *testing.T) {
+	utxos := []btcjson.ListUnspentResult{
+		{TxID: "received", Address: "A", Amount: 0.001, Confirmations: 3},
+		{TxID: "change", Address: "B", Amount: 0.0002},
+		{TxID: "unknown", Address: "C", Amount: 0.00000001},
+	}
+
+	derivations := map[string]types.Derivation{
+		"A": {Account: "wpkh(xpub/0/*)", Label: "savings"},
+		"B": {Account: "wpkh(xpub/0/*)", Label: "savings", Chain: "change"},
+	}
+
+	derivation := func(address string) (types.Derivation, bool) {
+		derivation, ok := derivations[address]
+		return derivation, ok
+	}
+
+	got, total := unspentOutputs(context.Background(), utxos, map[string]bool{"A": false, "B": true}, derivation)
+	if len(got) != 3 || total != 120001 {
+		t.Fatalf("got %d outputs, total %d, want 3 outputs of 120001 sat", len(got), total)
+	}
+
+	for idx, want := range []struct {
+		label  string
+		change bool
+	}{{"savings", false}, {"savings", true}, {"", false}} {
+		if got[idx].Label != want.label || got[idx].Change != want.change {
+			t.Errorf("%s: got label %q, change %v, want %q, %v",
+				got[idx].TxID, got[idx].Label, got[idx].Change, want.label, want.change)
+		}
+	}
+}
@@ -1,24 +1,28 @@
 package svc
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 	"strings"
 
+	"github.com/ledgerhq/satstack/bus"
+	"github.com/ledgerhq/satstack/redact"
 	"github.com/ledgerhq/satstack/types"
 	"github.com/ledgerhq/satstack/utils"
 
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	log "github.com/sirupsen/logrus"
 )
 
 // GetBlock is a service method to get a Block by a string reference
-func (s *Service) GetBlock(ref string) (*types.Block, error) {
-	rawBlockHash, err := s.getBlockHashByReference(ref)
+func (s *Service) GetBlock(ctx context.Context, ref string) (*types.Block, error) {
+	rawBlockHash, err := s.getBlockHashByReference(ctx, ref)
 	if err != nil {
 		return nil, err
 	}
 
-	block, err := s.Bus.GetBlock(rawBlockHash)
+	block, err := s.Bus.GetBlock(ctx, rawBlockHash)
 	if err != nil {
 		return nil, err
 	}
@@ -26,14 +30,253 @@ func (s *Service) GetBlock(ref string) (*types.Block, error) {
 	return block, nil
 }
 
-func (s *Service) getBlockHashByReference(ref string) (*chainhash.Hash, error) {
+// ChainVersion is a service method to get a version of the chain, to derive
+// the ETag of the block endpoints; see bus.ChainVersion.
+func (s *Service) ChainVersion() (string, bool) {
+	return s.Bus.ChainVersion()
+}
+
+// GetBlockHeader is a service method to get the header of a block by a
+// string reference, without fetching its transactions.
+func (s *Service) GetBlockHeader(ctx context.Context, ref string) (*types.BlockHeader, error) {
+	hash, err := s.getBlockHashByReference(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Bus.GetBlockHeader(ctx, hash)
+}
+
+// GetBlockStats is a service method to get the fee statistics of a block by
+// a string reference; see bus.GetBlockStats.
+func (s *Service) GetBlockStats(ctx context.Context, ref string) (*types.BlockStats, error) {
+	hash, err := s.getBlockHashByReference(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Bus.GetBlockStats(ctx, hash)
+}
+
+// blockTxChunkSize is the number of transactions of a block fetched at once
+// while streaming them, on nodes that cannot return the block along with the
+// outputs spent by its transactions.
+const blockTxChunkSize = 100
+
+// BlockTransactions is a range of the transactions of a block, which are
+// built one at a time by Each, so that large blocks can be streamed rather
+// than held in memory.
+type BlockTransactions struct {
+	// Total is the number of transactions of the block.
+	Total int
+
+	service         *Service
+	block           *types.Block // without the list of transaction IDs
+	bestBlockHeight int32
+
+	// Transactions of the range, by ID, and their position in the block.
+	txids  []string
+	offset int
+
+	// Block with the outputs spent by its transactions, if supported.
+	prevouts *bus.PrevoutsBlock
+}
+
+// GetBlockTransactions is a service method to get the transactions of a
+// Block by a string reference, in the order in which they appear in the
+// block, skipping the first offset ones. If limit is positive, at most limit
+// transactions are returned.
+//
+// On nodes that support it, the transactions and the outputs spent by their
+// inputs are fetched along with the block, in a single RPC. Otherwise, or if
+// this fails, transactions and the transactions spent by their inputs are
+// fetched using batched RPC requests, a chunk at a time. Transactions that
+// could not be fetched are skipped, and an error is returned only if the
+// block itself could not be retrieved.
+func (s *Service) GetBlockTransactions(ctx context.Context, ref string, offset int, limit int) (*BlockTransactions, error) {
+	bestBlockHeight, err := s.Bus.GetBestBlockHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	var block *types.Block
+	var prevouts *bus.PrevoutsBlock
+
+	if s.Bus.BlockPrevoutsSupported() {
+		hash, err := s.getBlockHashByReference(ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+
+		if prevouts, err = s.Bus.GetPrevoutsBlock(ctx, hash); err == nil {
+			block = prevouts.Block
+		} else {
+			utils.Logger(ctx).WithFields(log.Fields{
+				"error": err,
+				"block": ref,
+			}).Warn("Failed to get block with prevouts, falling back to input lookups")
+		}
+	}
+
+	if block == nil {
+		if block, err = s.GetBlock(ctx, ref); err != nil {
+			return nil, err
+		}
+	}
+
+	txids, offset := blockTxRange(*block.Transactions, offset, limit)
+
+	return &BlockTransactions{
+		Total:   len(*block.Transactions),
+		service: s,
+		// Attach a copy of the block without the list of transaction IDs,
+		// to keep the size of the response reasonable.
+		block: &types.Block{
+			Hash:   block.Hash,
+			Height: block.Height,
+			Time:   block.Time,
+		},
+		bestBlockHeight: int32(bestBlockHeight),
+		txids:           txids,
+		offset:          offset,
+		prevouts:        prevouts,
+	}, nil
+}
+
+// blockTxRange returns the txids of a block after the first offset ones, and
+// at most limit of them if limit is positive, along with the position of the
+// first one in the block.
+func blockTxRange(txids []string, offset int, limit int) ([]string, int) {
+	if offset > len(txids) {
+		offset = len(txids)
+	}
+
+	txids = txids[offset:]
+	if limit > 0 && limit < len(txids) {
+		txids = txids[:limit]
+	}
+
+	return txids, offset
+}
+
+// Each builds the transactions of the range, and calls fn with each of them,
+// in the order of the block. At most blockTxChunkSize transactions are held
+// at once. It stops at the first error returned by fn, or once ctx is done,
+// and returns it.
+func (p *BlockTransactions) Each(ctx context.Context, fn func(tx *types.Transaction) error) error {
+	if p.prevouts != nil {
+		for idx := range p.txids {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			tx, utxos, err := p.prevouts.Transaction(p.offset + idx)
+			if err != nil {
+				utils.Logger(ctx).WithFields(log.Fields{
+					"error": err,
+					"hash":  redact.TxID(p.txids[idx]),
+				}).Error("Unable to decode transaction")
+				continue
+			}
+
+			if err := p.yield(tx, utxos, fn); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	s := p.service
+
+	for start := 0; start < len(p.txids); start += blockTxChunkSize {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		end := start + blockTxChunkSize
+		if end > len(p.txids) {
+			end = len(p.txids)
+		}
+
+		txs := s.Bus.GetTransactions(ctx, p.txids[start:end])
+		utxos := s.buildUTXOsBatch(ctx, txs)
+
+		for _, tx := range txs {
+			if tx == nil {
+				continue
+			}
+
+			if err := p.yield(tx, utxos, fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// yield builds the transaction, and calls fn with it.
+func (p *BlockTransactions) yield(
+	tx *types.Transaction, utxos types.UTXOs, fn func(tx *types.Transaction) error,
+) error {
+	tx.Block = p.block
+	buildTx(tx, utxos, p.bestBlockHeight)
+
+	return fn(tx)
+}
+
+// getBlockWithPrevouts returns the block by a string reference, its
+// transactions, and the outputs spent by their inputs.
+func (s *Service) getBlockWithPrevouts(
+	ctx context.Context, ref string,
+) (*types.Block, []*types.Transaction, types.UTXOs, error) {
+	if s.Bus.BlockPrevoutsSupported() {
+		hash, err := s.getBlockHashByReference(ctx, ref)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		block, txs, utxos, err := s.Bus.GetBlockWithPrevouts(ctx, hash)
+		if err == nil {
+			return block, txs, utxos, nil
+		}
+
+		utils.Logger(ctx).WithFields(log.Fields{
+			"error": err,
+			"block": ref,
+		}).Warn("Failed to get block with prevouts, falling back to input lookups")
+	}
+
+	block, err := s.GetBlock(ctx, ref)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	txs := s.Bus.GetTransactions(ctx, *block.Transactions)
+
+	return block, txs, s.buildUTXOsBatch(ctx, txs), nil
+}
+
+// GetBlockAtTime is a service method to get the last block of the best chain
+// at the given UNIX timestamp, according to the median time of the blocks.
+func (s *Service) GetBlockAtTime(ctx context.Context, timestamp int64) (*types.BlockTime, error) {
+	return s.Bus.GetBlockAtTime(ctx, timestamp)
+}
+
+func (s *Service) getBlockHashByReference(ctx context.Context, ref string) (*chainhash.Hash, error) {
 	switch {
 	case ref == "current":
 		return s.Bus.GetBestBlockHash()
 
 	case strings.HasPrefix(ref, "0x"), len(ref) == 64:
 		// 256-bit hex string with or without 0x prefix
-		return utils.ParseChainHash(ref)
+		hash, err := utils.ParseChainHash(ref)
+		if err != nil {
+			return nil, fmt.Errorf("%w '%s': %s", ErrInvalidBlock, ref, err)
+		}
+
+		return hash, nil
 	default:
 		{
 			// Either an int64 block height, or garbage input
@@ -41,10 +284,10 @@ func (s *Service) getBlockHashByReference(ref string) (*chainhash.Hash, error) {
 
 			switch err {
 			case nil:
-				return s.Bus.GetBlockHash(blockHeight)
+				return s.Bus.GetBlockHash(ctx, blockHeight)
 
 			default:
-				return nil, fmt.Errorf("invalid block '%s'", ref)
+				return nil, fmt.Errorf("%w '%s'", ErrInvalidBlock, ref)
 			}
 		}
 
@@ -0,0 +1,32 @@
+package svc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBlockTxRange(t *testing.T) {
+	txids := []string{"a", "b", "c", "d", "e"}
+
+	tests := []struct {
+		offset, limit int
+		want          []string
+		wantOffset    int
+	}{
+		{0, 0, txids, 0},
+		{2, 0, []string{"c", "d", "e"}, 2},
+		{0, 2, []string{"a", "b"}, 0},
+		{3, 10, []string{"d", "e"}, 3},
+		{1, 3, []string{"b", "c", "d"}, 1},
+		{5, 2, []string{}, 5},
+		{10, 0, []string{}, 5},
+	}
+
+	for _, test := range tests {
+		got, offset := blockTxRange(txids, test.offset, test.limit)
+		if !reflect.DeepEqual(got, test.want) || offset != test.wantOffset {
+			t.Errorf("offset %d, limit %d: got %v at %d, want %v at %d",
+				test.offset, test.limit, got, offset, test.want, test.wantOffset)
+		}
+	}
+}
@@ -1,10 +1,12 @@
 package svc
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/ledgerhq/satstack/bus"
 	"github.com/ledgerhq/satstack/config"
+	"github.com/ledgerhq/satstack/redact"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -18,31 +20,137 @@ func (s *Service) ImportAccounts(accounts []config.Account) {
 	}()
 }
 
-func (s *Service) HasDescriptor(descriptor string) (bool, error) {
-	client, err := s.Bus.ClientFactory()
+// ReloadAccounts re-reads the accounts from the config file, and imports the
+// ones that were added since the last load.
+func (s *Service) ReloadAccounts() error {
+	if s.ConfigPath == "" {
+		return fmt.Errorf("%s: no config file", config.ErrConfigFileNotFound)
+	}
+
+	configuration, err := config.LoadFile(s.ConfigPath)
 	if err != nil {
-		return false, err
+		return err
+	}
+
+	chains := configuration.ChainConfigurations()
+	if s.ChainIndex >= len(chains) {
+		return fmt.Errorf("%s: chain #%d removed from config file", config.ErrValidation, s.ChainIndex)
+	}
+
+	chain := chains[s.ChainIndex]
+
+	if err := chain.ValidateChain(s.Bus.Params); err != nil {
+		return err
+	}
+
+	return s.Bus.ReloadAccounts(chain.Accounts)
+}
+
+// AddAccount validates the account, adds it to the configured ones, and
+// appends it to the config file, so that it is kept across restarts. Its
+// descriptors are imported in the background; see bus.AddAccount.
+func (s *Service) AddAccount(account config.Account) error {
+	if s.ConfigPath == "" {
+		return fmt.Errorf("%s: no config file", config.ErrConfigFileNotFound)
+	}
+
+	if err := config.ValidateAccount(account, s.Bus.Params); err != nil {
+		return err
+	}
+
+	if err := s.Bus.AddAccount(account); err != nil {
+		return err
 	}
 
-	defer client.Shutdown()
+	if err := config.AddAccountToFile(s.ConfigPath, s.ChainIndex, account); err != nil {
+		return fmt.Errorf("account added, but not saved to %s: %w", s.ConfigPath, err)
+	}
+
+	log.WithFields(log.Fields{
+		"descriptor": redact.Descriptor(account.ID()),
+		"path":       s.ConfigPath,
+	}).Info("Added account to config file")
+
+	return nil
+}
+
+// ListDescriptors is a service method to list the descriptors of the
+// configured accounts, along with their import parameters.
+//
+// In privacy mode, the extended keys of the descriptors are replaced with
+// their fingerprint, as in the logs.
+func (s *Service) ListDescriptors() ([]bus.AccountDescriptors, error) {
+	accounts, err := s.Bus.ListDescriptors()
+	if err != nil || !redact.Enabled() {
+		return accounts, err
+	}
+
+	for idx := range accounts {
+		account := &accounts[idx]
+
+		account.External.Descriptor = redact.DescriptorString(account.External.Descriptor)
+		account.External.Address = redact.Abbreviate(account.External.Address)
+		if account.Internal != nil {
+			account.Internal.Descriptor = redact.DescriptorString(account.Internal.Descriptor)
+			account.Internal.Address = redact.Abbreviate(account.Internal.Address)
+		}
+
+		account.Multipath = redact.DescriptorString(account.Multipath)
+		account.Address = redact.Abbreviate(account.Address)
+	}
+
+	return accounts, nil
+}
+
+// Rescan is a service method to rescan the blockchain for wallet
+// transactions, from the given height or UNIX timestamp. It returns the
+// height from which the rescan starts.
+func (s *Service) Rescan(height *int64, timestamp *int64) (int64, error) {
+	return s.Bus.Rescan(height, timestamp)
+}
+
+// StopRescan is a service method to abort the rescan in progress.
+func (s *Service) StopRescan() error {
+	return s.Bus.StopRescan()
+}
+
+// RPCStats is a service method to get the stats of the RPC calls made to
+// bitcoind, by method.
+func (s *Service) RPCStats() bus.RPCStats {
+	return s.Bus.RPCStats()
+}
+
+// ResetRPCStats is a service method to reset the stats of the RPC calls.
+func (s *Service) ResetRPCStats() {
+	s.Bus.ResetRPCStats()
+}
+
+// SelfTest is a service method to check the read path from SatStack to
+// bitcoind; see bus.SelfTest.
+func (s *Service) SelfTest(ctx context.Context, broadcast bool) bus.SelfTestReport {
+	return s.Bus.SelfTest(ctx, broadcast)
+}
+
+func (s *Service) HasDescriptor(ctx context.Context, descriptor string) (bool, error) {
+	client := s.Bus.Client()
 
 	canonicalDesc, err := bus.GetCanonicalDescriptor(client, descriptor)
 	if err != nil {
-		return false, fmt.Errorf("%s: %w", bus.ErrInvalidDescriptor, err)
+		return false, fmt.Errorf("%w: %v", bus.ErrInvalidDescriptor, err)
 	}
 
-	address, err := bus.DeriveAddress(client, *canonicalDesc, 0)
+	address, err := bus.DeriveAddress(ctx, client, *canonicalDesc, 0)
 	if err != nil {
 		return false, fmt.Errorf("%s (%s - #%d): %w",
 			bus.ErrDeriveAddress, *canonicalDesc, 0, err)
 	}
 
-	addressInfo, err := client.GetAddressInfo(*address)
+	addressInfo, err := client.GetAddressInfo(ctx, *address)
 	if err != nil {
 		return false, fmt.Errorf("%s (%s): %w", bus.ErrAddressInfo, *address, err)
 	}
 
-	if !addressInfo.IsWatchOnly {
+	if !addressInfo.IsWatchOnly && !addressInfo.IsMine {
 		return false, nil
 	}
 
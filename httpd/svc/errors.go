@@ -0,0 +1,7 @@
+package svc
+
+import "errors"
+
+// ErrInvalidBlock indicates that a block reference is neither "current", a
+// block hash, nor a block height.
+var ErrInvalidBlock = errors.New("invalid block")
@@ -1,18 +1,16 @@
 package svc
 
 import (
-	"fmt"
+	"context"
 	"strconv"
 	"time"
 
-	"github.com/btcsuite/btcd/btcjson"
 	"github.com/ledgerhq/satstack/bus"
-	"github.com/ledgerhq/satstack/version"
-	log "github.com/sirupsen/logrus"
+	"github.com/ledgerhq/satstack/types"
 )
 
-func (s *Service) GetHealth() error {
-	_, err := s.Bus.GetBlockChainInfo()
+func (s *Service) GetHealth(ctx context.Context) error {
+	_, err := s.Bus.GetBlockChainInfo(ctx)
 	if err != nil {
 		return err
 	}
@@ -22,83 +20,58 @@ func (s *Service) GetHealth() error {
 	return nil
 }
 
-func (s *Service) GetFees(targets []int64, mode string) map[string]interface{} {
+// GetFees returns fee rate estimates in satoshis per kvB, keyed by the
+// stringified confirmation target. The source of the estimates is included
+// in the "source" field.
+//
+// If targets is empty or mode is blank, the defaults of the Service are used.
+func (s *Service) GetFees(ctx context.Context, targets []int64, mode string) map[string]interface{} {
+	if len(targets) == 0 {
+		targets = s.FeeTargets
+	}
+
+	if mode == "" {
+		mode = s.FeeMode
+	}
+
+	fees, source := s.Bus.EstimateFees(ctx, targets, mode)
+
 	result := make(map[string]interface{})
-	for _, target := range targets {
-		fee := s.Bus.EstimateSmartFee(target, mode)
+	for target, fee := range fees {
 		result[strconv.FormatInt(target, 10)] = fee
 	}
 
 	result["last_updated"] = int32(time.Now().Unix())
+	result["source"] = source
 	return result
 }
 
+// GetStatus queries the current status of SatStack and bitcoind.
 func (s *Service) GetStatus() *bus.ExplorerStatus {
-	// Prepare base bus.ExplorerStatus instance.
-	status := bus.ExplorerStatus{
-		Version:  version.Version,
-		TxIndex:  s.Bus.TxIndex,
-		Pruned:   s.Bus.Pruned,
-		Chain:    s.Bus.Chain,
-		Currency: s.Bus.Currency,
-	}
-
-	// Case 1: satstack is running the numbers.
-	if s.Bus.IsPendingScan {
-		status.Status = bus.PendingScan
-		return &status
-	}
-
-	// Case 2: Unable to initialize rpcclient.Client.
-	client, err := s.Bus.ClientFactory()
-	if err != nil {
-		log.WithField(
-			"err", fmt.Errorf("%s: %w", bus.ErrBitcoindUnreachable, err),
-		).Error("Failed to query status")
-		status.Status = bus.NodeDisconnected
-		return &status
-	}
-
-	defer client.Shutdown()
-
-	// Case 3: bitcoind is unreachable - chain RPC failed.
-	blockChainInfo, err := client.GetBlockChainInfo()
-	if err != nil {
-		log.WithField(
-			"err", fmt.Errorf("%s: %w", bus.ErrBitcoindUnreachable, err),
-		).Error("Failed to query status")
-
-		status.Status = bus.NodeDisconnected
-		return &status
-	}
-
-	// Case 4: bitcoind is currently catching up on new blocks.
-	if blockChainInfo.Blocks != blockChainInfo.Headers {
-		status.Status = bus.Syncing
-		status.SyncProgress = btcjson.Float64(
-			blockChainInfo.VerificationProgress * 100)
-		return &status
-	}
+	return s.Bus.QueryStatus()
+}
 
-	// Case 5: bitcoind is currently importing descriptors
-	walletInfo, err := client.GetWalletInfo()
-	if err != nil {
-		log.WithField(
-			"err", fmt.Errorf("%s: %w", bus.ErrBitcoindUnreachable, err),
-		).Error("Failed to query status")
+// GetMempool returns the distribution of the fee rates of the transactions
+// in the mempool.
+func (s *Service) GetMempool(ctx context.Context) (*types.MempoolHistogram, error) {
+	return s.Bus.GetMempoolHistogram(ctx)
+}
 
-		status.Status = bus.NodeDisconnected
-		return &status
-	}
+// GetReadiness returns the last known Status and its detail, without
+// querying bitcoind.
+func (s *Service) GetReadiness() (bus.Status, string) {
+	return s.Bus.CachedStatus()
+}
 
-	switch v := walletInfo.Scanning.Value.(type) {
-	case btcjson.ScanProgress:
-		status.Status = bus.Scanning
-		status.ScanProgress = btcjson.Float64(v.Progress * 100)
-		return &status
-	}
+// GetCachedStatus returns the last known ExplorerStatus, without querying
+// bitcoind, or nil if it is not known yet.
+func (s *Service) GetCachedStatus() *bus.ExplorerStatus {
+	return s.Bus.CachedExplorerStatus()
+}
 
-	// Case 6: bitcoind is ready to be used with satstack.
-	status.Status = bus.Ready
-	return &status
+// SubscribeStatus subscribes to the updates of the ExplorerStatus published
+// by the worker. The updates are shared by all subscribers, and must not be
+// modified.
+func (s *Service) SubscribeStatus() (<-chan *bus.ExplorerStatus, func()) {
+	return s.Bus.SubscribeStatus()
 }
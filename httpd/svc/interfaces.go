@@ -1,34 +1,68 @@
 package svc
 
 import (
+	"context"
+
 	"github.com/ledgerhq/satstack/bus"
 	"github.com/ledgerhq/satstack/config"
 	"github.com/ledgerhq/satstack/types"
+
+	"github.com/btcsuite/btcutil"
 )
 
 type TransactionsService interface {
-	GetTransaction(hash string, block *types.Block, bestBlockHeight int32) (*types.Transaction, error)
-	GetTransactionHex(hash string) (string, error)
-	SendTransaction(tx string) (string, error)
+	GetTransaction(ctx context.Context, hash string, block *types.Block, bestBlockHeight int32) (*types.Transaction, error)
+	GetTransactionHex(ctx context.Context, hash string, hint bus.TransactionHint) (string, error)
+	GetMempoolTransaction(ctx context.Context, hash string) (*types.MempoolTransaction, error)
+	SendTransaction(tx string, maxFeeRate *float64) (string, bool, error)
+	SendTransactions(txs []string) []bus.BroadcastResult
 }
 
 type BlocksService interface {
-	GetBlock(ref string) (*types.Block, error)
+	GetBlock(ctx context.Context, ref string) (*types.Block, error)
+	GetBlockHeader(ctx context.Context, ref string) (*types.BlockHeader, error)
+	GetBlockTransactions(ctx context.Context, ref string, offset int, limit int) (*BlockTransactions, error)
+	GetBlockStats(ctx context.Context, ref string) (*types.BlockStats, error)
+	GetBlockAtTime(ctx context.Context, timestamp int64) (*types.BlockTime, error)
+	ChainVersion() (string, bool)
 }
 
 type AddressesService interface {
-	GetAddresses(addresses []string, blockHash *string) (types.Addresses, error)
+	GetAddresses(ctx context.Context, addresses []string, blockHash *string, batchSize int) (types.Addresses, error)
+	GetAddressTransactions(ctx context.Context, addresses []string, blockHash *string, batchSize int) (*AddressTransactions, error)
+	WalletEnabled() bool
+	FilterAccountAddresses(account string, addresses []string) ([]string, error)
+	GetUTXOs(ctx context.Context, addresses []string) (types.AddressUTXOs, error)
+	GetBalances(ctx context.Context, addresses []string) (types.AddressBalances, error)
+	AddressesVersion(addresses []string) (string, bool)
 }
 
 type ExplorerService interface {
-	GetHealth() error
+	GetHealth(ctx context.Context) error
 	GetStatus() *bus.ExplorerStatus
-	GetFees(targets []int64, mode string) map[string]interface{}
+	GetReadiness() (bus.Status, string)
+	GetCachedStatus() *bus.ExplorerStatus
+	SubscribeStatus() (<-chan *bus.ExplorerStatus, func())
+	GetFees(ctx context.Context, targets []int64, mode string) map[string]interface{}
+	GetMempool(ctx context.Context) (*types.MempoolHistogram, error)
 }
 
 type ControlService interface {
 	ImportAccounts(accounts []config.Account)
-	HasDescriptor(descriptor string) (bool, error)
+	ReloadAccounts() error
+	AddAccount(account config.Account) error
+	HasDescriptor(ctx context.Context, descriptor string) (bool, error)
+	ListDescriptors() ([]bus.AccountDescriptors, error)
+	Rescan(height *int64, timestamp *int64) (int64, error)
+	StopRescan() error
+	RPCStats() bus.RPCStats
+	ResetRPCStats()
+	SelfTest(ctx context.Context, broadcast bool) bus.SelfTestReport
+}
+
+type RegtestService interface {
+	Generate(count int, address *string) (*bus.GenerateResult, error)
+	Fund(address string, amount btcutil.Amount) (*bus.GenerateResult, error)
 }
 
 type ServiceInterface interface {
@@ -37,4 +71,5 @@ type ServiceInterface interface {
 	AddressesService
 	ExplorerService
 	ControlService
+	RegtestService
 }
@@ -0,0 +1,19 @@
+package svc
+
+import (
+	"github.com/ledgerhq/satstack/bus"
+
+	"github.com/btcsuite/btcutil"
+)
+
+// Generate is a service method to mine blocks on regtest, to the given
+// address or to the faucet.
+func (s *Service) Generate(count int, address *string) (*bus.GenerateResult, error) {
+	return s.Bus.Generate(count, address)
+}
+
+// Fund is a service method to send an amount to an address on regtest,
+// confirmed by a new block.
+func (s *Service) Fund(address string, amount btcutil.Amount) (*bus.GenerateResult, error) {
+	return s.Bus.Fund(address, amount)
+}
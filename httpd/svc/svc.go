@@ -4,4 +4,18 @@ import "github.com/ledgerhq/satstack/bus"
 
 type Service struct {
 	Bus *bus.Bus
+
+	// Default confirmation targets and estimatesmartfee mode for GetFees.
+	FeeTargets []int64
+	FeeMode    string
+
+	// Number of recent blocks cached by WarmUp, or 0 to disable it.
+	WarmupBlocks int
+
+	// Path of the config file, used to reload the accounts.
+	ConfigPath string
+
+	// Position of the chain in the config file, as listed by
+	// ChainConfigurations, used to reload the accounts of the chain.
+	ChainIndex int
 }
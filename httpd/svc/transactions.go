@@ -1,8 +1,12 @@
 package svc
 
 import (
+	"context"
 	"time"
 
+	"github.com/ledgerhq/satstack/bus"
+	"github.com/ledgerhq/satstack/protocol"
+	"github.com/ledgerhq/satstack/redact"
 	"github.com/ledgerhq/satstack/types"
 	"github.com/ledgerhq/satstack/utils"
 
@@ -12,77 +16,232 @@ import (
 
 // GetTransaction is a service function to query transaction details
 // by transaction hash.
-func (s *Service) GetTransaction(hash string, block *types.Block, bestBlockHeight int32) (*types.Transaction, error) {
-	tx, err := s.Bus.GetTransaction(hash)
-	if err != nil {
-		return nil, err
+//
+// Built transactions are cached in the Bus, and served from the cache as long
+// as they are still in the given block. The confirmations are always computed
+// against bestBlockHeight.
+func (s *Service) GetTransaction(ctx context.Context, hash string, block *types.Block, bestBlockHeight int32) (*types.Transaction, error) {
+	if tx, found := s.Bus.CachedTransaction(ctx, hash); found && sameBlock(tx.Block, block) {
+		tx.Confirmations = confirmations(tx.Block, bestBlockHeight)
+		s.addFirstSeen(tx)
+		s.addDerivations(tx)
+		return tx, nil
 	}
 
-	utxos, err := s.buildUTXOs(tx.Inputs)
+	tx, err := s.Bus.GetTransaction(ctx, hash)
 	if err != nil {
 		return nil, err
 	}
 
+	utxos := s.Bus.ResolvePrevouts(ctx, tx.Inputs)
+
 	tx.Block = block
 	buildTx(tx, utxos, bestBlockHeight)
 
+	s.Bus.CacheTransaction(hash, tx)
+	s.addFirstSeen(tx)
+	s.addDerivations(tx)
+
 	return tx, nil
 }
 
+// addFirstSeen reports the time at which SatStack first saw the transaction
+// unconfirmed, if it did, and uses it as the received time, rather than the
+// block time, or the time of the request.
+func (s *Service) addFirstSeen(tx *types.Transaction) {
+	seen, ok := s.Bus.FirstSeen(tx.Hash)
+	if !ok {
+		return
+	}
+
+	tx.FirstSeen = utils.ParseUnixTimestamp(seen)
+	tx.ReceivedAt = tx.FirstSeen
+}
+
+// addDerivations annotates the outputs paying to the addresses of the
+// configured accounts with their derivation. The derivations are not cached
+// with the transaction, since the ranges of the accounts may be extended.
+func (s *Service) addDerivations(tx *types.Transaction) {
+	for idx, output := range tx.Outputs {
+		tx.Outputs[idx].Derivation = nil
+
+		if derivation, ok := s.Bus.Derivation(output.Address); ok {
+			tx.Outputs[idx].Derivation = &derivation
+		}
+	}
+}
+
+// sameBlock indicates whether a and b reference the same block, or are both
+// nil (unconfirmed).
+func sameBlock(a *types.Block, b *types.Block) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return a.Hash == b.Hash
+}
+
+// confirmations returns the number of confirmations of a transaction in the
+// given block, or 0 if the block is nil.
+//
+// The block may be more recent than the cached chain tip, if it was found
+// right before the worker refreshed the tip, in which case the transaction
+// has exactly 1 confirmation.
+func confirmations(block *types.Block, bestBlockHeight int32) uint64 {
+	if block == nil {
+		return 0
+	}
+
+	if block.Height > int64(bestBlockHeight) {
+		return 1
+	}
+
+	return uint64(int64(bestBlockHeight)-block.Height) + 1
+}
+
 // GetTransactionHex is a service function to get hex encoded raw
-// transaction by hash.
-func (s *Service) GetTransactionHex(hash string) (string, error) {
+// transaction by hash. The hint is used to find the transactions that do not
+// belong to the wallet, if bitcoind has no transaction index.
+func (s *Service) GetTransactionHex(ctx context.Context, hash string, hint bus.TransactionHint) (string, error) {
 	chainHash, err := utils.ParseChainHash(hash)
 	if err != nil {
 		return "", err
 	}
 
-	return s.Bus.GetTransactionHex(chainHash)
+	return s.Bus.GetTransactionHex(ctx, chainHash, hint)
 }
 
-func (s *Service) SendTransaction(tx string) (string, error) {
-	hash, err := s.Bus.SendTransaction(tx)
+// GetMempoolTransaction is a service function to get the mempool data of a
+// transaction by hash; see bus.GetMempoolTransaction.
+func (s *Service) GetMempoolTransaction(ctx context.Context, hash string) (*types.MempoolTransaction, error) {
+	chainHash, err := utils.ParseChainHash(hash)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return hash.String(), nil
+
+	return s.Bus.GetMempoolTransaction(ctx, chainHash)
 }
 
-func (s *Service) buildUTXOs(vin []types.Input) (types.UTXOs, error) {
-	utxoMap := make(types.UTXOs)
+// SendTransaction is a service function to broadcast a transaction. It
+// also returns whether bitcoind already had the transaction.
+func (s *Service) SendTransaction(tx string, maxFeeRate *float64) (string, bool, error) {
+	hash, known, err := s.Bus.SendTransaction(tx, maxFeeRate)
+	if err != nil {
+		return "", false, err
+	}
+	return hash.String(), known, nil
+}
 
-	for _, inputRaw := range vin {
-		if len(inputRaw.Coinbase) > 0 {
+// SendTransactions is a service function to broadcast a package of
+// transactions, such as a parent and a child paying for it (CPFP).
+func (s *Service) SendTransactions(txs []string) []bus.BroadcastResult {
+	return s.Bus.SendTransactions(txs)
+}
+
+// addMempoolInfo annotates an unconfirmed transaction with its mempool data.
+//
+// If the transaction is no longer in the mempool, it has most likely been
+// confirmed since it was listed, in which case the confirmed representation
+// is used instead.
+func (s *Service) addMempoolInfo(ctx context.Context, tx *types.Transaction, bestBlockHeight int32) {
+	entry, err := s.Bus.GetMempoolEntry(ctx, tx.Hash)
+	if err == nil {
+		tx.Mempool = &types.MempoolInfo{
+			Replaceable:   entry.Replaceable,
+			Time:          utils.ParseUnixTimestamp(entry.Time),
+			AncestorCount: entry.AncestorCount,
+			AncestorSize:  entry.AncestorSize,
+			AncestorFees:  entry.AncestorFees(),
+		}
+		return
+	}
+
+	utils.Logger(ctx).WithFields(log.Fields{
+		"error": err,
+		"hash":  redact.TxID(tx.Hash),
+	}).Debug("Transaction not found in mempool")
+
+	chainHash, err := utils.ParseChainHash(tx.Hash)
+	if err != nil {
+		return
+	}
+
+	block, err := s.Bus.GetTransactionBlock(ctx, chainHash)
+	if err != nil || block == nil {
+		// Neither in the mempool, nor confirmed; for ex, evicted or
+		// conflicted. Leave the transaction as is.
+		return
+	}
+
+	tx.Block = block
+	tx.Confirmations = confirmations(block, bestBlockHeight)
+	tx.ReceivedAt = block.Time
+	s.addFirstSeen(tx)
+}
+
+// buildUTXOsBatch collects the UTXOs spent by all the given transactions
+// using batched RPC requests, like bus.ResolvePrevouts for a single one. Nil
+// transactions are ignored.
+func (s *Service) buildUTXOsBatch(ctx context.Context, txs []*types.Transaction) types.UTXOs {
+	var utxoIDs []types.OutputIdentifier
+	var hashes []string
+	visited := make(map[string]bool)
+
+	for _, tx := range txs {
+		if tx == nil {
 			continue
 		}
 
-		utxoID := types.OutputIdentifier{
-			Hash:  inputRaw.OutputHash,
-			Index: *inputRaw.OutputIndex, // FIXME: can panic
+		for _, inputRaw := range tx.Inputs {
+			if len(inputRaw.Coinbase) > 0 || inputRaw.OutputIndex == nil {
+				continue
+			}
+
+			utxoID := types.OutputIdentifier{
+				Hash:  inputRaw.OutputHash,
+				Index: *inputRaw.OutputIndex,
+			}
+
+			utxoIDs = append(utxoIDs, utxoID)
+
+			if !visited[utxoID.Hash] {
+				hashes = append(hashes, utxoID.Hash)
+				visited[utxoID.Hash] = true
+			}
+		}
+	}
+
+	prevTxs := make(map[string]*types.Transaction, len(hashes))
+	for idx, prevTx := range s.Bus.GetTransactions(ctx, hashes) {
+		if prevTx != nil {
+			prevTxs[hashes[idx]] = prevTx
 		}
+	}
 
-		utxo, err := s.Bus.GetTransaction(utxoID.Hash)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"error": err,
-				"hash":  utxoID.Hash,
-				"vout":  utxoID.Index,
-			}).Debug("Encountered non-wallet Vout")
+	utxoMap := make(types.UTXOs)
+
+	for _, utxoID := range utxoIDs {
+		utxo, ok := prevTxs[utxoID.Hash]
+		if !ok || int(utxoID.Index) >= len(utxo.Outputs) {
 			continue
 		}
 
 		utxoMap[utxoID] = types.UTXOData{
-			Value:   *utxo.Outputs[utxoID.Index].Value, // FIXME: can panic
+			Value:   *utxo.Outputs[utxoID.Index].Value,
 			Address: utxo.Outputs[utxoID.Index].Address,
 		}
 	}
 
-	return utxoMap, nil
+	return utxoMap
 }
 
+// buildTx populates the inputs of the transaction with the value and address
+// of the outputs they spend, and computes its amount and fee. The fee is
+// unknown, and left nil, unless all the spent outputs are in utxoMap.
 func buildTx(tx *types.Transaction, utxoMap types.UTXOs, bestBlockHeight int32) {
 	sumVinValues := btcutil.Amount(0)
 	vinHasCoinbase := false
+	vinResolved := true
 
 	for idx, vin := range tx.Inputs {
 		if len(vin.Coinbase) > 0 {
@@ -90,52 +249,69 @@ func buildTx(tx *types.Transaction, utxoMap types.UTXOs, bestBlockHeight int32)
 			continue
 		}
 
+		tx.Inputs[idx].Address = "" // mutate the vins in tx
+		tx.Inputs[idx].Value = nil
+
+		if vin.OutputIndex == nil {
+			vinResolved = false
+			continue
+		}
+
 		utxoID := types.OutputIdentifier{
 			Hash:  vin.OutputHash,
 			Index: *vin.OutputIndex,
 		}
 
-		utxo := utxoMap[utxoID]
+		utxo, ok := utxoMap[utxoID]
+		if !ok {
+			vinResolved = false
+			continue
+		}
 
-		tx.Inputs[idx].Address = utxo.Address // mutate the vins in tx
+		tx.Inputs[idx].Address = utxo.Address
 		tx.Inputs[idx].Value = &utxo.Value
 
 		sumVinValues += utxo.Value
 	}
 
 	sumVoutValues := btcutil.Amount(0)
+	burnedValues := btcutil.Amount(0)
 
 	for _, vout := range tx.Outputs {
 		sumVoutValues += *vout.Value
+
+		// The value of OP_RETURN outputs is burned, and paid to no one.
+		if vout.ScriptType == protocol.ScriptTypeOpReturn {
+			burnedValues += *vout.Value
+		}
 	}
 
+	tx.Confirmations = confirmations(tx.Block, bestBlockHeight)
+
 	if tx.Block != nil {
-		tx.Confirmations = uint64(int64(bestBlockHeight)-tx.Block.Height) + 1
 		tx.ReceivedAt = tx.Block.Time
 	} else {
 		// Handle the case of unconfirmed transaction.
-		tx.Confirmations = 0
 		tx.ReceivedAt = utils.ParseUnixTimestamp(time.Now().Unix())
 	}
 
 	var fees btcutil.Amount
 
-	if vinHasCoinbase {
+	switch {
+	case vinHasCoinbase:
 		// Coinbase transactions have no fees
-		fees = btcutil.Amount(0)
-	} else {
+		tx.Fees = &fees
+	case vinResolved && sumVinValues >= sumVoutValues:
 		fees = sumVinValues - sumVoutValues
+		tx.Fees = &fees
+	default:
+		// This is typically the case of incoming transactions, where the
+		// outputs spent cannot be found without a transaction index.
+		tx.Fees = nil
 	}
 
-	// This is typically in case of incoming transactions, where computing
-	// the fees without a transaction index is impossible.
-	if fees < 0 {
-		fees = 0
-	}
-
-	tx.Fees = &fees
-
 	// In Ledger Blockchain Explorer v2, the Amount field is the sum of all
-	// Vout values.
-	tx.Amount = &sumVoutValues
+	// Vout values, except that OP_RETURN outputs are not counted.
+	amount := sumVoutValues - burnedValues
+	tx.Amount = &amount
 }
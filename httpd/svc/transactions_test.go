@@ -0,0 +1,36 @@
+package svc
+
+import (
+	"testing"
+
+	"github.com/ledgerhq/satstack/types"
+)
+
+func TestConfirmations(t *testing.T) {
+	tests := []struct {
+		name       string
+		block      *types.Block
+		bestHeight int32
+		want       uint64
+	}{
+		{"unconfirmed", nil, 100, 0},
+		{"tip", &types.Block{Height: 100}, 100, 1},
+		{"deep", &types.Block{Height: 90}, 100, 11},
+		{"ahead of the cached tip", &types.Block{Height: 101}, 100, 1},
+	}
+
+	for _, test := range tests {
+		if got := confirmations(test.block, test.bestHeight); got != test.want {
+			t.Errorf("%s: got %d confirmations, want %d", test.name, got, test.want)
+		}
+	}
+
+	// Cached transactions get their confirmations from the tip of each
+	// request.
+	block := &types.Block{Height: 95}
+	for bestHeight := int32(95); bestHeight <= 97; bestHeight++ {
+		if got, want := confirmations(block, bestHeight), uint64(bestHeight-95+1); got != want {
+			t.Errorf("tip %d: got %d confirmations, want %d", bestHeight, got, want)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package svc
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ledgerhq/satstack/bus"
+	"github.com/ledgerhq/satstack/types"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// warmupConcurrency indicates the number of blocks fetched at the same time
+// while warming up the caches, which is kept low to leave bitcoind to the
+// requests of the clients.
+const warmupConcurrency = 2
+
+// WarmUp populates the caches of the Bus with the WarmupBlocks most recent
+// blocks and their headers, and with the transactions of the chain tip, as
+// served by the explorer endpoints. It waits for the Bus to be Ready first,
+// and returns early once ctx is done.
+//
+// Failures are logged at the debug level only, since the entries are
+// fetched again on demand.
+func (s *Service) WarmUp(ctx context.Context) {
+	if s.WarmupBlocks <= 0 || !s.waitReady(ctx) {
+		return
+	}
+
+	start := time.Now()
+
+	tip, err := s.Bus.GetBestBlockHeight()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"prefix": "warmup",
+			"error":  err,
+		}).Warn("Failed to warm up caches")
+		return
+	}
+
+	heights := make(chan int64)
+	var blocks, txs int64
+	var tipBlock *types.Block
+
+	var wg sync.WaitGroup
+	for i := 0; i < warmupConcurrency; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for height := range heights {
+				block, err := s.warmBlock(ctx, height)
+				if err != nil {
+					log.WithFields(log.Fields{
+						"prefix": "warmup",
+						"height": height,
+						"error":  err,
+					}).Debug("Failed to warm up block")
+					continue
+				}
+
+				atomic.AddInt64(&blocks, 1)
+
+				// Written by the only worker fetching the tip.
+				if height == tip {
+					tipBlock = block
+				}
+			}
+		}()
+	}
+
+	for height := tip; height > tip-int64(s.WarmupBlocks) && height >= 0; height-- {
+		if ctx.Err() != nil {
+			break
+		}
+
+		heights <- height
+	}
+
+	close(heights)
+	wg.Wait()
+
+	if tipBlock != nil && ctx.Err() == nil {
+		txs = int64(s.warmTransactions(ctx, tipBlock, int32(tip)))
+	}
+
+	log.WithFields(log.Fields{
+		"prefix":       "warmup",
+		"blocks":       blocks,
+		"transactions": txs,
+		"duration":     time.Since(start).Round(time.Millisecond),
+		"cancelled":    ctx.Err() != nil,
+	}).Info("Warmed up caches")
+}
+
+// waitReady waits for the Bus to be Ready, and returns false if ctx is done,
+// or the status subscribers are closed, before.
+func (s *Service) waitReady(ctx context.Context) bool {
+	statuses, cancel := s.Bus.SubscribeStatus()
+	defer cancel()
+
+	for {
+		select {
+		case status, ok := <-statuses:
+			if !ok {
+				return false
+			}
+
+			if status.Status == bus.Ready {
+				return true
+			}
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// warmBlock caches the block at the given height, along with its header.
+func (s *Service) warmBlock(ctx context.Context, height int64) (*types.Block, error) {
+	hash, err := s.Bus.GetBlockHash(ctx, height)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := s.Bus.GetBlock(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.Bus.GetBlockHeader(ctx, hash); err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
+
+// warmTransactions caches the transactions of the block, built as by
+// GetTransaction, and returns the number of transactions cached.
+func (s *Service) warmTransactions(ctx context.Context, block *types.Block, bestBlockHeight int32) int {
+	_, txs, utxos, err := s.getBlockWithPrevouts(ctx, block.Hash)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"prefix": "warmup",
+			"block":  block.Hash,
+			"error":  err,
+		}).Debug("Failed to warm up block transactions")
+		return 0
+	}
+
+	txBlock := &types.Block{
+		Hash:   block.Hash,
+		Height: block.Height,
+		Time:   block.Time,
+	}
+
+	var count int
+	for _, tx := range txs {
+		if tx == nil {
+			continue
+		}
+
+		tx.Block = txBlock
+		buildTx(tx, utxos, bestBlockHeight)
+
+		s.Bus.CacheTransaction(tx.Hash, tx)
+		count++
+	}
+
+	return count
+}
@@ -0,0 +1,308 @@
+// Package ws streams the events published by the Bus to WebSocket clients.
+package ws
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/ledgerhq/satstack/bus"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/websocket"
+)
+
+// sendQueueSize indicates the number of events queued for a client. Clients
+// that let the queue overflow are disconnected, so that a slow consumer never
+// delays the others.
+const sendQueueSize = 64
+
+// Subscription is the message sent by clients to limit the transaction
+// events they receive to the given addresses. An empty list of addresses
+// subscribes to all the transactions of the wallet. Block events are always
+// sent.
+type Subscription struct {
+	Addresses []string `json:"addresses"`
+}
+
+// Subscriber is the subset of the Bus used by the Hub, to subscribe to its
+// events; see bus.Bus.Subscribe.
+type Subscriber interface {
+	Subscribe() (<-chan bus.Event, func())
+}
+
+// Hub dispatches the events of the Bus to the connected clients.
+//
+// The Hub is only subscribed to the events of the Bus while clients are
+// connected, so that the Bus does not track the wallet transactions, nor
+// look up the headers of new blocks, for nobody.
+type Hub struct {
+	bus            Subscriber
+	allowedOrigins []string
+
+	mu      sync.Mutex
+	clients map[*client]struct{}
+	closed  bool
+
+	// Subscription to the events of the Bus, if clients are connected, and
+	// the channel closed once its events are dispatched.
+	events <-chan bus.Event
+	cancel func()
+	done   chan struct{}
+}
+
+type client struct {
+	conn *websocket.Conn
+	send chan bus.Event
+
+	mu        sync.RWMutex
+	addresses map[string]bool // nil for all addresses
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewHub returns a Hub dispatching the events of the Bus, from the time the
+// first client connects. Browser clients are only accepted from the given
+// origins, in the same way as for CORS requests.
+func NewHub(b Subscriber, allowedOrigins []string) *Hub {
+	return &Hub{
+		bus:            b,
+		allowedOrigins: allowedOrigins,
+		clients:        make(map[*client]struct{}),
+	}
+}
+
+// Close disconnects all the clients, and stops dispatching events. It must
+// be called on shutdown, since hijacked connections are not closed by
+// http.Server.Shutdown.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return
+	}
+
+	h.closed = true
+	clients := h.clients
+	h.clients = nil
+	cancel, done := h.cancel, h.done
+	h.events, h.cancel, h.done = nil, nil, nil
+	h.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+
+	for c := range clients {
+		c.close()
+	}
+}
+
+// Handler is a gin handler (factory) that upgrades the connection to a
+// WebSocket, and streams the events to it as JSON messages.
+func (h *Hub) Handler() gin.HandlerFunc {
+	server := websocket.Server{
+		Handshake: h.handshake,
+		Handler:   h.serve,
+	}
+
+	return func(ctx *gin.Context) {
+		server.ServeHTTP(ctx.Writer, ctx.Request)
+	}
+}
+
+// run dispatches the events of a subscription to the clients, until the
+// subscription is cancelled.
+func (h *Hub) run(events <-chan bus.Event, done chan struct{}) {
+	defer close(done)
+
+	for event := range events {
+		h.mu.Lock()
+
+		// The events left in a cancelled subscription are dropped.
+		if h.events != events {
+			h.mu.Unlock()
+			continue
+		}
+
+		for c := range h.clients {
+			if !c.wants(event) {
+				continue
+			}
+
+			select {
+			case c.send <- event:
+			default:
+				log.WithField("prefix", "ws").Warn("Client is not keeping up, disconnecting")
+				h.removeLocked(c)
+				c.close()
+			}
+		}
+		h.mu.Unlock()
+	}
+}
+
+// addLocked registers a client, and subscribes to the events of the Bus if
+// it is the first one. h.mu must be held.
+func (h *Hub) addLocked(c *client) {
+	h.clients[c] = struct{}{}
+	if h.cancel != nil {
+		return
+	}
+
+	h.events, h.cancel = h.bus.Subscribe()
+	h.done = make(chan struct{})
+
+	go h.run(h.events, h.done)
+}
+
+// removeLocked unregisters a client, and cancels the subscription to the
+// events of the Bus if it was the last one. h.mu must be held.
+func (h *Hub) removeLocked(c *client) {
+	if _, ok := h.clients[c]; !ok {
+		return
+	}
+
+	delete(h.clients, c)
+	if len(h.clients) > 0 || h.cancel == nil {
+		return
+	}
+
+	h.cancel()
+	h.events, h.cancel, h.done = nil, nil, nil
+}
+
+// handshake rejects browser clients from origins that are not allowed.
+// Clients that do not send an Origin header, such as scripts, are accepted.
+func (h *Hub) handshake(config *websocket.Config, r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+
+	originURL, err := url.Parse(origin)
+	if err != nil {
+		return err
+	}
+
+	config.Origin = originURL
+
+	if originURL.Host == r.Host {
+		return nil
+	}
+
+	for _, allowed := range h.allowedOrigins {
+		if allowed == "*" || strings.TrimSuffix(allowed, "/") == origin {
+			return nil
+		}
+	}
+
+	return websocket.ErrBadWebSocketOrigin
+}
+
+func (h *Hub) serve(conn *websocket.Conn) {
+	c := &client{
+		conn:   conn,
+		send:   make(chan bus.Event, sendQueueSize),
+		closed: make(chan struct{}),
+	}
+
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		conn.Close()
+		return
+	}
+	h.addLocked(c)
+	h.mu.Unlock()
+
+	log.WithField("prefix", "ws").Debug("Client connected")
+
+	go c.readSubscriptions()
+
+	// The handler must not return before the connection is done with, since
+	// the connection is closed as soon as it does.
+	c.writeEvents()
+
+	h.mu.Lock()
+	h.removeLocked(c)
+	h.mu.Unlock()
+
+	c.close()
+
+	log.WithField("prefix", "ws").Debug("Client disconnected")
+}
+
+// writeEvents sends the queued events to the client until the connection is
+// closed.
+func (c *client) writeEvents() {
+	for {
+		select {
+		case event := <-c.send:
+			if err := websocket.JSON.Send(c.conn, event); err != nil {
+				return
+			}
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+// readSubscriptions processes the subscription messages of the client until
+// the connection is closed.
+func (c *client) readSubscriptions() {
+	defer c.close()
+
+	for {
+		var subscription Subscription
+		if err := websocket.JSON.Receive(c.conn, &subscription); err != nil {
+			return
+		}
+
+		var addresses map[string]bool
+		if len(subscription.Addresses) > 0 {
+			addresses = make(map[string]bool, len(subscription.Addresses))
+			for _, address := range subscription.Addresses {
+				addresses[address] = true
+			}
+		}
+
+		c.mu.Lock()
+		c.addresses = addresses
+		c.mu.Unlock()
+	}
+}
+
+func (c *client) wants(event bus.Event) bool {
+	if event.Type != bus.EventTransaction {
+		return true
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.addresses == nil {
+		return true
+	}
+
+	for _, address := range event.Transaction.Addresses {
+		if c.addresses[address] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// close closes the connection, which terminates both the reader and the
+// writer of the client.
+func (c *client) close() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.conn.Close()
+	})
+}
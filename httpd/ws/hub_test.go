@@ -0,0 +1,183 @@
+package ws
+
+import (
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ledgerhq/satstack/bus"
+	"github.com/ledgerhq/satstack/types"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+)
+
+// fakeBus is a Subscriber recording the active subscriptions.
+type fakeBus struct {
+	mu            sync.Mutex
+	subscriptions map[chan bus.Event]struct{}
+	total         int
+}
+
+func (b *fakeBus) Subscribe() (<-chan bus.Event, func()) {
+	ch := make(chan bus.Event, 8)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.subscriptions == nil {
+		b.subscriptions = make(map[chan bus.Event]struct{})
+	}
+	b.subscriptions[ch] = struct{}{}
+	b.total++
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscriptions, ch)
+			b.mu.Unlock()
+
+			close(ch)
+		})
+	}
+}
+
+// active returns the number of active subscriptions, and the total number
+// of subscriptions so far.
+func (b *fakeBus) active() (int, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.subscriptions), b.total
+}
+
+func (b *fakeBus) publish(event bus.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscriptions {
+		ch <- event
+	}
+}
+
+// waitActive waits until the number of active subscriptions is want.
+func waitActive(t *testing.T, b *fakeBus, want int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		active, _ := b.active()
+		if active == want {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d active subscriptions, want %d", active, want)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func newTestHub(t *testing.T) (*fakeBus, *Hub, string) {
+	gin.SetMode(gin.TestMode)
+
+	b := &fakeBus{}
+	hub := NewHub(b, nil)
+
+	engine := gin.New()
+	engine.GET("/ws", hub.Handler())
+
+	server := httptest.NewServer(engine)
+	t.Cleanup(func() {
+		hub.Close()
+		server.Close()
+	})
+
+	return b, hub, "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+}
+
+func dial(t *testing.T, url string) *websocket.Conn {
+	// Same-origin clients are accepted.
+	conn, err := websocket.Dial(url, "", "http"+strings.TrimSuffix(strings.TrimPrefix(url, "ws"), "/ws"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return conn
+}
+
+func TestHubSubscribesWithClients(t *testing.T) {
+	b, _, url := newTestHub(t)
+
+	if active, _ := b.active(); active != 0 {
+		t.Fatalf("got %d subscriptions without clients, want 0", active)
+	}
+
+	first := dial(t, url)
+	waitActive(t, b, 1)
+
+	second := dial(t, url)
+
+	// Both clients share the subscription.
+	b.publish(bus.Event{Type: bus.EventBlock, Block: &types.Block{Height: 1}})
+
+	for _, conn := range []*websocket.Conn{first, second} {
+		var event bus.Event
+		if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := websocket.JSON.Receive(conn, &event); err != nil {
+			t.Fatal(err)
+		}
+
+		if event.Type != bus.EventBlock || event.Block.Height != 1 {
+			t.Errorf("got event %+v, want block 1", event)
+		}
+	}
+
+	if active, total := b.active(); active != 1 || total != 1 {
+		t.Errorf("got %d active subscriptions out of %d, want a single one", active, total)
+	}
+
+	first.Close()
+	second.Close()
+	waitActive(t, b, 0)
+
+	// The next client subscribes again.
+	third := dial(t, url)
+	defer third.Close()
+
+	waitActive(t, b, 1)
+	if _, total := b.active(); total != 2 {
+		t.Errorf("got %d subscriptions in total, want 2", total)
+	}
+}
+
+func TestHubClose(t *testing.T) {
+	b, hub, url := newTestHub(t)
+
+	conn := dial(t, url)
+	defer conn.Close()
+
+	waitActive(t, b, 1)
+
+	hub.Close()
+	if active, _ := b.active(); active != 0 {
+		t.Errorf("got %d subscriptions after Close, want 0", active)
+	}
+
+	// Clients are disconnected.
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	var event bus.Event
+	if err := websocket.JSON.Receive(conn, &event); err == nil {
+		t.Error("got an event after Close")
+	}
+}
@@ -4,6 +4,7 @@ package main
 
 import (
 	"os"
+	"time"
 
 	"github.com/magefile/mage/sh"
 )
@@ -11,7 +12,12 @@ import (
 const (
 	entryPoint = "cmd/lss.go"
 	ldFlags    = "-X '$PACKAGE/version.GitCommit=$COMMIT_HASH' " +
-		"-X '$PACKAGE/version.Build=$BUILD'"
+		"-X '$PACKAGE/version.Build=$BUILD' " +
+		"-X '$PACKAGE/version.BuildDate=$BUILD_DATE'"
+
+	// versionLdFlag overrides the version with the git tag of the commit,
+	// if it is tagged.
+	versionLdFlag = " -X '$PACKAGE/version.Version=$VERSION'"
 )
 
 // Allow user to override Go executable on UNIX-like systems.
@@ -29,7 +35,14 @@ func init() {
 
 // Build binary
 func Build() error {
-	return sh.RunWith(flagEnv(), goexe, "build", "-ldflags", ldFlags,
+	env := flagEnv()
+
+	flags := ldFlags
+	if env["VERSION"] != "" {
+		flags += versionLdFlag
+	}
+
+	return sh.RunWith(env, goexe, "build", "-ldflags", flags,
 		entryPoint)
 }
 
@@ -56,6 +69,7 @@ func Lint() error {
 
 func flagEnv() map[string]string {
 	hash, _ := sh.Output("git", "rev-parse", "--short", "HEAD")
+	tag, _ := sh.Output("git", "describe", "--tags", "--exact-match")
 
 	build := "development"
 	if mode := os.Getenv("GIN_MODE"); mode == "release" {
@@ -63,8 +77,10 @@ func flagEnv() map[string]string {
 	}
 
 	return map[string]string{
-		"PACKAGE":     "satstack",
+		"PACKAGE":     "github.com/ledgerhq/satstack",
 		"COMMIT_HASH": hash,
 		"BUILD":       build,
+		"BUILD_DATE":  time.Now().UTC().Format(time.RFC3339),
+		"VERSION":     tag,
 	}
 }
@@ -0,0 +1,119 @@
+// Package metrics defines the Prometheus collectors exported by SatStack on
+// the /metrics endpoint.
+//
+// All collectors are safe for concurrent use, and recording a value never
+// blocks. They are always updated, but only exposed over HTTP when the
+// metrics option is enabled in the configuration.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "satstack"
+
+var (
+	// Status is a gauge labeled by chain and status value. Exactly one
+	// label has the value 1 for each chain at any time; the others are set
+	// to 0.
+	Status = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "status",
+		Help:      "Current status of SatStack (1 for the active status).",
+	}, []string{"chain", "status"})
+
+	// SyncProgress is the block verification progress of bitcoind, in
+	// percent, by chain.
+	SyncProgress = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "sync_progress",
+		Help:      "Block verification progress of bitcoind, in percent.",
+	}, []string{"chain"})
+
+	// ScanProgress is the progress of the wallet rescan, in percent, by
+	// chain.
+	ScanProgress = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "scan_progress",
+		Help:      "Wallet rescan progress, in percent.",
+	}, []string{"chain"})
+
+	// RPCCalls counts the RPC calls made to bitcoind, by method.
+	RPCCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rpc_calls_total",
+		Help:      "Number of RPC calls made to bitcoind, by method.",
+	}, []string{"method"})
+
+	// RPCErrors counts the RPC calls to bitcoind that returned an error, by
+	// method.
+	RPCErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rpc_errors_total",
+		Help:      "Number of failed RPC calls made to bitcoind, by method.",
+	}, []string{"method"})
+
+	// HTTPRequestDuration is a histogram of HTTP request latencies, by
+	// route.
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests served by SatStack, by route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "code"})
+
+	registry = prometheus.NewRegistry()
+)
+
+func init() {
+	registry.MustRegister(
+		Status,
+		SyncProgress,
+		ScanProgress,
+		RPCCalls,
+		RPCErrors,
+		HTTPRequestDuration,
+	)
+}
+
+// ObserveRPC records the outcome of an RPC call to bitcoind.
+func ObserveRPC(method string, err error) {
+	RPCCalls.WithLabelValues(method).Inc()
+
+	if err != nil {
+		RPCErrors.WithLabelValues(method).Inc()
+	}
+}
+
+// SetStatus marks the given status as active for the chain, and resets the
+// others among the list of known statuses.
+func SetStatus(chain string, status string, known []string) {
+	for _, s := range known {
+		var value float64
+		if s == status {
+			value = 1
+		}
+
+		Status.WithLabelValues(chain, s).Set(value)
+	}
+}
+
+// RegisterCacheSize exports the number of entries held by an internal cache.
+// The size function is evaluated on every scrape, and must not block.
+func RegisterCacheSize(chain string, cache string, size func() float64) {
+	registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        "cache_entries",
+		Help:        "Number of entries held by an internal cache.",
+		ConstLabels: prometheus.Labels{"chain": chain, "cache": cache},
+	}, size))
+}
+
+// Handler returns an http.Handler that serves the collected metrics in the
+// Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSetStatus(t *testing.T) {
+	known := []string{"initializing", "syncing", "ready"}
+
+	for _, status := range known {
+		SetStatus("test", status, known)
+
+		for _, s := range known {
+			want := 0.0
+			if s == status {
+				want = 1
+			}
+
+			if got := testutil.ToFloat64(Status.WithLabelValues("test", s)); got != want {
+				t.Errorf("after SetStatus(%s): status{status=%s} = %v, want %v", status, s, got, want)
+			}
+		}
+	}
+}
+
+func TestObserveRPC(t *testing.T) {
+	calls := testutil.ToFloat64(RPCCalls.WithLabelValues("test"))
+	errs := testutil.ToFloat64(RPCErrors.WithLabelValues("test"))
+
+	ObserveRPC("test", nil)
+	ObserveRPC("test", errors.New("connection refused"))
+
+	if got := testutil.ToFloat64(RPCCalls.WithLabelValues("test")) - calls; got != 2 {
+		t.Errorf("rpc_calls_total increased by %v, want 2", got)
+	}
+
+	if got := testutil.ToFloat64(RPCErrors.WithLabelValues("test")) - errs; got != 1 {
+		t.Errorf("rpc_errors_total increased by %v, want 1", got)
+	}
+}
+
+func TestRegisterCacheSize(t *testing.T) {
+	size := 3.0
+	RegisterCacheSize("test", "blocks", func() float64 { return size })
+
+	expected := `
+# HELP satstack_cache_entries Number of entries held by an internal cache.
+# TYPE satstack_cache_entries gauge
+satstack_cache_entries{cache="blocks",chain="test"} 3
+`
+
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "satstack_cache_entries"); err != nil {
+		t.Error(err)
+	}
+
+	// The size is evaluated on every scrape.
+	size = 5
+
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(strings.Replace(expected, "} 3", "} 5", 1)),
+		"satstack_cache_entries"); err != nil {
+		t.Error(err)
+	}
+}
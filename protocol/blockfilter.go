@@ -0,0 +1,171 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+const (
+	// basicFilterP and basicFilterM are the Golomb-Rice coding parameter and
+	// the false positive rate parameter of basic block filters, as specified
+	// in BIP-0158.
+	basicFilterP = 19
+	basicFilterM = 784931
+)
+
+// errFilterTruncated indicates that a block filter ended before all its
+// items were decoded.
+var errFilterTruncated = errors.New("block filter truncated")
+
+// MatchBasicFilter returns true if the serialized BIP-0158 basic filter of
+// the block with the given hash may contain the script, which is any output
+// script created or spent in the block. False positives happen at a rate of
+// 1/784931, but there are no false negatives.
+//
+// The gcs package of btcutil depends on modules that SatStack does not
+// vendor, so the filter is decoded here.
+func MatchBasicFilter(filter []byte, blockHash *chainhash.Hash, script []byte) (bool, error) {
+	reader := bytes.NewReader(filter)
+
+	n, err := wire.ReadVarInt(reader, 0)
+	if err != nil {
+		return false, err
+	}
+
+	if n == 0 {
+		return false, nil
+	}
+
+	// The items are hashed with SipHash-2-4, keyed by the first 16 bytes of
+	// the block hash, and mapped uniformly to [0, N*M).
+	k0 := binary.LittleEndian.Uint64(blockHash[0:8])
+	k1 := binary.LittleEndian.Uint64(blockHash[8:16])
+
+	target, _ := bits.Mul64(sipHash24(k0, k1, script), n*basicFilterM)
+
+	stream := bitReader{data: filter[len(filter)-reader.Len():]}
+
+	var value uint64
+	for i := uint64(0); i < n; i++ {
+		delta, err := stream.readGolombRice(basicFilterP)
+		if err != nil {
+			return false, err
+		}
+
+		value += delta
+
+		switch {
+		case value == target:
+			return true, nil
+		case value > target:
+			return false, nil // items are sorted
+		}
+	}
+
+	return false, nil
+}
+
+// bitReader reads a bit stream, most significant bit first.
+type bitReader struct {
+	data []byte
+	pos  uint // in bits
+}
+
+func (r *bitReader) readBit() (uint64, error) {
+	if r.pos >= uint(len(r.data))*8 {
+		return 0, errFilterTruncated
+	}
+
+	bit := (r.data[r.pos/8] >> (7 - r.pos%8)) & 1
+	r.pos++
+
+	return uint64(bit), nil
+}
+
+// readGolombRice reads a Golomb-Rice coded value: the quotient in unary,
+// followed by the remainder in p bits.
+func (r *bitReader) readGolombRice(p uint) (uint64, error) {
+	var quotient uint64
+	for {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+
+		if bit == 0 {
+			break
+		}
+
+		quotient++
+	}
+
+	var remainder uint64
+	for i := uint(0); i < p; i++ {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+
+		remainder = remainder<<1 | bit
+	}
+
+	return quotient<<p | remainder, nil
+}
+
+// sipHash24 computes the SipHash-2-4 of data, with the key (k0, k1).
+func sipHash24(k0, k1 uint64, data []byte) uint64 {
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573
+
+	round := func() {
+		v0 += v1
+		v1 = bits.RotateLeft64(v1, 13)
+		v1 ^= v0
+		v0 = bits.RotateLeft64(v0, 32)
+		v2 += v3
+		v3 = bits.RotateLeft64(v3, 16)
+		v3 ^= v2
+		v0 += v3
+		v3 = bits.RotateLeft64(v3, 21)
+		v3 ^= v0
+		v2 += v1
+		v1 = bits.RotateLeft64(v1, 17)
+		v1 ^= v2
+		v2 = bits.RotateLeft64(v2, 32)
+	}
+
+	length := len(data)
+	for len(data) >= 8 {
+		m := binary.LittleEndian.Uint64(data)
+		v3 ^= m
+		round()
+		round()
+		v0 ^= m
+		data = data[8:]
+	}
+
+	last := uint64(length) << 56
+	for idx, c := range data {
+		last |= uint64(c) << (8 * uint(idx))
+	}
+
+	v3 ^= last
+	round()
+	round()
+	v0 ^= last
+
+	v2 ^= 0xff
+	round()
+	round()
+	round()
+	round()
+
+	return v0 ^ v1 ^ v2 ^ v3
+}
@@ -0,0 +1,98 @@
+package protocol
+
+import (
+	"encoding/hex"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+// Normalized types of output scripts, reported in the script_type of the
+// outputs of transactions.
+const (
+	ScriptTypeP2PK        = "p2pk"
+	ScriptTypeP2PKH       = "p2pkh"
+	ScriptTypeP2SH        = "p2sh"
+	ScriptTypeP2WPKH      = "p2wpkh"
+	ScriptTypeP2WSH       = "p2wsh"
+	ScriptTypeP2TR        = "p2tr"
+	ScriptTypeMultisig    = "multisig"
+	ScriptTypeOpReturn    = "op_return"
+	ScriptTypeNonStandard = "nonstandard"
+)
+
+// ScriptType returns the normalized type of the passed output script.
+//
+// Any script starting with OP_RETURN is provably unspendable, and classified
+// as op_return, even if it is larger than the standard data carrier size.
+func ScriptType(pkScript []byte) string {
+	if isPayToTaproot(pkScript) {
+		return ScriptTypeP2TR
+	}
+
+	if len(pkScript) > 0 && pkScript[0] == txscript.OP_RETURN {
+		return ScriptTypeOpReturn
+	}
+
+	switch txscript.GetScriptClass(pkScript) {
+	case txscript.PubKeyTy:
+		return ScriptTypeP2PK
+	case txscript.PubKeyHashTy:
+		return ScriptTypeP2PKH
+	case txscript.ScriptHashTy:
+		return ScriptTypeP2SH
+	case txscript.WitnessV0PubKeyHashTy:
+		return ScriptTypeP2WPKH
+	case txscript.WitnessV0ScriptHashTy:
+		return ScriptTypeP2WSH
+	case txscript.MultiSigTy:
+		return ScriptTypeMultisig
+	default:
+		return ScriptTypeNonStandard
+	}
+}
+
+// NullData returns the hex-encoded data pushed by the passed OP_RETURN
+// script, concatenated, or an empty string if the script is not an OP_RETURN
+// script, pushes no data, or cannot be parsed.
+func NullData(pkScript []byte) string {
+	if len(pkScript) == 0 || pkScript[0] != txscript.OP_RETURN {
+		return ""
+	}
+
+	pushes, err := txscript.PushedData(pkScript[1:])
+	if err != nil {
+		return ""
+	}
+
+	var data []byte
+	for _, push := range pushes {
+		data = append(data, push...)
+	}
+
+	return hex.EncodeToString(data)
+}
+
+// ScriptAddresses returns all the addresses of the passed output script,
+// which has several for bare multisig scripts, or nil if it has none.
+func ScriptAddresses(pkScript []byte, chainParams *chaincfg.Params) []string {
+	if isPayToTaproot(pkScript) {
+		if addr, err := encodeTaprootAddress(pkScript, chainParams); err == nil {
+			return []string{addr}
+		}
+	}
+
+	// Ignore the error here since an error means the script couldn't parse.
+	_, addrs, _, _ := txscript.ExtractPkScriptAddrs(pkScript, chainParams)
+
+	if len(addrs) == 0 {
+		return nil
+	}
+
+	ret := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		ret = append(ret, addr.EncodeAddress())
+	}
+
+	return ret
+}
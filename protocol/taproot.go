@@ -0,0 +1,142 @@
+package protocol
+
+import (
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcutil/bech32"
+)
+
+const (
+	// bech32mConst is the constant used in the checksum of bech32m strings,
+	// as specified in BIP-0350. Plain bech32 uses 1 instead.
+	bech32mConst = 0x2bc830a3
+
+	bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+)
+
+// isPayToTaproot returns true if the script is a segwit v1 output with a
+// 32-byte witness program (P2TR), as specified in BIP-0341.
+//
+// The btcd version used by SatStack predates Taproot, and does not classify
+// such scripts.
+func isPayToTaproot(script []byte) bool {
+	return len(script) == 34 &&
+		script[0] == txscript.OP_1 &&
+		script[1] == txscript.OP_DATA_32
+}
+
+// encodeTaprootAddress encodes the witness program of a P2TR script as a
+// bech32m address for the given network.
+func encodeTaprootAddress(script []byte, params *chaincfg.Params) (string, error) {
+	program, err := bech32.ConvertBits(script[2:], 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+
+	// Prepend the witness version.
+	data := append([]byte{1}, program...)
+
+	hrp := strings.ToLower(params.Bech32HRPSegwit)
+	checksum := bech32mChecksum(hrp, data)
+
+	var b strings.Builder
+	b.WriteString(hrp)
+	b.WriteByte('1')
+
+	for _, v := range append(data, checksum...) {
+		b.WriteByte(bech32Charset[v])
+	}
+
+	return b.String(), nil
+}
+
+// IsTaprootAddress returns true if the address is a valid bech32m P2TR
+// address for the given network.
+//
+// The btcutil version used by SatStack predates Taproot, and rejects segwit
+// v1 addresses.
+func IsTaprootAddress(address string, params *chaincfg.Params) bool {
+	// Mixed case strings are invalid.
+	lower := strings.ToLower(address)
+	if address != lower && address != strings.ToUpper(address) {
+		return false
+	}
+
+	sep := strings.LastIndexByte(lower, '1')
+	if sep < 0 || lower[:sep] != strings.ToLower(params.Bech32HRPSegwit) {
+		return false
+	}
+
+	data := make([]byte, 0, len(lower)-sep-1)
+	for _, c := range lower[sep+1:] {
+		v := strings.IndexRune(bech32Charset, c)
+		if v < 0 {
+			return false
+		}
+
+		data = append(data, byte(v))
+	}
+
+	// Witness version, 32-byte witness program, and checksum.
+	if len(data) != 1+52+6 || data[0] != 1 {
+		return false
+	}
+
+	if bech32Polymod(append(bech32HrpExpand(lower[:sep]), data...)) != bech32mConst {
+		return false
+	}
+
+	program, err := bech32.ConvertBits(data[1:len(data)-6], 5, 8, false)
+	return err == nil && len(program) == 32
+}
+
+func bech32mChecksum(hrp string, data []byte) []byte {
+	values := append(bech32HrpExpand(hrp), data...)
+	values = append(values, 0, 0, 0, 0, 0, 0)
+
+	polymod := bech32Polymod(values) ^ bech32mConst
+
+	checksum := make([]byte, 6)
+	for i := range checksum {
+		checksum[i] = byte((polymod >> uint(5*(5-i))) & 31)
+	}
+
+	return checksum
+}
+
+func bech32HrpExpand(hrp string) []byte {
+	ret := make([]byte, 0, len(hrp)*2+1)
+	for _, c := range []byte(hrp) {
+		ret = append(ret, c>>5)
+	}
+
+	ret = append(ret, 0)
+
+	for _, c := range []byte(hrp) {
+		ret = append(ret, c&31)
+	}
+
+	return ret
+}
+
+func bech32Polymod(values []byte) uint32 {
+	generator := [5]uint32{
+		0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3,
+	}
+
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+
+	return chk
+}
@@ -0,0 +1,113 @@
+package protocol
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// taprootVectors are the output keys of the first receive addresses of the
+// BIP-0086 test vectors, for tr(xpub6BgBgsespWvERF3LHQu6CnqdvfEvtMcQjYrcRzx53QJjSxarj2afYWcLteoGVky7D3UKDP9QyrLprQ3VCECoY49yfdDEHGCtMMj92pReUsQ/0/*),
+// along with the BIP-0350 segwit v1 vector, and their bech32m addresses.
+var taprootVectors = []struct {
+	outputKey string
+	mainnet   string
+	testnet   string
+}{
+	{
+		"a60869f0dbcf1dc659c9cecbaf8050135ea9e8cdc487053f1dc6880949dc684c",
+		"bc1p5cyxnuxmeuwuvkwfem96lqzszd02n6xdcjrs20cac6yqjjwudpxqkedrcr",
+		"tb1p5cyxnuxmeuwuvkwfem96lqzszd02n6xdcjrs20cac6yqjjwudpxqp3mvzv",
+	},
+	{
+		"a82f29944d65b86ae6b5e5cc75e294ead6c59391a1edc5e016e3498c67fc7bbb",
+		"bc1p4qhjn9zdvkux4e44uhx8tc55attvtyu358kutcqkudyccelu0was9fqzwh",
+		"tb1p4qhjn9zdvkux4e44uhx8tc55attvtyu358kutcqkudyccelu0wasjpkd5c",
+	},
+	{
+		"000000c4a5cad46221b2a187905e5266362b99d5e91c6ce24d165dab93e86433",
+		"bc1pqqqqp399et2xygdj5xreqhjjvcmzhxw4aywxecjdzew6hylgvses7epu4h",
+		"tb1pqqqqp399et2xygdj5xreqhjjvcmzhxw4aywxecjdzew6hylgvsesf3hn0c",
+	},
+}
+
+// taprootScript returns the P2TR output script of an output key.
+func taprootScript(t *testing.T, outputKey string) []byte {
+	key, err := hex.DecodeString(outputKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return append([]byte{0x51, 0x20}, key...)
+}
+
+func TestEncodeTaprootAddress(t *testing.T) {
+	for _, vector := range taprootVectors {
+		script := taprootScript(t, vector.outputKey)
+
+		if !isPayToTaproot(script) || ScriptType(script) != ScriptTypeP2TR {
+			t.Errorf("%s: script not classified as P2TR", vector.outputKey)
+		}
+
+		for params, want := range map[*chaincfg.Params]string{
+			&chaincfg.MainNetParams:  vector.mainnet,
+			&chaincfg.TestNet3Params: vector.testnet,
+		} {
+			if got := ScriptAddress(script, params); got != want {
+				t.Errorf("%s on %s: got address %s, want %s", vector.outputKey, params.Name, got, want)
+			}
+
+			if !IsTaprootAddress(want, params) {
+				t.Errorf("%s on %s: address %s rejected", vector.outputKey, params.Name, want)
+			}
+		}
+	}
+}
+
+func TestDecodeTaprootOutput(t *testing.T) {
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{1}, 0), nil, nil))
+	for _, vector := range taprootVectors {
+		tx.AddTxOut(wire.NewTxOut(1000, taprootScript(t, vector.outputKey)))
+	}
+
+	decoded := DecodeMsgTx(tx, &chaincfg.TestNet3Params)
+	for idx, vector := range taprootVectors {
+		output := decoded.Outputs[idx]
+		if output.Address != vector.testnet || output.ScriptType != ScriptTypeP2TR {
+			t.Errorf("output %d: got %s (%s), want %s (%s)",
+				idx, output.Address, output.ScriptType, vector.testnet, ScriptTypeP2TR)
+		}
+	}
+}
+
+func TestIsTaprootAddressInvalid(t *testing.T) {
+	valid := taprootVectors[0].testnet
+
+	tests := []struct {
+		name    string
+		address string
+	}{
+		{"mainnet address", taprootVectors[0].mainnet},
+		{"mixed case", "tb1P" + valid[4:]},
+		{"bad checksum", valid[:len(valid)-1] + "q"},
+		{"segwit v0", "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx"},
+		{"bech32 checksum", "tb1pw508d6qejxtdg4y5r3zarqfsj6c3"},
+		{"invalid character", strings.Replace(valid, "p5c", "pbc", 1)},
+		{"empty", ""},
+	}
+
+	for _, test := range tests {
+		if IsTaprootAddress(test.address, &chaincfg.TestNet3Params) {
+			t.Errorf("%s: address %q accepted", test.name, test.address)
+		}
+	}
+
+	if !IsTaprootAddress(strings.ToUpper(valid), &chaincfg.TestNet3Params) {
+		t.Error("uppercase address rejected")
+	}
+}
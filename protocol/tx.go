@@ -10,13 +10,12 @@ import (
 	"github.com/btcsuite/btcd/blockchain"
 	"github.com/btcsuite/btcd/btcjson"
 	"github.com/btcsuite/btcd/chaincfg"
-	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
 	"github.com/ledgerhq/satstack/types"
 )
 
-func ParseVerboseTransaction(txRaw *btcjson.TxRawResult) *types.Transaction {
+func ParseVerboseTransaction(txRaw *btcjson.TxRawResult) (*types.Transaction, error) {
 	var inputs []types.Input
 	for i, input := range txRaw.Vin {
 		var scriptSig *string
@@ -37,7 +36,11 @@ func ParseVerboseTransaction(txRaw *btcjson.TxRawResult) *types.Transaction {
 
 	var outputs []types.Output
 	for _, output := range txRaw.Vout {
-		val := utils.ParseSatoshi(output.Value)
+		val, err := utils.ParseSatoshi(output.Value)
+		if err != nil {
+			return nil, fmt.Errorf("output %d of %s: %w", output.N, txRaw.Hash, err)
+		}
+
 		var addr string
 		if addrs := output.ScriptPubKey.Addresses; len(addrs) > 0 {
 			addr = addrs[0]
@@ -57,7 +60,7 @@ func ParseVerboseTransaction(txRaw *btcjson.TxRawResult) *types.Transaction {
 		LockTime: txRaw.LockTime,
 		Inputs:   inputs,
 		Outputs:  nil,
-	}
+	}, nil
 }
 
 func DecodeMsgTx(msgTx *wire.MsgTx, params *chaincfg.Params) *types.Transaction {
@@ -136,30 +139,14 @@ func createVoutList(mtx *wire.MsgTx, chainParams *chaincfg.Params) []types.Outpu
 		value := btcutil.Amount(v.Value)
 		vout.Value = &value
 		vout.ScriptHex = hex.EncodeToString(v.PkScript)
-
-		// Ignore the error here since an error means the script
-		// couldn't parse. In such a case, addrs will be nil.
-		_, addrs, _, _ := txscript.ExtractPkScriptAddrs(
-			v.PkScript, chainParams)
-
-		// Encode the addresses to to string.
-		encodedAddrs := make([]string, len(addrs))
-		for j, addr := range addrs {
-			encodedAddr := addr.EncodeAddress()
-			encodedAddrs[j] = encodedAddr
-		}
-
-		// ScriptPubKey can have multiple addresses for multisig transactions.
-		//
-		// We pick the first address in the list, which is what libcore
-		// expects. Caution: may have side-effects.
-		//
-		// In case of no addresses, the Address field is not populated.
-		// Generally, this means the ScriptPubKey is corrupt.
-		//
-		// Ref: https://bitcoin.stackexchange.com/a/4693/106367
-		if len(encodedAddrs) > 0 {
-			vout.Address = encodedAddrs[0]
+		vout.ScriptType = ScriptType(v.PkScript)
+		vout.Address = ScriptAddress(v.PkScript, chainParams)
+		vout.Data = NullData(v.PkScript)
+
+		// The first address is reported in Address; bare multisig scripts
+		// list all of them.
+		if addrs := ScriptAddresses(v.PkScript, chainParams); len(addrs) > 1 {
+			vout.Addresses = addrs
 		}
 
 		voutList = append(voutList, vout)
@@ -168,6 +155,27 @@ func createVoutList(mtx *wire.MsgTx, chainParams *chaincfg.Params) []types.Outpu
 	return voutList
 }
 
+// ScriptAddress returns the address of the passed output script, or an empty
+// string if the script has no address.
+func ScriptAddress(pkScript []byte, chainParams *chaincfg.Params) string {
+	addrs := ScriptAddresses(pkScript, chainParams)
+
+	// ScriptPubKey can have multiple addresses for multisig transactions.
+	//
+	// We pick the first address in the list, which is what libcore expects.
+	// Caution: may have side-effects.
+	//
+	// In case of no addresses, the Address field is not populated.
+	// Generally, this means the ScriptPubKey is corrupt.
+	//
+	// Ref: https://bitcoin.stackexchange.com/a/4693/106367
+	if len(addrs) == 0 {
+		return ""
+	}
+
+	return addrs[0]
+}
+
 // witnessToHex formats the passed witness stack as a slice of hex-encoded
 // strings to be used in a JSON response.
 func witnessToHex(witness wire.TxWitness) []string {
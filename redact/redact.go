@@ -0,0 +1,194 @@
+// Package redact hides the identifying values logged by SatStack, like
+// addresses, txids and extended keys, so that logs can be shared in bug
+// reports without revealing the accounts they relate to.
+//
+// Such values are logged with the field types of this package, for ex:
+//
+//	log.WithField("hash", redact.TxID(hash)).Info("Transaction broadcast")
+//
+// which are redacted when formatted, as text or as JSON, while privacy mode
+// is enabled. Privacy mode is enabled by default.
+package redact
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// disabled is 1 while privacy mode is disabled. Access it atomically.
+var disabled int32
+
+var (
+	// extendedKeyPattern matches the extended keys of a descriptor.
+	extendedKeyPattern = regexp.MustCompile(`[1-9A-HJ-NP-Za-km-z]{100,}`)
+
+	// addrPattern matches the address of an addr() descriptor.
+	addrPattern = regexp.MustCompile(`addr\(([^)]*)\)`)
+)
+
+// SetEnabled enables or disables privacy mode.
+func SetEnabled(enabled bool) {
+	var value int32
+	if !enabled {
+		value = 1
+	}
+
+	atomic.StoreInt32(&disabled, value)
+}
+
+// Enabled indicates whether privacy mode is enabled.
+func Enabled() bool {
+	return atomic.LoadInt32(&disabled) == 0
+}
+
+// Abbreviate keeps the first and last 4 characters of the value, for ex an
+// address or a txid, while privacy mode is enabled.
+func Abbreviate(value string) string {
+	if !Enabled() || len(value) <= 12 {
+		return value
+	}
+
+	return value[:4] + "..." + value[len(value)-4:]
+}
+
+// Fingerprint returns a short fingerprint of an extended key, made of its
+// prefix and of the beginning of its SHA-256 hash, for ex xpub:1a2b3c4d. The
+// key cannot be recovered from it, but the same key always has the same
+// fingerprint.
+func Fingerprint(key string) string {
+	sum := sha256.Sum256([]byte(key))
+
+	prefix := key
+	if len(prefix) > 4 {
+		prefix = prefix[:4]
+	}
+
+	return prefix + ":" + hex.EncodeToString(sum[:4])
+}
+
+// DescriptorString replaces the extended keys of the descriptor with their
+// fingerprint, and abbreviates the address of addr() descriptors, while
+// privacy mode is enabled.
+func DescriptorString(desc string) string {
+	if !Enabled() {
+		return desc
+	}
+
+	desc = extendedKeyPattern.ReplaceAllStringFunc(desc, Fingerprint)
+
+	return addrPattern.ReplaceAllStringFunc(desc, func(match string) string {
+		address := match[len("addr(") : len(match)-1]
+		return "addr(" + Abbreviate(address) + ")"
+	})
+}
+
+// Address is the type of the log fields holding an address.
+type Address string
+
+func (a Address) String() string {
+	return Abbreviate(string(a))
+}
+
+// MarshalText implements the encoding.TextMarshaler interface, used by the
+// JSON formatter of the logs.
+func (a Address) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// Addresses is the type of the log fields holding a list of addresses.
+type Addresses []string
+
+func (a Addresses) String() string {
+	ret := make([]string, len(a))
+	for idx, address := range a {
+		ret[idx] = Abbreviate(address)
+	}
+
+	return "[" + strings.Join(ret, " ") + "]"
+}
+
+// MarshalText implements the encoding.TextMarshaler interface.
+func (a Addresses) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// TxID is the type of the log fields holding the hash of a transaction.
+type TxID string
+
+func (t TxID) String() string {
+	return Abbreviate(string(t))
+}
+
+// MarshalText implements the encoding.TextMarshaler interface.
+func (t TxID) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// Descriptor is the type of the log fields holding a descriptor, or the ID
+// of an account, which is either a descriptor or an address.
+type Descriptor string
+
+func (d Descriptor) String() string {
+	if !strings.Contains(string(d), "(") {
+		return Abbreviate(string(d))
+	}
+
+	return DescriptorString(string(d))
+}
+
+// MarshalText implements the encoding.TextMarshaler interface.
+func (d Descriptor) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// RawTx is the type of the log fields holding a hex-encoded transaction,
+// which is replaced with its size while privacy mode is enabled.
+type RawTx string
+
+func (r RawTx) String() string {
+	if !Enabled() {
+		return string(r)
+	}
+
+	return fmt.Sprintf("<%d bytes>", len(r)/2)
+}
+
+// MarshalText implements the encoding.TextMarshaler interface.
+func (r RawTx) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// Path is the type of the log fields holding the path of an HTTP request,
+// whose elements longer than 24 characters, like addresses and txids, are
+// abbreviated while privacy mode is enabled. The elements are separated by
+// slashes, or by commas for lists of addresses.
+type Path string
+
+func (p Path) String() string {
+	if !Enabled() {
+		return string(p)
+	}
+
+	segments := strings.Split(string(p), "/")
+	for idx, segment := range segments {
+		items := strings.Split(segment, ",")
+		for i, item := range items {
+			if len(item) > 24 {
+				items[i] = Abbreviate(item)
+			}
+		}
+
+		segments[idx] = strings.Join(items, ",")
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// MarshalText implements the encoding.TextMarshaler interface.
+func (p Path) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
@@ -35,7 +35,72 @@ type Output struct {
 	OutputIndex *uint32         `json:"output_index,omitempty"` // Used to uniquely identify an output in a transaction
 	Value       *btcutil.Amount `json:"value,omitempty"`        // Value of output in satoshis
 	ScriptHex   string          `json:"script_hex"`             // Hex-encoded script
+	ScriptType  string          `json:"script_type"`            // Normalized script type, for ex p2wpkh or op_return
 	Address     string          `json:"address,omitempty"`      // Address of the UTXO; can be empty
+	Addresses   []string        `json:"addresses,omitempty"`    // All the addresses of bare multisig scripts
+	Data        string          `json:"data,omitempty"`         // Hex-encoded data of OP_RETURN scripts
+	Derivation  *Derivation     `json:"derivation,omitempty"`   // Set if the address belongs to a configured account
+}
+
+// Derivation describes how an address is derived from the descriptors of a
+// configured account.
+type Derivation struct {
+	Account string `json:"account"` // external descriptor or address of the account, as configured
+	Label   string `json:"label"`   // label of the account, or a fingerprint of its extended key
+	Chain   string `json:"chain"`   // external or change
+	Index   int    `json:"index"`   // 0 for a single address
+}
+
+// UnspentOutput models an unspent output of the wallet.
+type UnspentOutput struct {
+	TxID          string         `json:"txid"`
+	Vout          uint32         `json:"vout"`
+	Value         btcutil.Amount `json:"value"`         // Value of output in satoshis
+	Confirmations int64          `json:"confirmations"` // 0 for unconfirmed outputs
+	ScriptHex     string         `json:"script_hex"`    // Hex-encoded script
+	Address       string         `json:"address"`
+	Change        bool           `json:"change"`          // whether the address is a change address of the wallet
+	Label         string         `json:"label,omitempty"` // label of the account of the address; see Derivation
+}
+
+// AddressUTXOs models the response of the GetUTXOs handler.
+type AddressUTXOs struct {
+	UTXOs    []UnspentOutput `json:"utxos"`
+	Total    btcutil.Amount  `json:"total"`              // Sum of the values in satoshis
+	Warnings []string        `json:"warnings,omitempty"` // invalid addresses, or unknown to the wallet
+}
+
+// AddressBalance models the balance of an address, or of a set of addresses.
+// All values are in satoshis.
+type AddressBalance struct {
+	Confirmed   btcutil.Amount `json:"confirmed"`      // balance, considering confirmed transactions only
+	Unconfirmed btcutil.Amount `json:"unconfirmed"`    // change of the balance by unconfirmed transactions
+	Received    btcutil.Amount `json:"total_received"` // sum of all the outputs received, including unconfirmed ones
+}
+
+// AddressBalances models the response of the GetBalances handler.
+type AddressBalances struct {
+	Total     AddressBalance            `json:"total"`
+	Addresses map[string]AddressBalance `json:"addresses"`
+}
+
+// MempoolHistogram models the response of the GetMempool handler: the
+// distribution of the fee rates of the transactions in the mempool.
+type MempoolHistogram struct {
+	Size          int64           `json:"size"`            // number of transactions
+	Bytes         int64           `json:"bytes"`           // sum of the virtual sizes of the transactions
+	MempoolMinFee btcutil.Amount  `json:"mempool_min_fee"` // minimum fee rate to enter the mempool, in satoshis per kvB
+	Buckets       []MempoolBucket `json:"buckets"`         // by increasing fee rate
+}
+
+// MempoolBucket models the transactions of the mempool within a range of
+// fee rates, in satoshis per vbyte. The range includes MinFeeRate, and
+// excludes MaxFeeRate, which is omitted for the last bucket.
+type MempoolBucket struct {
+	MinFeeRate int64  `json:"min_fee_rate"`
+	MaxFeeRate *int64 `json:"max_fee_rate,omitempty"`
+	Count      int64  `json:"count"`
+	VSize      int64  `json:"vsize"` // sum of the virtual sizes of the transactions
 }
 
 // Block models data corresponding to a block, but with limited information.
@@ -48,6 +113,43 @@ type Block struct {
 	Transactions *[]string `json:"txs,omitempty"` // optional list of 0x prefixed transaction IDs
 }
 
+// BlockHeader models the header of a block, along with its position in the
+// best chain.
+type BlockHeader struct {
+	Hash              string `json:"hash"`
+	Height            int64  `json:"height"`
+	Time              string `json:"time"`        // RFC3339 format
+	MedianTime        string `json:"median_time"` // RFC3339 format
+	PreviousBlockHash string `json:"previous_block_hash,omitempty"`
+	MerkleRoot        string `json:"merkle_root"`
+	Confirmations     int64  `json:"confirmations"` // -1 if not in the best chain
+}
+
+// BlockStats models the fee statistics of a block. Fees are in satoshis, and
+// fee rates in satoshis per vbyte, rounded down like bitcoind does. The
+// coinbase transaction is not counted in the fee rates.
+type BlockStats struct {
+	Hash          string         `json:"hash"`
+	Height        int64          `json:"height"`
+	Time          string         `json:"time"`     // RFC3339 format
+	TxCount       int64          `json:"tx_count"` // including the coinbase transaction
+	TotalFees     btcutil.Amount `json:"total_fees"`
+	MedianFeeRate int64          `json:"median_fee_rate"` // weighted by transaction weight
+	MinFeeRate    int64          `json:"min_fee_rate"`
+	MaxFeeRate    int64          `json:"max_fee_rate"`
+	AvgFeeRate    int64          `json:"avg_fee_rate"`
+	Source        string         `json:"source"` // getblockstats, or block if computed from the block
+}
+
+// BlockTime models the last block of the best chain with a median time not
+// after a given time.
+type BlockTime struct {
+	Hash       string `json:"hash"`        // 0x prefixed
+	Height     int64  `json:"height"`      // integer
+	MedianTime string `json:"median_time"` // RFC3339 format
+	Future     bool   `json:"future"`      // whether the given time is in the future
+}
+
 // BlockWithTransactions is a struct that embeds Block, but also contains
 // transaction hashes.
 type BlockWithTransactions struct {
@@ -61,15 +163,50 @@ type Transaction struct {
 	Hash          string          `json:"hash"`
 	ReceivedAt    string          `json:"received_at"`
 	LockTime      uint32          `json:"lock_time"`
-	Fees          *btcutil.Amount `json:"fees"`
+	Fees          *btcutil.Amount `json:"fees,omitempty"`
 	Amount        *btcutil.Amount `json:"amount,omitempty"` // legacy field for v2 explorer
 	Confirmations uint64          `json:"confirmations"`
 	Inputs        []Input         `json:"inputs"`
 	Outputs       []Output        `json:"outputs"`
 	Block         *Block          `json:"block"`
+	Mempool       *MempoolInfo    `json:"mempool,omitempty"`    // only for unconfirmed transactions
+	FirstSeen     string          `json:"first_seen,omitempty"` // time SatStack first saw it unconfirmed (RFC3339)
+}
+
+// MempoolInfo models the mempool data of an unconfirmed transaction.
+type MempoolInfo struct {
+	Replaceable   bool           `json:"replaceable"`    // BIP125 replaceability (opt-in RBF)
+	Time          string         `json:"time"`           // time the transaction entered the mempool (RFC3339)
+	AncestorCount int64          `json:"ancestor_count"` // in-mempool ancestors, including the transaction itself
+	AncestorSize  int64          `json:"ancestor_size"`  // virtual size of the ancestors, in vbytes
+	AncestorFees  btcutil.Amount `json:"ancestor_fees"`  // fees of the ancestors, in satoshis
+}
+
+// MempoolTransaction models the mempool data of a transaction, as served by
+// the mempool endpoint of transactions. Fees are in satoshis, and sizes in
+// vbytes.
+type MempoolTransaction struct {
+	TxID            string         `json:"txid"`
+	Fee             btcutil.Amount `json:"fee"`
+	VSize           int64          `json:"vsize"`
+	FeeRate         float64        `json:"fee_rate"`          // in satoshis per vbyte
+	AncestorCount   int64          `json:"ancestor_count"`    // in-mempool ancestors, including the transaction itself
+	AncestorSize    int64          `json:"ancestor_size"`     // virtual size of the ancestors
+	AncestorFee     btcutil.Amount `json:"ancestor_fee"`      // fees of the ancestors
+	AncestorFeeRate float64        `json:"ancestor_fee_rate"` // effective fee rate with the ancestors, in satoshis per vbyte
+	DescendantCount int64          `json:"descendant_count"`  // in-mempool descendants, including the transaction itself
+	DescendantSize  int64          `json:"descendant_size"`   // virtual size of the descendants
+	DescendantFee   btcutil.Amount `json:"descendant_fee"`    // fees of the descendants
+	Time            string         `json:"time"`              // time the transaction entered the mempool (RFC3339)
+	TimeInMempool   int64          `json:"time_in_mempool"`   // in seconds
+	Replaceable     bool           `json:"replaceable"`       // BIP125 replaceability (opt-in RBF)
 }
 
 type Addresses struct {
 	Truncated    bool          `json:"truncated"`
 	Transactions []Transaction `json:"txs"`
+
+	// Token is the cursor to pass as block_hash to fetch the next page of
+	// transactions. It is only set if more pages are available.
+	Token *string `json:"token,omitempty"`
 }
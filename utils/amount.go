@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcutil"
+)
+
+// ErrInvalidAmount is returned for bitcoin amounts that are not a whole
+// number of satoshis, or beyond the supply of bitcoins.
+var ErrInvalidAmount = errors.New("invalid bitcoin amount")
+
+// satoshiPerBitcoin is btcutil.SatoshiPerBitcoin, as a rational.
+var satoshiPerBitcoin = big.NewRat(btcutil.SatoshiPerBitcoin, 1)
+
+// ParseBTC converts a decimal bitcoin value, for ex 0.00000001 or -1.5 as
+// written in the JSON responses of bitcoind, to satoshis. The conversion is
+// exact, since the value is never represented as a float64.
+//
+// ErrInvalidAmount is returned if the value has more than 8 significant
+// decimals, or if its magnitude exceeds the supply of bitcoins.
+func ParseBTC(value string) (btcutil.Amount, error) {
+	value = strings.TrimSpace(value)
+
+	// Rationals are also parsed from fractions like 1/3, which are not
+	// decimal values.
+	rat, ok := new(big.Rat).SetString(value)
+	if !ok || strings.Contains(value, "/") {
+		return 0, fmt.Errorf("%w: '%s'", ErrInvalidAmount, value)
+	}
+
+	rat.Mul(rat, satoshiPerBitcoin)
+	if !rat.IsInt() {
+		return 0, fmt.Errorf("%w: '%s' is not a whole number of satoshis", ErrInvalidAmount, value)
+	}
+
+	satoshis := rat.Num()
+	if !satoshis.IsInt64() || satoshis.Int64() > btcutil.MaxSatoshi || satoshis.Int64() < -btcutil.MaxSatoshi {
+		return 0, fmt.Errorf("%w: '%s' exceeds the supply of bitcoins", ErrInvalidAmount, value)
+	}
+
+	return btcutil.Amount(satoshis.Int64()), nil
+}
+
+// ParseSatoshi converts a float64 bitcoin value, like the amounts decoded by
+// btcjson, to satoshis. Named after ParseInt function.
+//
+// The value is rounded to the nearest satoshi in decimal, rather than by
+// multiplying the float64, so that the amount is the one bitcoind wrote
+// with 8 decimals, which the float64 is the closest representation of.
+//
+// ErrInvalidAmount is returned for NaN and infinite values, and for values
+// whose magnitude exceeds the supply of bitcoins.
+func ParseSatoshi(value float64) (btcutil.Amount, error) {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidAmount, value)
+	}
+
+	return ParseBTC(strconv.FormatFloat(value, 'f', 8, 64))
+}
+
+// FormatBTC formats an amount in satoshis as a decimal bitcoin value, with 8
+// decimals, for ex -0.00000001. Unlike btcutil.Amount.String, it is exact,
+// and has no unit.
+func FormatBTC(amount btcutil.Amount) string {
+	sign := ""
+	satoshis := uint64(amount)
+	if amount < 0 {
+		sign = "-"
+		satoshis = uint64(-amount)
+	}
+
+	return fmt.Sprintf("%s%d.%08d", sign,
+		satoshis/btcutil.SatoshiPerBitcoin, satoshis%btcutil.SatoshiPerBitcoin)
+}
@@ -0,0 +1,157 @@
+package utils
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/btcsuite/btcutil"
+)
+
+func TestParseSatoshi(t *testing.T) {
+	tests := []struct {
+		value float64
+		want  btcutil.Amount
+	}{
+		{0, 0},
+		{1e-8, 1},
+		{-1e-8, -1},
+		{0.1, 10000000},
+		{0.1 + 0.2, 30000000}, // 0.30000000000000004
+		{1.15, 115000000},     // 114999999.99999999 satoshis as a float64
+		{-1.15, -115000000},
+		{0.29, 29000000}, // 28999999.999999996 satoshis as a float64
+		{20999999.9769, 2099999997690000},
+		{-1.5, -150000000},
+		{0.00095, 95000},
+		{21e6, btcutil.MaxSatoshi},
+		{-21e6, -btcutil.MaxSatoshi},
+	}
+
+	for _, test := range tests {
+		got, err := ParseSatoshi(test.value)
+		if err != nil || got != test.want {
+			t.Errorf("%v: got %d, %v, want %d", test.value, got, err, test.want)
+		}
+	}
+}
+
+func TestParseSatoshiInvalid(t *testing.T) {
+	tests := []float64{
+		math.NaN(),
+		math.Inf(1),
+		math.Inf(-1),
+		21e6 + 1e-8,
+		-21e6 - 1e-8,
+		1e300,
+	}
+
+	for _, value := range tests {
+		if got, err := ParseSatoshi(value); !errors.Is(err, ErrInvalidAmount) {
+			t.Errorf("%v: got %d, %v, want %v", value, got, err, ErrInvalidAmount)
+		}
+	}
+}
+
+func TestParseBTC(t *testing.T) {
+	tests := []struct {
+		value string
+		want  btcutil.Amount
+	}{
+		{"0", 0},
+		{"0.00000001", 1},
+		{"-0.00000001", -1},
+		{"0.1", 10000000},
+		{"20999999.9769", 2099999997690000},
+		{"-20999999.9769", -2099999997690000},
+		{"0.30000000", 30000000},
+		{" 1.5 ", 150000000},
+		{"0.100000000", 10000000}, // trailing zeros are not significant
+		{"21000000", btcutil.MaxSatoshi},
+	}
+
+	for _, test := range tests {
+		got, err := ParseBTC(test.value)
+		if err != nil || got != test.want {
+			t.Errorf("'%s': got %d, %v, want %d", test.value, got, err, test.want)
+		}
+	}
+}
+
+func TestParseSatoshiRoundTrip(t *testing.T) {
+	// The small amounts, then amounts up to the supply of bitcoins, with a
+	// step that is not round, so that the decimals vary.
+	var amounts []btcutil.Amount
+	for amount := btcutil.Amount(0); amount <= 100000; amount++ {
+		amounts = append(amounts, amount)
+	}
+
+	for amount := btcutil.Amount(100000); amount < btcutil.MaxSatoshi; amount += 9999999967 {
+		amounts = append(amounts, amount)
+	}
+
+	amounts = append(amounts, btcutil.MaxSatoshi)
+
+	for _, amount := range amounts {
+		for _, amount := range []btcutil.Amount{amount, -amount} {
+			// The value as written by bitcoind, and as decoded by btcjson.
+			value := FormatBTC(amount)
+			float, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := ParseSatoshi(float)
+			if err != nil || got != amount {
+				t.Fatalf("%v: got %d, %v, want %d", float, got, err, amount)
+			}
+
+			if got, err := ParseBTC(value); err != nil || got != amount {
+				t.Fatalf("'%s': got %d, %v, want %d", value, got, err, amount)
+			}
+		}
+	}
+}
+
+func TestParseBTCInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"abc",
+		"0.000000001",
+		"1/3",
+		"21000000.00000001",
+		"-21000000.00000001",
+		"1e30",
+	}
+
+	for _, value := range tests {
+		if got, err := ParseBTC(value); !errors.Is(err, ErrInvalidAmount) {
+			t.Errorf("'%s': got %d, %v, want %v", value, got, err, ErrInvalidAmount)
+		}
+	}
+}
+
+func TestFormatBTC(t *testing.T) {
+	tests := []struct {
+		amount btcutil.Amount
+		want   string
+	}{
+		{0, "0.00000000"},
+		{1, "0.00000001"},
+		{-1, "-0.00000001"},
+		{-150000000, "-1.50000000"},
+		{btcutil.MaxSatoshi, "21000000.00000000"},
+	}
+
+	for _, test := range tests {
+		if got := FormatBTC(test.amount); got != test.want {
+			t.Errorf("%d: got %s, want %s", test.amount, got, test.want)
+		}
+
+		// The formatted value is parsed back to the same amount.
+		if got, err := ParseBTC(test.want); err != nil || got != test.amount {
+			t.Errorf("'%s': got %d, %v, want %d", test.want, got, err, test.amount)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of the context carrying the ID of the HTTP
+// request being served.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the ID of the HTTP request carried by the context, or an
+// empty string if there is none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Logger returns a log entry tagged with the ID of the HTTP request carried
+// by the context, if any, so that log entries triggered by a request can be
+// correlated.
+func Logger(ctx context.Context) *log.Entry {
+	if id := RequestID(ctx); id != "" {
+		return log.WithField("request_id", id)
+	}
+
+	return log.NewEntry(log.StandardLogger())
+}
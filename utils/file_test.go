@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+
+	if err := ioutil.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteFileAtomic(path, []byte("new"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err := ioutil.ReadFile(path); err != nil || string(data) != "new" {
+		t.Errorf("got %q, %v, want the file replaced", data, err)
+	}
+
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("got mode %v, %v, want %v", info.Mode().Perm(), err, os.FileMode(0600))
+	}
+
+	// The temporary file is renamed, and none is left behind.
+	if entries, err := ioutil.ReadDir(dir); err != nil || len(entries) != 1 {
+		t.Errorf("got %d files, %v, want the file only", len(entries), err)
+	}
+
+	if err := WriteFileAtomic(filepath.Join(dir, "missing", "state.json"), nil, 0600); err == nil {
+		t.Error("got no error writing to a missing directory")
+	}
+}
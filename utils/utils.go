@@ -8,7 +8,6 @@ import (
 	"github.com/btcsuite/btcd/btcjson"
 
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
-	"github.com/btcsuite/btcutil"
 )
 
 // ParseUnixTimestamp converts a UNIX timestamp in seconds, and returns a
@@ -31,18 +30,6 @@ func ParseRFC3339Timestamp(timestamp string) (*int64, error) {
 	return &tUnix, nil
 }
 
-// ParseSatoshi converts a float64 bitcoin value to satoshis.
-// Named after ParseInt function.
-func ParseSatoshi(value float64) btcutil.Amount {
-	// Convert BTC value to satoshi without losing precision.
-	amount, err := btcutil.NewAmount(value)
-	if err != nil {
-		// TODO: Log an error here
-		return -1
-	}
-	return amount
-}
-
 func ParseChainHash(hash string) (*chainhash.Hash, error) {
 	return chainhash.NewHashFromStr(strings.TrimLeft(hash, "0x"))
 }
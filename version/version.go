@@ -5,17 +5,62 @@ import (
 	"runtime"
 )
 
+// unknown is the value of the build-time variables that were not filled in
+// by the compiler, for ex with go build or go run.
+const unknown = "unknown"
+
 // GitCommit returns the git commit that was compiled. This will be filled in by the compiler.
-var GitCommit string
+var GitCommit = unknown
 
 // Version returns the main version number that is being run at the moment.
-const Version = "v0.12.0"
+// It may be overridden by the compiler, for ex with the git tag of a release.
+var Version = "v0.12.0"
 
 // Build indicates whether the build was a development or a production build.
-var Build string
+// This will be filled in by the compiler.
+var Build = "development"
+
+// BuildDate returns the date at which the binary was compiled, in RFC 3339
+// format. This will be filled in by the compiler.
+var BuildDate = unknown
 
 // GoVersion returns the version of the go runtime used to compile the binary
 var GoVersion = runtime.Version()
 
 // OsArch returns the os and arch used to build the binary
 var OsArch = fmt.Sprintf("%s %s", runtime.GOOS, runtime.GOARCH)
+
+// Full returns a description of the binary, including the build-time
+// variables, for ex "v0.12.0 (commit 1a2b3c4, built 2021-01-01T00:00:00Z,
+// release)".
+func Full() string {
+	return fmt.Sprintf("%s (commit %s, built %s, %s)",
+		orUnknown(Version), orUnknown(GitCommit), orUnknown(BuildDate), orUnknown(Build))
+}
+
+// UserAgent returns the User-Agent of the HTTP requests made by SatStack,
+// for ex "satstack/v0.12.0 (commit 1a2b3c4, built 2021-01-01T00:00:00Z,
+// release)", so that node operators can identify them.
+func UserAgent() string {
+	return "satstack/" + Full()
+}
+
+// orUnknown guards against build-time variables explicitly set to an empty
+// string, for ex if git is unavailable when compiling.
+func orUnknown(value string) string {
+	if value == "" {
+		return unknown
+	}
+
+	return value
+}
+
+// Commit returns GitCommit, or "unknown" if it was not filled in.
+func Commit() string {
+	return orUnknown(GitCommit)
+}
+
+// Date returns BuildDate, or "unknown" if it was not filled in.
+func Date() string {
+	return orUnknown(BuildDate)
+}
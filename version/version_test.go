@@ -0,0 +1,55 @@
+package version
+
+import (
+	"strings"
+	"testing"
+)
+
+// setBuild sets the build-time variables for the duration of the test.
+func setBuild(t *testing.T, commit string, date string, build string) {
+	gitCommit, buildDate, buildType := GitCommit, BuildDate, Build
+	t.Cleanup(func() {
+		GitCommit, BuildDate, Build = gitCommit, buildDate, buildType
+	})
+
+	GitCommit, BuildDate, Build = commit, date, build
+}
+
+func TestDefaults(t *testing.T) {
+	if Commit() != unknown || Date() != unknown {
+		t.Errorf("got commit %q and date %q, want %q", Commit(), Date(), unknown)
+	}
+
+	want := Version + " (commit unknown, built unknown, development)"
+	if got := Full(); got != want {
+		t.Errorf("Full() = %q, want %q", got, want)
+	}
+}
+
+func TestEmptyValues(t *testing.T) {
+	// For ex -ldflags "-X ...GitCommit=" when git is unavailable.
+	setBuild(t, "", "", "")
+
+	if Commit() != unknown || Date() != unknown {
+		t.Errorf("got commit %q and date %q, want %q", Commit(), Date(), unknown)
+	}
+
+	want := Version + " (commit unknown, built unknown, unknown)"
+	if got := Full(); got != want {
+		t.Errorf("Full() = %q, want %q", got, want)
+	}
+}
+
+func TestUserAgent(t *testing.T) {
+	setBuild(t, "1a2b3c4", "2021-01-01T00:00:00Z", "release")
+
+	want := "satstack/" + Version + " (commit 1a2b3c4, built 2021-01-01T00:00:00Z, release)"
+	if got := UserAgent(); got != want {
+		t.Errorf("UserAgent() = %q, want %q", got, want)
+	}
+
+	// The User-Agent is sent in an HTTP header.
+	if strings.ContainsAny(UserAgent(), "\r\n") {
+		t.Errorf("UserAgent() = %q contains a line break", UserAgent())
+	}
+}